@@ -910,6 +910,29 @@ func (lc *Client) QueryDNS(ctx context.Context, name string, queryType string) (
 	return res.Bytes, res.Resolvers, nil
 }
 
+// DebugDNSCache returns the upstream DNS responses cached by the internal DNS
+// resolver.
+func (lc *Client) DebugDNSCache(ctx context.Context) ([]apitype.DNSCacheEntry, error) {
+	if !buildfeatures.HasDNS {
+		return nil, feature.ErrUnavailable
+	}
+	body, err := lc.get200(ctx, "/localapi/v0/debug-dns-cache")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.DNSCacheEntry](body)
+}
+
+// FlushDNSCache flushes the internal DNS resolver's cache of upstream DNS
+// responses.
+func (lc *Client) FlushDNSCache(ctx context.Context) error {
+	if !buildfeatures.HasDNS {
+		return feature.ErrUnavailable
+	}
+	_, err := lc.send(ctx, "POST", "/localapi/v0/debug-dns-cache", http.StatusNoContent, nil)
+	return err
+}
+
 // StartLoginInteractive starts an interactive login.
 func (lc *Client) StartLoginInteractive(ctx context.Context) error {
 	_, err := lc.send(ctx, "POST", "/localapi/v0/login-interactive", http.StatusNoContent, nil)
@@ -1218,6 +1241,13 @@ func (lc *Client) DebugPeerRelaySessions(ctx context.Context) (*status.ServerSta
 	return decodeJSON[*status.ServerStatus](body)
 }
 
+// ClosePeerRelaySession tears down the peer relay session with the provided
+// VNI that is running through this node.
+func (lc *Client) ClosePeerRelaySession(ctx context.Context, vni uint32) error {
+	_, err := lc.send(ctx, "DELETE", fmt.Sprintf("/localapi/v0/peer-relay-sessions/%d", vni), http.StatusNoContent, nil)
+	return err
+}
+
 // StreamDebugCapture streams a pcap-formatted packet capture.
 //
 // The provided context does not determine the lifetime of the
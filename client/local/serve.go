@@ -11,6 +11,7 @@
 	"fmt"
 	"net/http"
 
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn"
 )
 
@@ -53,3 +54,13 @@ func (lc *Client) SetServeConfig(ctx context.Context, config *ipn.ServeConfig) e
 	}
 	return nil
 }
+
+// ServeUpstreams returns the status of the backends of the serve config's
+// Proxy handlers with upstreams.
+func (lc *Client) ServeUpstreams(ctx context.Context) ([]apitype.ServeUpstreamStatus, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/serve-upstreams")
+	if err != nil {
+		return nil, fmt.Errorf("getting serve upstreams: %w", err)
+	}
+	return decodeJSON[[]apitype.ServeUpstreamStatus](body)
+}
@@ -0,0 +1,47 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_ssh
+
+package local
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/sessionrecording"
+)
+
+// SSHRecordings returns the Tailscale SSH session recordings stored on the
+// local node's disk, oldest first.
+func (lc *Client) SSHRecordings(ctx context.Context) ([]sessionrecording.LocalRecording, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/ssh-recordings/")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]sessionrecording.LocalRecording](body)
+}
+
+// SSHRecording returns the named Tailscale SSH session recording stored on
+// the local node's disk, along with the result of checking it against its
+// hash.
+func (lc *Client) SSHRecording(ctx context.Context, name string) (io.ReadCloser, sessionrecording.Integrity, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/ssh-recordings/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, "", err
+	}
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, "", fmt.Errorf("HTTP %s: %s", res.Status, body)
+	}
+	return res.Body, sessionrecording.Integrity(res.Header.Get("Tailscale-Recording-Integrity")), nil
+}
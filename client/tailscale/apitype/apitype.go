@@ -5,6 +5,8 @@
 package apitype
 
 import (
+	"time"
+
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/util/ctxkey"
@@ -95,6 +97,47 @@ type DNSQueryResponse struct {
 	Resolvers []*dnstype.Resolver
 }
 
+// DNSCacheEntry is a response from an upstream DNS server cached by the
+// built-in DNS resolver, as returned by the debug-dns-cache LocalAPI endpoint.
+type DNSCacheEntry struct {
+	// Name is the queried name, in lowercase and with a trailing dot.
+	Name string
+	// Type is the query type, like "TypeA".
+	Type string
+	// Upstream is the DNS server the response came from, if it was sent
+	// to one in particular, or empty if it was resolved using the DNS
+	// configuration's routes.
+	Upstream string `json:",omitempty"`
+	// RCode is the response code, like "RCodeSuccess".
+	RCode string
+	// Negative is whether the response is a cached NXDOMAIN or NODATA
+	// response (RFC 2308).
+	Negative bool `json:",omitempty"`
+	// Records is the number of answer records in the response.
+	Records int
+	// Expires is when the entry expires from the cache.
+	Expires time.Time
+}
+
+// ServeUpstreamStatus is the status of a backend of a serve Proxy handler
+// with upstreams, as returned by the serve-upstreams LocalAPI endpoint.
+type ServeUpstreamStatus struct {
+	// HostPort and Mount identify the handler.
+	HostPort string
+	Mount    string
+	// Backend is the backend, as in the handler's Proxy or Upstreams.
+	Backend string
+	// Healthy is whether the backend is getting requests. Backends are
+	// healthy until they fail health checks.
+	Healthy bool
+	// ActiveRequests is the number of requests in flight to the backend.
+	ActiveRequests int64
+	// LastCheck is when the backend was last health checked, if ever.
+	LastCheck time.Time `json:",omitzero"`
+	// LastError is why the last health check failed, if it did.
+	LastError string `json:",omitempty"`
+}
+
 // OptionalFeatures describes which optional features are enabled in the build.
 type OptionalFeatures struct {
 	// Features is the map of optional feature names to whether they are
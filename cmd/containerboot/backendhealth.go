@@ -0,0 +1,225 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	healthz "tailscale.com/kube/health"
+	"tailscale.com/kube/kubetypes"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/httpm"
+)
+
+const (
+	defaultBackendHealthCheckInterval = 10 * time.Second
+	backendHealthCheckTimeout         = 5 * time.Second
+
+	// backendHealthCheckTailnetTarget is the TS_EXPERIMENTAL_BACKEND_HEALTH_CHECK
+	// value that checks whether the egress target set via TS_TAILNET_TARGET_IP or
+	// TS_TAILNET_TARGET_FQDN is reachable over the tailnet.
+	backendHealthCheckTailnetTarget = "tailnet-target"
+)
+
+// pinger pings tailnet IPs. It is implemented by *local.Client.
+type pinger interface {
+	Ping(context.Context, netip.Addr, tailcfg.PingType) (*ipnstate.PingResult, error)
+}
+
+// backendHealthCheck periodically checks the health of the backend that this
+// containerboot instance proxies traffic to. It is configured via
+// TS_EXPERIMENTAL_BACKEND_HEALTH_CHECK, whose value is one of:
+//   - tcp:<host>:<port>: the backend is healthy if a TCP connection to
+//     <host>:<port> can be established.
+//   - an http:// or https:// URL: the backend is healthy if a GET request to
+//     the URL returns a status code in the 200-399 range. As with Kubernetes
+//     HTTP probes, the backend's TLS certificate is not verified.
+//   - tailnet-target: the backend is healthy if the egress target set via
+//     TS_TAILNET_TARGET_IP or TS_TAILNET_TARGET_FQDN responds to a TSMP ping
+//     over the tailnet.
+type backendHealthCheck struct {
+	tcpAddr  string   // if non-empty, the address to connect to
+	httpURL  *url.URL // if non-nil, the URL to GET
+	tailnet  bool     // whether to ping the tailnet target
+	interval time.Duration
+
+	httpClient *http.Client
+
+	mu             sync.Mutex
+	tailnetTargets []netip.Addr // tailnet IPs of the egress target
+}
+
+// newBackendHealthCheck returns the backend health check configured in cfg, or
+// nil if none is configured.
+func newBackendHealthCheck(cfg *settings) (*backendHealthCheck, error) {
+	spec := cfg.BackendHealthCheck
+	if spec == "" {
+		return nil, nil
+	}
+	c := &backendHealthCheck{interval: defaultBackendHealthCheckInterval}
+	if cfg.BackendHealthCheckInterval != "" {
+		d, err := time.ParseDuration(cfg.BackendHealthCheckInterval)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing TS_EXPERIMENTAL_BACKEND_HEALTH_CHECK_INTERVAL value %q: %w", cfg.BackendHealthCheckInterval, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("TS_EXPERIMENTAL_BACKEND_HEALTH_CHECK_INTERVAL must be at least 1s, got %v", d)
+		}
+		c.interval = d
+	}
+	switch {
+	case strings.HasPrefix(spec, "tcp:"):
+		addr := strings.TrimPrefix(spec, "tcp:")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("error parsing TS_EXPERIMENTAL_BACKEND_HEALTH_CHECK address %q: %w", addr, err)
+		}
+		c.tcpAddr = addr
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		u, err := url.Parse(spec)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid TS_EXPERIMENTAL_BACKEND_HEALTH_CHECK URL %q", spec)
+		}
+		c.httpURL = u
+		c.httpClient = &http.Client{
+			Timeout: backendHealthCheckTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				DisableKeepAlives: true,
+			},
+		}
+	case spec == backendHealthCheckTailnetTarget:
+		if cfg.TailnetTargetIP == "" && cfg.TailnetTargetFQDN == "" {
+			return nil, errors.New("TS_EXPERIMENTAL_BACKEND_HEALTH_CHECK=tailnet-target requires TS_TAILNET_TARGET_IP or TS_TAILNET_TARGET_FQDN to be set")
+		}
+		c.tailnet = true
+		if cfg.TailnetTargetIP != "" {
+			ip, err := netip.ParseAddr(cfg.TailnetTargetIP)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing TS_TAILNET_TARGET_IP value %q: %w", cfg.TailnetTargetIP, err)
+			}
+			c.tailnetTargets = []netip.Addr{ip}
+		}
+	default:
+		return nil, fmt.Errorf("invalid TS_EXPERIMENTAL_BACKEND_HEALTH_CHECK value %q, must be tcp:<host>:<port>, an http(s) URL or %q", spec, backendHealthCheckTailnetTarget)
+	}
+	return c, nil
+}
+
+// setTailnetTargets sets the tailnet IPs of the egress target, as resolved
+// from TS_TAILNET_TARGET_FQDN.
+func (c *backendHealthCheck) setTailnetTargets(addrs []netip.Prefix) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tailnetTargets = c.tailnetTargets[:0]
+	for _, pfx := range addrs {
+		c.tailnetTargets = append(c.tailnetTargets, pfx.Addr())
+	}
+}
+
+// check runs the backend health check once and returns nil if the backend is
+// healthy, else an error describing why it is not.
+func (c *backendHealthCheck) check(ctx context.Context, lc pinger) error {
+	ctx, cancel := context.WithTimeout(ctx, backendHealthCheckTimeout)
+	defer cancel()
+
+	switch {
+	case c.tcpAddr != "":
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", c.tcpAddr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case c.httpURL != nil:
+		req, err := http.NewRequestWithContext(ctx, httpm.GET, c.httpURL.String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("GET %s returned status %d", c.httpURL.Redacted(), resp.StatusCode)
+		}
+		return nil
+	case c.tailnet:
+		c.mu.Lock()
+		targets := append([]netip.Addr(nil), c.tailnetTargets...)
+		c.mu.Unlock()
+		if len(targets) == 0 {
+			return errors.New("tailnet target not yet resolved")
+		}
+		// The egress target is reachable if any of its tailnet IPs
+		// responds, as traffic is forwarded to whichever of them
+		// matches the IP family of the cluster traffic.
+		var errs []error
+		for _, ip := range targets {
+			res, err := lc.Ping(ctx, ip, tailcfg.PingTSMP)
+			if err == nil && res.Err != "" {
+				err = errors.New(res.Err)
+			}
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, fmt.Errorf("pinging %v: %w", ip, err))
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// run runs the backend health check every interval until ctx is done. It
+// reports the results to hz, if non-nil, and writes changes in the backend's
+// health to the state Secret via kc, if non-nil.
+func (c *backendHealthCheck) run(ctx context.Context, lc pinger, hz *healthz.Healthz, kc *kubeClient) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	var lastStatus string
+	for {
+		err := c.check(ctx, lc)
+		if ctx.Err() != nil {
+			return
+		}
+		if hz != nil {
+			hz.UpdateBackend(err)
+		}
+		status := kubetypes.ValueBackendHealthy
+		if err != nil {
+			status = err.Error()
+		}
+		if status != lastStatus {
+			log.Printf("Backend health check: %s", status)
+			lastStatus = status
+			if kc != nil {
+				if err := kc.storeBackendHealth(ctx, status); err != nil {
+					log.Printf("Error storing backend health in Kubernetes Secret: %v", err)
+					lastStatus = "" // retry on the next check
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
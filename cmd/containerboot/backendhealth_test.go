@@ -0,0 +1,153 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+func TestNewBackendHealthCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     settings
+		wantErr bool
+	}{
+		{name: "unset"},
+		{name: "tcp", cfg: settings{BackendHealthCheck: "tcp:10.0.0.1:5432"}},
+		{name: "tcp_no_port", cfg: settings{BackendHealthCheck: "tcp:10.0.0.1"}, wantErr: true},
+		{name: "http", cfg: settings{BackendHealthCheck: "http://backend.default.svc:8080/healthz"}},
+		{name: "https_no_host", cfg: settings{BackendHealthCheck: "https:///healthz"}, wantErr: true},
+		{name: "tailnet_target_ip", cfg: settings{BackendHealthCheck: "tailnet-target", TailnetTargetIP: "100.64.0.1"}},
+		{name: "tailnet_target_fqdn", cfg: settings{BackendHealthCheck: "tailnet-target", TailnetTargetFQDN: "db.tailnet.ts.net."}},
+		{name: "tailnet_target_unset", cfg: settings{BackendHealthCheck: "tailnet-target"}, wantErr: true},
+		{name: "interval", cfg: settings{BackendHealthCheck: "tcp:10.0.0.1:80", BackendHealthCheckInterval: "30s"}},
+		{name: "interval_too_short", cfg: settings{BackendHealthCheck: "tcp:10.0.0.1:80", BackendHealthCheckInterval: "10ms"}, wantErr: true},
+		{name: "unknown", cfg: settings{BackendHealthCheck: "udp:10.0.0.1:53"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newBackendHealthCheck(&tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (c == nil) != (tt.cfg.BackendHealthCheck == "") {
+				t.Errorf("got check %v for value %q", c, tt.cfg.BackendHealthCheck)
+			}
+		})
+	}
+}
+
+type fakePinger map[netip.Addr]string // IP => ping error, if any
+
+func (f fakePinger) Ping(_ context.Context, ip netip.Addr, _ tailcfg.PingType) (*ipnstate.PingResult, error) {
+	pingErr, ok := f[ip]
+	if !ok {
+		return nil, errors.New("unknown peer")
+	}
+	return &ipnstate.PingResult{IP: ip.String(), Err: pingErr}, nil
+}
+
+func TestBackendHealthCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	closedLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closedLn.Addr().String()
+	closedLn.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.Error(w, "unhealthy", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	pinger := fakePinger{
+		netip.MustParseAddr("100.64.0.1"):         "",
+		netip.MustParseAddr("fd7a:115c:a1e0::1"):  "",
+		netip.MustParseAddr("100.64.0.2"):         "timed out",
+		netip.MustParseAddr("fd7a:115c:a1e0::99"): "timed out",
+	}
+
+	tests := []struct {
+		name           string
+		cfg            settings
+		tailnetTargets []netip.Prefix
+		wantHealthy    bool
+	}{
+		{
+			name:        "tcp_listening",
+			cfg:         settings{BackendHealthCheck: "tcp:" + ln.Addr().String()},
+			wantHealthy: true,
+		},
+		{
+			name: "tcp_closed",
+			cfg:  settings{BackendHealthCheck: "tcp:" + closedAddr},
+		},
+		{
+			name:        "http_ok",
+			cfg:         settings{BackendHealthCheck: srv.URL + "/healthz"},
+			wantHealthy: true,
+		},
+		{
+			name: "http_unavailable",
+			cfg:  settings{BackendHealthCheck: srv.URL + "/other"},
+		},
+		{
+			name:        "tailnet_target_ip",
+			cfg:         settings{BackendHealthCheck: "tailnet-target", TailnetTargetIP: "100.64.0.1"},
+			wantHealthy: true,
+		},
+		{
+			name: "tailnet_target_ip_unreachable",
+			cfg:  settings{BackendHealthCheck: "tailnet-target", TailnetTargetIP: "100.64.0.2"},
+		},
+		{
+			name: "tailnet_target_fqdn_unresolved",
+			cfg:  settings{BackendHealthCheck: "tailnet-target", TailnetTargetFQDN: "db.tailnet.ts.net."},
+		},
+		{
+			name:           "tailnet_target_fqdn_one_reachable",
+			cfg:            settings{BackendHealthCheck: "tailnet-target", TailnetTargetFQDN: "db.tailnet.ts.net."},
+			tailnetTargets: []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32"), netip.MustParsePrefix("fd7a:115c:a1e0::1/128")},
+			wantHealthy:    true,
+		},
+		{
+			name:           "tailnet_target_fqdn_none_reachable",
+			cfg:            settings{BackendHealthCheck: "tailnet-target", TailnetTargetFQDN: "db.tailnet.ts.net."},
+			tailnetTargets: []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32"), netip.MustParsePrefix("fd7a:115c:a1e0::99/128")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newBackendHealthCheck(&tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tailnetTargets != nil {
+				c.setTailnetTargets(tt.tailnetTargets)
+			}
+			err = c.check(context.Background(), pinger)
+			if (err == nil) != tt.wantHealthy {
+				t.Errorf("check() = %v, want healthy %v", err, tt.wantHealthy)
+			}
+		})
+	}
+}
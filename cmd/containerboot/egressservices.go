@@ -262,10 +262,26 @@ func (ep *egressProxy) syncEgressConfigs(cfgs *egressservices.Configs, status *e
 	if err := ensureRulesDeleted(rulesPerSvcToDelete, ep.nfr); err != nil {
 		return nil, fmt.Errorf("error deleting rules: %w", err)
 	}
+	if len(rulesPerSvcToDelete) != 0 {
+		ep.flushUDPFlows()
+	}
 
 	return newStatus, nil
 }
 
+// flushUDPFlows deletes the conntrack entries of UDP flows to the proxy Pod,
+// after rules forwarding them to tailnet targets have been deleted. Flows of
+// services whose rules haven't changed get new entries for the same rules on
+// their next packet.
+func (ep *egressProxy) flushUDPFlows() {
+	ip, err := netip.ParseAddr(ep.podIPv4)
+	if err != nil {
+		log.Printf("error parsing Pod IP %q: %v", ep.podIPv4, err)
+		return
+	}
+	flushUDPFlows(ip)
+}
+
 // updatesForCfg calculates any rules that need to be added or deleted for an individucal egress service config.
 func updatesForCfg(svcName string, cfg egressservices.Config, status *egressservices.Status, tailnetTargetIPs []netip.Addr) ([]rule, []rule, error) {
 	rulesToAdd := make([]rule, 0)
@@ -363,18 +379,24 @@ func (ep *egressProxy) deleteUnnecessaryServices(cfgs *egressservices.Configs, s
 				return fmt.Errorf("error deleting service %s: %w", svcName, err)
 			}
 		}
+		ep.flushUDPFlows()
 		return nil
 	}
 
+	var deleted bool
 	for svcName, svc := range status.Services {
 		if _, ok := (*cfgs)[svcName]; !ok {
 			log.Printf("service %s is no longer required, deleting", svcName)
 			if err := ensureServiceDeleted(svcName, svc, ep.nfr); err != nil {
 				return fmt.Errorf("error deleting service %s: %w", svcName, err)
 			}
+			deleted = true
 			// TODO (irbekrm): also delete the SNAT rule here
 		}
 	}
+	if deleted {
+		ep.flushUDPFlows()
+	}
 	return nil
 }
 
@@ -16,8 +16,13 @@
 	"sync"
 	"testing"
 
+	"golang.org/x/sys/unix"
+	"tailscale.com/ipn"
 	"tailscale.com/kube/egressservices"
 	"tailscale.com/kube/kubetypes"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/linuxfw"
 )
 
 func Test_updatesForSvc(t *testing.T) {
@@ -322,3 +327,55 @@ func (m *mockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	}
 	return resp, nil
 }
+
+func TestSyncEgressConfigsFlushesUDPFlows(t *testing.T) {
+	var flushed []netip.Addr
+	old := deleteConntrackEntries
+	deleteConntrackEntries = func(dst netip.Addr, proto uint8) (int, error) {
+		if proto != unix.IPPROTO_UDP {
+			t.Errorf("got protocol %d, want UDP", proto)
+		}
+		flushed = append(flushed, dst)
+		return 1, nil
+	}
+	t.Cleanup(func() { deleteConntrackEntries = old })
+
+	ep := &egressProxy{
+		nfr:     linuxfw.NewFakeNetfilterRunner(),
+		podIPv4: "10.0.0.2",
+	}
+	n := ipn.Notify{NetMap: &netmap.NetworkMap{SelfNode: (&tailcfg.Node{
+		Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.10/32")},
+	}).View()}}
+	svc := func(ip, proto string) egressservices.Config {
+		return egressservices.Config{
+			TailnetTarget: egressservices.TailnetTarget{IP: ip},
+			Ports:         egressservices.PortMaps{{Protocol: proto, MatchPort: 4003, TargetPort: 53}: {}},
+		}
+	}
+	status, err := ep.syncEgressConfigs(&egressservices.Configs{
+		"foo": svc("100.64.0.1", "udp"),
+		"bar": svc("100.64.0.2", "tcp"),
+	}, nil, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flushed) != 0 {
+		t.Fatalf("flushed UDP flows to %v when adding rules, want none", flushed)
+	}
+
+	// The tailnet target of foo has changed, and bar has been removed.
+	if _, err := ep.syncEgressConfigs(&egressservices.Configs{
+		"foo": svc("100.64.0.3", "udp"),
+	}, status, n); err != nil {
+		t.Fatal(err)
+	}
+	if len(flushed) == 0 {
+		t.Fatal("no UDP flows flushed after deleting rules")
+	}
+	for _, dst := range flushed {
+		if dst != netip.MustParseAddr("10.0.0.2") {
+			t.Errorf("flushed UDP flows to %v, want the Pod IP", dst)
+		}
+	}
+}
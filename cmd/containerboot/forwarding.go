@@ -15,6 +15,7 @@
 	"path/filepath"
 	"strings"
 
+	"golang.org/x/sys/unix"
 	"tailscale.com/util/linuxfw"
 )
 
@@ -95,6 +96,25 @@ func enableIPForwarding(v4Forwarding, v6Forwarding bool, root string) error {
 	return nil
 }
 
+// deleteConntrackEntries can be replaced in tests.
+var deleteConntrackEntries = linuxfw.DeleteConntrackEntries
+
+// flushUDPFlows deletes the conntrack entries of UDP flows to dst, so that
+// they are forwarded according to the current DNAT rules. A TCP connection
+// whose backend has gone away gets re-established, and with it its DNAT, but
+// a UDP flow that keeps sending would otherwise be forwarded to the old
+// backend indefinitely.
+func flushUDPFlows(dst netip.Addr) {
+	n, err := deleteConntrackEntries(dst, unix.IPPROTO_UDP)
+	if err != nil {
+		log.Printf("error deleting conntrack entries of UDP flows to %v: %v", dst, err)
+		return
+	}
+	if n > 0 {
+		log.Printf("deleted conntrack entries of %d UDP flows to %v", n, dst)
+	}
+}
+
 func installEgressForwardingRule(_ context.Context, dstStr string, tsIPs []netip.Prefix, nfr linuxfw.NetfilterRunner) error {
 	dst, err := netip.ParseAddr(dstStr)
 	if err != nil {
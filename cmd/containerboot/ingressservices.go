@@ -287,7 +287,13 @@ func ensureIngressRulesDeleted(cfgs map[string]ingressservices.Config, nfr linux
 
 func deleteDNATRuleForSvc(nfr linuxfw.NetfilterRunner, serviceName string, tsIP, clusterIP netip.Addr) error {
 	log.Printf("deleting DNAT rule for Tailscale Service %s with IP %s to Kubernetes Service IP %s", serviceName, tsIP, clusterIP)
-	return nfr.DeleteDNATRuleForSvc(serviceName, tsIP, clusterIP)
+	if err := nfr.DeleteDNATRuleForSvc(serviceName, tsIP, clusterIP); err != nil {
+		return err
+	}
+	// UDP flows to the Tailscale Service would otherwise keep being
+	// forwarded to the old Kubernetes Service IP.
+	flushUDPFlows(tsIP)
+	return nil
 }
 
 // isCurrentStatus returns true if the status of an ingress proxy as read from
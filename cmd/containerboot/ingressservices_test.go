@@ -7,8 +7,10 @@
 
 import (
 	"net/netip"
+	"slices"
 	"testing"
 
+	"golang.org/x/sys/unix"
 	"tailscale.com/kube/ingressservices"
 	"tailscale.com/util/linuxfw"
 )
@@ -221,3 +223,48 @@ func makeWantService(tsIP, clusterIP string) struct {
 		ClusterIP:          netip.MustParseAddr(clusterIP),
 	}
 }
+
+func TestSyncIngressConfigsFlushesUDPFlows(t *testing.T) {
+	var flushed []netip.Addr
+	old := deleteConntrackEntries
+	deleteConntrackEntries = func(dst netip.Addr, proto uint8) (int, error) {
+		if proto != unix.IPPROTO_UDP {
+			t.Errorf("got protocol %d, want UDP", proto)
+		}
+		flushed = append(flushed, dst)
+		return 1, nil
+	}
+	t.Cleanup(func() { deleteConntrackEntries = old })
+
+	ep := &ingressProxy{
+		nfr:     linuxfw.NewFakeNetfilterRunner(),
+		podIPv4: "10.0.0.2",
+	}
+	status := &ingressservices.Status{
+		Configs: ingressservices.Configs{
+			"svc:foo": makeServiceConfig("100.64.0.1", "10.0.0.1", "", ""),
+			"svc:bar": makeServiceConfig("100.64.0.2", "10.0.0.3", "", ""),
+		},
+		PodIPv4: "10.0.0.2",
+	}
+	if err := ep.syncIngressConfigs(&status.Configs, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(flushed) != 0 {
+		t.Fatalf("flushed UDP flows to %v when adding rules, want none", flushed)
+	}
+
+	// The Kubernetes Service for svc:foo has been recreated with a new
+	// ClusterIP, and svc:bar has been removed.
+	cfgs := &ingressservices.Configs{
+		"svc:foo": makeServiceConfig("100.64.0.1", "10.0.0.4", "", ""),
+	}
+	if err := ep.syncIngressConfigs(cfgs, status); err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(flushed, netip.Addr.Compare)
+	want := []netip.Addr{netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("100.64.0.2")}
+	if !slices.Equal(flushed, want) {
+		t.Errorf("flushed UDP flows to %v, want %v", flushed, want)
+	}
+}
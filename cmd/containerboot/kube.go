@@ -99,6 +99,17 @@ func (kc *kubeClient) storeHTTPSEndpoint(ctx context.Context, ep string) error {
 	return kc.StrategicMergePatchSecret(ctx, kc.stateSecret, s, "tailscale-container")
 }
 
+// storeBackendHealth writes the result of the backend health check to the
+// 'backend_health' field of the client's state Secret.
+func (kc *kubeClient) storeBackendHealth(ctx context.Context, status string) error {
+	s := &kubeapi.Secret{
+		Data: map[string][]byte{
+			kubetypes.KeyBackendHealth: []byte(status),
+		},
+	}
+	return kc.StrategicMergePatchSecret(ctx, kc.stateSecret, s, "tailscale-container")
+}
+
 // deleteAuthKey deletes the 'authkey' field of the given kube
 // secret. No-op if there is no authkey in the secret.
 func (kc *kubeClient) deleteAuthKey(ctx context.Context) error {
@@ -145,6 +156,7 @@ func (kc *kubeClient) resetContainerbootState(ctx context.Context, podUID string
 		kubetypes.KeyDeviceFQDN,
 		kubetypes.KeyDeviceIPs,
 		kubetypes.KeyHTTPSEndpoint,
+		kubetypes.KeyBackendHealth,
 		egressservices.KeyEgressServices,
 		ingressservices.IngressConfigKey,
 	})
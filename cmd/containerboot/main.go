@@ -78,6 +78,17 @@
 //     the address specified by TS_LOCAL_ADDR_PORT. The health endpoint will return 200
 //     OK if this node has at least one tailnet IP address, otherwise returns 503.
 //     NB: the health criteria might change in the future.
+//   - TS_EXPERIMENTAL_BACKEND_HEALTH_CHECK: if specified, a health check of the
+//     backend that this node proxies to. It can be 'tcp:<host>:<port>' to check
+//     that a TCP connection can be established, an http:// or https:// URL to
+//     check that a GET request returns a 2xx or 3xx status, or 'tailnet-target'
+//     to check that the TS_TAILNET_TARGET_IP or TS_TAILNET_TARGET_FQDN target
+//     responds to pings over the tailnet. While the check fails, the health
+//     endpoint returns 503. The result is also written to the 'backend_health'
+//     field of the state Secret when running on Kubernetes.
+//     NB: This env var is currently experimental and the logic will likely change!
+//   - TS_EXPERIMENTAL_BACKEND_HEALTH_CHECK_INTERVAL: how often to run the
+//     TS_EXPERIMENTAL_BACKEND_HEALTH_CHECK. Defaults to 10s.
 //   - TS_EXPERIMENTAL_VERSIONED_CONFIG_DIR: if specified, a path to a
 //     directory that containers tailscaled config in file. The config file needs to be
 //     named cap-<current-tailscaled-cap>.hujson. If this is set, TS_HOSTNAME,
@@ -293,6 +304,14 @@ func run() error {
 		defer close()
 	}
 
+	backendCheck, err := newBackendHealthCheck(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if backendCheck != nil && healthCheck != nil {
+		healthCheck.EnableBackendCheck()
+	}
+
 	if cfg.EnableForwardingOptimizations {
 		if err := client.SetUDPGROForwarding(bootCtx); err != nil {
 			log.Printf("[unexpected] error enabling UDP GRO forwarding: %v", err)
@@ -557,6 +576,10 @@ func run() error {
 						break
 					}
 
+					if backendCheck != nil {
+						backendCheck.setTailnetTargets(egressAddrs)
+					}
+
 					newCurentEgressIPs := deephash.Hash(&egressAddrs)
 					egressIPsHaveChanged := newCurentEgressIPs != currentEgressIPs
 					// The firewall rules get (re-)installed:
@@ -602,6 +625,13 @@ func run() error {
 						if err := installIngressForwardingRuleForDNSTarget(ctx, newBackendAddrs, addrs, nfr); err != nil {
 							return fmt.Errorf("error installing ingress proxy rules: %w", err)
 						}
+						if len(backendAddrs) != 0 {
+							for _, pfx := range addrs {
+								if pfx.IsSingleIP() {
+									flushUDPFlows(pfx.Addr())
+								}
+							}
+						}
 					}
 					resetTimer(false)
 					backendAddrs = newBackendAddrs
@@ -696,6 +726,14 @@ func run() error {
 					log.Println("Startup complete, waiting for shutdown signal")
 					startupTasksDone = true
 
+					if backendCheck != nil {
+						var backendCheckKC *kubeClient
+						if hasKubeStateStore(cfg) {
+							backendCheckKC = kc
+						}
+						go backendCheck.run(ctx, client, healthCheck, backendCheckKC)
+					}
+
 					// Configure egress proxy. Egress proxy will set up firewall rules to proxy
 					// traffic to tailnet targets configured in the provided configuration file. It
 					// will then continuously monitor the config file and netmap updates and
@@ -85,6 +85,10 @@ type settings struct {
 	// certs) and 'rw' for Pods that should manage the TLS certs shared
 	// amongst the replicas.
 	CertShareMode string
+	// BackendHealthCheck, if set, configures a periodic health check of
+	// the backend that this instance proxies to, see backendHealthCheck.
+	BackendHealthCheck         string
+	BackendHealthCheckInterval string
 }
 
 func configFromEnv() (*settings, error) {
@@ -130,6 +134,8 @@ func configFromEnv() (*settings, error) {
 		EgressProxiesCfgPath:                  defaultEnv("TS_EGRESS_PROXIES_CONFIG_PATH", ""),
 		IngressProxiesCfgPath:                 defaultEnv("TS_INGRESS_PROXIES_CONFIG_PATH", ""),
 		PodUID:                                defaultEnv("POD_UID", ""),
+		BackendHealthCheck:                    defaultEnv("TS_EXPERIMENTAL_BACKEND_HEALTH_CHECK", ""),
+		BackendHealthCheckInterval:            defaultEnv("TS_EXPERIMENTAL_BACKEND_HEALTH_CHECK_INTERVAL", ""),
 	}
 
 	podIPs, ok := os.LookupEnv("POD_IPS")
@@ -344,6 +350,9 @@ func (s *settings) validate() error {
 	if s.IngressProxiesCfgPath != "" && !(s.InKubernetes && s.KubeSecret != "") {
 		return errors.New("TS_INGRESS_PROXIES_CONFIG_PATH is only supported for Tailscale running on Kubernetes")
 	}
+	if _, err := newBackendHealthCheck(s); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -52,24 +52,42 @@ func init() {
 	}))
 }
 
+// bootstrapDNSChanged is sent to when a config reload changes the lists of
+// bootstrap DNS names, to refresh them right away.
+var bootstrapDNSChanged = make(chan struct{}, 1)
+
+// bootstrapDNSNames returns the current values of the --bootstrap-dns-names
+// and --unpublished-bootstrap-dns-names flags.
+func bootstrapDNSNames() (published, unpublished string) {
+	flagsMu.Lock()
+	defer flagsMu.Unlock()
+	return *bootstrapDNS, *unpublishedDNS
+}
+
 func refreshBootstrapDNSLoop() {
-	if *bootstrapDNS == "" && *unpublishedDNS == "" {
-		return
-	}
+	t := time.NewTimer(0)
 	for {
+		select {
+		case <-t.C:
+		case <-bootstrapDNSChanged:
+			t.Stop()
+		}
 		refreshBootstrapDNS()
 		refreshUnpublishedDNS()
-		time.Sleep(10 * time.Minute)
+		t.Reset(10 * time.Minute)
 	}
 }
 
 func refreshBootstrapDNS() {
-	if *bootstrapDNS == "" {
+	names, _ := bootstrapDNSNames()
+	if names == "" {
+		dnsCache.Store(nil)
+		dnsCacheBytes.Store(nil)
 		return
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
 	defer cancel()
-	dnsEntries := resolveList(ctx, *bootstrapDNS)
+	dnsEntries := resolveList(ctx, names)
 	// Randomize the order of the IPs for each name to avoid the client biasing
 	// to IPv6
 	for _, vv := range dnsEntries.IPs {
@@ -86,12 +104,14 @@ func refreshBootstrapDNS() {
 }
 
 func refreshUnpublishedDNS() {
-	if *unpublishedDNS == "" {
+	_, names := bootstrapDNSNames()
+	if names == "" {
+		unpublishedDNSCache.Store(nil)
 		return
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
 	defer cancel()
-	dnsEntries := resolveList(ctx, *unpublishedDNS)
+	dnsEntries := resolveList(ctx, names)
 	unpublishedDNSCache.Store(dnsEntries)
 }
 
@@ -0,0 +1,324 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/tailscale/hujson"
+	"sigs.k8s.io/yaml"
+	"tailscale.com/derp/derpserver"
+	"tailscale.com/tstime"
+	"tailscale.com/types/key"
+	"tailscale.com/util/set"
+)
+
+// config is the derper config file, named by the -c flag.
+//
+// It's HuJSON, or YAML if its name ends in ".yaml" or ".yml". derper writes
+// a new one with just a PrivateKey if it doesn't exist.
+//
+// All other fields are optional. Each one that's set overrides the default of
+// the flag named in its comment; flags given on the command line still take
+// precedence. On SIGHUP, derper reloads the file and applies the changes it
+// can without dropping client connections; see reloadableFlags.
+type config struct {
+	PrivateKey key.NodePrivate
+
+	Addr     string `json:",omitempty"` // -a
+	HTTPPort *int   `json:",omitempty"` // --http-port
+	STUNPort *int   `json:",omitempty"` // --stun-port
+	STUN     *bool  `json:",omitempty"` // --stun
+	DERP     *bool  `json:",omitempty"` // --derp
+	Home     string `json:",omitempty"` // --home
+
+	CertMode   string `json:",omitempty"` // --certmode
+	CertDir    string `json:",omitempty"` // --certdir
+	Hostname   string `json:",omitempty"` // --hostname
+	ACMEEABKid string `json:",omitempty"` // --acme-eab-kid
+	ACMEEABKey string `json:",omitempty"` // --acme-eab-key
+	ACMEEmail  string `json:",omitempty"` // --acme-email
+
+	MeshPSKFile string   `json:",omitempty"` // --mesh-psk-file
+	MeshWith    []string `json:",omitempty"` // --mesh-with
+
+	MeshDiscovery         string             `json:",omitempty"` // --mesh-discovery
+	MeshDiscoveryInterval *tstime.GoDuration `json:",omitempty"` // --mesh-discovery-interval
+
+	BootstrapDNSNames            []string `json:",omitempty"` // --bootstrap-dns-names
+	UnpublishedBootstrapDNSNames []string `json:",omitempty"` // --unpublished-bootstrap-dns-names
+
+	VerifyClients           *bool  `json:",omitempty"` // --verify-clients
+	VerifyClientURL         string `json:",omitempty"` // --verify-client-url
+	VerifyClientURLFailOpen *bool  `json:",omitempty"` // --verify-client-url-fail-open
+	Socket                  string `json:",omitempty"` // --socket
+
+	AcceptConnectionLimit *float64           `json:",omitempty"` // --accept-connection-limit
+	AcceptConnectionBurst *int               `json:",omitempty"` // --accept-connection-burst
+	TCPKeepAlive          *tstime.GoDuration `json:",omitempty"` // --tcp-keepalive-time
+	TCPUserTimeout        *tstime.GoDuration `json:",omitempty"` // --tcp-user-timeout
+	TCPWriteTimeout       *tstime.GoDuration `json:",omitempty"` // --tcp-write-timeout
+
+	// RateLimits sets all of the --client-*, --node-* and
+	// --rate-limit-disconnect flags; zero fields mean no limit.
+	RateLimits *derpserver.RateLimits `json:",omitempty"`
+}
+
+// flagValues returns the values of the flags that c sets, by flag name.
+func (c *config) flagValues() map[string]string {
+	m := map[string]string{}
+	setString := func(name, v string) {
+		if v != "" {
+			m[name] = v
+		}
+	}
+	setList := func(name string, v []string) {
+		if v != nil {
+			m[name] = strings.Join(v, ",")
+		}
+	}
+	setAny := func(name string, v any) {
+		m[name] = fmt.Sprint(v)
+	}
+	setString("a", c.Addr)
+	if c.HTTPPort != nil {
+		setAny("http-port", *c.HTTPPort)
+	}
+	if c.STUNPort != nil {
+		setAny("stun-port", *c.STUNPort)
+	}
+	if c.STUN != nil {
+		setAny("stun", *c.STUN)
+	}
+	if c.DERP != nil {
+		setAny("derp", *c.DERP)
+	}
+	setString("home", c.Home)
+	setString("certmode", c.CertMode)
+	setString("certdir", c.CertDir)
+	setString("hostname", c.Hostname)
+	setString("acme-eab-kid", c.ACMEEABKid)
+	setString("acme-eab-key", c.ACMEEABKey)
+	setString("acme-email", c.ACMEEmail)
+	setString("mesh-psk-file", c.MeshPSKFile)
+	setList("mesh-with", c.MeshWith)
+	setString("mesh-discovery", c.MeshDiscovery)
+	if c.MeshDiscoveryInterval != nil {
+		setAny("mesh-discovery-interval", c.MeshDiscoveryInterval.Duration)
+	}
+	setList("bootstrap-dns-names", c.BootstrapDNSNames)
+	setList("unpublished-bootstrap-dns-names", c.UnpublishedBootstrapDNSNames)
+	if c.VerifyClients != nil {
+		setAny("verify-clients", *c.VerifyClients)
+	}
+	setString("verify-client-url", c.VerifyClientURL)
+	if c.VerifyClientURLFailOpen != nil {
+		setAny("verify-client-url-fail-open", *c.VerifyClientURLFailOpen)
+	}
+	setString("socket", c.Socket)
+	if c.AcceptConnectionLimit != nil {
+		setAny("accept-connection-limit", *c.AcceptConnectionLimit)
+	}
+	if c.AcceptConnectionBurst != nil {
+		setAny("accept-connection-burst", *c.AcceptConnectionBurst)
+	}
+	if c.TCPKeepAlive != nil {
+		setAny("tcp-keepalive-time", c.TCPKeepAlive.Duration)
+	}
+	if c.TCPUserTimeout != nil {
+		setAny("tcp-user-timeout", c.TCPUserTimeout.Duration)
+	}
+	if c.TCPWriteTimeout != nil {
+		setAny("tcp-write-timeout", c.TCPWriteTimeout.Duration)
+	}
+	if l := c.RateLimits; l != nil {
+		setAny("client-bytes-per-sec", l.ClientBytesPerSecond)
+		setAny("client-bytes-burst", l.ClientBytesBurst)
+		setAny("client-packets-per-sec", l.ClientPacketsPerSecond)
+		setAny("client-packets-burst", l.ClientPacketsBurst)
+		setAny("node-bytes-per-sec", l.NodeBytesPerSecond)
+		setAny("node-bytes-burst", l.NodeBytesBurst)
+		setAny("node-packets-per-sec", l.NodePacketsPerSecond)
+		setAny("node-packets-burst", l.NodePacketsBurst)
+		setAny("node-daily-quota-bytes", l.NodeDailyQuotaBytes)
+		setAny("rate-limit-disconnect", l.Disconnect)
+	}
+	return m
+}
+
+// reloadableFlags are the flags whose changes in the config file are applied
+// on SIGHUP. Changes to other flags need a restart.
+var reloadableFlags = set.Of(
+	"mesh-with",
+	"mesh-discovery",
+	"mesh-discovery-interval",
+	"bootstrap-dns-names",
+	"unpublished-bootstrap-dns-names",
+	"verify-clients",
+	"verify-client-url",
+	"verify-client-url-fail-open",
+	"tcp-write-timeout",
+	"client-bytes-per-sec",
+	"client-bytes-burst",
+	"client-packets-per-sec",
+	"client-packets-burst",
+	"node-bytes-per-sec",
+	"node-bytes-burst",
+	"node-packets-per-sec",
+	"node-packets-burst",
+	"node-daily-quota-bytes",
+	"rate-limit-disconnect",
+)
+
+// flagsMu guards the reloadable flags once derper is serving, as
+// configReloader may change them.
+var flagsMu sync.Mutex
+
+// parseConfig parses the config file contents b, read from path.
+func parseConfig(path string, b []byte) (config, error) {
+	var cfg config
+	var err error
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		b, err = yaml.YAMLToJSON(b)
+	default:
+		b, err = hujson.Standardize(b)
+	}
+	if err != nil {
+		return cfg, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// readConfig reads and parses the config file at path.
+func readConfig(path string) (config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config{}, err
+	}
+	cfg, err := parseConfig(path, b)
+	if err != nil {
+		return config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// commandLineFlags returns the names of the flags given on the command line.
+func commandLineFlags() set.Set[string] {
+	s := set.Set[string]{}
+	flag.Visit(func(f *flag.Flag) { s.Add(f.Name) })
+	return s
+}
+
+// applyConfigFlags sets the flags that cfg sets, except those in cmdLine.
+// It's called at startup, before the flags are used.
+func applyConfigFlags(cfg *config, cmdLine set.Set[string]) error {
+	for name, v := range cfg.flagValues() {
+		if cmdLine.Contains(name) {
+			continue
+		}
+		if err := flag.Set(name, v); err != nil {
+			return fmt.Errorf("config: %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// configReloader reloads the config file on SIGHUP.
+type configReloader struct {
+	path    string
+	cmdLine set.Set[string] // flags given on the command line
+	apply   func() error    // applies the reloadable flags
+
+	mu        sync.Mutex
+	privKey   key.NodePrivate
+	fileFlags map[string]string // flag values from the config file last loaded
+}
+
+// reload reloads the config file and applies the changes to reloadable flags
+// that aren't overridden on the command line. It logs changes that need a
+// restart.
+func (r *configReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := readConfig(r.path)
+	if err != nil {
+		return err
+	}
+	if !cfg.PrivateKey.Equal(r.privKey) {
+		log.Printf("config: PrivateKey changed; restart derper to apply")
+	}
+	newFlags := cfg.flagValues()
+
+	// Flags that are no longer in the file revert to their defaults.
+	names := set.Set[string]{}
+	for name := range r.fileFlags {
+		names.Add(name)
+	}
+	for name := range newFlags {
+		names.Add(name)
+	}
+
+	flagsMu.Lock()
+	var changed []string
+	old := map[string]string{}
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		if r.cmdLine.Contains(name) {
+			continue
+		}
+		f := flag.Lookup(name)
+		v, ok := newFlags[name]
+		if !ok {
+			v = f.DefValue
+		}
+		if v == f.Value.String() {
+			continue
+		}
+		if !reloadableFlags.Contains(name) {
+			log.Printf("config: %s changed; restart derper to apply", name)
+			continue
+		}
+		old[name] = f.Value.String()
+		if err := flag.Set(name, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		changed = append(changed, name)
+	}
+	if len(errs) > 0 {
+		// Don't apply a partial change.
+		for name, v := range old {
+			flag.Set(name, v)
+		}
+		flagsMu.Unlock()
+		return fmt.Errorf("config: %w", errors.Join(errs...))
+	}
+	flagsMu.Unlock()
+
+	r.fileFlags = newFlags
+	if len(changed) == 0 {
+		log.Printf("config: reloaded; no changes to apply")
+		return nil
+	}
+	log.Printf("config: reloaded; applying changes to %s", strings.Join(changed, ", "))
+	return r.apply()
+}
@@ -0,0 +1,204 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"flag"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tailscale.com/types/key"
+	"tailscale.com/util/set"
+)
+
+func TestParseConfig(t *testing.T) {
+	k := key.NewNode()
+	kText, err := k.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"a":                      ":8443",
+		"certmode":               "manual",
+		"mesh-with":              "derp1.example.com,derp2.example.com/10.0.0.2",
+		"bootstrap-dns-names":    "controlplane.tailscale.com",
+		"verify-clients":         "true",
+		"verify-client-url":      "https://admit.example.com/",
+		"tcp-write-timeout":      "5s",
+		"client-bytes-per-sec":   "1000",
+		"client-bytes-burst":     "0",
+		"client-packets-per-sec": "0",
+		"client-packets-burst":   "0",
+		"node-bytes-per-sec":     "0",
+		"node-bytes-burst":       "0",
+		"node-packets-per-sec":   "0",
+		"node-packets-burst":     "0",
+		"node-daily-quota-bytes": "1000000",
+		"rate-limit-disconnect":  "false",
+	}
+
+	tests := []struct {
+		name string
+		file string
+		in   string
+	}{
+		{
+			name: "hujson",
+			file: "derper.json",
+			in: `{
+				"PrivateKey": "` + string(kText) + `",
+				// Listen on a non-default port.
+				"Addr": ":8443",
+				"CertMode": "manual",
+				"MeshWith": ["derp1.example.com", "derp2.example.com/10.0.0.2"],
+				"BootstrapDNSNames": ["controlplane.tailscale.com"],
+				"VerifyClients": true,
+				"VerifyClientURL": "https://admit.example.com/",
+				"TCPWriteTimeout": "5s",
+				"RateLimits": {
+					"ClientBytesPerSecond": 1000,
+					"NodeDailyQuotaBytes": 1000000,
+				},
+			}`,
+		},
+		{
+			name: "yaml",
+			file: "derper.yaml",
+			in: `
+PrivateKey: ` + string(kText) + `
+# Listen on a non-default port.
+Addr: ":8443"
+CertMode: manual
+MeshWith:
+  - derp1.example.com
+  - derp2.example.com/10.0.0.2
+BootstrapDNSNames: [controlplane.tailscale.com]
+VerifyClients: true
+VerifyClientURL: https://admit.example.com/
+TCPWriteTimeout: 5s
+RateLimits:
+  ClientBytesPerSecond: 1000
+  NodeDailyQuotaBytes: 1000000
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseConfig(tt.file, []byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cfg.PrivateKey.Equal(k) {
+				t.Errorf("PrivateKey = %v; want %v", cfg.PrivateKey.Public(), k.Public())
+			}
+			if got := cfg.flagValues(); !maps.Equal(got, want) {
+				t.Errorf("flagValues = %v; want %v", got, want)
+			}
+			for name := range want {
+				if flag.Lookup(name) == nil {
+					t.Errorf("no flag %q", name)
+				}
+			}
+		})
+	}
+}
+
+func TestParseConfigUnknownField(t *testing.T) {
+	if _, err := parseConfig("derper.json", []byte(`{"MeshWit": ["derp1.example.com"]}`)); err == nil {
+		t.Error("unexpected success parsing config with unknown field")
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	// Restore the flags that the test changes.
+	for _, name := range []string{"mesh-with", "verify-client-url", "certmode", "client-bytes-per-sec"} {
+		old := flag.Lookup(name).Value.String()
+		t.Cleanup(func() { flag.Set(name, old) })
+	}
+
+	k := key.NewNode()
+	path := filepath.Join(t.TempDir(), "derper.yaml")
+	write := func(s string) {
+		t.Helper()
+		kText, err := k.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("PrivateKey: "+string(kText)+"\n"+s), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("MeshWith: [derp1.example.com]\nCertMode: letsencrypt\n")
+	cfg, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmdLine := set.Of("client-bytes-per-sec")
+	if err := applyConfigFlags(&cfg, cmdLine); err != nil {
+		t.Fatal(err)
+	}
+
+	applied := 0
+	r := &configReloader{
+		path:      path,
+		cmdLine:   cmdLine,
+		privKey:   k,
+		fileFlags: cfg.flagValues(),
+		apply: func() error {
+			applied++
+			return nil
+		},
+	}
+
+	// Reloadable changes are applied, others need a restart, and flags from
+	// the command line win.
+	write(`
+MeshWith: [derp1.example.com, derp2.example.com]
+VerifyClientURL: https://admit.example.com/
+CertMode: manual
+RateLimits:
+  ClientBytesPerSecond: 1000
+`)
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if applied != 1 {
+		t.Errorf("applied %d times; want 1", applied)
+	}
+	if *meshWith != "derp1.example.com,derp2.example.com" {
+		t.Errorf("mesh-with = %q", *meshWith)
+	}
+	if *verifyClientURL != "https://admit.example.com/" {
+		t.Errorf("verify-client-url = %q", *verifyClientURL)
+	}
+	if *certMode != "letsencrypt" {
+		t.Errorf("certmode = %q; want unchanged", *certMode)
+	}
+	if *clientBytesPerSec != 0 {
+		t.Errorf("client-bytes-per-sec = %d; want command line value", *clientBytesPerSec)
+	}
+
+	// Removed flags revert to their defaults.
+	write("CertMode: manual\n")
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if applied != 2 {
+		t.Errorf("applied %d times; want 2", applied)
+	}
+	if *meshWith != "" || *verifyClientURL != "" {
+		t.Errorf("mesh-with = %q, verify-client-url = %q; want defaults", *meshWith, *verifyClientURL)
+	}
+
+	// Bad configs change nothing.
+	write("MeshWith: [derp3.example.com]\nNodeDailyQuotaBytes: 1\n")
+	if err := r.reload(); err == nil {
+		t.Error("unexpected success reloading bad config")
+	}
+	if applied != 2 || *meshWith != "" {
+		t.Errorf("bad config applied")
+	}
+}
@@ -59,7 +59,7 @@
 	addr        = flag.String("a", ":443", "server HTTP/HTTPS listen address, in form \":port\", \"ip:port\", or for IPv6 \"[ip]:port\". If the IP is omitted, it defaults to all interfaces. Serves HTTPS if the port is 443 and/or -certmode is manual, otherwise HTTP.")
 	httpPort    = flag.Int("http-port", 80, "The port on which to serve HTTP. Set to -1 to disable. The listener is bound to the same IP (if any) as specified in the -a flag.")
 	stunPort    = flag.Int("stun-port", 3478, "The UDP port on which to serve STUN. The listener is bound to the same IP (if any) as specified in the -a flag.")
-	configPath  = flag.String("c", "", "config file path")
+	configPath  = flag.String("c", "", "config file path. The file holds the server's private key, and can set the defaults of most other flags; see the config type. It's HuJSON, or YAML if its name ends in .yaml or .yml. derper reloads it on SIGHUP.")
 	certMode    = flag.String("certmode", "letsencrypt", "mode for getting a cert. possible options: manual, letsencrypt, gcp")
 	certDir     = flag.String("certdir", tsweb.DefaultCertDir("derper-certs"), "directory to store ACME (e.g. LetsEncrypt) certs, if addr's port is :443")
 	hostname    = flag.String("hostname", "derp.tailscale.com", "TLS host name for certs, if addr's port is :443. When --certmode=manual, this can be an IP address to avoid SNI checks")
@@ -70,13 +70,15 @@
 	runDERP     = flag.Bool("derp", true, "whether to run a DERP server. The only reason to set this false is if you're decommissioning a server but want to keep its bootstrap DNS functionality still running.")
 	flagHome    = flag.String("home", "", "what to serve at the root path. It may be left empty (the default, for a default homepage), \"blank\" for a blank page, or a URL to redirect to")
 
-	meshPSKFile     = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It must be 64 lowercase hexadecimal characters; whitespace is trimmed.")
-	meshWith        = flag.String("mesh-with", "", "optional comma-separated list of hostnames to mesh with; the server's own hostname can be in the list. If an entry contains a slash, the second part names a hostname to be used when dialing the target.")
-	secretsURL      = flag.String("secrets-url", "", "SETEC server URL for secrets retrieval of mesh key")
-	secretPrefix    = flag.String("secrets-path-prefix", "prod/derp", "setec path prefix for \""+setecMeshKeyName+"\" secret for DERP mesh key")
-	secretsCacheDir = flag.String("secrets-cache-dir", defaultSetecCacheDir(), "directory to cache setec secrets in (required if --secrets-url is set)")
-	bootstrapDNS    = flag.String("bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns")
-	unpublishedDNS  = flag.String("unpublished-bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns and not publish in the list. If an entry contains a slash, the second part names a DNS record to poll for its TXT record with a `0` to `100` value for rollout percentage.")
+	meshPSKFile           = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It must be 64 lowercase hexadecimal characters; whitespace is trimmed.")
+	meshWith              = flag.String("mesh-with", "", "optional comma-separated list of hostnames to mesh with; the server's own hostname can be in the list. If an entry contains a slash, the second part names a hostname to be used when dialing the target.")
+	meshDiscovery         = flag.String("mesh-discovery", "", "optional source of mesh peers in addition to --mesh-with, polled every --mesh-discovery-interval: \"srv:<name>\" for the targets of a DNS SRV record, \"file:<path>\" for a file of --mesh-with entries separated by commas or whitespace, or \"derpmap:<url>#<region ID>\" for the nodes of a region of the DERP map at url")
+	meshDiscoveryInterval = flag.Duration("mesh-discovery-interval", 30*time.Second, "how often to poll --mesh-discovery for mesh peers")
+	secretsURL            = flag.String("secrets-url", "", "SETEC server URL for secrets retrieval of mesh key")
+	secretPrefix          = flag.String("secrets-path-prefix", "prod/derp", "setec path prefix for \""+setecMeshKeyName+"\" secret for DERP mesh key")
+	secretsCacheDir       = flag.String("secrets-cache-dir", defaultSetecCacheDir(), "directory to cache setec secrets in (required if --secrets-url is set)")
+	bootstrapDNS          = flag.String("bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns")
+	unpublishedDNS        = flag.String("unpublished-bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns and not publish in the list. If an entry contains a slash, the second part names a DNS record to poll for its TXT record with a `0` to `100` value for rollout percentage.")
 
 	verifyClients   = flag.Bool("verify-clients", false, "verify clients to this DERP server through a local tailscaled instance.")
 	verifyClientURL = flag.String("verify-client-url", "", "if non-empty, an admission controller URL for permitting client connections; see tailcfg.DERPAdmitClientRequest")
@@ -94,6 +96,18 @@
 	// tcpWriteTimeout is the timeout for writing to client TCP connections. It does not apply to mesh connections.
 	tcpWriteTimeout = flag.Duration("tcp-write-timeout", derpserver.DefaultTCPWiteTimeout, "TCP write timeout; 0 results in no timeout being set on writes")
 
+	// Rate limits and quotas; see derpserver.RateLimits.
+	clientBytesPerSec   = flag.Int("client-bytes-per-sec", 0, "if non-zero, the bytes per second each client connection may send")
+	clientBytesBurst    = flag.Int("client-bytes-burst", 0, "burst size for --client-bytes-per-sec, in bytes; 0 means one second's worth")
+	clientPacketsPerSec = flag.Int("client-packets-per-sec", 0, "if non-zero, the packets per second each client connection may send")
+	clientPacketsBurst  = flag.Int("client-packets-burst", 0, "burst size for --client-packets-per-sec, in packets; 0 means one second's worth")
+	nodeBytesPerSec     = flag.Int("node-bytes-per-sec", 0, "if non-zero, the bytes per second all connections of a node key together may send")
+	nodeBytesBurst      = flag.Int("node-bytes-burst", 0, "burst size for --node-bytes-per-sec, in bytes; 0 means one second's worth")
+	nodePacketsPerSec   = flag.Int("node-packets-per-sec", 0, "if non-zero, the packets per second all connections of a node key together may send")
+	nodePacketsBurst    = flag.Int("node-packets-burst", 0, "burst size for --node-packets-per-sec, in packets; 0 means one second's worth")
+	nodeDailyQuota      = flag.Int64("node-daily-quota-bytes", 0, "if non-zero, the bytes relayed from and to each node key per UTC day, after which its packets are dropped")
+	rateLimitDisconnect = flag.Bool("rate-limit-disconnect", false, "disconnect clients that exceed their rate limits or quota, rather than throttling them or dropping their packets")
+
 	// ACE
 	flagACEEnabled = flag.Bool("ace", false, "whether to enable embedded ACE server [experimental + in-development as of 2025-09-12; not yet documented]")
 )
@@ -106,10 +120,6 @@
 const setecMeshKeyName = "meshkey"
 const meshKeyEnvVar = "TAILSCALE_DERPER_MESH_KEY"
 
-type config struct {
-	PrivateKey key.NodePrivate
-}
-
 func loadConfig() config {
 	if *dev {
 		return config{PrivateKey: key.NewNode()}
@@ -130,14 +140,68 @@ func loadConfig() config {
 		log.Fatal(err)
 		panic("unreachable")
 	default:
-		var cfg config
-		if err := json.Unmarshal(b, &cfg); err != nil {
+		cfg, err := parseConfig(*configPath, b)
+		if err != nil {
 			log.Fatalf("derper: config: %v", err)
 		}
 		return cfg
 	}
 }
 
+// applyServerFlags applies the flags that configure s and may be changed by
+// a config reload.
+func applyServerFlags(s *derpserver.Server) {
+	flagsMu.Lock()
+	defer flagsMu.Unlock()
+	s.SetVerifyClient(*verifyClients)
+	s.SetVerifyClientURL(*verifyClientURL)
+	s.SetVerifyClientURLFailOpen(*verifyFailOpen)
+	s.SetTCPWriteTimeout(*tcpWriteTimeout)
+	s.SetRateLimits(&derpserver.RateLimits{
+		ClientBytesPerSecond:   *clientBytesPerSec,
+		ClientBytesBurst:       *clientBytesBurst,
+		ClientPacketsPerSecond: *clientPacketsPerSec,
+		ClientPacketsBurst:     *clientPacketsBurst,
+		NodeBytesPerSecond:     *nodeBytesPerSec,
+		NodeBytesBurst:         *nodeBytesBurst,
+		NodePacketsPerSecond:   *nodePacketsPerSec,
+		NodePacketsBurst:       *nodePacketsBurst,
+		NodeDailyQuotaBytes:    *nodeDailyQuota,
+		Disconnect:             *rateLimitDisconnect,
+	})
+}
+
+// applyMeshFlags applies the --mesh-with and --mesh-discovery flags to mesh.
+func applyMeshFlags(mesh *meshPeers) error {
+	flagsMu.Lock()
+	meshWith, discovery, interval := *meshWith, *meshDiscovery, *meshDiscoveryInterval
+	flagsMu.Unlock()
+	d, err := parseMeshDiscovery(discovery)
+	if err != nil {
+		return err
+	}
+	return errors.Join(mesh.set(meshWith), mesh.setDiscovery(d, interval))
+}
+
+// reloadConfigOnSIGHUP reloads the config file with r each time derper gets
+// a SIGHUP, until ctx is done.
+func reloadConfigOnSIGHUP(ctx context.Context, r *configReloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("config: got SIGHUP; reloading %s", r.path)
+			if err := r.reload(); err != nil {
+				log.Printf("config: reload failed: %v", err)
+			}
+		}
+	}
+}
+
 func writeNewConfig() config {
 	k := key.NewNode()
 	if err := os.MkdirAll(filepath.Dir(*configPath), 0777); err != nil {
@@ -166,6 +230,12 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	cfg := loadConfig()
+	cmdLine := commandLineFlags()
+	if err := applyConfigFlags(&cfg, cmdLine); err != nil {
+		log.Fatalf("derper: %v", err)
+	}
+
 	if *dev {
 		*addr = ":3340" // above the keys DERP
 		log.Printf("Running in dev mode.")
@@ -182,16 +252,11 @@ func main() {
 		go ss.ListenAndServe(net.JoinHostPort(listenHost, fmt.Sprint(*stunPort)))
 	}
 
-	cfg := loadConfig()
-
 	serveTLS := tsweb.IsProd443(*addr) || *certMode == "manual"
 
 	s := derpserver.New(cfg.PrivateKey, log.Printf)
-	s.SetVerifyClient(*verifyClients)
 	s.SetTailscaledSocketPath(*socket)
-	s.SetVerifyClientURL(*verifyClientURL)
-	s.SetVerifyClientURLFailOpen(*verifyFailOpen)
-	s.SetTCPWriteTimeout(*tcpWriteTimeout)
+	applyServerFlags(s)
 
 	var meshKey string
 	if *dev {
@@ -241,10 +306,12 @@ func main() {
 		log.Println("DERP mesh key configured")
 	}
 
-	if err := startMesh(s); err != nil {
-		log.Fatalf("startMesh: %v", err)
+	mesh := newMeshPeers(s)
+	if err := applyMeshFlags(mesh); err != nil {
+		log.Fatalf("mesh: %v", err)
 	}
 	expvar.Publish("derp", s.ExpVar())
+	expvar.Publish("derper_mesh", mesh.expVar())
 
 	handleHome, ok := getHomeHandler(*flagHome)
 	if !ok {
@@ -268,6 +335,23 @@ func main() {
 	mux.HandleFunc("/derp/latency-check", derpserver.ProbeHandler)
 
 	go refreshBootstrapDNSLoop()
+	if !*dev {
+		r := &configReloader{
+			path:      *configPath,
+			cmdLine:   cmdLine,
+			privKey:   cfg.PrivateKey,
+			fileFlags: cfg.flagValues(),
+			apply: func() error {
+				applyServerFlags(s)
+				select {
+				case bootstrapDNSChanged <- struct{}{}:
+				default:
+				}
+				return applyMeshFlags(mesh)
+			},
+		}
+		go reloadConfigOnSIGHUP(ctx, r)
+	}
 	mux.HandleFunc("/bootstrap-dns", tsweb.BrowserHeaderHandlerFunc(handleBootstrapDNS))
 	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tsweb.AddBrowserHeaders(w)
@@ -290,6 +374,7 @@ func main() {
 		}
 	}))
 	debug.Handle("traffic", "Traffic check", http.HandlerFunc(s.ServeDebugTraffic))
+	debug.Handle("mesh", "Mesh peers", http.HandlerFunc(mesh.serveDebug))
 	debug.Handle("set-mutex-profile-fraction", "SetMutexProfileFraction", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		s := r.FormValue("rate")
 		if s == "" || r.Header.Get("Sec-Debug") != "derp" {
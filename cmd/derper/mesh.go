@@ -5,40 +5,290 @@
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
 	"log"
+	"maps"
 	"net"
+	"net/http"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"tailscale.com/derp"
 	"tailscale.com/derp/derphttp"
 	"tailscale.com/derp/derpserver"
+	"tailscale.com/metrics"
 	"tailscale.com/net/netmon"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/set"
 )
 
-func startMesh(s *derpserver.Server) error {
-	if *meshWith == "" {
-		return nil
-	}
-	if !s.HasMeshKey() {
-		return errors.New("--mesh-with requires --mesh-psk-file")
+// meshPingInterval is how often mesh peers are pinged to check their health.
+const meshPingInterval = 15 * time.Second
+
+// meshPeers is the set of DERP servers that a server meshes with, which can
+// change at runtime. Peers come from the --mesh-with list and from an optional
+// meshDiscoverer.
+type meshPeers struct {
+	s *derpserver.Server
+
+	peersAdded      expvar.Int
+	peersRemoved    expvar.Int
+	discoveryErrors expvar.Int
+	peerHealthy     *metrics.LabelMap // by host tuple; 1 or 0
+
+	mu              sync.Mutex
+	static          set.Set[string]      // from --mesh-with
+	discovered      set.Set[string]      // from discovery
+	discovery       meshDiscoverer       // or nil
+	stopDiscovery   context.CancelFunc   // or nil
+	lastDiscovery   time.Time            // of last successful discovery
+	lastDiscoverErr error                // from last discovery, if it failed
+	peers           map[string]*meshPeer // by host tuple
+}
+
+func newMeshPeers(s *derpserver.Server) *meshPeers {
+	return &meshPeers{
+		s:           s,
+		peerHealthy: &metrics.LabelMap{Label: "peer"},
+		peers:       map[string]*meshPeer{},
 	}
-	for hostTuple := range strings.SplitSeq(*meshWith, ",") {
-		if err := startMeshWithHost(s, hostTuple); err != nil {
+}
+
+// set sets the peers to mesh with from the --mesh-with flag to meshWith, a
+// comma-separated list of host tuples. It stops meshing with peers that are
+// no longer wanted and starts meshing with new ones, leaving the connections
+// to the others alone.
+func (m *meshPeers) set(meshWith string) error {
+	want := set.Set[string]{}
+	for hostTuple := range strings.SplitSeq(meshWith, ",") {
+		if hostTuple == "" {
+			continue
+		}
+		if _, _, err := parseMeshHostTuple(hostTuple); err != nil {
 			return err
 		}
+		want.Add(hostTuple)
+	}
+	if len(want) > 0 && !m.s.HasMeshKey() {
+		return errors.New("--mesh-with requires --mesh-psk-file")
 	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.static = want
+	return m.updateLocked()
+}
+
+// setDiscovery sets the source of discovered mesh peers to d, which is
+// polled every interval. A nil d stops discovery and forgets the peers it
+// found.
+//
+// Peers from a previous source are kept until d first returns its peers.
+func (m *meshPeers) setDiscovery(d meshDiscoverer, interval time.Duration) error {
+	if d != nil && !m.s.HasMeshKey() {
+		return errors.New("--mesh-discovery requires --mesh-psk-file")
+	}
+	if interval <= 0 {
+		return fmt.Errorf("invalid mesh discovery interval %v", interval)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopDiscovery != nil {
+		m.stopDiscovery()
+		m.stopDiscovery = nil
+	}
+	m.discovery = d
+	m.lastDiscoverErr = nil
+	if d == nil {
+		m.discovered = nil
+		return m.updateLocked()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.stopDiscovery = cancel
+	go m.discoveryLoop(ctx, d, interval)
 	return nil
 }
 
-func startMeshWithHost(s *derpserver.Server, hostTuple string) error {
-	var host string
-	var dialHost string
+func (m *meshPeers) discoveryLoop(ctx context.Context, d meshDiscoverer, interval time.Duration) {
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		hostTuples, err := d.discover(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		m.setDiscovered(ctx, d, hostTuples, err)
+		t.Reset(interval)
+	}
+}
+
+// setDiscovered records the result of discovering mesh peers from d, as
+// started with ctx. On error, it keeps the previously discovered peers.
+func (m *meshPeers) setDiscovered(ctx context.Context, d meshDiscoverer, hostTuples []string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ctx.Err() != nil {
+		// Discovery was stopped or replaced while d was running.
+		return
+	}
+	if err != nil {
+		m.discoveryErrors.Add(1)
+		m.lastDiscoverErr = err
+		log.Printf("mesh: discovery from %v failed, keeping %d peers: %v", d, len(m.discovered), err)
+		return
+	}
+	m.lastDiscoverErr = nil
+	m.lastDiscovery = time.Now()
+	want := set.Set[string]{}
+	for _, hostTuple := range hostTuples {
+		if _, _, err := parseMeshHostTuple(hostTuple); err != nil {
+			log.Printf("mesh: discovery from %v: ignoring %v", d, err)
+			continue
+		}
+		want.Add(hostTuple)
+	}
+	if !want.Equal(m.discovered) {
+		log.Printf("mesh: discovered %d peers from %v", len(want), d)
+	}
+	m.discovered = want
+	if err := m.updateLocked(); err != nil {
+		log.Printf("mesh: %v", err)
+	}
+}
+
+// updateLocked starts and stops meshing with peers so that they match the
+// union of the static and discovered peers.
+func (m *meshPeers) updateLocked() error {
+	want := set.Set[string]{}
+	want.AddSet(m.static)
+	want.AddSet(m.discovered)
+
+	for hostTuple, p := range m.peers {
+		if !want.Contains(hostTuple) {
+			log.Printf("mesh: removing peer %q", hostTuple)
+			p.stop()
+			delete(m.peers, hostTuple)
+			m.peerHealthy.Delete(hostTuple)
+			m.peersRemoved.Add(1)
+		}
+	}
+	var errs []error
+	for _, hostTuple := range slices.Sorted(maps.Keys(want)) {
+		if _, ok := m.peers[hostTuple]; ok {
+			continue
+		}
+		p, err := startMeshWithHost(m.s, hostTuple, m.peerHealthy)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		m.peers[hostTuple] = p
+		m.peersAdded.Add(1)
+	}
+	return errors.Join(errs...)
+}
+
+// expVar returns the mesh metrics.
+func (m *meshPeers) expVar() expvar.Var {
+	ms := new(metrics.Set)
+	ms.Set("gauge_peers", expvar.Func(func() any {
+		n, _ := m.countPeers()
+		return n
+	}))
+	ms.Set("gauge_peers_healthy", expvar.Func(func() any {
+		_, healthy := m.countPeers()
+		return healthy
+	}))
+	ms.Set("counter_peers_added", &m.peersAdded)
+	ms.Set("counter_peers_removed", &m.peersRemoved)
+	ms.Set("counter_discovery_errors", &m.discoveryErrors)
+	ms.Set("gauge_peer_healthy", m.peerHealthy)
+	return ms
+}
+
+// countPeers returns the number of mesh peers, not counting this server
+// itself, and how many of them are healthy.
+func (m *meshPeers) countPeers() (peers, healthy int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.peers {
+		st := p.status()
+		if st.Self {
+			continue
+		}
+		peers++
+		if st.Healthy {
+			healthy++
+		}
+	}
+	return peers, healthy
+}
+
+// meshStatus is the status of the mesh, served at /debug/mesh.
+type meshStatus struct {
+	Discovery          string    `json:",omitempty"`
+	LastDiscovery      time.Time `json:",omitzero"`
+	LastDiscoveryError string    `json:",omitempty"`
+	Peers              []meshPeerStatus
+}
+
+// meshPeerStatus is the status of a mesh peer.
+type meshPeerStatus struct {
+	Peer       string // host tuple
+	Static     bool   `json:",omitempty"` // from --mesh-with
+	Discovered bool   `json:",omitempty"`
+
+	Self        bool      `json:",omitempty"` // the peer is this server
+	Healthy     bool      // the last ping succeeded
+	RTT         string    `json:",omitempty"` // of the last successful ping
+	LastHealthy time.Time `json:",omitzero"`
+	LastError   string    `json:",omitempty"`
+}
+
+func (m *meshPeers) status() meshStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var st meshStatus
+	if m.discovery != nil {
+		st.Discovery = m.discovery.String()
+		st.LastDiscovery = m.lastDiscovery
+		if m.lastDiscoverErr != nil {
+			st.LastDiscoveryError = m.lastDiscoverErr.Error()
+		}
+	}
+	for _, hostTuple := range slices.Sorted(maps.Keys(m.peers)) {
+		ps := m.peers[hostTuple].status()
+		ps.Static = m.static.Contains(hostTuple)
+		ps.Discovered = m.discovered.Contains(hostTuple)
+		st.Peers = append(st.Peers, ps)
+	}
+	return st
+}
+
+// serveDebug serves the mesh status as JSON.
+func (m *meshPeers) serveDebug(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(m.status())
+}
+
+// parseMeshHostTuple parses a --mesh-with entry, "host" or "host/dialHost".
+func parseMeshHostTuple(hostTuple string) (host, dialHost string, err error) {
 	hostParts := strings.Split(hostTuple, "/")
 	if len(hostParts) > 2 {
-		return fmt.Errorf("too many components in host tuple %q", hostTuple)
+		return "", "", fmt.Errorf("too many components in host tuple %q", hostTuple)
 	}
 	host = hostParts[0]
 	if len(hostParts) == 2 {
@@ -46,12 +296,36 @@ func startMeshWithHost(s *derpserver.Server, hostTuple string) error {
 	} else {
 		dialHost = hostParts[0]
 	}
+	return host, dialHost, nil
+}
+
+// meshPeer is a DERP server that this server meshes with.
+type meshPeer struct {
+	hostTuple string
+	c         *derphttp.Client
+	cancel    context.CancelFunc
+	healthy   *expvar.Int // 1 if healthy, else 0; in meshPeers.peerHealthy
+
+	mu          sync.Mutex
+	self        bool // the peer is this server, so it's not used
+	lastHealthy time.Time
+	rtt         time.Duration // of the last successful ping
+	lastErr     error         // of the last failed connection or ping, if unhealthy
+}
+
+// startMeshWithHost starts meshing with the DERP server named by hostTuple,
+// recording its health in healthy.
+func startMeshWithHost(s *derpserver.Server, hostTuple string, healthy *metrics.LabelMap) (*meshPeer, error) {
+	host, dialHost, err := parseMeshHostTuple(hostTuple)
+	if err != nil {
+		return nil, err
+	}
 
 	logf := logger.WithPrefix(log.Printf, fmt.Sprintf("mesh(%q): ", host))
 	netMon := netmon.NewStatic() // good enough for cmd/derper; no need for netns fanciness
 	c, err := derphttp.NewClient(s.PrivateKey(), "https://"+host+"/derp", logf, netMon)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	c.MeshKey = s.MeshKey()
 	c.WatchConnectionChanges = true
@@ -71,9 +345,96 @@ func startMeshWithHost(s *derpserver.Server, hostTuple string) error {
 		})
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &meshPeer{
+		hostTuple: hostTuple,
+		c:         c,
+		cancel:    cancel,
+		healthy:   healthy.Get(hostTuple),
+	}
+
 	add := func(m derp.PeerPresentMessage) { s.AddPacketForwarder(m.Key, c) }
 	remove := func(m derp.PeerGoneMessage) { s.RemovePacketForwarder(m.Peer, c) }
-	notifyError := func(err error) {}
-	go c.RunWatchConnectionLoop(context.Background(), s.PublicKey(), logf, add, remove, notifyError)
-	return nil
+	notifyError := func(err error) { p.setHealth(0, err) }
+	go func() {
+		c.RunWatchConnectionLoop(ctx, s.PublicKey(), logf, add, remove, notifyError)
+		if ctx.Err() == nil {
+			// RunWatchConnectionLoop only returns early when the
+			// peer is this server itself.
+			p.mu.Lock()
+			p.self = true
+			p.mu.Unlock()
+			healthy.Delete(hostTuple)
+			c.Close()
+		}
+	}()
+	go p.pingLoop(ctx)
+	return p, nil
+}
+
+// stop stops meshing with p, removing the packet forwarders it added.
+func (p *meshPeer) stop() {
+	p.cancel()
+	p.c.Close()
+}
+
+// pingLoop pings p every meshPingInterval to check its health, until ctx is
+// done.
+func (p *meshPeer) pingLoop(ctx context.Context) {
+	t := time.NewTicker(meshPingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if p.status().Self {
+			return
+		}
+		start := time.Now()
+		err := p.c.Ping(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		p.setHealth(time.Since(start), err)
+	}
+}
+
+// setHealth records the result of a ping, or a connection error with a zero
+// rtt.
+func (p *meshPeer) setHealth(rtt time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.self {
+		return
+	}
+	p.lastErr = err
+	if err == nil {
+		p.lastHealthy = time.Now()
+		p.rtt = rtt
+	}
+	if err == nil {
+		p.healthy.Set(1)
+	} else {
+		p.healthy.Set(0)
+	}
+}
+
+func (p *meshPeer) status() meshPeerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := meshPeerStatus{
+		Peer:        p.hostTuple,
+		Self:        p.self,
+		LastHealthy: p.lastHealthy,
+		Healthy:     !p.self && !p.lastHealthy.IsZero() && p.lastErr == nil,
+	}
+	if st.Healthy {
+		st.RTT = p.rtt.String()
+	}
+	if p.lastErr != nil {
+		st.LastError = p.lastErr.Error()
+	}
+	return st
 }
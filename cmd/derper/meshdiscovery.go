@@ -0,0 +1,197 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+// meshDiscoverer finds the DERP servers to mesh with, for the
+// --mesh-discovery flag.
+type meshDiscoverer interface {
+	// discover returns the current mesh peers, as host tuples in the form
+	// of --mesh-with entries. They may include this server itself.
+	discover(context.Context) ([]string, error)
+
+	// String returns the --mesh-discovery value of the discoverer.
+	String() string
+}
+
+// parseMeshDiscovery parses a --mesh-discovery flag value. It returns a nil
+// meshDiscoverer if v is empty.
+func parseMeshDiscovery(v string) (meshDiscoverer, error) {
+	if v == "" {
+		return nil, nil
+	}
+	kind, arg, _ := strings.Cut(v, ":")
+	switch kind {
+	case "srv":
+		if arg == "" {
+			return nil, fmt.Errorf("mesh discovery %q: missing SRV record name", v)
+		}
+		return &srvMeshDiscoverer{name: arg}, nil
+	case "file":
+		if arg == "" {
+			return nil, fmt.Errorf("mesh discovery %q: missing file name", v)
+		}
+		return &fileMeshDiscoverer{path: arg}, nil
+	case "derpmap":
+		u, err := url.Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("mesh discovery %q: %w", v, err)
+		}
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return nil, fmt.Errorf("mesh discovery %q: DERP map URL must be http or https", v)
+		}
+		regionID, err := strconv.Atoi(u.Fragment)
+		if err != nil {
+			return nil, fmt.Errorf("mesh discovery %q: DERP map URL must end in #<region ID>", v)
+		}
+		u.Fragment = ""
+		return &derpMapMeshDiscoverer{url: u.String(), regionID: regionID}, nil
+	}
+	return nil, fmt.Errorf("unknown mesh discovery %q; want srv:<name>, file:<path> or derpmap:<url>#<region ID>", v)
+}
+
+// srvMeshDiscoverer finds mesh peers from the targets of a DNS SRV record.
+// Targets with a port other than 443 are meshed with on that port.
+type srvMeshDiscoverer struct {
+	name string // full SRV record name, like "_derp-mesh._tcp.example.com"
+
+	// lookupSRV, if non-nil, replaces net.DefaultResolver.LookupSRV in
+	// tests.
+	lookupSRV func(ctx context.Context, name string) ([]*net.SRV, error)
+}
+
+func (d *srvMeshDiscoverer) String() string { return "srv:" + d.name }
+
+func (d *srvMeshDiscoverer) discover(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+	lookup := d.lookupSRV
+	if lookup == nil {
+		lookup = func(ctx context.Context, name string) ([]*net.SRV, error) {
+			_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+			return srvs, err
+		}
+	}
+	srvs, err := lookup(ctx, d.name)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, srv := range srvs {
+		host := strings.TrimSuffix(srv.Target, ".")
+		if host == "" {
+			continue // "." means no service
+		}
+		if srv.Port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(int(srv.Port)))
+		}
+		ret = append(ret, host)
+	}
+	return ret, nil
+}
+
+// fileMeshDiscoverer finds mesh peers in a file that's re-read every
+// discovery interval. The file has host tuples separated by commas or
+// whitespace; "#" starts a comment.
+type fileMeshDiscoverer struct {
+	path string
+}
+
+func (d *fileMeshDiscoverer) String() string { return "file:" + d.path }
+
+func (d *fileMeshDiscoverer) discover(context.Context) ([]string, error) {
+	b, err := os.ReadFile(d.path)
+	if err != nil {
+		return nil, err
+	}
+	return parseMeshPeersFile(string(b)), nil
+}
+
+func parseMeshPeersFile(s string) []string {
+	var ret []string
+	for line := range strings.Lines(s) {
+		line, _, _ = strings.Cut(line, "#")
+		ret = append(ret, strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+		})...)
+	}
+	return ret
+}
+
+// derpMapMeshDiscoverer finds mesh peers in a region of a DERP map fetched
+// from a URL.
+type derpMapMeshDiscoverer struct {
+	url      string
+	regionID int
+}
+
+func (d *derpMapMeshDiscoverer) String() string {
+	return fmt.Sprintf("derpmap:%s#%d", d.url, d.regionID)
+}
+
+// maxDERPMapSize is the maximum size of a DERP map that
+// derpMapMeshDiscoverer reads.
+const maxDERPMapSize = 4 << 20
+
+func (d *derpMapMeshDiscoverer) discover(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", d.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching DERP map: %v", res.Status)
+	}
+	var dm tailcfg.DERPMap
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxDERPMapSize)).Decode(&dm); err != nil {
+		return nil, fmt.Errorf("decoding DERP map: %w", err)
+	}
+	return derpRegionMeshPeers(&dm, d.regionID)
+}
+
+// derpRegionMeshPeers returns host tuples for the DERP nodes in region
+// regionID of dm. Nodes with a fixed IPv4 address are dialed at it.
+func derpRegionMeshPeers(dm *tailcfg.DERPMap, regionID int) ([]string, error) {
+	reg := dm.Regions[regionID]
+	if reg == nil {
+		return nil, fmt.Errorf("DERP map has no region %d", regionID)
+	}
+	var ret []string
+	for _, n := range reg.Nodes {
+		if n.STUNOnly || n.HostName == "" {
+			continue
+		}
+		host := n.HostName
+		if n.DERPPort != 0 && n.DERPPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(n.DERPPort))
+		}
+		if ip, err := netip.ParseAddr(n.IPv4); err == nil && ip.Is4() {
+			host += "/" + ip.String()
+		}
+		ret = append(ret, host)
+	}
+	return ret, nil
+}
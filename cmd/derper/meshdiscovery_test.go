@@ -0,0 +1,227 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/derp/derpserver"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestParseMeshDiscovery(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string // String of the result
+		wantErr bool
+	}{
+		{in: "", want: "<nil>"},
+		{in: "srv:_derp-mesh._tcp.example.com", want: "srv:_derp-mesh._tcp.example.com"},
+		{in: "file:/etc/derper/mesh-peers", want: "file:/etc/derper/mesh-peers"},
+		{in: "derpmap:https://example.com/derpmap.json#900", want: "derpmap:https://example.com/derpmap.json#900"},
+		{in: "derpmap:https://example.com/derpmap.json", wantErr: true},
+		{in: "derpmap:ftp://example.com/derpmap.json#900", wantErr: true},
+		{in: "srv:", wantErr: true},
+		{in: "consul:derp", wantErr: true},
+	}
+	for _, tt := range tests {
+		d, err := parseMeshDiscovery(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseMeshDiscovery(%q) error = %v; want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got := "<nil>"
+		if d != nil {
+			got = d.String()
+		}
+		if got != tt.want {
+			t.Errorf("parseMeshDiscovery(%q) = %s; want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSRVMeshDiscoverer(t *testing.T) {
+	d := &srvMeshDiscoverer{
+		name: "_derp-mesh._tcp.example.com",
+		lookupSRV: func(ctx context.Context, name string) ([]*net.SRV, error) {
+			return []*net.SRV{
+				{Target: "derp1.example.com.", Port: 443},
+				{Target: "derp2.example.com.", Port: 8443},
+			}, nil
+		},
+	}
+	got, err := d.discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"derp1.example.com", "derp2.example.com:8443"}
+	if !slices.Equal(got, want) {
+		t.Errorf("discover = %q; want %q", got, want)
+	}
+}
+
+func TestFileMeshDiscoverer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mesh-peers")
+	const contents = `# region 900
+derp1.example.com
+derp2.example.com/10.0.0.2, derp3.example.com # new
+`
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d := &fileMeshDiscoverer{path: path}
+	got, err := d.discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"derp1.example.com", "derp2.example.com/10.0.0.2", "derp3.example.com"}
+	if !slices.Equal(got, want) {
+		t.Errorf("discover = %q; want %q", got, want)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.discover(context.Background()); err == nil {
+		t.Error("unexpected success discovering from missing file")
+	}
+}
+
+func TestDERPMapMeshDiscoverer(t *testing.T) {
+	dm := &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			900: {
+				RegionID: 900,
+				Nodes: []*tailcfg.DERPNode{
+					{Name: "900a", RegionID: 900, HostName: "derp1.example.com"},
+					{Name: "900b", RegionID: 900, HostName: "derp2.example.com", IPv4: "10.0.0.2", DERPPort: 8443},
+					{Name: "900c", RegionID: 900, HostName: "derp3.example.com", IPv4: "none"},
+					{Name: "900s", RegionID: 900, HostName: "stun.example.com", STUNOnly: true},
+				},
+			},
+			901: {
+				RegionID: 901,
+				Nodes: []*tailcfg.DERPNode{
+					{Name: "901a", RegionID: 901, HostName: "derp4.example.com"},
+				},
+			},
+		},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(dm)
+	}))
+	defer ts.Close()
+
+	d, err := parseMeshDiscovery("derpmap:" + ts.URL + "/derpmap.json#900")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"derp1.example.com", "derp2.example.com:8443/10.0.0.2", "derp3.example.com"}
+	if !slices.Equal(got, want) {
+		t.Errorf("discover = %q; want %q", got, want)
+	}
+
+	d, err = parseMeshDiscovery("derpmap:" + ts.URL + "/derpmap.json#902")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.discover(context.Background()); err == nil || !strings.Contains(err.Error(), "no region 902") {
+		t.Errorf("discover of missing region = %v; want error", err)
+	}
+}
+
+// staticMeshDiscoverer is a meshDiscoverer for tests.
+type staticMeshDiscoverer []string
+
+func (d staticMeshDiscoverer) String() string { return "static" }
+
+func (d staticMeshDiscoverer) discover(context.Context) ([]string, error) {
+	return d, nil
+}
+
+func TestMeshPeers(t *testing.T) {
+	s := derpserver.New(key.NewNode(), t.Logf)
+	defer s.Close()
+	if err := s.SetMeshKey(strings.Repeat("ab", 32)); err != nil {
+		t.Fatal(err)
+	}
+	m := newMeshPeers(s)
+	defer func() {
+		m.set("")
+		m.setDiscovery(nil, time.Second)
+		if got := m.status().Peers; len(got) != 0 {
+			t.Errorf("peers after stopping = %v", got)
+		}
+	}()
+
+	peers := func() (static, discovered []string) {
+		for _, p := range m.status().Peers {
+			if p.Static {
+				static = append(static, p.Peer)
+			}
+			if p.Discovered {
+				discovered = append(discovered, p.Peer)
+			}
+		}
+		return static, discovered
+	}
+
+	if err := m.set("127.0.0.1:1,127.0.0.2:1"); err != nil {
+		t.Fatal(err)
+	}
+	first := m.peers["127.0.0.1:1"]
+
+	if err := m.setDiscovery(staticMeshDiscoverer{"127.0.0.2:1", "127.0.0.3:1"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	var static, discovered []string
+	for range 100 {
+		if static, discovered = peers(); len(discovered) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if want := []string{"127.0.0.1:1", "127.0.0.2:1"}; !slices.Equal(static, want) {
+		t.Errorf("static peers = %q; want %q", static, want)
+	}
+	if want := []string{"127.0.0.2:1", "127.0.0.3:1"}; !slices.Equal(discovered, want) {
+		t.Errorf("discovered peers = %q; want %q", discovered, want)
+	}
+
+	// Removing a peer leaves the others alone.
+	if err := m.set("127.0.0.1:1"); err != nil {
+		t.Fatal(err)
+	}
+	if m.peers["127.0.0.1:1"] != first {
+		t.Error("unchanged peer was restarted")
+	}
+	if _, ok := m.peers["127.0.0.2:1"]; !ok {
+		t.Error("peer still discovered was removed")
+	}
+	if got, want := m.peersAdded.Value(), int64(3); got != want {
+		t.Errorf("peers added = %d; want %d", got, want)
+	}
+
+	if err := m.set("a/b/c"); err == nil {
+		t.Error("unexpected success setting invalid host tuple")
+	}
+}
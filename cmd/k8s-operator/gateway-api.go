@@ -0,0 +1,307 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// This file contains a subset of the fields of the Gateway API resources
+// that the operator reconciles. Duplicating them here allows us to avoid
+// importing the gateway-api library, and to run without the Gateway API
+// CRDs installed. The resources are read and written as Unstructured.
+// https://github.com/kubernetes-sigs/gateway-api/tree/v1.2.1/apis
+
+const gatewayAPIGroup = "gateway.networking.k8s.io"
+
+var (
+	gatewayClassGVK = schema.GroupVersionKind{Group: gatewayAPIGroup, Version: "v1", Kind: "GatewayClass"}
+	gatewayGVK      = schema.GroupVersionKind{Group: gatewayAPIGroup, Version: "v1", Kind: "Gateway"}
+	httpRouteGVK    = schema.GroupVersionKind{Group: gatewayAPIGroup, Version: "v1", Kind: "HTTPRoute"}
+	tlsRouteGVK     = schema.GroupVersionKind{Group: gatewayAPIGroup, Version: "v1alpha2", Kind: "TLSRoute"}
+)
+
+// Gateway listener protocols.
+const (
+	gatewayProtocolHTTP  = "HTTP"
+	gatewayProtocolHTTPS = "HTTPS"
+	gatewayProtocolTLS   = "TLS"
+)
+
+// Gateway listener TLS modes.
+const (
+	gatewayTLSModeTerminate   = "Terminate"
+	gatewayTLSModePassthrough = "Passthrough"
+)
+
+// HTTPRoute match types.
+const (
+	pathMatchExact      = "Exact"
+	pathMatchPathPrefix = "PathPrefix"
+	headerMatchExact    = "Exact"
+)
+
+// HTTPRoute filter types.
+const (
+	httpRouteFilterRequestHeaderModifier  = "RequestHeaderModifier"
+	httpRouteFilterResponseHeaderModifier = "ResponseHeaderModifier"
+)
+
+// Gateway API condition types and reasons.
+const (
+	gatewayConditionAccepted     = "Accepted"
+	gatewayConditionProgrammed   = "Programmed"
+	gatewayConditionResolvedRefs = "ResolvedRefs"
+
+	gatewayReasonAccepted            = "Accepted"
+	gatewayReasonProgrammed          = "Programmed"
+	gatewayReasonPending             = "Pending"
+	gatewayReasonInvalid             = "Invalid"
+	gatewayReasonResolvedRefs        = "ResolvedRefs"
+	gatewayReasonUnsupportedProtocol = "UnsupportedProtocol"
+	gatewayReasonUnsupportedValue    = "UnsupportedValue"
+	gatewayReasonNoMatchingParent    = "NoMatchingParent"
+	gatewayReasonBackendNotFound     = "BackendNotFound"
+	gatewayReasonRefNotPermitted     = "RefNotPermitted"
+	gatewayReasonInvalidKind         = "InvalidKind"
+)
+
+// GatewayClass contains a subset of the fields of the
+// gatewayclasses.gateway.networking.k8s.io Custom Resource Definition.
+type GatewayClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec   GatewayClassSpec   `json:"spec"`
+	Status GatewayClassStatus `json:"status,omitempty"`
+}
+
+type GatewayClassSpec struct {
+	// ControllerName is the name of the controller that manages Gateways of
+	// this class.
+	ControllerName string `json:"controllerName"`
+}
+
+type GatewayClassStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// Gateway contains a subset of the fields of the
+// gateways.gateway.networking.k8s.io Custom Resource Definition.
+type Gateway struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec   GatewaySpec   `json:"spec"`
+	Status GatewayStatus `json:"status,omitempty"`
+}
+
+type GatewaySpec struct {
+	GatewayClassName string     `json:"gatewayClassName"`
+	Listeners        []Listener `json:"listeners"`
+}
+
+type Listener struct {
+	Name          string                 `json:"name"`
+	Hostname      *string                `json:"hostname,omitempty"`
+	Port          int32                  `json:"port"`
+	Protocol      string                 `json:"protocol"`
+	TLS           *GatewayTLSConfig      `json:"tls,omitempty"`
+	AllowedRoutes *ListenerAllowedRoutes `json:"allowedRoutes,omitempty"`
+}
+
+type GatewayTLSConfig struct {
+	// Mode is Terminate (the default) or Passthrough.
+	Mode *string `json:"mode,omitempty"`
+}
+
+type ListenerAllowedRoutes struct {
+	Namespaces *RouteNamespaces `json:"namespaces,omitempty"`
+}
+
+type RouteNamespaces struct {
+	// From is Same (the default), All or Selector. Selector is not
+	// supported, and treated as Same.
+	From *string `json:"from,omitempty"`
+}
+
+type GatewayStatus struct {
+	Addresses  []GatewayStatusAddress `json:"addresses,omitempty"`
+	Conditions []metav1.Condition     `json:"conditions,omitempty"`
+	Listeners  []ListenerStatus       `json:"listeners,omitempty"`
+}
+
+type GatewayStatusAddress struct {
+	// Type is Hostname or IPAddress.
+	Type  *string `json:"type,omitempty"`
+	Value string  `json:"value"`
+}
+
+type ListenerStatus struct {
+	Name           string             `json:"name"`
+	SupportedKinds []RouteGroupKind   `json:"supportedKinds"`
+	AttachedRoutes int32              `json:"attachedRoutes"`
+	Conditions     []metav1.Condition `json:"conditions"`
+}
+
+type RouteGroupKind struct {
+	Group *string `json:"group,omitempty"`
+	Kind  string  `json:"kind"`
+}
+
+// HTTPRoute contains a subset of the fields of the
+// httproutes.gateway.networking.k8s.io Custom Resource Definition.
+type HTTPRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec   HTTPRouteSpec `json:"spec"`
+	Status RouteStatus   `json:"status,omitempty"`
+}
+
+type HTTPRouteSpec struct {
+	ParentRefs []ParentReference `json:"parentRefs,omitempty"`
+	Hostnames  []string          `json:"hostnames,omitempty"`
+	Rules      []HTTPRouteRule   `json:"rules,omitempty"`
+}
+
+type HTTPRouteRule struct {
+	Matches     []HTTPRouteMatch  `json:"matches,omitempty"`
+	Filters     []HTTPRouteFilter `json:"filters,omitempty"`
+	BackendRefs []BackendRef      `json:"backendRefs,omitempty"`
+}
+
+type HTTPRouteMatch struct {
+	Path        *HTTPPathMatch        `json:"path,omitempty"`
+	Headers     []HTTPHeaderMatch     `json:"headers,omitempty"`
+	QueryParams []HTTPQueryParamMatch `json:"queryParams,omitempty"`
+	Method      *string               `json:"method,omitempty"`
+}
+
+type HTTPPathMatch struct {
+	// Type is Exact, PathPrefix (the default) or RegularExpression, which is
+	// not supported.
+	Type  *string `json:"type,omitempty"`
+	Value *string `json:"value,omitempty"`
+}
+
+type HTTPHeaderMatch struct {
+	// Type is Exact (the default) or RegularExpression, which is not
+	// supported.
+	Type  *string `json:"type,omitempty"`
+	Name  string  `json:"name"`
+	Value string  `json:"value"`
+}
+
+type HTTPQueryParamMatch struct {
+	Name string `json:"name"`
+}
+
+type HTTPRouteFilter struct {
+	Type                   string            `json:"type"`
+	RequestHeaderModifier  *HTTPHeaderFilter `json:"requestHeaderModifier,omitempty"`
+	ResponseHeaderModifier *HTTPHeaderFilter `json:"responseHeaderModifier,omitempty"`
+}
+
+type HTTPHeaderFilter struct {
+	Set    []HTTPHeader `json:"set,omitempty"`
+	Add    []HTTPHeader `json:"add,omitempty"`
+	Remove []string     `json:"remove,omitempty"`
+}
+
+type HTTPHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// TLSRoute contains a subset of the fields of the
+// tlsroutes.gateway.networking.k8s.io Custom Resource Definition.
+type TLSRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec   TLSRouteSpec `json:"spec"`
+	Status RouteStatus  `json:"status,omitempty"`
+}
+
+type TLSRouteSpec struct {
+	ParentRefs []ParentReference `json:"parentRefs,omitempty"`
+	Hostnames  []string          `json:"hostnames,omitempty"`
+	Rules      []TLSRouteRule    `json:"rules,omitempty"`
+}
+
+type TLSRouteRule struct {
+	BackendRefs []BackendRef `json:"backendRefs,omitempty"`
+}
+
+type ParentReference struct {
+	Group       *string `json:"group,omitempty"`
+	Kind        *string `json:"kind,omitempty"`
+	Namespace   *string `json:"namespace,omitempty"`
+	Name        string  `json:"name"`
+	SectionName *string `json:"sectionName,omitempty"`
+	Port        *int32  `json:"port,omitempty"`
+}
+
+type BackendRef struct {
+	// Group and Kind default to a core Service, the only supported kind of
+	// backend.
+	Group     *string `json:"group,omitempty"`
+	Kind      *string `json:"kind,omitempty"`
+	Name      string  `json:"name"`
+	Namespace *string `json:"namespace,omitempty"`
+	Port      *int32  `json:"port,omitempty"`
+	Weight    *int32  `json:"weight,omitempty"`
+}
+
+type RouteStatus struct {
+	Parents []RouteParentStatus `json:"parents"`
+}
+
+type RouteParentStatus struct {
+	ParentRef      ParentReference    `json:"parentRef"`
+	ControllerName string             `json:"controllerName"`
+	Conditions     []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// newUnstructured returns an empty Unstructured of the given kind, that can
+// be used in kube API server calls via the c/r client.
+func newUnstructured(gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	return u
+}
+
+// newUnstructuredList returns an empty UnstructuredList of the given kind.
+func newUnstructuredList(gvk schema.GroupVersionKind) *unstructured.UnstructuredList {
+	l := &unstructured.UnstructuredList{}
+	l.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	return l
+}
+
+// fromUnstructured converts u to obj, which must be a pointer to one of the
+// types in this file.
+func fromUnstructured(u *unstructured.Unstructured, obj any) error {
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), obj); err != nil {
+		return fmt.Errorf("error converting %s %s/%s: %w", u.GetKind(), u.GetNamespace(), u.GetName(), err)
+	}
+	return nil
+}
+
+// setUnstructuredStatus sets the status of u to status, which must be one of
+// the status types in this file.
+func setUnstructuredStatus(u *unstructured.Unstructured, status any) error {
+	st, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+	if err != nil {
+		return fmt.Errorf("error converting %s status: %w", u.GetKind(), err)
+	}
+	return unstructured.SetNestedMap(u.Object, st, "status")
+}
@@ -0,0 +1,583 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"tailscale.com/ipn"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
+)
+
+// routeObject is an HTTPRoute or TLSRoute, with the Unstructured it was read
+// from.
+type routeObject struct {
+	u          *unstructured.Unstructured
+	parentRefs []ParentReference
+	status     *RouteStatus
+
+	http *HTTPRoute // set for an HTTPRoute
+	tls  *TLSRoute  // set for a TLSRoute
+}
+
+// routeResult is the outcome of translating a route for a Gateway, reported
+// in the route's status.
+type routeResult struct {
+	attached bool // to at least one listener
+
+	// unresolvedReason and unresolvedMsg are the reason and message of the
+	// route's ResolvedRefs condition, if any of its backends could not be
+	// resolved.
+	unresolvedReason string
+	unresolvedMsg    string
+
+	// unsupported are the parts of the route that were ignored because
+	// they aren't supported.
+	unsupported []string
+
+	// forwarded is whether a TLS listener forwards connections to the
+	// route's backend. shadowedBy is the namespace/name of the TLSRoute
+	// whose backend a TLS listener forwards connections to instead, if
+	// the route is attached to one that doesn't forward to its own.
+	forwarded  bool
+	shadowedBy string
+}
+
+// acceptedCondition returns the status, reason and message of the route's
+// Accepted condition.
+func (res *routeResult) acceptedCondition() (_ metav1.ConditionStatus, reason, msg string) {
+	unsupported := res.unsupported
+	switch {
+	case !res.attached:
+		return metav1.ConditionFalse, gatewayReasonNoMatchingParent, "no listener of the Gateway accepts the route"
+	case res.shadowedBy != "" && !res.forwarded:
+		return metav1.ConditionFalse, gatewayReasonUnsupportedValue, fmt.Sprintf("the Gateway's TLS listener forwards all connections to the backend of TLSRoute %s; routing connections to more than one TLSRoute by hostname (SNI) is not supported", res.shadowedBy)
+	case res.shadowedBy != "":
+		unsupported = append(slices.Clone(unsupported), "more than one TLSRoute per listener")
+	}
+	if len(unsupported) == 0 {
+		return metav1.ConditionTrue, gatewayReasonAccepted, ""
+	}
+	slices.Sort(unsupported)
+	return metav1.ConditionTrue, gatewayReasonAccepted, "some of the route is not supported and was ignored: " + strings.Join(slices.Compact(unsupported), ", ")
+}
+
+func (res *routeResult) setUnresolved(reason, msg string) {
+	if res.unresolvedReason == "" {
+		res.unresolvedReason, res.unresolvedMsg = reason, msg
+	}
+}
+
+// gatewayBackend is a backend of a route rule.
+type gatewayBackend struct {
+	addr   string // IP:port
+	https  bool   // whether the backend expects TLS
+	weight int
+}
+
+// proxyURL returns the backend as an ipn.HTTPHandler.Proxy value.
+func (b gatewayBackend) proxyURL() string {
+	if b.https {
+		return "https+insecure://" + b.addr
+	}
+	return "http://" + b.addr
+}
+
+// httpRouteEntry is a match of an HTTPRoute rule, with the handler for the
+// requests it matches.
+type httpRouteEntry struct {
+	exact   bool // path must match exactly, rather than as a prefix
+	path    string
+	headers map[string]string
+	handler *ipn.HTTPHandler
+}
+
+// parentRefMatches reports whether ref, of a route in routeNS, refers to gw.
+func parentRefMatches(ref ParentReference, routeNS string, gw *Gateway) bool {
+	if ptrOr(ref.Group, gatewayAPIGroup) != gatewayAPIGroup || ptrOr(ref.Kind, "Gateway") != "Gateway" {
+		return false
+	}
+	return ptrOr(ref.Namespace, routeNS) == gw.Namespace && ref.Name == gw.Name
+}
+
+// listenerAccepts reports whether listener l of gw accepts a route of the
+// given kind in routeNS, attached with ref.
+func listenerAccepts(gw *Gateway, l Listener, ref ParentReference, kind, routeNS string) bool {
+	if ref.SectionName != nil && *ref.SectionName != l.Name {
+		return false
+	}
+	if ref.Port != nil && *ref.Port != l.Port {
+		return false
+	}
+	switch l.Protocol {
+	case gatewayProtocolHTTP, gatewayProtocolHTTPS:
+		if kind != httpRouteGVK.Kind {
+			return false
+		}
+	case gatewayProtocolTLS:
+		if kind != tlsRouteGVK.Kind {
+			return false
+		}
+	default:
+		return false
+	}
+	from := "Same"
+	if l.AllowedRoutes != nil && l.AllowedRoutes.Namespaces != nil {
+		from = ptrOr(l.AllowedRoutes.Namespaces.From, from)
+	}
+	return from == "All" || routeNS == gw.Namespace
+}
+
+// listenerTLSMode returns the TLS mode of an HTTPS or TLS listener.
+func listenerTLSMode(l Listener) string {
+	if l.TLS == nil {
+		return gatewayTLSModeTerminate
+	}
+	return ptrOr(l.TLS.Mode, gatewayTLSModeTerminate)
+}
+
+// validateListener returns the reason and message of why l can't be
+// served, or empty strings if it can.
+func validateListener(l Listener) (reason, msg string) {
+	if l.Port <= 0 || l.Port > 65535 {
+		return gatewayReasonInvalid, fmt.Sprintf("invalid port %d", l.Port)
+	}
+	switch l.Protocol {
+	case gatewayProtocolHTTP:
+	case gatewayProtocolHTTPS:
+		if mode := listenerTLSMode(l); mode != gatewayTLSModeTerminate {
+			return gatewayReasonUnsupportedValue, fmt.Sprintf("TLS mode %q is not supported for HTTPS listeners, use a TLS listener instead", mode)
+		}
+	case gatewayProtocolTLS:
+		if mode := listenerTLSMode(l); mode != gatewayTLSModeTerminate && mode != gatewayTLSModePassthrough {
+			return gatewayReasonUnsupportedValue, fmt.Sprintf("unknown TLS mode %q", mode)
+		}
+	default:
+		return gatewayReasonUnsupportedProtocol, fmt.Sprintf("protocol %q is not supported, supported protocols are HTTP, HTTPS and TLS", l.Protocol)
+	}
+	return "", ""
+}
+
+// resolveBackend resolves ref, of a route in routeNS, to the cluster IP and
+// port of a Service. If it can't, it returns the reason and message for the
+// route's ResolvedRefs condition.
+func resolveBackend(ctx context.Context, cl client.Client, routeNS string, ref BackendRef) (_ gatewayBackend, reason, msg string) {
+	if ptrOr(ref.Group, "") != "" || ptrOr(ref.Kind, "Service") != "Service" {
+		return gatewayBackend{}, gatewayReasonInvalidKind, fmt.Sprintf("backend %q is not a Service", ref.Name)
+	}
+	if ns := ptrOr(ref.Namespace, routeNS); ns != routeNS {
+		return gatewayBackend{}, gatewayReasonRefNotPermitted, fmt.Sprintf("backend %s/%s is in another namespace, which is not supported", ns, ref.Name)
+	}
+	if ref.Port == nil {
+		return gatewayBackend{}, gatewayReasonBackendNotFound, fmt.Sprintf("backend %q has no port", ref.Name)
+	}
+	svc := &corev1.Service{}
+	err := cl.Get(ctx, types.NamespacedName{Namespace: routeNS, Name: ref.Name}, svc)
+	if apierrors.IsNotFound(err) {
+		return gatewayBackend{}, gatewayReasonBackendNotFound, fmt.Sprintf("Service %q not found", ref.Name)
+	} else if err != nil {
+		return gatewayBackend{}, gatewayReasonBackendNotFound, fmt.Sprintf("error getting Service %q: %v", ref.Name, err)
+	}
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == "None" {
+		return gatewayBackend{}, gatewayReasonBackendNotFound, fmt.Sprintf("Service %q has no ClusterIP", ref.Name)
+	}
+	i := slices.IndexFunc(svc.Spec.Ports, func(p corev1.ServicePort) bool { return p.Port == *ref.Port })
+	if i < 0 {
+		return gatewayBackend{}, gatewayReasonBackendNotFound, fmt.Sprintf("Service %q has no port %d", ref.Name, *ref.Port)
+	}
+	port := svc.Spec.Ports[i]
+	return gatewayBackend{
+		addr:   net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(int(port.Port))),
+		https:  port.Port == 443 || port.Name == "https" || ptrOr(port.AppProtocol, "") == "https",
+		weight: int(ptrOr(ref.Weight, 1)),
+	}, "", ""
+}
+
+// resolveBackends resolves the backends of a route rule, recording any that
+// can't be resolved in res. It omits backends with weight 0.
+func resolveBackends(ctx context.Context, cl client.Client, routeNS string, refs []BackendRef, res *routeResult) []gatewayBackend {
+	var backends []gatewayBackend
+	for _, ref := range refs {
+		b, reason, msg := resolveBackend(ctx, cl, routeNS, ref)
+		if reason != "" {
+			res.setUnresolved(reason, msg)
+			continue
+		}
+		if b.weight > 0 {
+			backends = append(backends, b)
+		}
+	}
+	return backends
+}
+
+// handlerForHTTPRouteRule returns the handler for the requests matching an
+// HTTPRoute rule with the given backends and filters, recording filters that
+// aren't supported in res. With no backends, the handler serves 500 errors.
+func handlerForHTTPRouteRule(backends []gatewayBackend, filters []HTTPRouteFilter, res *routeResult) *ipn.HTTPHandler {
+	// Gateway API routes requests with their paths unmodified.
+	h := &ipn.HTTPHandler{StripPrefix: "/"}
+	weighted := false
+	for i, b := range backends {
+		if i == 0 {
+			h.Proxy = b.proxyURL()
+		} else {
+			h.Upstreams = append(h.Upstreams, b.proxyURL())
+		}
+		h.Weights = append(h.Weights, b.weight)
+		weighted = weighted || b.weight != backends[0].weight
+	}
+	if !weighted {
+		h.Weights = nil
+	}
+	for _, f := range filters {
+		switch {
+		case f.Type == httpRouteFilterRequestHeaderModifier && f.RequestHeaderModifier != nil:
+			m := f.RequestHeaderModifier
+			for _, hdr := range slices.Concat(m.Set, m.Add) {
+				mak.Set(&h.SetRequestHeaders, hdr.Name, hdr.Value)
+			}
+			h.RemoveRequestHeaders = append(h.RemoveRequestHeaders, m.Remove...)
+		case f.Type == httpRouteFilterResponseHeaderModifier && f.ResponseHeaderModifier != nil:
+			m := f.ResponseHeaderModifier
+			for _, hdr := range slices.Concat(m.Set, m.Add) {
+				mak.Set(&h.SetResponseHeaders, hdr.Name, hdr.Value)
+			}
+			h.RemoveResponseHeaders = append(h.RemoveResponseHeaders, m.Remove...)
+		default:
+			res.unsupported = append(res.unsupported, fmt.Sprintf("filter %q", f.Type))
+		}
+	}
+	return h
+}
+
+// httpRouteEntries returns the entries for the rules of route, recording
+// matches that aren't supported in res.
+func httpRouteEntries(ctx context.Context, cl client.Client, route *HTTPRoute, res *routeResult) []httpRouteEntry {
+	var entries []httpRouteEntry
+	for _, rule := range route.Spec.Rules {
+		backends := resolveBackends(ctx, cl, route.Namespace, rule.BackendRefs, res)
+		h := handlerForHTTPRouteRule(backends, rule.Filters, res)
+		matches := rule.Matches
+		if len(matches) == 0 {
+			matches = []HTTPRouteMatch{{}}
+		}
+	nextMatch:
+		for _, m := range matches {
+			e := httpRouteEntry{path: "/", handler: h}
+			if m.Path != nil {
+				switch typ := ptrOr(m.Path.Type, pathMatchPathPrefix); typ {
+				case pathMatchExact:
+					e.exact = true
+				case pathMatchPathPrefix:
+				default:
+					res.unsupported = append(res.unsupported, fmt.Sprintf("path match type %q", typ))
+					continue nextMatch
+				}
+				e.path = ptrOr(m.Path.Value, "/")
+			}
+			if m.Method != nil || len(m.QueryParams) > 0 {
+				res.unsupported = append(res.unsupported, "method and query parameter matches")
+				continue
+			}
+			for _, hm := range m.Headers {
+				if typ := ptrOr(hm.Type, headerMatchExact); typ != headerMatchExact {
+					res.unsupported = append(res.unsupported, fmt.Sprintf("header match type %q", typ))
+					continue nextMatch
+				}
+				mak.Set(&e.headers, hm.Name, hm.Value)
+			}
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// handlersForHTTPRouteEntries returns the serve handlers, by mount point,
+// that route requests as entries do. Entries are in the order of the routes
+// and rules they come from, oldest route first.
+//
+// Each path becomes a mount point, so that the longest matching path takes
+// precedence. At each mount point, exact path matches come first, then
+// matches with more headers. A mount point whose entries all have
+// conditions falls back to those of the mount point of the next shorter
+// path, as a request that matches none of them would with Gateway API.
+func handlersForHTTPRouteEntries(entries []httpRouteEntry) map[string]*ipn.HTTPHandler {
+	handlers := map[string]*ipn.HTTPHandler{}
+	hasDefault := set.Set[string]{}
+	for _, e := range entries {
+		mount := e.path
+		if !e.exact && mount != "/" {
+			mount = strings.TrimSuffix(mount, "/")
+		}
+		h, ok := handlers[mount]
+		if !ok {
+			h = &ipn.HTTPHandler{}
+			handlers[mount] = h
+		}
+		if !e.exact && len(e.headers) == 0 {
+			// The oldest route takes precedence.
+			if !hasDefault.Contains(mount) {
+				setHandlerBackend(h, e.handler)
+				hasDefault.Add(mount)
+			}
+			continue
+		}
+		rt := &ipn.HTTPRoute{Headers: e.headers, Handler: e.handler}
+		if e.exact {
+			rt.Path = e.path
+		}
+		h.Routes = append(h.Routes, rt)
+	}
+
+	mounts := slices.Sorted(maps.Keys(handlers))
+	// Shorter mount points first, so that those they fall back to are
+	// complete by the time they do.
+	slices.SortStableFunc(mounts, func(a, b string) int { return cmp.Compare(len(a), len(b)) })
+	for _, mount := range mounts {
+		h := handlers[mount]
+		slices.SortStableFunc(h.Routes, func(a, b *ipn.HTTPRoute) int {
+			if (a.Path != "") != (b.Path != "") {
+				if a.Path != "" {
+					return -1
+				}
+				return 1
+			}
+			return cmp.Compare(len(b.Headers), len(a.Headers))
+		})
+		if hasDefault.Contains(mount) {
+			continue
+		}
+		parent, ok := parentMount(handlers, mount)
+		if !ok {
+			continue
+		}
+		p := handlers[parent]
+		for _, rt := range p.Routes {
+			if rt.Path == "" {
+				h.Routes = append(h.Routes, rt.Clone())
+			}
+		}
+		if hasDefault.Contains(parent) {
+			setHandlerBackend(h, p)
+			hasDefault.Add(mount)
+		}
+	}
+	return handlers
+}
+
+// parentMount returns the mount point in handlers that requests for the
+// path of mount would be routed to if mount didn't exist, which is the
+// first one that LocalBackend's lookup of serve handlers tries.
+func parentMount(handlers map[string]*ipn.HTTPHandler, mount string) (string, bool) {
+	for mount != "/" {
+		if strings.HasSuffix(mount, "/") {
+			mount = strings.TrimSuffix(mount, "/")
+		} else {
+			mount = path.Dir(mount)
+			if _, ok := handlers[mount+"/"]; ok && mount != "/" {
+				return mount + "/", true
+			}
+		}
+		if _, ok := handlers[mount]; ok {
+			return mount, true
+		}
+	}
+	return "", false
+}
+
+// setHandlerBackend sets everything but the Routes of dst to those of src.
+func setHandlerBackend(dst, src *ipn.HTTPHandler) {
+	routes := dst.Routes
+	*dst = *src.Clone()
+	dst.Routes = routes
+}
+
+// gatewayServeConfig is the serve config of a Gateway, for a single host.
+type gatewayServeConfig struct {
+	TCP map[uint16]*ipn.TCPPortHandler
+	Web map[ipn.HostPort]*ipn.WebServerConfig
+}
+
+// programmedPorts returns the ports served by cfg, sorted.
+func (cfg *gatewayServeConfig) programmedPorts() []uint16 {
+	ports := make([]uint16, 0, len(cfg.TCP))
+	for p := range cfg.TCP {
+		ports = append(ports, p)
+	}
+	slices.Sort(ports)
+	return ports
+}
+
+// needsCerts reports whether cfg terminates TLS.
+func (cfg *gatewayServeConfig) needsCerts() bool {
+	for _, h := range cfg.TCP {
+		if h.HTTPS || h.TerminateTLS != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPlaintext reports whether cfg serves anything without terminating TLS.
+func (cfg *gatewayServeConfig) hasPlaintext() bool {
+	for _, h := range cfg.TCP {
+		if h.HTTP || (h.TCPForward != "" && h.TerminateTLS == "") {
+			return true
+		}
+	}
+	return false
+}
+
+// buildGatewayServeConfig returns the serve config for gw, served on host,
+// with the routes attached to it. It also returns the status of gw's
+// listeners, and the results of translating each route.
+func buildGatewayServeConfig(ctx context.Context, cl client.Client, gw *Gateway, routes []*routeObject, host string) (*gatewayServeConfig, []ListenerStatus, map[*routeObject]*routeResult) {
+	cfg := &gatewayServeConfig{}
+	results := make(map[*routeObject]*routeResult, len(routes))
+	for _, ro := range routes {
+		results[ro] = &routeResult{}
+	}
+	var statuses []ListenerStatus
+	ports := set.Set[int32]{}
+	// Entries are resolved once per route, not once per listener.
+	httpEntries := map[*routeObject][]httpRouteEntry{}
+	for _, l := range gw.Spec.Listeners {
+		st := ListenerStatus{Name: l.Name}
+		group := gatewayAPIGroup
+		switch l.Protocol {
+		case gatewayProtocolHTTP, gatewayProtocolHTTPS:
+			st.SupportedKinds = []RouteGroupKind{{Group: &group, Kind: httpRouteGVK.Kind}}
+		case gatewayProtocolTLS:
+			st.SupportedKinds = []RouteGroupKind{{Group: &group, Kind: tlsRouteGVK.Kind}}
+		default:
+			st.SupportedKinds = []RouteGroupKind{}
+		}
+		reason, msg := validateListener(l)
+		if reason == "" && ports.Contains(l.Port) {
+			reason, msg = gatewayReasonInvalid, fmt.Sprintf("port %d is used by another listener", l.Port)
+		}
+		if reason != "" {
+			st.Conditions = listenerConditions(metav1.ConditionFalse, reason, msg, gw.Generation)
+			statuses = append(statuses, st)
+			continue
+		}
+		ports.Add(l.Port)
+
+		var attached []*routeObject
+		for _, ro := range routes {
+			for _, ref := range ro.parentRefs {
+				if parentRefMatches(ref, ro.u.GetNamespace(), gw) && listenerAccepts(gw, l, ref, ro.u.GetKind(), ro.u.GetNamespace()) {
+					attached = append(attached, ro)
+					results[ro].attached = true
+					break
+				}
+			}
+		}
+		st.AttachedRoutes = int32(len(attached))
+
+		port := uint16(l.Port)
+		hp := ipn.HostPort(net.JoinHostPort(host, strconv.Itoa(int(port))))
+		switch l.Protocol {
+		case gatewayProtocolHTTP, gatewayProtocolHTTPS:
+			var entries []httpRouteEntry
+			for _, ro := range attached {
+				es, ok := httpEntries[ro]
+				if !ok {
+					es = httpRouteEntries(ctx, cl, ro.http, results[ro])
+					httpEntries[ro] = es
+				}
+				entries = append(entries, es...)
+			}
+			if len(entries) == 0 {
+				break
+			}
+			mak.Set(&cfg.TCP, port, &ipn.TCPPortHandler{
+				HTTP:  l.Protocol == gatewayProtocolHTTP,
+				HTTPS: l.Protocol == gatewayProtocolHTTPS,
+			})
+			mak.Set(&cfg.Web, hp, &ipn.WebServerConfig{Handlers: handlersForHTTPRouteEntries(entries)})
+		case gatewayProtocolTLS:
+			// Connections can only be forwarded to a single backend, that
+			// of the oldest route with one, whatever their SNI. The other
+			// routes are not accepted.
+			var forwarded *routeObject
+			for _, ro := range attached {
+				var backends []gatewayBackend
+				for _, rule := range ro.tls.Spec.Rules {
+					backends = append(backends, resolveBackends(ctx, cl, ro.u.GetNamespace(), rule.BackendRefs, results[ro])...)
+				}
+				if len(backends) == 0 || forwarded != nil {
+					continue
+				}
+				forwarded = ro
+				res := results[ro]
+				res.forwarded = true
+				if len(backends) > 1 {
+					res.unsupported = append(res.unsupported, "more than one backend")
+				}
+				if len(ro.tls.Spec.Hostnames) > 0 {
+					res.unsupported = append(res.unsupported, "hostnames (connections are forwarded whatever their SNI)")
+				}
+				th := &ipn.TCPPortHandler{TCPForward: backends[0].addr}
+				if listenerTLSMode(l) == gatewayTLSModeTerminate {
+					th.TerminateTLS = host
+				}
+				mak.Set(&cfg.TCP, port, th)
+			}
+			if forwarded != nil {
+				for _, ro := range attached {
+					if ro != forwarded {
+						results[ro].shadowedBy = forwarded.u.GetNamespace() + "/" + forwarded.u.GetName()
+					}
+				}
+			}
+		}
+		st.Conditions = listenerConditions(metav1.ConditionTrue, "", "", gw.Generation)
+		statuses = append(statuses, st)
+	}
+	return cfg, statuses, results
+}
+
+// listenerConditions returns the conditions of a listener that is accepted,
+// or not accepted for reason, with msg.
+func listenerConditions(status metav1.ConditionStatus, reason, msg string, gen int64) []metav1.Condition {
+	if status == metav1.ConditionTrue {
+		return []metav1.Condition{
+			{Type: gatewayConditionAccepted, Status: status, Reason: gatewayReasonAccepted, ObservedGeneration: gen},
+			{Type: gatewayConditionProgrammed, Status: status, Reason: gatewayReasonProgrammed, ObservedGeneration: gen},
+			{Type: gatewayConditionResolvedRefs, Status: status, Reason: gatewayReasonResolvedRefs, ObservedGeneration: gen},
+		}
+	}
+	return []metav1.Condition{
+		{Type: gatewayConditionAccepted, Status: status, Reason: reason, Message: msg, ObservedGeneration: gen},
+		{Type: gatewayConditionProgrammed, Status: status, Reason: gatewayReasonInvalid, Message: msg, ObservedGeneration: gen},
+		{Type: gatewayConditionResolvedRefs, Status: metav1.ConditionTrue, Reason: gatewayReasonResolvedRefs, ObservedGeneration: gen},
+	}
+}
+
+// ptrOr returns *p, or def if p is nil.
+func ptrOr[T any](p *T, def T) T {
+	if p == nil {
+		return def
+	}
+	return *p
+}
@@ -0,0 +1,1008 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"tailscale.com/internal/client/tailscale"
+	"tailscale.com/ipn"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/kube/kubetypes"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
+	"tailscale.com/types/opt"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
+)
+
+// tailscaleGatewayControllerName is the spec.controllerName of GatewayClasses
+// whose Gateways the operator manages.
+const tailscaleGatewayControllerName = "tailscale.com/gateway-controller"
+
+var (
+	// gaugeGatewayResources tracks the number of Gateways that we're
+	// currently managing.
+	gaugeGatewayResources = clientmetric.NewGauge(kubetypes.MetricGatewayResourceCount)
+)
+
+// GatewayClassReconciler accepts GatewayClasses with the Tailscale controller
+// name.
+type GatewayClassReconciler struct {
+	client.Client
+
+	logger *zap.SugaredLogger
+	clock  tstime.Clock
+}
+
+func (r *GatewayClassReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	logger := r.logger.With("GatewayClass", req.Name)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	u := newUnstructured(gatewayClassGVK)
+	err := r.Get(ctx, req.NamespacedName, u)
+	if apierrors.IsNotFound(err) {
+		logger.Debugf("GatewayClass not found, assuming it was deleted")
+		return reconcile.Result{}, nil
+	} else if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get GatewayClass: %w", err)
+	}
+	gc := new(GatewayClass)
+	if err := fromUnstructured(u, gc); err != nil {
+		return reconcile.Result{}, err
+	}
+	if gc.Spec.ControllerName != tailscaleGatewayControllerName {
+		return reconcile.Result{}, nil
+	}
+
+	old := u.DeepCopy()
+	tsoperator.SetCondition(&gc.Status.Conditions, gatewayConditionAccepted, metav1.ConditionTrue, gatewayReasonAccepted, "GatewayClass is managed by the Tailscale Kubernetes operator", gc.Generation, r.clock, logger)
+	if err := setUnstructuredStatus(u, &gc.Status); err != nil {
+		return reconcile.Result{}, err
+	}
+	if apiequality.Semantic.DeepEqual(old.Object["status"], u.Object["status"]) {
+		return reconcile.Result{}, nil
+	}
+	logger.Infof("accepting GatewayClass")
+	if err := r.Status().Update(ctx, u); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to update GatewayClass status: %w", err)
+	}
+	return reconcile.Result{}, nil
+}
+
+// GatewayReconciler exposes Gateways of a Tailscale GatewayClass on the
+// tailnet. It translates the HTTPRoutes and TLSRoutes attached to a Gateway
+// to a serve config, which it serves on a proxy of the Gateway's own, or, if
+// the Gateway has the tailscale.com/proxy-group annotation, as a Tailscale
+// Service on an ingress ProxyGroup, in the same way as Ingresses.
+type GatewayReconciler struct {
+	client.Client
+
+	recorder record.EventRecorder
+	ssr      *tailscaleSTSReconciler
+	logger   *zap.SugaredLogger
+	clock    tstime.Clock
+
+	// ha does the work that is the same for Gateways on ProxyGroups as for
+	// HA Ingresses.
+	ha *HAIngressReconciler
+
+	// tlsRoutes is whether the TLSRoute CRD is installed.
+	tlsRoutes bool
+
+	defaultProxyClass string
+
+	mu sync.Mutex // protects following
+
+	// managedGateways is a set of all Gateways that we're currently
+	// managing. This is only used for metrics.
+	managedGateways set.Slice[types.UID]
+}
+
+func (r *GatewayReconciler) Reconcile(ctx context.Context, req reconcile.Request) (res reconcile.Result, err error) {
+	logger := r.logger.With("Gateway", req.NamespacedName)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	u := newUnstructured(gatewayGVK)
+	err = r.Get(ctx, req.NamespacedName, u)
+	if apierrors.IsNotFound(err) {
+		// Request object not found, could have been deleted after reconcile request.
+		logger.Debugf("Gateway not found, assuming it was deleted")
+		return res, nil
+	} else if err != nil {
+		return res, fmt.Errorf("failed to get Gateway: %w", err)
+	}
+	gw := new(Gateway)
+	if err := fromUnstructured(u, gw); err != nil {
+		return res, err
+	}
+
+	managed, err := r.isTailscaleGatewayClass(ctx, gw.Spec.GatewayClassName)
+	if err != nil {
+		return res, err
+	}
+	pgName := gw.Annotations[AnnotationProxyGroup]
+	if !gw.DeletionTimestamp.IsZero() || !managed {
+		logger.Debugf("Gateway is being deleted or should not be exposed, cleaning up")
+		return res, r.maybeCleanup(ctx, logger, u, gw)
+	}
+
+	var svcsChanged bool
+	if pgName == "" {
+		err = r.maybeProvision(ctx, logger, u, gw)
+	} else {
+		svcsChanged, err = r.maybeProvisionPG(ctx, logger, u, gw, pgName)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), optimisticLockErrorMsg) {
+			logger.Infof("optimistic lock error, retrying: %s", err)
+			return res, nil
+		}
+		return res, err
+	}
+	if svcsChanged {
+		// See HAIngressReconciler.Reconcile.
+		res = reconcile.Result{RequeueAfter: requeueInterval()}
+	}
+	return res, nil
+}
+
+// isTailscaleGatewayClass reports whether the GatewayClass name has the
+// Tailscale controller name.
+func (r *GatewayReconciler) isTailscaleGatewayClass(ctx context.Context, name string) (bool, error) {
+	u := newUnstructured(gatewayClassGVK)
+	err := r.Get(ctx, types.NamespacedName{Name: name}, u)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get GatewayClass %q: %w", name, err)
+	}
+	gc := new(GatewayClass)
+	if err := fromUnstructured(u, gc); err != nil {
+		return false, err
+	}
+	return gc.Spec.ControllerName == tailscaleGatewayControllerName, nil
+}
+
+// maybeProvision ensures that gw is exposed over tailscale on a proxy of its
+// own, and that its status and those of its routes are up to date.
+func (r *GatewayReconciler) maybeProvision(ctx context.Context, logger *zap.SugaredLogger, u *unstructured.Unstructured, gw *Gateway) error {
+	if slices.Contains(gw.Finalizers, FinalizerNamePG) {
+		// The Gateway has moved off a ProxyGroup.
+		if _, err := r.maybeCleanupPG(ctx, logger, u, gw, ""); err != nil {
+			return err
+		}
+	}
+	if !slices.Contains(gw.Finalizers, FinalizerName) {
+		// This log line is printed exactly once during initial provisioning,
+		// because once the finalizer is in place this block gets skipped. So,
+		// this is a nice place to tell the operator that the high level,
+		// multi-reconcile operation is underway.
+		logger.Infof("exposing Gateway over tailscale")
+		if err := r.addFinalizer(ctx, u, gw, FinalizerName); err != nil {
+			return err
+		}
+	}
+
+	proxyClass := proxyClassForObject(gw, r.defaultProxyClass)
+	if proxyClass != "" {
+		if ready, err := proxyClassIsReady(ctx, proxyClass, r.Client); err != nil {
+			return fmt.Errorf("error verifying ProxyClass for Gateway: %w", err)
+		} else if !ready {
+			logger.Infof("ProxyClass %s specified for the Gateway, but is not (yet) Ready, waiting..", proxyClass)
+			return nil
+		}
+	}
+
+	r.mu.Lock()
+	r.managedGateways.Add(gw.UID)
+	gaugeGatewayResources.Set(int64(r.managedGateways.Len()))
+	r.mu.Unlock()
+
+	if !IsHTTPSEnabledOnTailnet(r.ssr.tsnetServer) {
+		r.recorder.Event(u, corev1.EventTypeWarning, "HTTPSNotEnabled", "HTTPS is not enabled on the tailnet; Gateway may not work")
+	}
+	if violations := tagViolations(gw); len(violations) > 0 {
+		return r.setGatewayInvalid(ctx, logger, u, gw, fmt.Sprintf("Gateway contains invalid tags: %v", strings.Join(violations, ",")))
+	}
+
+	routes, err := r.attachedRoutes(ctx, gw)
+	if err != nil {
+		return err
+	}
+	// magicHost is a fake hostname that we can use to tell containerboot to
+	// swap out with the real hostname once it's known.
+	const magicHost = "${TS_CERT_DOMAIN}"
+	gcfg, listeners, results := buildGatewayServeConfig(ctx, r.Client, gw, routes, magicHost)
+	if err := r.updateRouteStatuses(ctx, logger, gw, routes, results); err != nil {
+		return err
+	}
+	sc := &ipn.ServeConfig{TCP: gcfg.TCP, Web: gcfg.Web}
+	if opt.Bool(gw.Annotations[AnnotationFunnel]).EqualBool(true) {
+		for hp := range gcfg.Web {
+			if gcfg.TCP[portOfHostPort(hp)].HTTPS {
+				mak.Set(&sc.AllowFunnel, hp, true)
+			}
+		}
+	}
+	if len(sc.TCP) == 0 {
+		logger.Warn("Gateway has no listeners with routes")
+		r.recorder.Event(u, corev1.EventTypeWarning, "NoValidRoutes", "no listeners with valid routes")
+	}
+
+	crl := childResourceLabels(gw.Name, gw.Namespace, "gateway")
+	var tags []string
+	if tstr, ok := gw.Annotations[AnnotationTags]; ok {
+		tags = strings.Split(tstr, ",")
+	}
+	sts := &tailscaleSTSConfig{
+		Replicas:            1,
+		Hostname:            hostnameForGateway(gw),
+		ParentResourceName:  gw.Name,
+		ParentResourceUID:   string(gw.UID),
+		ServeConfig:         sc,
+		Tags:                tags,
+		ChildResourceLabels: crl,
+		ProxyClassName:      proxyClass,
+		proxyType:           proxyTypeGatewayResource,
+		LoginServer:         r.ssr.loginServer,
+	}
+	if _, err := r.ssr.Provision(ctx, logger, sts); err != nil {
+		return fmt.Errorf("failed to provision: %w", err)
+	}
+
+	devices, err := r.ssr.DeviceInfo(ctx, crl, logger)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve Gateway device status: %w", err)
+	}
+	var addrs []GatewayStatusAddress
+	for _, dev := range devices {
+		if dev.ingressDNSName == "" {
+			continue
+		}
+		addrs = append(addrs, hostnameAddress(dev.ingressDNSName))
+		for _, ip := range dev.ips {
+			addrs = append(addrs, ipAddress(ip))
+		}
+	}
+	return r.updateGatewayStatus(ctx, logger, u, gw, addrs, listeners)
+}
+
+// maybeProvisionPG ensures that gw is exposed over tailscale as a Tailscale
+// Service on the ProxyGroup pgName, and that its status and those of its
+// routes are up to date. It reports whether the Tailscale Service changed.
+func (r *GatewayReconciler) maybeProvisionPG(ctx context.Context, logger *zap.SugaredLogger, u *unstructured.Unstructured, gw *Gateway, pgName string) (svcsChanged bool, err error) {
+	hostname := hostnameForGateway(gw)
+	logger = logger.With("ProxyGroup", pgName, "hostname", hostname)
+
+	pg := &tsapi.ProxyGroup{}
+	err = r.Get(ctx, client.ObjectKey{Name: pgName}, pg)
+	if apierrors.IsNotFound(err) {
+		logger.Infof("ProxyGroup does not exist, it may have been deleted. Reconciliation for Gateway will be skipped until the ProxyGroup is found")
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("getting ProxyGroup %q: %w", pgName, err)
+	}
+	tsClient, err := clientFromProxyGroup(ctx, r.Client, pg, r.ha.tsNamespace, r.ha.tsClient)
+	if err != nil {
+		return false, fmt.Errorf("failed to get tailscale client: %w", err)
+	}
+
+	if slices.Contains(gw.Finalizers, FinalizerName) {
+		// The Gateway has moved onto a ProxyGroup.
+		if err := r.maybeCleanup(ctx, logger, u, gw); err != nil {
+			return false, err
+		}
+	}
+	if err := r.validateGatewayForPG(ctx, gw, hostname, pg); err != nil {
+		logger.Infof("invalid Gateway configuration: %v", err)
+		return false, r.setGatewayInvalid(ctx, logger, u, gw, err.Error())
+	}
+	if !slices.Contains(gw.Finalizers, FinalizerNamePG) {
+		logger.Infof("exposing Gateway over tailscale")
+		if err := r.addFinalizer(ctx, u, gw, FinalizerNamePG); err != nil {
+			return false, err
+		}
+	}
+	r.mu.Lock()
+	r.managedGateways.Add(gw.UID)
+	gaugeGatewayResources.Set(int64(r.managedGateways.Len()))
+	r.mu.Unlock()
+
+	serviceName := tailcfg.ServiceName("svc:" + hostname)
+	existingTSSvc, err := tsClient.GetVIPService(ctx, serviceName)
+	if err != nil && !isErrorTailscaleServiceNotFound(err) {
+		return false, fmt.Errorf("error getting Tailscale Service %q: %w", hostname, err)
+	}
+	updatedAnnotations, err := ownerAnnotations(r.ha.operatorID, existingTSSvc)
+	if err != nil {
+		const instr = "To proceed, you can either manually delete the existing Tailscale Service or choose a different hostname with the tailscale.com/hostname annotation"
+		msg := fmt.Sprintf("error ensuring ownership of Tailscale Service %s: %v. %s", hostname, err, instr)
+		logger.Warn(msg)
+		r.recorder.Event(u, corev1.EventTypeWarning, "InvalidTailscaleService", msg)
+		return false, nil
+	}
+
+	dnsName, err := dnsNameForService(ctx, r.Client, serviceName, pg, r.ha.tsNamespace)
+	if err != nil {
+		return false, fmt.Errorf("error determining DNS name for service: %w", err)
+	}
+	if err := r.ha.ensureCertResources(ctx, pg, dnsName, u); err != nil {
+		return false, fmt.Errorf("error ensuring cert resources: %w", err)
+	}
+
+	routes, err := r.attachedRoutes(ctx, gw)
+	if err != nil {
+		return false, err
+	}
+	gcfg, listeners, results := buildGatewayServeConfig(ctx, r.Client, gw, routes, dnsName)
+	if err := r.updateRouteStatuses(ctx, logger, gw, routes, results); err != nil {
+		return false, err
+	}
+
+	cm, cfg, err := r.ha.proxyGroupServeConfig(ctx, pgName)
+	if err != nil {
+		return false, fmt.Errorf("error getting ProxyGroup serve config: %w", err)
+	}
+	if cm == nil {
+		logger.Infof("no ProxyGroup serve config ConfigMap found, unable to update serve config. Ensure that ProxyGroup is healthy.")
+		return false, nil
+	}
+	svcCfg := &ipn.ServiceConfig{TCP: gcfg.TCP, Web: gcfg.Web}
+	if !reflect.DeepEqual(cfg.Services[serviceName], svcCfg) {
+		logger.Infof("Updating serve config")
+		mak.Set(&cfg.Services, serviceName, svcCfg)
+		cfgBytes, err := json.Marshal(cfg)
+		if err != nil {
+			return false, fmt.Errorf("error marshaling serve config: %w", err)
+		}
+		mak.Set(&cm.BinaryData, serveConfigKey, cfgBytes)
+		if err := r.Update(ctx, cm); err != nil {
+			return false, fmt.Errorf("error updating serve config: %w", err)
+		}
+	}
+
+	tags := r.ha.defaultTags
+	if tstr, ok := gw.Annotations[AnnotationTags]; ok {
+		tags = strings.Split(tstr, ",")
+	}
+	var ports []string
+	for _, p := range gcfg.programmedPorts() {
+		ports = append(ports, "tcp:"+strconv.Itoa(int(p)))
+	}
+	tsSvc := &tailscale.VIPService{
+		Name:        serviceName,
+		Tags:        tags,
+		Ports:       ports,
+		Comment:     managedTSServiceComment,
+		Annotations: updatedAnnotations,
+	}
+	if existingTSSvc != nil {
+		tsSvc.Addrs = existingTSSvc.Addrs
+	}
+	if len(ports) > 0 && (existingTSSvc == nil ||
+		!reflect.DeepEqual(tsSvc.Tags, existingTSSvc.Tags) ||
+		!reflect.DeepEqual(tsSvc.Ports, existingTSSvc.Ports) ||
+		!ownersAreSetAndEqual(tsSvc, existingTSSvc)) {
+		logger.Infof("Ensuring Tailscale Service exists and is up to date")
+		if err := tsClient.CreateOrUpdateVIPService(ctx, tsSvc); err != nil {
+			return false, fmt.Errorf("error creating Tailscale Service: %w", err)
+		}
+		svcsChanged = existingTSSvc != nil
+	}
+
+	// Like for Ingresses, a Tailscale Service that needs TLS certs isn't
+	// advertised until they've been issued, unless it's also served
+	// without TLS.
+	mode := serviceAdvertisementHTTPAndHTTPS
+	switch {
+	case len(ports) == 0:
+		mode = serviceAdvertisementOff
+	case gcfg.needsCerts() && !gcfg.hasPlaintext():
+		mode = serviceAdvertisementHTTPS
+	}
+	if err := r.ha.maybeUpdateAdvertiseServicesConfig(ctx, serviceName, mode, pg); err != nil {
+		return false, fmt.Errorf("failed to update tailscaled config: %w", err)
+	}
+
+	count, err := numberPodsAdvertising(ctx, r.Client, r.ha.tsNamespace, pg.Name, serviceName)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if any Pods are configured: %w", err)
+	}
+	var addrs []GatewayStatusAddress
+	if count > 0 {
+		addrs = append(addrs, hostnameAddress(dnsName))
+		for _, ip := range tsSvc.Addrs {
+			addrs = append(addrs, ipAddress(ip))
+		}
+	}
+	return svcsChanged, r.updateGatewayStatus(ctx, logger, u, gw, addrs, listeners)
+}
+
+// validateGatewayForPG validates that gw can be exposed as the Tailscale
+// Service hostname on pg.
+func (r *GatewayReconciler) validateGatewayForPG(ctx context.Context, gw *Gateway, hostname string, pg *tsapi.ProxyGroup) error {
+	if violations := tagViolations(gw); len(violations) > 0 {
+		return fmt.Errorf("Gateway contains invalid tags: %v", strings.Join(violations, ","))
+	}
+	if err := dnsname.ValidLabel(hostname); err != nil {
+		return fmt.Errorf("invalid hostname %q: %w. Ensure that the hostname is a valid DNS label", hostname, err)
+	}
+	if pg.Spec.Type != tsapi.ProxyGroupTypeIngress {
+		return fmt.Errorf("ProxyGroup %q is of type %q but must be of type %q", pg.Name, pg.Spec.Type, tsapi.ProxyGroupTypeIngress)
+	}
+	if !tsoperator.ProxyGroupAvailable(pg) {
+		return fmt.Errorf("ProxyGroup %q is not ready", pg.Name)
+	}
+	// Gateways and Ingresses on a ProxyGroup share the Tailscale Services
+	// named after their hostnames.
+	ingList := &networkingv1.IngressList{}
+	if err := r.List(ctx, ingList); err != nil {
+		return fmt.Errorf("[unexpected] error listing Ingresses: %w", err)
+	}
+	for _, ing := range ingList.Items {
+		if r.ha.shouldExpose(&ing) && hostnameForIngress(&ing) == hostname {
+			return fmt.Errorf("found Ingress %q for hostname %q - a Gateway and an Ingress can't have the same hostname", client.ObjectKeyFromObject(&ing), hostname)
+		}
+	}
+	gwList := newUnstructuredList(gatewayGVK)
+	if err := r.List(ctx, gwList); err != nil {
+		return fmt.Errorf("[unexpected] error listing Gateways: %w", err)
+	}
+	for _, other := range gwList.Items {
+		if other.GetUID() == gw.UID || other.GetAnnotations()[AnnotationProxyGroup] == "" {
+			continue
+		}
+		o := new(Gateway)
+		if err := fromUnstructured(&other, o); err != nil {
+			return err
+		}
+		if hostnameForGateway(o) == hostname {
+			return fmt.Errorf("found duplicate Gateway %s/%s for hostname %q - multiple Gateways on ProxyGroups for the same hostname in the same cluster are not allowed", o.Namespace, o.Name, hostname)
+		}
+	}
+	return nil
+}
+
+// maybeCleanup ensures that any resources created for gw are cleaned up,
+// and its routes no longer report being attached to it, if it's being
+// deleted or is no longer a Tailscale Gateway.
+func (r *GatewayReconciler) maybeCleanup(ctx context.Context, logger *zap.SugaredLogger, u *unstructured.Unstructured, gw *Gateway) error {
+	if slices.Contains(gw.Finalizers, FinalizerNamePG) {
+		if _, err := r.maybeCleanupPG(ctx, logger, u, gw, gw.Annotations[AnnotationProxyGroup]); err != nil {
+			return err
+		}
+	}
+	if !slices.Contains(gw.Finalizers, FinalizerName) {
+		logger.Debugf("no finalizer, nothing to do")
+		r.forgetGateway(gw.UID)
+		return nil
+	}
+	if done, err := r.ssr.Cleanup(ctx, operatorTailnet, logger, childResourceLabels(gw.Name, gw.Namespace, "gateway"), proxyTypeGatewayResource); err != nil {
+		return fmt.Errorf("failed to cleanup: %w", err)
+	} else if !done {
+		logger.Debugf("cleanup not done yet, waiting for next reconcile")
+		return nil
+	}
+	if err := r.detachRoutes(ctx, logger, gw); err != nil {
+		return err
+	}
+	if err := r.removeFinalizer(ctx, u, gw, FinalizerName); err != nil {
+		return err
+	}
+	// Unlike most log entries in the reconcile loop, this will get printed
+	// exactly once at the very end of cleanup, because the final step of
+	// cleanup removes the tailscale finalizer, which will make all future
+	// reconciles exit early.
+	logger.Infof("unexposed Gateway from tailnet")
+	r.forgetGateway(gw.UID)
+	return nil
+}
+
+// maybeCleanupPG ensures that the Tailscale Service for gw on the ProxyGroup
+// pgName, and any resources created for it, are cleaned up. If pgName is
+// empty, or the ProxyGroup no longer exists, only the finalizer is removed.
+// It reports whether the Tailscale Service was updated rather than deleted.
+func (r *GatewayReconciler) maybeCleanupPG(ctx context.Context, logger *zap.SugaredLogger, u *unstructured.Unstructured, gw *Gateway, pgName string) (svcChanged bool, err error) {
+	defer func() {
+		if err != nil {
+			return
+		}
+		if err = r.detachRoutes(ctx, logger, gw); err != nil {
+			return
+		}
+		if err = r.removeFinalizer(ctx, u, gw, FinalizerNamePG); err != nil {
+			return
+		}
+		r.forgetGateway(gw.UID)
+	}()
+	if pgName == "" {
+		// The ProxyGroup that the Gateway was on is unknown. Its Tailscale
+		// Service will be cleaned up by
+		// HAIngressReconciler.maybeCleanupProxyGroup.
+		return false, nil
+	}
+	pg := &tsapi.ProxyGroup{}
+	if err := r.Get(ctx, client.ObjectKey{Name: pgName}, pg); apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("getting ProxyGroup %q: %w", pgName, err)
+	}
+	tsClient, err := clientFromProxyGroup(ctx, r.Client, pg, r.ha.tsNamespace, r.ha.tsClient)
+	if err != nil {
+		return false, fmt.Errorf("failed to get tailscale client: %w", err)
+	}
+
+	hostname := hostnameForGateway(gw)
+	logger.Infof("Ensuring that Tailscale Service %q configuration is cleaned up", hostname)
+	serviceName := tailcfg.ServiceName("svc:" + hostname)
+	svc, err := tsClient.GetVIPService(ctx, serviceName)
+	if err != nil && !isErrorTailscaleServiceNotFound(err) {
+		return false, fmt.Errorf("error getting Tailscale Service: %w", err)
+	}
+	cm, cfg, err := r.ha.proxyGroupServeConfig(ctx, pg.Name)
+	if err != nil {
+		return false, fmt.Errorf("error getting ProxyGroup serve config: %w", err)
+	}
+	// See HAIngressReconciler.maybeCleanup.
+	if cfg != nil && cfg.Services != nil && cfg.Services[serviceName] == nil {
+		return false, nil
+	}
+	svcChanged, err = r.ha.cleanupTailscaleService(ctx, svc, logger, tsClient)
+	if err != nil {
+		return false, fmt.Errorf("error deleting Tailscale Service: %w", err)
+	}
+	if err = cleanupCertResources(ctx, r.Client, r.ha.tsNamespace, serviceName, pg); err != nil {
+		return false, fmt.Errorf("failed to clean up cert resources: %w", err)
+	}
+	if cfg == nil || cfg.Services == nil {
+		return svcChanged, nil
+	}
+	if err = r.ha.maybeUpdateAdvertiseServicesConfig(ctx, serviceName, serviceAdvertisementOff, pg); err != nil {
+		return false, fmt.Errorf("failed to update tailscaled config services: %w", err)
+	}
+	logger.Infof("Removing Tailscale Service %q from serve config for ProxyGroup %q", hostname, pg.Name)
+	delete(cfg.Services, serviceName)
+	cfgBytes, err := json.Marshal(cfg)
+	if err != nil {
+		return false, fmt.Errorf("error marshaling serve config: %w", err)
+	}
+	mak.Set(&cm.BinaryData, serveConfigKey, cfgBytes)
+	return svcChanged, r.Update(ctx, cm)
+}
+
+func (r *GatewayReconciler) forgetGateway(uid types.UID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.managedGateways.Remove(uid)
+	gaugeGatewayResources.Set(int64(r.managedGateways.Len()))
+}
+
+func (r *GatewayReconciler) addFinalizer(ctx context.Context, u *unstructured.Unstructured, gw *Gateway, finalizer string) error {
+	gw.Finalizers = append(gw.Finalizers, finalizer)
+	u.SetFinalizers(gw.Finalizers)
+	if err := r.Update(ctx, u); err != nil {
+		return fmt.Errorf("failed to add finalizer: %w", err)
+	}
+	return nil
+}
+
+func (r *GatewayReconciler) removeFinalizer(ctx context.Context, u *unstructured.Unstructured, gw *Gateway, finalizer string) error {
+	ix := slices.Index(gw.Finalizers, finalizer)
+	if ix < 0 {
+		return nil
+	}
+	gw.Finalizers = slices.Delete(gw.Finalizers, ix, ix+1)
+	u.SetFinalizers(gw.Finalizers)
+	if err := r.Update(ctx, u); err != nil {
+		return fmt.Errorf("failed to remove finalizer %q: %w", finalizer, err)
+	}
+	return nil
+}
+
+// attachedRoutes returns the HTTPRoutes and TLSRoutes with a parent
+// reference to gw, oldest first.
+func (r *GatewayReconciler) attachedRoutes(ctx context.Context, gw *Gateway) ([]*routeObject, error) {
+	var routes []*routeObject
+	httpList := newUnstructuredList(httpRouteGVK)
+	if err := r.List(ctx, httpList); err != nil {
+		return nil, fmt.Errorf("error listing HTTPRoutes: %w", err)
+	}
+	for i := range httpList.Items {
+		route := new(HTTPRoute)
+		if err := fromUnstructured(&httpList.Items[i], route); err != nil {
+			return nil, err
+		}
+		routes = append(routes, &routeObject{u: &httpList.Items[i], parentRefs: route.Spec.ParentRefs, status: &route.Status, http: route})
+	}
+	if r.tlsRoutes {
+		tlsList := newUnstructuredList(tlsRouteGVK)
+		if err := r.List(ctx, tlsList); err != nil {
+			return nil, fmt.Errorf("error listing TLSRoutes: %w", err)
+		}
+		for i := range tlsList.Items {
+			route := new(TLSRoute)
+			if err := fromUnstructured(&tlsList.Items[i], route); err != nil {
+				return nil, err
+			}
+			routes = append(routes, &routeObject{u: &tlsList.Items[i], parentRefs: route.Spec.ParentRefs, status: &route.Status, tls: route})
+		}
+	}
+	routes = slices.DeleteFunc(routes, func(ro *routeObject) bool {
+		return !slices.ContainsFunc(ro.parentRefs, func(ref ParentReference) bool {
+			return parentRefMatches(ref, ro.u.GetNamespace(), gw)
+		})
+	})
+	// Gateway API gives precedence to the oldest route, then to the first
+	// in alphabetical order of namespace and name.
+	slices.SortStableFunc(routes, func(a, b *routeObject) int {
+		if c := a.u.GetCreationTimestamp().Compare(b.u.GetCreationTimestamp().Time); c != 0 {
+			return c
+		}
+		return strings.Compare(a.u.GetNamespace()+"/"+a.u.GetName(), b.u.GetNamespace()+"/"+b.u.GetName())
+	})
+	return routes, nil
+}
+
+// updateRouteStatuses updates the status of each route for its parent
+// references to gw, with the results of translating it.
+func (r *GatewayReconciler) updateRouteStatuses(ctx context.Context, logger *zap.SugaredLogger, gw *Gateway, routes []*routeObject, results map[*routeObject]*routeResult) error {
+	for _, ro := range routes {
+		res := results[ro]
+		accepted, acceptedReason, acceptedMsg := res.acceptedCondition()
+		resolved, resolvedReason, resolvedMsg := metav1.ConditionTrue, gatewayReasonResolvedRefs, ""
+		if res.unresolvedReason != "" {
+			resolved, resolvedReason, resolvedMsg = metav1.ConditionFalse, res.unresolvedReason, res.unresolvedMsg
+		}
+		if err := r.setRouteParentStatus(ctx, logger, ro, gw, func(conds *[]metav1.Condition) {
+			tsoperator.SetCondition(conds, gatewayConditionAccepted, accepted, acceptedReason, acceptedMsg, ro.u.GetGeneration(), r.clock, logger)
+			tsoperator.SetCondition(conds, gatewayConditionResolvedRefs, resolved, resolvedReason, resolvedMsg, ro.u.GetGeneration(), r.clock, logger)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// detachRoutes removes the statuses of routes for their parent references
+// to gw.
+func (r *GatewayReconciler) detachRoutes(ctx context.Context, logger *zap.SugaredLogger, gw *Gateway) error {
+	routes, err := r.attachedRoutes(ctx, gw)
+	if err != nil {
+		return err
+	}
+	for _, ro := range routes {
+		if err := r.setRouteParentStatus(ctx, logger, ro, gw, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setRouteParentStatus updates the status of the route ro for its parent
+// references to gw with setConds, or removes it if setConds is nil.
+func (r *GatewayReconciler) setRouteParentStatus(ctx context.Context, logger *zap.SugaredLogger, ro *routeObject, gw *Gateway, setConds func(*[]metav1.Condition)) error {
+	old := ro.u.DeepCopy()
+	var parents []RouteParentStatus
+	for _, p := range ro.status.Parents {
+		if p.ControllerName == tailscaleGatewayControllerName && parentRefMatches(p.ParentRef, ro.u.GetNamespace(), gw) {
+			continue
+		}
+		parents = append(parents, p)
+	}
+	if setConds != nil {
+		for _, ref := range ro.parentRefs {
+			if !parentRefMatches(ref, ro.u.GetNamespace(), gw) {
+				continue
+			}
+			var conds []metav1.Condition
+			if i := slices.IndexFunc(ro.status.Parents, func(p RouteParentStatus) bool {
+				return p.ControllerName == tailscaleGatewayControllerName && reflect.DeepEqual(p.ParentRef, ref)
+			}); i >= 0 {
+				conds = ro.status.Parents[i].Conditions
+			}
+			setConds(&conds)
+			parents = append(parents, RouteParentStatus{
+				ParentRef:      ref,
+				ControllerName: tailscaleGatewayControllerName,
+				Conditions:     conds,
+			})
+		}
+	}
+	ro.status.Parents = parents
+	if ro.status.Parents == nil {
+		ro.status.Parents = []RouteParentStatus{}
+	}
+	if err := setUnstructuredStatus(ro.u, ro.status); err != nil {
+		return err
+	}
+	if apiequality.Semantic.DeepEqual(old.Object["status"], ro.u.Object["status"]) {
+		return nil
+	}
+	logger.Debugf("updating status of %s %s/%s", ro.u.GetKind(), ro.u.GetNamespace(), ro.u.GetName())
+	if err := r.Status().Update(ctx, ro.u); err != nil {
+		return fmt.Errorf("failed to update %s status: %w", ro.u.GetKind(), err)
+	}
+	return nil
+}
+
+// setGatewayInvalid sets the status of gw to not accepted, with msg.
+func (r *GatewayReconciler) setGatewayInvalid(ctx context.Context, logger *zap.SugaredLogger, u *unstructured.Unstructured, gw *Gateway, msg string) error {
+	r.recorder.Event(u, corev1.EventTypeWarning, "InvalidGatewayConfiguration", msg)
+	old := u.DeepCopy()
+	tsoperator.SetCondition(&gw.Status.Conditions, gatewayConditionAccepted, metav1.ConditionFalse, gatewayReasonInvalid, msg, gw.Generation, r.clock, logger)
+	tsoperator.SetCondition(&gw.Status.Conditions, gatewayConditionProgrammed, metav1.ConditionFalse, gatewayReasonInvalid, msg, gw.Generation, r.clock, logger)
+	return r.writeGatewayStatus(ctx, old, u, gw)
+}
+
+// updateGatewayStatus sets the status of gw to accepted, with the given
+// addresses and listener statuses.
+func (r *GatewayReconciler) updateGatewayStatus(ctx context.Context, logger *zap.SugaredLogger, u *unstructured.Unstructured, gw *Gateway, addrs []GatewayStatusAddress, listeners []ListenerStatus) error {
+	old := u.DeepCopy()
+	tsoperator.SetCondition(&gw.Status.Conditions, gatewayConditionAccepted, metav1.ConditionTrue, gatewayReasonAccepted, "", gw.Generation, r.clock, logger)
+	if len(addrs) > 0 {
+		tsoperator.SetCondition(&gw.Status.Conditions, gatewayConditionProgrammed, metav1.ConditionTrue, gatewayReasonProgrammed, "", gw.Generation, r.clock, logger)
+	} else {
+		tsoperator.SetCondition(&gw.Status.Conditions, gatewayConditionProgrammed, metav1.ConditionFalse, gatewayReasonPending, "waiting for the Gateway to be served on the tailnet", gw.Generation, r.clock, logger)
+	}
+	gw.Status.Addresses = addrs
+	// Keep the transition times of conditions that didn't change.
+	for i, st := range listeners {
+		var conds []metav1.Condition
+		if j := slices.IndexFunc(gw.Status.Listeners, func(old ListenerStatus) bool { return old.Name == st.Name }); j >= 0 {
+			conds = gw.Status.Listeners[j].Conditions
+		}
+		for _, c := range st.Conditions {
+			tsoperator.SetCondition(&conds, c.Type, c.Status, c.Reason, c.Message, c.ObservedGeneration, r.clock, logger)
+		}
+		listeners[i].Conditions = conds
+	}
+	gw.Status.Listeners = listeners
+	return r.writeGatewayStatus(ctx, old, u, gw)
+}
+
+// writeGatewayStatus writes the status of gw, if it has changed from that of
+// old.
+func (r *GatewayReconciler) writeGatewayStatus(ctx context.Context, old, u *unstructured.Unstructured, gw *Gateway) error {
+	if err := setUnstructuredStatus(u, &gw.Status); err != nil {
+		return err
+	}
+	if apiequality.Semantic.DeepEqual(old.Object["status"], u.Object["status"]) {
+		return nil
+	}
+	if err := r.Status().Update(ctx, u); err != nil {
+		return fmt.Errorf("failed to update Gateway status: %w", err)
+	}
+	return nil
+}
+
+// hostnameForGateway returns the tailnet hostname for gw: that of its
+// tailscale.com/hostname annotation, else the first label of the hostname of
+// its first listener with one, else one derived from its name and namespace.
+func hostnameForGateway(gw *Gateway) string {
+	if h := gw.Annotations[AnnotationHostname]; h != "" {
+		return h
+	}
+	for _, l := range gw.Spec.Listeners {
+		if l.Hostname != nil && *l.Hostname != "" && !strings.HasPrefix(*l.Hostname, "*") {
+			hostname, _, _ := strings.Cut(*l.Hostname, ".")
+			return hostname
+		}
+	}
+	return gw.Namespace + "-" + gw.Name + "-gateway"
+}
+
+// proxyGroupGatewayHostnames returns the hostnames of the Gateways on the
+// ProxyGroup pgName, which are the names of their Tailscale Services. If the
+// Gateway API CRDs aren't installed, there are none.
+func proxyGroupGatewayHostnames(ctx context.Context, cl client.Client, pgName string) (set.Set[string], error) {
+	gwList := newUnstructuredList(gatewayGVK)
+	if err := cl.List(ctx, gwList); meta.IsNoMatchError(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("listing Gateways: %w", err)
+	}
+	hostnames := set.Set[string]{}
+	for i := range gwList.Items {
+		if gwList.Items[i].GetAnnotations()[AnnotationProxyGroup] != pgName {
+			continue
+		}
+		gw := new(Gateway)
+		if err := fromUnstructured(&gwList.Items[i], gw); err != nil {
+			return nil, err
+		}
+		hostnames.Add(hostnameForGateway(gw))
+	}
+	return hostnames, nil
+}
+
+func hostnameAddress(hostname string) GatewayStatusAddress {
+	typ := "Hostname"
+	return GatewayStatusAddress{Type: &typ, Value: hostname}
+}
+
+func ipAddress(ip string) GatewayStatusAddress {
+	typ := "IPAddress"
+	return GatewayStatusAddress{Type: &typ, Value: ip}
+}
+
+// portOfHostPort returns the port of hp, or 0 if it has none.
+func portOfHostPort(hp ipn.HostPort) uint16 {
+	_, port, _ := strings.Cut(string(hp), ":")
+	p, _ := strconv.ParseUint(port, 10, 16)
+	return uint16(p)
+}
+
+// gatewaysForGatewayClass returns a handler that returns reconcile requests
+// for all Gateways of a GatewayClass.
+func gatewaysForGatewayClass(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		return gatewayRequests(ctx, cl, logger, func(gw *unstructured.Unstructured) bool {
+			class, _, _ := unstructured.NestedString(gw.Object, "spec", "gatewayClassName")
+			return class == o.GetName()
+		})
+	}
+}
+
+// gatewaysForProxyGroup returns a handler that returns reconcile requests
+// for all Gateways on an ingress ProxyGroup.
+func gatewaysForProxyGroup(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		pg, ok := o.(*tsapi.ProxyGroup)
+		if !ok || pg.Spec.Type != tsapi.ProxyGroupTypeIngress {
+			return nil
+		}
+		return gatewayRequests(ctx, cl, logger, func(gw *unstructured.Unstructured) bool {
+			return gw.GetAnnotations()[AnnotationProxyGroup] == pg.Name
+		})
+	}
+}
+
+// gatewaysFromSecret returns a handler that returns reconcile requests for
+// the Gateways that should be reconciled in response to a Secret event: for
+// a proxy or TLS Secret of a Gateway, that Gateway, and for a ProxyGroup
+// state Secret, the Gateways on the ProxyGroup.
+func gatewaysFromSecret(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		secret, ok := o.(*corev1.Secret)
+		if !ok {
+			logger.Infof("[unexpected] Secret handler triggered for an object that is not a Secret")
+			return nil
+		}
+		if isManagedByType(secret, "gateway") {
+			return []reconcile.Request{{NamespacedName: parentFromObjectLabels(secret)}}
+		}
+		if !isPGStateSecret(secret) {
+			return nil
+		}
+		pgName := secret.Labels[LabelParentName]
+		return gatewayRequests(ctx, cl, logger, func(gw *unstructured.Unstructured) bool {
+			return gw.GetAnnotations()[AnnotationProxyGroup] == pgName
+		})
+	}
+}
+
+// gatewaysForRoute returns a handler that returns reconcile requests for the
+// Gateways that an HTTPRoute or TLSRoute refers to.
+func gatewaysForRoute(logger *zap.SugaredLogger) handler.MapFunc {
+	return func(_ context.Context, o client.Object) []reconcile.Request {
+		u, ok := o.(*unstructured.Unstructured)
+		if !ok {
+			logger.Infof("[unexpected] route handler triggered for an object that is not Unstructured")
+			return nil
+		}
+		return gatewayRequestsForRoute(u)
+	}
+}
+
+// gatewaysForService returns a handler that returns reconcile requests for
+// the Gateways of the routes that may have a Service as a backend.
+func gatewaysForService(cl client.Client, logger *zap.SugaredLogger, tlsRoutes bool) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		gvks := []*unstructured.UnstructuredList{newUnstructuredList(httpRouteGVK)}
+		if tlsRoutes {
+			gvks = append(gvks, newUnstructuredList(tlsRouteGVK))
+		}
+		var reqs []reconcile.Request
+		for _, list := range gvks {
+			if err := cl.List(ctx, list, client.InNamespace(o.GetNamespace())); err != nil {
+				logger.Debugf("error listing %s: %v", list.GetKind(), err)
+				return nil
+			}
+			for i := range list.Items {
+				if routeHasBackend(&list.Items[i], o.GetName()) {
+					reqs = append(reqs, gatewayRequestsForRoute(&list.Items[i])...)
+				}
+			}
+		}
+		return reqs
+	}
+}
+
+// routeHasBackend reports whether the route u has a backend reference with
+// the given name.
+func routeHasBackend(u *unstructured.Unstructured, name string) bool {
+	rules, _, _ := unstructured.NestedSlice(u.Object, "spec", "rules")
+	for _, rule := range rules {
+		rule, ok := rule.(map[string]any)
+		if !ok {
+			continue
+		}
+		refs, _, _ := unstructured.NestedSlice(rule, "backendRefs")
+		for _, ref := range refs {
+			if ref, ok := ref.(map[string]any); ok && ref["name"] == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gatewayRequestsForRoute returns reconcile requests for the Gateways that
+// the route u refers to.
+func gatewayRequestsForRoute(u *unstructured.Unstructured) []reconcile.Request {
+	refs, _, _ := unstructured.NestedSlice(u.Object, "spec", "parentRefs")
+	var reqs []reconcile.Request
+	for _, ref := range refs {
+		m, ok := ref.(map[string]any)
+		if !ok {
+			continue
+		}
+		if kind, ok := m["kind"].(string); ok && kind != "Gateway" {
+			continue
+		}
+		name, _ := m["name"].(string)
+		ns, _ := m["namespace"].(string)
+		if ns == "" {
+			ns = u.GetNamespace()
+		}
+		reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: ns, Name: name}})
+	}
+	return reqs
+}
+
+// gatewayRequests returns reconcile requests for the Gateways for which
+// include returns true.
+func gatewayRequests(ctx context.Context, cl client.Client, logger *zap.SugaredLogger, include func(*unstructured.Unstructured) bool) []reconcile.Request {
+	gwList := newUnstructuredList(gatewayGVK)
+	if err := cl.List(ctx, gwList); err != nil {
+		logger.Debugf("error listing Gateways: %v", err)
+		return nil
+	}
+	var reqs []reconcile.Request
+	for i := range gwList.Items {
+		if include(&gwList.Items[i]) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&gwList.Items[i])})
+		}
+	}
+	return reqs
+}
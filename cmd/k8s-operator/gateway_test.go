@@ -0,0 +1,298 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"tailscale.com/ipn"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/types/ptr"
+)
+
+func TestHandlersForHTTPRouteEntries(t *testing.T) {
+	a := &ipn.HTTPHandler{Proxy: "http://a"}
+	b := &ipn.HTTPHandler{Proxy: "http://b"}
+	c := &ipn.HTTPHandler{Proxy: "http://c"}
+	d := &ipn.HTTPHandler{Proxy: "http://d"}
+	canary := map[string]string{"X-Canary": "1"}
+
+	tests := []struct {
+		name    string
+		entries []httpRouteEntry
+		want    map[string]*ipn.HTTPHandler
+	}{
+		{
+			name: "oldest_default_wins",
+			entries: []httpRouteEntry{
+				{path: "/", handler: a},
+				{path: "/", handler: b},
+			},
+			want: map[string]*ipn.HTTPHandler{
+				"/": {Proxy: "http://a"},
+			},
+		},
+		{
+			name: "prefix_trailing_slash",
+			entries: []httpRouteEntry{
+				{path: "/", handler: a},
+				{path: "/api/", handler: b},
+			},
+			want: map[string]*ipn.HTTPHandler{
+				"/":    {Proxy: "http://a"},
+				"/api": {Proxy: "http://b"},
+			},
+		},
+		{
+			name: "headers_before_default",
+			entries: []httpRouteEntry{
+				{path: "/", handler: a},
+				{path: "/", headers: canary, handler: b},
+			},
+			want: map[string]*ipn.HTTPHandler{
+				"/": {
+					Proxy:  "http://a",
+					Routes: []*ipn.HTTPRoute{{Headers: canary, Handler: b}},
+				},
+			},
+		},
+		{
+			name: "exact_first_and_fallback",
+			entries: []httpRouteEntry{
+				{path: "/", handler: a},
+				{path: "/api", headers: canary, handler: b},
+				{path: "/api/v1", exact: true, handler: c},
+			},
+			want: map[string]*ipn.HTTPHandler{
+				"/": {Proxy: "http://a"},
+				"/api": {
+					Proxy:  "http://a",
+					Routes: []*ipn.HTTPRoute{{Headers: canary, Handler: b}},
+				},
+				"/api/v1": {
+					Proxy: "http://a",
+					Routes: []*ipn.HTTPRoute{
+						{Path: "/api/v1", Handler: c},
+						{Headers: canary, Handler: b},
+					},
+				},
+			},
+		},
+		{
+			name: "more_headers_first",
+			entries: []httpRouteEntry{
+				{path: "/", headers: canary, handler: b},
+				{path: "/", headers: map[string]string{"X-Canary": "1", "X-User": "x"}, handler: c},
+				{path: "/", exact: true, handler: d},
+			},
+			want: map[string]*ipn.HTTPHandler{
+				"/": {
+					Routes: []*ipn.HTTPRoute{
+						{Path: "/", Handler: d},
+						{Headers: map[string]string{"X-Canary": "1", "X-User": "x"}, Handler: c},
+						{Headers: canary, Handler: b},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := handlersForHTTPRouteEntries(tt.entries)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected handlers (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBuildGatewayServeConfig(t *testing.T) {
+	svc := func(name, ip string, port int32, portName string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: corev1.ServiceSpec{
+				ClusterIP: ip,
+				Ports:     []corev1.ServicePort{{Name: portName, Port: port}},
+			},
+		}
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(
+			svc("web", "10.0.0.1", 8080, "http"),
+			svc("canary", "10.0.0.2", 8080, "http"),
+			svc("db", "10.0.0.3", 443, "https"),
+		).
+		Build()
+
+	gw := &Gateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "gw", Namespace: "default", Generation: 2},
+		Spec: GatewaySpec{
+			GatewayClassName: "tailscale",
+			Listeners: []Listener{
+				{Name: "http", Port: 80, Protocol: gatewayProtocolHTTP},
+				{Name: "https", Port: 443, Protocol: gatewayProtocolHTTPS},
+				{Name: "tls", Port: 5432, Protocol: gatewayProtocolTLS},
+				{Name: "dup", Port: 80, Protocol: gatewayProtocolHTTP},
+				{Name: "udp", Port: 53, Protocol: "UDP"},
+			},
+		},
+	}
+	httpRoute := &HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: HTTPRouteSpec{
+			ParentRefs: []ParentReference{{Name: "gw"}},
+			Rules: []HTTPRouteRule{
+				{
+					BackendRefs: []BackendRef{
+						{Name: "web", Port: ptr.To[int32](8080), Weight: ptr.To[int32](3)},
+						{Name: "canary", Port: ptr.To[int32](8080), Weight: ptr.To[int32](1)},
+					},
+					Filters: []HTTPRouteFilter{
+						{Type: httpRouteFilterRequestHeaderModifier, RequestHeaderModifier: &HTTPHeaderFilter{
+							Set:    []HTTPHeader{{Name: "X-Foo", Value: "bar"}},
+							Remove: []string{"X-Secret"},
+						}},
+						{Type: "URLRewrite"},
+					},
+				},
+				{
+					Matches: []HTTPRouteMatch{{
+						Path:    &HTTPPathMatch{Type: ptr.To(pathMatchPathPrefix), Value: ptr.To("/missing")},
+						Headers: []HTTPHeaderMatch{{Name: "X-Canary", Value: "1"}},
+					}},
+					BackendRefs: []BackendRef{{Name: "missing", Port: ptr.To[int32](80)}},
+				},
+			},
+		},
+	}
+	tlsRoute := &TLSRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Spec: TLSRouteSpec{
+			ParentRefs: []ParentReference{{Name: "gw", SectionName: ptr.To("tls")}},
+			Rules:      []TLSRouteRule{{BackendRefs: []BackendRef{{Name: "db", Port: ptr.To[int32](443)}}}},
+		},
+	}
+	shadowedRoute := &TLSRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "db2", Namespace: "default"},
+		Spec: TLSRouteSpec{
+			ParentRefs: []ParentReference{{Name: "gw", SectionName: ptr.To("tls")}},
+			Hostnames:  []string{"db2.example.com"},
+			Rules:      []TLSRouteRule{{BackendRefs: []BackendRef{{Name: "web", Port: ptr.To[int32](8080)}}}},
+		},
+	}
+	otherRoute := &HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "other"},
+		Spec: HTTPRouteSpec{
+			ParentRefs: []ParentReference{{Name: "gw", Namespace: ptr.To("default")}},
+		},
+	}
+	routeObj := func(name, ns string, http *HTTPRoute, tls *TLSRoute) *routeObject {
+		ro := &routeObject{http: http, tls: tls}
+		if http != nil {
+			ro.u = newUnstructured(httpRouteGVK)
+			ro.parentRefs = http.Spec.ParentRefs
+		} else {
+			ro.u = newUnstructured(tlsRouteGVK)
+			ro.parentRefs = tls.Spec.ParentRefs
+		}
+		ro.u.SetName(name)
+		ro.u.SetNamespace(ns)
+		return ro
+	}
+	httpRO := routeObj("web", "default", httpRoute, nil)
+	tlsRO := routeObj("db", "default", nil, tlsRoute)
+	shadowedRO := routeObj("db2", "default", nil, shadowedRoute)
+	otherRO := routeObj("other", "other", otherRoute, nil)
+
+	const host = "gw.tailnet.ts.net"
+	cfg, listeners, results := buildGatewayServeConfig(context.Background(), fc, gw, []*routeObject{httpRO, tlsRO, shadowedRO, otherRO}, host)
+
+	webHandler := &ipn.HTTPHandler{
+		StripPrefix:          "/",
+		Proxy:                "http://10.0.0.1:8080",
+		Upstreams:            []string{"http://10.0.0.2:8080"},
+		Weights:              []int{3, 1},
+		SetRequestHeaders:    map[string]string{"X-Foo": "bar"},
+		RemoveRequestHeaders: []string{"X-Secret"},
+	}
+	handlers := map[string]*ipn.HTTPHandler{
+		"/": webHandler,
+		"/missing": {
+			StripPrefix:          "/",
+			Proxy:                "http://10.0.0.1:8080",
+			Upstreams:            []string{"http://10.0.0.2:8080"},
+			Weights:              []int{3, 1},
+			SetRequestHeaders:    map[string]string{"X-Foo": "bar"},
+			RemoveRequestHeaders: []string{"X-Secret"},
+			Routes: []*ipn.HTTPRoute{{
+				Headers: map[string]string{"X-Canary": "1"},
+				Handler: &ipn.HTTPHandler{StripPrefix: "/"},
+			}},
+		},
+	}
+	wantCfg := &gatewayServeConfig{
+		TCP: map[uint16]*ipn.TCPPortHandler{
+			80:   {HTTP: true},
+			443:  {HTTPS: true},
+			5432: {TCPForward: "10.0.0.3:443", TerminateTLS: host},
+		},
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			host + ":80":  {Handlers: handlers},
+			host + ":443": {Handlers: handlers},
+		},
+	}
+	if diff := cmp.Diff(wantCfg, cfg); diff != "" {
+		t.Errorf("unexpected serve config (-want +got):\n%s", diff)
+	}
+	if got, want := cfg.programmedPorts(), []uint16{80, 443, 5432}; !cmp.Equal(got, want) {
+		t.Errorf("programmedPorts() = %v, want %v", got, want)
+	}
+	if !cfg.needsCerts() || !cfg.hasPlaintext() {
+		t.Errorf("needsCerts() = %v, hasPlaintext() = %v, want true, true", cfg.needsCerts(), cfg.hasPlaintext())
+	}
+
+	wantAttached := map[string]int32{"http": 1, "https": 1, "tls": 2, "dup": 0, "udp": 0}
+	wantAccepted := map[string]metav1.ConditionStatus{"http": "True", "https": "True", "tls": "True", "dup": "False", "udp": "False"}
+	if len(listeners) != len(gw.Spec.Listeners) {
+		t.Fatalf("got %d listener statuses, want %d", len(listeners), len(gw.Spec.Listeners))
+	}
+	for _, st := range listeners {
+		if st.AttachedRoutes != wantAttached[st.Name] {
+			t.Errorf("listener %q: attachedRoutes = %d, want %d", st.Name, st.AttachedRoutes, wantAttached[st.Name])
+		}
+		if got := st.Conditions[0]; got.Type != gatewayConditionAccepted || got.Status != wantAccepted[st.Name] {
+			t.Errorf("listener %q: got condition %s=%s, want Accepted=%s", st.Name, got.Type, got.Status, wantAccepted[st.Name])
+		}
+	}
+
+	if res := results[httpRO]; !res.attached ||
+		res.unresolvedReason != gatewayReasonBackendNotFound ||
+		!cmp.Equal(res.unsupported, []string{`filter "URLRewrite"`}) {
+		t.Errorf("unexpected HTTPRoute result %+v", res)
+	}
+	if res := results[tlsRO]; !res.attached || res.unresolvedReason != "" || !res.forwarded {
+		t.Errorf("unexpected TLSRoute result %+v", res)
+	}
+	if status, _, _ := results[tlsRO].acceptedCondition(); status != metav1.ConditionTrue {
+		t.Errorf("forwarded TLSRoute: Accepted = %s, want True", status)
+	}
+	if res := results[shadowedRO]; !res.attached || res.forwarded || res.shadowedBy != "default/db" {
+		t.Errorf("unexpected shadowed TLSRoute result %+v", res)
+	}
+	if status, reason, msg := results[shadowedRO].acceptedCondition(); status != metav1.ConditionFalse || reason != gatewayReasonUnsupportedValue || !strings.Contains(msg, "default/db") {
+		t.Errorf("shadowed TLSRoute: got Accepted=%s, %s, %q; want False, %s, mentioning default/db", status, reason, msg, gatewayReasonUnsupportedValue)
+	}
+	if res := results[otherRO]; res.attached {
+		t.Errorf("route from other namespace attached, want not attached")
+	}
+}
@@ -455,9 +455,18 @@ func (r *HAIngressReconciler) maybeCleanupProxyGroup(ctx context.Context, logger
 	if err := r.List(ctx, ingList); err != nil {
 		return false, fmt.Errorf("listing Ingresses: %w", err)
 	}
+	// Tailscale Services for Gateways on the ProxyGroup are cleaned up by
+	// GatewayReconciler.
+	gwHostnames, err := proxyGroupGatewayHostnames(ctx, r.Client, pg.Name)
+	if err != nil {
+		return false, err
+	}
 	serveConfigChanged := false
 	// For each Tailscale Service in serve config...
 	for tsSvcName := range cfg.Services {
+		if gwHostnames.Contains(tsSvcName.WithoutPrefix()) {
+			continue
+		}
 		// ...check if there is currently an Ingress with this hostname
 		found := false
 		for _, i := range ingList.Items {
@@ -930,15 +939,15 @@ func ownersAreSetAndEqual(a, b *tailscale.VIPService) bool {
 		strings.EqualFold(a.Annotations[ownerAnnotation], b.Annotations[ownerAnnotation])
 }
 
-// ensureCertResources ensures that the TLS Secret for an HA Ingress and RBAC
+// ensureCertResources ensures that the TLS Secret for an HA Ingress or Gateway and RBAC
 // resources that allow proxies to manage the Secret are created.
 // Note that Tailscale Service's name validation matches Kubernetes
 // resource name validation, so we can be certain that the Tailscale Service name
 // (domain) is a valid Kubernetes resource name.
 // https://github.com/tailscale/tailscale/blob/8b1e7f646ee4730ad06c9b70c13e7861b964949b/util/dnsname/dnsname.go#L99
 // https://kubernetes.io/docs/concepts/overview/working-with-objects/names/#dns-subdomain-names
-func (r *HAIngressReconciler) ensureCertResources(ctx context.Context, pg *tsapi.ProxyGroup, domain string, ing *networkingv1.Ingress) error {
-	secret := certSecret(pg.Name, r.tsNamespace, domain, ing)
+func (r *HAIngressReconciler) ensureCertResources(ctx context.Context, pg *tsapi.ProxyGroup, domain string, parent client.Object) error {
+	secret := certSecret(pg.Name, r.tsNamespace, domain, parent)
 	if _, err := createOrUpdate(ctx, r.Client, r.tsNamespace, secret, func(s *corev1.Secret) {
 		// Labels might have changed if the Ingress has been updated to use a
 		// different ProxyGroup.
@@ -240,7 +240,7 @@ func metricsResourceLabels(opts *metricsOpts) map[string]string {
 // promJobName constructs the value of the Prometheus job label that will apply to all metrics for a ServiceMonitor.
 func promJobName(opts *metricsOpts) string {
 	// Include parent resource namespace for proxies created for namespaced types.
-	if opts.proxyType == proxyTypeIngressResource || opts.proxyType == proxyTypeIngressService {
+	if isNamespacedProxyType(opts.proxyType) {
 		return fmt.Sprintf("ts_%s_%s_%s", opts.proxyType, opts.proxyLabels[LabelParentNamespace], opts.proxyLabels[LabelParentName])
 	}
 	return fmt.Sprintf("ts_%s_%s", opts.proxyType, opts.proxyLabels[LabelParentName])
@@ -282,7 +282,7 @@ type metricsOpts struct {
 }
 
 func isNamespacedProxyType(typ string) bool {
-	return typ == proxyTypeIngressResource || typ == proxyTypeIngressService
+	return typ == proxyTypeIngressResource || typ == proxyTypeIngressService || typ == proxyTypeGatewayResource
 }
 
 func mergeMapKeys(a, b map[string]string) map[string]string {
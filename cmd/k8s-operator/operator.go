@@ -427,6 +427,17 @@ func runReconcilers(opts reconcilerOpts) {
 		startlog.Fatalf("error determining stable ID of the operator's Tailscale device: %v", err)
 	}
 	ingressProxyGroupFilter := handler.EnqueueRequestsFromMapFunc(ingressesFromIngressProxyGroup(mgr.GetClient(), opts.log))
+	haIngressReconciler := &HAIngressReconciler{
+		recorder:         eventRecorder,
+		tsClient:         opts.tsClient,
+		tsnetServer:      opts.tsServer,
+		defaultTags:      strings.Split(opts.proxyTags, ","),
+		Client:           mgr.GetClient(),
+		logger:           opts.log.Named("ingress-pg-reconciler"),
+		operatorID:       id,
+		tsNamespace:      opts.tailscaleNamespace,
+		ingressClassName: opts.ingressClassName,
+	}
 	err = builder.
 		ControllerManagedBy(mgr).
 		For(&networkingv1.Ingress{}).
@@ -434,17 +445,7 @@ func runReconcilers(opts reconcilerOpts) {
 		Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(serviceHandlerForIngressPG(mgr.GetClient(), startlog, opts.ingressClassName))).
 		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(HAIngressesFromSecret(mgr.GetClient(), startlog))).
 		Watches(&tsapi.ProxyGroup{}, ingressProxyGroupFilter).
-		Complete(&HAIngressReconciler{
-			recorder:         eventRecorder,
-			tsClient:         opts.tsClient,
-			tsnetServer:      opts.tsServer,
-			defaultTags:      strings.Split(opts.proxyTags, ","),
-			Client:           mgr.GetClient(),
-			logger:           opts.log.Named("ingress-pg-reconciler"),
-			operatorID:       id,
-			tsNamespace:      opts.tailscaleNamespace,
-			ingressClassName: opts.ingressClassName,
-		})
+		Complete(haIngressReconciler)
 	if err != nil {
 		startlog.Fatalf("could not create ingress-pg-reconciler: %v", err)
 	}
@@ -452,6 +453,56 @@ func runReconcilers(opts reconcilerOpts) {
 		startlog.Fatalf("failed setting up indexer for HA Ingresses: %v", err)
 	}
 
+	// Gateway API support is only enabled if the Gateway API CRDs are
+	// installed.
+	if _, err := mgr.GetRESTMapper().RESTMapping(gatewayGVK.GroupKind(), gatewayGVK.Version); err != nil {
+		startlog.Infof("Gateway API CRDs not found, not reconciling Gateways: %v", err)
+	} else {
+		err = builder.
+			ControllerManagedBy(mgr).
+			For(newUnstructured(gatewayClassGVK)).
+			Named("gatewayclass-reconciler").
+			Complete(&GatewayClassReconciler{
+				Client: mgr.GetClient(),
+				logger: opts.log.Named("gatewayclass-reconciler"),
+				clock:  tstime.DefaultClock{},
+			})
+		if err != nil {
+			startlog.Fatalf("could not create gatewayclass reconciler: %v", err)
+		}
+
+		_, err = mgr.GetRESTMapper().RESTMapping(tlsRouteGVK.GroupKind(), tlsRouteGVK.Version)
+		tlsRoutes := err == nil
+		gatewayChildFilter := handler.EnqueueRequestsFromMapFunc(managedResourceHandlerForType("gateway"))
+		routeFilter := handler.EnqueueRequestsFromMapFunc(gatewaysForRoute(startlog))
+		gwBuilder := builder.
+			ControllerManagedBy(mgr).
+			For(newUnstructured(gatewayGVK)).
+			Named("gateway-reconciler").
+			Watches(newUnstructured(gatewayClassGVK), handler.EnqueueRequestsFromMapFunc(gatewaysForGatewayClass(mgr.GetClient(), startlog))).
+			Watches(newUnstructured(httpRouteGVK), routeFilter).
+			Watches(&appsv1.StatefulSet{}, gatewayChildFilter).
+			Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(gatewaysFromSecret(mgr.GetClient(), startlog))).
+			Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(gatewaysForService(mgr.GetClient(), startlog, tlsRoutes))).
+			Watches(&tsapi.ProxyGroup{}, handler.EnqueueRequestsFromMapFunc(gatewaysForProxyGroup(mgr.GetClient(), startlog)))
+		if tlsRoutes {
+			gwBuilder = gwBuilder.Watches(newUnstructured(tlsRouteGVK), routeFilter)
+		}
+		err = gwBuilder.Complete(&GatewayReconciler{
+			Client:            mgr.GetClient(),
+			recorder:          eventRecorder,
+			ssr:               ssr,
+			logger:            opts.log.Named("gateway-reconciler"),
+			clock:             tstime.DefaultClock{},
+			ha:                haIngressReconciler,
+			tlsRoutes:         tlsRoutes,
+			defaultProxyClass: opts.defaultProxyClass,
+		})
+		if err != nil {
+			startlog.Fatalf("could not create gateway reconciler: %v", err)
+		}
+	}
+
 	ingressSvcFromEpsFilter := handler.EnqueueRequestsFromMapFunc(ingressSvcFromEps(mgr.GetClient(), opts.log.Named("service-pg-reconciler")))
 	err = builder.
 		ControllerManagedBy(mgr).
@@ -2112,3 +2112,93 @@ func proxyCreatedCondition(clock tstime.Clock) []metav1.Condition {
 func conditionTime(clock tstime.Clock) metav1.Time {
 	return metav1.NewTime(clock.Now().Truncate(time.Second))
 }
+
+func TestLBPortStatuses(t *testing.T) {
+	svc := &corev1.Service{
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Name: "dns-udp", Port: 53, Protocol: corev1.ProtocolUDP},
+				{Name: "dns-tcp", Port: 53, Protocol: corev1.ProtocolTCP},
+				{Name: "http", Port: 80},
+			},
+		},
+	}
+	want := []corev1.PortStatus{
+		{Port: 53, Protocol: corev1.ProtocolUDP},
+		{Port: 53, Protocol: corev1.ProtocolTCP},
+		{Port: 80, Protocol: corev1.ProtocolTCP},
+	}
+	if diff := cmp.Diff(want, lbPortStatuses(svc)); diff != "" {
+		t.Errorf("unexpected port statuses (-want +got):\n%s", diff)
+	}
+	if got := lbPortStatuses(&corev1.Service{}); got != nil {
+		t.Errorf("got %v for Service without ports, want nil", got)
+	}
+}
+
+func TestProxyBackendHealth(t *testing.T) {
+	fc := fake.NewFakeClient()
+	ft := &fakeTSClient{}
+	zl := zap.Must(zap.NewDevelopment())
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	sr := &ServiceReconciler{
+		Client: fc,
+		ssr: &tailscaleSTSReconciler{
+			Client:            fc,
+			tsClient:          ft,
+			defaultTags:       []string{"tag:k8s"},
+			operatorNamespace: "operator-ns",
+			proxyImage:        "tailscale/tailscale",
+		},
+		logger:   zl.Sugar(),
+		clock:    clock,
+		recorder: record.NewFakeRecorder(100),
+	}
+
+	mustCreate(t, fc, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+			UID:       types.UID("1234-UID"),
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:         "10.20.30.40",
+			Type:              corev1.ServiceTypeLoadBalancer,
+			LoadBalancerClass: new("tailscale"),
+		},
+	})
+	expectReconciled(t, sr, "default", "test")
+
+	fullName, _ := findGenName(t, fc, "default", "test", "svc")
+	mustUpdate(t, fc, "operator-ns", fullName, func(s *corev1.Secret) {
+		mak.Set(&s.Data, kubetypes.KeyDeviceID, []byte("ts-id-1234"))
+		mak.Set(&s.Data, kubetypes.KeyDeviceFQDN, []byte("tailscale.device.name."))
+		mak.Set(&s.Data, kubetypes.KeyDeviceIPs, []byte(`["100.99.98.97"]`))
+		mak.Set(&s.Data, kubetypes.KeyBackendHealth, []byte("dial tcp 10.20.30.40:80: connect: connection refused"))
+	})
+
+	wantCondition := func(status metav1.ConditionStatus, reason, msg string) {
+		t.Helper()
+		expectReconciled(t, sr, "default", "test")
+		svc := &corev1.Service{}
+		if err := fc.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "test"}, svc); err != nil {
+			t.Fatal(err)
+		}
+		if len(svc.Status.Conditions) != 1 {
+			t.Fatalf("got conditions %v, want exactly one", svc.Status.Conditions)
+		}
+		got := svc.Status.Conditions[0]
+		if got.Status != status || got.Reason != reason || got.Message != msg {
+			t.Errorf("got condition %s/%s %q, want %s/%s %q", got.Status, got.Reason, got.Message, status, reason, msg)
+		}
+		if len(svc.Status.LoadBalancer.Ingress) == 0 {
+			t.Errorf("LoadBalancer status not set")
+		}
+	}
+	wantCondition(metav1.ConditionFalse, reasonProxyBackendUnhealthy, "proxy backend is unhealthy: dial tcp 10.20.30.40:80: connect: connection refused")
+
+	mustUpdate(t, fc, "operator-ns", fullName, func(s *corev1.Secret) {
+		s.Data[kubetypes.KeyBackendHealth] = []byte(kubetypes.ValueBackendHealthy)
+	})
+	wantCondition(metav1.ConditionTrue, reasonProxyCreated, reasonProxyCreated)
+}
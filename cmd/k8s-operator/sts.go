@@ -98,6 +98,7 @@
 	proxyTypeEgress          = "egress_service"
 	proxyTypeIngressService  = "ingress_service"
 	proxyTypeIngressResource = "ingress_resource"
+	proxyTypeGatewayResource = "gateway_resource"
 	proxyTypeConnector       = "connector"
 	proxyTypeProxyGroup      = "proxygroup"
 
@@ -593,6 +594,10 @@ type device struct {
 	// when the device has been configured to serve traffic on it via 'tailscale serve'.
 	ingressDNSName string
 	capver         tailcfg.CapabilityVersion
+	// backendHealth is the result of the proxy's backend health check, if
+	// one is configured. It is either kubetypes.ValueBackendHealthy or a
+	// description of why the backend is unhealthy.
+	backendHealth string
 }
 
 func deviceInfo(sec *corev1.Secret, podUID string, log *zap.SugaredLogger) (dev *device, err error) {
@@ -613,6 +618,7 @@ func deviceInfo(sec *corev1.Secret, podUID string, log *zap.SugaredLogger) (dev
 		return dev, nil
 	}
 	dev.ingressDNSName = dev.hostname
+	dev.backendHealth = string(sec.Data[kubetypes.KeyBackendHealth])
 	pcv := proxyCapVer(sec, podUID, log)
 	dev.capver = pcv
 	// TODO(irbekrm): we fall back to using the hostname field to determine Ingress's hostname to ensure backwards
@@ -910,6 +916,7 @@ func applyProxyClassToStatefulSet(pc *tsapi.ProxyClass, ss *appsv1.StatefulSet,
 
 	if stsCfg != nil {
 		usesLetsEncrypt := stsCfg.proxyType == proxyTypeIngressResource ||
+			stsCfg.proxyType == proxyTypeGatewayResource ||
 			stsCfg.proxyType == string(tsapi.ProxyGroupTypeIngress) ||
 			stsCfg.proxyType == string(tsapi.ProxyGroupTypeKubernetesAPIServer)
 
@@ -357,6 +357,7 @@ func (r *HAServiceReconciler) maybeProvision(ctx context.Context, hostname strin
 			{
 				Hostname: dnsName,
 				IP:       tsSvcIPv4.String(),
+				Ports:    lbPortStatuses(svc),
 			},
 		}
 
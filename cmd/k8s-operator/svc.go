@@ -38,10 +38,11 @@
 	resolvConfPath       = "/etc/resolv.conf"
 	defaultClusterDomain = "cluster.local"
 
-	reasonProxyCreated = "ProxyCreated"
-	reasonProxyInvalid = "ProxyInvalid"
-	reasonProxyFailed  = "ProxyFailed"
-	reasonProxyPending = "ProxyPending"
+	reasonProxyCreated          = "ProxyCreated"
+	reasonProxyInvalid          = "ProxyInvalid"
+	reasonProxyFailed           = "ProxyFailed"
+	reasonProxyPending          = "ProxyPending"
+	reasonProxyBackendUnhealthy = "ProxyBackendUnhealthy"
 
 	indexServiceProxyClass = ".metadata.annotations.service-proxy-class"
 )
@@ -323,6 +324,16 @@ func (a *ServiceReconciler) maybeProvision(ctx context.Context, logger *zap.Suga
 				return errMsg
 			}
 		}
+		devices, err := a.ssr.DeviceInfo(ctx, crl, logger)
+		if err != nil {
+			return fmt.Errorf("failed to get device ID: %w", err)
+		}
+		if len(devices) != 0 {
+			if msg := unhealthyBackendMessage(devices[0]); msg != "" {
+				tsoperator.SetServiceCondition(svc, tsapi.ProxyReady, metav1.ConditionFalse, reasonProxyBackendUnhealthy, msg, a.clock, logger)
+				return nil
+			}
+		}
 		tsoperator.SetServiceCondition(svc, tsapi.ProxyReady, metav1.ConditionTrue, reasonProxyCreated, reasonProxyCreated, a.clock, logger)
 		return nil
 	}
@@ -370,12 +381,46 @@ func (a *ServiceReconciler) maybeProvision(ctx context.Context, logger *zap.Suga
 			ingress = append(ingress, corev1.LoadBalancerIngress{IP: ip})
 		}
 	}
+	ports := lbPortStatuses(svc)
+	for i := range ingress {
+		ingress[i].Ports = ports
+	}
 
 	svc.Status.LoadBalancer.Ingress = ingress
+	if msg := unhealthyBackendMessage(dev); msg != "" {
+		tsoperator.SetServiceCondition(svc, tsapi.ProxyReady, metav1.ConditionFalse, reasonProxyBackendUnhealthy, msg, a.clock, logger)
+		return nil
+	}
 	tsoperator.SetServiceCondition(svc, tsapi.ProxyReady, metav1.ConditionTrue, reasonProxyCreated, reasonProxyCreated, a.clock, logger)
 	return nil
 }
 
+// unhealthyBackendMessage returns a message describing why the backend of the
+// proxy device dev is unhealthy, or "" if the proxy does not report its backend
+// as unhealthy.
+func unhealthyBackendMessage(dev *device) string {
+	if dev.backendHealth == "" || dev.backendHealth == kubetypes.ValueBackendHealthy {
+		return ""
+	}
+	return fmt.Sprintf("proxy backend is unhealthy: %s", dev.backendHealth)
+}
+
+// lbPortStatuses returns the port statuses to report for each of the
+// LoadBalancer ingress points of svc. The proxy forwards all IP traffic to the
+// Service, so every port of the Service, TCP and UDP alike, is exposed on the
+// tailnet.
+func lbPortStatuses(svc *corev1.Service) []corev1.PortStatus {
+	var ports []corev1.PortStatus
+	for _, p := range svc.Spec.Ports {
+		proto := p.Protocol
+		if proto == "" {
+			proto = corev1.ProtocolTCP
+		}
+		ports = append(ports, corev1.PortStatus{Port: p.Port, Protocol: proto})
+	}
+	return ports
+}
+
 func validateService(svc *corev1.Service) []string {
 	violations := make([]string, 0)
 	if svc.Spec.ClusterIP == "None" {
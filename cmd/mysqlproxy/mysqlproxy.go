@@ -0,0 +1,544 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// The mysqlproxy server is a proxy for the MySQL and MariaDB client
+// protocol.
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"tailscale.com/client/local"
+	"tailscale.com/metrics"
+	"tailscale.com/tsnet"
+	"tailscale.com/tsweb"
+)
+
+var (
+	hostname     = flag.String("hostname", "", "Tailscale hostname to serve on")
+	port         = flag.Int("port", 3306, "Listening port for client connections")
+	debugPort    = flag.Int("debug-port", 80, "Listening port for debug/metrics endpoint")
+	upstreamAddr = flag.String("upstream-addr", "", "Address of the upstream MySQL or MariaDB server, in host:port format")
+	upstreamCA   = flag.String("upstream-ca-file", "", "File containing the PEM-encoded CA certificate for the upstream server")
+	tailscaleDir = flag.String("state-dir", "", "Directory in which to store the Tailscale auth state")
+)
+
+func main() {
+	flag.Parse()
+	if *hostname == "" {
+		log.Fatal("missing --hostname")
+	}
+	if *upstreamAddr == "" {
+		log.Fatal("missing --upstream-addr")
+	}
+	if *upstreamCA == "" {
+		log.Fatal("missing --upstream-ca-file")
+	}
+	if *tailscaleDir == "" {
+		log.Fatal("missing --state-dir")
+	}
+
+	ts := &tsnet.Server{
+		Dir:      *tailscaleDir,
+		Hostname: *hostname,
+	}
+
+	if os.Getenv("TS_AUTHKEY") == "" {
+		log.Print("Note: you need to run this with TS_AUTHKEY=... the first time, to join your tailnet of choice.")
+	}
+
+	tsclient, err := ts.LocalClient()
+	if err != nil {
+		log.Fatalf("getting tsnet API client: %v", err)
+	}
+
+	p, err := newProxy(*upstreamAddr, *upstreamCA, tsclient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	expvar.Publish("mysqlproxy", p.Expvar())
+
+	if *debugPort != 0 {
+		mux := http.NewServeMux()
+		tsweb.Debugger(mux)
+		srv := &http.Server{
+			Handler: mux,
+		}
+		dln, err := ts.Listen("tcp", fmt.Sprintf(":%d", *debugPort))
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			log.Fatal(srv.Serve(dln))
+		}()
+	}
+
+	ln, err := ts.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("serving access to %s on port %d", *upstreamAddr, *port)
+	log.Fatal(p.Serve(ln))
+}
+
+// proxy is a MySQL protocol proxy, which strictly enforces the
+// security of the TLS connection to its upstream regardless of what
+// the client's TLS configuration is.
+type proxy struct {
+	upstreamAddr     string // "my.database.com:3306"
+	upstreamHost     string // "my.database.com"
+	upstreamCertPool *x509.CertPool
+	downstreamCert   []tls.Certificate
+	client           *local.Client
+
+	// authKey is the RSA key that plaintext clients encrypt their
+	// passwords to, when a caching_sha2_password upstream asks for
+	// them. See authenticate.
+	authKey *rsa.PrivateKey
+
+	activeSessions  expvar.Int
+	startedSessions expvar.Int
+	errors          metrics.LabelMap
+}
+
+// newProxy returns a proxy that forwards connections to
+// upstreamAddr. The upstream's TLS session is verified using the CA
+// cert(s) in upstreamCAPath.
+func newProxy(upstreamAddr, upstreamCAPath string, client *local.Client) (*proxy, error) {
+	bs, err := os.ReadFile(upstreamCAPath)
+	if err != nil {
+		return nil, err
+	}
+	upstreamCertPool := x509.NewCertPool()
+	if !upstreamCertPool.AppendCertsFromPEM(bs) {
+		return nil, fmt.Errorf("invalid CA cert in %q", upstreamCAPath)
+	}
+
+	h, _, err := net.SplitHostPort(upstreamAddr)
+	if err != nil {
+		return nil, err
+	}
+	downstreamCert, err := mkSelfSigned(h)
+	if err != nil {
+		return nil, err
+	}
+	authKey, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxy{
+		upstreamAddr:     upstreamAddr,
+		upstreamHost:     h,
+		upstreamCertPool: upstreamCertPool,
+		downstreamCert:   []tls.Certificate{downstreamCert},
+		client:           client,
+		authKey:          authKey,
+		errors:           metrics.LabelMap{Label: "kind"},
+	}, nil
+}
+
+// Expvar returns p's monitoring metrics.
+func (p *proxy) Expvar() expvar.Var {
+	ret := &metrics.Set{}
+	ret.Set("sessions_active", &p.activeSessions)
+	ret.Set("sessions_started", &p.startedSessions)
+	ret.Set("session_errors", &p.errors)
+	return ret
+}
+
+// Serve accepts MySQL client connections on ln and proxies them to
+// the configured upstream. ln can be any net.Listener, but all client
+// connections must originate from tailscale IPs that can be verified
+// with WhoIs.
+func (p *proxy) Serve(ln net.Listener) error {
+	var lastSessionID int64
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		id := time.Now().UnixNano()
+		if id == lastSessionID {
+			// Bluntly enforce SID uniqueness, even if collisions are
+			// fantastically unlikely (but OSes vary in how much timer
+			// precision they expose to the OS, so id might be rounded
+			// e.g. to the same millisecond)
+			id++
+		}
+		lastSessionID = id
+		go func(sessionID int64) {
+			if err := p.serve(sessionID, c); err != nil {
+				log.Printf("%d: session ended with error: %v", sessionID, err)
+			}
+		}(id)
+	}
+}
+
+// serve proxies the MySQL client on c to the proxy's upstream,
+// enforcing strict TLS to the upstream.
+func (p *proxy) serve(sessionID int64, c net.Conn) error {
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	whois, err := p.client.WhoIs(ctx, c.RemoteAddr().String())
+	if err != nil {
+		p.errors.Add("whois-failed", 1)
+		return fmt.Errorf("getting client identity: %v", err)
+	}
+
+	// Before anything else, log the connection attempt.
+	user, machine := "", ""
+	if whois.Node != nil {
+		if whois.Node.Hostinfo.ShareeNode() {
+			machine = "external-device"
+		} else {
+			machine = strings.TrimSuffix(whois.Node.Name, ".")
+		}
+	}
+	if whois.UserProfile != nil {
+		user = whois.UserProfile.LoginName
+		if user == "tagged-devices" && whois.Node != nil {
+			user = strings.Join(whois.Node.Tags, ",")
+		}
+	}
+	if user == "" || machine == "" {
+		p.errors.Add("no-ts-identity", 1)
+		return fmt.Errorf("couldn't identify source user and machine (user %q, machine %q)", user, machine)
+	}
+	log.Printf("%d: session start, from %s (machine %s, user %s)", sessionID, c.RemoteAddr(), machine, user)
+	p.startedSessions.Add(1)
+	p.activeSessions.Add(1)
+	start := time.Now()
+	defer func() {
+		p.activeSessions.Add(-1)
+		elapsed := time.Since(start)
+		log.Printf("%d: session end, from %s (machine %s, user %s), lasted %s", sessionID, c.RemoteAddr(), machine, user, elapsed.Round(time.Millisecond))
+	}()
+
+	s, err := p.connect(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer s.upstream.Close()
+	log.Printf("%d: logging in as mysql user %q to database %q (client TLS: %v)", sessionID, s.user, s.database, s.clientTLS)
+
+	if err := s.relay(); err != nil {
+		// Don't increment error counts here, because the most common
+		// cause of termination is client or server closing the
+		// connection normally, and it'll obscure "interesting"
+		// handshake errors.
+		return fmt.Errorf("session terminated with error: %v", err)
+	}
+	return nil
+}
+
+// session is a client connection whose handshake has been forwarded
+// to a verified TLS connection to the upstream.
+type session struct {
+	client   net.Conn // the client connection, wrapped in TLS if clientTLS
+	upstream net.Conn // the TLS connection to the upstream
+
+	// clientTLS is whether the client upgraded its connection to the
+	// proxy to TLS. If not, the proxy carries out the rest of the
+	// connection phase in connect, as the packets between the client
+	// and the upstream have different sequence IDs.
+	clientTLS bool
+
+	user     string // the MySQL user that the client logs in as
+	database string // the database that the client asked for, if any
+}
+
+// connect dials the upstream and carries out the connection phase on
+// behalf of the client on c, up to and including forwarding the
+// client's HandshakeResponse to the upstream over TLS, and for
+// plaintext clients, the rest of the authentication exchange. On
+// success, the caller must close the returned session's upstream
+// connection.
+func (p *proxy) connect(ctx context.Context, c net.Conn) (_ *session, retErr error) {
+	// MySQL servers speak first, so dial the upstream before
+	// anything else.
+	var d net.Dialer
+	d.Timeout = 10 * time.Second
+	upc, err := d.DialContext(ctx, "tcp", p.upstreamAddr)
+	if err != nil {
+		p.errors.Add("network-error", 1)
+		writeErrPacket(c, 0, erHandshakeError, "08S01", "proxy failed to connect to upstream")
+		return nil, fmt.Errorf("upstream dial: %v", err)
+	}
+	defer func() {
+		if retErr != nil {
+			upc.Close()
+		}
+	}()
+	seq, greeting, err := readPacket(upc, maxHandshakePacketLen)
+	if err != nil {
+		p.errors.Add("network-error", 1)
+		return nil, fmt.Errorf("reading upstream handshake: %v", err)
+	}
+	hs, err := parseServerHandshake(greeting)
+	if err != nil {
+		p.errors.Add("upstream-bad-protocol", 1)
+		writeErrPacket(c, 0, erHandshakeError, "08S01", "proxy failed to connect to upstream")
+		return nil, fmt.Errorf("upstream handshake: %v", err)
+	}
+	if hs.capabilities&clientSSL == 0 {
+		p.errors.Add("upstream-bad-protocol", 1)
+		writeErrPacket(c, 0, erHandshakeError, "08S01", "upstream does not support TLS")
+		return nil, fmt.Errorf("upstream %s does not support TLS", hs.serverVersion)
+	}
+
+	// Forward the upstream's handshake as is, so that the client
+	// answers the upstream's auth challenge. It always offers TLS,
+	// which the proxy provides with its own certificate.
+	if err := writePacket(c, seq, greeting); err != nil {
+		p.errors.Add("network-error", 1)
+		return nil, fmt.Errorf("sending handshake to client: %v", err)
+	}
+
+	// Accept the client conn and set it up the way the client wants.
+	s := &session{client: c}
+	seq, resp, err := readPacket(c, maxHandshakePacketLen)
+	if err != nil {
+		p.errors.Add("network-error", 1)
+		return nil, fmt.Errorf("reading client handshake response: %v", err)
+	}
+	if isSSLRequest(resp) {
+		tc := tls.Server(c, &tls.Config{
+			ServerName:   p.upstreamHost,
+			Certificates: p.downstreamCert,
+			MinVersion:   tls.VersionTLS12,
+		})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			p.errors.Add("client-tls", 1)
+			return nil, fmt.Errorf("client TLS handshake: %v", err)
+		}
+		s.client, s.clientTLS = tc, true
+		if seq, resp, err = readPacket(tc, maxHandshakePacketLen); err != nil {
+			p.errors.Add("network-error", 1)
+			return nil, fmt.Errorf("reading client handshake response: %v", err)
+		}
+	}
+	hr, err := parseHandshakeResponse(resp)
+	if err != nil {
+		p.errors.Add("client-bad-protocol", 1)
+		writeErrPacket(s.client, seq+1, erHandshakeError, "08S01", "bad handshake")
+		return nil, err
+	}
+	s.user, s.database = hr.user, hr.database
+
+	// Upgrade the upstream connection to TLS and verify it, before
+	// it gets to see the client's credentials.
+	sslRequest := sslRequestFor(resp)
+	if err := writePacket(upc, 1, sslRequest); err != nil {
+		p.errors.Add("network-error", 1)
+		return nil, fmt.Errorf("upstream write of SSLRequest: %v", err)
+	}
+	tlsConf := &tls.Config{
+		ServerName: p.upstreamHost,
+		RootCAs:    p.upstreamCertPool,
+		MinVersion: tls.VersionTLS12,
+	}
+	uptc := tls.Client(upc, tlsConf)
+	if err = uptc.HandshakeContext(ctx); err != nil {
+		p.errors.Add("upstream-tls", 1)
+		writeErrPacket(s.client, seq+1, erHandshakeError, "08S01", "proxy failed to verify upstream TLS")
+		return nil, fmt.Errorf("upstream TLS handshake: %v", err)
+	}
+	s.upstream = uptc
+
+	// The upstream must be told that the session uses TLS, which a
+	// plaintext client doesn't say in its own HandshakeResponse.
+	binary.LittleEndian.PutUint32(resp, binary.LittleEndian.Uint32(sslRequest))
+	if err := writePacket(uptc, 2, resp); err != nil {
+		p.errors.Add("network-error", 1)
+		return nil, fmt.Errorf("sending handshake response to upstream: %v", err)
+	}
+	if !s.clientTLS {
+		plugin := hs.authPlugin
+		if hr.authPlugin != "" {
+			plugin = hr.authPlugin
+		}
+		if err := p.authenticate(s, plugin, hs.scramble); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// authenticate relays the rest of the connection phase between the
+// plaintext client of s and the upstream, until the upstream accepts
+// or refuses the login. The client's packets start out with sequence
+// IDs one lower than the upstream's, because the upstream connection
+// has the extra SSLRequest packet.
+//
+// The client believes its connection to be insecure, so when a
+// caching_sha2_password upstream asks for the full password, the
+// client encrypts it with an RSA key that it asks the server for. The
+// proxy answers that request with its own key, and sends the
+// decrypted password to the upstream over TLS, as the upstream expects
+// of a TLS client.
+func (p *proxy) authenticate(s *session, plugin string, scramble []byte) error {
+	shift := byte(1) // the upstream's sequence IDs minus the client's
+	for {
+		seq, pkt, err := readPacket(s.upstream, maxHandshakePacketLen)
+		if err != nil {
+			p.errors.Add("network-error", 1)
+			return fmt.Errorf("reading upstream auth packet: %v", err)
+		}
+		if len(pkt) == 0 {
+			p.errors.Add("upstream-bad-protocol", 1)
+			writeErrPacket(s.client, seq-shift, erHandshakeError, "08S01", "bad handshake")
+			return fmt.Errorf("empty upstream auth packet")
+		}
+		if err := writePacket(s.client, seq-shift, pkt); err != nil {
+			p.errors.Add("network-error", 1)
+			return fmt.Errorf("sending auth packet to client: %v", err)
+		}
+		switch {
+		case pkt[0] == okPacket:
+			return nil
+		case pkt[0] == errPacket:
+			return fmt.Errorf("upstream refused login: %s", errPacketMessage(pkt))
+		case pkt[0] == authSwitchRequest:
+			if plugin, scramble, err = parseAuthSwitchRequest(pkt); err != nil {
+				p.errors.Add("upstream-bad-protocol", 1)
+				return err
+			}
+		case pkt[0] == authMoreData && plugin == cachingSHA2Password && len(pkt) == 2:
+			switch pkt[1] {
+			case cachingSHA2FastAuthSuccess:
+				continue // the upstream sends its OK packet next
+			case cachingSHA2FullAuth:
+				cseq, pw, err := p.readPassword(s.client, scramble)
+				if err != nil {
+					return err
+				}
+				if err := writePacket(s.upstream, seq+1, pw); err != nil {
+					p.errors.Add("network-error", 1)
+					return fmt.Errorf("sending password to upstream: %v", err)
+				}
+				shift = seq + 1 - cseq
+				continue
+			}
+		}
+
+		cseq, resp, err := readPacket(s.client, maxHandshakePacketLen)
+		if err != nil {
+			p.errors.Add("network-error", 1)
+			return fmt.Errorf("reading client auth packet: %v", err)
+		}
+		if err := writePacket(s.upstream, cseq+shift, resp); err != nil {
+			p.errors.Add("network-error", 1)
+			return fmt.Errorf("sending auth packet to upstream: %v", err)
+		}
+	}
+}
+
+// readPassword reads the password that a plaintext
+// caching_sha2_password client on c sends for full authentication,
+// first answering its request for the server's RSA key with the
+// proxy's. It returns the password, NUL-terminated, and the sequence
+// ID of the client's last packet.
+func (p *proxy) readPassword(c net.Conn, scramble []byte) (seq byte, pw []byte, err error) {
+	seq, resp, err := readPacket(c, maxHandshakePacketLen)
+	if err != nil {
+		p.errors.Add("network-error", 1)
+		return 0, nil, fmt.Errorf("reading client auth packet: %v", err)
+	}
+	if len(resp) == 1 && resp[0] == cachingSHA2RequestPublicKey {
+		pk, err := publicKeyPacket(&p.authKey.PublicKey)
+		if err != nil {
+			return 0, nil, err
+		}
+		if err := writePacket(c, seq+1, pk); err != nil {
+			p.errors.Add("network-error", 1)
+			return 0, nil, fmt.Errorf("sending public key to client: %v", err)
+		}
+		if seq, resp, err = readPacket(c, maxHandshakePacketLen); err != nil {
+			p.errors.Add("network-error", 1)
+			return 0, nil, fmt.Errorf("reading client auth packet: %v", err)
+		}
+	}
+	pw, err = decryptPassword(p.authKey, resp, scramble)
+	if err != nil {
+		// The client may have encrypted the password with the
+		// upstream's own key, which it was configured with.
+		p.errors.Add("client-bad-protocol", 1)
+		writeErrPacket(c, seq+1, erHandshakeError, "08S01", "proxy failed to decrypt password")
+		return 0, nil, fmt.Errorf("decrypting client password: %v", err)
+	}
+	return seq, pw, nil
+}
+
+// relay proxies the rest of the session between the client and the
+// upstream.
+func (s *session) relay() error {
+	errc := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(s.upstream, s.client)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(s.client, s.upstream)
+		errc <- err
+	}()
+	return <-errc
+}
+
+// mkSelfSigned creates and returns a self-signed TLS certificate for
+// hostname.
+func mkSelfSigned(hostname string) (tls.Certificate, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	pub := priv.Public()
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Organization: []string{"mysqlproxy"},
+		},
+		DNSNames:              []string{hostname},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	derBytes, err := x509.CreateCertificate(crand.Reader, &template, &template, pub, priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{derBytes},
+		PrivateKey:  priv,
+		Leaf:        cert,
+	}, nil
+}
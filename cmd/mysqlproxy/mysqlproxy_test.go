@@ -0,0 +1,324 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"net"
+	"testing"
+
+	"tailscale.com/metrics"
+)
+
+const testCapabilities = clientConnectWithDB | clientProtocol41 | clientSecureConnection | clientPluginAuth | clientPluginAuthLenencClient
+
+// testScramble is the auth plugin data in testGreeting.
+const testScramble = "abcdefghijklmnopqrst"
+
+// testGreeting returns the payload of a server's initial handshake
+// with capabilities caps.
+func testGreeting(caps uint32) []byte {
+	b := []byte{handshakeV10}
+	b = append(b, "8.0.36\x00"...)
+	b = binary.LittleEndian.AppendUint32(b, 42) // connection ID
+	b = append(b, "abcdefgh"...)                // auth plugin data, part 1
+	b = append(b, 0)
+	b = binary.LittleEndian.AppendUint16(b, uint16(caps))
+	b = append(b, 0xff)                        // character set
+	b = binary.LittleEndian.AppendUint16(b, 2) // status flags
+	b = binary.LittleEndian.AppendUint16(b, uint16(caps>>16))
+	b = append(b, 21)
+	b = append(b, make([]byte, 10)...)
+	b = append(b, "ijklmnopqrst\x00"...) // auth plugin data, part 2
+	return append(b, "caching_sha2_password\x00"...)
+}
+
+// testHandshakeResponse returns the payload of a HandshakeResponse41
+// with capabilities caps.
+func testHandshakeResponse(caps uint32, user, database string) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, caps)
+	b = binary.LittleEndian.AppendUint32(b, 1<<24) // max packet size
+	b = append(b, 0xff)                            // character set
+	b = append(b, make([]byte, 23)...)
+	b = append(b, user...)
+	b = append(b, 0)
+	b = append(b, 32)
+	b = append(b, bytes.Repeat([]byte{'x'}, 32)...) // auth response
+	b = append(b, database...)
+	b = append(b, 0)
+	return append(b, "caching_sha2_password\x00"...)
+}
+
+func TestParseServerHandshake(t *testing.T) {
+	hs, err := parseServerHandshake(testGreeting(testCapabilities | clientSSL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hs.serverVersion != "8.0.36" {
+		t.Errorf("got server version %q", hs.serverVersion)
+	}
+	if string(hs.scramble) != testScramble || hs.authPlugin != cachingSHA2Password {
+		t.Errorf("got scramble %q, auth plugin %q", hs.scramble, hs.authPlugin)
+	}
+	if want := uint32(testCapabilities | clientSSL); hs.capabilities != want {
+		t.Errorf("got capabilities %#x, want %#x", hs.capabilities, want)
+	}
+
+	var errBuf bytes.Buffer
+	writeErrPacket(&errBuf, 0, 1129, "HY000", "Host is blocked")
+	if _, err := parseServerHandshake(errBuf.Bytes()[4:]); err == nil {
+		t.Error("got nil error for ERR packet")
+	}
+	if _, err := parseServerHandshake([]byte{9, 'v', 0}); err == nil {
+		t.Error("got nil error for old protocol version")
+	}
+}
+
+func TestParseHandshakeResponse(t *testing.T) {
+	for _, caps := range []uint32{testCapabilities, testCapabilities &^ clientPluginAuthLenencClient} {
+		r, err := parseHandshakeResponse(testHandshakeResponse(caps, "app", "orders"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.user != "app" || r.database != "orders" || r.authPlugin != cachingSHA2Password {
+			t.Errorf("capabilities %#x: got user %q, database %q, auth plugin %q", caps, r.user, r.database, r.authPlugin)
+		}
+	}
+	if _, err := parseHandshakeResponse(testHandshakeResponse(testCapabilities&^clientProtocol41, "app", "")); err == nil {
+		t.Error("got nil error for protocol 4.0 client")
+	}
+	if _, err := parseHandshakeResponse(testHandshakeResponse(testCapabilities, "app", "")[:40]); err == nil {
+		t.Error("got nil error for truncated response")
+	}
+	if !isSSLRequest(sslRequestFor(testHandshakeResponse(testCapabilities, "app", ""))) {
+		t.Error("sslRequestFor did not return an SSLRequest")
+	}
+}
+
+// fakeUpstream serves a single MySQL session on ln over TLS with cert,
+// accepting the user "app". If fullAuth, it asks for the password
+// "secret", as caching_sha2_password does when the user's password
+// isn't cached.
+func fakeUpstream(t *testing.T, ln net.Listener, cert tls.Certificate, fullAuth bool) {
+	c, err := ln.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	if err := writePacket(c, 0, testGreeting(testCapabilities|clientSSL)); err != nil {
+		t.Error(err)
+		return
+	}
+	seq, req, err := readPacket(c, maxHandshakePacketLen)
+	if err != nil || seq != 1 || !isSSLRequest(req) {
+		t.Errorf("got packet %d %q, %v; want SSLRequest", seq, req, err)
+		return
+	}
+	tc := tls.Server(c, &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err := tc.Handshake(); err != nil {
+		return // the proxy rejected the certificate
+	}
+	seq, resp, err := readPacket(tc, maxHandshakePacketLen)
+	if err != nil || seq != 2 {
+		t.Errorf("got packet %d, %v; want HandshakeResponse41", seq, err)
+		return
+	}
+	hr, err := parseHandshakeResponse(resp)
+	if err != nil || hr.user != "app" || hr.capabilities&clientSSL == 0 {
+		t.Errorf("got handshake response %+v, %v", hr, err)
+		return
+	}
+	if fullAuth {
+		writePacket(tc, 3, []byte{authMoreData, cachingSHA2FullAuth})
+		seq, pw, err := readPacket(tc, maxHandshakePacketLen)
+		if err != nil || seq != 4 || string(pw) != "secret\x00" {
+			t.Errorf("got packet %d %q, %v; want cleartext password", seq, pw, err)
+			return
+		}
+		writePacket(tc, 5, []byte{okPacket, 0, 0, 2, 0, 0, 0})
+	} else {
+		writePacket(tc, 3, []byte{authMoreData, cachingSHA2FastAuthSuccess})
+		writePacket(tc, 4, []byte{okPacket, 0, 0, 2, 0, 0, 0})
+	}
+
+	// Answer a COM_PING.
+	seq, cmd, err := readPacket(tc, maxHandshakePacketLen)
+	if err != nil || seq != 0 || !bytes.Equal(cmd, []byte{0x0e}) {
+		t.Errorf("got packet %d %q, %v; want COM_PING", seq, cmd, err)
+		return
+	}
+	writePacket(tc, 1, []byte{okPacket, 0, 0, 2, 0, 0, 0})
+}
+
+func TestProxySession(t *testing.T) {
+	upstreamCert, err := mkSelfSigned("db.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCert, err := mkSelfSigned("db.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(upstreamCert.Leaf)
+	downstreamCert, err := mkSelfSigned("db.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		clientTLS bool
+		fullAuth  bool
+		cert      tls.Certificate // presented by the upstream
+		wantErr   bool
+	}{
+		{name: "plaintext_client", cert: upstreamCert},
+		{name: "tls_client", clientTLS: true, cert: upstreamCert},
+		{name: "plaintext_client_full_auth", fullAuth: true, cert: upstreamCert},
+		{name: "tls_client_full_auth", clientTLS: true, fullAuth: true, cert: upstreamCert},
+		{name: "untrusted_upstream", cert: otherCert, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			go fakeUpstream(t, ln, tt.cert, tt.fullAuth)
+
+			p := &proxy{
+				upstreamAddr:     ln.Addr().String(),
+				upstreamHost:     "db.example.com",
+				upstreamCertPool: pool,
+				downstreamCert:   []tls.Certificate{downstreamCert},
+				authKey:          authKey,
+				errors:           metrics.LabelMap{Label: "kind"},
+			}
+			c, proxyConn := net.Pipe()
+			defer c.Close()
+			errc := make(chan error, 1)
+			go func() {
+				defer proxyConn.Close()
+				s, err := p.connect(context.Background(), proxyConn)
+				if err != nil {
+					errc <- err
+					return
+				}
+				defer s.upstream.Close()
+				if s.user != "app" || s.database != "orders" {
+					t.Errorf("got user %q, database %q", s.user, s.database)
+				}
+				errc <- s.relay()
+			}()
+
+			seq, greeting, err := readPacket(c, maxHandshakePacketLen)
+			if err != nil || seq != 0 {
+				t.Fatalf("reading greeting: %d, %v", seq, err)
+			}
+			if _, err := parseServerHandshake(greeting); err != nil {
+				t.Fatal(err)
+			}
+			resp := testHandshakeResponse(testCapabilities, "app", "orders")
+			seq = 1
+			if tt.clientTLS {
+				if err := writePacket(c, seq, sslRequestFor(resp)); err != nil {
+					t.Fatal(err)
+				}
+				tc := tls.Client(c, &tls.Config{InsecureSkipVerify: true})
+				if err := tc.Handshake(); err != nil {
+					t.Fatal(err)
+				}
+				c, seq = tc, 2
+			}
+			if err := writePacket(c, seq, resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantErr {
+				_, pkt, err := readPacket(c, maxHandshakePacketLen)
+				if err != nil || len(pkt) == 0 || pkt[0] != errPacket {
+					t.Errorf("got packet %q, %v; want ERR packet", pkt, err)
+				}
+				if err := <-errc; err == nil {
+					t.Error("connect succeeded with untrusted upstream")
+				}
+				if got := p.errors.Get("upstream-tls"); got == nil || got.String() != "1" {
+					t.Errorf("upstream-tls errors = %v, want 1", got)
+				}
+				return
+			}
+
+			read := func(want ...byte) []byte {
+				t.Helper()
+				seq++
+				gotSeq, pkt, err := readPacket(c, maxHandshakePacketLen)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if gotSeq != seq || !bytes.HasPrefix(pkt, want) {
+					t.Fatalf("got packet %d %q, want sequence ID %d and prefix %q", gotSeq, pkt, seq, want)
+				}
+				return pkt
+			}
+			write := func(pkt []byte) {
+				t.Helper()
+				seq++
+				if err := writePacket(c, seq, pkt); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !tt.fullAuth {
+				read(authMoreData, cachingSHA2FastAuthSuccess)
+			} else {
+				read(authMoreData, cachingSHA2FullAuth)
+				if tt.clientTLS {
+					write([]byte("secret\x00"))
+				} else {
+					// Like MySQL clients on insecure connections, ask
+					// for the server's key and encrypt the password.
+					write([]byte{cachingSHA2RequestPublicKey})
+					block, _ := pem.Decode(read(authMoreData)[1:])
+					if block == nil {
+						t.Fatal("no public key in response")
+					}
+					pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+					if err != nil {
+						t.Fatal(err)
+					}
+					pw := []byte("secret\x00")
+					for i := range pw {
+						pw[i] ^= testScramble[i%len(testScramble)]
+					}
+					enc, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, pub.(*rsa.PublicKey), pw, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					write(enc)
+				}
+			}
+			read(okPacket)
+			if err := writePacket(c, 0, []byte{0x0e}); err != nil {
+				t.Fatal(err)
+			}
+			if seq, pkt, err := readPacket(c, maxHandshakePacketLen); err != nil || seq != 1 || pkt[0] != okPacket {
+				t.Fatalf("got ping response %d %q, %v", seq, pkt, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// This file implements the parts of the MySQL client/server protocol
+// that the proxy needs to understand: the packet framing, the server's
+// initial handshake, the client's SSLRequest and HandshakeResponse41,
+// and enough of the authentication exchange to complete
+// caching_sha2_password logins for plaintext clients. MariaDB speaks
+// the same protocol for these.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase.html.
+
+const (
+	// handshakeV10 is the protocol version in the initial handshake
+	// of all current MySQL and MariaDB servers.
+	handshakeV10 = 10
+	// maxHandshakePacketLen is the largest packet the proxy reads
+	// during the connection phase. Handshake packets are small, save
+	// for client connection attributes, which are limited to 64KiB.
+	maxHandshakePacketLen = 1 << 20
+	// sslRequestLen is the length of an SSLRequest packet's payload,
+	// which is the fixed-size prefix of a HandshakeResponse41.
+	sslRequestLen = 32
+
+	// Capability flags.
+	clientConnectWithDB          = 0x00000008
+	clientProtocol41             = 0x00000200
+	clientSSL                    = 0x00000800
+	clientSecureConnection       = 0x00008000
+	clientPluginAuth             = 0x00080000
+	clientPluginAuthLenencClient = 0x00200000
+
+	// Markers at the start of server packets.
+	okPacket          = 0x00
+	errPacket         = 0xff
+	authMoreData      = 0x01
+	authSwitchRequest = 0xfe
+
+	// cachingSHA2Password is the name of MySQL's default
+	// authentication plugin, and the values it sends in AuthMoreData
+	// packets and clients send to ask for the server's RSA key.
+	cachingSHA2Password         = "caching_sha2_password"
+	cachingSHA2FastAuthSuccess  = 0x03
+	cachingSHA2FullAuth         = 0x04
+	cachingSHA2RequestPublicKey = 0x02
+
+	// erHandshakeError is the server error code for a failed
+	// connection handshake. Clients show it as "Bad handshake".
+	erHandshakeError = 1043
+)
+
+// readPacket reads a single packet of at most maxLen bytes from r,
+// returning its sequence ID and payload.
+func readPacket(r io.Reader, maxLen int) (seq byte, payload []byte, err error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(hdr[0]) | int(hdr[1])<<8 | int(hdr[2])<<16
+	if n > maxLen {
+		return 0, nil, fmt.Errorf("packet length %d exceeds maximum of %d", n, maxLen)
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return hdr[3], payload, nil
+}
+
+// writePacket writes a single packet with sequence ID seq to w.
+func writePacket(w io.Writer, seq byte, payload []byte) error {
+	n := len(payload)
+	b := make([]byte, 4, 4+n)
+	b[0], b[1], b[2], b[3] = byte(n), byte(n>>8), byte(n>>16), seq
+	_, err := w.Write(append(b, payload...))
+	return err
+}
+
+// writeErrPacket sends an ERR packet with sequence ID seq, the given
+// error code and SQLSTATE, and msg to a client.
+func writeErrPacket(w io.Writer, seq byte, code uint16, sqlState, msg string) error {
+	b := []byte{errPacket}
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = append(b, '#')
+	b = append(b, sqlState...)
+	b = append(b, msg...)
+	return writePacket(w, seq, b)
+}
+
+// serverHandshake is the parts of a server's initial handshake packet
+// that the proxy cares about.
+type serverHandshake struct {
+	serverVersion string
+	capabilities  uint32
+	scramble      []byte // the auth plugin data, without its NUL terminator
+	authPlugin    string // or empty, if the server didn't name one
+}
+
+// parseServerHandshake parses the payload of a server's initial
+// handshake packet.
+func parseServerHandshake(payload []byte) (*serverHandshake, error) {
+	if len(payload) == 0 {
+		return nil, errors.New("empty handshake")
+	}
+	if payload[0] == errPacket {
+		return nil, fmt.Errorf("server refused connection: %s", errPacketMessage(payload))
+	}
+	if payload[0] != handshakeV10 {
+		return nil, fmt.Errorf("unsupported handshake protocol version %d", payload[0])
+	}
+	version, rest, ok := bytes.Cut(payload[1:], []byte{0})
+	if !ok {
+		return nil, errors.New("unterminated server version")
+	}
+	// Skip the connection ID, the first 8 bytes of the auth plugin
+	// data, and a filler byte, to get to the capability flags, which
+	// are split around the character set and status flags.
+	if len(rest) < 4+8+1+2+1+2+2 {
+		return nil, errors.New("short handshake")
+	}
+	scramble := bytes.Clone(rest[4 : 4+8])
+	rest = rest[4+8+1:]
+	caps := uint32(binary.LittleEndian.Uint16(rest)) | uint32(binary.LittleEndian.Uint16(rest[5:]))<<16
+	hs := &serverHandshake{
+		serverVersion: string(version),
+		capabilities:  caps,
+		scramble:      scramble,
+	}
+
+	// The rest of the auth plugin data and the plugin name follow the
+	// upper capability flags, the length of the auth plugin data and 10
+	// reserved bytes.
+	if len(rest) < 2+1+2+2+1+10 {
+		return hs, nil
+	}
+	authDataLen := int(rest[7])
+	rest = rest[2+1+2+2+1+10:]
+	if caps&clientSecureConnection != 0 {
+		n := max(13, authDataLen-8)
+		if len(rest) < n {
+			return nil, errors.New("short handshake")
+		}
+		hs.scramble = append(hs.scramble, bytes.TrimSuffix(rest[:n], []byte{0})...)
+		rest = rest[n:]
+	}
+	if caps&clientPluginAuth != 0 {
+		name, _, _ := bytes.Cut(rest, []byte{0})
+		hs.authPlugin = string(name)
+	}
+	return hs, nil
+}
+
+// handshakeResponse is the parts of a client's HandshakeResponse41
+// that the proxy cares about.
+type handshakeResponse struct {
+	capabilities uint32
+	user         string
+	database     string // or empty, if the client didn't pick one
+	authPlugin   string // or empty, if the client didn't name one
+}
+
+// parseHandshakeResponse parses the payload of a client's
+// HandshakeResponse41.
+func parseHandshakeResponse(payload []byte) (*handshakeResponse, error) {
+	if len(payload) < sslRequestLen {
+		return nil, errors.New("short handshake response")
+	}
+	r := &handshakeResponse{capabilities: binary.LittleEndian.Uint32(payload)}
+	if r.capabilities&clientProtocol41 == 0 {
+		return nil, errors.New("client does not support protocol 4.1")
+	}
+	user, rest, ok := bytes.Cut(payload[sslRequestLen:], []byte{0})
+	if !ok {
+		return nil, errors.New("unterminated user name")
+	}
+	r.user = string(user)
+
+	// Skip the auth response.
+	switch {
+	case r.capabilities&clientPluginAuthLenencClient != 0:
+		n, size, ok := readLenencInt(rest)
+		if !ok || uint64(len(rest)-size) < n {
+			return nil, errors.New("truncated auth response")
+		}
+		rest = rest[size+int(n):]
+	case r.capabilities&clientSecureConnection != 0:
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return nil, errors.New("truncated auth response")
+		}
+		rest = rest[1+int(rest[0]):]
+	default:
+		_, after, ok := bytes.Cut(rest, []byte{0})
+		if !ok {
+			return nil, errors.New("unterminated auth response")
+		}
+		rest = after
+	}
+
+	if r.capabilities&clientConnectWithDB != 0 {
+		db, after, ok := bytes.Cut(rest, []byte{0})
+		if !ok {
+			return nil, errors.New("unterminated database name")
+		}
+		r.database = string(db)
+		rest = after
+	}
+	if r.capabilities&clientPluginAuth != 0 {
+		name, _, _ := bytes.Cut(rest, []byte{0})
+		r.authPlugin = string(name)
+	}
+	return r, nil
+}
+
+// parseAuthSwitchRequest parses the payload of a server's
+// AuthSwitchRequest, returning the plugin to switch to and its auth
+// plugin data, without its NUL terminator.
+func parseAuthSwitchRequest(payload []byte) (plugin string, scramble []byte, err error) {
+	if len(payload) == 0 || payload[0] != authSwitchRequest {
+		return "", nil, errors.New("not an AuthSwitchRequest")
+	}
+	name, data, ok := bytes.Cut(payload[1:], []byte{0})
+	if !ok {
+		return "", nil, errors.New("unterminated auth plugin name")
+	}
+	return string(name), bytes.TrimSuffix(data, []byte{0}), nil
+}
+
+// publicKeyPacket returns the payload of the AuthMoreData packet with
+// which a caching_sha2_password server answers a client's request for
+// its RSA public key.
+func publicKeyPacket(key *rsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{authMoreData}, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...), nil
+}
+
+// decryptPassword returns the NUL-terminated password that a
+// caching_sha2_password client encrypted as ciphertext with the public
+// key of key, having XORed it with scramble as the plugin does.
+func decryptPassword(key *rsa.PrivateKey, ciphertext, scramble []byte) ([]byte, error) {
+	if len(scramble) == 0 {
+		return nil, errors.New("no scramble")
+	}
+	pw, err := rsa.DecryptOAEP(sha1.New(), nil, key, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pw {
+		pw[i] ^= scramble[i%len(scramble)]
+	}
+	return pw, nil
+}
+
+// readLenencInt reads a length-encoded integer from the start of b,
+// returning it and the number of bytes it took up.
+func readLenencInt(b []byte) (n uint64, size int, ok bool) {
+	if len(b) == 0 {
+		return 0, 0, false
+	}
+	switch b[0] {
+	case 0xfc:
+		size = 3
+	case 0xfd:
+		size = 4
+	case 0xfe:
+		size = 9
+	case 0xfb, 0xff:
+		return 0, 0, false
+	default:
+		return uint64(b[0]), 1, true
+	}
+	if len(b) < size {
+		return 0, 0, false
+	}
+	var buf [8]byte
+	copy(buf[:], b[1:size])
+	return binary.LittleEndian.Uint64(buf[:]), size, true
+}
+
+// isSSLRequest reports whether the client's first packet, with payload
+// payload, is an SSLRequest rather than a full HandshakeResponse41.
+func isSSLRequest(payload []byte) bool {
+	return len(payload) == sslRequestLen && binary.LittleEndian.Uint32(payload)&clientSSL != 0
+}
+
+// sslRequestFor returns the SSLRequest payload for a client whose
+// HandshakeResponse41 payload is resp. It has the same capabilities,
+// maximum packet size and character set as resp, plus clientSSL.
+func sslRequestFor(resp []byte) []byte {
+	req := bytes.Clone(resp[:sslRequestLen])
+	binary.LittleEndian.PutUint32(req, binary.LittleEndian.Uint32(req)|clientSSL)
+	return req
+}
+
+// errPacketMessage returns the human-readable message of the ERR
+// packet with payload payload.
+func errPacketMessage(payload []byte) string {
+	if len(payload) < 3 {
+		return "unknown error"
+	}
+	msg := payload[3:]
+	if len(msg) >= 6 && msg[0] == '#' {
+		msg = msg[6:] // SQLSTATE
+	}
+	return fmt.Sprintf("error %d: %s", binary.LittleEndian.Uint16(payload[1:]), msg)
+}
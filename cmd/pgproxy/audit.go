@@ -0,0 +1,572 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/net/netx"
+	"tailscale.com/sessionrecording"
+	"tailscale.com/tailcfg"
+)
+
+// defaultMaxAuditedQueryLen is the default --audit-max-query-len, the
+// largest client message whose contents the proxy inspects for the audit
+// log. Only the start of larger messages, such as huge queries, is buffered
+// and inspected; the rest is forwarded as it arrives.
+const defaultMaxAuditedQueryLen = 1 << 20
+
+// maxAuditedBackendMessageLen is the largest upstream message whose contents
+// the proxy inspects for the audit log. The messages it inspects, such as
+// CommandComplete and ErrorResponse, are normally much smaller.
+const maxAuditedBackendMessageLen = 64 << 10
+
+// The types of the messages that copyFrontend and copyBackend inspect. The
+// others are forwarded without being buffered.
+const (
+	auditedFrontendMessages = "QPBEFSC"
+	auditedBackendMessages  = "CIsEZ"
+)
+
+// auditEvent is a single record of the audit log, written as a line of
+// JSON.
+type auditEvent struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"` // "session-start", "query" or "session-end"
+	SessionID int64     `json:"sessionID"`
+	User      string    `json:"user"`    // tailnet login name, or tags of tagged devices
+	Machine   string    `json:"machine"` // tailnet machine name
+	Role      string    `json:"role,omitempty"`
+	Database  string    `json:"database,omitempty"`
+
+	// The following fields are only set for "query" events.
+
+	// Query is the text of the query.
+	Query string `json:"query,omitempty"`
+	// Truncated is whether Query is only the start of a query that was
+	// too large to record in full.
+	Truncated bool `json:"truncated,omitempty"`
+	// Function is the OID of the function called with the fast-path
+	// function call protocol, in which case Query is empty.
+	Function uint32 `json:"function,omitempty"`
+	// Extended is whether the query was run with the extended query
+	// protocol, as opposed to the simple query protocol.
+	Extended bool `json:"extended,omitempty"`
+	// NumParams is the number of parameters bound to an extended
+	// query, or of arguments passed to a Function.
+	NumParams int `json:"numParams,omitempty"`
+	// Params are the values of the parameters bound to an extended
+	// query or of the arguments passed to a Function, or nil unless
+	// the proxy runs with --audit-params. NULL parameters are null,
+	// and binary parameters are hex encoded.
+	Params []*string `json:"params,omitempty"`
+	// Commands are the command tags returned by the upstream for
+	// each completed statement, such as "SELECT 2" or "INSERT 0 1".
+	Commands []string `json:"commands,omitempty"`
+	// Rows is the number of rows returned or affected, as reported in
+	// Commands.
+	Rows int64 `json:"rows,omitempty"`
+	// Error is the error message returned by the upstream, if any.
+	Error string `json:"error,omitempty"`
+	// DurationMillis is how long the upstream took to complete the
+	// query, or the session for "session-end" events.
+	DurationMillis int64 `json:"durationMillis,omitempty"`
+}
+
+// auditor writes the audit log of postgres sessions to a local file
+// and/or to a tsrecorder.
+type auditor struct {
+	includeParams bool // whether to record the values of query parameters
+	maxQueryLen   int  // largest client message to inspect in full
+
+	mu   sync.Mutex
+	file *os.File // or nil
+
+	recorders []netip.AddrPort // or nil
+	dial      netx.DialFunc
+}
+
+// newAuditor returns an auditor that appends to the file at path, if
+// non-empty, and uploads a recording of each session to the first
+// reachable recorder, if any, dialed with dial. Queries longer than
+// maxQueryLen bytes are truncated, or longer than defaultMaxAuditedQueryLen
+// if it's zero.
+func newAuditor(path string, recorders []netip.AddrPort, dial netx.DialFunc, includeParams bool, maxQueryLen int) (*auditor, error) {
+	a := &auditor{
+		includeParams: includeParams,
+		maxQueryLen:   cmp.Or(maxQueryLen, defaultMaxAuditedQueryLen),
+		recorders:     recorders,
+		dial:          dial,
+	}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		a.file = f
+	}
+	return a, nil
+}
+
+// parseRecorders parses a comma-separated list of recorder addresses.
+func parseRecorders(s string) ([]netip.AddrPort, error) {
+	var recs []netip.AddrPort
+	for addr := range strings.SplitSeq(s, ",") {
+		ap, err := netip.ParseAddrPort(strings.TrimSpace(addr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid recorder address %q: %w", addr, err)
+		}
+		recs = append(recs, ap)
+	}
+	return recs, nil
+}
+
+// sessionAudit is the audit log of a single session. Its queries are
+// recorded as they pass through copyFrontend and copyBackend.
+type sessionAudit struct {
+	a     *auditor
+	base  auditEvent // session fields of all events
+	start time.Time
+
+	rec    io.WriteCloser // upload to the recorder, or nil
+	recErr <-chan error   // result of the upload
+
+	// pending are the queries and Sync points sent upstream whose
+	// results have not been fully received yet, in the order the
+	// upstream processes them.
+	mu      sync.Mutex
+	pending []*pendingQuery
+
+	// statements and portals are the prepared statements and portals
+	// of the extended query protocol, by name. They are only
+	// accessed by copyFrontend.
+	statements map[string]statement
+	portals    map[string]*portal
+}
+
+// statement is a prepared extended protocol statement.
+type statement struct {
+	query     string
+	truncated bool // query is only the start of the statement's text
+}
+
+// pendingQuery is a query awaiting its results, or a Sync.
+type pendingQuery struct {
+	ev     auditEvent
+	isSync bool
+}
+
+// portal is a bound extended protocol statement.
+type portal struct {
+	statement
+	numParams int
+	params    []*string // nil unless the auditor includes params
+}
+
+// startSession starts the audit log of a session, connecting to the
+// recorder if one is configured.
+func (a *auditor) startSession(ctx context.Context, base auditEvent, srcNode *tailcfg.Node) (*sessionAudit, error) {
+	s := &sessionAudit{
+		a:          a,
+		base:       base,
+		start:      time.Now(),
+		statements: make(map[string]statement),
+		portals:    make(map[string]*portal),
+	}
+	if len(a.recorders) > 0 {
+		rec, _, errc, err := sessionrecording.ConnectToRecorder(ctx, a.recorders, a.dial)
+		if err != nil {
+			return nil, err
+		}
+		s.rec, s.recErr = rec, errc
+		hdr := sessionrecording.CastHeader{
+			Version:   2,
+			Timestamp: s.start.Unix(),
+			Command:   "pgproxy",
+			LocalUser: base.Role,
+		}
+		if srcNode != nil {
+			hdr.SrcNode = strings.TrimSuffix(srcNode.Name, ".")
+			hdr.SrcNodeID = srcNode.StableID
+			if srcNode.IsTagged() {
+				hdr.SrcNodeTags = srcNode.Tags
+			} else {
+				hdr.SrcNodeUser = base.User
+			}
+		}
+		if err := json.NewEncoder(rec).Encode(hdr); err != nil {
+			rec.Close()
+			return nil, err
+		}
+	}
+	ev := s.base
+	ev.Type = "session-start"
+	if err := s.write(&ev); err != nil {
+		s.closeRecorder()
+		return nil, err
+	}
+	return s, nil
+}
+
+// end records the end of the session and finishes the recording.
+func (s *sessionAudit) end() error {
+	ev := s.base
+	ev.Type = "session-end"
+	ev.DurationMillis = time.Since(s.start).Milliseconds()
+	s.mu.Lock()
+	err := s.write(&ev)
+	s.mu.Unlock()
+	return errors.Join(err, s.closeRecorder())
+}
+
+func (s *sessionAudit) closeRecorder() error {
+	if s.rec == nil {
+		return nil
+	}
+	if err := s.rec.Close(); err != nil {
+		return err
+	}
+	return <-s.recErr
+}
+
+// write writes ev to the audit log, setting its Time to now if unset.
+// An error means that the session can no longer be audited.
+func (s *sessionAudit) write(ev *auditEvent) error {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if s.a.file != nil {
+		s.a.mu.Lock()
+		_, err := s.a.file.Write(b)
+		s.a.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("writing audit log: %w", err)
+		}
+	}
+	if s.rec != nil {
+		if _, err := s.rec.Write(b); err != nil {
+			return fmt.Errorf("uploading audit log to recorder: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyFrontend forwards the messages that the client sends on src to
+// the upstream on dst, noting the queries among them.
+func (s *sessionAudit) copyFrontend(dst io.Writer, src io.Reader) error {
+	return copyMessages(dst, src, auditedFrontendMessages, s.a.maxQueryLen, func(typ byte, body []byte, truncated bool) error {
+		switch typ {
+		case 'Q': // Query
+			q, _, _ := bytes.Cut(body, []byte{0})
+			ev := s.queryEvent(string(q), false)
+			ev.Truncated = truncated
+			s.push(&pendingQuery{ev: ev})
+		case 'P': // Parse
+			r := msgReader{b: body}
+			name := r.cstring()
+			st := statement{truncated: truncated}
+			if truncated {
+				// The query text runs to the end of the buffered prefix.
+				st.query = string(r.b)
+			} else {
+				st.query = r.cstring()
+			}
+			s.statements[name] = st
+		case 'B': // Bind
+			s.bind(body)
+		case 'E': // Execute
+			r := msgReader{b: body}
+			ev := s.queryEvent("", true)
+			if p, ok := s.portals[r.cstring()]; ok {
+				ev.Query = p.query
+				ev.Truncated = p.truncated
+				ev.NumParams = p.numParams
+				ev.Params = p.params
+			}
+			s.push(&pendingQuery{ev: ev})
+		case 'F': // FunctionCall
+			r := msgReader{b: body}
+			ev := s.queryEvent("", false)
+			ev.Function = uint32(r.int32())
+			ev.NumParams, ev.Params = s.readParams(&r)
+			s.push(&pendingQuery{ev: ev})
+		case 'S': // Sync
+			s.push(&pendingQuery{isSync: true})
+		case 'C': // Close
+			r := msgReader{b: body}
+			kind, name := r.byte(), r.cstring()
+			if kind == 'S' {
+				delete(s.statements, name)
+			} else {
+				delete(s.portals, name)
+			}
+		}
+		return nil
+	})
+}
+
+// copyBackend forwards the messages that the upstream sends on src to
+// the client on dst, recording the results of the queries noted by
+// copyFrontend.
+func (s *sessionAudit) copyBackend(dst io.Writer, src io.Reader) error {
+	return copyMessages(dst, src, auditedBackendMessages, maxAuditedBackendMessageLen, func(typ byte, body []byte, truncated bool) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var head *pendingQuery
+		if len(s.pending) > 0 {
+			head = s.pending[0]
+		}
+		switch typ {
+		case 'C', 'I', 's': // CommandComplete, EmptyQueryResponse, PortalSuspended
+			if head == nil || head.isSync {
+				return nil
+			}
+			if typ == 'C' {
+				tag, _, _ := bytes.Cut(body, []byte{0})
+				head.ev.Commands = append(head.ev.Commands, string(tag))
+				head.ev.Rows += rowCount(string(tag))
+			}
+			if head.ev.Extended {
+				return s.finishHeadLocked()
+			}
+		case 'E': // ErrorResponse
+			if head == nil || head.isSync {
+				return nil
+			}
+			head.ev.Error = (&pgError{body: body}).message()
+			if head.ev.Extended {
+				return s.finishHeadLocked()
+			}
+		case 'Z': // ReadyForQuery
+			// The upstream is done with everything up to and
+			// including the Query or Sync that it answered.
+			for len(s.pending) > 0 {
+				q := s.pending[0]
+				if !q.isSync && q.ev.Error == "" && len(q.ev.Commands) == 0 && q.ev.Extended {
+					q.ev.Error = "not executed due to an earlier error"
+				}
+				if err := s.finishHeadLocked(); err != nil {
+					return err
+				}
+				if q.isSync || !q.ev.Extended {
+					break
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// push notes that q was sent upstream.
+func (s *sessionAudit) push(q *pendingQuery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, q)
+}
+
+// finishHeadLocked records the first pending query, if it is a
+// query, and removes it from the pending queue.
+func (s *sessionAudit) finishHeadLocked() error {
+	q := s.pending[0]
+	s.pending = s.pending[1:]
+	if q.isSync {
+		return nil
+	}
+	q.ev.DurationMillis = time.Since(q.ev.Time).Milliseconds()
+	return s.write(&q.ev)
+}
+
+// queryEvent returns a query event for the query text q, sent now.
+func (s *sessionAudit) queryEvent(q string, extended bool) auditEvent {
+	ev := s.base
+	ev.Type = "query"
+	ev.Time = time.Now()
+	ev.Query = q
+	ev.Extended = extended
+	return ev
+}
+
+// bind records the portal created by a Bind message.
+func (s *sessionAudit) bind(body []byte) {
+	r := msgReader{b: body}
+	name := r.cstring()
+	p := &portal{statement: s.statements[r.cstring()]}
+	p.numParams, p.params = s.readParams(&r)
+	s.portals[name] = p
+}
+
+// readParams reads the format codes and values of the parameters of a
+// Bind message, or of the arguments of a FunctionCall message, from r.
+// It returns the number of parameters, and their values if the auditor
+// includes params and they could all be read.
+func (s *sessionAudit) readParams(r *msgReader) (n int, params []*string) {
+	formats := make([]int16, max(r.int16(), 0))
+	for i := range formats {
+		formats[i] = r.int16()
+	}
+	n = int(r.int16())
+	if !s.a.includeParams {
+		return n, nil
+	}
+	for i := range n {
+		size := r.int32()
+		if size < 0 {
+			params = append(params, nil)
+			continue
+		}
+		v := r.bytes(int(size))
+		isBinary := len(formats) == 1 && formats[0] == 1 || len(formats) > i && formats[i] == 1
+		var str string
+		if isBinary {
+			str = `\x` + hex.EncodeToString(v)
+		} else {
+			str = string(v)
+		}
+		params = append(params, &str)
+	}
+	if r.err {
+		return n, nil
+	}
+	return n, params
+}
+
+// rowCount returns the number of rows in a CommandComplete tag such as
+// "SELECT 5" or "INSERT 0 1".
+func rowCount(tag string) int64 {
+	f := strings.Fields(tag)
+	if len(f) < 2 {
+		return 0
+	}
+	n, _ := strconv.ParseInt(f[len(f)-1], 10, 64)
+	return n
+}
+
+// copyMessages copies protocol messages from src to dst. It calls
+// handle with the type and body of each message whose type is in
+// audited before forwarding it. If the body is larger than maxLen,
+// handle only gets its first maxLen bytes, and truncated is true.
+// Messages of other types are forwarded as they arrive, without being
+// buffered. It returns nil when src is closed between messages.
+func copyMessages(dst io.Writer, src io.Reader, audited string, maxLen int, handle func(typ byte, body []byte, truncated bool) error) error {
+	br := bufio.NewReader(src)
+	bw := bufio.NewWriter(dst)
+	var hdr [5]byte
+	for {
+		if br.Buffered() == 0 {
+			// Don't hold back data while waiting for more.
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+		}
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			if err == io.EOF {
+				return bw.Flush()
+			}
+			return err
+		}
+		n := binary.BigEndian.Uint32(hdr[1:])
+		if n < 4 {
+			return fmt.Errorf("invalid length %d for message type %q", n, hdr[0])
+		}
+		n -= 4
+		if strings.IndexByte(audited, hdr[0]) < 0 {
+			if _, err := bw.Write(hdr[:]); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(bw, br, int64(n)); err != nil {
+				return err
+			}
+			continue
+		}
+		body := make([]byte, min(int64(n), int64(maxLen)))
+		if _, err := io.ReadFull(br, body); err != nil {
+			return err
+		}
+		rest := int64(n) - int64(len(body))
+		if err := handle(hdr[0], body, rest > 0); err != nil {
+			return err
+		}
+		if _, err := bw.Write(hdr[:]); err != nil {
+			return err
+		}
+		if _, err := bw.Write(body); err != nil {
+			return err
+		}
+		if rest > 0 {
+			if _, err := io.CopyN(bw, br, rest); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// msgReader reads the fields of a protocol message body. Reads past
+// the end of the body return zero values and set err.
+type msgReader struct {
+	b   []byte
+	err bool
+}
+
+func (r *msgReader) cstring() string {
+	s, rest, ok := bytes.Cut(r.b, []byte{0})
+	if !ok {
+		r.err = true
+		r.b = nil
+		return ""
+	}
+	r.b = rest
+	return string(s)
+}
+
+func (r *msgReader) bytes(n int) []byte {
+	if n > len(r.b) {
+		r.err = true
+		r.b = nil
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *msgReader) byte() byte {
+	if v := r.bytes(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (r *msgReader) int16() int16 {
+	if v := r.bytes(2); v != nil {
+		return int16(binary.BigEndian.Uint16(v))
+	}
+	return 0
+}
+
+func (r *msgReader) int32() int32 {
+	if v := r.bytes(4); v != nil {
+		return int32(binary.BigEndian.Uint32(v))
+	}
+	return 0
+}
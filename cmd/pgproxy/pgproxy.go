@@ -12,6 +12,8 @@
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
@@ -20,6 +22,7 @@
 	"math/big"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"strings"
 	"time"
@@ -37,6 +40,13 @@
 	upstreamAddr = flag.String("upstream-addr", "", "Address of the upstream Postgres server, in host:port format")
 	upstreamCA   = flag.String("upstream-ca-file", "", "File containing the PEM-encoded CA certificate for the upstream server")
 	tailscaleDir = flag.String("state-dir", "", "Directory in which to store the Tailscale auth state")
+	policyFile   = flag.String("policy-file", "", "If set, HuJSON file with grants of postgres roles and databases to tailnet users and tags. Implies --require-grant.")
+	requireGrant = flag.Bool("require-grant", false, "Only allow sessions permitted by a grant in the --policy-file or the "+string(aclCap)+" peer capability")
+	auditLog     = flag.String("audit-log", "", "If set, file to append a JSON audit log of sessions and their queries to")
+	auditRecs    = flag.String("audit-recorders", "", "If set, comma-separated ip:port addresses of tsrecorder instances to upload the audit log of each session to. Sessions are refused if no recorder is reachable.")
+	auditParams  = flag.Bool("audit-params", false, "Include the values of query parameters in the audit log, instead of only their number")
+	auditMaxLen  = flag.Int("audit-max-query-len", defaultMaxAuditedQueryLen, "Largest query, in bytes, to record in full in the audit log; longer ones are truncated. Bounds the memory buffered for each client message.")
+	upstreamCred = flag.String("upstream-credentials-file", "", "If set, JSON file mapping postgres role names to the passwords to authenticate to the upstream with on behalf of clients. Requires --policy-file or --require-grant.")
 )
 
 func main() {
@@ -53,6 +63,12 @@ func main() {
 	if *tailscaleDir == "" {
 		log.Fatal("missing --state-dir")
 	}
+	if *upstreamCred != "" && *policyFile == "" && !*requireGrant {
+		log.Fatal("--upstream-credentials-file requires --policy-file or --require-grant")
+	}
+	if *auditMaxLen <= 0 {
+		log.Fatal("--audit-max-query-len must be positive")
+	}
 
 	ts := &tsnet.Server{
 		Dir:      *tailscaleDir,
@@ -72,6 +88,28 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	p.requireGrant = *requireGrant || *policyFile != ""
+	if *policyFile != "" {
+		if p.policy, err = loadPolicy(*policyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *upstreamCred != "" {
+		if p.upstreamCreds, err = loadUpstreamCredentials(*upstreamCred); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *auditLog != "" || *auditRecs != "" {
+		var recs []netip.AddrPort
+		if *auditRecs != "" {
+			if recs, err = parseRecorders(*auditRecs); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if p.audit, err = newAuditor(*auditLog, recs, ts.Dial, *auditParams, *auditMaxLen); err != nil {
+			log.Fatal(err)
+		}
+	}
 	expvar.Publish("pgproxy", p.Expvar())
 
 	if *debugPort != 0 {
@@ -107,6 +145,13 @@ type proxy struct {
 	downstreamCert   []tls.Certificate
 	client           *local.Client
 
+	// requireGrant is whether sessions must be allowed by one of
+	// the grants in policy or the aclCap peer capability.
+	requireGrant  bool
+	policy        *accessPolicy     // or nil
+	upstreamCreds map[string]string // role => upstream password
+	audit         *auditor          // or nil
+
 	activeSessions  expvar.Int
 	startedSessions expvar.Int
 	errors          metrics.LabelMap
@@ -186,10 +231,6 @@ func (p *proxy) Serve(ln net.Listener) error {
 	// that they want to do a TLS handshake. Servers should respond with
 	// the single byte "S" before starting a normal TLS handshake.
 	sslStart = [8]byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}
-	// plaintextStart is the magic bytes that postgres clients use to
-	// indicate that they're starting a plaintext authentication
-	// handshake.
-	plaintextStart = [8]byte{0, 0, 0, 86, 0, 3, 0, 0}
 )
 
 // serve proxies the postgres client on c to the proxy's upstream,
@@ -239,15 +280,76 @@ func (p *proxy) serve(sessionID int64, c net.Conn) error {
 		p.errors.Add("network-error", 1)
 		return fmt.Errorf("initial magic read: %v", err)
 	}
-	var clientIsTLS bool
-	switch {
-	case buf == sslStart:
-		clientIsTLS = true
-	case buf == plaintextStart:
-		clientIsTLS = false
-	default:
+
+	// Accept the client conn and set it up the way the client wants.
+	var clientConn net.Conn = c
+	if buf == sslStart {
+		io.WriteString(c, "S") // yeah, we're good to speak TLS
+		s := tls.Server(c, &tls.Config{
+			ServerName:   p.upstreamHost,
+			Certificates: p.downstreamCert,
+			MinVersion:   tls.VersionTLS12,
+		})
+		if err = s.HandshakeContext(ctx); err != nil {
+			p.errors.Add("client-tls", 1)
+			return fmt.Errorf("client TLS handshake: %v", err)
+		}
+		clientConn = s
+		if _, err := io.ReadFull(clientConn, buf[:]); err != nil {
+			p.errors.Add("network-error", 1)
+			return fmt.Errorf("startup message read: %v", err)
+		}
+	}
+	startup, err := readStartupMessage(clientConn, buf)
+	if err != nil {
 		p.errors.Add("client-bad-protocol", 1)
-		return fmt.Errorf("unrecognized initial packet = % 02x", buf)
+		return err
+	}
+
+	role := startup.get("user")
+	if p.requireGrant {
+		grants, err := p.grantsFor(whois)
+		if err != nil {
+			p.errors.Add("bad-grant", 1)
+			writeErrorResponse(clientConn, "28000", "invalid tailnet grants")
+			return err
+		}
+		requested, database := role, startup.get("database")
+		role, err = authorize(grants, startup)
+		if err != nil {
+			p.errors.Add("access-denied", 1)
+			writeErrorResponse(clientConn, "28000", fmt.Sprintf("tailnet user %s is not permitted to connect as role %q to database %q", user, requested, database))
+			return fmt.Errorf("denied connecting as role %q to database %q", requested, database)
+		}
+		if role != requested {
+			log.Printf("%d: connecting as role %q instead of requested role %q", sessionID, role, requested)
+		}
+	}
+
+	var sa *sessionAudit
+	if p.audit != nil {
+		database := startup.get("database")
+		if database == "" {
+			database = role
+		}
+		sa, err = p.audit.startSession(ctx, auditEvent{
+			SessionID: sessionID,
+			User:      user,
+			Machine:   machine,
+			Role:      role,
+			Database:  database,
+		}, whois.Node)
+		if err != nil {
+			p.errors.Add("audit-failed", 1)
+			writeErrorResponse(clientConn, "08004", "proxy failed to start session audit log")
+			return fmt.Errorf("starting audit log: %v", err)
+		}
+		defer func() {
+			if err := sa.end(); err != nil {
+				p.errors.Add("audit-failed", 1)
+				log.Printf("%d: ending audit log: %v", sessionID, err)
+			}
+		}()
 	}
 
 	// Dial & verify upstream connection.
@@ -282,38 +384,51 @@ func (p *proxy) serve(sessionID int64, c net.Conn) error {
 		return fmt.Errorf("upstream TLS handshake: %v", err)
 	}
 
-	// Accept the client conn and set it up the way the client wants.
-	var clientConn net.Conn
-	if clientIsTLS {
-		io.WriteString(c, "S") // yeah, we're good to speak TLS
-		s := tls.Server(c, &tls.Config{
-			ServerName:   p.upstreamHost,
-			Certificates: p.downstreamCert,
-			MinVersion:   tls.VersionTLS12,
-		})
-		if err = uptc.HandshakeContext(ctx); err != nil {
-			p.errors.Add("client-tls", 1)
-			return fmt.Errorf("client TLS handshake: %v", err)
+	if password, ok := p.upstreamCreds[role]; ok {
+		// Authenticate to the upstream ourselves, so that the
+		// client never needs to know the role's password.
+		if err := authenticateUpstream(uptc, startup, password); err != nil {
+			p.errors.Add("upstream-auth", 1)
+			var pgErr *pgError
+			if errors.As(err, &pgErr) {
+				writeMessage(clientConn, 'E', pgErr.body)
+			} else {
+				writeErrorResponse(clientConn, "08006", "proxy failed to authenticate to upstream")
+			}
+			return fmt.Errorf("upstream authentication as role %q: %v", role, err)
 		}
-		clientConn = s
-	} else {
-		// Repeat the header we read earlier up to the server.
-		if _, err := uptc.Write(plaintextStart[:]); err != nil {
+		// The upstream follows its AuthenticationOk with the rest
+		// of the session startup, which the client receives below.
+		if err := writeMessage(clientConn, 'R', binary.BigEndian.AppendUint32(nil, authOK)); err != nil {
 			p.errors.Add("network-error", 1)
-			return fmt.Errorf("sending initial client bytes to upstream: %v", err)
+			return fmt.Errorf("sending AuthenticationOk to client: %v", err)
 		}
-		clientConn = c
+	} else if _, err := uptc.Write(startup.marshal()); err != nil {
+		p.errors.Add("network-error", 1)
+		return fmt.Errorf("sending startup message to upstream: %v", err)
 	}
 
 	// Finally, proxy the client to the upstream.
+	toUpstream := func() error {
+		_, err := io.Copy(uptc, clientConn)
+		return err
+	}
+	toClient := func() error {
+		_, err := io.Copy(clientConn, uptc)
+		return err
+	}
+	if sa != nil {
+		// Parse the messages in both directions to record the
+		// queries and their results.
+		toUpstream = func() error { return sa.copyFrontend(uptc, clientConn) }
+		toClient = func() error { return sa.copyBackend(clientConn, uptc) }
+	}
 	errc := make(chan error, 1)
 	go func() {
-		_, err := io.Copy(uptc, clientConn)
-		errc <- err
+		errc <- toUpstream()
 	}()
 	go func() {
-		_, err := io.Copy(clientConn, uptc)
-		errc <- err
+		errc <- toClient()
 	}()
 	if err := <-errc; err != nil {
 		// Don't increment error counts here, because the most common
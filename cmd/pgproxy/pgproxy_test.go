@@ -0,0 +1,425 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartupMessageRoundTrip(t *testing.T) {
+	m := &startupMessage{params: []startupParam{
+		{"user", "alice"},
+		{"database", "app"},
+		{"application_name", "psql"},
+	}}
+	b := m.marshal()
+	var hdr [8]byte
+	copy(hdr[:], b)
+	got, err := readStartupMessage(bytes.NewReader(b[8:]), hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("got %+v, want %+v", got, m)
+	}
+
+	if _, err := readStartupMessage(bytes.NewReader(nil), [8]byte{0, 0, 0, 16, 0x04, 0xd2, 0x16, 0x2e}); err == nil {
+		t.Error("got nil error for cancel request")
+	}
+	if _, err := parseStartupMessage([]byte("user\x00alice")); err == nil {
+		t.Error("got nil error for unterminated message")
+	}
+}
+
+func TestGrantApplies(t *testing.T) {
+	g := accessGrant{Src: []string{"alice@example.com", "tag:analytics"}}
+	tests := []struct {
+		loginName string
+		tags      []string
+		want      bool
+	}{
+		{loginName: "alice@example.com", want: true},
+		{loginName: "Alice@Example.com", want: true},
+		{loginName: "bob@example.com", want: false},
+		{tags: []string{"tag:web", "tag:analytics"}, want: true},
+		{tags: []string{"tag:web"}, want: false},
+	}
+	for _, tt := range tests {
+		if got := grantApplies(g, tt.loginName, tt.tags); got != tt.want {
+			t.Errorf("grantApplies(%q, %q) = %v, want %v", tt.loginName, tt.tags, got, tt.want)
+		}
+	}
+	if !grantApplies(accessGrant{Src: []string{"*"}}, "bob@example.com", nil) {
+		t.Error("wildcard grant does not apply")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	grants := []accessGrant{
+		{Roles: []string{"reader", "writer"}, Databases: []string{"app"}},
+		{AsRole: "analyst", Databases: []string{"warehouse"}},
+		{Roles: []string{"*"}, Databases: []string{"scratch"}},
+	}
+	tests := []struct {
+		name       string
+		params     []startupParam
+		wantRole   string
+		wantParams []startupParam
+		wantErr    bool
+	}{
+		{
+			name:       "allowed_role",
+			params:     []startupParam{{"user", "reader"}, {"database", "app"}},
+			wantRole:   "reader",
+			wantParams: []startupParam{{"user", "reader"}, {"database", "app"}},
+		},
+		{
+			name:    "role_not_granted",
+			params:  []startupParam{{"user", "postgres"}, {"database", "app"}},
+			wantErr: true,
+		},
+		{
+			name:    "database_not_granted",
+			params:  []startupParam{{"user", "reader"}, {"database", "billing"}},
+			wantErr: true,
+		},
+		{
+			name:       "rewritten_role",
+			params:     []startupParam{{"user", "alice"}, {"database", "warehouse"}},
+			wantRole:   "analyst",
+			wantParams: []startupParam{{"user", "analyst"}, {"database", "warehouse"}},
+		},
+		{
+			name:       "rewritten_role_pins_default_database",
+			params:     []startupParam{{"user", "warehouse"}},
+			wantRole:   "analyst",
+			wantParams: []startupParam{{"user", "analyst"}, {"database", "warehouse"}},
+		},
+		{
+			name:       "any_role",
+			params:     []startupParam{{"user", "postgres"}, {"database", "scratch"}},
+			wantRole:   "postgres",
+			wantParams: []startupParam{{"user", "postgres"}, {"database", "scratch"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &startupMessage{params: tt.params}
+			role, err := authorize(grants, m)
+			if tt.wantErr {
+				if !errors.Is(err, errAccessDenied) {
+					t.Fatalf("got error %v, want %v", err, errAccessDenied)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if role != tt.wantRole {
+				t.Errorf("got role %q, want %q", role, tt.wantRole)
+			}
+			if !reflect.DeepEqual(m.params, tt.wantParams) {
+				t.Errorf("got params %v, want %v", m.params, tt.wantParams)
+			}
+		})
+	}
+}
+
+// fakeUpstream runs the server side of password authentication for
+// the StartupMessage it reads from c, and reports the result on c.
+func fakeUpstream(t *testing.T, c net.Conn, method uint32, password string) {
+	defer c.Close()
+	var hdr [8]byte
+	if _, err := io.ReadFull(c, hdr[:]); err != nil {
+		t.Error(err)
+		return
+	}
+	m, err := readStartupMessage(c, hdr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	authRequest := func(code uint32, data string) {
+		writeMessage(c, 'R', append(binary.BigEndian.AppendUint32(nil, code), data...))
+	}
+	readPassword := func() string {
+		typ, body, err := readMessage(c)
+		if err != nil || typ != 'p' {
+			t.Errorf("reading password message: %q, %v", typ, err)
+		}
+		return string(body)
+	}
+	ok := false
+	switch method {
+	case authCleartextPassword:
+		authRequest(authCleartextPassword, "")
+		ok = readPassword() == password+"\x00"
+	case authMD5Password:
+		salt := "salt"
+		authRequest(authMD5Password, salt)
+		ok = readPassword() == md5Password(m.get("user"), password, []byte(salt))+"\x00"
+	case authSASL:
+		authRequest(authSASL, "SCRAM-SHA-256-PLUS\x00SCRAM-SHA-256\x00\x00")
+		resp := readPassword()
+		mech, rest, _ := strings.Cut(resp, "\x00")
+		if mech != scramSHA256 {
+			t.Errorf("got mechanism %q", mech)
+			return
+		}
+		clientFirstBare := strings.TrimPrefix(rest[4:], "n,,")
+		nonce := scramAttrs(clientFirstBare)["r"] + "server"
+		salt := []byte("0123456789abcdef")
+		serverFirst := "r=" + nonce + ",s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+		authRequest(authSASLContinue, serverFirst)
+
+		clientFinal := readPassword()
+		withoutProof, proof64, _ := strings.Cut(clientFinal, ",p=")
+		proof, _ := base64.StdEncoding.DecodeString(proof64)
+		salted, _ := pbkdf2.Key(sha256.New, password, salt, 4096, sha256.Size)
+		storedKey := sha256.Sum256(hmacSHA256(salted, "Client Key"))
+		authMessage := clientFirstBare + "," + serverFirst + "," + withoutProof
+		clientKey := hmacSHA256(storedKey[:], authMessage)
+		for i := range clientKey {
+			clientKey[i] ^= proof[i]
+		}
+		if sha256.Sum256(clientKey) == storedKey {
+			ok = true
+			authRequest(authSASLFinal, "v="+base64.StdEncoding.EncodeToString(hmacSHA256(hmacSHA256(salted, "Server Key"), authMessage)))
+		}
+	}
+	if ok {
+		authRequest(authOK, "")
+	} else {
+		writeErrorResponse(c, "28P01", "password authentication failed")
+	}
+}
+
+func TestAuthenticateUpstream(t *testing.T) {
+	methods := map[string]uint32{
+		"cleartext": authCleartextPassword,
+		"md5":       authMD5Password,
+		"scram":     authSASL,
+	}
+	for name, method := range methods {
+		t.Run(name, func(t *testing.T) {
+			m := &startupMessage{params: []startupParam{{"user", "analyst"}, {"database", "warehouse"}}}
+			for _, password := range []string{"correct", "wrong"} {
+				client, server := net.Pipe()
+				go fakeUpstream(t, server, method, "correct")
+				err := authenticateUpstream(client, m, password)
+				client.Close()
+				if password == "correct" && err != nil {
+					t.Errorf("authenticating with correct password: %v", err)
+				}
+				var pgErr *pgError
+				if password == "wrong" && !errors.As(err, &pgErr) {
+					t.Errorf("authenticating with wrong password: got %v, want upstream error", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionAudit(t *testing.T) {
+	for _, includeParams := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		a, err := newAuditor(path, nil, nil, includeParams, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sa, err := a.startSession(context.Background(), auditEvent{
+			SessionID: 1,
+			User:      "alice@example.com",
+			Machine:   "laptop",
+			Role:      "reader",
+			Database:  "app",
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cstr := func(s string) []byte { return append([]byte(s), 0) }
+		var frontend bytes.Buffer
+		writeMessage(&frontend, 'Q', cstr("SELECT 1; SELECT * FROM t"))
+		parse := append(cstr(""), cstr("SELECT * FROM users WHERE id = $1 AND name = $2")...)
+		writeMessage(&frontend, 'P', append(parse, 0, 0))
+		bind := append(cstr(""), cstr("")...)
+		bind = append(bind, 0, 0, 0, 2)                   // no format codes, 2 params
+		bind = append(bind, 0, 0, 0, 2, '4', '2')         // "42"
+		bind = append(bind, 0xff, 0xff, 0xff, 0xff, 0, 0) // NULL, no result formats
+		writeMessage(&frontend, 'B', bind)
+		writeMessage(&frontend, 'E', append(cstr(""), 0, 0, 0, 0))
+		writeMessage(&frontend, 'E', append(cstr(""), 0, 0, 0, 0))
+		writeMessage(&frontend, 'S', nil)
+		writeMessage(&frontend, 'Q', cstr("DROP TABLE users"))
+		call := []byte{0, 0, 0x04, 0xd2}                      // function OID 1234
+		call = append(call, 0, 0, 0, 1, 0, 0, 0, 2, 'h', 'i') // no format codes, 1 arg: "hi"
+		writeMessage(&frontend, 'F', append(call, 0, 0))
+
+		var backend bytes.Buffer
+		writeMessage(&backend, 'C', cstr("SELECT 1"))
+		writeMessage(&backend, 'C', cstr("SELECT 3"))
+		writeMessage(&backend, 'Z', []byte{'I'})
+		writeMessage(&backend, '1', nil) // ParseComplete
+		writeMessage(&backend, '2', nil) // BindComplete
+		writeMessage(&backend, 'D', []byte{0, 0})
+		writeMessage(&backend, 'E', []byte("SERROR\x00C42P01\x00Mrelation \"users\" does not exist\x00\x00"))
+		writeMessage(&backend, 'Z', []byte{'I'})
+		writeMessage(&backend, 'E', []byte("SERROR\x00C42501\x00Mpermission denied\x00\x00"))
+		writeMessage(&backend, 'Z', []byte{'I'})
+		writeMessage(&backend, 'V', []byte{0xff, 0xff, 0xff, 0xff}) // NULL result
+		writeMessage(&backend, 'Z', []byte{'I'})
+
+		wantFrontend, wantBackend := frontend.Bytes(), backend.Bytes()
+		var gotFrontend, gotBackend bytes.Buffer
+		if err := sa.copyFrontend(&gotFrontend, bytes.NewReader(wantFrontend)); err != nil {
+			t.Fatal(err)
+		}
+		if err := sa.copyBackend(&gotBackend, bytes.NewReader(wantBackend)); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(gotFrontend.Bytes(), wantFrontend) || !bytes.Equal(gotBackend.Bytes(), wantBackend) {
+			t.Error("messages were not forwarded unchanged")
+		}
+		if err := sa.end(); err != nil {
+			t.Fatal(err)
+		}
+
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []auditEvent
+		for line := range strings.Lines(string(bs)) {
+			var ev auditEvent
+			if err := json.Unmarshal([]byte(line), &ev); err != nil {
+				t.Fatal(err)
+			}
+			if ev.User != "alice@example.com" || ev.Role != "reader" {
+				t.Errorf("event is missing session fields: %+v", ev)
+			}
+			ev.Time, ev.DurationMillis = time.Time{}, 0
+			ev.SessionID, ev.User, ev.Machine, ev.Role, ev.Database = 0, "", "", "", ""
+			got = append(got, ev)
+		}
+		var params, args []*string
+		if includeParams {
+			params = []*string{new("42"), nil}
+			args = []*string{new("hi")}
+		}
+		want := []auditEvent{
+			{Type: "session-start"},
+			{Type: "query", Query: "SELECT 1; SELECT * FROM t", Commands: []string{"SELECT 1", "SELECT 3"}, Rows: 4},
+			{Type: "query", Query: "SELECT * FROM users WHERE id = $1 AND name = $2", Extended: true, NumParams: 2, Params: params, Error: `relation "users" does not exist`},
+			{Type: "query", Query: "SELECT * FROM users WHERE id = $1 AND name = $2", Extended: true, NumParams: 2, Params: params, Error: "not executed due to an earlier error"},
+			{Type: "query", Query: "DROP TABLE users", Error: "permission denied"},
+			{Type: "query", Function: 1234, NumParams: 1, Params: args},
+			{Type: "session-end"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("includeParams=%v: got events\n%+v\nwant\n%+v", includeParams, got, want)
+		}
+	}
+}
+
+func TestSessionAuditLargeQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	const maxQueryLen = 1000
+	a, err := newAuditor(path, nil, nil, false, maxQueryLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sa, err := a.startSession(context.Background(), auditEvent{SessionID: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := "SELECT '" + strings.Repeat("x", maxQueryLen) + "'"
+	var frontend, backend bytes.Buffer
+	writeMessage(&frontend, 'Q', append([]byte(q), 0))
+	writeMessage(&backend, 'C', []byte("SELECT 1\x00"))
+	writeMessage(&backend, 'Z', []byte{'I'})
+
+	var gotFrontend bytes.Buffer
+	if err := sa.copyFrontend(&gotFrontend, bytes.NewReader(frontend.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotFrontend.Bytes(), frontend.Bytes()) {
+		t.Error("query was not forwarded unchanged")
+	}
+	if err := sa.copyBackend(io.Discard, &backend); err != nil {
+		t.Fatal(err)
+	}
+	if err := sa.end(); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := slices.Collect(strings.Lines(string(bs)))
+	if len(lines) != 3 {
+		t.Fatalf("got %d audit events, want 3", len(lines))
+	}
+	var ev auditEvent
+	if err := json.Unmarshal([]byte(lines[1]), &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Query != q[:maxQueryLen] || !ev.Truncated {
+		t.Errorf("got query of %d bytes, truncated %v; want the first %d bytes, truncated", len(ev.Query), ev.Truncated, maxQueryLen)
+	}
+	if !reflect.DeepEqual(ev.Commands, []string{"SELECT 1"}) {
+		t.Errorf("got commands %q", ev.Commands)
+	}
+}
+
+func TestCopyMessages(t *testing.T) {
+	var src bytes.Buffer
+	writeMessage(&src, 'D', bytes.Repeat([]byte("d"), 100)) // DataRow, not audited
+	writeMessage(&src, 'C', []byte("SELECT 1\x00"))
+	writeMessage(&src, 'E', bytes.Repeat([]byte("e"), 100))
+	want := bytes.Clone(src.Bytes())
+
+	type msg struct {
+		typ       byte
+		body      string
+		truncated bool
+	}
+	var got []msg
+	var dst bytes.Buffer
+	err := copyMessages(&dst, &src, "CE", 10, func(typ byte, body []byte, truncated bool) error {
+		got = append(got, msg{typ, string(body), truncated})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Bytes(), want) {
+		t.Error("messages were not forwarded unchanged")
+	}
+	wantMsgs := []msg{
+		{'C', "SELECT 1\x00", false},
+		{'E', "eeeeeeeeee", true},
+	}
+	if !reflect.DeepEqual(got, wantMsgs) {
+		t.Errorf("handled %+v; want %+v", got, wantMsgs)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/tailscale/hujson"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// aclCap is the Tailscale ACL capability used to grant tailnet users
+// and tags access to postgres roles and databases through the proxy.
+const aclCap tailcfg.PeerCapability = "tailscale.com/cap/pgproxy"
+
+// accessGrant allows clients to open postgres sessions as certain
+// roles to certain databases. Grants come from the aclCap peer
+// capability and from the --policy-file.
+type accessGrant struct {
+	// Src lists the tailnet users (by login name) and tags that the
+	// grant applies to, or "*" for everyone. It is only used in the
+	// policy file: capability grants apply to the peers they are
+	// granted to.
+	Src []string `json:"src,omitempty"`
+	// Roles lists the roles that clients may request, or "*" for any
+	// role.
+	Roles []string `json:"roles,omitempty"`
+	// Databases lists the databases that clients may connect to, or
+	// "*" for any database.
+	Databases []string `json:"databases,omitempty"`
+	// AsRole, if non-empty, is the role that sessions are opened as,
+	// regardless of the role that the client requested.
+	AsRole string `json:"asRole,omitempty"`
+}
+
+// accessPolicy is the contents of the --policy-file.
+type accessPolicy struct {
+	Grants []accessGrant `json:"grants"`
+}
+
+// loadPolicy reads the HuJSON access policy at path.
+func loadPolicy(path string) (*accessPolicy, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	bs, err = hujson.Standardize(bs)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", path, err)
+	}
+	var pol accessPolicy
+	if err := json.Unmarshal(bs, &pol); err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", path, err)
+	}
+	for i, g := range pol.Grants {
+		if len(g.Src) == 0 {
+			return nil, fmt.Errorf("grant %d in %q has no src", i, path)
+		}
+		if len(g.Databases) == 0 {
+			return nil, fmt.Errorf("grant %d in %q has no databases", i, path)
+		}
+		if len(g.Roles) == 0 && g.AsRole == "" {
+			return nil, fmt.Errorf("grant %d in %q has neither roles nor asRole", i, path)
+		}
+	}
+	return &pol, nil
+}
+
+// loadUpstreamCredentials reads the JSON file at path, which maps
+// postgres role names to the passwords the proxy uses to
+// authenticate to the upstream as those roles.
+func loadUpstreamCredentials(path string) (map[string]string, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var creds map[string]string
+	if err := json.Unmarshal(bs, &creds); err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", path, err)
+	}
+	return creds, nil
+}
+
+// grantsFor returns the grants that apply to the client identified by
+// whois, policy file grants first.
+func (p *proxy) grantsFor(whois *apitype.WhoIsResponse) ([]accessGrant, error) {
+	var loginName string
+	var tags []string
+	if whois.Node != nil && whois.Node.IsTagged() {
+		tags = whois.Node.Tags
+	} else if whois.UserProfile != nil {
+		loginName = whois.UserProfile.LoginName
+	}
+
+	var grants []accessGrant
+	if p.policy != nil {
+		for _, g := range p.policy.Grants {
+			if grantApplies(g, loginName, tags) {
+				grants = append(grants, g)
+			}
+		}
+	}
+	capGrants, err := tailcfg.UnmarshalCapJSON[accessGrant](whois.CapMap, aclCap)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s grants: %w", aclCap, err)
+	}
+	for _, g := range capGrants {
+		g.Src = nil
+		grants = append(grants, g)
+	}
+	return grants, nil
+}
+
+// grantApplies reports whether the policy file grant g applies to the
+// user loginName or, for tagged devices, to a device with tags.
+func grantApplies(g accessGrant, loginName string, tags []string) bool {
+	for _, src := range g.Src {
+		switch {
+		case src == "*":
+			return true
+		case strings.HasPrefix(src, "tag:"):
+			if slices.Contains(tags, src) {
+				return true
+			}
+		case loginName != "" && strings.EqualFold(src, loginName):
+			return true
+		}
+	}
+	return false
+}
+
+// errAccessDenied is returned by authorize when no grant allows the
+// requested session.
+var errAccessDenied = errors.New("access denied")
+
+// authorize applies the first of grants that allows a session for the
+// requested role to the requested database, which may be empty, and
+// rewrites m accordingly. It returns the role that the session must be
+// opened as, or errAccessDenied.
+func authorize(grants []accessGrant, m *startupMessage) (role string, err error) {
+	requested := m.get("user")
+	database := m.get("database")
+	if database == "" {
+		// Postgres connects to the database named after the
+		// requested role by default.
+		database = requested
+	}
+	for _, g := range grants {
+		if !matchesAny(g.Databases, database) {
+			continue
+		}
+		switch {
+		case g.AsRole != "":
+			role = g.AsRole
+		case requested != "" && matchesAny(g.Roles, requested):
+			role = requested
+		default:
+			continue
+		}
+		if role != requested {
+			// Pin the database, which would otherwise default
+			// to the rewritten role's name.
+			m.set("database", database)
+			m.set("user", role)
+		}
+		return role, nil
+	}
+	return "", errAccessDenied
+}
+
+// matchesAny reports whether v is in patterns, or patterns contains
+// "*".
+func matchesAny(patterns []string, v string) bool {
+	return slices.Contains(patterns, "*") || (v != "" && slices.Contains(patterns, v))
+}
@@ -0,0 +1,378 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// This file implements the parts of the postgres wire protocol that
+// the proxy needs to understand: the client's StartupMessage, and
+// password authentication to the upstream on the client's behalf.
+// See https://www.postgresql.org/docs/current/protocol-message-formats.html.
+
+const (
+	// protocolVersion3 is the protocol version in the StartupMessage
+	// of all current postgres clients.
+	protocolVersion3 = 3 << 16
+	// maxStartupMessageLen is the largest StartupMessage that
+	// postgres itself accepts.
+	maxStartupMessageLen = 10000
+	// maxMessageLen is the largest upstream message the proxy reads
+	// while authenticating to the upstream.
+	maxMessageLen = 1 << 20
+
+	// Authentication request codes sent by the server.
+	authOK                = 0
+	authCleartextPassword = 3
+	authMD5Password       = 5
+	authSASL              = 10
+	authSASLContinue      = 11
+	authSASLFinal         = 12
+
+	scramSHA256 = "SCRAM-SHA-256"
+)
+
+// startupMessage is a postgres StartupMessage.
+type startupMessage struct {
+	// params are the session parameters requested by the client,
+	// such as "user" and "database", in the order the client sent
+	// them.
+	params []startupParam
+}
+
+type startupParam struct {
+	key, value string
+}
+
+// readStartupMessage reads the rest of the StartupMessage whose first
+// 8 bytes, the length and protocol version, are hdr.
+func readStartupMessage(r io.Reader, hdr [8]byte) (*startupMessage, error) {
+	n := binary.BigEndian.Uint32(hdr[:4])
+	if v := binary.BigEndian.Uint32(hdr[4:]); v != protocolVersion3 {
+		return nil, fmt.Errorf("unrecognized initial packet = % 02x", hdr)
+	}
+	if n < 8 || n > maxStartupMessageLen {
+		return nil, fmt.Errorf("invalid startup message length %d", n)
+	}
+	body := make([]byte, n-8)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return parseStartupMessage(body)
+}
+
+// parseStartupMessage parses the parameters of a StartupMessage. body
+// is the message with its length and protocol version removed.
+func parseStartupMessage(body []byte) (*startupMessage, error) {
+	m := &startupMessage{}
+	for {
+		key, rest, ok := bytes.Cut(body, []byte{0})
+		if !ok {
+			return nil, errors.New("unterminated startup message")
+		}
+		if len(key) == 0 {
+			return m, nil
+		}
+		value, rest, ok := bytes.Cut(rest, []byte{0})
+		if !ok {
+			return nil, fmt.Errorf("missing value for startup parameter %q", key)
+		}
+		m.params = append(m.params, startupParam{string(key), string(value)})
+		body = rest
+	}
+}
+
+// get returns the value of the session parameter key, or "" if the
+// client didn't set it.
+func (m *startupMessage) get(key string) string {
+	for _, p := range m.params {
+		if p.key == key {
+			return p.value
+		}
+	}
+	return ""
+}
+
+// set sets the session parameter key to value.
+func (m *startupMessage) set(key, value string) {
+	for i, p := range m.params {
+		if p.key == key {
+			m.params[i].value = value
+			return
+		}
+	}
+	m.params = append(m.params, startupParam{key, value})
+}
+
+// marshal returns the wire encoding of m.
+func (m *startupMessage) marshal() []byte {
+	b := make([]byte, 8, 64)
+	for _, p := range m.params {
+		b = append(b, p.key...)
+		b = append(b, 0)
+		b = append(b, p.value...)
+		b = append(b, 0)
+	}
+	b = append(b, 0)
+	binary.BigEndian.PutUint32(b[:4], uint32(len(b)))
+	binary.BigEndian.PutUint32(b[4:8], protocolVersion3)
+	return b
+}
+
+// readMessage reads a single typed protocol message from r.
+func readMessage(r io.Reader) (typ byte, body []byte, err error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n < 4 || n > maxMessageLen {
+		return 0, nil, fmt.Errorf("invalid length %d for message type %q", n, hdr[0])
+	}
+	body = make([]byte, n-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], body, nil
+}
+
+// writeMessage writes a single typed protocol message to w.
+func writeMessage(w io.Writer, typ byte, body []byte) error {
+	b := make([]byte, 5, 5+len(body))
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:], uint32(4+len(body)))
+	_, err := w.Write(append(b, body...))
+	return err
+}
+
+// writeErrorResponse sends a fatal ErrorResponse with the given
+// SQLSTATE code and message to a client.
+func writeErrorResponse(w io.Writer, code, msg string) error {
+	var b []byte
+	for _, f := range []struct {
+		typ byte
+		val string
+	}{
+		{'S', "FATAL"},
+		{'V', "FATAL"},
+		{'C', code},
+		{'M', msg},
+	} {
+		b = append(b, f.typ)
+		b = append(b, f.val...)
+		b = append(b, 0)
+	}
+	return writeMessage(w, 'E', append(b, 0))
+}
+
+// pgError is an ErrorResponse sent by the upstream.
+type pgError struct {
+	body []byte // the ErrorResponse message body, to relay to the client
+}
+
+func (e *pgError) Error() string {
+	return "upstream error: " + e.message()
+}
+
+// message returns the primary human-readable message of the error.
+func (e *pgError) message() string {
+	fields := e.body
+	for len(fields) > 1 {
+		typ := fields[0]
+		val, rest, _ := bytes.Cut(fields[1:], []byte{0})
+		if typ == 'M' {
+			return string(val)
+		}
+		fields = rest
+	}
+	return "unknown error"
+}
+
+// authenticateUpstream starts a session on the upstream connection rw
+// with the StartupMessage m, and answers the upstream's password
+// challenge for the role in m with password. It returns once the
+// upstream has sent AuthenticationOk. If the upstream rejects the
+// session, the returned error is a *pgError.
+func authenticateUpstream(rw io.ReadWriter, m *startupMessage, password string) error {
+	if _, err := rw.Write(m.marshal()); err != nil {
+		return err
+	}
+	var scram *scramClient
+	for {
+		typ, body, err := readMessage(rw)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case 'E':
+			return &pgError{body: body}
+		case 'R':
+		default:
+			return fmt.Errorf("unexpected message type %q during authentication", typ)
+		}
+		if len(body) < 4 {
+			return errors.New("short authentication request")
+		}
+		code, data := binary.BigEndian.Uint32(body[:4]), body[4:]
+		switch code {
+		case authOK:
+			if scram != nil && !scram.verified {
+				return errors.New("upstream completed SCRAM authentication without proving it knows the password")
+			}
+			return nil
+		case authCleartextPassword:
+			err = writeMessage(rw, 'p', append([]byte(password), 0))
+		case authMD5Password:
+			if len(data) != 4 {
+				return errors.New("invalid MD5 salt")
+			}
+			err = writeMessage(rw, 'p', append([]byte(md5Password(m.get("user"), password, data)), 0))
+		case authSASL:
+			mechs := strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+			if !slices.Contains(mechs, scramSHA256) {
+				return fmt.Errorf("upstream offered no supported SASL mechanism, got %q", mechs)
+			}
+			scram, err = newSCRAMClient(password)
+			if err != nil {
+				return err
+			}
+			first := scram.clientFirst()
+			resp := append([]byte(scramSHA256), 0)
+			resp = binary.BigEndian.AppendUint32(resp, uint32(len(first)))
+			err = writeMessage(rw, 'p', append(resp, first...))
+		case authSASLContinue:
+			if scram == nil {
+				return errors.New("unexpected SASLContinue")
+			}
+			var final string
+			if final, err = scram.clientFinal(string(data)); err != nil {
+				return err
+			}
+			err = writeMessage(rw, 'p', []byte(final))
+		case authSASLFinal:
+			if scram == nil {
+				return errors.New("unexpected SASLFinal")
+			}
+			err = scram.verifyServerFinal(string(data))
+		default:
+			return fmt.Errorf("unsupported authentication method %d", code)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// md5Password returns the response to an MD5 password challenge.
+func md5Password(user, password string, salt []byte) string {
+	inner := md5.Sum([]byte(password + user))
+	outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+	return "md5" + hex.EncodeToString(outer[:])
+}
+
+// scramClient is the client side of a SCRAM-SHA-256 exchange, as
+// described in RFC 5802 and RFC 7677, without channel binding.
+type scramClient struct {
+	password        string
+	nonce           string
+	clientFirstBare string
+	serverSignature []byte // set by clientFinal
+	verified        bool   // whether the server proved its knowledge of the password
+}
+
+func newSCRAMClient(password string) (*scramClient, error) {
+	var b [18]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	return &scramClient{
+		password: password,
+		nonce:    base64.StdEncoding.EncodeToString(b[:]),
+	}, nil
+}
+
+// clientFirst returns the client-first-message. Postgres takes the
+// user name from the StartupMessage, so it is left empty here.
+func (c *scramClient) clientFirst() string {
+	c.clientFirstBare = "n=,r=" + c.nonce
+	return "n,," + c.clientFirstBare
+}
+
+// clientFinal returns the client-final-message in response to
+// the server-first-message serverFirst.
+func (c *scramClient) clientFinal(serverFirst string) (string, error) {
+	attrs := scramAttrs(serverFirst)
+	nonce, salt64, iters := attrs["r"], attrs["s"], attrs["i"]
+	if !strings.HasPrefix(nonce, c.nonce) || len(nonce) == len(c.nonce) {
+		return "", errors.New("invalid SCRAM server nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil {
+		return "", fmt.Errorf("invalid SCRAM salt: %w", err)
+	}
+	n, err := strconv.Atoi(iters)
+	if err != nil || n < 1 {
+		return "", fmt.Errorf("invalid SCRAM iteration count %q", iters)
+	}
+	salted, err := pbkdf2.Key(sha256.New, c.password, salt, n, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	clientKey := hmacSHA256(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	withoutProof := "c=biws,r=" + nonce // biws is base64("n,,")
+	authMessage := c.clientFirstBare + "," + serverFirst + "," + withoutProof
+	proof := hmacSHA256(storedKey[:], authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	c.serverSignature = hmacSHA256(hmacSHA256(salted, "Server Key"), authMessage)
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// verifyServerFinal checks the server-final-message.
+func (c *scramClient) verifyServerFinal(serverFinal string) error {
+	attrs := scramAttrs(serverFinal)
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("SCRAM authentication failed: %s", e)
+	}
+	sig, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil || !hmac.Equal(sig, c.serverSignature) {
+		return errors.New("invalid SCRAM server signature")
+	}
+	c.verified = true
+	return nil
+}
+
+// scramAttrs parses the comma-separated attributes of a SCRAM message.
+func scramAttrs(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for attr := range strings.SplitSeq(msg, ",") {
+		if k, v, ok := strings.Cut(attr, "="); ok {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
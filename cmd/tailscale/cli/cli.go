@@ -218,6 +218,7 @@ func noDupFlagify(c *ffcli.Command) {
 	maybeServeCmd,
 	maybeCertCmd,
 	maybeUpdateCmd,
+	maybeSSHRecordingsCmd,
 	_ func() *ffcli.Command
 )
 
@@ -259,6 +260,7 @@ func newRootCmd() *ffcli.Command {
 			pingCmd,
 			ncCmd,
 			sshCmd,
+			nilOrCall(maybeSSHRecordingsCmd),
 			nilOrCall(maybeFunnelCmd),
 			nilOrCall(maybeServeCmd),
 			versionCmd,
@@ -9,9 +9,12 @@
 	"bytes"
 	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"net/netip"
 	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/net/udprelay/status"
@@ -24,12 +27,45 @@ func init() {
 func mkDebugPeerRelaySessionsCmd() *ffcli.Command {
 	return &ffcli.Command{
 		Name:       "peer-relay-sessions",
-		ShortUsage: "tailscale debug peer-relay-sessions",
+		ShortUsage: "tailscale debug peer-relay-sessions\ntailscale debug peer-relay-sessions close <VNI>",
 		Exec:       runPeerRelaySessions,
 		ShortHelp:  "Print the current set of active peer relay sessions relayed through this node",
+		LongHelp: strings.TrimSpace(`
+Print the current set of active peer relay sessions relayed through this node,
+or tear one down with 'close'.
+
+The resources committed to peer relay sessions can be limited with the
+following 'tailscale set' flags (zero means no limit), and the limits in effect
+are printed with the sessions:
+
+  --relay-server-max-sessions            maximum number of sessions
+  --relay-server-max-sessions-per-node   maximum number of sessions per client
+  --relay-server-session-bytes-per-sec   maximum bytes/sec relayed per session
+  --relay-server-session-idle-timeout    idle time before a session is torn
+                                         down, like "2m" (default 5m)
+`),
+		Subcommands: []*ffcli.Command{
+			{
+				Name:       "close",
+				ShortUsage: "tailscale debug peer-relay-sessions close <VNI>",
+				ShortHelp:  "Tear down a peer relay session relayed through this node",
+				Exec:       runClosePeerRelaySession,
+			},
+		},
 	}
 }
 
+func runClosePeerRelaySession(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale debug peer-relay-sessions close <VNI>")
+	}
+	vni, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid VNI %q", args[0])
+	}
+	return localClient.ClosePeerRelaySession(ctx, uint32(vni))
+}
+
 func runPeerRelaySessions(ctx context.Context, args []string) error {
 	srv, err := localClient.DebugPeerRelaySessions(ctx)
 	if err != nil {
@@ -46,6 +82,18 @@ func runPeerRelaySessions(ctx context.Context, args []string) error {
 		f("%d", *srv.UDPPort)
 	}
 	f("\n")
+	if srv.UDPPort != nil {
+		fmtLimit := func(n int64) string {
+			if n <= 0 {
+				return "none"
+			}
+			return strconv.FormatInt(n, 10)
+		}
+		lim := srv.Limits
+		f("Limits: Sessions: %s Per node: %s Bytes/sec per session: %s Idle timeout: %v\n",
+			fmtLimit(int64(lim.MaxSessions)), fmtLimit(int64(lim.MaxSessionsPerNode)),
+			fmtLimit(lim.SessionBytesPerSec), lim.IdleTimeout)
+	}
 	f("Sessions count: %d\n", len(srv.Sessions))
 	if len(srv.Sessions) == 0 {
 		Stdout.Write(buf.Bytes())
@@ -59,10 +107,14 @@ func runPeerRelaySessions(ctx context.Context, args []string) error {
 			}
 			return "<no handshake>"
 		}
-		return fmt.Sprintf("%s(%s) --> %s(%s), Packets: %d Bytes: %d",
+		s := fmt.Sprintf("%s(%s) --> %s(%s), Packets: %d Bytes: %d",
 			fmtEndpoint(a.Endpoint), a.ShortDisco,
 			fmtEndpoint(z.Endpoint), z.ShortDisco,
 			a.PacketsTx, a.BytesTx)
+		if a.PacketsDropped > 0 {
+			s += fmt.Sprintf(" Dropped: %d", a.PacketsDropped)
+		}
+		return s
 	}
 
 	f("\n")
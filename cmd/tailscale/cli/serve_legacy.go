@@ -23,9 +23,11 @@
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
@@ -150,6 +152,7 @@ type localServeClient interface {
 	GetPrefs(ctx context.Context) (*ipn.Prefs, error)
 	EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error)
 	CheckSOMarkInUse(ctx context.Context) (bool, error)
+	ServeUpstreams(ctx context.Context) ([]apitype.ServeUpstreamStatus, error)
 }
 
 // serveEnv is the environment the serve command runs within. All I/O should be
@@ -177,6 +180,20 @@ type serveEnv struct {
 	allServices      bool                     // apply config file to all services
 	acceptAppCaps    []tailcfg.PeerCapability // app capabilities to forward
 
+	// Proxy handler options.
+	setRequestHeaders     map[string]string // headers to set on requests to the backend
+	removeRequestHeaders  []string          // headers to remove from requests to the backend
+	setResponseHeaders    map[string]string // headers to set on responses from the backend
+	removeResponseHeaders []string          // headers to remove from responses from the backend
+	stripPrefix           string            // path prefix to strip instead of the mount point
+	connectTimeout        time.Duration     // timeout connecting to the backend
+	responseTimeout       time.Duration     // timeout waiting for the backend's response
+	errorPage             string            // [<httpcode>:]file to serve when the backend fails
+	upstreams             []string          // additional backends to load balance over
+	lbPolicy              string            // ipn.LoadBalancing* policy
+	healthCheckPath       string            // path to health check backends on
+	healthCheckInterval   time.Duration     // interval between health checks
+
 	lc localServeClient // localClient interface, specific to serve
 	// optional stuff for tests:
 	testFlagOut io.Writer
@@ -604,6 +621,38 @@ func (e *serveEnv) handleTCPServeRemove(ctx context.Context, src uint16) error {
 	return errors.New("error: serve config does not exist")
 }
 
+// serveStatusJSON is the output of "serve status --json": the serve config,
+// plus the status of the backends of its Proxy handlers with upstreams, if
+// there are any.
+type serveStatusJSON struct {
+	*ipn.ServeConfig
+	UpstreamStatus []apitype.ServeUpstreamStatus `json:",omitempty"`
+}
+
+// hasUpstreams reports whether sc has any Proxy handlers with upstreams.
+func hasUpstreams(sc *ipn.ServeConfig) bool {
+	if sc == nil {
+		return false
+	}
+	webs := []map[ipn.HostPort]*ipn.WebServerConfig{sc.Web}
+	for _, svc := range sc.Services {
+		webs = append(webs, svc.Web)
+	}
+	for _, fg := range sc.Foreground {
+		webs = append(webs, fg.Web)
+	}
+	for _, web := range webs {
+		for _, conf := range web {
+			for _, h := range conf.Handlers {
+				if len(h.Upstreams) > 0 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 // runServeStatus is the entry point for the "serve status"
 // subcommand and prints the current serve config.
 //
@@ -619,7 +668,17 @@ func (e *serveEnv) runServeStatus(ctx context.Context, args []string) error {
 		return err
 	}
 	if e.json {
-		j, err := json.MarshalIndent(sc, "", "  ")
+		// Without upstreams, print the config as is, which is null if
+		// there's none.
+		var v any = sc
+		if hasUpstreams(sc) {
+			ups, err := e.lc.ServeUpstreams(ctx)
+			if err != nil {
+				return err
+			}
+			v = serveStatusJSON{ServeConfig: sc, UpstreamStatus: ups}
+		}
+		j, err := json.MarshalIndent(v, "", "  ")
 		if err != nil {
 			return err
 		}
@@ -714,7 +773,7 @@ func (e *serveEnv) printWebStatusTree(sc *ipn.ServeConfig, hp ipn.HostPort) erro
 		case h.Path != "":
 			return "path", h.Path
 		case h.Proxy != "":
-			return "proxy", h.Proxy
+			return "proxy", strings.Join(append([]string{h.Proxy}, h.Upstreams...), ", ")
 		case h.Text != "":
 			return "text", "\"" + elipticallyTruncate(h.Text, 20) + "\""
 		}
@@ -19,6 +19,7 @@
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
@@ -862,6 +863,7 @@ type fakeLocalServeClient struct {
 	prefs                *ipn.Prefs                // fake preferences, used to test GetPrefs and SetPrefs
 	SOMarkInUse          bool                      // fake SO mark in use status
 	statusWithoutPeers   *ipnstate.Status          // nil for fakeStatus
+	upstreams            []apitype.ServeUpstreamStatus
 }
 
 // fakeStatus is a fake ipnstate.Status value for tests.
@@ -942,6 +944,10 @@ func (lc *fakeLocalServeClient) CheckSOMarkInUse(ctx context.Context) (bool, err
 	return lc.SOMarkInUse, nil
 }
 
+func (lc *fakeLocalServeClient) ServeUpstreams(ctx context.Context) ([]apitype.ServeUpstreamStatus, error) {
+	return lc.upstreams, nil
+}
+
 // exactError returns an error checker that wants exactly the provided want error.
 // If optName is non-empty, it's used in the error message.
 func exactErr(want error, optName ...string) func(error) string {
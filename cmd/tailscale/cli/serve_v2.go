@@ -13,6 +13,7 @@
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"math"
 	"net"
 	"net/url"
@@ -134,6 +135,97 @@ func (u *acceptAppCapsFlag) String() string {
 	return strings.Join(s, ",")
 }
 
+// validHeaderName matches valid HTTP header field names (RFC 9110, section
+// 5.1).
+var validHeaderName = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// checkProxyHeaderName returns an error if name isn't a header that serve
+// may set or remove on proxied requests and responses.
+func checkProxyHeaderName(name string) error {
+	if !validHeaderName.MatchString(name) {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	if strings.HasPrefix(strings.ToLower(name), "tailscale-") {
+		return fmt.Errorf("cannot change Tailscale header %q", name)
+	}
+	return nil
+}
+
+// setHeaderFlag is a flag.Value for a repeatable flag that adds a
+// "Name: value" header to a map.
+type setHeaderFlag struct {
+	Value *map[string]string
+}
+
+// Set parses s as a "Name: value" header and adds it to the map.
+func (f *setHeaderFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("%q is not of the form \"Name: value\"", s)
+	}
+	name = strings.TrimSpace(name)
+	if err := checkProxyHeaderName(name); err != nil {
+		return err
+	}
+	mak.Set(f.Value, name, strings.TrimSpace(value))
+	return nil
+}
+
+// String returns the headers as a comma-separated list of "Name: value".
+func (f *setHeaderFlag) String() string {
+	var s []string
+	for _, k := range slices.Sorted(maps.Keys(*f.Value)) {
+		s = append(s, k+": "+(*f.Value)[k])
+	}
+	return strings.Join(s, ", ")
+}
+
+// upstreamsFlag is a flag.Value for a repeatable flag that appends
+// comma-separated proxy targets to a slice.
+type upstreamsFlag struct {
+	Value *[]string
+}
+
+// Set appends the proxy targets in s to the slice.
+func (f *upstreamsFlag) Set(s string) error {
+	for target := range strings.SplitSeq(s, ",") {
+		t, err := ipn.ExpandProxyTargetValue(strings.TrimSpace(target), []string{"http", "https", "https+insecure", "unix"}, "http")
+		if err != nil {
+			return err
+		}
+		*f.Value = append(*f.Value, t)
+	}
+	return nil
+}
+
+// String returns the proxy targets as a comma-separated list.
+func (f *upstreamsFlag) String() string {
+	return strings.Join(*f.Value, ",")
+}
+
+// removeHeaderFlag is a flag.Value for a repeatable flag that appends
+// comma-separated header names to a slice.
+type removeHeaderFlag struct {
+	Value *[]string
+}
+
+// Set appends the header names in s to the slice.
+func (f *removeHeaderFlag) Set(s string) error {
+	for name := range strings.SplitSeq(s, ",") {
+		name = strings.TrimSpace(name)
+		if err := checkProxyHeaderName(name); err != nil {
+			return err
+		}
+		*f.Value = append(*f.Value, name)
+	}
+	return nil
+}
+
+// String returns the header names as a comma-separated list.
+func (f *removeHeaderFlag) String() string {
+	return strings.Join(*f.Value, ",")
+}
+
 var serveHelpCommon = strings.TrimSpace(`
 <target> can be a file, directory, text, or most commonly the location to a service running on the
 local machine. The location to the location service can be expressed as a port number (e.g., 3000),
@@ -245,6 +337,18 @@ func newServeV2Command(e *serveEnv, subcmd serveMode) *ffcli.Command {
 				fs.Var(&serviceNameFlag{Value: &e.service}, "service", "Serve for a service with distinct virtual IP instead on node itself.")
 				fs.BoolVar(&e.tun, "tun", false, "Forward all traffic to the local machine (default false), only supported for services. Refer to docs for more information.")
 			}
+			fs.Var(&setHeaderFlag{Value: &e.setRequestHeaders}, "set-request-header", `Set a "Name: value" header on requests proxied to the server (can be repeated)`)
+			fs.Var(&removeHeaderFlag{Value: &e.removeRequestHeaders}, "remove-request-header", "Remove headers from requests proxied to the server (specify multiple headers with a comma-separated list)")
+			fs.Var(&setHeaderFlag{Value: &e.setResponseHeaders}, "set-response-header", `Set a "Name: value" header on responses from the server (can be repeated)`)
+			fs.Var(&removeHeaderFlag{Value: &e.removeResponseHeaders}, "remove-response-header", "Remove headers from responses from the server (specify multiple headers with a comma-separated list)")
+			fs.StringVar(&e.stripPrefix, "strip-prefix", "", `Path prefix to strip from requests proxied to the server instead of the --set-path mount point; "/" to strip nothing`)
+			fs.DurationVar(&e.connectTimeout, "connect-timeout", 0, "Timeout for connecting to the server for each proxied request (default none)")
+			fs.DurationVar(&e.responseTimeout, "response-timeout", 0, "Timeout for the server to start responding to each proxied request (default none)")
+			fs.StringVar(&e.errorPage, "error-page", "", `Absolute path of a file to serve when the server can't be reached or times out, optionally prefixed with an HTTP status code (e.g. "503:/srv/down.html")`)
+			fs.Var(&upstreamsFlag{Value: &e.upstreams}, "upstream", "Additional servers to spread proxied requests over, in the same forms as <target> (specify multiple servers with a comma-separated list)")
+			fs.StringVar(&e.lbPolicy, "lb-policy", "", `How to spread requests over the target and --upstream servers: "round-robin" or "least-conn" (default "round-robin")`)
+			fs.StringVar(&e.healthCheckPath, "health-check-path", "", "Path to health check the target and --upstream servers on; servers failing health checks get no requests (default no health checks)")
+			fs.DurationVar(&e.healthCheckInterval, "health-check-interval", 0, "Interval between health checks (default 10s)")
 			fs.UintVar(&e.tcp, "tcp", 0, "Expose a TCP forwarder to forward raw TCP packets at the specified port")
 			fs.UintVar(&e.tlsTerminatedTCP, "tls-terminated-tcp", 0, "Expose a TCP forwarder to forward TLS-terminated TCP packets at the specified port")
 			fs.UintVar(&e.proxyProtocol, "proxy-protocol", 0, "PROXY protocol version (1 or 2) for TCP forwarding")
@@ -923,6 +1027,9 @@ func (e *serveEnv) setServe(sc *ipn.ServeConfig, dnsName string, srvType serveTy
 		if e.setPath != "" {
 			return fmt.Errorf("cannot mount a path for TCP serve")
 		}
+		if e.hasProxyOptions() {
+			return fmt.Errorf("header, path prefix, timeout, error page and upstream options are not supported for TCP serve")
+		}
 		err := e.applyTCPServe(sc, dnsName, srvType, srvPort, target, mds, proxyProtocol)
 		if err != nil {
 			return fmt.Errorf("failed to apply TCP serve: %w", err)
@@ -1190,6 +1297,12 @@ func (e *serveEnv) applyWebServe(sc *ipn.ServeConfig, dnsName string, srvPort ui
 		}
 		h.Proxy = t
 		h.AcceptAppCaps = caps
+		if err := e.applyProxyOptions(h); err != nil {
+			return err
+		}
+	}
+	if h.Proxy == "" && e.hasProxyOptions() {
+		return errors.New("header, path prefix, timeout, error page and upstream options are only supported when proxying to a server")
 	}
 
 	// TODO: validation needs to check nested foreground configs
@@ -1203,6 +1316,72 @@ func (e *serveEnv) applyWebServe(sc *ipn.ServeConfig, dnsName string, srvPort ui
 	return nil
 }
 
+// hasProxyOptions reports whether any options for Proxy handlers were set.
+func (e *serveEnv) hasProxyOptions() bool {
+	return len(e.setRequestHeaders) > 0 || len(e.removeRequestHeaders) > 0 ||
+		len(e.setResponseHeaders) > 0 || len(e.removeResponseHeaders) > 0 ||
+		e.stripPrefix != "" || e.connectTimeout != 0 || e.responseTimeout != 0 ||
+		e.errorPage != "" || len(e.upstreams) > 0 || e.lbPolicy != "" ||
+		e.healthCheckPath != "" || e.healthCheckInterval != 0
+}
+
+// applyProxyOptions validates the options for Proxy handlers and sets them
+// on h.
+func (e *serveEnv) applyProxyOptions(h *ipn.HTTPHandler) error {
+	if e.stripPrefix != "" {
+		p, err := cleanURLPath(e.stripPrefix)
+		if err != nil {
+			return fmt.Errorf("invalid --strip-prefix: %w", err)
+		}
+		h.StripPrefix = p
+	}
+	if e.connectTimeout < 0 || e.responseTimeout < 0 {
+		return errors.New("timeouts must not be negative")
+	}
+	if e.errorPage != "" {
+		file := e.errorPage
+		if len(file) >= 4 && file[3] == ':' {
+			code, err := strconv.Atoi(file[:3])
+			if err != nil || code < 400 || code > 599 {
+				return fmt.Errorf("invalid --error-page status code %q; must be 4xx or 5xx", file[:3])
+			}
+			file = file[4:]
+		}
+		if !filepath.IsAbs(file) {
+			return fmt.Errorf("--error-page %q must be an absolute path", file)
+		}
+		if fi, err := os.Stat(file); err != nil || !fi.Mode().IsRegular() {
+			return fmt.Errorf("--error-page %q is not a file", file)
+		}
+	}
+	if len(e.upstreams) == 0 && (e.lbPolicy != "" || e.healthCheckPath != "" || e.healthCheckInterval != 0) {
+		return errors.New("--lb-policy and health check options require --upstream")
+	}
+	switch e.lbPolicy {
+	case "", ipn.LoadBalancingRoundRobin, ipn.LoadBalancingLeastConn:
+	default:
+		return fmt.Errorf("invalid --lb-policy %q; must be %q or %q", e.lbPolicy, ipn.LoadBalancingRoundRobin, ipn.LoadBalancingLeastConn)
+	}
+	if e.healthCheckPath != "" && !strings.HasPrefix(e.healthCheckPath, "/") {
+		return fmt.Errorf("--health-check-path %q must start with /", e.healthCheckPath)
+	}
+	if e.healthCheckInterval < 0 || e.healthCheckInterval != 0 && e.healthCheckPath == "" {
+		return errors.New("--health-check-interval must be positive and requires --health-check-path")
+	}
+	h.SetRequestHeaders = e.setRequestHeaders
+	h.RemoveRequestHeaders = e.removeRequestHeaders
+	h.SetResponseHeaders = e.setResponseHeaders
+	h.RemoveResponseHeaders = e.removeResponseHeaders
+	h.ConnectTimeout.Duration = e.connectTimeout
+	h.ResponseTimeout.Duration = e.responseTimeout
+	h.ErrorPage = e.errorPage
+	h.Upstreams = e.upstreams
+	h.LoadBalancing = e.lbPolicy
+	h.HealthCheckPath = e.healthCheckPath
+	h.HealthCheckInterval.Duration = e.healthCheckInterval
+	return nil
+}
+
 func (e *serveEnv) applyTCPServe(sc *ipn.ServeConfig, dnsName string, srcType serveType, srcPort uint16, target string, mds string, proxyProtocol int) error {
 	var terminateTLS bool
 	switch srcType {
@@ -7,6 +7,7 @@
 	"bytes"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"net/netip"
 	"os"
@@ -17,12 +18,15 @@
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/views"
 )
 
@@ -2087,6 +2091,186 @@ func TestSetServe(t *testing.T) {
 	}
 }
 
+func TestSetServeProxyOptions(t *testing.T) {
+	errorPage := filepath.Join(t.TempDir(), "error.html")
+	if err := os.WriteFile(errorPage, []byte("down"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	newEnv := func(t *testing.T, args ...string) *serveEnv {
+		t.Helper()
+		e := &serveEnv{}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Var(&setHeaderFlag{Value: &e.setRequestHeaders}, "set-request-header", "")
+		fs.Var(&removeHeaderFlag{Value: &e.removeRequestHeaders}, "remove-request-header", "")
+		fs.Var(&setHeaderFlag{Value: &e.setResponseHeaders}, "set-response-header", "")
+		fs.Var(&removeHeaderFlag{Value: &e.removeResponseHeaders}, "remove-response-header", "")
+		fs.StringVar(&e.stripPrefix, "strip-prefix", "", "")
+		fs.DurationVar(&e.connectTimeout, "connect-timeout", 0, "")
+		fs.DurationVar(&e.responseTimeout, "response-timeout", 0, "")
+		fs.StringVar(&e.errorPage, "error-page", "", "")
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	e := newEnv(t,
+		"--set-request-header", "X-Forwarded-Prefix: /app",
+		"--set-request-header", "Host:backend.internal",
+		"--remove-request-header", "Cookie,Authorization",
+		"--set-response-header", "Strict-Transport-Security: max-age=31536000",
+		"--remove-response-header", "Server",
+		"--strip-prefix", "/",
+		"--connect-timeout", "5s",
+		"--response-timeout", "1m",
+		"--error-page", "503:"+errorPage,
+	)
+	sc := &ipn.ServeConfig{}
+	if err := e.setServe(sc, "foo.test.ts.net", serveTypeHTTPS, 443, "/app", "http://localhost:3000", false, "test.ts.net", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	want := &ipn.HTTPHandler{
+		Proxy:                 "http://localhost:3000",
+		SetRequestHeaders:     map[string]string{"X-Forwarded-Prefix": "/app", "Host": "backend.internal"},
+		RemoveRequestHeaders:  []string{"Cookie", "Authorization"},
+		SetResponseHeaders:    map[string]string{"Strict-Transport-Security": "max-age=31536000"},
+		RemoveResponseHeaders: []string{"Server"},
+		StripPrefix:           "/",
+		ConnectTimeout:        tstime.GoDuration{Duration: 5 * time.Second},
+		ResponseTimeout:       tstime.GoDuration{Duration: time.Minute},
+		ErrorPage:             "503:" + errorPage,
+	}
+	got := sc.Web["foo.test.ts.net:443"].Handlers["/app"]
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("handler mismatch (-want +got):\n%s", diff)
+	}
+
+	// Options are rejected for anything but proxies.
+	e = newEnv(t, "--response-timeout", "1m")
+	if err := e.setServe(&ipn.ServeConfig{}, "foo.test.ts.net", serveTypeHTTPS, 443, "/", "text:hi", false, "test.ts.net", nil, 0); err == nil {
+		t.Error("options for text handler unexpectedly accepted")
+	}
+	if err := e.setServe(&ipn.ServeConfig{}, "foo.test.ts.net", serveTypeTCP, 443, "", "localhost:3000", false, "test.ts.net", nil, 0); err == nil {
+		t.Error("options for TCP forwarder unexpectedly accepted")
+	}
+
+	for _, bad := range [][]string{
+		{"--error-page", "relative.html"},
+		{"--error-page", "302:" + errorPage},
+		{"--error-page", filepath.Join(t.TempDir(), "missing.html")},
+		{"--strip-prefix", "/foo/../bar"},
+	} {
+		e := newEnv(t, bad...)
+		if err := e.setServe(&ipn.ServeConfig{}, "foo.test.ts.net", serveTypeHTTPS, 443, "/", "3000", false, "test.ts.net", nil, 0); err == nil {
+			t.Errorf("%q unexpectedly accepted", bad)
+		}
+	}
+
+	for _, bad := range []string{"no-colon", "Bad Name: x", "Tailscale-User-Login: x"} {
+		var m map[string]string
+		if err := (&setHeaderFlag{Value: &m}).Set(bad); err == nil {
+			t.Errorf("set header %q unexpectedly accepted", bad)
+		}
+	}
+	var names []string
+	if err := (&removeHeaderFlag{Value: &names}).Set("X-Ok,tailscale-user-name"); err == nil {
+		t.Error("removing Tailscale header unexpectedly accepted")
+	}
+}
+
+func TestSetServeUpstreams(t *testing.T) {
+	newEnv := func(t *testing.T, args ...string) *serveEnv {
+		t.Helper()
+		e := &serveEnv{}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Var(&upstreamsFlag{Value: &e.upstreams}, "upstream", "")
+		fs.StringVar(&e.lbPolicy, "lb-policy", "", "")
+		fs.StringVar(&e.healthCheckPath, "health-check-path", "", "")
+		fs.DurationVar(&e.healthCheckInterval, "health-check-interval", 0, "")
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	e := newEnv(t,
+		"--upstream", "3001,localhost:3002",
+		"--upstream", "unix:/tmp/app.sock",
+		"--lb-policy", "least-conn",
+		"--health-check-path", "/healthz",
+		"--health-check-interval", "5s",
+	)
+	sc := &ipn.ServeConfig{}
+	if err := e.setServe(sc, "foo.test.ts.net", serveTypeHTTPS, 443, "/", "3000", false, "test.ts.net", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	want := &ipn.HTTPHandler{
+		Proxy:               "http://127.0.0.1:3000",
+		Upstreams:           []string{"http://127.0.0.1:3001", "http://localhost:3002", "unix:/tmp/app.sock"},
+		LoadBalancing:       ipn.LoadBalancingLeastConn,
+		HealthCheckPath:     "/healthz",
+		HealthCheckInterval: tstime.GoDuration{Duration: 5 * time.Second},
+	}
+	got := sc.Web["foo.test.ts.net:443"].Handlers["/"]
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("handler mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, bad := range [][]string{
+		{"--lb-policy", "random", "--upstream", "3001"},
+		{"--lb-policy", "least-conn"},
+		{"--health-check-path", "/healthz"},
+		{"--health-check-path", "healthz", "--upstream", "3001"},
+		{"--health-check-interval", "5s", "--upstream", "3001"},
+	} {
+		e := newEnv(t, bad...)
+		if err := e.setServe(&ipn.ServeConfig{}, "foo.test.ts.net", serveTypeHTTPS, 443, "/", "3000", false, "test.ts.net", nil, 0); err == nil {
+			t.Errorf("%q unexpectedly accepted", bad)
+		}
+	}
+	if err := (&upstreamsFlag{Value: new([]string)}).Set("ftp://localhost:21"); err == nil {
+		t.Error("ftp upstream unexpectedly accepted")
+	}
+
+	// serve status --json includes the upstreams' status only if there are
+	// any.
+	lc := &fakeLocalServeClient{
+		config: sc,
+		upstreams: []apitype.ServeUpstreamStatus{
+			{HostPort: "foo.test.ts.net:443", Mount: "/", Backend: "http://127.0.0.1:3000", Healthy: true},
+		},
+	}
+	for _, tt := range []struct {
+		config *ipn.ServeConfig
+		want   bool
+	}{
+		{sc, true},
+		{&ipn.ServeConfig{TCP: map[uint16]*ipn.TCPPortHandler{443: {TCPForward: "localhost:22"}}}, false},
+		{nil, false},
+	} {
+		lc.config = tt.config
+		var stdout bytes.Buffer
+		e := &serveEnv{lc: lc, json: true, testStdout: &stdout}
+		if err := e.runServeStatus(context.Background(), nil); err != nil {
+			t.Fatal(err)
+		}
+		if tt.config == nil && stdout.String() != "null\n" {
+			t.Errorf("status without config = %q; want null", stdout.String())
+		}
+		var st serveStatusJSON
+		if err := json.Unmarshal(stdout.Bytes(), &st); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(st.UpstreamStatus) > 0; got != tt.want {
+			t.Errorf("status has upstreams = %v; want %v:\n%s", got, tt.want, stdout.String())
+		}
+		if diff := cmp.Diff(tt.config, st.ServeConfig); diff != "" {
+			t.Errorf("status config mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
 func TestUnsetServe(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -14,6 +14,7 @@
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/cmd/tailscale/cli/ffcomplete"
@@ -23,6 +24,7 @@
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/safesocket"
 	"tailscale.com/tsconst"
+	"tailscale.com/tstime"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/views"
 	"tailscale.com/util/set"
@@ -68,6 +70,13 @@ type setArgsT struct {
 	netfilterMode              string
 	relayServerPort            string
 	relayServerStaticEndpoints string
+	relayServerMaxSessions     int
+	relayServerMaxSessionsNode int
+	relayServerBytesPerSec     int64
+	relayServerIdleTimeout     time.Duration
+	sshRecordLocally           bool
+	sshRecordingMaxSize        int
+	sshRecordingMaxAge         time.Duration
 }
 
 func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
@@ -80,6 +89,9 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.BoolVar(&setArgs.exitNodeAllowLANAccess, "exit-node-allow-lan-access", false, "Allow direct access to the local network when routing traffic via an exit node")
 	setf.BoolVar(&setArgs.shieldsUp, "shields-up", false, "don't allow incoming connections")
 	setf.BoolVar(&setArgs.runSSH, "ssh", false, "run an SSH server, permitting access per tailnet admin's declared policy")
+	setf.BoolVar(&setArgs.sshRecordLocally, "ssh-record-locally", false, "record Tailscale SSH sessions to local disk when the tailnet policy doesn't send them to recorder nodes; see 'tailscale ssh-recordings'")
+	setf.IntVar(&setArgs.sshRecordingMaxSize, "ssh-recording-max-size", ipn.DefaultSSHRecordingMaxSizeMB, "total size in megabytes of local SSH session recordings, after which the oldest are deleted, or -1 for no limit")
+	setf.DurationVar(&setArgs.sshRecordingMaxAge, "ssh-recording-max-age", 0, "how long to keep local SSH session recordings (like \"2160h\"), or 0 to only delete them for size")
 	setf.StringVar(&setArgs.hostname, "hostname", "", "hostname to use instead of the one provided by the OS")
 	setf.StringVar(&setArgs.advertiseRoutes, "advertise-routes", "", "routes to advertise to other nodes (comma-separated, e.g. \"10.0.0.0/8,192.168.0.0/24\") or empty string to not advertise routes")
 	setf.BoolVar(&setArgs.advertiseDefaultRoute, "advertise-exit-node", false, "offer to be an exit node for internet traffic for the tailnet")
@@ -91,6 +103,10 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.BoolVar(&setArgs.sync, "sync", false, hidden+"actively sync configuration from the control plane (set to false only for network failure testing)")
 	setf.StringVar(&setArgs.relayServerPort, "relay-server-port", "", "UDP port number (0 will pick a random unused port) for the relay server to bind to, on all interfaces, or empty string to disable relay server functionality")
 	setf.StringVar(&setArgs.relayServerStaticEndpoints, "relay-server-static-endpoints", "", "static IP:port endpoints to advertise as candidates for relay connections (comma-separated, e.g. \"[2001:db8::1]:40000,192.0.2.1:40000\") or empty string to not advertise any static endpoints")
+	setf.IntVar(&setArgs.relayServerMaxSessions, "relay-server-max-sessions", 0, "maximum number of sessions the relay server relays at once, or 0 for no limit")
+	setf.IntVar(&setArgs.relayServerMaxSessionsNode, "relay-server-max-sessions-per-node", 0, "maximum number of sessions the relay server relays at once for any one node, or 0 for no limit")
+	setf.Int64Var(&setArgs.relayServerBytesPerSec, "relay-server-session-bytes-per-sec", 0, "maximum rate in bytes per second at which the relay server relays each session, or 0 for no limit")
+	setf.DurationVar(&setArgs.relayServerIdleTimeout, "relay-server-session-idle-timeout", 0, "how long a relayed session can be idle before the relay server tears it down (like \"2m\"), or 0 for the default of 5m")
 
 	ffcomplete.Flag(setf, "exit-node", func(args []string) ([]string, ffcomplete.ShellCompDirective, error) {
 		st, err := localClient.Status(context.Background())
@@ -162,8 +178,15 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 			AppConnector: ipn.AppConnectorPrefs{
 				Advertise: setArgs.advertiseConnector,
 			},
-			PostureChecking:     setArgs.reportPosture,
-			NoStatefulFiltering: opt.NewBool(!setArgs.statefulFiltering),
+			PostureChecking:               setArgs.reportPosture,
+			NoStatefulFiltering:           opt.NewBool(!setArgs.statefulFiltering),
+			RelayServerMaxSessions:        setArgs.relayServerMaxSessions,
+			RelayServerMaxSessionsPerNode: setArgs.relayServerMaxSessionsNode,
+			RelayServerSessionBytesPerSec: setArgs.relayServerBytesPerSec,
+			RelayServerSessionIdleTimeout: tstime.GoDuration{Duration: setArgs.relayServerIdleTimeout},
+			SSHRecordLocally:              setArgs.sshRecordLocally,
+			SSHRecordingMaxSizeMB:         setArgs.sshRecordingMaxSize,
+			SSHRecordingMaxAge:            tstime.GoDuration{Duration: setArgs.sshRecordingMaxAge},
 		},
 	}
 
@@ -240,6 +263,13 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 		}
 	}
 
+	if maskedPrefs.SSHRecordingMaxAgeSet && setArgs.sshRecordingMaxAge < 0 {
+		return errors.New("--ssh-recording-max-age must not be negative")
+	}
+	if setArgs.relayServerMaxSessions < 0 || setArgs.relayServerMaxSessionsNode < 0 || setArgs.relayServerBytesPerSec < 0 || setArgs.relayServerIdleTimeout < 0 {
+		return errors.New("relay server limits must not be negative")
+	}
+
 	if setArgs.relayServerPort != "" {
 		uport, err := strconv.ParseUint(setArgs.relayServerPort, 10, 16)
 		if err != nil {
@@ -0,0 +1,219 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_ssh
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/sessionrecording"
+)
+
+const (
+	sshRecordingsListUsage   = "tailscale ssh-recordings list [--json]"
+	sshRecordingsPlayUsage   = "tailscale ssh-recordings play [--speed=N] [--idle-limit=DURATION] <name>"
+	sshRecordingsExportUsage = "tailscale ssh-recordings export [--output=FILE] <name>"
+)
+
+func init() {
+	maybeSSHRecordingsCmd = sshRecordingsCmd
+}
+
+var sshRecordingsArgs struct {
+	json      bool
+	speed     float64
+	idleLimit time.Duration
+	output    string
+}
+
+func sshRecordingsCmd() *ffcli.Command {
+	return &ffcli.Command{
+		Name:      "ssh-recordings",
+		ShortHelp: "List, replay and export Tailscale SSH session recordings on this machine",
+		ShortUsage: strings.Join([]string{
+			sshRecordingsListUsage,
+			sshRecordingsPlayUsage,
+			sshRecordingsExportUsage,
+		}, "\n"),
+		LongHelp: strings.TrimSpace(`
+The 'tailscale ssh-recordings' commands work with the recordings of Tailscale
+SSH sessions to this machine that tailscaled stores on local disk. Sessions are
+recorded locally after 'tailscale set --ssh-record-locally' when the tailnet
+policy doesn't send recordings to a recorder node.
+
+The oldest recordings are deleted once they take more than the megabytes set
+with 'tailscale set --ssh-recording-max-size' (1024 by default; -1 for no
+limit), or are older than 'tailscale set --ssh-recording-max-age' (like
+"2160h"; unlimited by default).
+
+Each finished recording has an HMAC-SHA256 stored next to it, keyed with a
+secret that only tailscaled can read, which the commands check to detect
+recordings modified after they were written. This doesn't detect changes made
+by root, who can read the secret too.
+
+The commands require root or sudo, as recordings can contain anything shown in
+any session, including those as root.
+`),
+		Exec: func(ctx context.Context, args []string) error {
+			return flag.ErrHelp
+		},
+		Subcommands: []*ffcli.Command{
+			{
+				Name:       "list",
+				ShortUsage: sshRecordingsListUsage,
+				ShortHelp:  "List recordings, oldest first",
+				Exec:       runSSHRecordingsList,
+				FlagSet: func() *flag.FlagSet {
+					fs := newFlagSet("list")
+					fs.BoolVar(&sshRecordingsArgs.json, "json", false, "output in JSON format")
+					return fs
+				}(),
+			},
+			{
+				Name:       "play",
+				ShortUsage: sshRecordingsPlayUsage,
+				ShortHelp:  "Replay a recording in the terminal",
+				Exec:       runSSHRecordingsPlay,
+				FlagSet: func() *flag.FlagSet {
+					fs := newFlagSet("play")
+					fs.Float64Var(&sshRecordingsArgs.speed, "speed", 1, "playback speed multiplier")
+					fs.DurationVar(&sshRecordingsArgs.idleLimit, "idle-limit", 2*time.Second, "maximum pause between outputs, or 0 for no limit")
+					return fs
+				}(),
+			},
+			{
+				Name:       "export",
+				ShortUsage: sshRecordingsExportUsage,
+				ShortHelp:  "Export a recording in asciinema v2 format",
+				Exec:       runSSHRecordingsExport,
+				FlagSet: func() *flag.FlagSet {
+					fs := newFlagSet("export")
+					fs.StringVar(&sshRecordingsArgs.output, "output", "", "file to write to, instead of stdout")
+					return fs
+				}(),
+			},
+		},
+	}
+}
+
+func runSSHRecordingsList(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: %s", sshRecordingsListUsage)
+	}
+	recs, err := localClient.SSHRecordings(ctx)
+	if err != nil {
+		return err
+	}
+	if sshRecordingsArgs.json {
+		enc := json.NewEncoder(Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(recs)
+	}
+	if len(recs) == 0 {
+		outln("No SSH session recordings.")
+		return nil
+	}
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTARTED\tSESSION\tSIZE\tINTEGRITY")
+	for _, rec := range recs {
+		started, session := "-", "-"
+		if h := rec.Header; h != nil {
+			started = time.Unix(h.Timestamp, 0).Format(time.DateTime)
+			session = h.Title()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", rec.Name, started, session, rec.Size, rec.Integrity)
+	}
+	return w.Flush()
+}
+
+// openSSHRecording opens the named recording, warning if it doesn't
+// match its hash.
+func openSSHRecording(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, integrity, err := localClient.SSHRecording(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if integrity == sessionrecording.IntegrityModified {
+		errf("WARNING: recording %s doesn't match its hash; it was modified after it was recorded.\n", name)
+	}
+	return rc, nil
+}
+
+func runSSHRecordingsPlay(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", sshRecordingsPlayUsage)
+	}
+	if sshRecordingsArgs.speed <= 0 {
+		return errors.New("--speed must be positive")
+	}
+	rc, err := openSSHRecording(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	cr, err := sessionrecording.NewCastReader(rc)
+	if err != nil {
+		return err
+	}
+	var last float64
+	for {
+		e, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if e.Type != "o" {
+			continue
+		}
+		d := time.Duration((e.Time - last) / sshRecordingsArgs.speed * float64(time.Second))
+		last = e.Time
+		if limit := sshRecordingsArgs.idleLimit; limit > 0 && d > limit {
+			d = limit
+		}
+		if d > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(d):
+			}
+		}
+		io.WriteString(Stdout, e.Data)
+	}
+}
+
+func runSSHRecordingsExport(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", sshRecordingsExportUsage)
+	}
+	rc, err := openSSHRecording(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if sshRecordingsArgs.output == "" {
+		return sessionrecording.WriteAsciinema(Stdout, rc)
+	}
+	f, err := os.Create(sshRecordingsArgs.output)
+	if err != nil {
+		return err
+	}
+	if err := sessionrecording.WriteAsciinema(f, rc); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
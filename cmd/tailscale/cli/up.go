@@ -904,6 +904,13 @@ func init() {
 	addPrefFlagMapping("relay-server-port", "RelayServerPort")
 	addPrefFlagMapping("sync", "Sync")
 	addPrefFlagMapping("relay-server-static-endpoints", "RelayServerStaticEndpoints")
+	addPrefFlagMapping("relay-server-max-sessions", "RelayServerMaxSessions")
+	addPrefFlagMapping("relay-server-max-sessions-per-node", "RelayServerMaxSessionsPerNode")
+	addPrefFlagMapping("relay-server-session-bytes-per-sec", "RelayServerSessionBytesPerSec")
+	addPrefFlagMapping("relay-server-session-idle-timeout", "RelayServerSessionIdleTimeout")
+	addPrefFlagMapping("ssh-record-locally", "SSHRecordLocally")
+	addPrefFlagMapping("ssh-recording-max-size", "SSHRecordingMaxSizeMB")
+	addPrefFlagMapping("ssh-recording-max-age", "SSHRecordingMaxAge")
 }
 
 func addPrefFlagMapping(flagName string, prefNames ...string) {
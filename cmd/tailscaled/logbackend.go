@@ -0,0 +1,56 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_logtail
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"tailscale.com/logtail"
+	"tailscale.com/logtail/backend"
+)
+
+func init() {
+	hookRegisterLogBackendFlags.Set(registerLogBackendFlags)
+	hookNewLogBackend.Set(newLogBackend)
+}
+
+func registerLogBackendFlags() {
+	flag.StringVar(&args.logBackend, "log-backend", "", `optional FORMAT:URL of a self-hosted log collector to upload logs to instead of Tailscale, where FORMAT is "json", "ndjson", "loki", "elasticsearch" or "otlp" (e.g. "otlp:http://collector:4318/v1/logs")`)
+	flag.StringVar(&args.logSpoolDir, "log-spool-dir", "", "optional directory to keep logs in while --log-backend is unreachable; without --log-backend, logs are only kept there")
+	flag.Int64Var(&args.logSpoolMaxSize, "log-spool-max-size", backend.DefaultSpoolMaxSize, "maximum total size in bytes of the logs in --log-spool-dir, after which the oldest are deleted")
+	flag.DurationVar(&args.logSpoolMaxAge, "log-spool-max-age", 0, "if non-zero, how long to keep logs in --log-spool-dir")
+}
+
+// newLogBackend returns the backend to upload logs to from the --log-backend
+// and --log-spool-* flags, or nil to upload them to Tailscale.
+func newLogBackend() (logtail.Backend, error) {
+	var b logtail.Backend
+	if args.logBackend != "" {
+		hostname, _ := os.Hostname()
+		h, err := backend.Parse(args.logBackend, backend.Options{
+			ServiceName: "tailscaled",
+			Hostname:    hostname,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("--log-backend: %w", err)
+		}
+		b = h
+	}
+	if args.logSpoolDir != "" {
+		s, err := backend.NewSpool(args.logSpoolDir, backend.SpoolOptions{
+			Upstream: b,
+			MaxSize:  args.logSpoolMaxSize,
+			MaxAge:   args.logSpoolMaxAge,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("--log-spool-dir: %w", err)
+		}
+		b = s
+	}
+	return b, nil
+}
@@ -12,6 +12,7 @@
 	"net/netip"
 
 	"tailscale.com/tsd"
+	"tailscale.com/types/bools"
 	"tailscale.com/types/logger"
 	"tailscale.com/wgengine/netstack"
 )
@@ -69,6 +70,13 @@ func newNetstack(logf logger.Logf, sys *tsd.System, onlyNetstack bool) (tsd.Nets
 			}
 			return udpConn, nil
 		}
+		dialer.NetstackListenTCP = func(src netip.AddrPort) (net.Listener, error) {
+			ln, err := ns.ListenTCP(bools.IfElse(src.Addr().Is6(), "tcp6", "tcp4"), src.String())
+			if err != nil {
+				return nil, err
+			}
+			return ln, nil
+		}
 	}
 
 	return ns, nil
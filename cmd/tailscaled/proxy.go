@@ -9,12 +9,15 @@
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"os"
 	"strings"
 
 	"tailscale.com/feature"
@@ -31,6 +34,7 @@ func init() {
 
 func registerOutboundProxyFlags() {
 	flag.StringVar(&args.socksAddr, "socks5-server", "", `optional [ip]:port to run a SOCK5 server (e.g. "localhost:1080")`)
+	flag.StringVar(&args.socksUsersFile, "socks5-users", "", `optional path to a JSON file of SOCKS5 usernames to their "Password" and optional "Allow" list of allowed "host:ports" destinations`)
 	flag.StringVar(&args.httpProxyAddr, "outbound-http-proxy-listen", "", `optional [ip]:port to run an outbound HTTP proxy (e.g. "localhost:8080")`)
 }
 
@@ -84,7 +88,47 @@ func outboundProxyListen() proxyStartFunc {
 	return mkProxyStartFunc(socksListener, httpListener)
 }
 
+// socksUserConfig is the configuration of a SOCKS5 user in the
+// --socks5-users file, which is a JSON object keyed by username.
+type socksUserConfig struct {
+	Password string
+	// Allow, if non-nil, restricts the destinations the user may reach.
+	// See socks5.ParsePolicy for the syntax.
+	Allow []string
+}
+
+// loadSOCKSUsers reads the --socks5-users file at path.
+func loadSOCKSUsers(path string) (socks5.Users, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg map[string]socksUserConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	users := make(socks5.Users, len(cfg))
+	for name, uc := range cfg {
+		u := socks5.User{Password: uc.Password}
+		if uc.Allow != nil {
+			if u.Policy, err = socks5.ParsePolicy(uc.Allow...); err != nil {
+				return nil, fmt.Errorf("user %q: %w", name, err)
+			}
+		}
+		users[name] = u
+	}
+	return users, nil
+}
+
 func mkProxyStartFunc(socksListener, httpListener net.Listener) proxyStartFunc {
+	var socksUsers socks5.Users
+	if socksListener != nil && args.socksUsersFile != "" {
+		var err error
+		socksUsers, err = loadSOCKSUsers(args.socksUsersFile)
+		if err != nil {
+			log.Fatalf("SOCKS5 users: %v", err)
+		}
+	}
 	return func(logf logger.Logf, dialer *tsdial.Dialer) {
 		var addrs []string
 		if httpListener != nil {
@@ -96,8 +140,13 @@ func mkProxyStartFunc(socksListener, httpListener net.Listener) proxyStartFunc {
 		}
 		if socksListener != nil {
 			ss := &socks5.Server{
-				Logf:   logger.WithPrefix(logf, "socks5: "),
-				Dialer: dialer.UserDial,
+				Logf:    logger.WithPrefix(logf, "socks5: "),
+				Dialer:  dialer.UserDial,
+				Resolve: dialer.UserResolve,
+				Listen:  dialer.UserListen,
+			}
+			if socksUsers != nil {
+				ss.Authenticator = socksUsers
 			}
 			go func() {
 				log.Fatalf("SOCKS5 server exited: %v", ss.Serve(socksListener))
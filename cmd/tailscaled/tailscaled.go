@@ -63,6 +63,7 @@
 	"tailscale.com/version"
 	"tailscale.com/version/distro"
 	"tailscale.com/wgengine"
+	"tailscale.com/wgengine/netlog"
 	"tailscale.com/wgengine/router"
 )
 
@@ -125,9 +126,15 @@ func defaultPort() uint16 {
 	birdSocketPath      string
 	verbose             int
 	socksAddr           string // listen address for SOCKS5 server
+	socksUsersFile      string // path to JSON file of SOCKS5 users, or empty
 	httpProxyAddr       string // listen address for HTTP proxy server
 	disableLogs         bool
 	hardwareAttestation boolFlag
+	netLogSinks         string // comma-separated netlog.NewSink specs, or empty
+	logBackend          string // backend.Parse spec, or empty
+	logSpoolDir         string // directory to spool logs in, or empty
+	logSpoolMaxSize     int64
+	logSpoolMaxAge      time.Duration
 }
 
 var (
@@ -167,6 +174,12 @@ func shouldRunCLI() bool {
 	return false
 }
 
+// Log backend hooks
+var (
+	hookRegisterLogBackendFlags feature.Hook[func()]
+	hookNewLogBackend           feature.Hook[func() (logtail.Backend, error)]
+)
+
 // Outbound Proxy hooks
 var (
 	hookRegisterOutboundProxyFlags feature.Hook[func()]
@@ -196,7 +209,7 @@ func main() {
 	}
 	flag.StringVar(&args.tunname, "tun", defaultTunName(), `tunnel interface name; use "userspace-networking" (beta) to not use TUN`)
 	flag.Var(flagtype.PortValue(&args.port, defaultPort()), "port", "UDP port to listen on for WireGuard and peer-to-peer traffic; 0 means automatically select")
-	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
+	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'sqlite:<file>[#<namespace>]' to use a SQLite database, if built with the ts_sqlite tag; use 'mem:' to not store state and register as an ephemeral node; prefix with 'encfile:' to encrypt the state with the key in $TS_STATE_ENCRYPTION_KEY_FILE. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
 	if buildfeatures.HasTPM {
 		flag.Var(&args.encryptState, "encrypt-state", `encrypt the state file on disk; when not set encryption will be enabled if supported on this platform; uses TPM on Linux and Windows, on all other platforms this flag is not supported`)
 	}
@@ -208,6 +221,9 @@ func main() {
 	flag.BoolVar(&printVersion, "version", false, "print version information and exit")
 	flag.BoolVar(&args.disableLogs, "no-logs-no-support", false, "disable log uploads; this also disables any technical support")
 	flag.StringVar(&args.confFile, "config", "", "path to config file, or 'vm:user-data' to use the VM's user-data (EC2)")
+	if buildfeatures.HasNetLog {
+		flag.StringVar(&args.netLogSinks, "netlog-sinks", "", `comma-separated local destinations for network flow logs, which are written even if network logging isn't enabled by the control plane: "jsonl:PATH[?max-size=SIZE&max-files=N]" for rotating JSON Lines files, "ipfix:HOST:PORT" or "netflow9:HOST:PORT" for a flow collector, or "syslog:[udp://HOST:PORT|tcp://HOST:PORT]"`)
+	}
 	if buildfeatures.HasTPM {
 		flag.Var(&args.hardwareAttestation, "hardware-attestation", `use hardware-backed keys to bind node identity to this device when supported
 by the OS and hardware. Uses TPM 2.0 on Linux and Windows; SecureEnclave on
@@ -217,6 +233,9 @@ func main() {
 	if f, ok := hookRegisterOutboundProxyFlags.GetOk(); ok {
 		f()
 	}
+	if f, ok := hookRegisterLogBackendFlags.GetOk(); ok {
+		f()
+	}
 
 	if runtime.GOOS == "plan9" && os.Getenv("_NETSHELL_CHILD_") != "" {
 		os.Args = []string{"tailscaled", "be-child", "plan9-netshell"}
@@ -372,8 +391,8 @@ func ipnServerOpts() (o serverOptions) {
 
 	// If an absolute --state is provided but not --statedir, try to derive
 	// a state directory.
-	if o.VarRoot == "" && filepath.IsAbs(args.statepath) {
-		if dir := filepath.Dir(args.statepath); strings.EqualFold(filepath.Base(dir), "tailscale") {
+	if statePath := strings.TrimPrefix(args.statepath, store.EncryptedPrefix); o.VarRoot == "" && filepath.IsAbs(statePath) {
+		if dir := filepath.Dir(statePath); strings.EqualFold(filepath.Base(dir), "tailscale") {
 			o.VarRoot = dir
 		}
 	}
@@ -429,11 +448,18 @@ func run() (err error) {
 	var publicLogID logid.PublicID
 	if buildfeatures.HasLogTail {
 
+		var backend logtail.Backend
+		if f, ok := hookNewLogBackend.GetOk(); ok {
+			if backend, err = f(); err != nil {
+				return err
+			}
+		}
 		pol := logpolicy.Options{
 			Collection: logtail.CollectionNode,
 			NetMon:     netMon,
 			Health:     sys.HealthTracker.Get(),
 			Bus:        sys.Bus.Get(),
+			Backend:    backend,
 		}.New()
 		pol.SetVerbosityLevel(args.verbose)
 		publicLogID = pol.PublicID
@@ -753,6 +779,19 @@ func tryEngine(logf logger.Logf, sys *tsd.System, name string) (onlyNetstack boo
 	if f, ok := hookSetWgEnginConfigDrive.GetOk(); ok {
 		f(&conf, logf)
 	}
+	if buildfeatures.HasNetLog && args.netLogSinks != "" {
+		conf.NetLogSinks, err = netlog.ParseSinks(args.netLogSinks)
+		if err != nil {
+			return false, fmt.Errorf("--netlog-sinks: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				for _, s := range conf.NetLogSinks {
+					s.Close()
+				}
+			}
+		}()
+	}
 
 	sys.HealthTracker.Get().SetMetricsRegistry(sys.UserMetricsRegistry())
 
@@ -8,14 +8,18 @@
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/hmac"
 	crand "crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
@@ -23,6 +27,7 @@
 	"fmt"
 	"io"
 	"log"
+	"math/big"
 	"net"
 	"net/http"
 	"net/netip"
@@ -79,6 +84,7 @@ type ctxConn struct{
 	flagFunnel                        = flag.Bool("funnel", false, "use Tailscale Funnel to make tsidp available on the public internet")
 	flagHostname                      = flag.String("hostname", "idp", "tsnet hostname to use instead of idp")
 	flagDir                           = flag.String("dir", "", "tsnet state directory; a default one will be created if not provided")
+	flagRequirePKCE                   = flag.Bool("require-pkce", false, "require PKCE (S256) for public clients, which have no client secret")
 	flagAllowInsecureRegistrationBool opt.Bool
 	flagAllowInsecureRegistration     = opt.BoolFlag{Bool: &flagAllowInsecureRegistrationBool}
 )
@@ -198,6 +204,7 @@ func main() {
 		localTSMode:               *flagUseLocalTailscaled,
 		rootPath:                  rootPath,
 		allowInsecureRegistration: getAllowInsecureRegistration(),
+		requirePKCE:               *flagRequirePKCE,
 	}
 
 	if *flagPort != 443 {
@@ -342,15 +349,23 @@ type idpServer struct {
 	localTSMode               bool
 	rootPath                  string // root path, used for storing state files
 	allowInsecureRegistration bool   // If true, allow OAuth without pre-registered clients
+	requirePKCE               bool   // If true, public clients must use PKCE
 
 	lazyMux        lazy.SyncValue[*http.ServeMux]
 	lazySigningKey lazy.SyncValue[*signingKey]
 	lazySigner     lazy.SyncValue[jose.Signer]
 
-	mu            sync.Mutex               // guards the fields below
-	code          map[string]*authRequest  // keyed by random hex
-	accessToken   map[string]*authRequest  // keyed by random hex
-	funnelClients map[string]*funnelClient // keyed by client ID
+	// lazyLogoutCSRFKey is the key of the CSRF tokens that the end_session
+	// confirmation page's form must be submitted with. See logoutCSRFToken.
+	lazyLogoutCSRFKey lazy.SyncValue[[]byte]
+
+	mu            sync.Mutex                    // guards the fields below
+	code          map[string]*authRequest       // keyed by random hex
+	accessToken   map[string]*authRequest       // keyed by random hex
+	refreshToken  map[string]*authRequest       // keyed by random hex
+	deviceCode    map[string]*deviceAuthRequest // keyed by random hex
+	userCode      map[string]*deviceAuthRequest // keyed by user code in canonical form
+	funnelClients map[string]*funnelClient      // keyed by client ID
 }
 
 type authRequest struct {
@@ -372,12 +387,21 @@ type authRequest struct {
 	// clientID is the "client_id" sent in the authorized request.
 	clientID string
 
+	// audience is the client that a token from a token exchange is meant
+	// for, if one was requested. The token still belongs to the client
+	// that did the exchange, which is clientID.
+	audience string
+
 	// nonce presented in the request.
 	nonce string
 
 	// redirectURI is the redirect_uri presented in the request.
 	redirectURI string
 
+	// codeChallenge is the S256 PKCE code challenge presented in the
+	// request, if any.
+	codeChallenge string
+
 	// remoteUser is the user who is being authenticated.
 	remoteUser *apitype.WhoIsResponse
 
@@ -385,6 +409,26 @@ type authRequest struct {
 	// As of 2023-11-14, it is 5 minutes.
 	// TODO: add routine to delete expired tokens.
 	validTill time.Time
+
+	// refreshExpires is when the refresh tokens of the grant stop being
+	// valid, however often they're rotated. It is set when the first
+	// refresh token is issued.
+	refreshExpires time.Time
+
+	// grant identifies the authorization grant that the tokens for this
+	// authRequest were issued under. It is shared by the access and refresh
+	// tokens issued for the grant, including those issued when refreshing,
+	// so that they can be revoked together.
+	grant string
+}
+
+// isPublicClient reports whether the relying party for ar is a public client,
+// which has no client secret to authenticate with.
+func (ar *authRequest) isPublicClient() bool {
+	if ar.funnelRP != nil {
+		return ar.funnelRP.Secret == ""
+	}
+	return true
 }
 
 // allowRelyingParty validates that a relying party identified either by a
@@ -473,18 +517,8 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Get user information
-		var remoteAddr string
-		if s.localTSMode {
-			remoteAddr = r.Header.Get("X-Forwarded-For")
-		} else {
-			remoteAddr = r.RemoteAddr
-		}
-
 		// Check who is visiting the authorize endpoint.
-		var who *apitype.WhoIsResponse
-		var err error
-		who, err = s.lc.WhoIs(r.Context(), remoteAddr)
+		who, err := s.lc.WhoIs(r.Context(), s.remoteAddr(r))
 		if err != nil {
 			log.Printf("Error getting WhoIs: %v", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -499,6 +533,10 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 			clientID:    clientID,
 			funnelRP:    c, // Store the validated client
 		}
+		if err := s.setCodeChallenge(ar, uq); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		s.mu.Lock()
 		mak.Set(&s.code, code, ar)
@@ -522,16 +560,7 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var remoteAddr string
-	if s.localTSMode {
-		// in local tailscaled mode, the local tailscaled is forwarding us
-		// HTTP requests, so reading r.RemoteAddr will just get us our own
-		// address.
-		remoteAddr = r.Header.Get("X-Forwarded-For")
-	} else {
-		remoteAddr = r.RemoteAddr
-	}
-	who, err := s.lc.WhoIs(r.Context(), remoteAddr)
+	who, err := s.lc.WhoIs(r.Context(), s.remoteAddr(r))
 	if err != nil {
 		log.Printf("Error getting WhoIs: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -569,6 +598,10 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	if err := s.setCodeChallenge(ar, uq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	s.mu.Lock()
 	mak.Set(&s.code, code, ar)
@@ -604,6 +637,11 @@ func (s *idpServer) newMux() *http.ServeMux {
 	}
 	mux.HandleFunc("/userinfo", s.serveUserInfo)
 	mux.HandleFunc("/token", s.serveToken)
+	mux.HandleFunc("/introspect", s.serveIntrospect)
+	mux.HandleFunc("/revoke", s.serveRevoke)
+	mux.HandleFunc("/end_session", s.serveEndSession)
+	mux.HandleFunc("/device_authorization", s.serveDeviceAuthorization)
+	mux.HandleFunc("/device", s.serveDevice)
 	mux.HandleFunc("/clients/", s.serveClients)
 	mux.HandleFunc("/", s.handleUI)
 	return mux
@@ -841,15 +879,53 @@ func withExtraClaims(v any, rules []capRule) (map[string]any, error) {
 	return claimMap, nil
 }
 
+// Grant types supported by the token endpoint.
+const (
+	grantTypeAuthorizationCode = "authorization_code"
+	grantTypeRefreshToken      = "refresh_token"
+	grantTypeClientCredentials = "client_credentials"
+	grantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange" // RFC 8693
+	grantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"    // RFC 8628
+)
+
+// tokenTypeAccessToken is the RFC 8693 token type identifier for OAuth 2.0
+// access tokens, which is the only type tsidp accepts and issues in token
+// exchange requests.
+const tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+const (
+	// accessTokenLifetime is how long access and ID tokens are valid for.
+	accessTokenLifetime = 5 * time.Minute
+
+	// refreshTokenLifetime is how long after the first refresh token of a
+	// grant is issued that the relying party may keep using refresh tokens,
+	// before it has to send the user through /authorize again. Refresh
+	// tokens are rotated on every use, which doesn't extend the lifetime.
+	refreshTokenLifetime = 7 * 24 * time.Hour
+)
+
 func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if r.FormValue("grant_type") != "authorization_code" {
+	switch r.FormValue("grant_type") {
+	case grantTypeAuthorizationCode:
+		s.serveAuthorizationCodeGrant(w, r)
+	case grantTypeRefreshToken:
+		s.serveRefreshTokenGrant(w, r)
+	case grantTypeClientCredentials:
+		s.serveClientCredentialsGrant(w, r)
+	case grantTypeTokenExchange:
+		s.serveTokenExchangeGrant(w, r)
+	case grantTypeDeviceCode:
+		s.serveDeviceCodeGrant(w, r)
+	default:
 		http.Error(w, "tsidp: grant_type not supported", http.StatusBadRequest)
-		return
 	}
+}
+
+func (s *idpServer) serveAuthorizationCodeGrant(w http.ResponseWriter, r *http.Request) {
 	code := r.FormValue("code")
 	if code == "" {
 		http.Error(w, "tsidp: code is required", http.StatusBadRequest)
@@ -866,66 +942,653 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if code, err := s.allowTokenRequest(r, ar); err != nil {
+		log.Printf("Error allowing relying party: %v", err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	if ar.redirectURI != r.FormValue("redirect_uri") {
+		http.Error(w, "tsidp: redirect_uri mismatch", http.StatusBadRequest)
+		return
+	}
+	if err := ar.verifyCodeVerifier(r.FormValue("code_verifier")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	idToken, ok := s.mintIDToken(w, ar, now)
+	if !ok {
+		return
+	}
+	s.writeTokenResponse(w, oidcTokenResponse{
+		AccessToken:  s.newAccessToken(ar, now),
+		RefreshToken: s.newRefreshToken(ar, now),
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenLifetime.Seconds()),
+		IDToken:      idToken,
+	})
+}
+
+// serveRefreshTokenGrant handles the refresh_token grant from RFC 6749
+// section 6. The presented refresh token is consumed and a new one is
+// returned alongside the new access and ID tokens.
+func (s *idpServer) serveRefreshTokenGrant(w http.ResponseWriter, r *http.Request) {
+	rt := r.FormValue("refresh_token")
+	if rt == "" {
+		http.Error(w, "tsidp: refresh_token is required", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	ar, ok := s.refreshToken[rt]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "tsidp: invalid refresh token", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if ar.validTill.Before(now) {
+		http.Error(w, "tsidp: refresh token expired", http.StatusBadRequest)
+		return
+	}
+
+	// Only consume the token once the client has authenticated, so that
+	// requests with bad credentials can't revoke it.
+	if code, err := s.allowTokenRequest(r, ar); err != nil {
+		log.Printf("Error allowing relying party: %v", err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+	s.mu.Lock()
+	ok = s.refreshToken[rt] == ar
+	if ok {
+		// Refresh tokens are single use; the client gets a new one below.
+		delete(s.refreshToken, rt)
+	}
+	s.mu.Unlock()
+	if !ok {
+		// A concurrent request used the token first.
+		http.Error(w, "tsidp: invalid refresh token", http.StatusBadRequest)
+		return
+	}
+
+	idToken, ok := s.mintIDToken(w, ar, now)
+	if !ok {
+		return
+	}
+	s.writeTokenResponse(w, oidcTokenResponse{
+		AccessToken:  s.newAccessToken(ar, now),
+		RefreshToken: s.newRefreshToken(ar, now),
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenLifetime.Seconds()),
+		IDToken:      idToken,
+	})
+}
+
+// serveClientCredentialsGrant handles the client_credentials grant from RFC
+// 6749 section 4.4. The issued access token is bound to the tailnet identity
+// of the node making the request, so the grant is not available over Funnel.
+func (s *idpServer) serveClientCredentialsGrant(w http.ResponseWriter, r *http.Request) {
+	if isFunnelRequest(r) {
+		http.Error(w, "tsidp: client_credentials grant not allowed over funnel", http.StatusUnauthorized)
+		return
+	}
+
+	ar := &authRequest{}
 	if !s.allowInsecureRegistration {
-		// When insecure registration is NOT allowed, always validate client credentials regardless of request source
-		clientID := r.FormValue("client_id")
-		clientSecret := r.FormValue("client_secret")
-
-		// Try basic auth if form values are empty
-		if clientID == "" || clientSecret == "" {
-			if basicClientID, basicClientSecret, ok := r.BasicAuth(); ok {
-				if clientID == "" {
-					clientID = basicClientID
-				}
-				if clientSecret == "" {
-					clientSecret = basicClientSecret
-				}
-			}
+		c, err := s.authenticateClient(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
 		}
+		ar.funnelRP = c
+		ar.clientID = c.ID
+	}
+
+	who, err := s.lc.WhoIs(r.Context(), s.remoteAddr(r))
+	if err != nil {
+		log.Printf("Error getting WhoIs: %v", err)
+		http.Error(w, "tsidp: could not identify caller", http.StatusUnauthorized)
+		return
+	}
+	ar.remoteUser = who
+	ar.rpNodeID = who.Node.ID
+	if ar.clientID == "" {
+		// Without registered clients, the calling node is the client.
+		ar.clientID = fmt.Sprint(who.Node.ID)
+	}
+
+	// Per RFC 6749 section 4.4.3, no refresh token is issued: the client
+	// can always repeat the grant.
+	s.writeTokenResponse(w, oidcTokenResponse{
+		AccessToken: s.newAccessToken(ar, time.Now()),
+		TokenType:   "Bearer",
+		ExpiresIn:   int(accessTokenLifetime.Seconds()),
+	})
+}
 
-		if clientID == "" || clientSecret == "" {
-			http.Error(w, "tsidp: client credentials required in when insecure registration is not allowed", http.StatusUnauthorized)
+// serveTokenExchangeGrant handles RFC 8693 token exchange. It accepts an
+// access token previously issued by tsidp as the subject_token and issues a
+// new access token for the same user on behalf of the requesting client,
+// optionally scoped to a different audience.
+func (s *idpServer) serveTokenExchangeGrant(w http.ResponseWriter, r *http.Request) {
+	subjectToken := r.FormValue("subject_token")
+	if subjectToken == "" {
+		http.Error(w, "tsidp: subject_token is required", http.StatusBadRequest)
+		return
+	}
+	if r.FormValue("subject_token_type") != tokenTypeAccessToken {
+		http.Error(w, "tsidp: subject_token_type not supported", http.StatusBadRequest)
+		return
+	}
+	if rtt := r.FormValue("requested_token_type"); rtt != "" && rtt != tokenTypeAccessToken {
+		http.Error(w, "tsidp: requested_token_type not supported", http.StatusBadRequest)
+		return
+	}
+
+	// Authenticate the client doing the exchange. Registered clients always
+	// authenticate with their credentials; otherwise the caller has to be a
+	// tailnet node.
+	var (
+		client   *funnelClient
+		clientID string
+		rpNodeID tailcfg.NodeID
+	)
+	if !s.allowInsecureRegistration || isFunnelRequest(r) {
+		c, err := s.authenticateClient(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
-
-		// Validate against the stored auth request
-		if ar.clientID != clientID {
-			http.Error(w, "tsidp: client_id mismatch", http.StatusBadRequest)
+		client, clientID = c, c.ID
+	} else {
+		who, err := s.lc.WhoIs(r.Context(), s.remoteAddr(r))
+		if err != nil {
+			log.Printf("Error getting WhoIs: %v", err)
+			http.Error(w, "tsidp: could not identify caller", http.StatusUnauthorized)
 			return
 		}
+		clientID = fmt.Sprint(who.Node.ID)
+		rpNodeID = who.Node.ID
+	}
 
-		// Validate client credentials against stored clients
-		if ar.funnelRP == nil {
-			http.Error(w, "tsidp: no client information found", http.StatusBadRequest)
-			return
+	s.mu.Lock()
+	subject, ok := s.accessToken[subjectToken]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "tsidp: invalid subject_token", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if subject.validTill.Before(now) {
+		http.Error(w, "tsidp: subject_token expired", http.StatusBadRequest)
+		return
+	}
+
+	// The new token belongs to the client doing the exchange, not the one
+	// the subject token was issued to.
+	ar := *subject
+	ar.localRP = false
+	ar.rpNodeID = rpNodeID
+	ar.funnelRP = client
+	ar.clientID = clientID
+	ar.audience = ""
+	if aud := r.FormValue("audience"); aud != "" {
+		if !s.allowInsecureRegistration {
+			s.mu.Lock()
+			_, ok := s.funnelClients[aud]
+			s.mu.Unlock()
+			if !ok {
+				http.Error(w, "tsidp: unknown audience", http.StatusBadRequest)
+				return
+			}
 		}
+		ar.audience = aud
+	}
 
-		clientIDcmp := subtle.ConstantTimeCompare([]byte(clientID), []byte(ar.funnelRP.ID))
-		clientSecretcmp := subtle.ConstantTimeCompare([]byte(clientSecret), []byte(ar.funnelRP.Secret))
-		if clientIDcmp != 1 || clientSecretcmp != 1 {
-			http.Error(w, "tsidp: invalid client credentials", http.StatusUnauthorized)
+	s.writeTokenResponse(w, oidcTokenResponse{
+		AccessToken:     s.newAccessToken(&ar, now),
+		IssuedTokenType: tokenTypeAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int(accessTokenLifetime.Seconds()),
+	})
+}
+
+// serveDeviceCodeGrant handles the device_code grant from RFC 8628 section
+// 3.4, which the device polls until the user approves or denies the request
+// on the verification page.
+func (s *idpServer) serveDeviceCodeGrant(w http.ResponseWriter, r *http.Request) {
+	dc := r.FormValue("device_code")
+	if dc == "" {
+		writeOAuthError(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	da, ok := s.deviceCode[dc]
+	s.mu.Unlock()
+	if !ok {
+		writeOAuthError(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+	if code, err := s.allowTokenRequest(r, da.ar); err != nil {
+		log.Printf("Error allowing relying party: %v", err)
+		writeOAuthError(w, "invalid_client", code)
+		return
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	var errCode string
+	switch {
+	case now.After(da.expires):
+		errCode = "expired_token"
+	case da.denied:
+		errCode = "access_denied"
+	case !da.approved:
+		if now.Sub(da.lastPoll) < da.interval {
+			// RFC 8628 section 3.5: the client must back off by 5 seconds
+			// for this and all subsequent requests.
+			da.interval += devicePollInterval
+			errCode = "slow_down"
+		} else {
+			errCode = "authorization_pending"
+		}
+		da.lastPoll = now
+	}
+	if errCode != "authorization_pending" && errCode != "slow_down" {
+		// The device code is single use, whatever the outcome.
+		delete(s.deviceCode, da.deviceCode)
+		delete(s.userCode, da.userCode)
+	}
+	s.mu.Unlock()
+	if errCode != "" {
+		writeOAuthError(w, errCode, http.StatusBadRequest)
+		return
+	}
+
+	idToken, ok := s.mintIDToken(w, da.ar, now)
+	if !ok {
+		return
+	}
+	s.writeTokenResponse(w, oidcTokenResponse{
+		AccessToken:  s.newAccessToken(da.ar, now),
+		RefreshToken: s.newRefreshToken(da.ar, now),
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenLifetime.Seconds()),
+		IDToken:      idToken,
+	})
+}
+
+// writeOAuthError writes an RFC 6749 section 5.2 JSON error response. The
+// device authorization grant needs these, as clients distinguish between the
+// errors while polling.
+func writeOAuthError(w http.ResponseWriter, errCode string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{errCode})
+}
+
+// setCodeChallenge records the RFC 7636 PKCE code challenge from the query
+// parameters q of an authorization request in ar. Only the S256 method is
+// supported.
+func (s *idpServer) setCodeChallenge(ar *authRequest, q url.Values) error {
+	challenge := q.Get("code_challenge")
+	if challenge == "" {
+		if s.requirePKCE && ar.isPublicClient() {
+			return errors.New("tsidp: code_challenge required for public clients")
+		}
+		return nil
+	}
+	if q.Get("code_challenge_method") != "S256" {
+		return errors.New("tsidp: code_challenge_method must be S256")
+	}
+	// An S256 challenge is the unpadded base64url encoding of a SHA-256 hash.
+	if b, err := base64.RawURLEncoding.DecodeString(challenge); err != nil || len(b) != sha256.Size {
+		return errors.New("tsidp: invalid code_challenge")
+	}
+	ar.codeChallenge = challenge
+	return nil
+}
+
+// verifyCodeVerifier checks the PKCE code_verifier presented at the token
+// endpoint against the code challenge from the authorization request, if
+// there was one.
+func (ar *authRequest) verifyCodeVerifier(verifier string) error {
+	if ar.codeChallenge == "" {
+		return nil
+	}
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return errors.New("tsidp: invalid code_verifier")
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	got := base64.RawURLEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(got), []byte(ar.codeChallenge)) != 1 {
+		return errors.New("tsidp: invalid code_verifier")
+	}
+	return nil
+}
+
+const (
+	// deviceCodeLifetime is how long the user has to approve a device
+	// authorization request.
+	deviceCodeLifetime = 10 * time.Minute
+
+	// devicePollInterval is the minimum interval between device token
+	// requests, and how much it grows by on each slow_down error.
+	devicePollInterval = 5 * time.Second
+)
+
+// userCodeAlphabet is the character set for device flow user codes. Per RFC
+// 8628 section 6.1, it has no vowels, to avoid accidentally forming words,
+// and no easily confused characters.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// deviceAuthRequest is an in-progress RFC 8628 device authorization.
+type deviceAuthRequest struct {
+	// ar is the authRequest that tokens are issued for. Its remoteUser is
+	// set when the user approves the request.
+	ar *authRequest
+
+	deviceCode string
+	userCode   string // in canonical form, as returned by normalizeUserCode
+	expires    time.Time
+
+	// csrfKey is the key of the CSRF tokens that the verification page's
+	// form must be submitted with. See csrfToken.
+	csrfKey []byte
+
+	// The fields below are guarded by idpServer.mu.
+	interval time.Duration
+	lastPoll time.Time
+	approved bool
+	denied   bool
+}
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// serveDeviceAuthorization is the RFC 8628 device authorization endpoint. It
+// starts a device flow for the requesting client, which then shows the user
+// the verification URI and user code and polls the token endpoint.
+func (s *idpServer) serveDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := r.FormValue("client_id")
+	if clientID == "" {
+		http.Error(w, "tsidp: must specify client_id", http.StatusBadRequest)
+		return
+	}
+
+	ar := &authRequest{clientID: clientID}
+	switch {
+	case !s.allowInsecureRegistration:
+		s.mu.Lock()
+		c, ok := s.funnelClients[clientID]
+		s.mu.Unlock()
+		if !ok {
+			writeOAuthError(w, "invalid_client", http.StatusUnauthorized)
+			return
+		}
+		if c.Secret != "" {
+			if got, err := s.authenticateClient(r); err != nil || got != c {
+				writeOAuthError(w, "invalid_client", http.StatusUnauthorized)
+				return
+			}
+		}
+		ar.funnelRP = c
+	case isFunnelRequest(r):
+		c, err := s.authenticateClient(r)
+		if err != nil || c.ID != clientID {
+			writeOAuthError(w, "invalid_client", http.StatusUnauthorized)
 			return
 		}
+		ar.funnelRP = c
+	default:
+		if who, err := s.lc.WhoIs(r.Context(), s.remoteAddr(r)); err == nil {
+			ar.rpNodeID = who.Node.ID
+		} else if ap, perr := netip.ParseAddrPort(r.RemoteAddr); perr == nil && ap.Addr().IsLoopback() {
+			ar.localRP = true
+		} else {
+			log.Printf("Error getting WhoIs: %v", err)
+			writeOAuthError(w, "invalid_client", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	now := time.Now()
+	da := &deviceAuthRequest{
+		ar:         ar,
+		deviceCode: rands.HexString(32),
+		expires:    now.Add(deviceCodeLifetime),
+		csrfKey:    []byte(rands.HexString(64)),
+		interval:   devicePollInterval,
+	}
+	s.mu.Lock()
+	s.deleteExpiredDeviceAuthsLocked(now)
+	for {
+		da.userCode = newUserCode()
+		if _, dup := s.userCode[da.userCode]; !dup {
+			break
+		}
+	}
+	mak.Set(&s.deviceCode, da.deviceCode, da)
+	mak.Set(&s.userCode, da.userCode, da)
+	s.mu.Unlock()
+
+	display := da.userCode[:4] + "-" + da.userCode[4:]
+	verificationURI := s.serverURL + "/device"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(deviceAuthorizationResponse{
+		DeviceCode:              da.deviceCode,
+		UserCode:                display,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {display}}.Encode(),
+		ExpiresIn:               int(deviceCodeLifetime.Seconds()),
+		Interval:                int(devicePollInterval.Seconds()),
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// deleteExpiredDeviceAuthsLocked forgets the device authorization requests
+// that expired before now, whether or not the user decided on them, so that
+// abandoned requests don't accumulate. s.mu must be held.
+func (s *idpServer) deleteExpiredDeviceAuthsLocked(now time.Time) {
+	for dc, da := range s.deviceCode {
+		if now.After(da.expires) {
+			delete(s.deviceCode, dc)
+			delete(s.userCode, da.userCode)
+		}
+	}
+}
+
+// csrfToken returns the token that the verification page's form for da must
+// be submitted with by the user on node. Binding it to the node, as well as
+// to the request, means that a page elsewhere can't have the user's browser
+// submit the form, even with a code and a token of its own.
+func (da *deviceAuthRequest) csrfToken(node tailcfg.NodeID) string {
+	h := hmac.New(sha256.New, da.csrfKey)
+	binary.Write(h, binary.BigEndian, int64(node))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// validCSRFToken reports whether token is the CSRF token of da for the user
+// on node.
+func (da *deviceAuthRequest) validCSRFToken(node tailcfg.NodeID, token string) bool {
+	return hmac.Equal([]byte(da.csrfToken(node)), []byte(token))
+}
+
+// newUserCode returns a random 8 character user code in canonical form.
+func newUserCode() string {
+	b := make([]byte, 8)
+	nAlphabet := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range b {
+		n := must.Get(crand.Int(crand.Reader, nAlphabet))
+		b[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(b)
+}
+
+// normalizeUserCode returns the canonical form of a user code as typed in by
+// the user, ignoring case, dashes and spaces.
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '-' || r == ' ':
+			return -1
+		case 'a' <= r && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return r
+	}, code)
+}
+
+// lookupUserCode returns the unexpired device authorization request for the
+// user code typed in by the user, or nil if there is none.
+func (s *idpServer) lookupUserCode(code string) *deviceAuthRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	da, ok := s.userCode[normalizeUserCode(code)]
+	if !ok || time.Now().After(da.expires) || da.approved || da.denied {
+		return nil
+	}
+	return da
+}
+
+// decideDeviceAuth records the user's approval or denial of a device
+// authorization request. It reports false if the request is no longer
+// pending.
+func (s *idpServer) decideDeviceAuth(da *deviceAuthRequest, who *apitype.WhoIsResponse, approve bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.userCode[da.userCode] != da || da.approved || da.denied {
+		return false
+	}
+	if approve {
+		da.ar.remoteUser = who
+		da.approved = true
 	} else {
+		da.denied = true
+	}
+	return true
+}
+
+// allowTokenRequest reports whether the client making the token request r is
+// allowed to redeem a grant that was issued for ar. On failure, it returns the
+// HTTP status code to respond with.
+func (s *idpServer) allowTokenRequest(r *http.Request, ar *authRequest) (int, error) {
+	if s.allowInsecureRegistration {
 		// Original behavior when insecure registration is allowed
 		// Only checks ClientID and Client Secret when over funnel.
 		// Local connections are allowed and tailnet connections only check matching nodeIDs.
 		if err := ar.allowRelyingParty(r, s.lc); err != nil {
-			log.Printf("Error allowing relying party: %v", err)
-			http.Error(w, err.Error(), http.StatusForbidden)
-			return
+			return http.StatusForbidden, err
 		}
+		return 0, nil
 	}
 
-	if ar.redirectURI != r.FormValue("redirect_uri") {
-		http.Error(w, "tsidp: redirect_uri mismatch", http.StatusBadRequest)
-		return
+	// Public clients have no secret to authenticate with; they are
+	// identified by their client ID alone.
+	if ar.funnelRP != nil && ar.funnelRP.Secret == "" {
+		if clientID, _ := clientCredentials(r); clientID == "" || clientID != ar.funnelRP.ID {
+			return http.StatusBadRequest, errors.New("tsidp: client_id mismatch")
+		}
+		return 0, nil
+	}
+
+	// When insecure registration is NOT allowed, always validate client credentials regardless of request source
+	clientID, clientSecret := clientCredentials(r)
+	if clientID == "" || clientSecret == "" {
+		return http.StatusUnauthorized, errors.New("tsidp: client credentials required in when insecure registration is not allowed")
+	}
+
+	// Validate against the stored auth request
+	if ar.clientID != clientID {
+		return http.StatusBadRequest, errors.New("tsidp: client_id mismatch")
+	}
+
+	// Validate client credentials against stored clients
+	if ar.funnelRP == nil {
+		return http.StatusBadRequest, errors.New("tsidp: no client information found")
+	}
+
+	clientIDcmp := subtle.ConstantTimeCompare([]byte(clientID), []byte(ar.funnelRP.ID))
+	clientSecretcmp := subtle.ConstantTimeCompare([]byte(clientSecret), []byte(ar.funnelRP.Secret))
+	if clientIDcmp != 1 || clientSecretcmp != 1 {
+		return http.StatusUnauthorized, errors.New("tsidp: invalid client credentials")
+	}
+	return 0, nil
+}
+
+// clientCredentials returns the client ID and secret presented in r, taken
+// from the form values or, if those are empty, from HTTP basic auth.
+func clientCredentials(r *http.Request) (clientID, clientSecret string) {
+	clientID = r.FormValue("client_id")
+	clientSecret = r.FormValue("client_secret")
+	if clientID == "" || clientSecret == "" {
+		if basicClientID, basicClientSecret, ok := r.BasicAuth(); ok {
+			if clientID == "" {
+				clientID = basicClientID
+			}
+			if clientSecret == "" {
+				clientSecret = basicClientSecret
+			}
+		}
+	}
+	return clientID, clientSecret
+}
+
+// authenticateClient returns the registered client whose credentials are
+// presented in r.
+func (s *idpServer) authenticateClient(r *http.Request) (*funnelClient, error) {
+	clientID, clientSecret := clientCredentials(r)
+	if clientID == "" || clientSecret == "" {
+		return nil, errors.New("tsidp: client credentials required")
+	}
+	s.mu.Lock()
+	c, ok := s.funnelClients[clientID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errors.New("tsidp: invalid client credentials")
 	}
+	if subtle.ConstantTimeCompare([]byte(clientSecret), []byte(c.Secret)) != 1 {
+		return nil, errors.New("tsidp: invalid client credentials")
+	}
+	return c, nil
+}
+
+// remoteAddr returns the address of the client that sent r.
+func (s *idpServer) remoteAddr(r *http.Request) string {
+	if s.localTSMode {
+		// in local tailscaled mode, the local tailscaled is forwarding us
+		// HTTP requests, so reading r.RemoteAddr will just get us our own
+		// address.
+		return r.Header.Get("X-Forwarded-For")
+	}
+	return r.RemoteAddr
+}
+
+// mintIDToken returns a signed OIDC ID token for the user in ar. On failure,
+// it writes an error to w and returns false.
+func (s *idpServer) mintIDToken(w http.ResponseWriter, ar *authRequest, now time.Time) (_ string, ok bool) {
 	signer, err := s.oidcSigner()
 	if err != nil {
 		log.Printf("Error getting signer: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return "", false
 	}
 	jti := rands.HexString(32)
 	who := ar.remoteUser
@@ -935,15 +1598,14 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 	n := who.Node.View()
 	if n.IsTagged() {
 		http.Error(w, "tsidp: tagged nodes not supported", http.StatusBadRequest)
-		return
+		return "", false
 	}
 
-	now := time.Now()
 	_, tcd, _ := strings.Cut(n.Name(), ".")
 	tsClaims := tailscaleClaims{
 		Claims: jwt.Claims{
 			Audience:  jwt.Audience{ar.clientID},
-			Expiry:    jwt.NewNumericDate(now.Add(5 * time.Minute)),
+			Expiry:    jwt.NewNumericDate(now.Add(accessTokenLifetime)),
 			ID:        jti,
 			IssuedAt:  jwt.NewNumericDate(now),
 			Issuer:    s.serverURL,
@@ -968,14 +1630,14 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Printf("tsidp: failed to unmarshal capability: %v", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return "", false
 	}
 
 	tsClaimsWithExtra, err := withExtraClaims(tsClaims, rules)
 	if err != nil {
 		log.Printf("tsidp: failed to merge extra claims: %v", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return "", false
 	}
 
 	// Create an OIDC token using this issuer's signer.
@@ -983,32 +1645,367 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Printf("Error getting token: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return "", false
 	}
+	return token, true
+}
 
+// newAccessToken records and returns a new opaque access token for ar.
+func (s *idpServer) newAccessToken(ar *authRequest, now time.Time) string {
 	at := rands.HexString(32)
 	s.mu.Lock()
-	ar.validTill = now.Add(5 * time.Minute)
+	if ar.grant == "" {
+		ar.grant = rands.HexString(16)
+	}
+	ar.validTill = now.Add(accessTokenLifetime)
 	mak.Set(&s.accessToken, at, ar)
 	s.mu.Unlock()
+	return at
+}
+
+// newRefreshToken records and returns a new refresh token for ar.
+func (s *idpServer) newRefreshToken(ar *authRequest, now time.Time) string {
+	// The refresh token gets its own copy of ar so that its validTill is
+	// independent of the access token's.
+	s.mu.Lock()
+	if ar.grant == "" {
+		ar.grant = rands.HexString(16)
+	}
+	rar := *ar
+	s.mu.Unlock()
+	if rar.refreshExpires.IsZero() {
+		rar.refreshExpires = now.Add(refreshTokenLifetime)
+	}
+	rar.validTill = rar.refreshExpires
+	rt := rands.HexString(32)
+	s.mu.Lock()
+	mak.Set(&s.refreshToken, rt, &rar)
+	s.mu.Unlock()
+	return rt
+}
 
+func (s *idpServer) writeTokenResponse(w http.ResponseWriter, resp oidcTokenResponse) {
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(oidcTokenResponse{
-		AccessToken: at,
-		TokenType:   "Bearer",
-		ExpiresIn:   5 * 60,
-		IDToken:     token,
-	}); err != nil {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// introspectionResponse is the RFC 7662 token introspection response.
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Expiry    int64  `json:"exp,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+
+	// Tailscale-specific fields, matching the names of the ID token claims.
+	NodeID   tailcfg.NodeID `json:"nid,omitempty"`
+	NodeName string         `json:"node,omitempty"`
+	Email    string         `json:"email,omitempty"`
+}
+
+// serveIntrospect implements RFC 7662 token introspection for the opaque
+// access and refresh tokens issued by tsidp. Callers must be registered
+// clients or, when insecure registration is allowed, tailnet nodes. They
+// can introspect the tokens issued to them and, so that resource servers can
+// validate the tokens presented to them, the tokens whose audience they are.
+// Other tokens are reported as inactive.
+func (s *idpServer) serveIntrospect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var caller string // the client ID of the caller
+	if !s.allowInsecureRegistration || isFunnelRequest(r) {
+		c, err := s.authenticateClient(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		caller = c.ID
+	} else {
+		who, err := s.lc.WhoIs(r.Context(), s.remoteAddr(r))
+		if err != nil {
+			log.Printf("Error getting WhoIs: %v", err)
+			http.Error(w, "tsidp: could not identify caller", http.StatusUnauthorized)
+			return
+		}
+		caller = fmt.Sprint(who.Node.ID)
+	}
+
+	tk := r.FormValue("token")
+	if tk == "" {
+		http.Error(w, "tsidp: token is required", http.StatusBadRequest)
+		return
+	}
+
+	var resp introspectionResponse
+	ar, tokenType := s.lookupToken(tk, r.FormValue("token_type_hint"))
+	if ar != nil && caller != ar.clientID && caller != ar.audience {
+		// As RFC 7662 suggests, don't tell clients about the tokens of
+		// others, not even that they exist.
+		ar = nil
+	}
+	if ar != nil && ar.validTill.After(time.Now()) {
+		resp = introspectionResponse{
+			Active:    true,
+			ClientID:  ar.clientID,
+			TokenType: tokenType,
+			Expiry:    ar.validTill.Unix(),
+			Audience:  cmp.Or(ar.audience, ar.clientID),
+			Issuer:    s.serverURL,
+		}
+		if ar.localRP {
+			resp.Issuer = s.loopbackURL
+		}
+		if who := ar.remoteUser; who != nil {
+			resp.NodeID = who.Node.ID
+			resp.NodeName = who.Node.Name
+			if !who.Node.IsTagged() {
+				resp.Subject = who.Node.User.String()
+				resp.Email = who.UserProfile.LoginName
+				resp.Username, _, _ = strings.Cut(who.UserProfile.LoginName, "@")
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// lookupToken returns the authRequest for the access or refresh token tk, and
+// "access_token" or "refresh_token" according to which kind it is. The hint
+// is an RFC 7009 token_type_hint and only affects the lookup order. It
+// returns nil if tk is not a known token.
+func (s *idpServer) lookupToken(tk, hint string) (_ *authRequest, tokenType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hint == "refresh_token" {
+		if ar, ok := s.refreshToken[tk]; ok {
+			return ar, "refresh_token"
+		}
+	}
+	if ar, ok := s.accessToken[tk]; ok {
+		return ar, "access_token"
+	}
+	if ar, ok := s.refreshToken[tk]; ok {
+		return ar, "refresh_token"
+	}
+	return nil, ""
+}
+
+// serveRevoke implements RFC 7009 token revocation. Clients may only revoke
+// tokens that were issued to them; as required by the RFC, unknown tokens
+// are not an error. Revoking a refresh token also revokes the access tokens
+// issued under the same grant.
+func (s *idpServer) serveRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tk := r.FormValue("token")
+	if tk == "" {
+		http.Error(w, "tsidp: token is required", http.StatusBadRequest)
+		return
+	}
+	ar, tokenType := s.lookupToken(tk, r.FormValue("token_type_hint"))
+	if ar == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if code, err := s.allowTokenRequest(r, ar); err != nil {
+		log.Printf("Error allowing relying party: %v", err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	s.mu.Lock()
+	if tokenType == "refresh_token" {
+		delete(s.refreshToken, tk)
+		s.revokeTokensLocked(func(a *authRequest) bool { return a.grant == ar.grant })
+	} else {
+		delete(s.accessToken, tk)
+	}
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeTokensLocked deletes all access and refresh tokens for which match
+// returns true. s.mu must be held.
+func (s *idpServer) revokeTokensLocked(match func(*authRequest) bool) {
+	for tk, ar := range s.accessToken {
+		if match(ar) {
+			delete(s.accessToken, tk)
+		}
+	}
+	for tk, ar := range s.refreshToken {
+		if match(ar) {
+			delete(s.refreshToken, tk)
+		}
+	}
+}
+
+// serveEndSession implements OpenID Connect RP-Initiated Logout. Because
+// tsidp authenticates users by their tailnet identity, there is no login
+// session to end; instead, the user's outstanding access and refresh tokens
+// are revoked. With an id_token_hint, only the tokens issued to that ID
+// token's audience are revoked.
+//
+// Without an id_token_hint, all of the user's tokens are revoked, but only
+// once the user confirms it on a page shown for GET requests, so that other
+// sites can't sign the user out.
+func (s *idpServer) serveEndSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Method == "POST" && !isSameOriginRequest(r) {
+		http.Error(w, "tsidp: cross-site request refused", http.StatusForbidden)
+		return
+	}
+
+	var (
+		sub string
+		aud jwt.Audience
+	)
+	if hint := r.FormValue("id_token_hint"); hint != "" {
+		claims, err := s.parseIDTokenHint(hint)
+		if err != nil {
+			log.Printf("Error parsing id_token_hint: %v", err)
+			http.Error(w, "tsidp: invalid id_token_hint", http.StatusBadRequest)
+			return
+		}
+		sub, aud = claims.Subject, claims.Audience
+		if cid := r.FormValue("client_id"); cid != "" && !aud.Contains(cid) {
+			http.Error(w, "tsidp: client_id does not match id_token_hint", http.StatusBadRequest)
+			return
+		}
+	} else {
+		if isFunnelRequest(r) {
+			http.Error(w, "tsidp: id_token_hint required over funnel", http.StatusBadRequest)
+			return
+		}
+		who, err := s.lc.WhoIs(r.Context(), s.remoteAddr(r))
+		if err != nil {
+			log.Printf("Error getting WhoIs: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.Method == "GET" {
+			s.renderLogoutPage(w, logoutDisplayData{
+				CSRFToken: s.logoutCSRFToken(who.Node.ID),
+				LoginName: who.UserProfile.LoginName,
+			})
+			return
+		}
+		if !hmac.Equal([]byte(s.logoutCSRFToken(who.Node.ID)), []byte(r.PostFormValue("csrf_token"))) {
+			http.Error(w, "tsidp: invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		sub = who.Node.User.String()
+	}
+
+	s.mu.Lock()
+	s.revokeTokensLocked(func(ar *authRequest) bool {
+		if ar.remoteUser == nil || ar.remoteUser.Node.IsTagged() || ar.remoteUser.Node.User.String() != sub {
+			return false
+		}
+		return len(aud) == 0 || aud.Contains(ar.clientID)
+	})
+	s.mu.Unlock()
+
+	if redirectURI := r.FormValue("post_logout_redirect_uri"); redirectURI != "" {
+		u, err := s.postLogoutRedirectURL(redirectURI, aud)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if state := r.FormValue("state"); state != "" {
+			q := u.Query()
+			q.Set("state", state)
+			u.RawQuery = q.Encode()
+		}
+		http.Redirect(w, r, u.String(), http.StatusFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "You have been signed out.\n")
+}
+
+// logoutCSRFToken returns the token that the end_session confirmation page's
+// form must be submitted with by the user on node.
+func (s *idpServer) logoutCSRFToken(node tailcfg.NodeID) string {
+	key := s.lazyLogoutCSRFKey.Get(func() []byte {
+		return []byte(rands.HexString(64))
+	})
+	h := hmac.New(sha256.New, key)
+	binary.Write(h, binary.BigEndian, int64(node))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// parseIDTokenHint verifies that hint is an ID token signed by this IdP and
+// returns its claims. Expired tokens are accepted, as the OIDC RP-Initiated
+// Logout spec allows.
+func (s *idpServer) parseIDTokenHint(hint string) (*jwt.Claims, error) {
+	tok, err := jwt.ParseSigned(hint)
+	if err != nil {
+		return nil, err
+	}
+	sk, err := s.oidcPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	var claims jwt.Claims
+	if err := tok.Claims(sk.k.Public(), &claims); err != nil {
+		return nil, err
+	}
+	if claims.Issuer != s.serverURL && (s.loopbackURL == "" || claims.Issuer != s.loopbackURL) {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	return &claims, nil
+}
+
+// postLogoutRedirectURL validates redirectURI as a post-logout redirect for
+// one of the clients in aud. Only registered clients can be redirected to,
+// and only to the same origin as their registered redirect_uri.
+func (s *idpServer) postLogoutRedirectURL(redirectURI string, aud jwt.Audience) (*url.URL, error) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return nil, errors.New("tsidp: invalid post_logout_redirect_uri")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cid := range aud {
+		c, ok := s.funnelClients[cid]
+		if !ok {
+			continue
+		}
+		ru, err := url.Parse(c.RedirectURI)
+		if err != nil {
+			continue
+		}
+		if ru.Scheme == u.Scheme && ru.Host == u.Host {
+			return u, nil
+		}
+	}
+	return nil, errors.New("tsidp: post_logout_redirect_uri not allowed")
+}
+
 type oidcTokenResponse struct {
-	IDToken      string `json:"id_token"`
-	TokenType    string `json:"token_type"`
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token,omitempty"`
-	ExpiresIn    int    `json:"expires_in"`
+	IDToken         string `json:"id_token,omitempty"`
+	TokenType       string `json:"token_type"`
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"` // RFC 8693
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	ExpiresIn       int    `json:"expires_in"`
 }
 
 const (
@@ -1097,12 +2094,18 @@ type openIDProviderMetadata struct {
 	AuthorizationEndpoint            string              `json:"authorization_endpoint,omitempty"`
 	TokenEndpoint                    string              `json:"token_endpoint,omitempty"`
 	UserInfoEndpoint                 string              `json:"userinfo_endpoint,omitempty"`
+	IntrospectionEndpoint            string              `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint               string              `json:"revocation_endpoint,omitempty"`
+	EndSessionEndpoint               string              `json:"end_session_endpoint,omitempty"`
+	DeviceAuthorizationEndpoint      string              `json:"device_authorization_endpoint,omitempty"`
 	JWKS_URI                         string              `json:"jwks_uri"`
 	ScopesSupported                  views.Slice[string] `json:"scopes_supported"`
 	ResponseTypesSupported           views.Slice[string] `json:"response_types_supported"`
 	SubjectTypesSupported            views.Slice[string] `json:"subject_types_supported"`
 	ClaimsSupported                  views.Slice[string] `json:"claims_supported"`
 	IDTokenSigningAlgValuesSupported views.Slice[string] `json:"id_token_signing_alg_values_supported"`
+	GrantTypesSupported              views.Slice[string] `json:"grant_types_supported"`
+	CodeChallengeMethodsSupported    views.Slice[string] `json:"code_challenge_methods_supported"`
 	// TODO(maisem): maybe add other fields?
 	// Currently we fill out the REQUIRED fields, scopes_supported and claims_supported.
 }
@@ -1141,6 +2144,19 @@ type tailscaleClaims struct {
 	// We only support getting the id_token.
 	openIDSupportedReponseTypes = views.SliceOf([]string{"id_token", "code"})
 
+	// The grant types accepted by the token endpoint.
+	openIDSupportedGrantTypes = views.SliceOf([]string{
+		grantTypeAuthorizationCode,
+		grantTypeRefreshToken,
+		grantTypeClientCredentials,
+		grantTypeTokenExchange,
+		grantTypeDeviceCode,
+	})
+
+	// The PKCE code challenge methods we support. "plain" is deliberately
+	// not supported.
+	openIDSupportedCodeChallengeMethods = views.SliceOf([]string{"S256"})
+
 	// The type of the "sub" field in the JWT, which means it is globally unique identifier.
 	// The other option is "pairwise", which means the identifier is different per receiving 3p.
 	openIDSupportedSubjectTypes = views.SliceOf([]string{"public"})
@@ -1210,11 +2226,17 @@ func (s *idpServer) serveOpenIDConfig(w http.ResponseWriter, r *http.Request) {
 		JWKS_URI:                         rpEndpoint + oidcJWKSPath,
 		UserInfoEndpoint:                 rpEndpoint + "/userinfo",
 		TokenEndpoint:                    rpEndpoint + "/token",
+		IntrospectionEndpoint:            rpEndpoint + "/introspect",
+		RevocationEndpoint:               rpEndpoint + "/revoke",
+		EndSessionEndpoint:               rpEndpoint + "/end_session",
+		DeviceAuthorizationEndpoint:      rpEndpoint + "/device_authorization",
 		ScopesSupported:                  openIDSupportedScopes,
 		ResponseTypesSupported:           openIDSupportedReponseTypes,
 		SubjectTypesSupported:            openIDSupportedSubjectTypes,
 		ClaimsSupported:                  openIDSupportedClaims,
 		IDTokenSigningAlgValuesSupported: openIDSupportedSigningAlgos,
+		GrantTypesSupported:              openIDSupportedGrantTypes,
+		CodeChallengeMethodsSupported:    openIDSupportedCodeChallengeMethods,
 	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -1283,7 +2305,10 @@ func (s *idpServer) serveNewClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	clientID := rands.HexString(32)
-	clientSecret := rands.HexString(64)
+	var clientSecret string
+	if !wantPublicClient(r) {
+		clientSecret = rands.HexString(64)
+	}
 	newClient := funnelClient{
 		ID:          clientID,
 		Secret:      clientSecret,
@@ -1304,6 +2329,19 @@ func (s *idpServer) serveNewClient(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(newClient)
 }
 
+// wantPublicClient reports whether the request r to create a client, from
+// the API or the UI, asks for a public client, which has no secret. The
+// "public" form value is a boolean as accepted by strconv.ParseBool, or "on"
+// as sent by an HTML checkbox.
+func wantPublicClient(r *http.Request) bool {
+	v := r.FormValue("public")
+	if v == "on" {
+		return true
+	}
+	public, _ := strconv.ParseBool(v)
+	return public
+}
+
 func (s *idpServer) serveGetClientsList(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
@@ -1348,6 +2386,10 @@ func (s *idpServer) serveDeleteClient(w http.ResponseWriter, r *http.Request, cl
 		s.funnelClients[clientID] = deleted
 		return
 	}
+	// Tokens issued to or meant for a deleted client must not outlive it.
+	s.revokeTokensLocked(func(ar *authRequest) bool {
+		return ar.funnelRP == deleted || ar.audience == clientID
+	})
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -17,6 +17,7 @@
 package main
 
 import (
+	"cmp"
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/json"
@@ -45,6 +46,8 @@
 	"tailscale.com/types/key"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/views"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/rands"
 )
 
 // normalizeMap recursively sorts []any values in a map[string]any to ensure
@@ -1103,6 +1106,7 @@ func setupTestServerWithClient(t *testing.T, strictMode bool, lc *local.Client)
 		allowInsecureRegistration: !strictMode,
 		code:                      make(map[string]*authRequest),
 		accessToken:               make(map[string]*authRequest),
+		refreshToken:              make(map[string]*authRequest),
 		funnelClients:             make(map[string]*funnelClient),
 		serverURL:                 "https://test.ts.net",
 		rootPath:                  t.TempDir(),
@@ -2061,3 +2065,991 @@ func TestServeUserInfoWithClientValidation(t *testing.T) {
 		})
 	}
 }
+
+// testRemoteUser returns a WhoIs response for an untagged test user.
+func testRemoteUser() *apitype.WhoIsResponse {
+	return &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{
+			ID:   123,
+			Name: "test-node.test.ts.net.",
+			User: 456,
+		},
+		UserProfile: &tailcfg.UserProfile{
+			LoginName:   "alice@example.com",
+			DisplayName: "Alice Example",
+		},
+	}
+}
+
+func postToken(t *testing.T, s *idpServer, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	s.serveToken(rr, req)
+	return rr
+}
+
+func TestRefreshTokenGrant(t *testing.T) {
+	s := setupTestServer(t, true)
+	s.code["valid-code"] = &authRequest{
+		clientID:    "test-client",
+		redirectURI: "https://rp.example.com/callback",
+		remoteUser:  testRemoteUser(),
+		funnelRP:    s.funnelClients["test-client"],
+	}
+
+	rr := postToken(t, s, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"valid-code"},
+		"redirect_uri":  {"https://rp.example.com/callback"},
+		"client_id":     {"test-client"},
+		"client_secret": {"test-secret"},
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("authorization_code: got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp oidcTokenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.RefreshToken == "" {
+		t.Fatal("expected refresh_token in authorization_code response")
+	}
+
+	refresh := func(rt, secret string) *httptest.ResponseRecorder {
+		return postToken(t, s, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {rt},
+			"client_id":     {"test-client"},
+			"client_secret": {secret},
+		})
+	}
+
+	if rr := refresh(resp.RefreshToken, "wrong-secret"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: got %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	// The failed attempt above must not have consumed the refresh token.
+	rt := resp.RefreshToken
+	s.mu.Lock()
+	refreshExpires := s.refreshToken[rt].refreshExpires
+	s.mu.Unlock()
+	if refreshExpires.IsZero() {
+		t.Fatal("refresh token consumed by a request with the wrong secret")
+	}
+
+	// Check that the refresh token rotates.
+	rr = refresh(rt, "test-secret")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("refresh_token: got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp2 oidcTokenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp2); err != nil {
+		t.Fatal(err)
+	}
+	if resp2.AccessToken == "" || resp2.IDToken == "" {
+		t.Errorf("missing access or ID token in refresh response: %+v", resp2)
+	}
+	if resp2.RefreshToken == "" || resp2.RefreshToken == rt {
+		t.Errorf("refresh token not rotated: got %q", resp2.RefreshToken)
+	}
+	if rr := refresh(rt, "test-secret"); rr.Code != http.StatusBadRequest {
+		t.Errorf("rotated-out refresh token: got %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	// Rotation doesn't extend the lifetime of the grant's refresh tokens.
+	s.mu.Lock()
+	rotated := s.refreshToken[resp2.RefreshToken]
+	s.mu.Unlock()
+	if rotated == nil || !rotated.validTill.Equal(refreshExpires) {
+		t.Errorf("rotated refresh token valid until %v, want %v", rotated.validTill, refreshExpires)
+	}
+
+	// Expired refresh tokens are rejected.
+	expired := rands.HexString(32)
+	s.mu.Lock()
+	s.refreshToken[expired] = &authRequest{
+		clientID:   "test-client",
+		remoteUser: testRemoteUser(),
+		funnelRP:   s.funnelClients["test-client"],
+		validTill:  time.Now().Add(-time.Minute),
+	}
+	s.mu.Unlock()
+	if rr := refresh(expired, "test-secret"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expired refresh token: got %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	tests := []struct {
+		name       string
+		creds      url.Values
+		funnel     bool
+		expectCode int
+	}{
+		{
+			name:       "over funnel",
+			creds:      url.Values{"client_id": {"test-client"}, "client_secret": {"test-secret"}},
+			funnel:     true,
+			expectCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "missing credentials",
+			expectCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong secret",
+			creds:      url.Values{"client_id": {"test-client"}, "client_secret": {"wrong-secret"}},
+			expectCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "unknown client",
+			creds:      url.Values{"client_id": {"other-client"}, "client_secret": {"test-secret"}},
+			expectCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := setupTestServer(t, true)
+			form := url.Values{"grant_type": {"client_credentials"}}
+			for k, v := range tt.creds {
+				form[k] = v
+			}
+			req := httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.funnel {
+				req.Header.Set("Tailscale-Funnel-Request", "1")
+			}
+			rr := httptest.NewRecorder()
+			s.serveToken(rr, req)
+			if rr.Code != tt.expectCode {
+				t.Errorf("got %d, want %d: %s", rr.Code, tt.expectCode, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestTokenExchangeGrant(t *testing.T) {
+	s := setupTestServer(t, true)
+	s.funnelClients["other-client"] = &funnelClient{
+		ID:          "other-client",
+		Secret:      "other-secret",
+		RedirectURI: "https://other.example.com/callback",
+	}
+	subject := s.newAccessToken(&authRequest{
+		clientID:   "test-client",
+		remoteUser: testRemoteUser(),
+		funnelRP:   s.funnelClients["test-client"],
+	}, time.Now())
+
+	base := url.Values{
+		"grant_type":         {grantTypeTokenExchange},
+		"subject_token":      {subject},
+		"subject_token_type": {tokenTypeAccessToken},
+		"client_id":          {"other-client"},
+		"client_secret":      {"other-secret"},
+	}
+	with := func(kv ...string) url.Values {
+		v := url.Values{}
+		for k, vv := range base {
+			v[k] = vv
+		}
+		for i := 0; i < len(kv); i += 2 {
+			v.Set(kv[i], kv[i+1])
+		}
+		return v
+	}
+
+	tests := []struct {
+		name         string
+		form         url.Values
+		expectCode   int
+		wantAudience string
+	}{
+		{
+			name:       "valid exchange",
+			form:       with(),
+			expectCode: http.StatusOK,
+		},
+		{
+			name:         "valid exchange with audience",
+			form:         with("audience", "test-client"),
+			expectCode:   http.StatusOK,
+			wantAudience: "test-client",
+		},
+		{
+			name:       "unknown audience",
+			form:       with("audience", "nope"),
+			expectCode: http.StatusBadRequest,
+		},
+		{
+			name:       "bad client secret",
+			form:       with("client_secret", "wrong"),
+			expectCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "unsupported subject_token_type",
+			form:       with("subject_token_type", "urn:ietf:params:oauth:token-type:id_token"),
+			expectCode: http.StatusBadRequest,
+		},
+		{
+			name:       "unsupported requested_token_type",
+			form:       with("requested_token_type", "urn:ietf:params:oauth:token-type:refresh_token"),
+			expectCode: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown subject_token",
+			form:       with("subject_token", "bogus"),
+			expectCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postToken(t, s, tt.form)
+			if rr.Code != tt.expectCode {
+				t.Fatalf("got %d, want %d: %s", rr.Code, tt.expectCode, rr.Body.String())
+			}
+			if tt.expectCode != http.StatusOK {
+				return
+			}
+			var resp oidcTokenResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.IssuedTokenType != tokenTypeAccessToken {
+				t.Errorf("issued_token_type = %q, want %q", resp.IssuedTokenType, tokenTypeAccessToken)
+			}
+			if resp.AccessToken == subject {
+				t.Error("exchange returned the subject token")
+			}
+			s.mu.Lock()
+			ar, ok := s.accessToken[resp.AccessToken]
+			s.mu.Unlock()
+			if !ok {
+				t.Fatal("exchanged access token not recorded")
+			}
+			if ar.clientID != "other-client" || ar.funnelRP != s.funnelClients["other-client"] || ar.audience != tt.wantAudience {
+				t.Errorf("exchanged token is owned by %q (%v) for audience %q; want other-client for %q", ar.clientID, ar.funnelRP, ar.audience, tt.wantAudience)
+			}
+			if ar.remoteUser.UserProfile.LoginName != "alice@example.com" {
+				t.Errorf("exchanged token is for %q", ar.remoteUser.UserProfile.LoginName)
+			}
+
+			// The client that did the exchange can introspect and revoke
+			// the token.
+			form := url.Values{
+				"token":         {resp.AccessToken},
+				"client_id":     {"other-client"},
+				"client_secret": {"other-secret"},
+			}
+			post := func(path string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				rr := httptest.NewRecorder()
+				handler(rr, req)
+				return rr
+			}
+			var ir introspectionResponse
+			if err := json.Unmarshal(post("/introspect", s.serveIntrospect).Body.Bytes(), &ir); err != nil {
+				t.Fatal(err)
+			}
+			if wantAud := cmp.Or(tt.wantAudience, "other-client"); !ir.Active || ir.ClientID != "other-client" || ir.Audience != wantAud {
+				t.Errorf("introspect exchanged token: got %+v, want client other-client, audience %q", ir, wantAud)
+			}
+			if tt.wantAudience != "" {
+				// The audience can introspect it too.
+				form.Set("client_id", "test-client")
+				form.Set("client_secret", "test-secret")
+				if err := json.Unmarshal(post("/introspect", s.serveIntrospect).Body.Bytes(), &ir); err != nil {
+					t.Fatal(err)
+				}
+				if !ir.Active || ir.Audience != tt.wantAudience {
+					t.Errorf("introspect exchanged token by its audience: got %+v", ir)
+				}
+				form.Set("client_id", "other-client")
+				form.Set("client_secret", "other-secret")
+			}
+			if rr := post("/revoke", s.serveRevoke); rr.Code != http.StatusOK {
+				t.Errorf("revoke exchanged token: got %d: %s", rr.Code, rr.Body.String())
+			}
+			s.mu.Lock()
+			_, ok = s.accessToken[resp.AccessToken]
+			s.mu.Unlock()
+			if ok {
+				t.Error("exchanged token not revoked")
+			}
+		})
+	}
+}
+
+func TestIntrospectAndRevoke(t *testing.T) {
+	s := setupTestServer(t, true)
+	s.funnelClients["other-client"] = &funnelClient{
+		ID:          "other-client",
+		Secret:      "other-secret",
+		RedirectURI: "https://other.example.com/callback",
+	}
+	s.funnelClients["third-client"] = &funnelClient{
+		ID:          "third-client",
+		Secret:      "third-secret",
+		RedirectURI: "https://third.example.com/callback",
+	}
+	ar := &authRequest{
+		clientID:   "test-client",
+		remoteUser: testRemoteUser(),
+		funnelRP:   s.funnelClients["test-client"],
+	}
+	now := time.Now()
+	at := s.newAccessToken(ar, now)
+	rt := s.newRefreshToken(ar, now)
+
+	post := func(path string, handler http.HandlerFunc, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+	introspect := func(tk, clientID, secret string) (int, introspectionResponse) {
+		rr := post("/introspect", s.serveIntrospect, url.Values{
+			"token":         {tk},
+			"client_id":     {clientID},
+			"client_secret": {secret},
+		})
+		var resp introspectionResponse
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr.Code, resp
+	}
+	revoke := func(tk, clientID, secret string) int {
+		return post("/revoke", s.serveRevoke, url.Values{
+			"token":         {tk},
+			"client_id":     {clientID},
+			"client_secret": {secret},
+		}).Code
+	}
+
+	if code, _ := introspect(at, "test-client", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("introspect with bad credentials: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	code, resp := introspect(at, "test-client", "test-secret")
+	if code != http.StatusOK {
+		t.Fatalf("introspect: got %d", code)
+	}
+	if !resp.Active || resp.ClientID != "test-client" || resp.TokenType != "access_token" {
+		t.Errorf("introspect access token: got %+v", resp)
+	}
+	if resp.Subject != "userid:456" || resp.Username != "alice" || resp.NodeID != 123 {
+		t.Errorf("introspect access token identity: got %+v", resp)
+	}
+	if _, resp := introspect(rt, "test-client", "test-secret"); !resp.Active || resp.TokenType != "refresh_token" {
+		t.Errorf("introspect refresh token: got %+v", resp)
+	}
+	if _, resp := introspect("bogus", "test-client", "test-secret"); resp.Active {
+		t.Errorf("introspect unknown token: got %+v", resp)
+	}
+	// Other clients can't learn about the client's tokens.
+	if code, resp := introspect(at, "other-client", "other-secret"); code != http.StatusOK || resp != (introspectionResponse{}) {
+		t.Errorf("introspect by another client: got %d, %+v", code, resp)
+	}
+	// Unless they're the token's audience, like a resource server that the
+	// token is presented to.
+	forOther := s.newAccessToken(&authRequest{
+		clientID:   "test-client",
+		audience:   "other-client",
+		remoteUser: testRemoteUser(),
+		funnelRP:   s.funnelClients["test-client"],
+	}, now)
+	if _, resp := introspect(forOther, "other-client", "other-secret"); !resp.Active || resp.ClientID != "test-client" || resp.Audience != "other-client" {
+		t.Errorf("introspect by the token's audience: got %+v", resp)
+	}
+	if _, resp := introspect(forOther, "third-client", "third-secret"); resp.Active {
+		t.Errorf("introspect by a client that isn't the token's audience: got %+v", resp)
+	}
+
+	// Only the client a token was issued to may revoke it.
+	if code := revoke(at, "other-client", "other-secret"); code == http.StatusOK {
+		t.Error("revoke by another client succeeded")
+	}
+	if code := revoke(at, "test-client", "test-secret"); code != http.StatusOK {
+		t.Errorf("revoke access token: got %d", code)
+	}
+	if _, resp := introspect(at, "test-client", "test-secret"); resp.Active {
+		t.Error("revoked access token is still active")
+	}
+	if _, resp := introspect(rt, "test-client", "test-secret"); !resp.Active {
+		t.Error("revoking an access token revoked its refresh token")
+	}
+
+	// Revoking a refresh token revokes the access tokens of its grant, but
+	// not those of other grants.
+	at2 := s.newAccessToken(ar, now)
+	otherGrant := s.newAccessToken(&authRequest{
+		clientID:   "test-client",
+		remoteUser: testRemoteUser(),
+		funnelRP:   s.funnelClients["test-client"],
+	}, now)
+	if code := revoke(rt, "test-client", "test-secret"); code != http.StatusOK {
+		t.Errorf("revoke refresh token: got %d", code)
+	}
+	if _, resp := introspect(rt, "test-client", "test-secret"); resp.Active {
+		t.Error("revoked refresh token is still active")
+	}
+	if _, resp := introspect(at2, "test-client", "test-secret"); resp.Active {
+		t.Error("access token of revoked grant is still active")
+	}
+	if _, resp := introspect(otherGrant, "test-client", "test-secret"); !resp.Active {
+		t.Error("access token of another grant was revoked")
+	}
+	// Revoking an unknown token is not an error.
+	if code := revoke("bogus", "test-client", "test-secret"); code != http.StatusOK {
+		t.Errorf("revoke unknown token: got %d", code)
+	}
+}
+
+func TestEndSession(t *testing.T) {
+	s := setupTestServer(t, true)
+	s.lazySigningKey.Set(&signingKey{k: mustGeneratePrivateKey(t)})
+	s.funnelClients["other-client"] = &funnelClient{
+		ID:          "other-client",
+		Secret:      "other-secret",
+		RedirectURI: "https://other.example.com/callback",
+	}
+
+	newToken := func(clientID string) string {
+		return s.newAccessToken(&authRequest{
+			clientID:   clientID,
+			remoteUser: testRemoteUser(),
+			funnelRP:   s.funnelClients[clientID],
+		}, time.Now())
+	}
+	idToken := func(claims jwt.Claims) string {
+		tok, err := jwt.Signed(oidcTestingSigner(t)).Claims(claims).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+	validHint := idToken(jwt.Claims{
+		Issuer:   s.serverURL,
+		Subject:  "userid:456",
+		Audience: jwt.Audience{"test-client"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(-time.Hour)), // expired hints are fine
+	})
+
+	tests := []struct {
+		name         string
+		query        url.Values
+		expectCode   int
+		wantLocation string
+		wantRevoked  []string // client IDs whose tokens are revoked
+		wantKept     []string // client IDs whose tokens are kept
+	}{
+		{
+			name:        "hint revokes only its audience",
+			query:       url.Values{"id_token_hint": {validHint}},
+			expectCode:  http.StatusOK,
+			wantRevoked: []string{"test-client"},
+			wantKept:    []string{"other-client"},
+		},
+		{
+			name: "redirect to registered origin",
+			query: url.Values{
+				"id_token_hint":            {validHint},
+				"post_logout_redirect_uri": {"https://rp.example.com/logged-out"},
+				"state":                    {"xyz"},
+			},
+			expectCode:   http.StatusFound,
+			wantLocation: "https://rp.example.com/logged-out?state=xyz",
+			wantRevoked:  []string{"test-client"},
+		},
+		{
+			name: "redirect to unregistered origin",
+			query: url.Values{
+				"id_token_hint":            {validHint},
+				"post_logout_redirect_uri": {"https://evil.example.com/"},
+			},
+			expectCode: http.StatusBadRequest,
+		},
+		{
+			name: "client_id not in audience",
+			query: url.Values{
+				"id_token_hint": {validHint},
+				"client_id":     {"other-client"},
+			},
+			expectCode: http.StatusBadRequest,
+			wantKept:   []string{"test-client", "other-client"},
+		},
+		{
+			name: "hint from another issuer",
+			query: url.Values{"id_token_hint": {idToken(jwt.Claims{
+				Issuer:   "https://evil.example.com",
+				Subject:  "userid:456",
+				Audience: jwt.Audience{"test-client"},
+			})}},
+			expectCode: http.StatusBadRequest,
+			wantKept:   []string{"test-client"},
+		},
+		{
+			name:       "no hint over funnel",
+			expectCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := map[string]string{
+				"test-client":  newToken("test-client"),
+				"other-client": newToken("other-client"),
+			}
+			req := httptest.NewRequest("GET", "/end_session?"+tt.query.Encode(), nil)
+			req.Header.Set("Tailscale-Funnel-Request", "1")
+			rr := httptest.NewRecorder()
+			s.serveEndSession(rr, req)
+			if rr.Code != tt.expectCode {
+				t.Fatalf("got %d, want %d: %s", rr.Code, tt.expectCode, rr.Body.String())
+			}
+			if got := rr.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			for _, cid := range tt.wantRevoked {
+				if _, ok := s.accessToken[tokens[cid]]; ok {
+					t.Errorf("token for %q not revoked", cid)
+				}
+			}
+			for _, cid := range tt.wantKept {
+				if _, ok := s.accessToken[tokens[cid]]; !ok {
+					t.Errorf("token for %q unexpectedly revoked", cid)
+				}
+			}
+		})
+	}
+}
+
+func TestEndSessionConfirmation(t *testing.T) {
+	s := setupTestServerWithClient(t, true, whoIsClient(testRemoteUser()))
+	at := s.newAccessToken(&authRequest{
+		clientID:   "test-client",
+		remoteUser: testRemoteUser(),
+		funnelRP:   s.funnelClients["test-client"],
+	}, time.Now())
+	revoked := func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		_, ok := s.accessToken[at]
+		return !ok
+	}
+	confirm := func(token string, header http.Header) int {
+		req := httptest.NewRequest("POST", "/end_session", strings.NewReader(url.Values{
+			"csrf_token": {token},
+		}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		s.serveEndSession(rr, req)
+		return rr.Code
+	}
+
+	// Without an id_token_hint, a GET only asks the user to confirm.
+	rr := httptest.NewRecorder()
+	s.serveEndSession(rr, httptest.NewRequest("GET", "/end_session", nil))
+	token := s.logoutCSRFToken(testRemoteUser().Node.ID)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), token) {
+		t.Fatalf("confirmation page: got %d: %s", rr.Code, rr.Body.String())
+	}
+	if revoked() {
+		t.Fatal("GET without id_token_hint revoked tokens")
+	}
+
+	if code := confirm("", nil); code != http.StatusForbidden {
+		t.Errorf("confirmation without CSRF token: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := confirm(s.logoutCSRFToken(999), nil); code != http.StatusForbidden {
+		t.Errorf("confirmation with another node's CSRF token: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := confirm(token, http.Header{"Sec-Fetch-Site": {"cross-site"}}); code != http.StatusForbidden {
+		t.Errorf("cross-site confirmation: got %d, want %d", code, http.StatusForbidden)
+	}
+	if revoked() {
+		t.Fatal("tokens revoked by forged request")
+	}
+	if code := confirm(token, http.Header{"Sec-Fetch-Site": {"same-origin"}}); code != http.StatusOK || !revoked() {
+		t.Errorf("confirmation: got %d, revoked %v", code, revoked())
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	// Test vector from RFC 7636 appendix B.
+	const (
+		verifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+		challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	)
+
+	tests := []struct {
+		name        string
+		requirePKCE bool
+		public      bool
+		query       url.Values
+		wantErr     bool
+	}{
+		{
+			name:  "S256",
+			query: url.Values{"code_challenge": {challenge}, "code_challenge_method": {"S256"}},
+		},
+		{
+			name:    "plain not supported",
+			query:   url.Values{"code_challenge": {verifier}, "code_challenge_method": {"plain"}},
+			wantErr: true,
+		},
+		{
+			name:    "method defaults to plain",
+			query:   url.Values{"code_challenge": {challenge}},
+			wantErr: true,
+		},
+		{
+			name:    "malformed challenge",
+			query:   url.Values{"code_challenge": {"not-a-hash"}, "code_challenge_method": {"S256"}},
+			wantErr: true,
+		},
+		{
+			name:   "not required for public clients by default",
+			public: true,
+		},
+		{
+			name:        "required for public clients",
+			requirePKCE: true,
+			public:      true,
+			wantErr:     true,
+		},
+		{
+			name:        "not required for confidential clients",
+			requirePKCE: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := setupTestServer(t, true)
+			s.requirePKCE = tt.requirePKCE
+			c := &funnelClient{ID: "c", Secret: "secret"}
+			if tt.public {
+				c.Secret = ""
+			}
+			ar := &authRequest{funnelRP: c}
+			err := s.setCodeChallenge(ar, tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setCodeChallenge err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && ar.codeChallenge != tt.query.Get("code_challenge") {
+				t.Errorf("codeChallenge = %q, want %q", ar.codeChallenge, tt.query.Get("code_challenge"))
+			}
+		})
+	}
+
+	ar := &authRequest{codeChallenge: challenge}
+	if err := ar.verifyCodeVerifier(verifier); err != nil {
+		t.Errorf("verifyCodeVerifier(valid) = %v", err)
+	}
+	for _, bad := range []string{"", "short", strings.Repeat("a", 43), strings.Repeat("a", 129)} {
+		if err := ar.verifyCodeVerifier(bad); err == nil {
+			t.Errorf("verifyCodeVerifier(%q) succeeded", bad)
+		}
+	}
+	if err := (&authRequest{}).verifyCodeVerifier(""); err != nil {
+		t.Errorf("verifyCodeVerifier without challenge = %v", err)
+	}
+
+	// A public client redeems its code with the verifier instead of a secret.
+	s := setupTestServer(t, true)
+	s.funnelClients["public-client"] = &funnelClient{
+		ID:          "public-client",
+		RedirectURI: "https://rp.example.com/callback",
+	}
+	for _, tc := range []struct {
+		verifier string
+		want     int
+	}{
+		{"wrong-verifier-wrong-verifier-wrong-verifier", http.StatusBadRequest},
+		{verifier, http.StatusOK},
+	} {
+		s.code["valid-code"] = &authRequest{
+			clientID:      "public-client",
+			redirectURI:   "https://rp.example.com/callback",
+			remoteUser:    testRemoteUser(),
+			funnelRP:      s.funnelClients["public-client"],
+			codeChallenge: challenge,
+		}
+		rr := postToken(t, s, url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {"valid-code"},
+			"redirect_uri":  {"https://rp.example.com/callback"},
+			"client_id":     {"public-client"},
+			"code_verifier": {tc.verifier},
+		})
+		if rr.Code != tc.want {
+			t.Errorf("code_verifier %q: got %d, want %d: %s", tc.verifier, rr.Code, tc.want, rr.Body.String())
+		}
+	}
+}
+
+func TestDeviceAuthorizationGrant(t *testing.T) {
+	s := setupTestServer(t, true)
+
+	// Confidential clients must authenticate.
+	req := httptest.NewRequest("POST", "/device_authorization", strings.NewReader(url.Values{
+		"client_id":     {"test-client"},
+		"client_secret": {"wrong-secret"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	s.serveDeviceAuthorization(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("bad client secret: got %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest("POST", "/device_authorization", strings.NewReader(url.Values{
+		"client_id":     {"test-client"},
+		"client_secret": {"test-secret"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	s.serveDeviceAuthorization(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("device_authorization: got %d: %s", rr.Code, rr.Body.String())
+	}
+	var dar deviceAuthorizationResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &dar); err != nil {
+		t.Fatal(err)
+	}
+	if dar.VerificationURI != "https://test.ts.net/device" {
+		t.Errorf("verification_uri = %q", dar.VerificationURI)
+	}
+	if dar.Interval != 5 || dar.ExpiresIn != 600 {
+		t.Errorf("interval = %d, expires_in = %d", dar.Interval, dar.ExpiresIn)
+	}
+
+	poll := func() (int, string) {
+		rr := postToken(t, s, url.Values{
+			"grant_type":    {grantTypeDeviceCode},
+			"device_code":   {dar.DeviceCode},
+			"client_id":     {"test-client"},
+			"client_secret": {"test-secret"},
+		})
+		var resp struct {
+			Error       string `json:"error"`
+			AccessToken string `json:"access_token"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp.Error
+	}
+
+	if _, errCode := poll(); errCode != "authorization_pending" {
+		t.Errorf("first poll: error = %q, want authorization_pending", errCode)
+	}
+	if _, errCode := poll(); errCode != "slow_down" {
+		t.Errorf("immediate second poll: error = %q, want slow_down", errCode)
+	}
+
+	// The user types in the code, in whatever form.
+	da := s.lookupUserCode(strings.ToLower(dar.UserCode))
+	if da == nil {
+		t.Fatalf("lookupUserCode(%q) found nothing", dar.UserCode)
+	}
+	if s.lookupUserCode("BBBB-BBBB") != nil {
+		t.Error("lookupUserCode found an unknown code")
+	}
+	if !s.decideDeviceAuth(da, testRemoteUser(), true) {
+		t.Fatal("decideDeviceAuth failed")
+	}
+	if s.decideDeviceAuth(da, testRemoteUser(), false) {
+		t.Error("decideDeviceAuth succeeded twice")
+	}
+
+	if code, errCode := poll(); code != http.StatusOK {
+		t.Fatalf("poll after approval: got %d, error %q", code, errCode)
+	}
+	if _, errCode := poll(); errCode != "invalid_grant" {
+		t.Errorf("poll after redemption: error = %q, want invalid_grant", errCode)
+	}
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	for _, in := range []string{"BCDF-GHJK", "bcdf-ghjk", "BCDFGHJK", "bcdf ghjk"} {
+		if got := normalizeUserCode(in); got != "BCDFGHJK" {
+			t.Errorf("normalizeUserCode(%q) = %q", in, got)
+		}
+	}
+	if c := newUserCode(); len(c) != 8 || strings.Trim(c, userCodeAlphabet) != "" {
+		t.Errorf("newUserCode() = %q", c)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// whoIsClient returns a LocalClient whose WhoIs always returns who.
+func whoIsClient(who *apitype.WhoIsResponse) *local.Client {
+	return &local.Client{
+		OmitAuth: true,
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			rec := httptest.NewRecorder()
+			if r.URL.Path != "/localapi/v0/whois" {
+				http.NotFound(rec, r)
+			} else {
+				json.NewEncoder(rec).Encode(who)
+			}
+			return rec.Result(), nil
+		}),
+	}
+}
+
+func TestServeDeviceCSRF(t *testing.T) {
+	s := setupTestServerWithClient(t, true, whoIsClient(testRemoteUser()))
+	newDeviceAuth := func() *deviceAuthRequest {
+		req := httptest.NewRequest("POST", "/device_authorization", strings.NewReader(url.Values{
+			"client_id":     {"test-client"},
+			"client_secret": {"test-secret"},
+		}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		s.serveDeviceAuthorization(rr, req)
+		var dar deviceAuthorizationResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &dar); err != nil {
+			t.Fatal(err)
+		}
+		return s.lookupUserCode(dar.UserCode)
+	}
+	approve := func(da *deviceAuthRequest, token string, header http.Header) int {
+		req := httptest.NewRequest("POST", "/device", strings.NewReader(url.Values{
+			"user_code":  {da.userCode},
+			"csrf_token": {token},
+			"action":     {"approve"},
+		}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		s.serveDevice(rr, req)
+		return rr.Code
+	}
+
+	da := newDeviceAuth()
+	if da == nil {
+		t.Fatal("device authorization not found")
+	}
+
+	// The confirmation page has a token for the user viewing it.
+	rr := httptest.NewRecorder()
+	s.serveDevice(rr, httptest.NewRequest("GET", "/device?user_code="+da.userCode, nil))
+	token := da.csrfToken(testRemoteUser().Node.ID)
+	if !strings.Contains(rr.Body.String(), token) {
+		t.Fatalf("confirmation page has no CSRF token: %s", rr.Body.String())
+	}
+
+	if code := approve(da, "", nil); code != http.StatusForbidden {
+		t.Errorf("approval without CSRF token: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := approve(da, da.csrfToken(999), nil); code != http.StatusForbidden {
+		t.Errorf("approval with another node's CSRF token: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := approve(da, token, http.Header{"Sec-Fetch-Site": {"cross-site"}}); code != http.StatusForbidden {
+		t.Errorf("cross-site approval: got %d, want %d", code, http.StatusForbidden)
+	}
+	if da.approved {
+		t.Fatal("device authorization approved by forged request")
+	}
+	if code := approve(da, token, http.Header{"Sec-Fetch-Site": {"same-origin"}}); code != http.StatusOK || !da.approved {
+		t.Errorf("approval: got %d, approved %v", code, da.approved)
+	}
+}
+
+func TestDeleteExpiredDeviceAuths(t *testing.T) {
+	s := setupTestServer(t, true)
+	now := time.Now()
+	for i, expires := range []time.Time{now.Add(-time.Second), now.Add(time.Minute)} {
+		da := &deviceAuthRequest{deviceCode: fmt.Sprint("dc", i), userCode: fmt.Sprint("UC", i), expires: expires}
+		mak.Set(&s.deviceCode, da.deviceCode, da)
+		mak.Set(&s.userCode, da.userCode, da)
+	}
+	s.mu.Lock()
+	s.deleteExpiredDeviceAuthsLocked(now)
+	s.mu.Unlock()
+	if _, ok := s.deviceCode["dc0"]; ok {
+		t.Error("expired device code not deleted")
+	}
+	if _, ok := s.userCode["UC0"]; ok {
+		t.Error("expired user code not deleted")
+	}
+	if s.deviceCode["dc1"] == nil || s.userCode["UC1"] == nil {
+		t.Error("unexpired device authorization deleted")
+	}
+}
+
+func TestNewPublicClient(t *testing.T) {
+	tests := []struct {
+		public     string
+		wantPublic bool
+	}{
+		{"", false},
+		{"false", false},
+		{"true", true},
+		{"on", true},
+		{"1", true},
+	}
+	for _, tt := range tests {
+		form := url.Values{
+			"name":         {"client-" + tt.public},
+			"redirect_uri": {"https://rp.example.com/callback"},
+			"public":       {tt.public},
+		}
+		newRequest := func(path string) *http.Request {
+			r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return r
+		}
+
+		t.Run("api/"+tt.public, func(t *testing.T) {
+			s := setupTestServer(t, true)
+			w := httptest.NewRecorder()
+			s.serveNewClient(w, newRequest("/clients/new"))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d; body: %s", w.Code, w.Body)
+			}
+			var c funnelClient
+			if err := json.NewDecoder(w.Body).Decode(&c); err != nil {
+				t.Fatal(err)
+			}
+			if gotPublic := c.Secret == ""; gotPublic != tt.wantPublic {
+				t.Errorf("public = %v; want %v", gotPublic, tt.wantPublic)
+			}
+		})
+
+		t.Run("ui/"+tt.public, func(t *testing.T) {
+			s := setupTestServer(t, true)
+			w := httptest.NewRecorder()
+			s.handleNewClient(w, newRequest("/new"))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d; body: %s", w.Code, w.Body)
+			}
+			var found bool
+			for _, c := range s.funnelClients {
+				if c.Name != form.Get("name") {
+					continue
+				}
+				found = true
+				if gotPublic := c.Secret == ""; gotPublic != tt.wantPublic {
+					t.Errorf("public = %v; want %v", gotPublic, tt.wantPublic)
+				}
+			}
+			if !found {
+				t.Error("client not created")
+			}
+		})
+	}
+}
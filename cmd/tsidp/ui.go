@@ -26,12 +26,20 @@
 //go:embed ui-edit.html
 var editHTML string
 
+//go:embed ui-device.html
+var deviceHTML string
+
+//go:embed ui-logout.html
+var logoutHTML string
+
 //go:embed ui-style.css
 var styleCSS string
 
 var headerTmpl = template.Must(template.New("header").Parse(headerHTML))
 var listTmpl = template.Must(headerTmpl.New("list").Parse(listHTML))
 var editTmpl = template.Must(headerTmpl.New("edit").Parse(editHTML))
+var deviceTmpl = template.Must(headerTmpl.New("device").Parse(deviceHTML))
+var logoutTmpl = template.Must(headerTmpl.New("logout").Parse(logoutHTML))
 
 var processStart = time.Now()
 
@@ -118,7 +126,10 @@ func (s *idpServer) handleNewClient(w http.ResponseWriter, r *http.Request) {
 		}
 
 		clientID := rands.HexString(32)
-		clientSecret := rands.HexString(64)
+		var clientSecret string
+		if !wantPublicClient(r) {
+			clientSecret = rands.HexString(64)
+		}
 		newClient := funnelClient{
 			ID:          clientID,
 			Secret:      clientSecret,
@@ -147,6 +158,10 @@ func (s *idpServer) handleNewClient(w http.ResponseWriter, r *http.Request) {
 			Secret:      clientSecret,
 			IsNew:       true,
 		}
+		if clientSecret == "" {
+			s.renderFormSuccess(w, successData, "Public client created successfully!")
+			return
+		}
 		s.renderFormSuccess(w, successData, "Client created successfully! Save the client secret - it won't be shown again.")
 		return
 	}
@@ -323,3 +338,116 @@ func validateRedirectURI(redirectURI string) string {
 
 	return ""
 }
+
+type deviceDisplayData struct {
+	UserCode   string
+	CSRFToken  string
+	ClientName string
+	LoginName  string
+	Confirm    bool
+	Success    string
+	Error      string
+}
+
+// serveDevice is the RFC 8628 verification page, where users enter the code
+// shown on their device and approve or deny its login. The user logging in is
+// whoever is visiting the page, as identified by WhoIs, so the page is only
+// available over the tailnet.
+func (s *idpServer) serveDevice(w http.ResponseWriter, r *http.Request) {
+	if isFunnelRequest(r) {
+		http.Error(w, "tsidp: not available over Funnel", http.StatusNotFound)
+		return
+	}
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Method == "POST" && !isSameOriginRequest(r) {
+		http.Error(w, "tsidp: cross-site request refused", http.StatusForbidden)
+		return
+	}
+
+	code := strings.TrimSpace(r.FormValue("user_code"))
+	data := deviceDisplayData{UserCode: code}
+	if code == "" {
+		s.renderDevicePage(w, data)
+		return
+	}
+
+	da := s.lookupUserCode(code)
+	if da == nil {
+		data.Error = "Invalid or expired code."
+		s.renderDevicePage(w, data)
+		return
+	}
+	who, err := s.lc.WhoIs(r.Context(), s.remoteAddr(r))
+	if err != nil {
+		log.Printf("Error getting WhoIs: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if who.Node.IsTagged() {
+		data.Error = "Tagged devices cannot be used to log in."
+		s.renderDevicePage(w, data)
+		return
+	}
+
+	if r.Method == "GET" {
+		data.Confirm = true
+		data.CSRFToken = da.csrfToken(who.Node.ID)
+		data.LoginName = who.UserProfile.LoginName
+		if rp := da.ar.funnelRP; rp != nil {
+			data.ClientName = rp.Name
+		}
+		s.renderDevicePage(w, data)
+		return
+	}
+
+	if !da.validCSRFToken(who.Node.ID, r.PostFormValue("csrf_token")) {
+		http.Error(w, "tsidp: invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	approve := r.FormValue("action") == "approve"
+	if !s.decideDeviceAuth(da, who, approve) {
+		data.Error = "Invalid or expired code."
+	} else if approve {
+		data.Success = "Device approved. You can return to your device."
+	} else {
+		data.Success = "Device login denied."
+	}
+	s.renderDevicePage(w, data)
+}
+
+// isSameOriginRequest reports whether r was not sent by a page of another
+// site, according to the browser's Sec-Fetch-Site header. Requests from
+// browsers that don't send it are allowed, and rely on CSRF tokens alone.
+func isSameOriginRequest(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+		return true
+	}
+	return false
+}
+
+func (s *idpServer) renderDevicePage(w http.ResponseWriter, data deviceDisplayData) {
+	var buf bytes.Buffer
+	if err := deviceTmpl.Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	buf.WriteTo(w)
+}
+
+type logoutDisplayData struct {
+	CSRFToken string
+	LoginName string
+}
+
+func (s *idpServer) renderLogoutPage(w http.ResponseWriter, data logoutDisplayData) {
+	var buf bytes.Buffer
+	if err := logoutTmpl.Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	buf.WriteTo(w)
+}
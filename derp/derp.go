@@ -306,4 +306,8 @@ type ServerInfo struct {
 
 	TokenBucketBytesPerSecond int `json:",omitempty"`
 	TokenBucketBytesBurst     int `json:",omitempty"`
+
+	TokenBucketPacketsPerSecond int   `json:",omitempty"`
+	TokenBucketPacketsBurst     int   `json:",omitempty"`
+	DailyQuotaBytes             int64 `json:",omitempty"`
 }
@@ -414,6 +414,25 @@ type ServerInfoMessage struct {
 	// Zero means unspecified. There might be a limit, but the
 	// client need not try to respect it.
 	TokenBucketBytesBurst int
+
+	// TokenBucketPacketsPerSecond is how many packets per second the
+	// server says it will accept.
+	//
+	// Zero means unspecified.
+	TokenBucketPacketsPerSecond int
+
+	// TokenBucketPacketsBurst is how many packets the server will allow
+	// to burst, temporarily violating TokenBucketPacketsPerSecond.
+	//
+	// Zero means unspecified.
+	TokenBucketPacketsBurst int
+
+	// DailyQuotaBytes is how many bytes the server will relay to and
+	// from the client's node key per UTC day, after which it drops
+	// packets or disconnects the client.
+	//
+	// Zero means unspecified.
+	DailyQuotaBytes int64
 }
 
 func (ServerInfoMessage) msg() {}
@@ -549,6 +568,10 @@ func (c *Client) recvTimeout(timeout time.Duration) (m ReceivedMessage, err erro
 			sm := ServerInfoMessage{
 				TokenBucketBytesPerSecond: si.TokenBucketBytesPerSecond,
 				TokenBucketBytesBurst:     si.TokenBucketBytesBurst,
+
+				TokenBucketPacketsPerSecond: si.TokenBucketPacketsPerSecond,
+				TokenBucketPacketsBurst:     si.TokenBucketPacketsBurst,
+				DailyQuotaBytes:             si.DailyQuotaBytes,
 			}
 			c.setSendRateLimiter(sm)
 			return sm, nil
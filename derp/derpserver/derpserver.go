@@ -165,6 +165,8 @@ type Server struct {
 	multiForwarderDeleted      expvar.Int
 	removePktForwardOther      expvar.Int
 	sclientWriteTimeouts       expvar.Int
+	rateLimitThrottled         expvar.Int       // packets delayed by rate limits
+	rateLimitDisconnects       expvar.Int       // clients disconnected for exceeding rate limits or quota
 	avgQueueDuration           *uint64          // In milliseconds; accessed atomically
 	tcpRtt                     metrics.LabelMap // histogram
 	meshUpdateBatchSize        *metrics.Histogram
@@ -174,10 +176,10 @@ type Server struct {
 	// verifyClientsLocalTailscaled only accepts client connections to the DERP
 	// server if the clientKey is a known peer in the network, as specified by a
 	// running tailscaled's client's LocalAPI.
-	verifyClientsLocalTailscaled bool
+	verifyClientsLocalTailscaled atomic.Bool
 
-	verifyClientsURL         string
-	verifyClientsURLFailOpen bool
+	verifyClientsURL         syncs.AtomicValue[string]
+	verifyClientsURLFailOpen atomic.Bool
 
 	mu       syncs.Mutex
 	closed   bool
@@ -202,7 +204,14 @@ type Server struct {
 	// Sets the client send queue depth for the server.
 	perClientSendQueueDepth int
 
-	tcpWriteTimeout time.Duration
+	tcpWriteTimeout syncs.AtomicValue[time.Duration]
+
+	rateLimits atomic.Pointer[RateLimits] // nil if unlimited; changed with mu held
+
+	// nodeLimiters holds the rate limiters and quota usage of node keys
+	// that are connected or used some of their quota today.
+	nodeLimiters          map[key.NodePublic]*nodeLimiter
+	nodeLimitersPrunedDay int64
 
 	clock tstime.Clock
 }
@@ -379,9 +388,10 @@ func New(privateKey key.NodePrivate, logf logger.Logf) *Server {
 		meshUpdateLoopCount: metrics.NewHistogram([]float64{0, 1, 2, 5, 10, 20, 50, 100}),
 		bufferedWriteFrames: metrics.NewHistogram([]float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 15, 20, 25, 50, 100}),
 		keyOfAddr:           map[netip.AddrPort]key.NodePublic{},
+		nodeLimiters:        map[key.NodePublic]*nodeLimiter{},
 		clock:               tstime.StdClock{},
-		tcpWriteTimeout:     DefaultTCPWiteTimeout,
 	}
+	s.tcpWriteTimeout.Store(DefaultTCPWiteTimeout)
 	s.initMetacert()
 	s.packetsRecvDisco = s.packetsRecvByKind.Get(string(packetKindDisco))
 	s.packetsRecvOther = s.packetsRecvByKind.Get(string(packetKindOther))
@@ -440,6 +450,7 @@ func genDroppedCounters() {
 		dropReasonQueueTail,
 		dropReasonWriteError,
 		dropReasonDupClient,
+		dropReasonQuota,
 	}
 
 	for _, dr := range dropReasons {
@@ -472,27 +483,31 @@ func (s *Server) SetMeshKey(v string) error {
 
 // SetVerifyClients sets whether this DERP server verifies clients through tailscaled.
 //
-// It must be called before serving begins.
+// It may be called at any time; it applies to clients that connect later.
 func (s *Server) SetVerifyClient(v bool) {
-	s.verifyClientsLocalTailscaled = v
+	s.verifyClientsLocalTailscaled.Store(v)
 }
 
 // SetVerifyClientURL sets the admission controller URL to use for verifying clients.
 // If empty, all clients are accepted (unless restricted by SetVerifyClient checking
 // against tailscaled).
+//
+// It may be called at any time; it applies to clients that connect later.
 func (s *Server) SetVerifyClientURL(v string) {
-	s.verifyClientsURL = v
+	s.verifyClientsURL.Store(v)
 }
 
 // SetVerifyClientURLFailOpen sets whether to allow clients to connect if the
 // admission controller URL is unreachable.
 func (s *Server) SetVerifyClientURLFailOpen(v bool) {
-	s.verifyClientsURLFailOpen = v
+	s.verifyClientsURLFailOpen.Store(v)
 }
 
 // SetTailscaledSocketPath sets the unix socket path to use to talk to
 // tailscaled if client verification is enabled.
 //
+// It must be called before serving begins.
+//
 // If unset or set to the empty string, the default path for the operating
 // system is used.
 func (s *Server) SetTailscaledSocketPath(path string) {
@@ -502,9 +517,9 @@ func (s *Server) SetTailscaledSocketPath(path string) {
 
 // SetTCPWriteTimeout sets the timeout for writing to connected clients.
 // This timeout does not apply to mesh connections.
-// Defaults to 2 seconds.
+// Defaults to 2 seconds. It may be called at any time.
 func (s *Server) SetTCPWriteTimeout(d time.Duration) {
-	s.tcpWriteTimeout = d
+	s.tcpWriteTimeout.Store(d)
 }
 
 // HasMeshKey reports whether the server is configured with a mesh key.
@@ -706,6 +721,11 @@ func (s *Server) registerClient(c *sclient) {
 
 	cs.activeClient.Store(c)
 
+	c.lim = newTrafficLimiter(s.rateLimits.Load(), false)
+	if !c.canMesh {
+		c.node = s.attachNodeLimiterLocked(c.key)
+	}
+
 	if _, ok := s.clientsMesh[c.key]; !ok {
 		s.clientsMesh[c.key] = nil // just for varz of total users in cluster
 	}
@@ -797,6 +817,9 @@ func (s *Server) unregisterClient(c *sclient) {
 	if c.canMesh {
 		delete(s.watchers, c)
 	}
+	if c.node != nil {
+		s.detachNodeLimiterLocked(c.key, c.node)
+	}
 
 	delete(s.keyOfAddr, c.remoteIPPort)
 
@@ -1183,6 +1206,16 @@ func (c *sclient) handleFrameSendPacket(ft derp.FrameType, fl uint32) error {
 	if err != nil {
 		return fmt.Errorf("client %v: recvPacket: %v", c.key, err)
 	}
+	if !c.canMesh {
+		ok, err := c.limitSend(len(contents))
+		if err != nil {
+			return fmt.Errorf("client %v: %w", c.key, err)
+		}
+		if !ok {
+			s.recordDrop(contents, c.key, dstKey, dropReasonQuota)
+			return nil
+		}
+	}
 
 	var fwd PacketForwarder
 	var dstLen int
@@ -1251,6 +1284,7 @@ type dropReasonKindLabels struct {
 	dropReasonQueueTail        dropReason = "queue_tail"          // destination queue is full, dropped packet at queue tail
 	dropReasonWriteError       dropReason = "write_error"         // OS write() failed
 	dropReasonDupClient        dropReason = "dup_client"          // the public key is connected 2+ times (active/active, fighting)
+	dropReasonQuota            dropReason = "quota_exceeded"      // the source or destination used up its daily quota
 )
 
 func (s *Server) recordDrop(packetBytes []byte, srcKey, dstKey key.NodePublic, reason dropReason) {
@@ -1281,6 +1315,11 @@ func (c *sclient) sendPkt(dst *sclient, p pkt) error {
 	s := c.s
 	dstKey := dst.key
 
+	if !dst.useQuota(s.rateLimits.Load(), len(p.bs)) {
+		s.recordDrop(p.bs, c.key, dstKey, dropReasonQuota)
+		return nil
+	}
+
 	// Attempt to queue for sending up to 3 times. On each attempt, if
 	// the queue is full, try to drop from queue head to prioritize
 	// fresher packets.
@@ -1379,7 +1418,7 @@ func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, inf
 	}
 
 	// tailscaled-based verification:
-	if s.verifyClientsLocalTailscaled {
+	if s.verifyClientsLocalTailscaled.Load() {
 		_, err := s.localClient.WhoIsNodeKey(ctx, clientKey)
 		if err == local.ErrPeerNotFound {
 			return fmt.Errorf("peer %v not authorized (not found in local tailscaled)", clientKey)
@@ -1394,7 +1433,7 @@ func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, inf
 	}
 
 	// admission controller-based verification:
-	if s.verifyClientsURL != "" {
+	if verifyURL := s.verifyClientsURL.Load(); verifyURL != "" {
 		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 		defer cancel()
 
@@ -1405,13 +1444,13 @@ func (s *Server) verifyClient(ctx context.Context, clientKey key.NodePublic, inf
 		if err != nil {
 			return err
 		}
-		req, err := http.NewRequestWithContext(ctx, "POST", s.verifyClientsURL, bytes.NewReader(jreq))
+		req, err := http.NewRequestWithContext(ctx, "POST", verifyURL, bytes.NewReader(jreq))
 		if err != nil {
 			return err
 		}
 		res, err := http.DefaultClient.Do(req)
 		if err != nil {
-			if s.verifyClientsURLFailOpen {
+			if s.verifyClientsURLFailOpen.Load() {
 				s.logf("admission controller unreachable; allowing client %v", clientKey)
 				return nil
 			}
@@ -1502,7 +1541,7 @@ func (s *Server) noteClientActivity(c *sclient) {
 type ServerInfo = derp.ServerInfo
 
 func (s *Server) sendServerInfo(bw *lazyBufioWriter, clientKey key.NodePublic) error {
-	msg, err := json.Marshal(ServerInfo{Version: derp.ProtocolVersion})
+	msg, err := json.Marshal(s.serverInfo())
 	if err != nil {
 		return err
 	}
@@ -1660,6 +1699,10 @@ type sclient struct {
 	// client that it's trying to establish a direct connection
 	// through us with a peer we have no record of.
 	peerGoneLim *rate.Limiter
+
+	// Set by registerClient.
+	lim  *trafficLimiter // this connection's rate limits
+	node *nodeLimiter    // the node's rate limits and quota; nil for mesh peers
 }
 
 func (c *sclient) presentFlags() derp.PeerPresentFlags {
@@ -1856,7 +1899,7 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 }
 
 func (c *sclient) setWriteDeadline() {
-	d := c.s.tcpWriteTimeout
+	d := c.s.tcpWriteTimeout.Load()
 	if c.canMesh {
 		// Trusted peers get more tolerance.
 		//
@@ -2233,6 +2276,9 @@ func (s *Server) ExpVar() expvar.Var {
 	m.Set("multiforwarder_deleted", &s.multiForwarderDeleted)
 	m.Set("packet_forwarder_delete_other_value", &s.removePktForwardOther)
 	m.Set("sclient_write_timeouts", &s.sclientWriteTimeouts)
+	m.Set("rate_limit_throttled_packets", &s.rateLimitThrottled)
+	m.Set("rate_limit_disconnects", &s.rateLimitDisconnects)
+	m.Set("gauge_nodes_over_quota", expvar.Func(func() any { return s.nodesOverQuota() }))
 	m.Set("average_queue_duration_ms", expvar.Func(func() any {
 		return math.Float64frombits(atomic.LoadUint64(s.avgQueueDuration))
 	}))
@@ -2280,7 +2326,7 @@ func (s *Server) ConsistencyCheck() error {
 			len(s.clients)))
 	}
 
-	if s.verifyClientsLocalTailscaled {
+	if s.verifyClientsLocalTailscaled.Load() {
 		if err := s.checkVerifyClientsLocalTailscaled(); err != nil {
 			errs = append(errs, err.Error())
 		}
@@ -616,6 +616,99 @@ func TestLimiter(t *testing.T) {
 	}
 }
 
+func TestTrafficLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tl := newTrafficLimiter(&RateLimits{ClientBytesPerSecond: 1000, ClientBytesBurst: 2000, NodePacketsPerSecond: 1}, false)
+	if d := tl.reserve(now, 1500); d != 0 {
+		t.Errorf("first reserve delay = %v; want 0", d)
+	}
+	if d := tl.reserve(now, 1500); d != time.Second {
+		t.Errorf("second reserve delay = %v; want 1s", d)
+	}
+	if tl.allow(now, 1) {
+		t.Error("allow succeeded with no tokens left")
+	}
+	if !tl.allow(now.Add(3*time.Second), 1500) {
+		t.Error("allow failed after refill")
+	}
+
+	// The node packet limit doesn't apply to client limiters, but does
+	// once set for a node.
+	tl.set(&RateLimits{NodePacketsPerSecond: 1}, true)
+	if !tl.allow(now, 1<<20) {
+		t.Error("first packet not allowed")
+	}
+	if tl.allow(now, 1) {
+		t.Error("second packet allowed beyond packet limit")
+	}
+	tl.set(nil, true)
+	if !tl.allow(now, 1) {
+		t.Error("allow failed after removing limits")
+	}
+}
+
+func TestNodeLimiterQuota(t *testing.T) {
+	nl := &nodeLimiter{lim: newTrafficLimiter(nil, true)}
+	day1 := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Hour)
+
+	if ok, first := nl.use(day1, 600, 1000); !ok || first {
+		t.Errorf("use = %v, %v; want true, false", ok, first)
+	}
+	if ok, first := nl.use(day1, 600, 1000); ok || !first {
+		t.Errorf("use over quota = %v, %v; want false, true", ok, first)
+	}
+	if ok, first := nl.use(day1, 100, 1000); !ok || first {
+		t.Errorf("use within remaining quota = %v, %v; want true, false", ok, first)
+	}
+	if ok, first := nl.use(day1, 600, 1000); ok || first {
+		t.Errorf("second use over quota = %v, %v; want false, false", ok, first)
+	}
+	if got := nl.usage(day1); got != 700 {
+		t.Errorf("usage = %d; want 700", got)
+	}
+	if !nl.isOverQuota(day1) {
+		t.Error("not over quota on day 1")
+	}
+	if nl.isOverQuota(day2) || nl.usage(day2) != 0 {
+		t.Error("quota not reset on day 2")
+	}
+	if ok, _ := nl.use(day2, 600, 1000); !ok {
+		t.Error("use on day 2 failed")
+	}
+}
+
+func TestSetRateLimits(t *testing.T) {
+	s := New(key.NewNode(), logger.Discard)
+	defer s.Close()
+
+	if si := s.serverInfo(); si != (ServerInfo{Version: derp.ProtocolVersion}) {
+		t.Errorf("serverInfo without limits = %+v", si)
+	}
+	s.SetRateLimits(&RateLimits{
+		ClientBytesPerSecond:   100_000,
+		NodeBytesPerSecond:     50_000,
+		ClientPacketsPerSecond: 100,
+		NodeDailyQuotaBytes:    1 << 30,
+	})
+	want := ServerInfo{
+		Version:                     derp.ProtocolVersion,
+		TokenBucketBytesPerSecond:   50_000,
+		TokenBucketBytesBurst:       derp.MaxPacketSize,
+		TokenBucketPacketsPerSecond: 100,
+		TokenBucketPacketsBurst:     100,
+		DailyQuotaBytes:             1 << 30,
+	}
+	if si := s.serverInfo(); si != want {
+		t.Errorf("serverInfo = %+v; want %+v", si, want)
+	}
+
+	s.SetRateLimits(&RateLimits{Disconnect: true})
+	if l := s.RateLimits(); l != nil {
+		t.Errorf("RateLimits after setting zero limits = %+v; want nil", l)
+	}
+}
+
 // BenchmarkConcurrentStreams exercises mutex contention on a
 // single Server instance with multiple concurrent client flows.
 func BenchmarkConcurrentStreams(b *testing.B) {
@@ -0,0 +1,349 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derpserver
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+	"tailscale.com/derp"
+	"tailscale.com/types/key"
+)
+
+// RateLimits configures how much traffic a Server relays for its clients.
+//
+// Rates and bursts are token buckets; a zero rate means no limit, and a zero
+// burst means one second's worth (but at least one max-size packet for
+// bytes). Limits apply to packets clients send, and never to mesh peers.
+type RateLimits struct {
+	// ClientBytesPerSecond and ClientBytesBurst limit the bytes each client
+	// connection may send.
+	ClientBytesPerSecond int `json:",omitempty"`
+	ClientBytesBurst     int `json:",omitempty"`
+
+	// ClientPacketsPerSecond and ClientPacketsBurst limit the packets each
+	// client connection may send.
+	ClientPacketsPerSecond int `json:",omitempty"`
+	ClientPacketsBurst     int `json:",omitempty"`
+
+	// NodeBytesPerSecond and NodeBytesBurst limit the bytes all connections
+	// of a node key together may send.
+	NodeBytesPerSecond int `json:",omitempty"`
+	NodeBytesBurst     int `json:",omitempty"`
+
+	// NodePacketsPerSecond and NodePacketsBurst limit the packets all
+	// connections of a node key together may send.
+	NodePacketsPerSecond int `json:",omitempty"`
+	NodePacketsBurst     int `json:",omitempty"`
+
+	// NodeDailyQuotaBytes, if non-zero, is how many bytes the server relays
+	// from and to each node key per UTC day. Once a node has used it up,
+	// its packets are dropped until the next day.
+	NodeDailyQuotaBytes int64 `json:",omitempty"`
+
+	// Disconnect is whether clients that exceed their rate limits or quota
+	// are disconnected. Otherwise, the server throttles them by reading
+	// their packets no faster than their limits allow.
+	Disconnect bool `json:",omitempty"`
+}
+
+// IsZero reports whether l doesn't limit anything.
+func (l *RateLimits) IsZero() bool {
+	if l == nil {
+		return true
+	}
+	v := *l
+	v.Disconnect = false
+	return v == RateLimits{}
+}
+
+var (
+	errRateLimited   = errors.New("rate limit exceeded")
+	errQuotaExceeded = errors.New("daily quota exceeded")
+)
+
+// SetRateLimits sets the rate limits and quotas of the server's clients. A
+// nil or zero l removes all limits.
+//
+// It may be called at any time. Connected clients get the new limits, but
+// are only told about them when they reconnect.
+func (s *Server) SetRateLimits(l *RateLimits) {
+	if l.IsZero() {
+		l = nil
+	} else {
+		l = new(*l)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimits.Store(l)
+	for _, cs := range s.clients {
+		cs.ForeachClient(func(c *sclient) {
+			c.lim.set(l, false)
+		})
+	}
+	for _, nl := range s.nodeLimiters {
+		nl.lim.set(l, true)
+	}
+}
+
+// RateLimits returns the rate limits and quotas of the server's clients, or
+// nil if there are none.
+func (s *Server) RateLimits() *RateLimits {
+	if l := s.rateLimits.Load(); l != nil {
+		return new(*l)
+	}
+	return nil
+}
+
+// trafficLimiter is a pair of token buckets limiting bytes and packets.
+// A nil limiter means no limit. Setting new limits replaces the limiters, so
+// their buckets start out full.
+type trafficLimiter struct {
+	bytes, packets atomic.Pointer[rate.Limiter]
+}
+
+func newTrafficLimiter(l *RateLimits, perNode bool) *trafficLimiter {
+	tl := new(trafficLimiter)
+	tl.set(l, perNode)
+	return tl
+}
+
+// set updates tl to the client (or if perNode, the node) limits of l.
+func (tl *trafficLimiter) set(l *RateLimits, perNode bool) {
+	var bps, bBurst, pps, pBurst int
+	if l != nil {
+		if perNode {
+			bps, bBurst, pps, pBurst = l.NodeBytesPerSecond, l.NodeBytesBurst, l.NodePacketsPerSecond, l.NodePacketsBurst
+		} else {
+			bps, bBurst, pps, pBurst = l.ClientBytesPerSecond, l.ClientBytesBurst, l.ClientPacketsPerSecond, l.ClientPacketsBurst
+		}
+	}
+	tl.bytes.Store(newLimiter(bps, bBurst, derp.MaxPacketSize))
+	tl.packets.Store(newLimiter(pps, pBurst, 1))
+}
+
+// newLimiter returns a limiter for perSecond tokens per second, or nil if
+// perSecond isn't positive. A zero burst means one second's worth, but at
+// least minBurst.
+func newLimiter(perSecond, burst, minBurst int) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = max(perSecond, minBurst)
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
+// reserve takes the tokens for a packet of n bytes from tl, and returns how
+// long the sender must wait before the packet is within the limits.
+func (tl *trafficLimiter) reserve(now time.Time, n int) time.Duration {
+	var d time.Duration
+	if lim := tl.bytes.Load(); lim != nil {
+		// Packets larger than the burst could never be reserved, so
+		// they take a full burst.
+		d = lim.ReserveN(now, min(n, lim.Burst())).DelayFrom(now)
+	}
+	if lim := tl.packets.Load(); lim != nil {
+		d = max(d, lim.ReserveN(now, 1).DelayFrom(now))
+	}
+	return d
+}
+
+// allow reports whether a packet of n bytes is within the limits of tl, and
+// takes its tokens if so.
+func (tl *trafficLimiter) allow(now time.Time, n int) bool {
+	if lim := tl.bytes.Load(); lim != nil && !lim.AllowN(now, min(n, lim.Burst())) {
+		return false
+	}
+	if lim := tl.packets.Load(); lim != nil && !lim.AllowN(now, 1) {
+		return false
+	}
+	return true
+}
+
+// nodeLimiter holds the limits and quota usage shared by all connections of
+// a node key.
+type nodeLimiter struct {
+	lim   *trafficLimiter
+	conns int // guarded by Server.mu
+
+	mu           sync.Mutex
+	day          int64 // days since the Unix epoch, UTC, that used is for
+	used         int64 // bytes relayed from and to the node on day
+	overQuotaDay int64 // day the node last exceeded its quota, or 0
+}
+
+// dayOf returns the number of days since the Unix epoch, in UTC, of t.
+func dayOf(t time.Time) int64 {
+	return t.Unix() / int64(24*time.Hour/time.Second)
+}
+
+// use adds n bytes to the quota usage of the node on the day of now, and
+// reports whether they're within the quota and whether they're the first
+// bytes over it that day.
+func (nl *nodeLimiter) use(now time.Time, n int, quota int64) (ok, first bool) {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	if day := dayOf(now); day != nl.day {
+		nl.day = day
+		nl.used = 0
+	}
+	if nl.used+int64(n) > quota {
+		first = nl.overQuotaDay != nl.day
+		nl.overQuotaDay = nl.day
+		return false, first
+	}
+	nl.used += int64(n)
+	return true, false
+}
+
+// usage returns the bytes used by the node on the day of now.
+func (nl *nodeLimiter) usage(now time.Time) int64 {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	if dayOf(now) != nl.day {
+		return 0
+	}
+	return nl.used
+}
+
+// isOverQuota reports whether the node has exceeded its quota on the day of
+// now.
+func (nl *nodeLimiter) isOverQuota(now time.Time) bool {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	return nl.overQuotaDay == dayOf(now)
+}
+
+// attachNodeLimiterLocked returns the nodeLimiter of k for a new connection,
+// creating it if needed.
+//
+// s.mu must be held.
+func (s *Server) attachNodeLimiterLocked(k key.NodePublic) *nodeLimiter {
+	now := s.clock.Now()
+	if day := dayOf(now); day != s.nodeLimitersPrunedDay {
+		// Forget the quota usage of disconnected nodes from previous
+		// days once a day.
+		s.nodeLimitersPrunedDay = day
+		for k, nl := range s.nodeLimiters {
+			if nl.conns == 0 && nl.usage(now) == 0 {
+				delete(s.nodeLimiters, k)
+			}
+		}
+	}
+	nl, ok := s.nodeLimiters[k]
+	if !ok {
+		nl = &nodeLimiter{lim: newTrafficLimiter(s.rateLimits.Load(), true)}
+		s.nodeLimiters[k] = nl
+	}
+	nl.conns++
+	return nl
+}
+
+// detachNodeLimiterLocked notes that a connection using the nodeLimiter of k
+// is gone. The nodeLimiter is kept while the node has used some of its quota
+// for the day, so that reconnecting doesn't reset it.
+//
+// s.mu must be held.
+func (s *Server) detachNodeLimiterLocked(k key.NodePublic, nl *nodeLimiter) {
+	nl.conns--
+	if nl.conns == 0 && nl.usage(s.clock.Now()) == 0 {
+		delete(s.nodeLimiters, k)
+	}
+}
+
+// limitSend applies the server's rate limits and quota to a packet of n bytes
+// sent by c, which isn't a mesh peer. It reports whether the packet should be
+// relayed, and returns an error if c should be disconnected.
+//
+// It's called by the run goroutine, and blocks while throttling c.
+func (c *sclient) limitSend(n int) (ok bool, err error) {
+	s := c.s
+	l := s.rateLimits.Load()
+	if l == nil {
+		return true, nil
+	}
+	now := s.clock.Now()
+	if l.Disconnect {
+		if !c.lim.allow(now, n) || !c.node.lim.allow(now, n) {
+			s.rateLimitDisconnects.Add(1)
+			return false, errRateLimited
+		}
+	} else if d := max(c.lim.reserve(now, n), c.node.lim.reserve(now, n)); d > 0 {
+		s.rateLimitThrottled.Add(1)
+		tc, ch := s.clock.NewTimer(d)
+		select {
+		case <-ch:
+		case <-c.done:
+			tc.Stop()
+			return false, nil
+		}
+	}
+	if !c.useQuota(l, n) {
+		if l.Disconnect {
+			s.rateLimitDisconnects.Add(1)
+			return false, errQuotaExceeded
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// useQuota adds n bytes relayed from or to c to its node's quota usage, and
+// reports whether they're within the quota.
+func (c *sclient) useQuota(l *RateLimits, n int) bool {
+	if l == nil || l.NodeDailyQuotaBytes <= 0 || c.node == nil {
+		return true
+	}
+	ok, first := c.node.use(c.s.clock.Now(), n, l.NodeDailyQuotaBytes)
+	if first {
+		c.logf("exceeded daily quota of %d bytes", l.NodeDailyQuotaBytes)
+	}
+	return ok
+}
+
+// serverInfo returns the ServerInfo to send to a client, advertising
+// the tighter of the per-client and per-node limits.
+func (s *Server) serverInfo() ServerInfo {
+	si := ServerInfo{Version: derp.ProtocolVersion}
+	l := s.rateLimits.Load()
+	if l == nil {
+		return si
+	}
+	tighter := func(a, aBurst, b, bBurst int) (int, int) {
+		if b > 0 && (a <= 0 || b < a) {
+			return b, bBurst
+		}
+		return a, aBurst
+	}
+	si.TokenBucketBytesPerSecond, si.TokenBucketBytesBurst = tighter(l.ClientBytesPerSecond, l.ClientBytesBurst, l.NodeBytesPerSecond, l.NodeBytesBurst)
+	si.TokenBucketPacketsPerSecond, si.TokenBucketPacketsBurst = tighter(l.ClientPacketsPerSecond, l.ClientPacketsBurst, l.NodePacketsPerSecond, l.NodePacketsBurst)
+	if si.TokenBucketBytesPerSecond > 0 && si.TokenBucketBytesBurst <= 0 {
+		si.TokenBucketBytesBurst = max(si.TokenBucketBytesPerSecond, derp.MaxPacketSize)
+	}
+	if si.TokenBucketPacketsPerSecond > 0 && si.TokenBucketPacketsBurst <= 0 {
+		si.TokenBucketPacketsBurst = si.TokenBucketPacketsPerSecond
+	}
+	si.DailyQuotaBytes = l.NodeDailyQuotaBytes
+	return si
+}
+
+// nodesOverQuota returns the number of node keys that have exceeded their
+// quota today.
+func (s *Server) nodesOverQuota() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	n := 0
+	for _, nl := range s.nodeLimiters {
+		if nl.isOverQuota(now) {
+			n++
+		}
+	}
+	return n
+}
@@ -7,9 +7,12 @@
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/netip"
+	"strconv"
+	"strings"
 
 	"tailscale.com/disco"
 	"tailscale.com/feature"
@@ -36,6 +39,17 @@ func init() {
 	feature.Register(featureName)
 	ipnext.RegisterExtension(featureName, newExtension)
 	localapi.Register("debug-peer-relay-sessions", servePeerRelayDebugSessions)
+	localapi.Register("peer-relay-sessions/", servePeerRelaySessions)
+}
+
+// limitsFromPrefs returns the relay server limits set in prefs.
+func limitsFromPrefs(prefs ipn.PrefsView) udprelay.Limits {
+	return udprelay.Limits{
+		MaxSessions:        prefs.RelayServerMaxSessions(),
+		MaxSessionsPerNode: prefs.RelayServerMaxSessionsPerNode(),
+		SessionBytesPerSec: prefs.RelayServerSessionBytesPerSec(),
+		IdleTimeout:        prefs.RelayServerSessionIdleTimeout().Duration,
+	}
 }
 
 // servePeerRelayDebugSessions is an HTTP handler for the Local API that
@@ -63,6 +77,46 @@ func servePeerRelayDebugSessions(h *localapi.Handler, w http.ResponseWriter, r *
 	w.Write(j)
 }
 
+// servePeerRelaySessions is an HTTP handler for the Local API that tears down
+// a peer relay session being relayed by this Tailscale node, as requested by
+// DELETE /localapi/v0/peer-relay-sessions/:vni. Its clients may allocate a
+// new one.
+func servePeerRelaySessions(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "peer relay session access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "DELETE" {
+		http.Error(w, "DELETE required", http.StatusMethodNotAllowed)
+		return
+	}
+	suffix, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/peer-relay-sessions/")
+	if !ok {
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	vni, err := strconv.ParseUint(suffix, 10, 32)
+	if err != nil {
+		http.Error(w, "invalid VNI", http.StatusBadRequest)
+		return
+	}
+
+	var e *extension
+	if ok := h.LocalBackend().FindMatchingExtension(&e); !ok {
+		http.Error(w, "peer relay server extension unavailable", http.StatusInternalServerError)
+		return
+	}
+	err = e.closeSession(uint32(vni))
+	switch {
+	case errors.Is(err, udprelay.ErrNoSuchSession):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 // newExtension is an [ipnext.NewExtensionFn] that creates a new relay server
 // extension. It is registered with [ipnext.RegisterExtension] if the package is
 // imported.
@@ -85,8 +139,11 @@ type relayServer interface {
 	Close() error
 	AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.ServerEndpoint, error)
 	GetSessions() []status.ServerSession
+	CloseSession(vni uint32) error
 	SetDERPMapView(tailcfg.DERPMapView)
 	SetStaticAddrPorts(addrPorts views.Slice[netip.AddrPort])
+	SetLimits(udprelay.Limits)
+	Limits() udprelay.Limits
 }
 
 // extension is an [ipnext.Extension] managing the relay server on platforms
@@ -102,6 +159,7 @@ type extension struct {
 	rs                            relayServer                 // nil when disabled
 	port                          *uint16                     // ipn.Prefs.RelayServerPort, nil if disabled
 	staticEndpoints               views.Slice[netip.AddrPort] // ipn.Prefs.RelayServerStaticEndpoints
+	limits                        udprelay.Limits             // from ipn.Prefs.RelayServerMaxSessions and friends
 	derpMapView                   tailcfg.DERPMapView         // latest seen over the eventbus
 	hasNodeAttrDisableRelayServer bool                        // [tailcfg.NodeAttrDisableRelayServer]
 }
@@ -181,6 +239,7 @@ func (e *extension) tryStartRelayServerLocked() {
 	}
 	e.rs = rs
 	e.rs.SetDERPMapView(e.derpMapView)
+	e.rs.SetLimits(e.limits)
 }
 
 func (e *extension) relayServerShouldBeRunningLocked() bool {
@@ -217,6 +276,12 @@ func (e *extension) profileStateChanged(_ ipn.LoginProfileView, prefs ipn.PrefsV
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	e.staticEndpoints = prefs.RelayServerStaticEndpoints()
+	if limits := limitsFromPrefs(prefs); limits != e.limits {
+		e.limits = limits
+		if e.rs != nil {
+			e.rs.SetLimits(limits)
+		}
+	}
 	newPort, ok := prefs.RelayServerPort().GetOk()
 	enableOrDisableServer := ok != (e.port != nil)
 	portChanged := ok && e.port != nil && newPort != *e.port
@@ -264,6 +329,23 @@ func (e *extension) serverStatus() status.ServerStatus {
 		return st
 	}
 	st.UDPPort = new(*e.port)
+	limits := e.rs.Limits()
+	st.Limits = status.ServerLimits{
+		MaxSessions:        limits.MaxSessions,
+		MaxSessionsPerNode: limits.MaxSessionsPerNode,
+		SessionBytesPerSec: limits.SessionBytesPerSec,
+		IdleTimeout:        limits.IdleTimeout,
+	}
 	st.Sessions = e.rs.GetSessions()
 	return st
 }
+
+// closeSession tears down the peer relay session with the provided VNI.
+func (e *extension) closeSession(vni uint32) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.rs == nil {
+		return udprelay.ErrNoSuchSession
+	}
+	return e.rs.CloseSession(vni)
+}
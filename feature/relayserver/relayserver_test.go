@@ -9,8 +9,10 @@
 	"reflect"
 	"slices"
 	"testing"
+	"time"
 
 	"tailscale.com/ipn"
+	"tailscale.com/net/udprelay"
 	"tailscale.com/net/udprelay/endpoint"
 	"tailscale.com/net/udprelay/status"
 	"tailscale.com/tailcfg"
@@ -246,6 +248,7 @@ func mockRelayServerNotZeroVal() *mockRelayServer {
 type mockRelayServer struct {
 	set       bool
 	addrPorts views.Slice[netip.AddrPort]
+	limits    udprelay.Limits
 }
 
 func (m *mockRelayServer) Close() error { return nil }
@@ -253,7 +256,10 @@ func (m *mockRelayServer) AllocateEndpoint(_, _ key.DiscoPublic) (endpoint.Serve
 	return endpoint.ServerEndpoint{}, errors.New("not implemented")
 }
 func (m *mockRelayServer) GetSessions() []status.ServerSession { return nil }
+func (m *mockRelayServer) CloseSession(uint32) error           { return udprelay.ErrNoSuchSession }
 func (m *mockRelayServer) SetDERPMapView(tailcfg.DERPMapView)  { return }
+func (m *mockRelayServer) SetLimits(l udprelay.Limits)         { m.limits = l }
+func (m *mockRelayServer) Limits() udprelay.Limits             { return m.limits }
 func (m *mockRelayServer) SetStaticAddrPorts(aps views.Slice[netip.AddrPort]) {
 	m.addrPorts = aps
 }
@@ -266,6 +272,42 @@ func (m mockSafeBackend) Sys() *tsd.System       { return m.sys }
 func (mockSafeBackend) Clock() tstime.Clock      { return nil }
 func (mockSafeBackend) TailscaleVarRoot() string { return "" }
 
+func Test_extension_limits(t *testing.T) {
+	sys := tsd.NewSystem()
+	ipne, err := newExtension(logger.Discard, mockSafeBackend{sys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := ipne.(*extension)
+	e.newServerFn = func(logf logger.Logf, port uint16, onlyStaticAddrPorts bool) (relayServer, error) {
+		return &mockRelayServer{}, nil
+	}
+	defer e.Shutdown()
+
+	prefs := &ipn.Prefs{
+		RelayServerPort:        new(uint16(1)),
+		RelayServerMaxSessions: 10,
+	}
+	e.profileStateChanged(ipn.LoginProfileView{}, prefs.View(), true)
+	if got := e.serverStatus().Limits.MaxSessions; got != 10 {
+		t.Errorf("MaxSessions = %d; want 10", got)
+	}
+
+	// Changing the limits applies them to the running server.
+	rs := e.rs
+	prefs.RelayServerMaxSessions = 0
+	prefs.RelayServerSessionBytesPerSec = 1 << 20
+	prefs.RelayServerSessionIdleTimeout = tstime.GoDuration{Duration: time.Minute}
+	e.profileStateChanged(ipn.LoginProfileView{}, prefs.View(), true)
+	if e.rs != rs {
+		t.Error("relay server restarted for changed limits")
+	}
+	want := status.ServerLimits{SessionBytesPerSec: 1 << 20, IdleTimeout: time.Minute}
+	if got := e.serverStatus().Limits; got != want {
+		t.Errorf("Limits = %+v; want %+v", got, want)
+	}
+}
+
 func Test_extension_handleRelayServerLifetimeLocked(t *testing.T) {
 	tests := []struct {
 		name                          string
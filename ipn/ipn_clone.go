@@ -11,6 +11,7 @@
 
 	"tailscale.com/drive"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
@@ -71,41 +72,48 @@ func (src *Prefs) Clone() *Prefs {
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _PrefsCloneNeedsRegeneration = Prefs(struct {
-	ControlURL                 string
-	RouteAll                   bool
-	ExitNodeID                 tailcfg.StableNodeID
-	ExitNodeIP                 netip.Addr
-	AutoExitNode               ExitNodeExpression
-	InternalExitNodePrior      tailcfg.StableNodeID
-	ExitNodeAllowLANAccess     bool
-	CorpDNS                    bool
-	RunSSH                     bool
-	RunWebClient               bool
-	WantRunning                bool
-	LoggedOut                  bool
-	ShieldsUp                  bool
-	AdvertiseTags              []string
-	Hostname                   string
-	NotepadURLs                bool
-	ForceDaemon                bool
-	Egg                        bool
-	AdvertiseRoutes            []netip.Prefix
-	AdvertiseServices          []string
-	Sync                       opt.Bool
-	NoSNAT                     bool
-	NoStatefulFiltering        opt.Bool
-	NetfilterMode              preftype.NetfilterMode
-	OperatorUser               string
-	ProfileName                string
-	AutoUpdate                 AutoUpdatePrefs
-	AppConnector               AppConnectorPrefs
-	PostureChecking            bool
-	NetfilterKind              string
-	DriveShares                []*drive.Share
-	RelayServerPort            *uint16
-	RelayServerStaticEndpoints []netip.AddrPort
-	AllowSingleHosts           marshalAsTrueInJSON
-	Persist                    *persist.Persist
+	ControlURL                    string
+	RouteAll                      bool
+	ExitNodeID                    tailcfg.StableNodeID
+	ExitNodeIP                    netip.Addr
+	AutoExitNode                  ExitNodeExpression
+	InternalExitNodePrior         tailcfg.StableNodeID
+	ExitNodeAllowLANAccess        bool
+	CorpDNS                       bool
+	RunSSH                        bool
+	RunWebClient                  bool
+	WantRunning                   bool
+	LoggedOut                     bool
+	ShieldsUp                     bool
+	AdvertiseTags                 []string
+	Hostname                      string
+	NotepadURLs                   bool
+	ForceDaemon                   bool
+	Egg                           bool
+	AdvertiseRoutes               []netip.Prefix
+	AdvertiseServices             []string
+	Sync                          opt.Bool
+	NoSNAT                        bool
+	NoStatefulFiltering           opt.Bool
+	NetfilterMode                 preftype.NetfilterMode
+	OperatorUser                  string
+	ProfileName                   string
+	AutoUpdate                    AutoUpdatePrefs
+	AppConnector                  AppConnectorPrefs
+	PostureChecking               bool
+	NetfilterKind                 string
+	DriveShares                   []*drive.Share
+	RelayServerPort               *uint16
+	RelayServerStaticEndpoints    []netip.AddrPort
+	RelayServerMaxSessions        int
+	RelayServerMaxSessionsPerNode int
+	RelayServerSessionBytesPerSec int64
+	RelayServerSessionIdleTimeout tstime.GoDuration
+	SSHRecordLocally              bool
+	SSHRecordingMaxSizeMB         int
+	SSHRecordingMaxAge            tstime.GoDuration
+	AllowSingleHosts              marshalAsTrueInJSON
+	Persist                       *persist.Persist
 }{})
 
 // Clone makes a deep copy of ServeConfig.
@@ -237,16 +245,66 @@ func (src *HTTPHandler) Clone() *HTTPHandler {
 	dst := new(HTTPHandler)
 	*dst = *src
 	dst.AcceptAppCaps = append(src.AcceptAppCaps[:0:0], src.AcceptAppCaps...)
+	dst.SetRequestHeaders = maps.Clone(src.SetRequestHeaders)
+	dst.RemoveRequestHeaders = append(src.RemoveRequestHeaders[:0:0], src.RemoveRequestHeaders...)
+	dst.SetResponseHeaders = maps.Clone(src.SetResponseHeaders)
+	dst.RemoveResponseHeaders = append(src.RemoveResponseHeaders[:0:0], src.RemoveResponseHeaders...)
+	dst.Upstreams = append(src.Upstreams[:0:0], src.Upstreams...)
+	dst.Weights = append(src.Weights[:0:0], src.Weights...)
+	if src.Routes != nil {
+		dst.Routes = make([]*HTTPRoute, len(src.Routes))
+		for i := range dst.Routes {
+			if src.Routes[i] == nil {
+				dst.Routes[i] = nil
+			} else {
+				dst.Routes[i] = src.Routes[i].Clone()
+			}
+		}
+	}
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerCloneNeedsRegeneration = HTTPHandler(struct {
-	Path          string
-	Proxy         string
-	Text          string
-	AcceptAppCaps []tailcfg.PeerCapability
-	Redirect      string
+	Path                  string
+	Proxy                 string
+	Text                  string
+	AcceptAppCaps         []tailcfg.PeerCapability
+	Redirect              string
+	SetRequestHeaders     map[string]string
+	RemoveRequestHeaders  []string
+	SetResponseHeaders    map[string]string
+	RemoveResponseHeaders []string
+	StripPrefix           string
+	ConnectTimeout        tstime.GoDuration
+	ResponseTimeout       tstime.GoDuration
+	ErrorPage             string
+	Upstreams             []string
+	LoadBalancing         string
+	HealthCheckPath       string
+	HealthCheckInterval   tstime.GoDuration
+	Weights               []int
+	Routes                []*HTTPRoute
+}{})
+
+// Clone makes a deep copy of HTTPRoute.
+// The result aliases no memory with the original.
+func (src *HTTPRoute) Clone() *HTTPRoute {
+	if src == nil {
+		return nil
+	}
+	dst := new(HTTPRoute)
+	*dst = *src
+	dst.Headers = maps.Clone(src.Headers)
+	dst.Handler = src.Handler.Clone()
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _HTTPRouteCloneNeedsRegeneration = HTTPRoute(struct {
+	Path    string
+	Headers map[string]string
+	Handler *HTTPHandler
 }{})
 
 // Clone makes a deep copy of WebServerConfig.
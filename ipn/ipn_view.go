@@ -14,13 +14,14 @@
 	"github.com/go-json-experiment/json/jsontext"
 	"tailscale.com/drive"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
 	"tailscale.com/types/views"
 )
 
-//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=LoginProfile,Prefs,ServeConfig,ServiceConfig,TCPPortHandler,HTTPHandler,WebServerConfig
+//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=LoginProfile,Prefs,ServeConfig,ServiceConfig,TCPPortHandler,HTTPHandler,HTTPRoute,WebServerConfig
 
 // View returns a read-only view of LoginProfile.
 func (p *LoginProfile) View() LoginProfileView {
@@ -453,6 +454,45 @@ func (v PrefsView) RelayServerStaticEndpoints() views.Slice[netip.AddrPort] {
 	return views.SliceOf(v.ж.RelayServerStaticEndpoints)
 }
 
+// RelayServerMaxSessions is the maximum number of sessions the relay
+// server relays at once. If zero, there's no limit.
+func (v PrefsView) RelayServerMaxSessions() int { return v.ж.RelayServerMaxSessions }
+
+// RelayServerMaxSessionsPerNode is the maximum number of sessions the
+// relay server relays at once that any one node is part of. If zero,
+// there's no limit.
+func (v PrefsView) RelayServerMaxSessionsPerNode() int { return v.ж.RelayServerMaxSessionsPerNode }
+
+// RelayServerSessionBytesPerSec is the maximum rate in bytes per second
+// at which the relay server forwards data for each session, summed
+// across both directions. If zero, there's no limit.
+func (v PrefsView) RelayServerSessionBytesPerSec() int64 { return v.ж.RelayServerSessionBytesPerSec }
+
+// RelayServerSessionIdleTimeout is how long a node can go without
+// sending anything in a relayed session before the relay server tears
+// it down. If zero, the default of 5 minutes is used.
+func (v PrefsView) RelayServerSessionIdleTimeout() tstime.GoDuration {
+	return v.ж.RelayServerSessionIdleTimeout
+}
+
+// SSHRecordLocally specifies whether Tailscale SSH sessions to this node
+// are recorded to the ssh-sessions directory of tailscaled's state
+// directory when the tailnet's SSH policy doesn't send recordings to
+// recorder nodes. Local recordings are kept according to
+// SSHRecordingMaxSizeMB and SSHRecordingMaxAge.
+func (v PrefsView) SSHRecordLocally() bool { return v.ж.SSHRecordLocally }
+
+// SSHRecordingMaxSizeMB is the maximum total size, in megabytes, of
+// local SSH session recordings, after which the oldest are deleted. If
+// zero, DefaultSSHRecordingMaxSizeMB is used. If negative, there's no
+// limit.
+func (v PrefsView) SSHRecordingMaxSizeMB() int { return v.ж.SSHRecordingMaxSizeMB }
+
+// SSHRecordingMaxAge is how long local SSH session recordings are
+// kept, after which they're deleted. If zero, recordings are only
+// deleted for size.
+func (v PrefsView) SSHRecordingMaxAge() tstime.GoDuration { return v.ж.SSHRecordingMaxAge }
+
 // AllowSingleHosts was a legacy field that was always true
 // for the past 4.5 years. It controlled whether Tailscale
 // peers got /32 or /128 routes for each other.
@@ -473,41 +513,48 @@ func (v PrefsView) Persist() persist.PersistView { return v.ж.Persist.View() }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _PrefsViewNeedsRegeneration = Prefs(struct {
-	ControlURL                 string
-	RouteAll                   bool
-	ExitNodeID                 tailcfg.StableNodeID
-	ExitNodeIP                 netip.Addr
-	AutoExitNode               ExitNodeExpression
-	InternalExitNodePrior      tailcfg.StableNodeID
-	ExitNodeAllowLANAccess     bool
-	CorpDNS                    bool
-	RunSSH                     bool
-	RunWebClient               bool
-	WantRunning                bool
-	LoggedOut                  bool
-	ShieldsUp                  bool
-	AdvertiseTags              []string
-	Hostname                   string
-	NotepadURLs                bool
-	ForceDaemon                bool
-	Egg                        bool
-	AdvertiseRoutes            []netip.Prefix
-	AdvertiseServices          []string
-	Sync                       opt.Bool
-	NoSNAT                     bool
-	NoStatefulFiltering        opt.Bool
-	NetfilterMode              preftype.NetfilterMode
-	OperatorUser               string
-	ProfileName                string
-	AutoUpdate                 AutoUpdatePrefs
-	AppConnector               AppConnectorPrefs
-	PostureChecking            bool
-	NetfilterKind              string
-	DriveShares                []*drive.Share
-	RelayServerPort            *uint16
-	RelayServerStaticEndpoints []netip.AddrPort
-	AllowSingleHosts           marshalAsTrueInJSON
-	Persist                    *persist.Persist
+	ControlURL                    string
+	RouteAll                      bool
+	ExitNodeID                    tailcfg.StableNodeID
+	ExitNodeIP                    netip.Addr
+	AutoExitNode                  ExitNodeExpression
+	InternalExitNodePrior         tailcfg.StableNodeID
+	ExitNodeAllowLANAccess        bool
+	CorpDNS                       bool
+	RunSSH                        bool
+	RunWebClient                  bool
+	WantRunning                   bool
+	LoggedOut                     bool
+	ShieldsUp                     bool
+	AdvertiseTags                 []string
+	Hostname                      string
+	NotepadURLs                   bool
+	ForceDaemon                   bool
+	Egg                           bool
+	AdvertiseRoutes               []netip.Prefix
+	AdvertiseServices             []string
+	Sync                          opt.Bool
+	NoSNAT                        bool
+	NoStatefulFiltering           opt.Bool
+	NetfilterMode                 preftype.NetfilterMode
+	OperatorUser                  string
+	ProfileName                   string
+	AutoUpdate                    AutoUpdatePrefs
+	AppConnector                  AppConnectorPrefs
+	PostureChecking               bool
+	NetfilterKind                 string
+	DriveShares                   []*drive.Share
+	RelayServerPort               *uint16
+	RelayServerStaticEndpoints    []netip.AddrPort
+	RelayServerMaxSessions        int
+	RelayServerMaxSessionsPerNode int
+	RelayServerSessionBytesPerSec int64
+	RelayServerSessionIdleTimeout tstime.GoDuration
+	SSHRecordLocally              bool
+	SSHRecordingMaxSizeMB         int
+	SSHRecordingMaxAge            tstime.GoDuration
+	AllowSingleHosts              marshalAsTrueInJSON
+	Persist                       *persist.Persist
 }{})
 
 // View returns a read-only view of ServeConfig.
@@ -925,13 +972,194 @@ func (v HTTPHandlerView) AcceptAppCaps() views.Slice[tailcfg.PeerCapability] {
 //   - ${REQUEST_URI}: replaced with the request's full URI (path and query string)
 func (v HTTPHandlerView) Redirect() string { return v.ж.Redirect }
 
+// SetRequestHeaders are headers to set on requests to the backend,
+// replacing any values sent by the client. A "Host" entry sets the Host
+// header. Tailscale-* headers can't be set.
+func (v HTTPHandlerView) SetRequestHeaders() views.Map[string, string] {
+	return views.MapOf(v.ж.SetRequestHeaders)
+}
+
+// RemoveRequestHeaders are headers to remove from requests to the
+// backend. Tailscale-* headers can't be removed.
+func (v HTTPHandlerView) RemoveRequestHeaders() views.Slice[string] {
+	return views.SliceOf(v.ж.RemoveRequestHeaders)
+}
+
+// SetResponseHeaders are headers to set on responses from the backend,
+// replacing any values it sent.
+func (v HTTPHandlerView) SetResponseHeaders() views.Map[string, string] {
+	return views.MapOf(v.ж.SetResponseHeaders)
+}
+
+// RemoveResponseHeaders are headers to remove from responses from the
+// backend.
+func (v HTTPHandlerView) RemoveResponseHeaders() views.Slice[string] {
+	return views.SliceOf(v.ж.RemoveResponseHeaders)
+}
+
+// StripPrefix, if not empty, is the prefix to strip from request paths
+// before proxying them, instead of the mount point. It's only stripped
+// from paths that have it. If StripPrefix is "/", paths are proxied
+// unmodified.
+func (v HTTPHandlerView) StripPrefix() string { return v.ж.StripPrefix }
+
+// ConnectTimeout, if non-zero, is how long to wait for a connection to
+// the backend for each request.
+func (v HTTPHandlerView) ConnectTimeout() tstime.GoDuration { return v.ж.ConnectTimeout }
+
+// ResponseTimeout, if non-zero, is how long to wait for the backend's
+// response headers after sending it the request.
+func (v HTTPHandlerView) ResponseTimeout() tstime.GoDuration { return v.ж.ResponseTimeout }
+
+// ErrorPage, if not empty, is the absolute path of a file to serve
+// when the backend can't be reached or times out, instead of a plain
+// "502 Bad Gateway" or "504 Gateway Timeout" error. If ErrorPage
+// starts with '<httpcode>:', then we use that status instead. Like
+// Path, it can only be set by local admins.
+func (v HTTPHandlerView) ErrorPage() string { return v.ж.ErrorPage }
+
+// Upstreams, if not empty, are additional backends for a Proxy
+// handler, in the same format as Proxy. Requests are spread over Proxy
+// and Upstreams according to LoadBalancing.
+func (v HTTPHandlerView) Upstreams() views.Slice[string] { return views.SliceOf(v.ж.Upstreams) }
+
+// LoadBalancing is how requests are spread over the backends of a
+// handler with Upstreams: LoadBalancingRoundRobin (the default) or
+// LoadBalancingLeastConn.
+func (v HTTPHandlerView) LoadBalancing() string { return v.ж.LoadBalancing }
+
+// HealthCheckPath, if not empty, is the absolute path on which the
+// backends of a handler with Upstreams are sent GET requests to check
+// their health. Backends that repeatedly fail to respond with a 2xx or
+// 3xx status get no requests until they pass again.
+func (v HTTPHandlerView) HealthCheckPath() string { return v.ж.HealthCheckPath }
+
+// HealthCheckInterval is how often backends are health checked, if
+// HealthCheckPath is set. If zero, DefaultHealthCheckInterval is used.
+// It must not be negative. Each check times out after the interval or
+// 5 seconds, whichever is shorter.
+func (v HTTPHandlerView) HealthCheckInterval() tstime.GoDuration { return v.ж.HealthCheckInterval }
+
+// Weights, if not empty, are the relative shares of requests that Proxy
+// and each of Upstreams get, in that order, with round-robin load
+// balancing. Backends without a weight have weight 1, and backends with
+// weight 0 get no requests.
+func (v HTTPHandlerView) Weights() views.Slice[int] { return views.SliceOf(v.ж.Weights) }
+
+// Routes, if not empty, are routes for requests that match conditions
+// beyond the handler's mount point. They're tried in order, and the
+// first one that matches serves the request. Requests that match none
+// are served by this handler, or get a 404 if it has nothing to serve.
+func (v HTTPHandlerView) Routes() views.SliceView[*HTTPRoute, HTTPRouteView] {
+	return views.SliceOfViews[*HTTPRoute, HTTPRouteView](v.ж.Routes)
+}
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerViewNeedsRegeneration = HTTPHandler(struct {
-	Path          string
-	Proxy         string
-	Text          string
-	AcceptAppCaps []tailcfg.PeerCapability
-	Redirect      string
+	Path                  string
+	Proxy                 string
+	Text                  string
+	AcceptAppCaps         []tailcfg.PeerCapability
+	Redirect              string
+	SetRequestHeaders     map[string]string
+	RemoveRequestHeaders  []string
+	SetResponseHeaders    map[string]string
+	RemoveResponseHeaders []string
+	StripPrefix           string
+	ConnectTimeout        tstime.GoDuration
+	ResponseTimeout       tstime.GoDuration
+	ErrorPage             string
+	Upstreams             []string
+	LoadBalancing         string
+	HealthCheckPath       string
+	HealthCheckInterval   tstime.GoDuration
+	Weights               []int
+	Routes                []*HTTPRoute
+}{})
+
+// View returns a read-only view of HTTPRoute.
+func (p *HTTPRoute) View() HTTPRouteView {
+	return HTTPRouteView{ж: p}
+}
+
+// HTTPRouteView provides a read-only view over HTTPRoute.
+//
+// Its methods should only be called if `Valid()` returns true.
+type HTTPRouteView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *HTTPRoute
+}
+
+// Valid reports whether v's underlying value is non-nil.
+func (v HTTPRouteView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v HTTPRouteView) AsStruct() *HTTPRoute {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+// MarshalJSON implements [jsonv1.Marshaler].
+func (v HTTPRouteView) MarshalJSON() ([]byte, error) {
+	return jsonv1.Marshal(v.ж)
+}
+
+// MarshalJSONTo implements [jsonv2.MarshalerTo].
+func (v HTTPRouteView) MarshalJSONTo(enc *jsontext.Encoder) error {
+	return jsonv2.MarshalEncode(enc, v.ж)
+}
+
+// UnmarshalJSON implements [jsonv1.Unmarshaler].
+func (v *HTTPRouteView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x HTTPRoute
+	if err := jsonv1.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+// UnmarshalJSONFrom implements [jsonv2.UnmarshalerFrom].
+func (v *HTTPRouteView) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	var x HTTPRoute
+	if err := jsonv2.UnmarshalDecode(dec, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+// Path, if not empty, is the path that requests must have exactly.
+// Otherwise, any path under the mount point matches.
+func (v HTTPRouteView) Path() string { return v.ж.Path }
+
+// Headers are the headers that requests must have, mapped to the value
+// each must have.
+func (v HTTPRouteView) Headers() views.Map[string, string] { return views.MapOf(v.ж.Headers) }
+
+// Handler serves the requests that match. Its own Routes are ignored.
+func (v HTTPRouteView) Handler() HTTPHandlerView { return v.ж.Handler.View() }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _HTTPRouteViewNeedsRegeneration = HTTPRoute(struct {
+	Path    string
+	Headers map[string]string
+	Handler *HTTPHandler
 }{})
 
 // View returns a read-only view of WebServerConfig.
@@ -326,6 +326,7 @@ type LocalBackend struct {
 
 	serveListeners     map[netip.AddrPort]*localListener // listeners for local serve traffic
 	serveProxyHandlers sync.Map                          // string (HTTPHandler.Proxy) => *reverseProxy
+	serveUpstreamPools sync.Map                          // string (upstreamPoolKey) => *upstreamPool
 
 	// dialPlan is any dial plan that we've received from the control
 	// server during a previous connection; it is cleared on logout.
@@ -826,6 +827,46 @@ func (b *LocalBackend) QueryDNS(name string, queryType dnsmessage.Type) (res []b
 	return res, rr, nil
 }
 
+// DNSCacheEntries returns the upstream DNS responses currently cached by the
+// built-in DNS resolver.
+func (b *LocalBackend) DNSCacheEntries() ([]apitype.DNSCacheEntry, error) {
+	if !buildfeatures.HasDNS {
+		return nil, feature.ErrUnavailable
+	}
+	manager, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return nil, errors.New("DNS manager not available")
+	}
+	ents := manager.Resolver().CacheEntries()
+	ret := make([]apitype.DNSCacheEntry, 0, len(ents))
+	for _, e := range ents {
+		ret = append(ret, apitype.DNSCacheEntry{
+			Name:     e.Name,
+			Type:     e.Type,
+			Upstream: e.Upstream,
+			RCode:    e.RCode,
+			Negative: e.Negative,
+			Records:  e.Records,
+			Expires:  e.Expires,
+		})
+	}
+	return ret, nil
+}
+
+// FlushDNSCache removes all upstream DNS responses cached by the built-in DNS
+// resolver.
+func (b *LocalBackend) FlushDNSCache() error {
+	if !buildfeatures.HasDNS {
+		return feature.ErrUnavailable
+	}
+	manager, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return errors.New("DNS manager not available")
+	}
+	manager.Resolver().FlushCache()
+	return nil
+}
+
 // GetComponentDebugLogging gets the time that component's debug logging is
 // enabled until, or the zero time if component's time is not currently
 // enabled.
@@ -8,6 +8,7 @@
 package ipnlocal
 
 import (
+	"cmp"
 	"context"
 	"crypto/sha256"
 	"crypto/tls"
@@ -16,15 +17,18 @@
 	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"maps"
 	"mime"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
 	"net/netip"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
@@ -92,6 +96,9 @@ type serveHTTPContext struct {
 	Funnel *funnelFlow
 	// AppCapabilities lists all PeerCapabilities that should be forwarded by serve
 	AppCapabilities views.Slice[tailcfg.PeerCapability]
+	// ProxyHandler is the Proxy handler serving the request, if any, for
+	// its header rules, timeouts and error page.
+	ProxyHandler ipn.HTTPHandlerView
 }
 
 // funnelFlow represents a funneled connection initiated via IngressPeer
@@ -924,6 +931,10 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "proxy is closed", http.StatusServiceUnavailable)
 		return
 	}
+	var h ipn.HTTPHandlerView
+	if c, ok := serveHTTPContextKey.ValueOk(r.Context()); ok {
+		h = c.ProxyHandler
+	}
 	p := &httputil.ReverseProxy{Rewrite: func(r *httputil.ProxyRequest) {
 		oldOutPath := r.Out.URL.Path
 		r.SetURL(rp.url)
@@ -946,6 +957,9 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			r.Out.Host = r.In.Host
 		}
 		addProxyForwardedHeaders(r)
+		if h.Valid() {
+			setProxyRequestHeaders(r, h)
+		}
 		rp.lb.addTailscaleIdentityHeaders(r)
 		if err := rp.lb.addAppCapabilitiesHeader(r); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -962,9 +976,133 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	} else {
 		p.Transport = rp.getTransport()
 	}
+	if !h.Valid() {
+		p.ServeHTTP(w, r)
+		return
+	}
+	if h.SetResponseHeaders().Len() > 0 || h.RemoveResponseHeaders().Len() > 0 {
+		p.ModifyResponse = func(res *http.Response) error {
+			for _, k := range h.RemoveResponseHeaders().All() {
+				res.Header.Del(k)
+			}
+			for k, v := range h.SetResponseHeaders().All() {
+				res.Header.Set(k, v)
+			}
+			return nil
+		}
+	}
+	connectTimeout, responseTimeout := h.ConnectTimeout().Duration, h.ResponseTimeout().Duration
+	if connectTimeout > 0 || responseTimeout > 0 {
+		var stop func()
+		r, stop = withProxyTimeouts(r, connectTimeout, responseTimeout)
+		defer stop()
+	}
+	if errorPage := h.ErrorPage(); errorPage != "" || connectTimeout > 0 || responseTimeout > 0 {
+		p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			code := http.StatusBadGateway
+			if errors.Is(context.Cause(r.Context()), errProxyTimeout) {
+				code = http.StatusGatewayTimeout
+			}
+			rp.logf("serve: proxy error for %s: %v", rp.backend, err)
+			rp.lb.serveProxyError(w, code, errorPage)
+		}
+	}
 	p.ServeHTTP(w, r)
 }
 
+// isTailscaleHeader reports whether the header name is one of the
+// Tailscale-* headers that serve sets itself.
+func isTailscaleHeader(name string) bool {
+	return strings.HasPrefix(http.CanonicalHeaderKey(name), "Tailscale-")
+}
+
+// setProxyRequestHeaders applies the request header rules of the Proxy
+// handler h to r.
+func setProxyRequestHeaders(r *httputil.ProxyRequest, h ipn.HTTPHandlerView) {
+	for _, k := range h.RemoveRequestHeaders().All() {
+		if !isTailscaleHeader(k) {
+			r.Out.Header.Del(k)
+		}
+	}
+	for k, v := range h.SetRequestHeaders().All() {
+		switch {
+		case isTailscaleHeader(k):
+		case http.CanonicalHeaderKey(k) == "Host":
+			r.Out.Host = v
+		default:
+			r.Out.Header.Set(k, v)
+		}
+	}
+}
+
+// errProxyTimeout is the cause of the cancellation of proxied requests that
+// time out.
+var errProxyTimeout = errors.New("timeout waiting for backend")
+
+// withProxyTimeouts returns a copy of r to proxy whose context is canceled
+// with errProxyTimeout if getting a connection to the backend takes longer
+// than connect, or the backend takes longer than response to start
+// responding once the request is sent. Zero durations mean no timeout.
+// The returned func must be called once the request is done.
+func withProxyTimeouts(r *http.Request, connect, response time.Duration) (_ *http.Request, stop func()) {
+	ctx, cancel := context.WithCancelCause(r.Context())
+	var (
+		mu    sync.Mutex
+		timer *time.Timer
+	)
+	// reset stops any running timer, and starts one for d if non-zero.
+	reset := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		if timer != nil {
+			timer.Stop()
+			timer = nil
+		}
+		if d > 0 {
+			timer = time.AfterFunc(d, func() { cancel(errProxyTimeout) })
+		}
+	}
+	reset(connect)
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn:              func(httptrace.GotConnInfo) { reset(0) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { reset(response) },
+		GotFirstResponseByte: func() { reset(0) },
+	})
+	return r.WithContext(ctx), func() {
+		reset(0)
+		cancel(nil)
+	}
+}
+
+// serveProxyError writes an error response with the status code to a
+// proxied request that failed. If errorPage is set, it's the
+// HTTPHandler.ErrorPage to serve instead of a plain error.
+func (b *LocalBackend) serveProxyError(w http.ResponseWriter, code int, errorPage string) {
+	if errorPage == "" {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	if len(errorPage) >= 4 && errorPage[3] == ':' {
+		if c, err := strconv.Atoi(errorPage[:3]); err == nil && c >= 400 && c <= 599 {
+			code, errorPage = c, errorPage[4:]
+		}
+	}
+	body, err := os.ReadFile(errorPage)
+	if err != nil {
+		b.logf("serve: reading error page: %v", err)
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	ctype := mime.TypeByExtension(filepath.Ext(errorPage))
+	if ctype == "" {
+		ctype = http.DetectContentType(body)
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
 // getTransport returns the Transport used for regular (non-GRPC) requests
 // to the backend. The Transport gets created lazily, at most once.
 func (rp *reverseProxy) getTransport() *http.Transport {
@@ -1144,6 +1282,12 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	// unrouted is whether h has routes, none of which r matches.
+	unrouted := h.Routes().Len() > 0
+	if rh, ok := matchHTTPRoute(h.Routes(), r); ok {
+		h = rh
+		unrouted = false
+	}
 	if s := h.Text(); s != "" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		io.WriteString(w, s)
@@ -1172,18 +1316,73 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		c.AppCapabilities = h.AcceptAppCaps()
-		h := p.(http.Handler)
-		// Trim the mount point from the URL path before proxying. (#6571)
-		if r.URL.Path != "/" {
-			h = http.StripPrefix(strings.TrimSuffix(mountPoint, "/"), h)
+		c.ProxyHandler = h
+		// Trim the mount point, or the handler's StripPrefix, from the
+		// URL path before proxying. (#6571)
+		prefix := strings.TrimSuffix(cmp.Or(h.StripPrefix(), mountPoint), "/")
+		strip := func(ph http.Handler) http.Handler {
+			if r.URL.Path != "/" && strings.HasPrefix(r.URL.Path, prefix) {
+				return http.StripPrefix(prefix, ph)
+			}
+			return ph
 		}
-		h.ServeHTTP(w, r)
+		if h.Upstreams().Len() > 0 {
+			b.serveUpstreams(w, r, h, strip)
+			return
+		}
+		strip(p.(http.Handler)).ServeHTTP(w, r)
+		return
+	}
+	if unrouted {
+		http.NotFound(w, r)
 		return
 	}
 
 	http.Error(w, "empty handler", 500)
 }
 
+// matchHTTPRoute returns the handler of the first of routes that r matches.
+func matchHTTPRoute(routes views.SliceView[*ipn.HTTPRoute, ipn.HTTPRouteView], r *http.Request) (_ ipn.HTTPHandlerView, ok bool) {
+	for _, rt := range routes.All() {
+		if p := rt.Path(); p != "" && p != r.URL.Path {
+			continue
+		}
+		if !rt.Handler().Valid() || !matchHeaders(rt.Headers(), r.Header) {
+			continue
+		}
+		return rt.Handler(), true
+	}
+	return ipn.HTTPHandlerView{}, false
+}
+
+// matchHeaders reports whether hdr has each of the headers in want with the
+// wanted value.
+func matchHeaders(want views.Map[string, string], hdr http.Header) bool {
+	for k, v := range want.All() {
+		if hdr.Get(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// allHTTPHandlers returns an iterator over the handlers of conf and of their
+// routes, with their mount points.
+func allHTTPHandlers(conf ipn.WebServerConfigView) iter.Seq2[string, ipn.HTTPHandlerView] {
+	return func(yield func(string, ipn.HTTPHandlerView) bool) {
+		for mount, h := range conf.Handlers().All() {
+			if !yield(mount, h) {
+				return
+			}
+			for _, rt := range h.Routes().All() {
+				if rt.Handler().Valid() && !yield(mount, rt.Handler()) {
+					return
+				}
+			}
+		}
+	}
+}
+
 func (b *LocalBackend) serveFileOrDirectory(w http.ResponseWriter, r *http.Request, fileOrDir, mountPoint string) {
 	fi, err := os.Stat(fileOrDir)
 	if err != nil {
@@ -1339,28 +1538,30 @@ func (b *LocalBackend) setServeProxyHandlersLocked() {
 	}
 	var backends map[string]bool
 	for _, conf := range b.serveConfig.Webs() {
-		for _, h := range conf.Handlers().All() {
-			backend := h.Proxy()
-			if backend == "" {
+		for _, h := range allHTTPHandlers(conf) {
+			if h.Proxy() == "" {
 				// Only create proxy handlers for servers with a proxy backend.
 				continue
 			}
-			mak.Set(&backends, backend, true)
-			if _, ok := b.serveProxyHandlers.Load(backend); ok {
-				continue
-			}
+			for _, backend := range upstreamBackends(h) {
+				mak.Set(&backends, backend, true)
+				if _, ok := b.serveProxyHandlers.Load(backend); ok {
+					continue
+				}
 
-			b.logf("serve: creating a new proxy handler for %s", backend)
-			p, err := b.proxyHandlerForBackend(backend)
-			if err != nil {
-				// The backend endpoint (h.Proxy) should have been validated by expandProxyTarget
-				// in the CLI, so just log the error here.
-				b.logf("[unexpected] could not create proxy for %v: %s", backend, err)
-				continue
+				b.logf("serve: creating a new proxy handler for %s", backend)
+				p, err := b.proxyHandlerForBackend(backend)
+				if err != nil {
+					// The backend endpoint (h.Proxy) should have been validated by expandProxyTarget
+					// in the CLI, so just log the error here.
+					b.logf("[unexpected] could not create proxy for %v: %s", backend, err)
+					continue
+				}
+				b.serveProxyHandlers.Store(backend, p)
 			}
-			b.serveProxyHandlers.Store(backend, p)
 		}
 	}
+	b.setServeUpstreamPoolsLocked()
 
 	// Clean up handlers for proxy backends that are no longer present
 	// in configuration.
@@ -1673,6 +1874,15 @@ func validateServeConfigUpdate(existing, incoming ipn.ServeConfigView) error {
 		}
 	}
 
+	// Health check intervals must be positive, or zero for the default.
+	for hp, conf := range incoming.Webs() {
+		for mount, h := range allHTTPHandlers(conf) {
+			if d := h.HealthCheckInterval().Duration; d < 0 {
+				return fmt.Errorf("invalid health check interval %v for %s%s: must not be negative", d, hp, mount)
+			}
+		}
+	}
+
 	if !existing.Valid() {
 		return nil
 	}
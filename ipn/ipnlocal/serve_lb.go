@@ -0,0 +1,344 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_serve
+
+package ipnlocal
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/util/mak"
+)
+
+const (
+	// upstreamEjectAfter is the number of successive failed health checks
+	// after which a backend gets no more requests.
+	upstreamEjectAfter = 2
+
+	// upstreamRestoreAfter is the number of successive passed health
+	// checks after which an ejected backend gets requests again.
+	upstreamRestoreAfter = 2
+
+	// upstreamMaxCheckTimeout is the longest a health check may take.
+	upstreamMaxCheckTimeout = 5 * time.Second
+)
+
+// upstreamPool spreads the requests to a Proxy handler with Upstreams over
+// its backends, and health checks them if the handler has a
+// HealthCheckPath.
+//
+// Handlers with the same backends and options share a pool.
+type upstreamPool struct {
+	b         *LocalBackend
+	leastConn bool
+	weighted  bool
+	upstreams []*upstream // Proxy, then Upstreams
+
+	next   atomic.Uint32      // round-robin counter
+	cancel context.CancelFunc // stops health checks; nil if there are none
+}
+
+// upstream is a backend of an upstreamPool.
+type upstream struct {
+	backend string       // as in HTTPHandler.Proxy
+	weight  int          // as in HTTPHandler.Weights
+	active  atomic.Int64 // requests in flight
+
+	mu        sync.Mutex
+	healthy   bool
+	failures  int // successive failed health checks
+	successes int // successive passed health checks
+	lastCheck time.Time
+	lastErr   string
+}
+
+// upstreamBackends returns the backends of the Proxy handler h, in order.
+func upstreamBackends(h ipn.HTTPHandlerView) []string {
+	return append([]string{h.Proxy()}, h.Upstreams().AsSlice()...)
+}
+
+// upstreamPoolKey returns the key of the upstreamPool for the Proxy handler
+// h with Upstreams in LocalBackend.serveUpstreamPools.
+func upstreamPoolKey(h ipn.HTTPHandlerView) string {
+	return fmt.Sprintf("%q %v %s %s %v", upstreamBackends(h), h.Weights().AsSlice(), h.LoadBalancing(), h.HealthCheckPath(), h.HealthCheckInterval().Duration)
+}
+
+// newUpstreamPool returns a pool for the Proxy handler h with Upstreams, and
+// starts health checking its backends if h has a HealthCheckPath. The
+// caller must call close when it's no longer needed.
+func (b *LocalBackend) newUpstreamPool(h ipn.HTTPHandlerView) *upstreamPool {
+	p := &upstreamPool{
+		b:         b,
+		leastConn: h.LoadBalancing() == ipn.LoadBalancingLeastConn,
+		weighted:  h.Weights().Len() > 0,
+	}
+	for i, backend := range upstreamBackends(h) {
+		weight := 1
+		if i < h.Weights().Len() {
+			weight = max(h.Weights().At(i), 0)
+		}
+		p.upstreams = append(p.upstreams, &upstream{backend: backend, weight: weight, healthy: true})
+	}
+	if path := h.HealthCheckPath(); path != "" {
+		interval := healthCheckInterval(h)
+		ctx, cancel := context.WithCancel(b.ctx)
+		p.cancel = cancel
+		b.goTracker.Go(func() { p.runHealthChecks(ctx, path, interval) })
+	}
+	return p
+}
+
+// healthCheckInterval returns how often the backends of the Proxy handler h
+// with Upstreams are health checked.
+func healthCheckInterval(h ipn.HTTPHandlerView) time.Duration {
+	if d := h.HealthCheckInterval().Duration; d > 0 {
+		return d
+	}
+	// validateServeConfigUpdate rejects negative intervals, but configs
+	// saved before it did may still have them, and time.NewTicker panics
+	// on them.
+	return ipn.DefaultHealthCheckInterval
+}
+
+// close stops the health checks of p, if any.
+func (p *upstreamPool) close() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+}
+
+// pick returns the backend to send the next request to. It only picks from
+// healthy backends, unless there are none, in which case it picks from all
+// of them, as failing health checks may be less bad than failing requests.
+// If p is weighted, backends with weight 0 are never picked.
+func (p *upstreamPool) pick() *upstream {
+	candidates := make([]*upstream, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		if p.eligible(u) && u.isHealthy() {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, u := range p.upstreams {
+			if p.eligible(u) {
+				candidates = append(candidates, u)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = p.upstreams
+	}
+	n := len(candidates)
+	if p.weighted && !p.leastConn {
+		total := 0
+		for _, u := range candidates {
+			total += u.weight
+		}
+		if total > 0 {
+			i := int(p.next.Add(1)-1) % total
+			for _, u := range candidates {
+				if i < u.weight {
+					return u
+				}
+				i -= u.weight
+			}
+		}
+	}
+	start := int(p.next.Add(1)-1) % n
+	if !p.leastConn {
+		return candidates[start]
+	}
+	// Start from the round-robin position so that ties are spread too.
+	best := candidates[start]
+	for i := 1; i < n; i++ {
+		if u := candidates[(start+i)%n]; u.active.Load() < best.active.Load() {
+			best = u
+		}
+	}
+	return best
+}
+
+// eligible reports whether u may be picked at all.
+func (p *upstreamPool) eligible(u *upstream) bool {
+	return !p.weighted || u.weight > 0
+}
+
+func (u *upstream) isHealthy() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.healthy
+}
+
+// runHealthChecks health checks the backends of p every interval until ctx
+// is done.
+func (p *upstreamPool) runHealthChecks(ctx context.Context, path string, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, u := range p.upstreams {
+			wg.Go(func() { p.checkHealth(ctx, u, path, min(interval, upstreamMaxCheckTimeout)) })
+		}
+		wg.Wait()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// checkHealth sends a health check request for path to u, and updates u's
+// health with the result.
+func (p *upstreamPool) checkHealth(ctx context.Context, u *upstream, path string, timeout time.Duration) {
+	err := p.sendHealthCheck(ctx, u.backend, path, timeout)
+	if ctx.Err() != nil {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.lastCheck = p.b.clock.Now()
+	if err != nil {
+		u.lastErr = err.Error()
+		u.successes = 0
+		u.failures++
+		if u.healthy && u.failures >= upstreamEjectAfter {
+			u.healthy = false
+			p.b.logf("serve: upstream %s failed health checks, ejecting: %v", u.backend, err)
+		}
+		return
+	}
+	u.lastErr = ""
+	u.failures = 0
+	u.successes++
+	if !u.healthy && u.successes >= upstreamRestoreAfter {
+		u.healthy = true
+		p.b.logf("serve: upstream %s passed health checks, restoring", u.backend)
+	}
+}
+
+// sendHealthCheck sends a GET request for path to backend, returning an
+// error if it fails or the response status isn't 2xx or 3xx.
+func (p *upstreamPool) sendHealthCheck(ctx context.Context, backend, path string, timeout time.Duration) error {
+	v, ok := p.b.serveProxyHandlers.Load(backend)
+	if !ok {
+		return fmt.Errorf("no proxy for %s", backend)
+	}
+	rp := v.(*reverseProxy)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", rp.url.Scheme+"://"+rp.url.Host+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "tailscale-serve-health-check")
+	res, err := rp.getTransport().RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %q", res.Status)
+	}
+	return nil
+}
+
+// setServeUpstreamPoolsLocked ensures there is an upstreamPool for each Proxy
+// handler with Upstreams in serveConfig, and closes the pools of handlers
+// that are gone.
+func (b *LocalBackend) setServeUpstreamPoolsLocked() {
+	var keys map[string]bool
+	if b.serveConfig.Valid() {
+		for _, conf := range b.serveConfig.Webs() {
+			for _, h := range allHTTPHandlers(conf) {
+				if h.Proxy() == "" || h.Upstreams().Len() == 0 {
+					continue
+				}
+				key := upstreamPoolKey(h)
+				mak.Set(&keys, key, true)
+				if _, ok := b.serveUpstreamPools.Load(key); ok {
+					continue
+				}
+				b.serveUpstreamPools.Store(key, b.newUpstreamPool(h))
+			}
+		}
+	}
+	b.serveUpstreamPools.Range(func(key, value any) bool {
+		if !keys[key.(string)] {
+			b.serveUpstreamPools.Delete(key)
+			value.(*upstreamPool).close()
+		}
+		return true
+	})
+}
+
+// serveUpstreams proxies r to a backend of the Proxy handler h with
+// Upstreams, through the proxy handler of the backend.
+func (b *LocalBackend) serveUpstreams(w http.ResponseWriter, r *http.Request, h ipn.HTTPHandlerView, strip func(http.Handler) http.Handler) {
+	v, ok := b.serveUpstreamPools.Load(upstreamPoolKey(h))
+	if !ok {
+		http.Error(w, "unknown proxy destination", http.StatusInternalServerError)
+		return
+	}
+	u := v.(*upstreamPool).pick()
+	p, ok := b.serveProxyHandlers.Load(u.backend)
+	if !ok {
+		http.Error(w, "unknown proxy destination", http.StatusInternalServerError)
+		return
+	}
+	u.active.Add(1)
+	defer u.active.Add(-1)
+	strip(p.(http.Handler)).ServeHTTP(w, r)
+}
+
+// ServeUpstreams returns the status of the backends of the Proxy handlers
+// with Upstreams in the current serve config, sorted by handler.
+func (b *LocalBackend) ServeUpstreams() []apitype.ServeUpstreamStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.serveConfig.Valid() {
+		return nil
+	}
+	var ret []apitype.ServeUpstreamStatus
+	for hp, conf := range b.serveConfig.Webs() {
+		for mount, h := range allHTTPHandlers(conf) {
+			if h.Proxy() == "" || h.Upstreams().Len() == 0 {
+				continue
+			}
+			v, ok := b.serveUpstreamPools.Load(upstreamPoolKey(h))
+			if !ok {
+				continue
+			}
+			for _, u := range v.(*upstreamPool).upstreams {
+				u.mu.Lock()
+				ret = append(ret, apitype.ServeUpstreamStatus{
+					HostPort:       string(hp),
+					Mount:          mount,
+					Backend:        u.backend,
+					Healthy:        u.healthy,
+					ActiveRequests: u.active.Load(),
+					LastCheck:      u.lastCheck,
+					LastError:      u.lastErr,
+				})
+				u.mu.Unlock()
+			}
+		}
+	}
+	slices.SortStableFunc(ret, func(a, b apitype.ServeUpstreamStatus) int {
+		return cmp.Or(strings.Compare(a.HostPort, b.HostPort), strings.Compare(a.Mount, b.Mount))
+	})
+	return ret
+}
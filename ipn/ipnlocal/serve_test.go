@@ -14,7 +14,9 @@
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"mime"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
@@ -22,7 +24,9 @@
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -33,6 +37,7 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/tstest"
+	"tailscale.com/tstime"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
@@ -801,6 +806,404 @@ type headerCheck struct {
 	}
 }
 
+func TestServeHTTPProxyOptions(t *testing.T) {
+	b := newTestBackend(t)
+
+	// Start test serve endpoint.
+	testServ := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// Reflect the request's path and headers back in the
+			// response, so they can be checked below.
+			w.Header().Set("Path", r.URL.Path)
+			w.Header().Set("Req-Host", r.Host)
+			for key, val := range r.Header {
+				w.Header().Set("Req-"+key, strings.Join(val, ","))
+			}
+			w.Header().Set("Server", "backend")
+			w.Header().Set("X-Powered-By", "backend")
+			if r.URL.Path == "/slow" {
+				time.Sleep(time.Second)
+			}
+		},
+	))
+	defer testServ.Close()
+
+	// A listener that's closed, to refuse connections.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadBackend := "http://" + ln.Addr().String()
+	ln.Close()
+
+	errorPage := filepath.Join(t.TempDir(), "error.html")
+	if err := os.WriteFile(errorPage, []byte("<h1>down</h1>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		handler     *ipn.HTTPHandler
+		mountPoint  string
+		requestPath string
+		wantCode    int
+		wantHeaders map[string]string
+		wantBody    string
+	}{
+		{
+			name: "headers",
+			handler: &ipn.HTTPHandler{
+				Proxy:                 testServ.URL,
+				SetRequestHeaders:     map[string]string{"X-Set": "1", "Host": "backend.internal", "Tailscale-User-Login": "evil"},
+				RemoveRequestHeaders:  []string{"X-Remove", "Tailscale-Headers-Info"},
+				SetResponseHeaders:    map[string]string{"Server": "serve", "Strict-Transport-Security": "max-age=60"},
+				RemoveResponseHeaders: []string{"X-Powered-By"},
+			},
+			mountPoint:  "/",
+			requestPath: "/",
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string]string{
+				"Req-X-Set":                  "1",
+				"Req-X-Remove":               "",
+				"Req-Host":                   "backend.internal",
+				"Req-Tailscale-User-Login":   "someone@example.com",
+				"Req-Tailscale-Headers-Info": "https://tailscale.com/s/serve-headers",
+				"Server":                     "serve",
+				"Strict-Transport-Security":  "max-age=60",
+				"X-Powered-By":               "",
+			},
+		},
+		{
+			name:        "strip-nothing",
+			handler:     &ipn.HTTPHandler{Proxy: testServ.URL, StripPrefix: "/"},
+			mountPoint:  "/foo",
+			requestPath: "/foo/bar",
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string]string{"Path": "/foo/bar"},
+		},
+		{
+			name:        "strip-longer-prefix",
+			handler:     &ipn.HTTPHandler{Proxy: testServ.URL, StripPrefix: "/foo/v1"},
+			mountPoint:  "/foo",
+			requestPath: "/foo/v1/bar",
+			wantCode:    http.StatusOK,
+			wantHeaders: map[string]string{"Path": "/bar"},
+		},
+		{
+			name:        "response-timeout",
+			handler:     &ipn.HTTPHandler{Proxy: testServ.URL, ResponseTimeout: tstime.GoDuration{Duration: 50 * time.Millisecond}},
+			mountPoint:  "/",
+			requestPath: "/slow",
+			wantCode:    http.StatusGatewayTimeout,
+		},
+		{
+			name:        "response-timeout-not-hit",
+			handler:     &ipn.HTTPHandler{Proxy: testServ.URL, ResponseTimeout: tstime.GoDuration{Duration: 10 * time.Second}},
+			mountPoint:  "/",
+			requestPath: "/",
+			wantCode:    http.StatusOK,
+		},
+		{
+			name:        "error-page",
+			handler:     &ipn.HTTPHandler{Proxy: deadBackend, ErrorPage: errorPage},
+			mountPoint:  "/",
+			requestPath: "/",
+			wantCode:    http.StatusBadGateway,
+			wantHeaders: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+			wantBody:    "<h1>down</h1>",
+		},
+		{
+			name:        "error-page-with-code",
+			handler:     &ipn.HTTPHandler{Proxy: deadBackend, ErrorPage: "503:" + errorPage},
+			mountPoint:  "/",
+			requestPath: "/",
+			wantCode:    http.StatusServiceUnavailable,
+			wantBody:    "<h1>down</h1>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+						tt.mountPoint: tt.handler,
+					}},
+				},
+			}
+			if err := b.SetServeConfig(conf, ""); err != nil {
+				t.Fatal(err)
+			}
+			req := &http.Request{
+				URL:    &url.URL{Path: tt.requestPath},
+				Header: http.Header{"X-Remove": {"1"}},
+				Host:   "example.ts.net",
+				TLS:    &tls.ConnectionState{ServerName: "example.ts.net"},
+			}
+			req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+				DestPort: 443,
+				SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+			}))
+
+			w := httptest.NewRecorder()
+			b.serveWebHandler(w, req)
+
+			res := w.Result()
+			if res.StatusCode != tt.wantCode {
+				t.Errorf("status = %d; want %d", res.StatusCode, tt.wantCode)
+			}
+			for k, want := range tt.wantHeaders {
+				if got := res.Header.Get(k); got != want {
+					t.Errorf("header %q = %q; want %q", k, got, want)
+				}
+			}
+			if tt.wantBody != "" {
+				if got := w.Body.String(); got != tt.wantBody {
+					t.Errorf("body = %q; want %q", got, tt.wantBody)
+				}
+			}
+		})
+	}
+}
+
+func TestServeUpstreams(t *testing.T) {
+	b := newTestBackend(t)
+
+	var bHealthy atomic.Bool
+	bHealthy.Store(true)
+	newBackend := func(name string, healthy *atomic.Bool) *httptest.Server {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/healthz" && healthy != nil && !healthy.Load() {
+				http.Error(w, "unhealthy", http.StatusServiceUnavailable)
+				return
+			}
+			io.WriteString(w, name)
+		}))
+		t.Cleanup(s.Close)
+		return s
+	}
+	backendA := newBackend("a", nil)
+	backendB := newBackend("b", &bHealthy)
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/": {
+					Proxy:               backendA.URL,
+					Upstreams:           []string{backendB.URL},
+					HealthCheckPath:     "/healthz",
+					HealthCheckInterval: tstime.GoDuration{Duration: 10 * time.Millisecond},
+				},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() string {
+		t.Helper()
+		req := &http.Request{
+			URL:  &url.URL{Path: "/"},
+			Host: "example.ts.net",
+			TLS:  &tls.ConnectionState{ServerName: "example.ts.net"},
+		}
+		req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+			DestPort: 443,
+			SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+		}))
+		w := httptest.NewRecorder()
+		b.serveWebHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d; want 200", w.Code)
+		}
+		return w.Body.String()
+	}
+	waitHealthy := func(backend string, want bool) {
+		t.Helper()
+		for range 500 {
+			for _, st := range b.ServeUpstreams() {
+				if st.Backend == backend && st.Healthy == want && !st.LastCheck.IsZero() {
+					return
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%s never became healthy=%v: %+v", backend, want, b.ServeUpstreams())
+	}
+
+	// Requests are spread over both backends in turn.
+	waitHealthy(backendB.URL, true)
+	got := []string{get(), get(), get(), get()}
+	if got[0] == got[1] || got[0] != got[2] || got[1] != got[3] {
+		t.Errorf("responses = %q; want alternating backends", got)
+	}
+
+	// A failing backend gets ejected, and restored when it recovers.
+	bHealthy.Store(false)
+	waitHealthy(backendB.URL, false)
+	for range 4 {
+		if got := get(); got != "a" {
+			t.Errorf("response from ejected backend %q", got)
+		}
+	}
+	sts := b.ServeUpstreams()
+	if len(sts) != 2 || sts[0].HostPort != "example.ts.net:443" || sts[0].Mount != "/" || sts[1].LastError == "" {
+		t.Errorf("ServeUpstreams = %+v", sts)
+	}
+	bHealthy.Store(true)
+	waitHealthy(backendB.URL, true)
+
+	// Removing the upstreams closes the pool.
+	conf.Web["example.ts.net:443"].Handlers["/"].Upstreams = nil
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+	if sts := b.ServeUpstreams(); len(sts) != 0 {
+		t.Errorf("ServeUpstreams after removing upstreams = %+v", sts)
+	}
+	n := 0
+	b.serveUpstreamPools.Range(func(_, _ any) bool { n++; return true })
+	if n != 0 {
+		t.Errorf("%d upstream pools left", n)
+	}
+
+	// Negative health check intervals are rejected.
+	conf.Web["example.ts.net:443"].Handlers["/"].Upstreams = []string{backendB.URL}
+	conf.Web["example.ts.net:443"].Handlers["/"].HealthCheckInterval.Duration = -time.Second
+	if err := b.SetServeConfig(conf, ""); err == nil {
+		t.Error("SetServeConfig with negative health check interval succeeded")
+	}
+}
+
+func TestHealthCheckInterval(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		want     time.Duration
+	}{
+		{0, ipn.DefaultHealthCheckInterval},
+		{-time.Second, ipn.DefaultHealthCheckInterval},
+		{time.Minute, time.Minute},
+	}
+	for _, tt := range tests {
+		h := &ipn.HTTPHandler{HealthCheckInterval: tstime.GoDuration{Duration: tt.interval}}
+		if got := healthCheckInterval(h.View()); got != tt.want {
+			t.Errorf("healthCheckInterval(%v) = %v; want %v", tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestUpstreamPoolPick(t *testing.T) {
+	p := &upstreamPool{leastConn: true}
+	for _, backend := range []string{"a", "b", "c"} {
+		p.upstreams = append(p.upstreams, &upstream{backend: backend, healthy: true})
+	}
+	p.upstreams[0].active.Store(3)
+	p.upstreams[1].active.Store(1)
+	p.upstreams[2].active.Store(2)
+	for range 3 {
+		if got := p.pick().backend; got != "b" {
+			t.Errorf("least-conn picked %q; want b", got)
+		}
+	}
+
+	// Unhealthy backends aren't picked, unless they all are.
+	p.upstreams[1].healthy = false
+	if got := p.pick().backend; got != "c" {
+		t.Errorf("least-conn picked %q; want c", got)
+	}
+	for _, u := range p.upstreams {
+		u.healthy = false
+	}
+	if got := p.pick().backend; got != "b" {
+		t.Errorf("least-conn with no healthy backends picked %q; want b", got)
+	}
+
+	p.leastConn = false
+	p.upstreams[0].healthy = true
+	p.upstreams[2].healthy = true
+	var got []string
+	for range 4 {
+		got = append(got, p.pick().backend)
+	}
+	if want := []string{"a", "c", "a", "c"}; !slices.Equal(got, want) && !slices.Equal(got, []string{"c", "a", "c", "a"}) {
+		t.Errorf("round-robin picked %q; want alternating a and c", got)
+	}
+
+	// Weighted backends get their share of requests, and those with
+	// weight 0 get none.
+	p = &upstreamPool{weighted: true}
+	for backend, weight := range map[string]int{"a": 3, "b": 1, "c": 0} {
+		p.upstreams = append(p.upstreams, &upstream{backend: backend, weight: weight, healthy: true})
+	}
+	counts := map[string]int{}
+	for range 400 {
+		counts[p.pick().backend]++
+	}
+	if want := map[string]int{"a": 300, "b": 100}; !maps.Equal(counts, want) {
+		t.Errorf("weighted round-robin picked %v; want %v", counts, want)
+	}
+}
+
+func TestServeHTTPRoutes(t *testing.T) {
+	b := newTestBackend(t)
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/": {
+					Routes: []*ipn.HTTPRoute{
+						{Path: "/exact", Handler: &ipn.HTTPHandler{Text: "exact"}},
+						{Headers: map[string]string{"X-Canary": "1"}, Handler: &ipn.HTTPHandler{Text: "canary"}},
+						{Headers: map[string]string{"X-Canary": "1", "X-Other": "1"}, Handler: &ipn.HTTPHandler{Text: "unreachable"}},
+					},
+				},
+				"/api": {
+					Text: "api",
+					Routes: []*ipn.HTTPRoute{
+						{Headers: map[string]string{"X-Version": "2"}, Handler: &ipn.HTTPHandler{Text: "api-v2"}},
+					},
+				},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		header   http.Header
+		wantCode int
+		wantBody string
+	}{
+		{path: "/exact", wantCode: http.StatusOK, wantBody: "exact"},
+		{path: "/exact/sub", wantCode: http.StatusNotFound},
+		{path: "/x", header: http.Header{"X-Canary": {"1"}, "X-Other": {"1"}}, wantCode: http.StatusOK, wantBody: "canary"},
+		{path: "/x", header: http.Header{"X-Canary": {"2"}}, wantCode: http.StatusNotFound},
+		{path: "/api/x", wantCode: http.StatusOK, wantBody: "api"},
+		{path: "/api/x", header: http.Header{"X-Version": {"2"}}, wantCode: http.StatusOK, wantBody: "api-v2"},
+	}
+	for _, tt := range tests {
+		req := &http.Request{
+			URL:    &url.URL{Path: tt.path},
+			Header: tt.header,
+			Host:   "example.ts.net",
+			TLS:    &tls.ConnectionState{ServerName: "example.ts.net"},
+		}
+		req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+			DestPort: 443,
+			SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+		}))
+		w := httptest.NewRecorder()
+		b.serveWebHandler(w, req)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s %v: status = %d; want %d", tt.path, tt.header, w.Code, tt.wantCode)
+		}
+		if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+			t.Errorf("%s %v: body = %q; want %q", tt.path, tt.header, w.Body.String(), tt.wantBody)
+		}
+	}
+}
+
 func TestServeHTTPProxyGrantHeader(t *testing.T) {
 	b := newTestBackend(t)
 
@@ -1747,6 +2150,71 @@ func TestValidateServeConfigUpdate(t *testing.T) {
 			},
 			wantError: true,
 		},
+		{
+			name:        "negative health check interval",
+			description: "health check intervals must not be negative",
+			existing:    nil,
+			incoming: &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"example.ts.net:443": {
+						Handlers: map[string]*ipn.HTTPHandler{
+							"/": {
+								Proxy:               "http://127.0.0.1:3000",
+								Upstreams:           []string{"http://127.0.0.1:3001"},
+								HealthCheckPath:     "/healthz",
+								HealthCheckInterval: tstime.GoDuration{Duration: -time.Second},
+							},
+						},
+					},
+				},
+			},
+			wantError: true,
+		},
+		{
+			name:        "negative health check interval in route",
+			description: "health check intervals of route handlers must not be negative",
+			existing:    nil,
+			incoming: &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"example.ts.net:443": {
+						Handlers: map[string]*ipn.HTTPHandler{
+							"/": {
+								Text: "hi",
+								Routes: []*ipn.HTTPRoute{{
+									Path: "/api",
+									Handler: &ipn.HTTPHandler{
+										Proxy:               "http://127.0.0.1:3000",
+										Upstreams:           []string{"http://127.0.0.1:3001"},
+										HealthCheckPath:     "/healthz",
+										HealthCheckInterval: tstime.GoDuration{Duration: -time.Second},
+									},
+								}},
+							},
+						},
+					},
+				},
+			},
+			wantError: true,
+		},
+		{
+			name:        "default health check interval",
+			description: "a zero health check interval means the default",
+			existing:    nil,
+			incoming: &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"example.ts.net:443": {
+						Handlers: map[string]*ipn.HTTPHandler{
+							"/": {
+								Proxy:           "http://127.0.0.1:3000",
+								Upstreams:       []string{"http://127.0.0.1:3001"},
+								HealthCheckPath: "/healthz",
+							},
+						},
+					},
+				},
+			},
+			wantError: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -17,6 +17,7 @@
 	"strconv"
 	"time"
 
+	"tailscale.com/derp"
 	"tailscale.com/derp/derphttp"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/netaddr"
@@ -267,6 +268,7 @@ func() {
 							Nodes:      []*tailcfg.DERPNode{derpNode},
 						}
 					})
+					defer rc.Close()
 					if err := rc.Connect(ctx); err != nil {
 						st.Errors = append(st.Errors, fmt.Sprintf("Error connecting to node %q @ try %d: %v", derpNode.HostName, i, err))
 						return
@@ -274,6 +276,11 @@ func() {
 
 					if len(serverPubKeys) == 0 {
 						st.Info = append(st.Info, fmt.Sprintf("Successfully established a DERP connection with node %q", derpNode.HostName))
+						if si, err := recvDERPServerInfo(ctx, rc); err != nil {
+							st.Warnings = append(st.Warnings, fmt.Sprintf("Error receiving server info from node %q: %v", derpNode.HostName, err))
+						} else {
+							st.Info = append(st.Info, derpLimitsInfo(derpNode.HostName, si)...)
+						}
 					}
 					serverPubKeys[rc.ServerPublicKey()] = true
 				}()
@@ -305,3 +312,53 @@ func() {
 	//   issued in the first place, tell them specifically that the
 	// 	 cert is bad not just that the connection failed.
 }
+
+// recvDERPServerInfo returns the ServerInfoMessage that a DERP server sends
+// first on a new connection.
+func recvDERPServerInfo(ctx context.Context, rc *derphttp.Client) (derp.ServerInfoMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	type result struct {
+		m   derp.ReceivedMessage
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		m, err := rc.Recv()
+		ch <- result{m, err}
+	}()
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return derp.ServerInfoMessage{}, r.err
+		}
+		si, ok := r.m.(derp.ServerInfoMessage)
+		if !ok {
+			return derp.ServerInfoMessage{}, fmt.Errorf("unexpected first message type %T", r.m)
+		}
+		return si, nil
+	case <-ctx.Done():
+		rc.Close()
+		return derp.ServerInfoMessage{}, ctx.Err()
+	}
+}
+
+// derpLimitsInfo describes the rate limits and quota that the DERP node
+// named host advertised in si.
+func derpLimitsInfo(host string, si derp.ServerInfoMessage) []string {
+	if si == (derp.ServerInfoMessage{}) {
+		return []string{fmt.Sprintf("Node %q doesn't advertise any rate limits", host)}
+	}
+	var ret []string
+	if si.TokenBucketBytesPerSecond > 0 {
+		ret = append(ret, fmt.Sprintf("Node %q limits clients to %d bytes/s (burst %d bytes)", host, si.TokenBucketBytesPerSecond, si.TokenBucketBytesBurst))
+	}
+	if si.TokenBucketPacketsPerSecond > 0 {
+		ret = append(ret, fmt.Sprintf("Node %q limits clients to %d packets/s (burst %d packets)", host, si.TokenBucketPacketsPerSecond, si.TokenBucketPacketsBurst))
+	}
+	if si.DailyQuotaBytes > 0 {
+		ret = append(ret, fmt.Sprintf("Node %q limits each node to %d bytes per UTC day", host, si.DailyQuotaBytes))
+	}
+	return ret
+}
@@ -121,6 +121,7 @@ func init() {
 	if buildfeatures.HasDNS {
 		Register("dns-osconfig", (*Handler).serveDNSOSConfig)
 		Register("dns-query", (*Handler).serveDNSQuery)
+		Register("debug-dns-cache", (*Handler).serveDebugDNSCache)
 	}
 	if buildfeatures.HasUserMetrics {
 		Register("usermetrics", (*Handler).serveUserMetrics)
@@ -1616,6 +1617,41 @@ func (h *Handler) serveDNSQuery(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// serveDebugDNSCache serves the upstream DNS responses cached by the
+// internal DNS resolver as a JSON array of DNSCacheEntry objects on GET, and
+// flushes the cache on POST.
+func (h *Handler) serveDebugDNSCache(w http.ResponseWriter, r *http.Request) {
+	if !buildfeatures.HasDNS {
+		http.Error(w, feature.ErrUnavailable.Error(), http.StatusNotImplemented)
+		return
+	}
+	// Require write access for privacy reasons.
+	if !h.PermitWrite {
+		http.Error(w, "debug-dns-cache access denied", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case httpm.GET:
+		ents, err := h.b.DNSCacheEntries()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(w)
+		e.SetIndent("", "\t")
+		e.Encode(ents)
+	case httpm.POST:
+		if err := h.b.FlushDNSCache(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "only GET or POST allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // dnsMessageTypeForString returns the dnsmessage.Type for the given string.
 // For example, DNSMessageTypeForString("A") returns dnsmessage.TypeA.
 func dnsMessageTypeForString(s string) (t dnsmessage.Type, err error) {
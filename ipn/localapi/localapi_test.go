@@ -251,6 +251,60 @@ func TestShouldDenyServeConfigForGOOSAndUserContext(t *testing.T) {
 			h:       newHandler(false),
 			wantErr: true,
 		},
+		{
+			name: "route-path-handler-not-admin",
+			configIn: &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+						"/": {
+							Proxy: "http://127.0.0.1:3000",
+							Routes: []*ipn.HTTPRoute{{
+								Path:    "/secrets",
+								Handler: &ipn.HTTPHandler{Path: "/etc"},
+							}},
+						},
+					}},
+				},
+			},
+			h:       newHandler(false),
+			wantErr: true,
+		},
+		{
+			name: "route-error-page-not-admin",
+			configIn: &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+						"/": {
+							Proxy: "http://127.0.0.1:3000",
+							Routes: []*ipn.HTTPRoute{{
+								Headers: map[string]string{"X-Canary": "1"},
+								Handler: &ipn.HTTPHandler{Proxy: "http://127.0.0.1:3001", ErrorPage: "/etc/shadow"},
+							}},
+						},
+					}},
+				},
+			},
+			h:       newHandler(false),
+			wantErr: true,
+		},
+		{
+			name: "route-proxy-handler-not-admin",
+			configIn: &ipn.ServeConfig{
+				Web: map[ipn.HostPort]*ipn.WebServerConfig{
+					"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+						"/": {
+							Proxy: "http://127.0.0.1:3000",
+							Routes: []*ipn.HTTPRoute{{
+								Headers: map[string]string{"X-Canary": "1"},
+								Handler: &ipn.HTTPHandler{Proxy: "http://127.0.0.1:3001"},
+							}},
+						},
+					}},
+				},
+			},
+			h:       newHandler(false),
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -20,6 +20,7 @@
 
 func init() {
 	Register("serve-config", (*Handler).serveServeConfig)
+	Register("serve-upstreams", (*Handler).serveServeUpstreams)
 }
 
 func (h *Handler) serveServeConfig(w http.ResponseWriter, r *http.Request) {
@@ -76,6 +77,19 @@ func (h *Handler) serveServeConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *Handler) serveServeUpstreams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != httpm.GET {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.PermitRead {
+		http.Error(w, "serve upstreams access denied", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.ServeUpstreams())
+}
+
 func authorizeServeConfigForGOOSAndUserContext(goos string, configIn *ipn.ServeConfig, h *Handler) error {
 	switch goos {
 	case "windows", "linux", "darwin", "illumos", "solaris":
@@ -25,6 +25,7 @@
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
@@ -40,6 +41,9 @@
 // The default control plane is the hosted version run by Tailscale.com.
 const DefaultControlURL = "https://controlplane.tailscale.com"
 
+// DefaultSSHRecordingMaxSizeMB is the default Prefs.SSHRecordingMaxSizeMB.
+const DefaultSSHRecordingMaxSizeMB = 1024
+
 var (
 	// ErrExitNodeIDAlreadySet is returned from (*Prefs).SetExitNodeIP when the
 	// Prefs.ExitNodeID field is already set.
@@ -291,6 +295,43 @@ type Prefs struct {
 	// non-nil.
 	RelayServerStaticEndpoints []netip.AddrPort `json:",omitempty"`
 
+	// RelayServerMaxSessions is the maximum number of sessions the relay
+	// server relays at once. If zero, there's no limit.
+	RelayServerMaxSessions int `json:",omitempty"`
+
+	// RelayServerMaxSessionsPerNode is the maximum number of sessions the
+	// relay server relays at once that any one node is part of. If zero,
+	// there's no limit.
+	RelayServerMaxSessionsPerNode int `json:",omitempty"`
+
+	// RelayServerSessionBytesPerSec is the maximum rate in bytes per second
+	// at which the relay server forwards data for each session, summed
+	// across both directions. If zero, there's no limit.
+	RelayServerSessionBytesPerSec int64 `json:",omitempty"`
+
+	// RelayServerSessionIdleTimeout is how long a node can go without
+	// sending anything in a relayed session before the relay server tears
+	// it down. If zero, the default of 5 minutes is used.
+	RelayServerSessionIdleTimeout tstime.GoDuration `json:",omitzero"`
+
+	// SSHRecordLocally specifies whether Tailscale SSH sessions to this node
+	// are recorded to the ssh-sessions directory of tailscaled's state
+	// directory when the tailnet's SSH policy doesn't send recordings to
+	// recorder nodes. Local recordings are kept according to
+	// SSHRecordingMaxSizeMB and SSHRecordingMaxAge.
+	SSHRecordLocally bool `json:",omitempty"`
+
+	// SSHRecordingMaxSizeMB is the maximum total size, in megabytes, of
+	// local SSH session recordings, after which the oldest are deleted. If
+	// zero, DefaultSSHRecordingMaxSizeMB is used. If negative, there's no
+	// limit.
+	SSHRecordingMaxSizeMB int `json:",omitempty"`
+
+	// SSHRecordingMaxAge is how long local SSH session recordings are
+	// kept, after which they're deleted. If zero, recordings are only
+	// deleted for size.
+	SSHRecordingMaxAge tstime.GoDuration `json:",omitzero"`
+
 	// AllowSingleHosts was a legacy field that was always true
 	// for the past 4.5 years. It controlled whether Tailscale
 	// peers got /32 or /128 routes for each other.
@@ -353,39 +394,46 @@ type AppConnectorPrefs struct {
 type MaskedPrefs struct {
 	Prefs
 
-	ControlURLSet                 bool                `json:",omitempty"`
-	RouteAllSet                   bool                `json:",omitempty"`
-	ExitNodeIDSet                 bool                `json:",omitempty"`
-	ExitNodeIPSet                 bool                `json:",omitempty"`
-	AutoExitNodeSet               bool                `json:",omitempty"`
-	InternalExitNodePriorSet      bool                `json:",omitempty"` // Internal; can't be set by LocalAPI clients
-	ExitNodeAllowLANAccessSet     bool                `json:",omitempty"`
-	CorpDNSSet                    bool                `json:",omitempty"`
-	RunSSHSet                     bool                `json:",omitempty"`
-	RunWebClientSet               bool                `json:",omitempty"`
-	WantRunningSet                bool                `json:",omitempty"`
-	LoggedOutSet                  bool                `json:",omitempty"`
-	ShieldsUpSet                  bool                `json:",omitempty"`
-	AdvertiseTagsSet              bool                `json:",omitempty"`
-	HostnameSet                   bool                `json:",omitempty"`
-	NotepadURLsSet                bool                `json:",omitempty"`
-	ForceDaemonSet                bool                `json:",omitempty"`
-	EggSet                        bool                `json:",omitempty"`
-	AdvertiseRoutesSet            bool                `json:",omitempty"`
-	AdvertiseServicesSet          bool                `json:",omitempty"`
-	SyncSet                       bool                `json:",omitzero"`
-	NoSNATSet                     bool                `json:",omitempty"`
-	NoStatefulFilteringSet        bool                `json:",omitempty"`
-	NetfilterModeSet              bool                `json:",omitempty"`
-	OperatorUserSet               bool                `json:",omitempty"`
-	ProfileNameSet                bool                `json:",omitempty"`
-	AutoUpdateSet                 AutoUpdatePrefsMask `json:",omitzero"`
-	AppConnectorSet               bool                `json:",omitempty"`
-	PostureCheckingSet            bool                `json:",omitempty"`
-	NetfilterKindSet              bool                `json:",omitempty"`
-	DriveSharesSet                bool                `json:",omitempty"`
-	RelayServerPortSet            bool                `json:",omitempty"`
-	RelayServerStaticEndpointsSet bool                `json:",omitzero"`
+	ControlURLSet                    bool                `json:",omitempty"`
+	RouteAllSet                      bool                `json:",omitempty"`
+	ExitNodeIDSet                    bool                `json:",omitempty"`
+	ExitNodeIPSet                    bool                `json:",omitempty"`
+	AutoExitNodeSet                  bool                `json:",omitempty"`
+	InternalExitNodePriorSet         bool                `json:",omitempty"` // Internal; can't be set by LocalAPI clients
+	ExitNodeAllowLANAccessSet        bool                `json:",omitempty"`
+	CorpDNSSet                       bool                `json:",omitempty"`
+	RunSSHSet                        bool                `json:",omitempty"`
+	RunWebClientSet                  bool                `json:",omitempty"`
+	WantRunningSet                   bool                `json:",omitempty"`
+	LoggedOutSet                     bool                `json:",omitempty"`
+	ShieldsUpSet                     bool                `json:",omitempty"`
+	AdvertiseTagsSet                 bool                `json:",omitempty"`
+	HostnameSet                      bool                `json:",omitempty"`
+	NotepadURLsSet                   bool                `json:",omitempty"`
+	ForceDaemonSet                   bool                `json:",omitempty"`
+	EggSet                           bool                `json:",omitempty"`
+	AdvertiseRoutesSet               bool                `json:",omitempty"`
+	AdvertiseServicesSet             bool                `json:",omitempty"`
+	SyncSet                          bool                `json:",omitzero"`
+	NoSNATSet                        bool                `json:",omitempty"`
+	NoStatefulFilteringSet           bool                `json:",omitempty"`
+	NetfilterModeSet                 bool                `json:",omitempty"`
+	OperatorUserSet                  bool                `json:",omitempty"`
+	ProfileNameSet                   bool                `json:",omitempty"`
+	AutoUpdateSet                    AutoUpdatePrefsMask `json:",omitzero"`
+	AppConnectorSet                  bool                `json:",omitempty"`
+	PostureCheckingSet               bool                `json:",omitempty"`
+	NetfilterKindSet                 bool                `json:",omitempty"`
+	DriveSharesSet                   bool                `json:",omitempty"`
+	RelayServerPortSet               bool                `json:",omitempty"`
+	RelayServerStaticEndpointsSet    bool                `json:",omitzero"`
+	RelayServerMaxSessionsSet        bool                `json:",omitempty"`
+	RelayServerMaxSessionsPerNodeSet bool                `json:",omitempty"`
+	RelayServerSessionBytesPerSecSet bool                `json:",omitempty"`
+	RelayServerSessionIdleTimeoutSet bool                `json:",omitempty"`
+	SSHRecordLocallySet              bool                `json:",omitempty"`
+	SSHRecordingMaxSizeMBSet         bool                `json:",omitempty"`
+	SSHRecordingMaxAgeSet            bool                `json:",omitempty"`
 }
 
 // SetsInternal reports whether mp has any of the Internal*Set field bools set
@@ -551,6 +599,9 @@ func (p *Prefs) pretty(goos string) string {
 	if buildfeatures.HasSSH && p.RunSSH {
 		sb.WriteString("ssh=true ")
 	}
+	if buildfeatures.HasSSH && p.SSHRecordLocally {
+		sb.WriteString("sshRecordLocally=true ")
+	}
 	if buildfeatures.HasWebClient && p.RunWebClient {
 		sb.WriteString("webclient=true ")
 	}
@@ -692,7 +743,14 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		slices.EqualFunc(p.DriveShares, p2.DriveShares, drive.SharesEqual) &&
 		p.NetfilterKind == p2.NetfilterKind &&
 		compareUint16Ptrs(p.RelayServerPort, p2.RelayServerPort) &&
-		slices.Equal(p.RelayServerStaticEndpoints, p2.RelayServerStaticEndpoints)
+		slices.Equal(p.RelayServerStaticEndpoints, p2.RelayServerStaticEndpoints) &&
+		p.RelayServerMaxSessions == p2.RelayServerMaxSessions &&
+		p.RelayServerMaxSessionsPerNode == p2.RelayServerMaxSessionsPerNode &&
+		p.RelayServerSessionBytesPerSec == p2.RelayServerSessionBytesPerSec &&
+		p.RelayServerSessionIdleTimeout == p2.RelayServerSessionIdleTimeout &&
+		p.SSHRecordLocally == p2.SSHRecordLocally &&
+		p.SSHRecordingMaxSizeMB == p2.SSHRecordingMaxSizeMB &&
+		p.SSHRecordingMaxAge == p2.SSHRecordingMaxAge
 }
 
 func (au AutoUpdatePrefs) Pretty() string {
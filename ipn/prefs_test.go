@@ -19,6 +19,7 @@
 	"tailscale.com/net/netaddr"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
+	"tailscale.com/tstime"
 	"tailscale.com/types/key"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/persist"
@@ -70,6 +71,13 @@ func TestPrefsEqual(t *testing.T) {
 		"DriveShares",
 		"RelayServerPort",
 		"RelayServerStaticEndpoints",
+		"RelayServerMaxSessions",
+		"RelayServerMaxSessionsPerNode",
+		"RelayServerSessionBytesPerSec",
+		"RelayServerSessionIdleTimeout",
+		"SSHRecordLocally",
+		"SSHRecordingMaxSizeMB",
+		"SSHRecordingMaxAge",
 		"AllowSingleHosts",
 		"Persist",
 	}
@@ -390,6 +398,46 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{RelayServerStaticEndpoints: aps("[2001:db8::1]:40000", "192.0.2.1:40000")},
 			false,
 		},
+		{
+			&Prefs{RelayServerMaxSessions: 10},
+			&Prefs{RelayServerMaxSessions: 10},
+			true,
+		},
+		{
+			&Prefs{RelayServerMaxSessionsPerNode: 2},
+			&Prefs{RelayServerMaxSessionsPerNode: 3},
+			false,
+		},
+		{
+			&Prefs{RelayServerSessionBytesPerSec: 1 << 20},
+			&Prefs{},
+			false,
+		},
+		{
+			&Prefs{RelayServerSessionIdleTimeout: tstime.GoDuration{Duration: time.Minute}},
+			&Prefs{RelayServerSessionIdleTimeout: tstime.GoDuration{Duration: 2 * time.Minute}},
+			false,
+		},
+		{
+			&Prefs{SSHRecordLocally: true},
+			&Prefs{SSHRecordLocally: false},
+			false,
+		},
+		{
+			&Prefs{SSHRecordingMaxSizeMB: 100},
+			&Prefs{SSHRecordingMaxSizeMB: -1},
+			false,
+		},
+		{
+			&Prefs{SSHRecordingMaxAge: tstime.GoDuration{Duration: time.Hour}},
+			&Prefs{SSHRecordingMaxAge: tstime.GoDuration{Duration: time.Hour}},
+			true,
+		},
+		{
+			&Prefs{SSHRecordingMaxAge: tstime.GoDuration{Duration: time.Hour}},
+			&Prefs{},
+			false,
+		},
 	}
 	for i, tt := range tests {
 		got := tt.a.Equals(tt.b)
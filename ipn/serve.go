@@ -14,9 +14,11 @@
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
 	"tailscale.com/types/ipproto"
 	"tailscale.com/util/dnsname"
 	"tailscale.com/util/mak"
@@ -179,10 +181,113 @@ type HTTPHandler struct {
 	//   - ${REQUEST_URI}: replaced with the request's full URI (path and query string)
 	Redirect string `json:",omitempty"`
 
+	// The following fields only apply to Proxy handlers.
+
+	// SetRequestHeaders are headers to set on requests to the backend,
+	// replacing any values sent by the client. A "Host" entry sets the Host
+	// header. Tailscale-* headers can't be set.
+	SetRequestHeaders map[string]string `json:",omitempty"`
+
+	// RemoveRequestHeaders are headers to remove from requests to the
+	// backend. Tailscale-* headers can't be removed.
+	RemoveRequestHeaders []string `json:",omitempty"`
+
+	// SetResponseHeaders are headers to set on responses from the backend,
+	// replacing any values it sent.
+	SetResponseHeaders map[string]string `json:",omitempty"`
+
+	// RemoveResponseHeaders are headers to remove from responses from the
+	// backend.
+	RemoveResponseHeaders []string `json:",omitempty"`
+
+	// StripPrefix, if not empty, is the prefix to strip from request paths
+	// before proxying them, instead of the mount point. It's only stripped
+	// from paths that have it. If StripPrefix is "/", paths are proxied
+	// unmodified.
+	StripPrefix string `json:",omitempty"`
+
+	// ConnectTimeout, if non-zero, is how long to wait for a connection to
+	// the backend for each request.
+	ConnectTimeout tstime.GoDuration `json:",omitzero"`
+
+	// ResponseTimeout, if non-zero, is how long to wait for the backend's
+	// response headers after sending it the request.
+	ResponseTimeout tstime.GoDuration `json:",omitzero"`
+
+	// ErrorPage, if not empty, is the absolute path of a file to serve
+	// when the backend can't be reached or times out, instead of a plain
+	// "502 Bad Gateway" or "504 Gateway Timeout" error. If ErrorPage
+	// starts with '<httpcode>:', then we use that status instead. Like
+	// Path, it can only be set by local admins.
+	ErrorPage string `json:",omitempty"`
+
+	// Upstreams, if not empty, are additional backends for a Proxy
+	// handler, in the same format as Proxy. Requests are spread over Proxy
+	// and Upstreams according to LoadBalancing.
+	Upstreams []string `json:",omitempty"`
+
+	// LoadBalancing is how requests are spread over the backends of a
+	// handler with Upstreams: LoadBalancingRoundRobin (the default) or
+	// LoadBalancingLeastConn.
+	LoadBalancing string `json:",omitempty"`
+
+	// HealthCheckPath, if not empty, is the absolute path on which the
+	// backends of a handler with Upstreams are sent GET requests to check
+	// their health. Backends that repeatedly fail to respond with a 2xx or
+	// 3xx status get no requests until they pass again.
+	HealthCheckPath string `json:",omitempty"`
+
+	// HealthCheckInterval is how often backends are health checked, if
+	// HealthCheckPath is set. If zero, DefaultHealthCheckInterval is used.
+	// It must not be negative. Each check times out after the interval or
+	// 5 seconds, whichever is shorter.
+	HealthCheckInterval tstime.GoDuration `json:",omitzero"`
+
+	// Weights, if not empty, are the relative shares of requests that Proxy
+	// and each of Upstreams get, in that order, with round-robin load
+	// balancing. Backends without a weight have weight 1, and backends with
+	// weight 0 get no requests.
+	Weights []int `json:",omitempty"`
+
+	// The following field applies to all handlers.
+
+	// Routes, if not empty, are routes for requests that match conditions
+	// beyond the handler's mount point. They're tried in order, and the
+	// first one that matches serves the request. Requests that match none
+	// are served by this handler, or get a 404 if it has nothing to serve.
+	Routes []*HTTPRoute `json:",omitempty"`
+
 	// TODO(bradfitz): bool to not enumerate directories? TTL on mapping for
-	// temporary ones? Error codes?
+	// temporary ones?
+}
+
+// HTTPRoute is a route of an HTTPHandler, for requests that match
+// conditions beyond the handler's mount point.
+type HTTPRoute struct {
+	// Path, if not empty, is the path that requests must have exactly.
+	// Otherwise, any path under the mount point matches.
+	Path string `json:",omitempty"`
+
+	// Headers are the headers that requests must have, mapped to the value
+	// each must have.
+	Headers map[string]string `json:",omitempty"`
+
+	// Handler serves the requests that match. Its own Routes are ignored.
+	Handler *HTTPHandler `json:",omitempty"`
 }
 
+// Values of HTTPHandler.LoadBalancing.
+const (
+	// LoadBalancingRoundRobin sends requests to each backend in turn.
+	LoadBalancingRoundRobin = "round-robin"
+	// LoadBalancingLeastConn sends requests to the backend with the fewest
+	// requests in flight.
+	LoadBalancingLeastConn = "least-conn"
+)
+
+// DefaultHealthCheckInterval is the default HTTPHandler.HealthCheckInterval.
+const DefaultHealthCheckInterval = 10 * time.Second
+
 // WebHandlerExists reports whether if the ServeConfig Web handler exists for
 // the given host:port and mount point.
 func (sc *ServeConfig) WebHandlerExists(svcName tailcfg.ServiceName, hp HostPort, mount string) bool {
@@ -227,12 +332,13 @@ func (sc *ServeConfig) GetTCPPortHandler(port uint16, svcName tailcfg.ServiceNam
 }
 
 // HasPathHandler reports whether if ServeConfig has at least
-// one path handler, including foreground configs.
+// one handler that serves local files, including foreground configs.
+// See HTTPHandler.servesLocalFiles.
 func (sc *ServeConfig) HasPathHandler() bool {
 	if sc.Web != nil {
 		for _, webServerConfig := range sc.Web {
 			for _, httpHandler := range webServerConfig.Handlers {
-				if httpHandler.Path != "" {
+				if httpHandler.servesLocalFiles() {
 					return true
 				}
 			}
@@ -244,7 +350,7 @@ func (sc *ServeConfig) HasPathHandler() bool {
 			if serviceConfig.Web != nil {
 				for _, webServerConfig := range serviceConfig.Web {
 					for _, httpHandler := range webServerConfig.Handlers {
-						if httpHandler.Path != "" {
+						if httpHandler.servesLocalFiles() {
 							return true
 						}
 					}
@@ -264,6 +370,26 @@ func (sc *ServeConfig) HasPathHandler() bool {
 	return false
 }
 
+// servesLocalFiles reports whether h, or the handler of any of its
+// Routes, reads files from the local filesystem to serve them, as a Path
+// handler or through its ErrorPage. Such handlers can only be configured
+// by local admins, since tailscaled reads the files with its own
+// privileges.
+func (h *HTTPHandler) servesLocalFiles() bool {
+	if h == nil {
+		return false
+	}
+	if h.Path != "" || h.ErrorPage != "" {
+		return true
+	}
+	for _, rt := range h.Routes {
+		if rt != nil && rt.Handler.servesLocalFiles() {
+			return true
+		}
+	}
+	return false
+}
+
 // IsTCPForwardingAny reports whether ServeConfig is currently forwarding in
 // TCPForward mode on any port. This is exclusive of Web/HTTPS serving.
 func (sc *ServeConfig) IsTCPForwardingAny() bool {
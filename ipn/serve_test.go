@@ -132,6 +132,36 @@ func TestHasPathHandler(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "with-error-page",
+			cfg: ServeConfig{
+				TCP: map[uint16]*TCPPortHandler{443: {HTTPS: true}},
+				Web: map[HostPort]*WebServerConfig{
+					"foo.test.ts.net:443": {Handlers: map[string]*HTTPHandler{
+						"/": {Proxy: "http://127.0.0.1:3000", ErrorPage: "/etc/shadow"},
+					}},
+				},
+			},
+			want: true,
+		},
+		{
+			name: "with-route-path-handler",
+			cfg: ServeConfig{
+				TCP: map[uint16]*TCPPortHandler{443: {HTTPS: true}},
+				Web: map[HostPort]*WebServerConfig{
+					"foo.test.ts.net:443": {Handlers: map[string]*HTTPHandler{
+						"/": {
+							Proxy: "http://127.0.0.1:3000",
+							Routes: []*HTTPRoute{{
+								Headers: map[string]string{"X-Canary": "1"},
+								Handler: &HTTPHandler{Path: "/etc"},
+							}},
+						},
+					}},
+				},
+			},
+			want: true,
+		},
 		{
 			name: "with-service-proxy-handler",
 			cfg: ServeConfig{
@@ -0,0 +1,355 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package store
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"tailscale.com/ipn"
+	"tailscale.com/types/logger"
+)
+
+// EncryptedPrefix is the path prefix used for an encrypted StateStore that
+// wraps another StateStore. See NewEncryptedStore.
+const EncryptedPrefix = "encfile:"
+
+func init() {
+	Register(EncryptedPrefix, NewEncryptedStore)
+}
+
+// Sources of the keys of an EncryptedStore. Exactly one source of the
+// current key must be set, and at most one source of the old key.
+const (
+	// encKeyEnv is the environment variable holding the key itself.
+	encKeyEnv    = "TS_STATE_ENCRYPTION_KEY"
+	encOldKeyEnv = "TS_STATE_ENCRYPTION_OLD_KEY"
+
+	// encKeyFileEnv is the environment variable holding the path of a file
+	// holding the key.
+	encKeyFileEnv    = "TS_STATE_ENCRYPTION_KEY_FILE"
+	encOldKeyFileEnv = "TS_STATE_ENCRYPTION_OLD_KEY_FILE"
+
+	// encKeyCredential is the name of the systemd credential holding the
+	// key. See https://systemd.io/CREDENTIALS/.
+	encKeyCredential    = "tailscaled-state-key"
+	encOldKeyCredential = "tailscaled-state-old-key"
+)
+
+// encMagic is the prefix of values encrypted by an EncryptedStore. It's
+// followed by the ID of the key they're encrypted with, the nonce, and the
+// ciphertext.
+const encMagic = "tsenc1:"
+
+// encKeyIDLen is the length of the IDs that identify the key a value was
+// encrypted with.
+const encKeyIDLen = 8
+
+// EncryptedStore is a StateStore that encrypts the values it stores in
+// another StateStore with XChaCha20-Poly1305, using a 32-byte key. Each
+// value is bound to its StateKey, so values can't be swapped.
+type EncryptedStore struct {
+	ipn.EncryptedStateStore
+
+	logf  logger.Logf
+	inner ipn.StateStore
+	key   encKey
+	old   *encKey // or nil
+}
+
+// encKey is a key of an EncryptedStore.
+type encKey struct {
+	id   [encKeyIDLen]byte
+	aead cipher.AEAD
+}
+
+func newEncKey(key []byte) (encKey, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return encKey{}, err
+	}
+	sum := sha256.Sum256(append([]byte("tailscale state encryption key id\x00"), key...))
+	return encKey{id: [encKeyIDLen]byte(sum[:]), aead: aead}, nil
+}
+
+// NewEncryptedStore returns an EncryptedStore for path, which is of the form
+// "encfile:<inner>", where inner is the path of the StateStore to wrap, as
+// passed to New.
+//
+// The key is read from exactly one of:
+//
+//   - the TS_STATE_ENCRYPTION_KEY environment variable, holding the key
+//   - the TS_STATE_ENCRYPTION_KEY_FILE environment variable, holding the
+//     path of a file holding the key
+//   - the "tailscaled-state-key" systemd credential
+//
+// Keys are 32 bytes, either raw (in files only) or encoded in hex or base64.
+//
+// Existing plaintext values in the inner store are encrypted when the store
+// is opened. To rotate keys, set the new key as above, and the old one in
+// TS_STATE_ENCRYPTION_OLD_KEY, TS_STATE_ENCRYPTION_OLD_KEY_FILE or the
+// "tailscaled-state-old-key" systemd credential; values encrypted with the
+// old key are then encrypted with the new one when the store is opened.
+// Both migrations happen as values are read instead if the inner store
+// doesn't implement ExportableStore.
+func NewEncryptedStore(logf logger.Logf, path string) (ipn.StateStore, error) {
+	innerPath := strings.TrimPrefix(path, EncryptedPrefix)
+	if innerPath == "" {
+		return nil, errors.New("encfile: missing path of the store to wrap")
+	}
+	if strings.HasPrefix(innerPath, EncryptedPrefix) {
+		return nil, errors.New("encfile: stores can't be nested")
+	}
+	key, err := loadEncKey(encKeyEnv, encKeyFileEnv, encKeyCredential)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("encfile: no key; set %s, %s or the %q systemd credential", encKeyEnv, encKeyFileEnv, encKeyCredential)
+	}
+	oldKey, err := loadEncKey(encOldKeyEnv, encOldKeyFileEnv, encOldKeyCredential)
+	if err != nil {
+		return nil, err
+	}
+	inner, err := New(logf, innerPath)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptedStore(logf, inner, key, oldKey)
+}
+
+// loadEncKey loads a key from the environment variable keyEnv, the file
+// named by the environment variable fileEnv, or the systemd credential
+// credential. It returns nil if none of them are set.
+func loadEncKey(keyEnv, fileEnv, credential string) ([]byte, error) {
+	var sources []string
+	var key []byte
+	if v := os.Getenv(keyEnv); v != "" {
+		sources = append(sources, keyEnv)
+		k, err := parseEncKey(v, false)
+		if err != nil {
+			return nil, fmt.Errorf("encfile: %s: %w", keyEnv, err)
+		}
+		key = k
+	}
+	var files []string
+	if v := os.Getenv(fileEnv); v != "" {
+		sources = append(sources, fileEnv)
+		files = append(files, v)
+	}
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+		file := filepath.Join(dir, credential)
+		if _, err := os.Stat(file); err == nil {
+			sources = append(sources, fmt.Sprintf("the %q systemd credential", credential))
+			files = append(files, file)
+		}
+	}
+	if len(sources) > 1 {
+		return nil, fmt.Errorf("encfile: key set by more than one of %s", strings.Join(sources, ", "))
+	}
+	for _, file := range files {
+		bs, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("encfile: reading key: %w", err)
+		}
+		if key, err = parseEncKey(string(bs), true); err != nil {
+			return nil, fmt.Errorf("encfile: %s: %w", file, err)
+		}
+	}
+	return key, nil
+}
+
+// parseEncKey parses s as a 32-byte key, encoded in hex or base64, or raw if
+// allowRaw.
+func parseEncKey(s string, allowRaw bool) ([]byte, error) {
+	if allowRaw && len(s) == chacha20poly1305.KeySize {
+		return []byte(s), nil
+	}
+	s = strings.TrimSpace(s)
+	if k, err := hex.DecodeString(s); err == nil && len(k) == chacha20poly1305.KeySize {
+		return k, nil
+	}
+	if k, err := base64.StdEncoding.DecodeString(s); err == nil && len(k) == chacha20poly1305.KeySize {
+		return k, nil
+	}
+	return nil, errors.New("key must be 32 bytes, encoded in hex or base64")
+}
+
+// newEncryptedStore returns an EncryptedStore wrapping inner, that encrypts
+// values with key and can also decrypt values encrypted with oldKey, if
+// non-nil. If inner is an ExportableStore, its values are re-encrypted with
+// key as needed.
+func newEncryptedStore(logf logger.Logf, inner ipn.StateStore, key, oldKey []byte) (*EncryptedStore, error) {
+	s := &EncryptedStore{
+		logf:  logf,
+		inner: inner,
+	}
+	var err error
+	if s.key, err = newEncKey(key); err != nil {
+		return nil, err
+	}
+	if oldKey != nil {
+		old, err := newEncKey(oldKey)
+		if err != nil {
+			return nil, err
+		}
+		if old.id != s.key.id {
+			s.old = &old
+		}
+	}
+	if exp, ok := inner.(ExportableStore); ok {
+		if err := s.migrate(exp); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *EncryptedStore) String() string { return fmt.Sprintf("EncryptedStore(%v)", s.inner) }
+
+// migrate encrypts the plaintext values in inner, and the values encrypted
+// with the old key, with the current key.
+func (s *EncryptedStore) migrate(inner ExportableStore) error {
+	// Collect the values first, as WriteState isn't safe while iterating.
+	var stale []ipn.StateKey
+	for k, v := range inner.All() {
+		if s.needsMigration(v) {
+			stale = append(stale, k)
+		}
+	}
+	var plaintext, rotated int
+	for _, k := range stale {
+		v, err := inner.ReadState(k)
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(v, []byte(encMagic)) {
+			plaintext++
+		} else {
+			rotated++
+		}
+		pt, err := s.decrypt(k, v)
+		if err != nil {
+			return err
+		}
+		if err := inner.WriteState(k, s.encrypt(k, pt)); err != nil {
+			return err
+		}
+	}
+	if plaintext > 0 {
+		s.logf("encfile: encrypted %d plaintext values in %v", plaintext, s.inner)
+	}
+	if rotated > 0 {
+		s.logf("encfile: re-encrypted %d values in %v with the new key", rotated, s.inner)
+	}
+	return nil
+}
+
+// needsMigration reports whether the stored value v isn't encrypted with
+// the current key.
+func (s *EncryptedStore) needsMigration(v []byte) bool {
+	rest, ok := bytes.CutPrefix(v, []byte(encMagic))
+	return !ok || !bytes.HasPrefix(rest, s.key.id[:])
+}
+
+// encrypt returns the stored form of the value pt for k.
+func (s *EncryptedStore) encrypt(k ipn.StateKey, pt []byte) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	rand.Read(nonce)
+	out := make([]byte, 0, len(encMagic)+encKeyIDLen+len(nonce)+len(pt)+chacha20poly1305.Overhead)
+	out = append(out, encMagic...)
+	out = append(out, s.key.id[:]...)
+	out = append(out, nonce...)
+	return s.key.aead.Seal(out, nonce, pt, []byte(k))
+}
+
+// decrypt returns the value for k from its stored form v. Values that
+// aren't encrypted are returned as is.
+func (s *EncryptedStore) decrypt(k ipn.StateKey, v []byte) ([]byte, error) {
+	rest, ok := bytes.CutPrefix(v, []byte(encMagic))
+	if !ok {
+		return v, nil
+	}
+	if len(rest) < encKeyIDLen+chacha20poly1305.NonceSizeX {
+		return nil, fmt.Errorf("encfile: value of %q is truncated", k)
+	}
+	id, nonce, ct := rest[:encKeyIDLen], rest[encKeyIDLen:][:chacha20poly1305.NonceSizeX], rest[encKeyIDLen+chacha20poly1305.NonceSizeX:]
+	key := &s.key
+	if !bytes.Equal(id, key.id[:]) {
+		if s.old == nil || !bytes.Equal(id, s.old.id[:]) {
+			return nil, fmt.Errorf("encfile: value of %q is encrypted with an unknown key", k)
+		}
+		key = s.old
+	}
+	pt, err := key.aead.Open(nil, nonce, ct, []byte(k))
+	if err != nil {
+		return nil, fmt.Errorf("encfile: decrypting value of %q: %w", k, err)
+	}
+	return pt, nil
+}
+
+// ReadState implements the StateStore interface.
+func (s *EncryptedStore) ReadState(k ipn.StateKey) ([]byte, error) {
+	v, err := s.inner.ReadState(k)
+	if err != nil {
+		return nil, err
+	}
+	pt, err := s.decrypt(k, v)
+	if err != nil {
+		return nil, err
+	}
+	if s.needsMigration(v) {
+		if err := s.inner.WriteState(k, s.encrypt(k, pt)); err != nil {
+			s.logf("encfile: re-encrypting %q: %v", k, err)
+		}
+	}
+	return pt, nil
+}
+
+// WriteState implements the StateStore interface.
+func (s *EncryptedStore) WriteState(k ipn.StateKey, bs []byte) error {
+	// Each encryption of a value differs, so skip writes of unchanged
+	// values, as the inner store can't.
+	if v, err := s.inner.ReadState(k); err == nil && !s.needsMigration(v) {
+		if pt, err := s.decrypt(k, v); err == nil && bytes.Equal(pt, bs) {
+			return nil
+		}
+	}
+	return s.inner.WriteState(k, s.encrypt(k, bs))
+}
+
+// All returns an iterator over the decrypted values in the store, if the
+// inner store is an ExportableStore. Otherwise, it yields nothing. Values
+// that fail to decrypt are skipped.
+func (s *EncryptedStore) All() iter.Seq2[ipn.StateKey, []byte] {
+	return func(yield func(ipn.StateKey, []byte) bool) {
+		exp, ok := s.inner.(ExportableStore)
+		if !ok {
+			return
+		}
+		for k, v := range exp.All() {
+			pt, err := s.decrypt(k, v)
+			if err != nil {
+				s.logf("%v", err)
+				continue
+			}
+			if !yield(k, pt) {
+				return
+			}
+		}
+	}
+}
+
+var _ ExportableStore = (*EncryptedStore)(nil)
@@ -0,0 +1,197 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package store
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+)
+
+var (
+	testEncKey1 = bytes.Repeat([]byte{1}, 32)
+	testEncKey2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestEncryptedStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	t.Setenv(encKeyEnv, hex.EncodeToString(testEncKey1))
+	s, err := New(t.Logf, EncryptedPrefix+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(ipn.EncryptedStateStore); !ok {
+		t.Errorf("%T is not an ipn.EncryptedStateStore", s)
+	}
+	testStoreSemantics(t, s)
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bs), "quux") {
+		t.Errorf("state file contains plaintext: %s", bs)
+	}
+
+	// The values are bound to their keys.
+	fs, err := NewFileStore(t.Logf, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foo, _ := fs.ReadState("foo")
+	fs.WriteState("baz", foo)
+	s, err = New(t.Logf, EncryptedPrefix+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadState("baz"); err == nil {
+		t.Error("reading swapped value unexpectedly succeeded")
+	}
+}
+
+func TestEncryptedStoreMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	fs, err := NewFileStore(t.Logf, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.WriteState("_machinekey", []byte("privkey:secret"))
+	fs.WriteState("foo", []byte("bar"))
+
+	readAll := func(t *testing.T, s ipn.StateStore) {
+		t.Helper()
+		for k, want := range map[ipn.StateKey]string{"_machinekey": "privkey:secret", "foo": "bar"} {
+			if got, err := s.ReadState(k); err != nil || string(got) != want {
+				t.Errorf("ReadState(%q) = %q, %v; want %q", k, got, err, want)
+			}
+		}
+	}
+	checkFile := func(t *testing.T) {
+		t.Helper()
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(bs), "secret") {
+			t.Errorf("state file contains plaintext: %s", bs)
+		}
+	}
+
+	// Opening the plaintext store encrypts it.
+	t.Setenv(encKeyEnv, hex.EncodeToString(testEncKey1))
+	s, err := New(t.Logf, EncryptedPrefix+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t)
+	readAll(t, s)
+
+	// Rotate to a new key.
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, testEncKey2, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(encKeyEnv, "")
+	t.Setenv(encKeyFileEnv, keyFile)
+	t.Setenv(encOldKeyEnv, hex.EncodeToString(testEncKey1))
+	s, err = New(t.Logf, EncryptedPrefix+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t)
+	readAll(t, s)
+
+	// The new key is enough after rotation, and the old one no longer works.
+	t.Setenv(encOldKeyEnv, "")
+	s, err = New(t.Logf, EncryptedPrefix+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, s)
+	t.Setenv(encKeyFileEnv, "")
+	t.Setenv(encKeyEnv, hex.EncodeToString(testEncKey1))
+	if _, err := New(t.Logf, EncryptedPrefix+path); err == nil || !strings.Contains(err.Error(), "unknown key") {
+		t.Errorf("opening with old key = %v; want unknown key error", err)
+	}
+}
+
+func TestEncryptedStoreLazyMigration(t *testing.T) {
+	// mem.Store isn't an ExportableStore, so values are migrated as they're
+	// read.
+	inner := new(mem.Store)
+	inner.WriteState("foo", []byte("bar"))
+	s, err := newEncryptedStore(t.Logf, inner, testEncKey1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := inner.ReadState("foo"); string(got) != "bar" {
+		t.Fatalf("value migrated early: %q", got)
+	}
+	if got, err := s.ReadState("foo"); err != nil || string(got) != "bar" {
+		t.Fatalf("ReadState = %q, %v; want bar", got, err)
+	}
+	if got, _ := inner.ReadState("foo"); !bytes.HasPrefix(got, []byte(encMagic)) {
+		t.Errorf("value not migrated: %q", got)
+	}
+
+	// Writing an unchanged value doesn't re-encrypt it.
+	before, _ := inner.ReadState("foo")
+	if err := s.WriteState("foo", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := inner.ReadState("foo"); !bytes.Equal(before, after) {
+		t.Error("unchanged value was rewritten")
+	}
+}
+
+func TestLoadEncKey(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(testEncKey1)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	credDir := filepath.Join(dir, "creds")
+	if err := os.Mkdir(credDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(credDir, encKeyCredential), testEncKey2, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    []byte
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "env-hex", env: map[string]string{encKeyEnv: hex.EncodeToString(testEncKey1)}, want: testEncKey1},
+		{name: "env-base64", env: map[string]string{encKeyEnv: "AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI="}, want: testEncKey2},
+		{name: "env-short", env: map[string]string{encKeyEnv: "abcd"}, wantErr: true},
+		{name: "file", env: map[string]string{encKeyFileEnv: keyFile}, want: testEncKey1},
+		{name: "file-missing", env: map[string]string{encKeyFileEnv: filepath.Join(dir, "missing")}, wantErr: true},
+		{name: "credential", env: map[string]string{"CREDENTIALS_DIRECTORY": credDir}, want: testEncKey2},
+		{name: "ambiguous", env: map[string]string{encKeyFileEnv: keyFile, "CREDENTIALS_DIRECTORY": credDir}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{encKeyEnv, encKeyFileEnv, "CREDENTIALS_DIRECTORY"} {
+				t.Setenv(k, tt.env[k])
+			}
+			got, err := loadEncKey(encKeyEnv, encKeyFileEnv, encKeyCredential)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("key = %x; want %x", got, tt.want)
+			}
+		})
+	}
+}
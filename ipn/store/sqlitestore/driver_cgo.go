@@ -0,0 +1,12 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build cgo && !ts_omit_sqlite_cgo
+
+package sqlitestore
+
+import _ "github.com/mattn/go-sqlite3"
+
+func init() {
+	DriverName = "sqlite3"
+}
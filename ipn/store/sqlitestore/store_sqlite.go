@@ -0,0 +1,263 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package sqlitestore contains an ipn.StateStore implementation backed by a
+// SQLite database, which can hold the state of many nodes, each in its own
+// namespace.
+//
+// The package uses database/sql with github.com/mattn/go-sqlite3, so it
+// requires cgo. Builds without cgo, or with the ts_omit_sqlite_cgo tag, have
+// no SQLite driver linked in, and Open fails unless the program links one
+// itself and sets DriverName to the name it registers.
+//
+// tailscaled registers the store when built with the ts_sqlite tag.
+package sqlitestore
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store"
+	"tailscale.com/types/logger"
+)
+
+// Prefix is the path prefix of SQLite stores passed to store.New. The rest
+// of the path is the database file name, optionally followed by "#" and a
+// namespace.
+const Prefix = "sqlite:"
+
+// DriverName is the name of the database/sql driver used to open databases.
+var DriverName = "sqlite"
+
+// busyTimeoutMillis is how long to wait for other processes that have the
+// database locked.
+const busyTimeoutMillis = 5000
+
+func init() {
+	store.Register(Prefix, func(logf logger.Logf, arg string) (ipn.StateStore, error) {
+		file, namespace, _ := strings.Cut(strings.TrimPrefix(arg, Prefix), "#")
+		s, err := Open(file)
+		if err != nil {
+			return nil, err
+		}
+		s.logf = logf
+		return s.WithNamespace(namespace), nil
+	})
+}
+
+var (
+	dbsMu sync.Mutex
+	dbs   map[string]*sql.DB // by absolute file name; guarded by dbsMu
+)
+
+// Store is an ipn.StateStore that keeps the state of one namespace in a
+// SQLite database. All Stores for a database share a connection to it.
+type Store struct {
+	db        *sql.DB
+	file      string
+	namespace string
+	logf      logger.Logf // or nil for log.Printf
+}
+
+// Open returns a Store for the default namespace of the SQLite database in
+// file, creating it if needed. Opening the same file again in the same
+// process reuses the connection to it.
+func Open(file string) (*Store, error) {
+	if file == "" {
+		return nil, errors.New("sqlitestore: missing database file name")
+	}
+	if !slices.Contains(sql.Drivers(), DriverName) {
+		return nil, fmt.Errorf("sqlitestore: no %q database/sql driver linked in; build with cgo, or link one in", DriverName)
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+
+	dbsMu.Lock()
+	defer dbsMu.Unlock()
+	if db, ok := dbs[abs]; ok {
+		return &Store{db: db, file: abs}, nil
+	}
+	db, err := sql.Open(DriverName, abs)
+	if err != nil {
+		return nil, fmt.Errorf("sqlitestore: %w", err)
+	}
+	// A single connection serializes access within this process, so
+	// transactions never conflict with each other.
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{
+		fmt.Sprintf("PRAGMA busy_timeout = %d", busyTimeoutMillis),
+		"PRAGMA journal_mode = WAL",
+		`CREATE TABLE IF NOT EXISTS state (
+			namespace TEXT NOT NULL,
+			key TEXT NOT NULL,
+			value BLOB NOT NULL,
+			PRIMARY KEY (namespace, key)
+		) WITHOUT ROWID`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("sqlitestore: initializing %s: %w", abs, err)
+		}
+	}
+	if dbs == nil {
+		dbs = make(map[string]*sql.DB)
+	}
+	dbs[abs] = db
+	return &Store{db: db, file: abs}, nil
+}
+
+func (s *Store) String() string {
+	return fmt.Sprintf("sqlitestore.Store(%q, %q)", s.file, s.namespace)
+}
+
+// Namespace returns the namespace of s.
+func (s *Store) Namespace() string { return s.namespace }
+
+// WithNamespace returns a Store for namespace in the same database as s.
+// It implements store.NamespacedStore.
+func (s *Store) WithNamespace(namespace string) ipn.StateStore {
+	return &Store{db: s.db, file: s.file, namespace: namespace, logf: s.logf}
+}
+
+// ReadState implements the StateStore interface.
+func (s *Store) ReadState(id ipn.StateKey) ([]byte, error) {
+	var bs []byte
+	err := s.db.QueryRow("SELECT value FROM state WHERE namespace = ? AND key = ?", s.namespace, string(id)).Scan(&bs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ipn.ErrStateNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sqlitestore: reading %q: %w", id, err)
+	}
+	return bs, nil
+}
+
+// WriteState implements the StateStore interface. The write is durable
+// when it returns.
+func (s *Store) WriteState(id ipn.StateKey, bs []byte) error {
+	return s.WriteStates(map[ipn.StateKey][]byte{id: bs})
+}
+
+// WriteStates writes several values in a single transaction, so that either
+// all of them or none are written.
+func (s *Store) WriteStates(m map[ipn.StateKey][]byte) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("sqlitestore: %w", err)
+	}
+	defer tx.Rollback()
+	for id, bs := range m {
+		if bs == nil {
+			bs = []byte{} // value is NOT NULL
+		}
+		var old []byte
+		err := tx.QueryRow("SELECT value FROM state WHERE namespace = ? AND key = ?", s.namespace, string(id)).Scan(&old)
+		if err == nil && bytes.Equal(old, bs) {
+			continue
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("sqlitestore: reading %q: %w", id, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO state (namespace, key, value) VALUES (?, ?, ?)
+			ON CONFLICT (namespace, key) DO UPDATE SET value = excluded.value`,
+			s.namespace, string(id), bs); err != nil {
+			return fmt.Errorf("sqlitestore: writing %q: %w", id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlitestore: %w", err)
+	}
+	return nil
+}
+
+// All returns an iterator over all keys and values in the namespace of s,
+// sorted by key. It reads them all before yielding any, so ReadState and
+// WriteState are safe to use while iterating. If reading them fails, the
+// error is logged and All yields nothing, rather than part of the state.
+func (s *Store) All() iter.Seq2[ipn.StateKey, []byte] {
+	return func(yield func(ipn.StateKey, []byte) bool) {
+		kvs, err := s.readAll()
+		if err != nil {
+			logf := s.logf
+			if logf == nil {
+				logf = log.Printf
+			}
+			logf("%v: %v", s, err)
+			return
+		}
+		for _, e := range kvs {
+			if !yield(e.k, e.v) {
+				return
+			}
+		}
+	}
+}
+
+type keyValue struct {
+	k ipn.StateKey
+	v []byte
+}
+
+// readAll returns all keys and values in the namespace of s, sorted by key.
+func (s *Store) readAll() ([]keyValue, error) {
+	rows, err := s.db.Query("SELECT key, value FROM state WHERE namespace = ? ORDER BY key", s.namespace)
+	if err != nil {
+		return nil, fmt.Errorf("sqlitestore: %w", err)
+	}
+	defer rows.Close()
+	var kvs []keyValue
+	for rows.Next() {
+		var e keyValue
+		if err := rows.Scan(&e.k, &e.v); err != nil {
+			return nil, fmt.Errorf("sqlitestore: %w", err)
+		}
+		kvs = append(kvs, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlitestore: %w", err)
+	}
+	return kvs, nil
+}
+
+// Namespaces returns the namespaces in the database of s that hold any
+// state, sorted.
+func (s *Store) Namespaces() ([]string, error) {
+	rows, err := s.db.Query("SELECT DISTINCT namespace FROM state ORDER BY namespace")
+	if err != nil {
+		return nil, fmt.Errorf("sqlitestore: %w", err)
+	}
+	defer rows.Close()
+	var ret []string
+	for rows.Next() {
+		var ns string
+		if err := rows.Scan(&ns); err != nil {
+			return nil, fmt.Errorf("sqlitestore: %w", err)
+		}
+		ret = append(ret, ns)
+	}
+	return ret, rows.Err()
+}
+
+// DeleteNamespace deletes all state in the namespace of s.
+func (s *Store) DeleteNamespace() error {
+	if _, err := s.db.Exec("DELETE FROM state WHERE namespace = ?", s.namespace); err != nil {
+		return fmt.Errorf("sqlitestore: %w", err)
+	}
+	return nil
+}
+
+var (
+	_ store.ExportableStore = (*Store)(nil)
+	_ store.NamespacedStore = (*Store)(nil)
+)
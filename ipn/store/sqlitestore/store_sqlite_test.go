@@ -0,0 +1,133 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sqlitestore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	if !slices.Contains(sql.Drivers(), DriverName) {
+		t.Skipf("no %q database/sql driver linked in", DriverName)
+	}
+	s, err := Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStore(t *testing.T) {
+	s := newTestStore(t)
+
+	if _, err := s.ReadState("foo"); !errors.Is(err, ipn.ErrStateNotExist) {
+		t.Fatalf("ReadState of missing key = %v; want ErrStateNotExist", err)
+	}
+	for _, v := range []string{"bar", "baz", ""} {
+		if err := s.WriteState("foo", []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := s.ReadState("foo"); err != nil || string(got) != v {
+			t.Fatalf("ReadState = %q, %v; want %q", got, err, v)
+		}
+	}
+
+	if err := s.WriteStates(map[ipn.StateKey][]byte{"a": []byte("1"), "b": []byte("2")}); err != nil {
+		t.Fatal(err)
+	}
+	got := maps.Collect(s.All())
+	want := map[ipn.StateKey][]byte{"a": []byte("1"), "b": []byte("2"), "foo": {}}
+	if !maps.EqualFunc(got, want, func(a, b []byte) bool { return string(a) == string(b) }) {
+		t.Errorf("All = %q; want %q", got, want)
+	}
+}
+
+func TestStoreNamespaces(t *testing.T) {
+	s := newTestStore(t)
+	a := s.WithNamespace("a")
+	b := s.WithNamespace("b")
+
+	if err := a.WriteState("foo", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteState("foo", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	for st, want := range map[ipn.StateStore]string{a: "a", b: "b"} {
+		if got, err := st.ReadState("foo"); err != nil || string(got) != want {
+			t.Errorf("%v: ReadState = %q, %v; want %q", st, got, err, want)
+		}
+	}
+	if _, err := s.ReadState("foo"); !errors.Is(err, ipn.ErrStateNotExist) {
+		t.Errorf("default namespace ReadState = %v; want ErrStateNotExist", err)
+	}
+	if got, err := s.Namespaces(); err != nil || !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("Namespaces = %q, %v; want [a b]", got, err)
+	}
+
+	if err := a.(*Store).DeleteNamespace(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ReadState("foo"); !errors.Is(err, ipn.ErrStateNotExist) {
+		t.Errorf("ReadState after DeleteNamespace = %v; want ErrStateNotExist", err)
+	}
+	if got, err := b.ReadState("foo"); err != nil || string(got) != "b" {
+		t.Errorf("other namespace ReadState = %q, %v; want b", got, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if !slices.Contains(sql.Drivers(), DriverName) {
+		t.Skipf("no %q database/sql driver linked in", DriverName)
+	}
+	file := filepath.Join(t.TempDir(), "state.db")
+	s1, err := store.New(t.Logf, Prefix+file+"#node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s1.WriteState("foo", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the same database again shares its connection.
+	s2, err := store.New(t.Logf, Prefix+file+"#node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s1.(*Store).db != s2.(*Store).db {
+		t.Error("stores for the same file don't share a connection")
+	}
+	if got, err := s2.ReadState("foo"); err != nil || string(got) != "bar" {
+		t.Errorf("ReadState = %q, %v; want bar", got, err)
+	}
+}
+
+func TestAllError(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.WriteState("foo", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	var logged []string
+	s.logf = func(format string, args ...any) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+	s.db.Close()
+	if got := maps.Collect(s.All()); len(got) != 0 {
+		t.Errorf("All on closed database = %q; want nothing", got)
+	}
+	if len(logged) != 1 || !strings.Contains(logged[0], "closed") {
+		t.Errorf("logged %q; want the error", logged)
+	}
+}
@@ -252,6 +252,17 @@ type ExportableStore interface {
 	All() iter.Seq2[ipn.StateKey, []byte]
 }
 
+// NamespacedStore is an ipn.StateStore that can hold the state of several
+// nodes, each in its own namespace. It holds the state of the default
+// namespace, "", itself.
+type NamespacedStore interface {
+	ipn.StateStore
+
+	// WithNamespace returns a StateStore for the given namespace, backed
+	// by the same storage.
+	WithNamespace(namespace string) ipn.StateStore
+}
+
 func maybeMigrateLocalStateFile(logf logger.Logf, path string) error {
 	path, toTPM := strings.CutPrefix(path, TPMPrefix)
 
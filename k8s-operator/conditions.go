@@ -100,6 +100,14 @@ func SetTailnetCondition(tn *tsapi.Tailnet, conditionType tsapi.ConditionType, s
 	tn.Status.Conditions = conds
 }
 
+// SetCondition ensures that conds has a condition with the given attributes.
+// It is for resources whose types are not in this package, such as Gateway
+// API resources. LastTransitionTime gets set every time condition's status
+// changes.
+func SetCondition(conds *[]metav1.Condition, conditionType string, status metav1.ConditionStatus, reason, message string, gen int64, clock tstime.Clock, logger *zap.SugaredLogger) {
+	*conds = updateCondition(*conds, tsapi.ConditionType(conditionType), status, reason, message, gen, clock, logger)
+}
+
 func updateCondition(conds []metav1.Condition, conditionType tsapi.ConditionType, status metav1.ConditionStatus, reason, message string, gen int64, clock tstime.Clock, logger *zap.SugaredLogger) []metav1.Condition {
 	newCondition := metav1.Condition{
 		Type:               string(conditionType),
@@ -9,6 +9,7 @@
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
@@ -21,25 +22,33 @@
 
 // Healthz is a simple health check server, if enabled it returns 200 OK if
 // this tailscale node currently has at least one tailnet IP address else
-// returns 503.
+// returns 503. If a backend check is enabled, it additionally returns 503
+// while the proxied backend is unhealthy.
 type Healthz struct {
 	sync.Mutex
 	hasAddrs bool
-	podIPv4  string
-	logger   logger.Logf
+	// checkBackend is whether backendErr is taken into account, see
+	// EnableBackendCheck.
+	checkBackend bool
+	backendErr   error // last result of the backend check
+	podIPv4      string
+	logger       logger.Logf
 }
 
 func (h *Healthz) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.Lock()
 	defer h.Unlock()
 
-	if h.hasAddrs {
+	switch {
+	case !h.hasAddrs:
+		http.Error(w, "node currently has no tailscale IPs", http.StatusServiceUnavailable)
+	case h.checkBackend && h.backendErr != nil:
+		http.Error(w, fmt.Sprintf("backend unhealthy: %v", h.backendErr), http.StatusServiceUnavailable)
+	default:
 		w.Header().Add(kubetypes.PodIPv4Header, h.podIPv4)
 		if _, err := w.Write([]byte("ok")); err != nil {
 			http.Error(w, fmt.Sprintf("error writing status: %v", err), http.StatusInternalServerError)
 		}
-	} else {
-		http.Error(w, "node currently has no tailscale IPs", http.StatusServiceUnavailable)
 	}
 }
 
@@ -53,6 +62,29 @@ func (h *Healthz) Update(healthy bool) {
 	h.hasAddrs = healthy
 }
 
+// EnableBackendCheck makes the health of the proxied backend, as reported via
+// UpdateBackend, a condition of this node being healthy. The backend is
+// considered unhealthy until the first UpdateBackend call.
+func (h *Healthz) EnableBackendCheck() {
+	h.Lock()
+	defer h.Unlock()
+
+	h.checkBackend = true
+	h.backendErr = errors.New("not yet checked")
+}
+
+// UpdateBackend records the result of a check of the proxied backend. A nil
+// err means that the backend is healthy.
+func (h *Healthz) UpdateBackend(err error) {
+	h.Lock()
+	defer h.Unlock()
+
+	if (h.backendErr == nil) != (err == nil) {
+		h.logger("Setting backend healthy %v", err == nil)
+	}
+	h.backendErr = err
+}
+
 func (h *Healthz) MonitorHealth(ctx context.Context, lc *local.Client) error {
 	w, err := lc.WatchIPNBus(ctx, ipn.NotifyInitialNetMap)
 	if err != nil {
@@ -22,6 +22,7 @@
 	MetricIngressResourceCount           = "k8s_ingress_resources"    // L7
 	MetricIngressPGResourceCount         = "k8s_ingress_pg_resources" // L7 on ProxyGroup
 	MetricServicePGResourceCount         = "k8s_service_pg_resources" // L3 on ProxyGroup
+	MetricGatewayResourceCount           = "k8s_gateway_resources"    // L7 Gateway API
 	MetricEgressProxyCount               = "k8s_egress_proxies"
 	MetricConnectorResourceCount         = "k8s_connector_resources"
 	MetricConnectorWithSubnetRouterCount = "k8s_connector_subnetrouter_resources"
@@ -49,6 +50,11 @@
 	// that cluster workloads behind the Ingress can now be accessed via the given DNS name over HTTPS.
 	KeyHTTPSEndpoint string = "https_endpoint"
 	ValueNoHTTPS     string = "no-https"
+	// KeyBackendHealth is a name of a field that containerboot sets to the result of the backend health check, if one
+	// is configured via TS_EXPERIMENTAL_BACKEND_HEALTH_CHECK. It is either ValueBackendHealthy or a description of why
+	// the backend is unhealthy.
+	KeyBackendHealth    string = "backend_health"
+	ValueBackendHealthy string = "healthy"
 
 	// Pod's IPv4 address header key as returned by containerboot health check endpoint.
 	PodIPv4Header string = "Pod-IPv4"
@@ -515,6 +515,11 @@ type Options struct {
 	// with the logging service as having a higher upload limit.
 	// If zero, a default upload size is chosen.
 	MaxUploadSize int
+
+	// Backend, if non-nil, is where logs are uploaded instead of the
+	// logs server, such as a self-hosted collector or a local spool.
+	// Logs are uploaded to it even if logging to Tailscale is disabled.
+	Backend logtail.Backend
 }
 
 // init initializes the log policy and returns a logtail.Config and the
@@ -629,7 +634,11 @@ func (opts Options) init(disableLogging bool) (*logtail.Config, *Policy) {
 		conf.IncludeProcSequence = true
 	}
 
-	if disableLogging {
+	if opts.Backend != nil {
+		opts.Logf("logpolicy: uploading logs to %v instead of the logs server", opts.Backend)
+		attachFilchBuffer(&conf, opts.Dir, opts.CmdName, opts.MaxBufferSize, opts.Logf)
+		conf.Backend = opts.Backend
+	} else if disableLogging {
 		opts.Logf("You have disabled logging. Tailscale will not be able to provide support.")
 		conf.HTTPC = &http.Client{Transport: noopPretendSuccessTransport{}}
 	} else {
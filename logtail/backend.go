@@ -0,0 +1,23 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"context"
+	"time"
+)
+
+// Backend is a destination for log uploads other than a logtail server.
+// See the logtail/backend package for implementations.
+type Backend interface {
+	// Upload uploads body, a JSON array of log entries as encoded by a
+	// Logger. Each entry is an object with an optional "logtail" member for
+	// metadata such as "client_time", an optional "v" verbosity level, and
+	// either a "text" member or the members of a structured log entry.
+	//
+	// If the upload fails, Upload may return how long to wait before
+	// retrying. The Logger retries the same body until it succeeds or the
+	// Logger is shut down, so Upload must not retain body.
+	Upload(ctx context.Context, body []byte) (retryAfter time.Duration, err error)
+}
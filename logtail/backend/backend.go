@@ -0,0 +1,121 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package backend provides [logtail.Backend] implementations for sending logs
+// somewhere other than a logtail server: a generic HTTP/JSON endpoint (such
+// as Loki or Elasticsearch), an OpenTelemetry (OTLP) collector, or a spool
+// directory on local disk.
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"tailscale.com/logtail"
+)
+
+// Options are options for the HTTP backends returned by [Parse].
+type Options struct {
+	// HTTPC is the client to upload with.
+	// If nil, [http.DefaultClient] is used.
+	HTTPC *http.Client
+
+	// ServiceName and Hostname identify the source of the logs.
+	// They're sent as Loki stream labels and OTLP resource attributes;
+	// other formats send log entries as they are.
+	ServiceName string // e.g. "tailscaled"
+	Hostname    string
+}
+
+// Parse returns a Backend that posts logs to an HTTP endpoint as described by
+// spec, which is FORMAT:URL where FORMAT is one of:
+//
+//   - "json", to post the JSON array of log entries as written by
+//     [logtail.Logger].
+//   - "ndjson", to post the log entries as newline-delimited JSON.
+//   - "loki", to post to the Grafana Loki push API, like
+//     "loki:http://loki:3100/loki/api/v1/push".
+//   - "elasticsearch", to post to the Elasticsearch bulk API for an index or
+//     data stream, like "elasticsearch:http://es:9200/tailscale-logs/_bulk".
+//   - "otlp", to post to an OpenTelemetry collector using OTLP/HTTP with JSON
+//     encoding, like "otlp:http://collector:4318/v1/logs".
+//
+// User info in the URL is sent as HTTP basic authentication.
+func Parse(spec string, opts Options) (*HTTP, error) {
+	f, rawURL, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid log backend %q; want FORMAT:URL", spec)
+	}
+	switch format(f) {
+	case formatJSON, formatNDJSON, formatLoki, formatElasticsearch, formatOTLP:
+	default:
+		return nil, fmt.Errorf("unknown log backend format %q; want json, ndjson, loki, elasticsearch or otlp", f)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("log backend %q: %w", spec, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("log backend %q: URL must be http or https", f+":"+u.Redacted())
+	}
+	return &HTTP{
+		url:         u.String(),
+		redactedURL: u.Redacted(),
+		format:      format(f),
+		httpc:       opts.HTTPC,
+		opts:        opts,
+		now:         time.Now,
+	}, nil
+}
+
+// entry is a log entry as encoded by a [logtail.Logger].
+type entry struct {
+	raw json.RawMessage
+
+	ClientTime time.Time // or zero
+	Text       string    // or empty for a structured log entry
+	Level      int       // 0 for normal messages, higher for verbose ones
+}
+
+// line returns the entry's text, or the JSON encoding of a structured log
+// entry.
+func (e *entry) line() string {
+	if e.Text != "" {
+		return e.Text
+	}
+	return string(e.raw)
+}
+
+// parseEntries parses body, a JSON array of log entries as passed to
+// [logtail.Backend.Upload]. Entries without a client time are given now.
+func parseEntries(body []byte, now time.Time) ([]entry, error) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(body, &raws); err != nil {
+		return nil, err
+	}
+	ents := make([]entry, 0, len(raws))
+	for _, raw := range raws {
+		var e struct {
+			Logtail struct {
+				ClientTime time.Time `json:"client_time"`
+			} `json:"logtail"`
+			Text  string `json:"text"`
+			Level int    `json:"v"`
+		}
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return nil, err
+		}
+		t := e.Logtail.ClientTime
+		if t.IsZero() {
+			t = now
+		}
+		ents = append(ents, entry{raw: raw, ClientTime: t, Text: e.Text, Level: e.Level})
+	}
+	return ents, nil
+}
+
+var _ logtail.Backend = (*HTTP)(nil)
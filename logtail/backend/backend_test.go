@@ -0,0 +1,262 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"tailscale.com/util/must"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string // String of the result, if not spec
+		wantErr bool
+	}{
+		{spec: "json:https://logs.example.com/ingest"},
+		{spec: "ndjson:http://127.0.0.1:8080/"},
+		{spec: "loki:http://loki:3100/loki/api/v1/push"},
+		{spec: "elasticsearch:https://user:pass@es:9200/tailscale-logs/_bulk", want: "elasticsearch:https://user:xxxxx@es:9200/tailscale-logs/_bulk"},
+		{spec: "otlp:http://collector:4318/v1/logs"},
+		{spec: "https://logs.example.com", wantErr: true},
+		{spec: "syslog:udp://localhost:514", wantErr: true},
+		{spec: "json:ftp://logs.example.com", wantErr: true},
+		{spec: "json:", wantErr: true},
+		{spec: "bogus", wantErr: true},
+	}
+	for _, tt := range tests {
+		h, err := Parse(tt.spec, Options{})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v; want error: %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		want := tt.want
+		if want == "" {
+			want = tt.spec
+		}
+		if err == nil && h.String() != want {
+			t.Errorf("Parse(%q).String() = %q; want %q", tt.spec, h.String(), want)
+		}
+	}
+}
+
+const testBody = `[` +
+	`{"logtail":{"client_time":"2025-01-02T03:04:05.000000006Z","proc_id":7},"text":"hello\n"},` +
+	`{"logtail":{"client_time":"2025-01-02T03:04:06Z"},"v":1,"text":"verbose"},` +
+	`{"logtail":{"client_time":"2025-01-02T03:04:07Z"},"netcheck":{"udp":true}}` +
+	`]`
+
+func TestHTTP(t *testing.T) {
+	var gotType, gotBody string
+	var status int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotType = r.Header.Get("Content-Type")
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		if status != 0 {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(status)
+		}
+	}))
+	defer ts.Close()
+
+	observed := time.Date(2025, 1, 2, 3, 4, 8, 0, time.UTC)
+	tests := []struct {
+		format   string
+		wantType string
+		want     string
+	}{
+		{
+			format:   "json",
+			wantType: "application/json",
+			want:     testBody,
+		},
+		{
+			format:   "ndjson",
+			wantType: "application/x-ndjson",
+			want: `{"logtail":{"client_time":"2025-01-02T03:04:05.000000006Z","proc_id":7},"text":"hello\n"}` + "\n" +
+				`{"logtail":{"client_time":"2025-01-02T03:04:06Z"},"v":1,"text":"verbose"}` + "\n" +
+				`{"logtail":{"client_time":"2025-01-02T03:04:07Z"},"netcheck":{"udp":true}}` + "\n",
+		},
+		{
+			format:   "elasticsearch",
+			wantType: "application/x-ndjson",
+			want: `{"create":{}}` + "\n" +
+				`{"logtail":{"client_time":"2025-01-02T03:04:05.000000006Z","proc_id":7},"text":"hello\n"}` + "\n" +
+				`{"create":{}}` + "\n" +
+				`{"logtail":{"client_time":"2025-01-02T03:04:06Z"},"v":1,"text":"verbose"}` + "\n" +
+				`{"create":{}}` + "\n" +
+				`{"logtail":{"client_time":"2025-01-02T03:04:07Z"},"netcheck":{"udp":true}}` + "\n",
+		},
+		{
+			format:   "loki",
+			wantType: "application/json",
+			want: `{"streams":[{"stream":{"host":"node1","service_name":"tailscaled"},"values":[` +
+				`["1735787045000000006","hello\n"],` +
+				`["1735787046000000000","verbose"],` +
+				`["1735787047000000000","{\"logtail\":{\"client_time\":\"2025-01-02T03:04:07Z\"},\"netcheck\":{\"udp\":true}}"]]}]}`,
+		},
+		{
+			format:   "otlp",
+			wantType: "application/json",
+			want: `{"resourceLogs":[{"resource":{"attributes":[` +
+				`{"key":"service.name","value":{"stringValue":"tailscaled"}},` +
+				`{"key":"host.name","value":{"stringValue":"node1"}}]},` +
+				`"scopeLogs":[{"scope":{"name":"tailscale.com/logtail"},"logRecords":[` +
+				`{"timeUnixNano":"1735787045000000006","observedTimeUnixNano":"1735787048000000000","severityNumber":9,"severityText":"INFO","body":{"stringValue":"hello\n"}},` +
+				`{"timeUnixNano":"1735787046000000000","observedTimeUnixNano":"1735787048000000000","severityNumber":5,"severityText":"DEBUG","body":{"stringValue":"verbose"}},` +
+				`{"timeUnixNano":"1735787047000000000","observedTimeUnixNano":"1735787048000000000","severityNumber":9,"severityText":"INFO","body":{"stringValue":"{\"logtail\":{\"client_time\":\"2025-01-02T03:04:07Z\"},\"netcheck\":{\"udp\":true}}"}}` +
+				`]}]}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			h, err := Parse(tt.format+":"+ts.URL, Options{ServiceName: "tailscaled", Hostname: "node1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			h.now = func() time.Time { return observed }
+			status = 0
+			if _, err := h.Upload(context.Background(), []byte(testBody)); err != nil {
+				t.Fatal(err)
+			}
+			if gotType != tt.wantType {
+				t.Errorf("Content-Type = %q; want %q", gotType, tt.wantType)
+			}
+			if strings.HasSuffix(tt.wantType, "/json") {
+				if !json.Valid([]byte(gotBody)) {
+					t.Errorf("invalid JSON body: %s", gotBody)
+				}
+			}
+			if diff := cmp.Diff(tt.want, gotBody); diff != "" {
+				t.Errorf("body mismatch (-want +got):\n%s", diff)
+			}
+
+			status = http.StatusServiceUnavailable
+			retryAfter, err := h.Upload(context.Background(), []byte(testBody))
+			if err == nil {
+				t.Fatal("unexpected success uploading to failing server")
+			}
+			if retryAfter != 7*time.Second {
+				t.Errorf("retryAfter = %v; want 7s", retryAfter)
+			}
+		})
+	}
+}
+
+// fakeUpstream is a Backend that records uploads, or fails.
+type fakeUpstream struct {
+	fail     bool
+	uploaded []string
+}
+
+func (f *fakeUpstream) Upload(ctx context.Context, body []byte) (time.Duration, error) {
+	if f.fail {
+		return 0, errors.New("unreachable")
+	}
+	f.uploaded = append(f.uploaded, string(body))
+	return 0, nil
+}
+
+func spooled(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bodies []string
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, string(b))
+	}
+	return bodies
+}
+
+func TestSpool(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "spool")
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	up := &fakeUpstream{fail: true}
+	s, err := NewSpool(dir, SpoolOptions{Upstream: up})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return now }
+	upload := func(body string) {
+		t.Helper()
+		if _, err := s.Upload(context.Background(), []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// While the upstream fails, logs are spooled, and the upstream isn't
+	// retried until the retry interval passes.
+	upload(`[1]`)
+	up.fail = false
+	now = now.Add(time.Second)
+	upload(`[2]`)
+	if diff := cmp.Diff([]string{`[1]`, `[2]`}, spooled(t, dir)); diff != "" {
+		t.Errorf("spooled (-want +got):\n%s", diff)
+	}
+	if len(up.uploaded) != 0 {
+		t.Errorf("upstream retried too soon: %q", up.uploaded)
+	}
+
+	// Once the upstream works, spooled logs are sent first.
+	now = now.Add(spoolRetryInterval)
+	upload(`[3]`)
+	if diff := cmp.Diff([]string{`[1]`, `[2]`, `[3]`}, up.uploaded); diff != "" {
+		t.Errorf("uploaded (-want +got):\n%s", diff)
+	}
+	if got := spooled(t, dir); len(got) != 0 {
+		t.Errorf("logs left in spool: %q", got)
+	}
+}
+
+func TestSpoolRetention(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSpool(dir, SpoolOptions{MaxSize: 10, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	for _, body := range []string{`[1]`, `[2]`, `[3]`, `[4]`} {
+		if _, err := s.Upload(context.Background(), []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the newest three files fit in 10 bytes.
+	if diff := cmp.Diff([]string{`[2]`, `[3]`, `[4]`}, spooled(t, dir)); diff != "" {
+		t.Errorf("spooled (-want +got):\n%s", diff)
+	}
+
+	// Make the oldest files too old.
+	for _, name := range must.Get(filepath.Glob(filepath.Join(dir, "*.json")))[:2] {
+		old := now.Add(-2 * time.Hour)
+		if err := os.Chtimes(name, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Upload(context.Background(), []byte(`[5]`)); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{`[4]`, `[5]`}, spooled(t, dir)); diff != "" {
+		t.Errorf("spooled (-want +got):\n%s", diff)
+	}
+}
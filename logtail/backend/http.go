@@ -0,0 +1,214 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// format is the encoding of logs posted by an [HTTP] backend.
+type format string
+
+const (
+	formatJSON          format = "json"
+	formatNDJSON        format = "ndjson"
+	formatLoki          format = "loki"
+	formatElasticsearch format = "elasticsearch"
+	formatOTLP          format = "otlp"
+)
+
+// HTTP is a [logtail.Backend] that posts logs to an HTTP endpoint.
+// It's created by [Parse].
+type HTTP struct {
+	url         string
+	redactedURL string // url with any password replaced by "xxxxx"
+	format      format
+	httpc       *http.Client // or nil for http.DefaultClient
+	opts        Options
+
+	now func() time.Time // or time.Now
+}
+
+// String returns the backend's spec, as passed to [Parse], with any
+// password in its URL redacted so that it can be logged.
+func (h *HTTP) String() string {
+	return string(h.format) + ":" + h.redactedURL
+}
+
+// Upload implements [logtail.Backend].
+func (h *HTTP) Upload(ctx context.Context, body []byte) (retryAfter time.Duration, err error) {
+	contentType := "application/json"
+	if h.format != formatJSON {
+		ents, err := parseEntries(body, h.now())
+		if err != nil {
+			return 0, fmt.Errorf("parsing logs: %w", err)
+		}
+		if len(ents) == 0 {
+			return 0, nil
+		}
+		switch h.format {
+		case formatNDJSON:
+			body, contentType = encodeNDJSON(ents), "application/x-ndjson"
+		case formatElasticsearch:
+			body, contentType = encodeElasticsearch(ents), "application/x-ndjson"
+		case formatLoki:
+			body, err = h.encodeLoki(ents)
+		case formatOTLP:
+			body, err = h.encodeOTLP(ents)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", h.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	httpc := h.httpc
+	if httpc == nil {
+		httpc = http.DefaultClient
+	}
+	res, err := httpc.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		n, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return time.Duration(n) * time.Second, fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(b))
+	}
+	// Elasticsearch reports rejected documents in a 200 response. They're
+	// rejected for being malformed, so retrying wouldn't help; drop them.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<20))
+	return 0, nil
+}
+
+func encodeNDJSON(ents []entry) []byte {
+	var b []byte
+	for _, e := range ents {
+		b = append(b, e.raw...)
+		b = append(b, '\n')
+	}
+	return b
+}
+
+func encodeElasticsearch(ents []entry) []byte {
+	var b []byte
+	for _, e := range ents {
+		// "create" works for both indices and data streams, and lets
+		// Elasticsearch choose the document ID.
+		b = append(b, `{"create":{}}`+"\n"...)
+		b = append(b, e.raw...)
+		b = append(b, '\n')
+	}
+	return b
+}
+
+// encodeLoki encodes ents as a request to the Loki push API
+// (https://grafana.com/docs/loki/latest/reference/loki-http-api/#ingest-logs).
+func (h *HTTP) encodeLoki(ents []entry) ([]byte, error) {
+	labels := map[string]string{"service_name": h.opts.ServiceName}
+	if labels["service_name"] == "" {
+		labels["service_name"] = "tailscale"
+	}
+	if h.opts.Hostname != "" {
+		labels["host"] = h.opts.Hostname
+	}
+	values := make([][2]string, len(ents))
+	for i, e := range ents {
+		values[i] = [2]string{strconv.FormatInt(e.ClientTime.UnixNano(), 10), e.line()}
+	}
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	return json.Marshal(struct {
+		Streams []stream `json:"streams"`
+	}{
+		Streams: []stream{{Stream: labels, Values: values}},
+	})
+}
+
+// OTLP severity numbers
+// (https://opentelemetry.io/docs/specs/otel/logs/data-model/#field-severitynumber).
+const (
+	otlpSeverityDebug = 5
+	otlpSeverityInfo  = 9
+)
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string       `json:"timeUnixNano"`
+	ObservedTimeUnixNano string       `json:"observedTimeUnixNano"`
+	SeverityNumber       int          `json:"severityNumber"`
+	SeverityText         string       `json:"severityText"`
+	Body                 otlpAnyValue `json:"body"`
+}
+
+// encodeOTLP encodes ents as an OTLP ExportLogsServiceRequest using the
+// protobuf JSON mapping
+// (https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding).
+func (h *HTTP) encodeOTLP(ents []entry) ([]byte, error) {
+	var attrs []otlpKeyValue
+	if h.opts.ServiceName != "" {
+		attrs = append(attrs, otlpKeyValue{"service.name", otlpAnyValue{h.opts.ServiceName}})
+	}
+	if h.opts.Hostname != "" {
+		attrs = append(attrs, otlpKeyValue{"host.name", otlpAnyValue{h.opts.Hostname}})
+	}
+	observed := strconv.FormatInt(h.now().UnixNano(), 10)
+	recs := make([]otlpLogRecord, len(ents))
+	for i, e := range ents {
+		recs[i] = otlpLogRecord{
+			TimeUnixNano:         strconv.FormatInt(e.ClientTime.UnixNano(), 10),
+			ObservedTimeUnixNano: observed,
+			SeverityNumber:       otlpSeverityInfo,
+			SeverityText:         "INFO",
+			Body:                 otlpAnyValue{e.line()},
+		}
+		if e.Level > 0 {
+			recs[i].SeverityNumber, recs[i].SeverityText = otlpSeverityDebug, "DEBUG"
+		}
+	}
+	type scope struct {
+		Name string `json:"name"`
+	}
+	type scopeLogs struct {
+		Scope      scope           `json:"scope"`
+		LogRecords []otlpLogRecord `json:"logRecords"`
+	}
+	type resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	type resourceLogs struct {
+		Resource  resource    `json:"resource"`
+		ScopeLogs []scopeLogs `json:"scopeLogs"`
+	}
+	return json.Marshal(struct {
+		ResourceLogs []resourceLogs `json:"resourceLogs"`
+	}{
+		ResourceLogs: []resourceLogs{{
+			Resource:  resource{Attributes: attrs},
+			ScopeLogs: []scopeLogs{{Scope: scope{Name: "tailscale.com/logtail"}, LogRecords: recs}},
+		}},
+	})
+}
@@ -0,0 +1,205 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package backend
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/logtail"
+)
+
+// DefaultSpoolMaxSize is the default [SpoolOptions.MaxSize].
+const DefaultSpoolMaxSize = 100 << 20
+
+// spoolRetryInterval is how long a [Spool] waits after its upstream fails
+// before trying it again, if the upstream doesn't say.
+const spoolRetryInterval = 30 * time.Second
+
+// SpoolOptions are options for [NewSpool].
+type SpoolOptions struct {
+	// Upstream, if non-nil, is where the spool sends logs when it can.
+	// If nil, logs are only kept on disk.
+	Upstream logtail.Backend
+
+	// MaxSize is the maximum total size of the spooled logs, after which
+	// the oldest are deleted. If zero, DefaultSpoolMaxSize is used.
+	MaxSize int64
+
+	// MaxAge is how long to keep spooled logs, after which they're deleted.
+	// If zero, logs are only deleted for size.
+	MaxAge time.Duration
+}
+
+// Spool is a [logtail.Backend] that writes logs to files in a directory,
+// for nodes without a reachable log collector.
+//
+// If it has an upstream Backend, logs are only spooled while the upstream
+// fails. Once it succeeds again, the spooled logs are sent to it, oldest
+// first, before any new ones.
+//
+// Each file is a JSON array of log entries, as passed to Upload, named so
+// that they sort by the time they were spooled.
+type Spool struct {
+	dir      string
+	upstream logtail.Backend // or nil
+	maxSize  int64
+	maxAge   time.Duration
+
+	now func() time.Time // or time.Now
+
+	mu      sync.Mutex
+	seq     uint64    // to make file names unique
+	retryAt time.Time // when to next try upstream after a failure
+}
+
+// NewSpool returns a new Spool that writes logs to dir, creating it if
+// needed. Logs already in dir are kept and sent upstream like new ones.
+func NewSpool(dir string, opts SpoolOptions) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	// Remove partially written files left by a previous process.
+	tmps, _ := filepath.Glob(filepath.Join(dir, "*.json.tmp"))
+	for _, f := range tmps {
+		os.Remove(f)
+	}
+	return &Spool{
+		dir:      dir,
+		upstream: opts.Upstream,
+		maxSize:  cmp.Or(opts.MaxSize, DefaultSpoolMaxSize),
+		maxAge:   opts.MaxAge,
+		now:      time.Now,
+	}, nil
+}
+
+// String returns a description of the spool for logging.
+func (s *Spool) String() string {
+	if s.upstream != nil {
+		return fmt.Sprintf("%v (spooling to %s)", s.upstream, s.dir)
+	}
+	return "spool:" + s.dir
+}
+
+// Upload implements [logtail.Backend]. It only fails if the logs
+// can't be spooled.
+func (s *Spool) Upload(ctx context.Context, body []byte) (retryAfter time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.upstream != nil && !s.now().Before(s.retryAt) {
+		err := s.flushLocked(ctx)
+		if err == nil {
+			retryAfter, err = s.upstream.Upload(ctx, body)
+			if err == nil {
+				return 0, nil
+			}
+		}
+		s.retryAt = s.now().Add(cmp.Or(retryAfter, spoolRetryInterval))
+	}
+	if err := s.writeLocked(body); err != nil {
+		return 0, fmt.Errorf("spooling logs: %w", err)
+	}
+	s.pruneLocked()
+	return 0, nil
+}
+
+// spoolFile is a file of spooled logs.
+type spoolFile struct {
+	name string
+	size int64
+	mod  time.Time
+}
+
+// filesLocked returns the spooled files, oldest first.
+func (s *Spool) filesLocked() ([]spoolFile, error) {
+	des, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []spoolFile
+	for _, de := range des {
+		if !de.Type().IsRegular() || !strings.HasSuffix(de.Name(), ".json") {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue // removed concurrently
+		}
+		files = append(files, spoolFile{de.Name(), fi.Size(), fi.ModTime()})
+	}
+	slices.SortFunc(files, func(a, b spoolFile) int { return strings.Compare(a.name, b.name) })
+	return files, nil
+}
+
+// flushLocked sends the spooled logs upstream, oldest first, deleting each
+// once it's sent. It stops at the first error.
+func (s *Spool) flushLocked(ctx context.Context) error {
+	s.pruneLocked()
+	files, err := s.filesLocked()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		path := filepath.Join(s.dir, f.name)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if _, err := s.upstream.Upload(ctx, b); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLocked writes body to a new file in the spool.
+func (s *Spool) writeLocked(body []byte) error {
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d.json", s.now().UnixNano(), s.seq%1e6)
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err := os.WriteFile(tmp, body, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, name))
+}
+
+// pruneLocked deletes the spooled files that are older than maxAge, then the
+// oldest files until the rest fit in maxSize.
+func (s *Spool) pruneLocked() error {
+	files, err := s.filesLocked()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	var errs []error
+	for _, f := range files {
+		tooOld := s.maxAge > 0 && s.now().Sub(f.mod) > s.maxAge
+		if !tooOld && total <= s.maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(s.dir, f.name)); err != nil {
+			errs = append(errs, err)
+		}
+		total -= f.size
+	}
+	return errors.Join(errs...)
+}
+
+var _ logtail.Backend = (*Spool)(nil)
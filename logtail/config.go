@@ -41,6 +41,10 @@ type Config struct {
 	CompressLogs   bool            // whether to compress the log uploads
 	MaxUploadSize  int             // maximum upload size; 0 means using the default
 
+	// Backend, if non-nil, is where logs are uploaded instead of the
+	// logtail server at BaseURL. Uploads to it aren't compressed.
+	Backend Backend
+
 	// MetricsDelta, if non-nil, is a func that returns an encoding
 	// delta in clientmetrics to upload alongside existing logs.
 	// It can return either an empty string (for nothing) or a string
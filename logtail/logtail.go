@@ -110,6 +110,7 @@ func NewLogger(cfg Config, logf tslogger.Logf) *Logger {
 		url:            cfg.BaseURL + "/c/" + cfg.Collection + "/" + cfg.PrivateID.String() + urlSuffix,
 		lowMem:         cfg.LowMemory,
 		buffer:         cfg.Buffer,
+		backend:        cfg.Backend,
 		maxUploadSize:  cfg.MaxUploadSize,
 		skipClientTime: cfg.SkipClientTime,
 		drainWake:      make(chan struct{}, 1),
@@ -152,6 +153,7 @@ type Logger struct {
 	skipClientTime bool
 	netMonitor     *netmon.Monitor
 	buffer         Buffer
+	backend        Backend // or nil to upload to url
 	maxUploadSize  int
 	drainWake      chan struct{}        // signal to speed up drain
 	drainBuf       []byte               // owned by drainPending for reuse
@@ -372,7 +374,7 @@ func (lg *Logger) uploading(ctx context.Context) {
 		body := lg.drainPending()
 		origlen := -1 // sentinel value: uncompressed
 		// Don't attempt to compress tiny bodies; not worth the CPU cycles.
-		if lg.compressLogs && len(body) > 256 {
+		if lg.compressLogs && lg.backend == nil && len(body) > 256 {
 			zbody := zstdframe.AppendEncode(nil, body,
 				zstdframe.FastestCompression, zstdframe.LowMemory(true))
 
@@ -481,7 +483,7 @@ func (lg *Logger) awaitInternetUp(ctx context.Context) {
 	}
 }
 
-// upload uploads body to the log server.
+// upload uploads body to the log server, or the Backend if there is one.
 // origlen indicates the pre-compression body length.
 // origlen of -1 indicates that the body is not compressed.
 func (lg *Logger) upload(ctx context.Context, body []byte, origlen int) (retryAfter time.Duration, err error) {
@@ -493,6 +495,17 @@ func (lg *Logger) upload(ctx context.Context, body []byte, origlen int) (retryAf
 	ctx, cancel := context.WithTimeout(ctx, maxUploadTime)
 	defer cancel()
 
+	if lg.backend != nil {
+		retryAfter, err := lg.backend.Upload(ctx, body)
+		if err != nil {
+			lg.failedCalls.Add(1)
+			return retryAfter, fmt.Errorf("log upload of %d bytes failed: %w", len(body), err)
+		}
+		lg.uploadedBytes.Add(int64(len(body)))
+		lg.uploadingTime.Add(int64(time.Since(startUpload)))
+		return 0, nil
+	}
+
 	req, err := http.NewRequestWithContext(ctx, "POST", lg.url, bytes.NewReader(body))
 	if err != nil {
 		// I know of no conditions under which this could fail.
@@ -590,6 +603,7 @@ func (lg *Logger) ExpVar() expvar.Var {
 var logtailDisabled atomic.Bool
 
 // Disable disables logtail uploads for the lifetime of the process.
+// Loggers with a [Config.Backend] continue to upload to it.
 func Disable() {
 	logtailDisabled.Store(true)
 }
@@ -613,7 +627,7 @@ func (lg *Logger) tryDrainWake() {
 
 func (lg *Logger) sendLocked(jsonBlob []byte) (int, error) {
 	tapSend(jsonBlob)
-	if logtailDisabled.Load() {
+	if logtailDisabled.Load() && lg.backend == nil {
 		return len(jsonBlob), nil
 	}
 
@@ -150,6 +150,36 @@ func TestEncodeAndUploadMessages(t *testing.T) {
 	}
 }
 
+// chanBackend is a Backend that sends uploads to a channel.
+type chanBackend chan []byte
+
+func (c chanBackend) Upload(ctx context.Context, body []byte) (time.Duration, error) {
+	c <- bytes.Clone(body)
+	return 0, nil
+}
+
+func TestBackend(t *testing.T) {
+	uploaded := make(chanBackend, 2+logLines)
+	logger := NewLogger(Config{
+		BaseURL:      "http://127.0.0.1:1", // unused
+		Bus:          eventbustest.NewBus(t),
+		Backend:      uploaded,
+		CompressLogs: true,
+	}, t.Logf)
+	if body := <-uploaded; !strings.Contains(string(body), "started") {
+		t.Errorf("unknown start logging statement: %q", body)
+	}
+
+	io.WriteString(logger, strings.Repeat("log line ", 100))
+	data := unmarshalOne(t, <-uploaded)
+	if got := data["text"]; !strings.HasPrefix(got.(string), "log line") {
+		t.Errorf("got text %q; want log line", got)
+	}
+	if err := logger.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestLoggerWriteLength(t *testing.T) {
 	lg := &Logger{
 		clock:  tstime.StdClock{},
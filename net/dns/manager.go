@@ -637,10 +637,13 @@ func (m *Manager) Down() error {
 	return nil
 }
 
+// FlushCaches flushes the resolver's response cache and the OS's DNS caches.
+// It's called on major link changes.
 func (m *Manager) FlushCaches() error {
 	if !buildfeatures.HasDNS {
 		return nil
 	}
+	m.resolver.FlushCache()
 	return flushCaches()
 }
 
@@ -0,0 +1,271 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"strings"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/envknob"
+	"tailscale.com/syncs"
+	"tailscale.com/util/lru"
+)
+
+const (
+	// cacheMaxEntries is the maximum number of responses in the cache.
+	cacheMaxEntries = 2048
+
+	// cacheMaxTTL is the longest a response is cached for, whatever its
+	// TTL, so that records changed upstream are picked up eventually.
+	cacheMaxTTL = time.Hour
+
+	// cacheMaxNegativeTTL is the longest an NXDOMAIN or NODATA response is
+	// cached for. RFC 2308 section 5 suggests 1 to 3 hours; we're more
+	// conservative, as a name that doesn't exist yet is often about to.
+	cacheMaxNegativeTTL = 5 * time.Minute
+)
+
+var disableCache = envknob.RegisterBool("TS_DNS_DISABLE_CACHE")
+
+// CacheEntry describes a cached DNS response, for debugging.
+type CacheEntry struct {
+	Name     string    // question name
+	Type     string    // question type, like "TypeA"
+	Upstream string    // resolver the response came from; empty for the DNS config's routes
+	RCode    string    // response code, like "RCodeSuccess"
+	Negative bool      // whether it's an NXDOMAIN or NODATA response
+	Records  int       // number of answer records
+	Expires  time.Time // when the entry expires
+}
+
+// responseCache is a bounded cache of DNS responses from upstream resolvers.
+// Positive responses are cached for the lowest TTL of their records, and
+// negative responses (NXDOMAIN and NODATA) as described in RFC 2308.
+//
+// A nil *responseCache is valid and caches nothing.
+type responseCache struct {
+	mu  syncs.Mutex
+	lru lru.Cache[cacheKey, *cacheEntry] // guarded by mu
+}
+
+func newResponseCache() *responseCache {
+	c := &responseCache{}
+	c.lru.MaxEntries = cacheMaxEntries
+	return c
+}
+
+// cacheKey is the key of a cached DNS response: the question it answers,
+// plus everything else about the query or its destination that may affect
+// the response.
+type cacheKey struct {
+	upstream         string // resolver that answered; empty for the DNS config's routes
+	name             string // lowercase
+	typ              dns.Type
+	class            dns.Class
+	edns             bool // whether the query had an EDNS OPT record
+	dnssecOK         bool // the EDNS DO bit
+	checkingDisabled bool
+}
+
+type cacheEntry struct {
+	msg      dns.Message // as received from upstream
+	negative bool
+	added    time.Time
+	expires  time.Time
+}
+
+// parseCacheQuery parses the DNS query packet q, reporting whether its
+// response can be cached.
+func parseCacheQuery(q []byte, upstream string) (_ cacheKey, _ dns.Header, _ dns.Question, ok bool) {
+	var p dns.Parser
+	h, err := p.Start(q)
+	if err != nil || h.Response || h.OpCode != 0 {
+		return
+	}
+	qs, err := p.AllQuestions()
+	if err != nil || len(qs) != 1 {
+		return
+	}
+	k := cacheKey{
+		upstream:         upstream,
+		name:             strings.ToLower(qs[0].Name.String()),
+		typ:              qs[0].Type,
+		class:            qs[0].Class,
+		checkingDisabled: h.CheckingDisabled,
+	}
+	if p.SkipAllAnswers() != nil || p.SkipAllAuthorities() != nil {
+		return
+	}
+	for {
+		rh, err := p.AdditionalHeader()
+		if err == dns.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return
+		}
+		if rh.Type == dns.TypeOPT {
+			// Responses to queries without EDNS must fit in 512 bytes
+			// and have no OPT record, so they're cached separately.
+			k.edns = true
+			k.dnssecOK = rh.DNSSECAllowed()
+		}
+		if p.SkipAdditional() != nil {
+			return
+		}
+	}
+	return k, h, qs[0], true
+}
+
+// cacheTTL returns how long the response msg may be cached for, and whether
+// it's a negative response. It returns zero if msg must not be cached.
+func cacheTTL(msg *dns.Message) (_ time.Duration, negative bool) {
+	if msg.Truncated {
+		return 0, false
+	}
+	switch {
+	case msg.RCode == dns.RCodeSuccess && len(msg.Answers) > 0:
+		ttl := uint32(cacheMaxTTL / time.Second)
+		for _, sec := range [][]dns.Resource{msg.Answers, msg.Authorities, msg.Additionals} {
+			for _, rr := range sec {
+				if rr.Header.Type != dns.TypeOPT {
+					ttl = min(ttl, rr.Header.TTL)
+				}
+			}
+		}
+		return time.Duration(ttl) * time.Second, false
+	case msg.RCode == dns.RCodeSuccess, msg.RCode == dns.RCodeNameError:
+		// RFC 2308 section 5: negative responses are cached for the lower of
+		// the SOA record's TTL and its MINIMUM field. Responses without an
+		// SOA record aren't cached.
+		for _, rr := range msg.Authorities {
+			soa, ok := rr.Body.(*dns.SOAResource)
+			if !ok {
+				continue
+			}
+			ttl := time.Duration(min(rr.Header.TTL, soa.MinTTL)) * time.Second
+			return min(ttl, cacheMaxNegativeTTL), true
+		}
+	}
+	return 0, false
+}
+
+// get returns the cached response to the DNS query packet q sent to
+// upstream, if any, as of now. The response has q's ID and question, and
+// record TTLs reduced by the time spent in the cache.
+func (c *responseCache) get(q []byte, upstream string, now time.Time) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	k, h, question, ok := parseCacheQuery(q, upstream)
+	if !ok {
+		return nil, false
+	}
+	c.mu.Lock()
+	e, ok := c.lru.GetOk(k)
+	if ok && !now.Before(e.expires) {
+		c.lru.Delete(k)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		metricDNSCacheMiss.Add(1)
+		return nil, false
+	}
+
+	elapsed := uint32(now.Sub(e.added) / time.Second)
+	msg := dns.Message{
+		Header:      e.msg.Header,
+		Questions:   []dns.Question{question},
+		Answers:     agedResources(e.msg.Answers, elapsed),
+		Authorities: agedResources(e.msg.Authorities, elapsed),
+		Additionals: agedResources(e.msg.Additionals, elapsed),
+	}
+	msg.ID = h.ID
+	res, err := msg.Pack()
+	if err != nil {
+		return nil, false
+	}
+	metricDNSCacheHit.Add(1)
+	return res, true
+}
+
+// agedResources returns a copy of rrs with elapsed seconds subtracted from
+// their TTLs.
+func agedResources(rrs []dns.Resource, elapsed uint32) []dns.Resource {
+	if len(rrs) == 0 {
+		return nil
+	}
+	ret := make([]dns.Resource, len(rrs))
+	for i, rr := range rrs {
+		if rr.Header.Type != dns.TypeOPT { // OPT's TTL field holds flags
+			rr.Header.TTL -= min(rr.Header.TTL, elapsed)
+		}
+		ret[i] = rr
+	}
+	return ret
+}
+
+// add caches res, the response from upstream to the DNS query packet q, if
+// it's cacheable.
+func (c *responseCache) add(q, res []byte, upstream string, now time.Time) {
+	if c == nil {
+		return
+	}
+	k, h, _, ok := parseCacheQuery(q, upstream)
+	if !ok {
+		return
+	}
+	e := &cacheEntry{added: now}
+	if err := e.msg.Unpack(res); err != nil || e.msg.ID != h.ID || !e.msg.Response {
+		return
+	}
+	ttl, negative := cacheTTL(&e.msg)
+	if ttl <= 0 {
+		return
+	}
+	e.negative = negative
+	e.expires = now.Add(ttl)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Set(k, e)
+}
+
+// flush removes all entries from the cache.
+func (c *responseCache) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Clear()
+}
+
+// entries returns the unexpired entries in the cache as of now, most recently
+// used first.
+func (c *responseCache) entries(now time.Time) []CacheEntry {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret []CacheEntry
+	c.lru.ForEach(func(k cacheKey, e *cacheEntry) {
+		if !now.Before(e.expires) {
+			return
+		}
+		ret = append(ret, CacheEntry{
+			Name:     k.name,
+			Type:     k.typ.String(),
+			Upstream: k.upstream,
+			RCode:    e.msg.RCode.String(),
+			Negative: e.negative,
+			Records:  len(e.msg.Answers),
+			Expires:  e.expires,
+		})
+	})
+	return ret
+}
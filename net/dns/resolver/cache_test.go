@@ -0,0 +1,298 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+)
+
+// makeCacheTestQuery returns a query for name and typ with the given ID,
+// with an EDNS OPT record with the DO bit set if dnssecOK.
+func makeCacheTestQuery(t testing.TB, id uint16, name string, typ dns.Type, dnssecOK bool) []byte {
+	t.Helper()
+	b := dns.NewBuilder(nil, dns.Header{ID: id, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dns.Question{Name: dns.MustNewName(name), Type: typ, Class: dns.ClassINET})
+	if dnssecOK {
+		b.StartAdditionals()
+		var rh dns.ResourceHeader
+		if err := rh.SetEDNS0(1232, dns.RCodeSuccess, true); err != nil {
+			t.Fatal(err)
+		}
+		b.OPTResource(rh, dns.OPTResource{})
+	}
+	q, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// makeCacheTestResponse returns a response to q with the given answers and
+// authorities.
+func makeCacheTestResponse(t testing.TB, q []byte, rcode dns.RCode, answers, authorities []dns.Resource) []byte {
+	t.Helper()
+	var msg dns.Message
+	if err := msg.Unpack(q); err != nil {
+		t.Fatal(err)
+	}
+	msg.Response = true
+	msg.RecursionAvailable = true
+	msg.RCode = rcode
+	msg.Answers = answers
+	msg.Authorities = authorities
+	msg.Additionals = nil
+	res, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func aRecord(name string, ttl uint32, ip string) dns.Resource {
+	return dns.Resource{
+		Header: dns.ResourceHeader{Name: dns.MustNewName(name), Type: dns.TypeA, Class: dns.ClassINET, TTL: ttl},
+		Body:   &dns.AResource{A: netip.MustParseAddr(ip).As4()},
+	}
+}
+
+func soaRecord(zone string, ttl, minTTL uint32) dns.Resource {
+	return dns.Resource{
+		Header: dns.ResourceHeader{Name: dns.MustNewName(zone), Type: dns.TypeSOA, Class: dns.ClassINET, TTL: ttl},
+		Body: &dns.SOAResource{
+			NS:     dns.MustNewName("ns." + zone),
+			MBox:   dns.MustNewName("hostmaster." + zone),
+			Serial: 1,
+			MinTTL: minTTL,
+		},
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	now := time.Now()
+	c := newResponseCache()
+
+	q := makeCacheTestQuery(t, 1, "foo.example.com.", dns.TypeA, false)
+	if _, ok := c.get(q, "", now); ok {
+		t.Fatal("unexpected hit in empty cache")
+	}
+	c.add(q, makeCacheTestResponse(t, q, dns.RCodeSuccess, []dns.Resource{
+		aRecord("foo.example.com.", 300, "1.2.3.4"),
+		aRecord("foo.example.com.", 60, "1.2.3.5"),
+	}, nil), "", now)
+
+	// A later query with a different ID and case gets the cached response
+	// with its own ID and question, and reduced TTLs.
+	q2 := makeCacheTestQuery(t, 2, "FOO.example.com.", dns.TypeA, false)
+	res, ok := c.get(q2, "", now.Add(10*time.Second))
+	if !ok {
+		t.Fatal("cache miss")
+	}
+	var msg dns.Message
+	if err := msg.Unpack(res); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != 2 {
+		t.Errorf("ID = %d; want 2", msg.ID)
+	}
+	if got := msg.Questions[0].Name.String(); got != "FOO.example.com." {
+		t.Errorf("question name = %q; want FOO.example.com.", got)
+	}
+	if len(msg.Answers) != 2 || msg.Answers[0].Header.TTL != 290 || msg.Answers[1].Header.TTL != 50 {
+		t.Errorf("answers = %+v; want TTLs 290 and 50", msg.Answers)
+	}
+
+	// Different upstreams, types and DO bits are cached separately.
+	for _, q := range [][]byte{
+		makeCacheTestQuery(t, 3, "foo.example.com.", dns.TypeAAAA, false),
+		makeCacheTestQuery(t, 3, "foo.example.com.", dns.TypeA, true),
+	} {
+		if _, ok := c.get(q, "", now); ok {
+			t.Errorf("unexpected hit for %x", q)
+		}
+	}
+	if _, ok := c.get(q, "1.1.1.1:53", now); ok {
+		t.Error("unexpected hit for other upstream")
+	}
+
+	// The entry expires with its lowest TTL.
+	if _, ok := c.get(q, "", now.Add(60*time.Second)); ok {
+		t.Error("unexpected hit after TTL expired")
+	}
+	if ents := c.entries(now); len(ents) != 0 {
+		t.Errorf("entries after expiry = %+v; want none", ents)
+	}
+}
+
+func TestResponseCacheEDNS(t *testing.T) {
+	now := time.Now()
+	c := newResponseCache()
+
+	// An EDNS query without the DO bit.
+	b := dns.NewBuilder(nil, dns.Header{ID: 1, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dns.Question{Name: dns.MustNewName("foo.example.com."), Type: dns.TypeA, Class: dns.ClassINET})
+	b.StartAdditionals()
+	var rh dns.ResourceHeader
+	if err := rh.SetEDNS0(1232, dns.RCodeSuccess, false); err != nil {
+		t.Fatal(err)
+	}
+	b.OPTResource(rh, dns.OPTResource{})
+	q, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.add(q, makeCacheTestResponse(t, q, dns.RCodeSuccess, []dns.Resource{
+		aRecord("foo.example.com.", 300, "1.2.3.4"),
+	}, nil), "", now)
+	if _, ok := c.get(q, "", now); !ok {
+		t.Fatal("cache miss for EDNS query")
+	}
+
+	// Its response, which may be too big for a non-EDNS client, isn't
+	// given to queries without EDNS.
+	if _, ok := c.get(makeCacheTestQuery(t, 2, "foo.example.com.", dns.TypeA, false), "", now); ok {
+		t.Error("unexpected hit for query without EDNS")
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	const name = "foo.example.com."
+	q := makeCacheTestQuery(t, 1, name, dns.TypeA, false)
+	tests := []struct {
+		name         string
+		res          []byte
+		wantTTL      time.Duration
+		wantNegative bool
+	}{
+		{
+			name:    "positive",
+			res:     makeCacheTestResponse(t, q, dns.RCodeSuccess, []dns.Resource{aRecord(name, 30, "1.2.3.4")}, nil),
+			wantTTL: 30 * time.Second,
+		},
+		{
+			name:    "positive-capped",
+			res:     makeCacheTestResponse(t, q, dns.RCodeSuccess, []dns.Resource{aRecord(name, 86400, "1.2.3.4")}, nil),
+			wantTTL: cacheMaxTTL,
+		},
+		{
+			name: "zero-ttl",
+			res:  makeCacheTestResponse(t, q, dns.RCodeSuccess, []dns.Resource{aRecord(name, 0, "1.2.3.4")}, nil),
+		},
+		{
+			name:         "nxdomain",
+			res:          makeCacheTestResponse(t, q, dns.RCodeNameError, nil, []dns.Resource{soaRecord("example.com.", 120, 60)}),
+			wantTTL:      60 * time.Second,
+			wantNegative: true,
+		},
+		{
+			name:         "nodata",
+			res:          makeCacheTestResponse(t, q, dns.RCodeSuccess, nil, []dns.Resource{soaRecord("example.com.", 30, 3600)}),
+			wantTTL:      30 * time.Second,
+			wantNegative: true,
+		},
+		{
+			name:         "nxdomain-capped",
+			res:          makeCacheTestResponse(t, q, dns.RCodeNameError, nil, []dns.Resource{soaRecord("example.com.", 86400, 86400)}),
+			wantTTL:      cacheMaxNegativeTTL,
+			wantNegative: true,
+		},
+		{
+			// RFC 2308 section 5: no SOA, no negative caching.
+			name: "nxdomain-no-soa",
+			res:  makeCacheTestResponse(t, q, dns.RCodeNameError, nil, nil),
+		},
+		{
+			name: "servfail",
+			res:  makeCacheTestResponse(t, q, dns.RCodeServerFailure, nil, []dns.Resource{soaRecord("example.com.", 120, 60)}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg dns.Message
+			if err := msg.Unpack(tt.res); err != nil {
+				t.Fatal(err)
+			}
+			ttl, negative := cacheTTL(&msg)
+			if ttl != tt.wantTTL || negative != tt.wantNegative {
+				t.Errorf("cacheTTL = %v, %v; want %v, %v", ttl, negative, tt.wantTTL, tt.wantNegative)
+			}
+
+			now := time.Now()
+			c := newResponseCache()
+			c.add(q, tt.res, "", now)
+			_, ok := c.get(q, "", now)
+			if want := tt.wantTTL > 0; ok != want {
+				t.Errorf("cached = %v; want %v", ok, want)
+			}
+		})
+	}
+}
+
+func TestResponseCacheTruncated(t *testing.T) {
+	q := makeCacheTestQuery(t, 1, "foo.example.com.", dns.TypeA, false)
+	res := makeCacheTestResponse(t, q, dns.RCodeSuccess, []dns.Resource{aRecord("foo.example.com.", 300, "1.2.3.4")}, nil)
+	setTCFlag(res)
+
+	now := time.Now()
+	c := newResponseCache()
+	c.add(q, res, "", now)
+	if _, ok := c.get(q, "", now); ok {
+		t.Error("truncated response was cached")
+	}
+}
+
+func TestResolverCacheFlush(t *testing.T) {
+	r := newResolver(t)
+	defer r.Close()
+
+	q := makeCacheTestQuery(t, 1, "foo.example.com.", dns.TypeA, false)
+	res := makeCacheTestResponse(t, q, dns.RCodeSuccess, []dns.Resource{aRecord("foo.example.com.", 300, "1.2.3.4")}, nil)
+	cached := func() bool {
+		r.cache.add(q, res, "", time.Now())
+		_, ok := r.cache.get(q, "", time.Now())
+		return ok
+	}
+
+	routes := map[dnsname.FQDN][]*dnstype.Resolver{
+		".": {{Addr: "1.1.1.1"}},
+	}
+	r.SetConfig(Config{Routes: routes})
+	if !cached() {
+		t.Fatal("response not cached")
+	}
+	if got := len(r.CacheEntries()); got != 1 {
+		t.Errorf("got %d cache entries; want 1", got)
+	}
+
+	// Setting an equivalent config keeps the cache.
+	r.SetConfig(Config{Routes: map[dnsname.FQDN][]*dnstype.Resolver{
+		".": {{Addr: "1.1.1.1"}},
+	}})
+	if _, ok := r.cache.get(q, "", time.Now()); !ok {
+		t.Error("cache flushed by unchanged config")
+	}
+
+	// Changing the routes flushes it.
+	r.SetConfig(Config{Routes: map[dnsname.FQDN][]*dnstype.Resolver{
+		".": {{Addr: "8.8.8.8"}},
+	}})
+	if _, ok := r.cache.get(q, "", time.Now()); ok {
+		t.Error("cache not flushed by routes change")
+	}
+
+	if !cached() {
+		t.Fatal("response not cached")
+	}
+	r.FlushCache()
+	if _, ok := r.cache.get(q, "", time.Now()); ok {
+		t.Error("cache not flushed by FlushCache")
+	}
+}
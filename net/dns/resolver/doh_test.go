@@ -4,13 +4,21 @@
 package resolver
 
 import (
+	"bytes"
 	"context"
 	"flag"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
 	"testing"
 
 	"golang.org/x/net/dns/dnsmessage"
 	"tailscale.com/net/dns/publicdns"
+	"tailscale.com/types/dnstype"
 )
 
 var testDoH = flag.Bool("test-doh", false, "do real DoH tests against the network")
@@ -97,3 +105,84 @@ func TestDoHV6Fallback(t *testing.T) {
 		}
 	}
 }
+
+func TestArbitraryDoH(t *testing.T) {
+	const domain = "doh-test.example.com."
+	request := makeTestRequest(t, domain, dnsmessage.TypeA, 0)
+	response := makeTestResponse(t, domain, dnsmessage.RCodeSuccess, netip.MustParseAddr("127.0.0.1"))
+
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != dohType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !bytes.Equal(body, request) {
+			t.Errorf("got request %x; want %x", body, request)
+		}
+		w.Header().Set("Content-Type", dohType)
+		w.Write(response)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+
+	tests := []struct {
+		name     string
+		resolver *dnstype.Resolver
+	}{
+		{
+			name:     "ip",
+			resolver: &dnstype.Resolver{Addr: srv.URL + "/dns-query"},
+		},
+		{
+			// The httptest server's certificate is valid for example.com.
+			name: "bootstrap",
+			resolver: &dnstype.Resolver{
+				Addr:                fmt.Sprintf("https://example.com:%d/dns-query", port),
+				BootstrapResolution: []netip.Addr{netip.MustParseAddr("127.0.0.1")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conns.Store(0)
+			fwd := newTestForwarder(t)
+			fwd.tlsConfigForTest = srv.Client().Transport.(*http.Transport).TLSClientConfig
+
+			for range 3 {
+				res, err := runTestQueryWithForwarder(t, fwd, request, "udp", tt.resolver)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(res, response) {
+					t.Fatalf("got response %x; want %x", res, response)
+				}
+			}
+			if got := conns.Load(); got != 1 {
+				t.Errorf("server accepted %d connections; want 1", got)
+			}
+		})
+	}
+
+	// A resolver with an untrusted certificate is reported as failing.
+	fwd := newTestForwarder(t)
+	r := &dnstype.Resolver{Addr: srv.URL + "/dns-query"}
+	if _, err := runTestQueryWithForwarder(t, fwd, request, "udp", r); err == nil {
+		t.Fatal("query with untrusted certificate unexpectedly succeeded")
+	}
+	if !fwd.health.IsUnhealthy(dnsEncryptedResolverFailing) {
+		t.Errorf("%s not raised after failed query", dnsEncryptedResolverFailing.Code)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/net/netx"
+	"tailscale.com/net/sockstats"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/mak"
+)
+
+const (
+	// dotDefaultPort is the DNS-over-TLS port from RFC 7858.
+	dotDefaultPort = "853"
+
+	// dotMaxIdleConns is the maximum number of idle connections to keep
+	// open to each DNS-over-TLS server. Idle connections are closed after
+	// dohIdleConnTimeout, for the same reasons as with DoH.
+	dotMaxIdleConns = 2
+)
+
+// parseDoTAddr parses a "tls://host[:port]" DoT resolver address, returning
+// the host:port to dial and the host to verify the server certificate for.
+func parseDoTAddr(addr string) (hostPort, host string, err error) {
+	s, ok := strings.CutPrefix(addr, "tls://")
+	if !ok {
+		return "", "", fmt.Errorf("invalid DoT resolver address %q", addr)
+	}
+	s = strings.TrimSuffix(s, "/")
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		// No port. Strip the brackets from a bracketed IPv6 address.
+		host = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+		port = dotDefaultPort
+	}
+	if host == "" || strings.ContainsAny(host, "/?#@") {
+		return "", "", fmt.Errorf("invalid DoT resolver address %q", addr)
+	}
+	return net.JoinHostPort(host, port), host, nil
+}
+
+// getDoTClient returns the client for the DoT resolver r, creating it if
+// needed.
+func (f *forwarder) getDoTClient(r *dnstype.Resolver) (*dotClient, error) {
+	hostPort, host, err := parseDoTAddr(r.Addr)
+	if err != nil {
+		return nil, err
+	}
+	key := encryptedResolverKey(r)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.dotClient[key]; ok {
+		return c, nil
+	}
+	tlsConfig := f.encryptedResolverTLSConfig(host)
+	tlsConfig.ServerName = host
+	c := &dotClient{
+		hostPort:  hostPort,
+		dial:      f.encryptedResolverDialer(host, r.BootstrapResolution),
+		tlsConfig: tlsConfig,
+	}
+	mak.Set(&f.dotClient, key, c)
+	return c, nil
+}
+
+// sendDoT sends the query fq to the DoT resolver c and returns its response.
+func (f *forwarder) sendDoT(ctx context.Context, c *dotClient, fq *forwardQuery) ([]byte, error) {
+	ctx = sockstats.WithSockStats(ctx, sockstats.LabelDNSForwarderTCP, f.logf)
+	metricDNSFwdDoT.Add(1)
+
+	ctx, cancel := context.WithTimeout(ctx, tcpQueryTimeout)
+	defer cancel()
+
+	res, err := c.exchange(ctx, fq.packet)
+	if err != nil {
+		metricDNSFwdDoTErrorTransport.Add(1)
+		return nil, err
+	}
+	if getTxID(res) != fq.txid {
+		metricDNSFwdDoTErrorTxID.Add(1)
+		return nil, errTxIDMismatch
+	}
+	// don't forward transient errors back to the client when the server fails
+	if getRCode(res) == dns.RCodeServerFailure {
+		metricDNSFwdDoTErrorServer.Add(1)
+		return nil, errServerFailure
+	}
+	if truncatedFlagSet(res) {
+		metricDNSFwdTruncated.Add(1)
+	}
+	metricDNSFwdDoTSuccess.Add(1)
+	return res, nil
+}
+
+// dotClient sends DNS-over-TLS (RFC 7858) queries to a single server. It
+// keeps a small pool of idle connections so that queries in quick succession
+// don't each need a new TCP connection and TLS handshake.
+type dotClient struct {
+	hostPort  string        // to dial
+	dial      netx.DialFunc // dials TCP
+	tlsConfig *tls.Config   // with ServerName set
+
+	mu   sync.Mutex
+	idle []*dotIdleConn // most recently used last
+}
+
+// dotIdleConn is an idle connection in a dotClient's pool.
+type dotIdleConn struct {
+	conn  *tls.Conn
+	timer *time.Timer // closes conn after dohIdleConnTimeout
+}
+
+// exchange sends the DNS query packet and returns the response. If a reused
+// connection fails, perhaps because the server closed it while it was idle,
+// the query is retried on another connection.
+func (c *dotClient) exchange(ctx context.Context, packet []byte) ([]byte, error) {
+	for {
+		conn, reused, err := c.getConn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		res, err := exchangeTCPConn(ctx, conn, packet)
+		if err != nil {
+			conn.Close()
+			if reused && ctx.Err() == nil {
+				continue
+			}
+			return nil, err
+		}
+		c.putConn(conn)
+		return res, nil
+	}
+}
+
+// getConn returns an idle connection from the pool if there is one, or else
+// dials a new one.
+func (c *dotClient) getConn(ctx context.Context) (_ *tls.Conn, reused bool, _ error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		ic := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		ic.timer.Stop()
+		return ic.conn, true, nil
+	}
+	c.mu.Unlock()
+
+	nc, err := c.dial(ctx, "tcp", c.hostPort)
+	if err != nil {
+		return nil, false, err
+	}
+	conn := tls.Client(nc, c.tlsConfig)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		nc.Close()
+		return nil, false, err
+	}
+	return conn, false, nil
+}
+
+// putConn returns conn to the pool of idle connections, or closes it if the
+// pool is full.
+func (c *dotClient) putConn(conn *tls.Conn) {
+	c.mu.Lock()
+	if len(c.idle) >= dotMaxIdleConns {
+		c.mu.Unlock()
+		conn.Close()
+		return
+	}
+	ic := &dotIdleConn{conn: conn}
+	ic.timer = time.AfterFunc(dohIdleConnTimeout, func() {
+		c.mu.Lock()
+		i := slices.Index(c.idle, ic)
+		if i >= 0 {
+			c.idle = slices.Delete(c.idle, i, i+1)
+		}
+		c.mu.Unlock()
+		if i >= 0 {
+			conn.Close()
+		}
+	})
+	c.idle = append(c.idle, ic)
+	c.mu.Unlock()
+}
+
+// closeIdleConns closes all idle connections in the pool.
+func (c *dotClient) closeIdleConns() {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.mu.Unlock()
+	for _, ic := range idle {
+		ic.timer.Stop()
+		ic.conn.Close()
+	}
+}
+
+// exchangeTCPConn writes the DNS query packet to conn using the two byte
+// length prefix framing of DNS over TCP (RFC 1035 section 4.2.2), and reads
+// back a single response. Any deadline that ctx has applies to the
+// exchange, and conn is unusable if ctx is done before the exchange is.
+func exchangeTCPConn(ctx context.Context, conn net.Conn, packet []byte) ([]byte, error) {
+	if len(packet) > 0xffff {
+		return nil, errors.New("DNS query too large")
+	}
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		// Unblock any pending Read or Write.
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	ctxOrErr := func(err2 error) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, err2
+	}
+
+	query := make([]byte, len(packet)+2)
+	binary.BigEndian.PutUint16(query, uint16(len(packet)))
+	copy(query[2:], packet)
+	if _, err := conn.Write(query); err != nil {
+		return ctxOrErr(err)
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return ctxOrErr(err)
+	}
+	res := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, res); err != nil {
+		return ctxOrErr(err)
+	}
+	if !stop() {
+		// ctx was done and conn's deadline is now in the past.
+		return nil, ctx.Err()
+	}
+	return res, nil
+}
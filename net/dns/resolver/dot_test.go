@@ -0,0 +1,250 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+)
+
+func TestParseDoTAddr(t *testing.T) {
+	tests := []struct {
+		addr         string
+		wantHostPort string
+		wantHost     string
+		wantErr      bool
+	}{
+		{addr: "tls://dns.example.com", wantHostPort: "dns.example.com:853", wantHost: "dns.example.com"},
+		{addr: "tls://dns.example.com/", wantHostPort: "dns.example.com:853", wantHost: "dns.example.com"},
+		{addr: "tls://dns.example.com:8853", wantHostPort: "dns.example.com:8853", wantHost: "dns.example.com"},
+		{addr: "tls://1.2.3.4", wantHostPort: "1.2.3.4:853", wantHost: "1.2.3.4"},
+		{addr: "tls://[2001:db8::1]", wantHostPort: "[2001:db8::1]:853", wantHost: "2001:db8::1"},
+		{addr: "tls://[2001:db8::1]:8853", wantHostPort: "[2001:db8::1]:8853", wantHost: "2001:db8::1"},
+		{addr: "tls://", wantErr: true},
+		{addr: "tls://dns.example.com/path", wantErr: true},
+		{addr: "https://dns.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			hostPort, host, err := parseDoTAddr(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; wantErr %v", err, tt.wantErr)
+			}
+			if hostPort != tt.wantHostPort || host != tt.wantHost {
+				t.Errorf("got (%q, %q); want (%q, %q)", hostPort, host, tt.wantHostPort, tt.wantHost)
+			}
+		})
+	}
+}
+
+// newTestCert returns a self-signed server certificate for 127.0.0.1 and
+// dot.example.com, and a client TLS config that trusts it.
+func newTestCert(t testing.TB) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dot.example.com"},
+		DNSNames:              []string{"dot.example.com"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	return server, &tls.Config{RootCAs: roots}
+}
+
+// runDoTServer runs a DNS-over-TLS server on localhost that answers every
+// query with response, with the query's DNS ID. If oneShot is set, the server
+// closes connections after answering one query. It returns the server's
+// address and a counter of accepted connections.
+func runDoTServer(t testing.TB, tlsConfig *tls.Config, response []byte, oneShot bool) (netip.AddrPort, *atomic.Int32) {
+	ln, err := tls.Listen("tcp4", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var conns atomic.Int32
+	handleConn := func(conn net.Conn) {
+		defer conn.Close()
+		for {
+			var length uint16
+			if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+				return
+			}
+			query := make([]byte, length)
+			if _, err := io.ReadFull(conn, query); err != nil {
+				return
+			}
+			res := bytes.Clone(response)
+			copy(res[:2], query[:2]) // DNS ID
+			out := binary.BigEndian.AppendUint16(nil, uint16(len(res)))
+			if _, err := conn.Write(append(out, res...)); err != nil {
+				return
+			}
+			if oneShot {
+				return
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go handleConn(conn)
+		}
+	}()
+	return netip.MustParseAddrPort(ln.Addr().String()), &conns
+}
+
+func TestDoT(t *testing.T) {
+	const domain = "dot-test.example.com."
+	request := makeTestRequest(t, domain, dns.TypeA, 0)
+	response := makeTestResponse(t, domain, dns.RCodeSuccess, netip.MustParseAddr("127.0.0.1"))
+	serverConfig, clientConfig := newTestCert(t)
+
+	tests := []struct {
+		name      string
+		oneShot   bool
+		wantConns int32
+		resolver  func(netip.AddrPort) *dnstype.Resolver
+	}{
+		{
+			name:      "ip",
+			wantConns: 1,
+			resolver: func(ap netip.AddrPort) *dnstype.Resolver {
+				return &dnstype.Resolver{Addr: "tls://" + ap.String()}
+			},
+		},
+		{
+			name:      "bootstrap",
+			wantConns: 1,
+			resolver: func(ap netip.AddrPort) *dnstype.Resolver {
+				return &dnstype.Resolver{
+					Addr:                fmt.Sprintf("tls://dot.example.com:%d", ap.Port()),
+					BootstrapResolution: []netip.Addr{ap.Addr()},
+				}
+			},
+		},
+		{
+			// The server closing idle connections shouldn't fail queries.
+			name:      "server-closes",
+			oneShot:   true,
+			wantConns: 3,
+			resolver: func(ap netip.AddrPort) *dnstype.Resolver {
+				return &dnstype.Resolver{Addr: "tls://" + ap.String()}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ap, conns := runDoTServer(t, serverConfig, response, tt.oneShot)
+			fwd := newTestForwarder(t)
+			fwd.tlsConfigForTest = clientConfig
+			r := tt.resolver(ap)
+
+			for range 3 {
+				res, err := runTestQueryWithForwarder(t, fwd, request, "udp", r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(res, response) {
+					t.Fatalf("got response %x; want %x", res, response)
+				}
+			}
+			if got := conns.Load(); got != tt.wantConns {
+				t.Errorf("server accepted %d connections; want %d", got, tt.wantConns)
+			}
+			if fwd.health.IsUnhealthy(dnsEncryptedResolverFailing) {
+				t.Errorf("%s unexpectedly unhealthy", dnsEncryptedResolverFailing.Code)
+			}
+		})
+	}
+}
+
+func TestDoTHealth(t *testing.T) {
+	const domain = "dot-test.example.com."
+	request := makeTestRequest(t, domain, dns.TypeA, 0)
+	response := makeTestResponse(t, domain, dns.RCodeSuccess)
+	serverConfig, _ := newTestCert(t)
+	ap, _ := runDoTServer(t, serverConfig, response, false)
+
+	fwd := newTestForwarder(t)
+	// Don't trust the server's certificate.
+	fwd.tlsConfigForTest = &tls.Config{RootCAs: x509.NewCertPool()}
+	r := &dnstype.Resolver{Addr: "tls://" + ap.String()}
+
+	if _, err := runTestQueryWithForwarder(t, fwd, request, "udp", r); err == nil {
+		t.Fatal("query unexpectedly succeeded")
+	}
+	if !fwd.health.IsUnhealthy(dnsEncryptedResolverFailing) {
+		t.Fatalf("%s not raised after failed query", dnsEncryptedResolverFailing.Code)
+	}
+
+	// Removing the resolver from the config clears the warning.
+	fwd.setRoutes(map[dnsname.FQDN][]*dnstype.Resolver{}, true)
+	if fwd.health.IsUnhealthy(dnsEncryptedResolverFailing) {
+		t.Fatalf("%s still raised after resolver removed", dnsEncryptedResolverFailing.Code)
+	}
+}
+
+func TestEncryptedResolverNeedsBootstrap(t *testing.T) {
+	const domain = "dot-test.example.com."
+	request := makeTestRequest(t, domain, dns.TypeA, 0)
+	fwd := newTestForwarder(t)
+
+	// Resolvers named by hostname without a bootstrap resolution aren't
+	// looked up, as the lookup could loop back through MagicDNS.
+	for _, addr := range []string{"tls://dot.example.com", "https://doh.example.com/dns-query"} {
+		_, err := runTestQueryWithForwarder(t, fwd, request, "udp", &dnstype.Resolver{Addr: addr})
+		if !errors.Is(err, errNoBootstrapResolution) {
+			t.Errorf("%s: got error %v; want %v", addr, err, errNoBootstrapResolution)
+		}
+	}
+	if !fwd.health.IsUnhealthy(dnsEncryptedResolverFailing) {
+		t.Errorf("%s not raised", dnsEncryptedResolverFailing.Code)
+	}
+}
@@ -13,11 +13,13 @@
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/http"
 	"net/netip"
 	"net/url"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -36,6 +38,7 @@
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netx"
 	"tailscale.com/net/sockstats"
+	"tailscale.com/net/tlsdial"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/syncs"
 	"tailscale.com/types/dnstype"
@@ -43,6 +46,7 @@
 	"tailscale.com/types/nettype"
 	"tailscale.com/util/cloudenv"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/mak"
 	"tailscale.com/util/race"
 	"tailscale.com/version"
 )
@@ -274,6 +278,20 @@ func checkResponseSizeAndSetTC(response []byte, request []byte, family string, l
 	TimeToVisible:       15 * time.Second,
 })
 
+// dnsEncryptedResolverFailing is raised when DNS-over-HTTPS or DNS-over-TLS
+// resolvers can't be reached, including when their TLS certificates fail
+// verification. It's cleared once they answer queries again.
+var dnsEncryptedResolverFailing = health.Register(&health.Warnable{
+	Code:      "dns-encrypted-resolver-failing",
+	Title:     "Encrypted DNS server unavailable",
+	Severity:  health.SeverityMedium,
+	DependsOn: []*health.Warnable{health.NetworkStatusWarnable},
+	Text: func(args health.Args) string {
+		return fmt.Sprintf("Tailscale can't reach the encrypted DNS servers %s. DNS queries sent to them will fail.", args[health.ArgDNSServers])
+	},
+	TimeToVisible: 15 * time.Second,
+})
+
 type route struct {
 	Suffix    dnsname.FQDN
 	Resolvers []resolverAndDelay
@@ -303,12 +321,21 @@ type forwarder struct {
 
 	controlKnobs *controlknobs.Knobs // or nil
 
+	// tlsConfigForTest, if non-nil, is used instead of tlsdial's config for
+	// DoH and DoT resolvers that aren't well-known DoH providers.
+	tlsConfigForTest *tls.Config
+
 	ctx       context.Context    // good until Close
 	ctxCancel context.CancelFunc // closes ctx
 
 	mu syncs.Mutex // guards following
 
-	dohClient map[string]*http.Client // urlBase -> client
+	dohClient map[string]*http.Client // encryptedResolverKey -> client
+	dotClient map[string]*dotClient   // encryptedResolverKey -> client
+
+	// failingEncryptedResolvers is the set of DoH and DoT resolver
+	// addresses whose last query failed. See noteEncryptedResolverResult.
+	failingEncryptedResolvers map[string]bool
 
 	// routes are per-suffix resolvers to use, with
 	// the most specific routes first.
@@ -353,6 +380,14 @@ func newForwarder(logf logger.Logf, netMon *netmon.Monitor, linkSel ForwardLinkS
 
 func (f *forwarder) Close() error {
 	f.ctxCancel()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.dohClient {
+		c.CloseIdleConnections()
+	}
+	for _, c := range f.dotClient {
+		c.closeIdleConns()
+	}
 	return nil
 }
 
@@ -472,11 +507,44 @@ func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolve
 		return routes[i].Suffix.NumLabels() > routes[j].Suffix.NumLabels()
 	})
 
+	// Forget about DoH and DoT resolvers that are no longer in use.
+	inUse := map[string]bool{} // by encryptedResolverKey and by Addr
+	for _, r := range routes {
+		for _, rr := range r.Resolvers {
+			inUse[rr.name.Addr] = true
+			inUse[encryptedResolverKey(rr.name)] = true
+		}
+	}
+
 	f.mu.Lock()
-	defer f.mu.Unlock()
 	f.acceptDNS = acceptDNS
 	f.routes = routes
 	f.cloudHostFallback = cloudHostFallback
+	for k, c := range f.dohClient {
+		if !inUse[k] {
+			c.CloseIdleConnections()
+			delete(f.dohClient, k)
+		}
+	}
+	for k, c := range f.dotClient {
+		if !inUse[k] {
+			c.closeIdleConns()
+			delete(f.dotClient, k)
+		}
+	}
+	var failingChanged bool
+	for addr := range f.failingEncryptedResolvers {
+		if !inUse[addr] {
+			delete(f.failingEncryptedResolvers, addr)
+			failingChanged = true
+		}
+	}
+	failingAddrs := slices.Sorted(maps.Keys(f.failingEncryptedResolvers))
+	f.mu.Unlock()
+
+	if failingChanged {
+		f.updateEncryptedResolverHealth(failingAddrs)
+	}
 }
 
 var stdNetPacketListener nettype.PacketListenerWithNetIP = nettype.MakePacketListenerWithNetIP(new(net.ListenConfig))
@@ -550,6 +618,147 @@ func (f *forwarder) getKnownDoHClientForProvider(urlBase string) (c *http.Client
 	return c, true
 }
 
+// getDoHClient returns an HTTP client for the DoH resolver r, which may be a
+// well-known provider (see getKnownDoHClientForProvider) or any other
+// https:// URL. Clients are cached so that connections are reused between
+// queries.
+func (f *forwarder) getDoHClient(r *dnstype.Resolver) (*http.Client, error) {
+	if c, ok := f.getKnownDoHClientForProvider(r.Addr); ok {
+		return c, nil
+	}
+	dohURL, err := url.Parse(r.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if dohURL.Hostname() == "" {
+		return nil, fmt.Errorf("invalid DoH resolver URL %q", r.Addr)
+	}
+
+	key := encryptedResolverKey(r)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.dohClient[key]; ok {
+		return c, nil
+	}
+	dialer := f.encryptedResolverDialer(dohURL.Hostname(), r.BootstrapResolution)
+	c := &http.Client{
+		Transport: &http.Transport{
+			ForceAttemptHTTP2:     true,
+			IdleConnTimeout:       dohIdleConnTimeout,
+			ResponseHeaderTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost:   1,
+			DialContext: func(ctx context.Context, netw, addr string) (net.Conn, error) {
+				if !strings.HasPrefix(netw, "tcp") {
+					return nil, fmt.Errorf("unexpected network %q", netw)
+				}
+				return dialer(ctx, netw, addr)
+			},
+			TLSClientConfig: f.encryptedResolverTLSConfig(dohURL.Hostname()),
+		},
+	}
+	mak.Set(&f.dohClient, key, c)
+	return c, nil
+}
+
+// encryptedResolverKey returns the key for r in the forwarder's dohClient
+// and dotClient maps. For well-known DoH providers, it's the DoH base URL.
+func encryptedResolverKey(r *dnstype.Resolver) string {
+	if len(r.BootstrapResolution) == 0 {
+		return r.Addr
+	}
+	return fmt.Sprintf("%s %v", r.Addr, r.BootstrapResolution)
+}
+
+// errNoBootstrapResolution is returned when dialing a DoH or DoT resolver
+// that's named by a hostname but has no BootstrapResolution.
+var errNoBootstrapResolution = errors.New("resolver hostname has no bootstrap resolution")
+
+// encryptedResolverDialer returns a dial func for a DoH or DoT resolver at
+// host. If bootstrap is non-empty, those addresses are dialed for host.
+// Otherwise, host must be an IP address: looking it up with the system
+// resolver would go through MagicDNS, and so back to this resolver, whenever
+// Tailscale manages the system's DNS configuration.
+func (f *forwarder) encryptedResolverDialer(host string, bootstrap []netip.Addr) netx.DialFunc {
+	if _, err := netip.ParseAddr(host); err != nil && len(bootstrap) == 0 {
+		return func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, fmt.Errorf("dialing %q: %w", host, errNoBootstrapResolution)
+		}
+	}
+	res := &dnscache.Resolver{
+		UseLastGood: true,
+		Logf:        f.logf,
+	}
+	if len(bootstrap) > 0 {
+		res.SingleHost = host
+		res.SingleHostStaticResult = bootstrap
+	}
+	return dnscache.Dialer(f.getDialerType(), res)
+}
+
+// encryptedResolverTLSConfig returns the TLS config to use for a DoH or DoT
+// resolver at host, other than the well-known DoH providers. Server
+// certificates are verified by tlsdial, which reports certificate errors to
+// f.health.
+func (f *forwarder) encryptedResolverTLSConfig(host string) *tls.Config {
+	if f.tlsConfigForTest != nil {
+		return f.tlsConfigForTest.Clone()
+	}
+	conf := tlsdial.Config(f.health, &tls.Config{MinVersion: tls.VersionTLS12})
+	if ip, err := netip.ParseAddr(host); err == nil {
+		// crypto/tls doesn't send SNI for IP addresses, which leaves the
+		// ConnectionState.ServerName that tlsdial verifies the
+		// certificate for empty. Check the certificate's IP SANs too.
+		verify := conf.VerifyConnection
+		conf.VerifyConnection = func(cs tls.ConnectionState) error {
+			if err := verify(cs); err != nil {
+				return err
+			}
+			return cs.PeerCertificates[0].VerifyHostname(ip.String())
+		}
+	}
+	return conf
+}
+
+// noteEncryptedResolverResult records the result err of a query to the DoH
+// or DoT resolver addr and updates the dnsEncryptedResolverFailing health
+// warning accordingly. DNS server failures and canceled queries (say, because
+// another resolver answered first) don't affect the resolver's health.
+func (f *forwarder) noteEncryptedResolverResult(addr string, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	failing := err != nil && !errors.Is(err, errServerFailure)
+
+	f.mu.Lock()
+	if f.failingEncryptedResolvers[addr] == failing {
+		f.mu.Unlock()
+		return
+	}
+	if failing {
+		f.logf("encrypted DNS resolver %q failing: %v", addr, err)
+		mak.Set(&f.failingEncryptedResolvers, addr, true)
+	} else {
+		delete(f.failingEncryptedResolvers, addr)
+	}
+	failingAddrs := slices.Sorted(maps.Keys(f.failingEncryptedResolvers))
+	f.mu.Unlock()
+
+	f.updateEncryptedResolverHealth(failingAddrs)
+}
+
+func (f *forwarder) updateEncryptedResolverHealth(failingAddrs []string) {
+	if f.health == nil {
+		return
+	}
+	if len(failingAddrs) == 0 {
+		f.health.SetHealthy(dnsEncryptedResolverFailing)
+		return
+	}
+	f.health.SetUnhealthy(dnsEncryptedResolverFailing, health.Args{
+		health.ArgDNSServers: strings.Join(failingAddrs, ", "),
+	})
+}
+
 const dohType = "application/dns-message"
 
 func (f *forwarder) sendDoH(ctx context.Context, urlBase string, c *http.Client, packet []byte) ([]byte, error) {
@@ -625,28 +834,38 @@ func (f *forwarder) send(ctx context.Context, fq *forwardQuery, rr resolverAndDe
 		return res, nil
 	}
 	if strings.HasPrefix(rr.name.Addr, "https://") {
-		// Only known DoH providers are supported currently. Specifically, we
-		// only support DoH providers where we can TCP connect to them on port
-		// 443 at the same IP address they serve normal UDP DNS from (1.1.1.1,
-		// 8.8.8.8, 9.9.9.9, etc.) That's why OpenDNS and custom DoH providers
-		// aren't currently supported. There's no backup DNS resolution path for
-		// them.
-		urlBase := rr.name.Addr
-		if hc, ok := f.getKnownDoHClientForProvider(urlBase); ok {
-			res, err := f.sendDoH(ctx, urlBase, hc, fq.packet)
-			if err != nil {
-				return nil, err
-			}
-			// Check response size and set TC flag if needed (only for UDP queries)
-			res = checkResponseSizeAndSetTC(res, fq.packet, fq.family, f.logf)
-			return res, nil
+		// Well-known DoH providers are dialed at the same IP addresses they
+		// serve normal UDP DNS from (1.1.1.1, 8.8.8.8, 9.9.9.9, etc.), which
+		// are statically known. Other DoH servers are dialed at their
+		// BootstrapResolution addresses, or at the IP address in their
+		// URL.
+		hc, err := f.getDoHClient(rr.name)
+		if err != nil {
+			metricDNSFwdErrorType.Add(1)
+			return nil, err
 		}
-		metricDNSFwdErrorType.Add(1)
-		return nil, fmt.Errorf("arbitrary https:// resolvers not supported yet")
+		res, err := f.sendDoH(ctx, rr.name.Addr, hc, fq.packet)
+		f.noteEncryptedResolverResult(rr.name.Addr, err)
+		if err != nil {
+			return nil, err
+		}
+		// Check response size and set TC flag if needed (only for UDP queries)
+		res = checkResponseSizeAndSetTC(res, fq.packet, fq.family, f.logf)
+		return res, nil
 	}
 	if strings.HasPrefix(rr.name.Addr, "tls://") {
-		metricDNSFwdErrorType.Add(1)
-		return nil, fmt.Errorf("tls:// resolvers not supported yet")
+		c, err := f.getDoTClient(rr.name)
+		if err != nil {
+			metricDNSFwdErrorType.Add(1)
+			return nil, err
+		}
+		res, err := f.sendDoT(ctx, c, fq)
+		f.noteEncryptedResolverResult(rr.name.Addr, err)
+		if err != nil {
+			return nil, err
+		}
+		res = checkResponseSizeAndSetTC(res, fq.packet, fq.family, f.logf)
+		return res, nil
 	}
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -474,6 +474,19 @@ func runTestQuery(tb testing.TB, request []byte, modify func(*forwarder), ports
 }
 
 func runTestQueryWithFamily(tb testing.TB, request []byte, family string, modify func(*forwarder), ports ...uint16) ([]byte, error) {
+	fwd := newTestForwarder(tb)
+	if modify != nil {
+		modify(fwd)
+	}
+
+	resolvers := make([]*dnstype.Resolver, len(ports))
+	for i, port := range ports {
+		resolvers[i] = &dnstype.Resolver{Addr: fmt.Sprintf("127.0.0.1:%d", port)}
+	}
+	return runTestQueryWithForwarder(tb, fwd, request, family, resolvers...)
+}
+
+func newTestForwarder(tb testing.TB) *forwarder {
 	logf := tstest.WhileTestRunningLogger(tb)
 	bus := eventbustest.NewBus(tb)
 	netMon, err := netmon.New(bus, logf)
@@ -486,13 +499,14 @@ func runTestQueryWithFamily(tb testing.TB, request []byte, family string, modify
 	dialer.SetBus(bus)
 
 	fwd := newForwarder(logf, netMon, nil, &dialer, health.NewTracker(bus), nil)
-	if modify != nil {
-		modify(fwd)
-	}
+	tb.Cleanup(func() { fwd.Close() })
+	return fwd
+}
 
-	resolvers := make([]resolverAndDelay, len(ports))
-	for i, port := range ports {
-		resolvers[i].name = &dnstype.Resolver{Addr: fmt.Sprintf("127.0.0.1:%d", port)}
+func runTestQueryWithForwarder(tb testing.TB, fwd *forwarder, request []byte, family string, rs ...*dnstype.Resolver) ([]byte, error) {
+	resolvers := make([]resolverAndDelay, len(rs))
+	for i, r := range rs {
+		resolvers[i].name = r
 	}
 
 	rpkt := packet{
@@ -504,7 +518,10 @@ func runTestQueryWithFamily(tb testing.TB, request []byte, family string, modify
 	rchan := make(chan packet, 1)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	tb.Cleanup(cancel)
-	err = fwd.forwardWithDestChan(ctx, rpkt, rchan, resolvers...)
+	err := fwd.forwardWithDestChan(ctx, rpkt, rchan, resolvers...)
+	if err != nil && len(rchan) == 0 {
+		return nil, err
+	}
 	select {
 	case res := <-rchan:
 		return res.bs, err
@@ -12,6 +12,7 @@
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/netip"
 	"os"
@@ -219,6 +220,8 @@ type Resolver struct {
 	saveConfigForTests func(cfg Config) // used in tests to capture resolver config
 	// forwarder forwards requests to upstream nameservers.
 	forwarder *forwarder
+	// cache caches responses from upstream nameservers; nil if disabled.
+	cache *responseCache
 
 	// closed signals all goroutines to stop.
 	closed chan struct{}
@@ -226,6 +229,7 @@ type Resolver struct {
 	// mu guards the following fields from being updated while used.
 	mu             syncs.Mutex
 	localDomains   []dnsname.FQDN
+	routes         map[dnsname.FQDN][]*dnstype.Resolver
 	hostToIP       map[dnsname.FQDN][]netip.Addr
 	ipToHost       map[netip.Addr]dnsname.FQDN
 	subdomainHosts set.Set[dnsname.FQDN]
@@ -261,6 +265,9 @@ func New(logf logger.Logf, linkSel ForwardLinkSelector, dialer *tsdial.Dialer, h
 		health:   health,
 	}
 	r.forwarder = newForwarder(r.logf, netMon, linkSel, dialer, health, knobs)
+	if !disableCache() {
+		r.cache = newResponseCache()
+	}
 	return r
 }
 
@@ -286,6 +293,12 @@ func (r *Resolver) SetConfig(cfg Config) error {
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if !routesEqual(r.routes, cfg.Routes) {
+		// Cached responses may be from resolvers that are no longer
+		// used, or that are now used for different names.
+		r.cache.flush()
+	}
+	r.routes = cfg.Routes
 	r.localDomains = cfg.LocalDomains
 	r.hostToIP = cfg.Hosts
 	r.ipToHost = reverse
@@ -293,6 +306,31 @@ func (r *Resolver) SetConfig(cfg Config) error {
 	return nil
 }
 
+func routesEqual(a, b map[dnsname.FQDN][]*dnstype.Resolver) bool {
+	return maps.EqualFunc(a, b, func(x, y []*dnstype.Resolver) bool {
+		return slices.EqualFunc(x, y, (*dnstype.Resolver).Equal)
+	})
+}
+
+// FlushCache removes all cached responses from upstream nameservers. It's
+// called on major link changes, as the upstream nameservers may now answer
+// differently.
+func (r *Resolver) FlushCache() {
+	if !buildfeatures.HasDNS {
+		return
+	}
+	r.cache.flush()
+}
+
+// CacheEntries returns the responses from upstream nameservers that are
+// currently cached, most recently used first.
+func (r *Resolver) CacheEntries() []CacheEntry {
+	if !buildfeatures.HasDNS {
+		return nil
+	}
+	return r.cache.entries(time.Now())
+}
+
 // Close shuts down the resolver and ensures poll goroutines have exited.
 // The Resolver cannot be used again after Close is called.
 func (r *Resolver) Close() {
@@ -329,6 +367,9 @@ func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from net
 
 	out, err := r.respond(bs)
 	if err == errNotOurName {
+		if out, ok := r.cache.get(bs, "", time.Now()); ok {
+			return checkResponseSizeAndSetTC(out, bs, family, r.logf), nil
+		}
 		responses := make(chan packet, 1)
 		ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
 		defer close(responses)
@@ -337,7 +378,9 @@ func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from net
 		if err != nil {
 			return nil, err
 		}
-		return (<-responses).bs, nil
+		out := (<-responses).bs
+		r.cache.add(bs, out, "", time.Now())
+		return out, nil
 	}
 
 	if err != nil {
@@ -399,6 +442,7 @@ func (r *Resolver) HandlePeerDNSQuery(ctx context.Context, q []byte, from netip.
 		return marshalResponse(resp)
 	}
 
+	var upstream string // for the cache key; empty for our DNS config
 	switch runtime.GOOS {
 	default:
 		return nil, errors.New("unsupported exit node OS")
@@ -428,11 +472,15 @@ func (r *Resolver) HandlePeerDNSQuery(ctx context.Context, q []byte, from netip.
 		case netip.Addr{}:
 			// Likewise, if the platform has no resolv.conf, just use our defaults.
 		default:
+			upstream = net.JoinHostPort(nameserver.String(), "53")
 			resolvers = []resolverAndDelay{{
-				name: &dnstype.Resolver{Addr: net.JoinHostPort(nameserver.String(), "53")},
+				name: &dnstype.Resolver{Addr: upstream},
 			}}
 		}
 
+		if res, ok := r.cache.get(q, upstream, time.Now()); ok {
+			return res, nil
+		}
 		err = r.forwarder.forwardWithDestChan(ctx, packet{q, "tcp", from}, ch, resolvers...)
 		if err != nil {
 			metricDNSExitProxyErrorForward.Add(1)
@@ -442,6 +490,7 @@ func (r *Resolver) HandlePeerDNSQuery(ctx context.Context, q []byte, from netip.
 	select {
 	case p, ok := <-ch:
 		if ok {
+			r.cache.add(q, p.bs, upstream, time.Now())
 			return p.bs, nil
 		}
 		panic("unexpected close chan")
@@ -1424,6 +1473,15 @@ func unARPA(a string) (ipStr string, ok bool) {
 	metricDNSFwdDoHErrorTransport = clientmetric.NewCounter("dns_query_fwd_doh_error_transport")
 	metricDNSFwdDoHErrorBody      = clientmetric.NewCounter("dns_query_fwd_doh_error_body")
 
+	metricDNSFwdDoT               = clientmetric.NewCounter("dns_query_fwd_dot")
+	metricDNSFwdDoTErrorTransport = clientmetric.NewCounter("dns_query_fwd_dot_error_transport")
+	metricDNSFwdDoTErrorServer    = clientmetric.NewCounter("dns_query_fwd_dot_error_server")
+	metricDNSFwdDoTErrorTxID      = clientmetric.NewCounter("dns_query_fwd_dot_error_txid")
+	metricDNSFwdDoTSuccess        = clientmetric.NewCounter("dns_query_fwd_dot_success")
+
+	metricDNSCacheHit  = clientmetric.NewCounter("dns_cache_hit")
+	metricDNSCacheMiss = clientmetric.NewCounter("dns_cache_miss")
+
 	metricDNSResolveLocal             = clientmetric.NewCounter("dns_resolve_local")
 	metricDNSResolveLocalErrorOnion   = clientmetric.NewCounter("dns_resolve_local_error_onion")
 	metricDNSResolveLocalErrorMissing = clientmetric.NewCounter("dns_resolve_local_error_missing")
@@ -0,0 +1,207 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package socks5
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Authenticator authenticates clients by the username and password they
+// provide (RFC 1929) and decides what they may do.
+type Authenticator interface {
+	// Authenticate returns the policy for a client with the given
+	// credentials, or an error if they're not valid. A nil Policy allows
+	// all destinations.
+	Authenticate(username, password string) (*Policy, error)
+}
+
+// AuthenticatorFunc is an Authenticator implemented by a func.
+type AuthenticatorFunc func(username, password string) (*Policy, error)
+
+// Authenticate implements Authenticator.
+func (f AuthenticatorFunc) Authenticate(username, password string) (*Policy, error) {
+	return f(username, password)
+}
+
+// User is a user of a Users authenticator.
+type User struct {
+	Password string
+	Policy   *Policy // or nil to allow all destinations
+}
+
+// Users is an Authenticator for a fixed set of users, keyed by username.
+type Users map[string]User
+
+var errInvalidCredentials = errors.New("invalid username or password")
+
+// Authenticate implements Authenticator.
+func (u Users) Authenticate(username, password string) (*Policy, error) {
+	user, ok := u[username]
+	if !ok || subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+		return nil, errInvalidCredentials
+	}
+	return user.Policy, nil
+}
+
+// Policy restricts the destinations that a client may reach.
+//
+// For CONNECT and UDP ASSOCIATE requests, a destination is allowed if its
+// host and port match one of the policy's rules. A hostname destination is
+// also allowed if the IP address it resolves to matches, in which case the
+// server connects to that address rather than resolving the name again. For BIND requests,
+// only the host of the destination and of the connecting peer are checked,
+// as the peer connects from an arbitrary port; hostname rules never match
+// the peer.
+//
+// A nil *Policy allows all destinations.
+type Policy struct {
+	rules []policyRule
+}
+
+// policyRule is a parsed rule of a Policy.
+type policyRule struct {
+	// Exactly one of host and prefix is set.
+	host   string       // "*", a lowercase hostname, or a "*."-prefixed domain wildcard
+	prefix netip.Prefix // IP addresses are single-IP prefixes
+	ports  []portRange  // empty means any port
+}
+
+type portRange struct {
+	first, last uint16
+}
+
+// ParsePolicy returns a policy that allows the given destinations.
+//
+// Each destination is of the form "host:ports", where host is "*" for any
+// host, an IP address, a CIDR prefix, a hostname, or a wildcard like
+// "*.example.com" matching all subdomains of example.com; and ports is "*"
+// for any port, or a comma-separated list of ports and port ranges like
+// "80,443,8000-8100". IPv6 hosts may be enclosed in brackets.
+//
+// With no destinations, the policy allows nothing.
+func ParsePolicy(allow ...string) (*Policy, error) {
+	p := &Policy{}
+	for _, s := range allow {
+		r, err := parsePolicyRule(s)
+		if err != nil {
+			return nil, err
+		}
+		p.rules = append(p.rules, r)
+	}
+	return p, nil
+}
+
+func parsePolicyRule(s string) (policyRule, error) {
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		return policyRule{}, fmt.Errorf("invalid destination %q: missing port", s)
+	}
+	host, ports := s[:i], s[i+1:]
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	var r policyRule
+	switch {
+	case host == "":
+		return policyRule{}, fmt.Errorf("invalid destination %q: missing host", s)
+	case host == "*":
+		r.host = host
+	case strings.Contains(host, "/"):
+		pfx, err := netip.ParsePrefix(host)
+		if err != nil {
+			return policyRule{}, fmt.Errorf("invalid destination %q: %w", s, err)
+		}
+		r.prefix = pfx.Masked()
+	default:
+		if ip, err := netip.ParseAddr(host); err == nil {
+			r.prefix = netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen())
+			break
+		}
+		name := strings.TrimSuffix(strings.ToLower(host), ".")
+		if strings.ContainsAny(strings.TrimPrefix(name, "*."), "*:[]") || strings.HasSuffix(name, ".") {
+			return policyRule{}, fmt.Errorf("invalid destination %q: bad hostname", s)
+		}
+		r.host = name
+	}
+
+	if ports == "*" {
+		return r, nil
+	}
+	for pr := range strings.SplitSeq(ports, ",") {
+		first, last, isRange := strings.Cut(pr, "-")
+		lo, err := strconv.ParseUint(first, 10, 16)
+		if err != nil {
+			return policyRule{}, fmt.Errorf("invalid destination %q: bad port %q", s, pr)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.ParseUint(last, 10, 16); err != nil || hi < lo {
+				return policyRule{}, fmt.Errorf("invalid destination %q: bad port range %q", s, pr)
+			}
+		}
+		r.ports = append(r.ports, portRange{uint16(lo), uint16(hi)})
+	}
+	return r, nil
+}
+
+// matchesHost reports whether host, an IP address or hostname, matches r.
+func (r *policyRule) matchesHost(host string) bool {
+	if r.host == "*" {
+		return true
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return r.prefix.IsValid() && r.prefix.Contains(ip.Unmap())
+	}
+	if r.host == "" {
+		return false
+	}
+	name := strings.TrimSuffix(strings.ToLower(host), ".")
+	if suffix, ok := strings.CutPrefix(r.host, "*"); ok {
+		return strings.HasSuffix(name, suffix)
+	}
+	return name == r.host
+}
+
+// matchesPort reports whether port matches r.
+func (r *policyRule) matchesPort(port uint16) bool {
+	if len(r.ports) == 0 {
+		return true
+	}
+	for _, pr := range r.ports {
+		if pr.first <= port && port <= pr.last {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHost reports whether p allows host, whatever the port.
+func (p *Policy) allowsHost(host string) bool {
+	if p == nil {
+		return true
+	}
+	for i := range p.rules {
+		if p.rules[i].matchesHost(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsDest reports whether p allows the destination a.
+func (p *Policy) allowsDest(a socksAddr) bool {
+	if p == nil {
+		return true
+	}
+	for i := range p.rules {
+		if p.rules[i].matchesHost(a.addr) && p.rules[i].matchesPort(a.port) {
+			return true
+		}
+	}
+	return false
+}
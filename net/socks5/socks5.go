@@ -21,6 +21,7 @@
 	"io"
 	"log"
 	"net"
+	"net/netip"
 	"slices"
 	"strconv"
 	"time"
@@ -88,6 +89,10 @@
 	readTimeout = 5 * time.Second
 )
 
+// bindAcceptTimeout is how long a BIND request waits for the incoming
+// connection.
+const bindAcceptTimeout = 2 * time.Minute
+
 // Server is a SOCKS5 proxy server.
 type Server struct {
 	// Logf optionally specifies the logger to use.
@@ -98,9 +103,29 @@ type Server struct {
 	// If nil, the net package's standard dialer is used.
 	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
 
+	// Resolve optionally specifies how to resolve hostname destinations that
+	// a client's Policy doesn't allow by name, so that their IP address can
+	// be checked against it and dialed. It should resolve names the same way
+	// as Dialer.
+	// If nil, the net package's default resolver is used.
+	Resolve func(ctx context.Context, network, addr string) (netip.AddrPort, error)
+
+	// Listen optionally specifies how to listen for the incoming connection
+	// of a BIND request. addr is the request's destination: the host:port
+	// of the host the client expects to connect, which the listener should
+	// be reachable from.
+	// If nil, the net package is used to listen on the local address that
+	// the system routes addr's traffic from.
+	Listen func(ctx context.Context, network, addr string) (net.Listener, error)
+
 	// Username and Password, if set, are the credential clients must provide.
+	// They're ignored if Authenticator is set.
 	Username string
 	Password string
+
+	// Authenticator, if non-nil, checks the username and password that
+	// clients must provide and returns the policy for their connection.
+	Authenticator Authenticator
 }
 
 func (s *Server) dial(ctx context.Context, network, addr string) (net.Conn, error) {
@@ -112,6 +137,47 @@ func (s *Server) dial(ctx context.Context, network, addr string) (net.Conn, erro
 	return dial(ctx, network, addr)
 }
 
+func (s *Server) resolve(ctx context.Context, network, addr string) (netip.AddrPort, error) {
+	if s.Resolve != nil {
+		return s.Resolve(ctx, network, addr)
+	}
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	ipNet := "ip"
+	switch network {
+	case "tcp4", "udp4":
+		ipNet = "ip4"
+	case "tcp6", "udp6":
+		ipNet = "ip6"
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, ipNet, host)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if len(ips) == 0 {
+		return netip.AddrPort{}, fmt.Errorf("DNS lookup returned no results for %q", host)
+	}
+	return netip.AddrPortFrom(ips[0].Unmap(), port), nil
+}
+
+func (s *Server) listen(ctx context.Context, network, addr string) (net.Listener, error) {
+	if s.Listen != nil {
+		return s.Listen(ctx, network, addr)
+	}
+	// Find the local address that traffic to addr comes from by "dialing"
+	// it over UDP, which doesn't send any packets.
+	var host string
+	var d net.Dialer
+	if c, err := d.DialContext(ctx, "udp", addr); err == nil {
+		host, _, _ = net.SplitHostPort(c.LocalAddr().String())
+		c.Close()
+	}
+	var lc net.ListenConfig
+	return lc.Listen(ctx, network, net.JoinHostPort(host, "0"))
+}
+
 func (s *Server) logf(format string, args ...any) {
 	logf := s.Logf
 	if logf == nil {
@@ -149,6 +215,7 @@ type Conn struct {
 	srv        *Server
 	clientConn net.Conn
 	request    *request
+	policy     *Policy // or nil if unrestricted
 
 	udpClientAddr  net.Addr
 	udpTargetConns map[socksAddr]net.Conn
@@ -156,7 +223,7 @@ type Conn struct {
 
 // Run starts the new connection.
 func (c *Conn) Run() error {
-	needAuth := c.srv.Username != "" || c.srv.Password != ""
+	needAuth := c.srv.Authenticator != nil || c.srv.Username != "" || c.srv.Password != ""
 	authMethod := noAuthRequired
 	if needAuth {
 		authMethod = passwordAuth
@@ -173,7 +240,14 @@ func (c *Conn) Run() error {
 	}
 
 	user, pwd, err := parseClientAuth(c.clientConn)
-	if err != nil || user != c.srv.Username || pwd != c.srv.Password {
+	if err == nil {
+		if a := c.srv.Authenticator; a != nil {
+			c.policy, err = a.Authenticate(user, pwd)
+		} else if user != c.srv.Username || pwd != c.srv.Password {
+			err = errInvalidCredentials
+		}
+	}
+	if err != nil {
 		c.clientConn.Write([]byte{1, 1}) // auth error
 		return err
 	}
@@ -195,6 +269,8 @@ func (c *Conn) handleRequest() error {
 	switch req.command {
 	case connect:
 		return c.handleTCP()
+	case bind:
+		return c.handleBind()
 	case udpAssociate:
 		return c.handleUDP()
 	default:
@@ -205,18 +281,26 @@ func (c *Conn) handleRequest() error {
 	}
 }
 
+// writeError writes an error response with the given reply code to the
+// client.
+func (c *Conn) writeError(code replyCode) {
+	res := errorResponse(code)
+	buf, _ := res.marshal()
+	c.clientConn.Write(buf)
+}
+
 func (c *Conn) handleTCP() error {
+	dst := c.request.destination
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	srv, err := c.srv.dial(
-		ctx,
-		"tcp",
-		c.request.destination.hostPort(),
-	)
+	addr, code, err := c.allowedDialAddr(ctx, "tcp", dst)
 	if err != nil {
-		res := errorResponse(generalFailure)
-		buf, _ := res.marshal()
-		c.clientConn.Write(buf)
+		c.writeError(code)
+		return err
+	}
+	srv, err := c.srv.dial(ctx, "tcp", addr)
+	if err != nil {
+		c.writeError(generalFailure)
 		return err
 	}
 	defer srv.Close()
@@ -242,6 +326,11 @@ func (c *Conn) handleTCP() error {
 	}
 	c.clientConn.Write(buf)
 
+	return c.relay(srv)
+}
+
+// relay copies data between the client and srv until either side is done.
+func (c *Conn) relay(srv net.Conn) error {
 	errc := make(chan error, 2)
 	go func() {
 		_, err := io.Copy(c.clientConn, srv)
@@ -260,6 +349,119 @@ func (c *Conn) handleTCP() error {
 	return <-errc
 }
 
+// handleBind handles a BIND request, in which the client asks the server to
+// accept one connection on its behalf, as used by protocols like FTP where
+// the client's peer connects back to it.
+//
+// The server replies twice: first with the address it's listening on, which
+// the client passes on to its peer, and then with the address of the peer
+// once it has connected. See RFC 1928, section 4.
+func (c *Conn) handleBind() error {
+	dst := c.request.destination
+	if !c.policy.allowsHost(dst.addr) {
+		c.writeError(connectionNotAllowed)
+		return fmt.Errorf("bind for %v not allowed by policy", dst)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), bindAcceptTimeout)
+	defer cancel()
+	ln, err := c.srv.listen(ctx, "tcp", dst.hostPort())
+	if err != nil {
+		c.writeError(generalFailure)
+		return err
+	}
+	defer ln.Close()
+	// Stop waiting for the peer after bindAcceptTimeout.
+	context.AfterFunc(ctx, func() { ln.Close() })
+
+	bindHost, bindPort, err := splitHostPort(ln.Addr().String())
+	if err != nil {
+		c.writeError(generalFailure)
+		return err
+	}
+	if ip := net.ParseIP(bindHost); ip == nil || ip.IsUnspecified() {
+		// Tell the client the address it reached us on instead, which is
+		// hopefully reachable from its peer too.
+		bindHost, _, err = splitHostPort(c.clientConn.LocalAddr().String())
+		if err != nil {
+			c.writeError(generalFailure)
+			return err
+		}
+	}
+	if err := c.writeSuccess(bindHost, bindPort); err != nil {
+		return err
+	}
+
+	peer, err := ln.Accept()
+	if err != nil {
+		c.writeError(generalFailure)
+		return fmt.Errorf("bind accept: %w", err)
+	}
+	defer peer.Close()
+	ln.Close()
+
+	peerHost, peerPort, err := splitHostPort(peer.RemoteAddr().String())
+	if err != nil {
+		c.writeError(generalFailure)
+		return err
+	}
+	// Only the host the client named (if it named one) may connect.
+	if ip := net.ParseIP(dst.addr); ip != nil && !ip.IsUnspecified() && !ip.Equal(net.ParseIP(peerHost)) {
+		c.writeError(connectionNotAllowed)
+		return fmt.Errorf("bind: unexpected connection from %v; want %v", peer.RemoteAddr(), dst.addr)
+	}
+	if !c.policy.allowsHost(peerHost) {
+		c.writeError(connectionNotAllowed)
+		return fmt.Errorf("bind: connection from %v not allowed by policy", peer.RemoteAddr())
+	}
+	if err := c.writeSuccess(peerHost, peerPort); err != nil {
+		return err
+	}
+	return c.relay(peer)
+}
+
+// writeSuccess writes a success response with the given bound address to the
+// client.
+func (c *Conn) writeSuccess(host string, port uint16) error {
+	res := &response{
+		reply: success,
+		bindAddr: socksAddr{
+			addrType: getAddrType(host),
+			addr:     host,
+			port:     port,
+		},
+	}
+	buf, err := res.marshal()
+	if err != nil {
+		c.writeError(generalFailure)
+		return err
+	}
+	_, err = c.clientConn.Write(buf)
+	return err
+}
+
+// allowedDialAddr returns the address to dial for the destination dst of a
+// CONNECT or UDP ASSOCIATE request, if the client's policy allows it. A
+// hostname that the policy doesn't allow by name is resolved, and its IP
+// address is checked against the policy and returned, so that what's dialed
+// is what was checked. On failure, it also returns the reply code to send.
+func (c *Conn) allowedDialAddr(ctx context.Context, network string, dst socksAddr) (string, replyCode, error) {
+	if c.policy.allowsDest(dst) {
+		return dst.hostPort(), success, nil
+	}
+	if dst.addrType != domainName {
+		return "", connectionNotAllowed, fmt.Errorf("%s to %v not allowed by policy", network, dst)
+	}
+	ipp, err := c.srv.resolve(ctx, network, dst.hostPort())
+	if err != nil {
+		return "", hostUnreachable, err
+	}
+	if !c.policy.allowsDest(socksAddr{addr: ipp.Addr().String(), port: dst.port}) {
+		return "", connectionNotAllowed, fmt.Errorf("%s to %v (%v) not allowed by policy", network, dst, ipp.Addr())
+	}
+	return ipp.String(), success, nil
+}
+
 func (c *Conn) handleUDP() error {
 	// The DST.ADDR and DST.PORT fields contain the address and port that
 	// the client expects to use to send UDP datagrams on for the
@@ -361,7 +563,11 @@ func (c *Conn) getOrDialTargetConn(
 	if exist {
 		return conn, nil
 	}
-	conn, err := c.srv.dial(ctx, "udp", targetAddr.hostPort())
+	addr, _, err := c.allowedDialAddr(ctx, "udp", targetAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err = c.srv.dial(ctx, "udp", addr)
 	if err != nil {
 		return nil, err
 	}
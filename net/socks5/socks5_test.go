@@ -5,10 +5,14 @@
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
+	"slices"
+	"sync"
 	"testing"
 
 	"golang.org/x/net/proxy"
@@ -287,3 +291,266 @@ func TestUDP(t *testing.T) {
 		}
 	}
 }
+
+func TestBind(t *testing.T) {
+	socks5ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks5ln.Close()
+	go func() {
+		var s Server
+		if err := s.Serve(socks5ln); err != nil && !errors.Is(err, net.ErrClosed) {
+			panic(err)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", socks5ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{socks5Version, 0x01, noAuthRequired}); err != nil {
+		t.Fatal(err)
+	}
+	var hello [2]byte
+	if _, err := io.ReadFull(conn, hello[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ask for a connection from 127.0.0.1.
+	dst, err := socksAddr{addrType: ipv4, addr: "127.0.0.1"}.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(bind), 0x00}, dst...)); err != nil {
+		t.Fatal(err)
+	}
+	readReply := func() socksAddr {
+		t.Helper()
+		var hdr [3]byte
+		if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+			t.Fatal(err)
+		}
+		if hdr != [3]byte{socks5Version, byte(success), 0x00} {
+			t.Fatalf("got reply %x; want success", hdr)
+		}
+		addr, err := parseSocksAddr(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return addr
+	}
+	bound := readReply()
+
+	// Connect to the bound address as the client's peer.
+	peer, err := net.Dial("tcp", bound.hostPort())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	if got, want := readReply().hostPort(), peer.LocalAddr().String(); got != want {
+		t.Errorf("second reply address = %v; want %v", got, want)
+	}
+
+	if _, err := peer.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("client got %q; want ping", buf)
+	}
+	if _, err := conn.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(peer, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "pong" {
+		t.Fatalf("peer got %q; want pong", buf)
+	}
+}
+
+func TestAuthenticatorPolicy(t *testing.T) {
+	newBackend := func() int {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				c.Write([]byte("Test"))
+				c.Close()
+			}
+		}()
+		t.Cleanup(func() { ln.Close() })
+		return ln.Addr().(*net.TCPAddr).Port
+	}
+	allowedPort, otherPort := newBackend(), newBackend()
+
+	policy, err := ParsePolicy(fmt.Sprintf("127.0.0.0/8:%d", allowedPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	socks5ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks5ln.Close()
+	var dialedMu sync.Mutex
+	var dialed []string
+	go func() {
+		s := Server{
+			Authenticator: Users{
+				"limited":   {Password: "pw1", Policy: policy},
+				"unlimited": {Password: "pw2"},
+			},
+			Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dialedMu.Lock()
+				dialed = append(dialed, addr)
+				dialedMu.Unlock()
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+			Resolve: func(ctx context.Context, network, addr string) (netip.AddrPort, error) {
+				host, port, err := splitHostPort(addr)
+				if err != nil {
+					return netip.AddrPort{}, err
+				}
+				if host != "backend.test" {
+					return netip.AddrPort{}, fmt.Errorf("unknown host %q", host)
+				}
+				return netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), port), nil
+			},
+		}
+		if err := s.Serve(socks5ln); err != nil && !errors.Is(err, net.ErrClosed) {
+			panic(err)
+		}
+	}()
+
+	dialHost := func(user, pwd, host string, port int) error {
+		d, err := proxy.SOCKS5("tcp", socks5ln.Addr().String(), &proxy.Auth{User: user, Password: pwd}, proxy.Direct)
+		if err != nil {
+			return err
+		}
+		c, err := d.Dial("tcp", net.JoinHostPort(host, fmt.Sprint(port)))
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return err
+		}
+		if string(buf) != "Test" {
+			return fmt.Errorf("got %q; want Test", buf)
+		}
+		return nil
+	}
+	dial := func(user, pwd string, port int) error {
+		return dialHost(user, pwd, "127.0.0.1", port)
+	}
+
+	if err := dial("limited", "wrong", allowedPort); err == nil {
+		t.Error("dial with bad password succeeded")
+	}
+	if err := dial("limited", "pw1", allowedPort); err != nil {
+		t.Errorf("dial to allowed port: %v", err)
+	}
+	if err := dial("limited", "pw1", otherPort); err == nil {
+		t.Error("dial to disallowed port succeeded")
+	}
+	if err := dial("unlimited", "pw2", otherPort); err != nil {
+		t.Errorf("unrestricted dial: %v", err)
+	}
+
+	// A hostname is checked by the IP address it resolves to, which is then
+	// what gets dialed, so that the name can't resolve differently for the
+	// check and the dial.
+	dialedMu.Lock()
+	dialed = nil
+	dialedMu.Unlock()
+	if err := dialHost("limited", "pw1", "backend.test", allowedPort); err != nil {
+		t.Errorf("dial to allowed hostname: %v", err)
+	}
+	if err := dialHost("limited", "pw1", "backend.test", otherPort); err == nil {
+		t.Error("dial to hostname on disallowed port succeeded")
+	}
+	dialedMu.Lock()
+	defer dialedMu.Unlock()
+	if want := []string{fmt.Sprintf("127.0.0.1:%d", allowedPort)}; !slices.Equal(dialed, want) {
+		t.Errorf("dialed %q; want %q", dialed, want)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy(
+		"100.64.0.0/10:22,80",
+		"192.168.1.1:8000-8100",
+		"[fd7a:115c:a1e0::1]:*",
+		"example.com:443",
+		"*.example.org:*",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host string
+		port uint16
+		want bool
+	}{
+		{"100.100.1.1", 22, true},
+		{"100.100.1.1", 80, true},
+		{"100.100.1.1", 443, false},
+		{"100.128.0.1", 22, false},
+		{"192.168.1.1", 8050, true},
+		{"192.168.1.1", 8101, false},
+		{"::ffff:192.168.1.1", 8000, true},
+		{"fd7a:115c:a1e0::1", 1234, true},
+		{"fd7a:115c:a1e0::2", 1234, false},
+		{"example.com", 443, true},
+		{"EXAMPLE.com.", 443, true},
+		{"example.com", 80, false},
+		{"www.example.com", 443, false},
+		{"www.example.org", 80, true},
+		{"example.org", 80, false},
+	}
+	for _, tt := range tests {
+		if got := p.allowsDest(socksAddr{addr: tt.host, port: tt.port}); got != tt.want {
+			t.Errorf("allowsDest(%s:%d) = %v; want %v", tt.host, tt.port, got, tt.want)
+		}
+	}
+	if !p.allowsHost("www.example.org") || p.allowsHost("example.net") {
+		t.Error("allowsHost mismatch")
+	}
+
+	var nilPolicy *Policy
+	if !nilPolicy.allowsDest(socksAddr{addr: "1.2.3.4", port: 1}) {
+		t.Error("nil policy disallowed destination")
+	}
+	if empty, _ := ParsePolicy(); empty.allowsDest(socksAddr{addr: "1.2.3.4", port: 1}) {
+		t.Error("empty policy allowed destination")
+	}
+
+	for _, bad := range []string{
+		"1.2.3.4",
+		":80",
+		"1.2.3.4:http",
+		"1.2.3.4:90-80",
+		"1.2.3.0/33:80",
+		"foo.*.com:80",
+		"1.2.3.4:70000",
+		"fd7a:115c:a1e0::1",
+	} {
+		if _, err := ParsePolicy(bad); err == nil {
+			t.Errorf("ParsePolicy(%q) succeeded; want error", bad)
+		}
+	}
+}
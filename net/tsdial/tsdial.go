@@ -12,6 +12,7 @@
 	"net/http"
 	"net/netip"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -75,6 +76,11 @@ type Dialer struct {
 	// If nil, it's not used.
 	NetstackDialUDP func(context.Context, netip.AddrPort) (net.Conn, error)
 
+	// NetstackListenTCP listens for TCP connections on the provided
+	// IPPort using netstack.
+	// If nil, it's not used.
+	NetstackListenTCP func(netip.AddrPort) (net.Listener, error)
+
 	peerClientOnce sync.Once
 	peerClient     *http.Client
 
@@ -90,7 +96,8 @@ type Dialer struct {
 	mu               syncs.Mutex
 	closed           bool
 	dns              dnsMap
-	tunName          string // tun device name
+	selfAddrs        []netip.Addr // this node's Tailscale IPs
+	tunName          string       // tun device name
 	netMon           *netmon.Monitor
 	netMonUnregister func()
 	exitDNSDoHBase   string                 // non-empty if DoH-proxying exit node in use; base URL+path (without '?')
@@ -352,10 +359,19 @@ func (d *Dialer) PeerDialControlFunc() func(network, address string, c syscall.R
 // in its DNS configuration.
 func (d *Dialer) SetNetMap(nm *netmap.NetworkMap) {
 	m := dnsMapFromNetworkMap(nm)
+	var selfAddrs []netip.Addr
+	if nm != nil {
+		for _, p := range nm.GetAddresses().All() {
+			if p.IsSingleIP() {
+				selfAddrs = append(selfAddrs, p.Addr())
+			}
+		}
+	}
 
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.dns = m
+	d.selfAddrs = selfAddrs
 }
 
 // userDialResolve resolves addr as if a user initiating the dial. (e.g. from a
@@ -515,6 +531,55 @@ func (d *Dialer) UserDial(ctx context.Context, network, addr string) (net.Conn,
 	return stdDialer.DialContext(ctx, network, ipp.String())
 }
 
+// UserResolve resolves addr the way UserDial does, including MagicDNS names.
+func (d *Dialer) UserResolve(ctx context.Context, network, addr string) (netip.AddrPort, error) {
+	return d.userDialResolve(ctx, network, addr)
+}
+
+// UserListen listens for a TCP connection from the provided network address
+// as if a user were listening, such as for a SOCKS5 BIND request. The
+// listener is bound to the local address that addr is reachable from, on a
+// random port.
+func (d *Dialer) UserListen(ctx context.Context, network, addr string) (net.Listener, error) {
+	ipp, err := d.userDialResolve(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if d.UseNetstackForIP != nil && d.UseNetstackForIP(ipp.Addr()) {
+		if d.NetstackListenTCP == nil {
+			return nil, errors.New("Dialer not initialized correctly")
+		}
+		d.mu.Lock()
+		i := slices.IndexFunc(d.selfAddrs, func(a netip.Addr) bool {
+			return a.Is6() == ipp.Addr().Is6()
+		})
+		var src netip.Addr
+		if i >= 0 {
+			src = d.selfAddrs[i]
+		}
+		d.mu.Unlock()
+		if !src.IsValid() {
+			return nil, fmt.Errorf("no Tailscale address to listen for %v on", ipp)
+		}
+		return d.NetstackListenTCP(netip.AddrPortFrom(src, 0))
+	}
+
+	// Find the local address the system uses to reach ipp by "dialing" it
+	// over UDP, which doesn't send any packets.
+	c, err := d.UserDial(ctx, "udp", ipp.String())
+	if err != nil {
+		return nil, err
+	}
+	local := c.LocalAddr().String()
+	c.Close()
+	host, _, err := net.SplitHostPort(local)
+	if err != nil {
+		return nil, err
+	}
+	var lc net.ListenConfig
+	return lc.Listen(ctx, "tcp", net.JoinHostPort(host, "0"))
+}
+
 // dialPeerAPI connects to a Tailscale peer's peerapi over TCP.
 //
 // network must a "tcp" type, and addr must be an ip:port. Name resolution
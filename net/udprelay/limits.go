@@ -0,0 +1,82 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package udprelay
+
+import (
+	"errors"
+	"time"
+
+	"tailscale.com/tstime/mono"
+)
+
+// Limits are limits on the resources a [Server] commits to peer relay
+// sessions. The zero value of each field means no limit.
+type Limits struct {
+	// MaxSessions is the maximum number of sessions allocated at once.
+	MaxSessions int
+	// MaxSessionsPerNode is the maximum number of sessions allocated at once
+	// that any one client, as identified by its disco key, is part of.
+	MaxSessionsPerNode int
+	// SessionBytesPerSec is the maximum rate in bytes per second at which a
+	// session forwards data, summed across both directions. Packets in
+	// excess of it are dropped.
+	SessionBytesPerSec int64
+	// IdleTimeout is how long a client of a session can go without sending
+	// anything before the session is torn down. If zero, the default of 5
+	// minutes is used. It's advertised to clients as
+	// [endpoint.ServerEndpoint.SteadyStateLifetime].
+	IdleTimeout time.Duration
+}
+
+var (
+	// ErrTooManySessions is returned by [Server.AllocateEndpoint] when
+	// allocating a session would exceed [Limits.MaxSessions].
+	ErrTooManySessions = errors.New("too many peer relay sessions")
+	// ErrTooManySessionsForNode is returned by [Server.AllocateEndpoint] when
+	// allocating a session would exceed [Limits.MaxSessionsPerNode] for
+	// either client.
+	ErrTooManySessionsForNode = errors.New("too many peer relay sessions for node")
+	// ErrNoSuchSession is returned by [Server.CloseSession] when there's no
+	// session with the requested VNI.
+	ErrNoSuchSession = errors.New("no such peer relay session")
+)
+
+// minByteLimiterBurst is the minimum burst size of a byteLimiter, so that a
+// maximum size UDP datagram can always get through eventually.
+const minByteLimiterBurst = 1<<16 - 1
+
+// byteLimiter is a token bucket limiting a rate of bytes. Its burst size is
+// one second's worth of bytes, or minByteLimiterBurst if larger.
+//
+// It is not safe for concurrent use.
+type byteLimiter struct {
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   mono.Time
+}
+
+// newByteLimiter returns a limiter of bytesPerSec, which must be positive.
+func newByteLimiter(bytesPerSec int64) *byteLimiter {
+	burst := float64(max(bytesPerSec, minByteLimiterBurst))
+	return &byteLimiter{
+		rate:   float64(bytesPerSec),
+		burst:  burst,
+		tokens: burst,
+	}
+}
+
+// allow reports whether n bytes can be sent at now, consuming their tokens if
+// so.
+func (l *byteLimiter) allow(now mono.Time, n int) bool {
+	if !l.last.IsZero() {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if float64(n) > l.tokens {
+		return false
+	}
+	l.tokens -= float64(n)
+	return true
+}
@@ -0,0 +1,43 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package udprelay
+
+import (
+	"testing"
+	"time"
+
+	"tailscale.com/tstime/mono"
+)
+
+func TestByteLimiter(t *testing.T) {
+	const rate = 1 << 20
+	l := newByteLimiter(rate)
+	now := mono.Now()
+	if !l.allow(now, rate) {
+		t.Fatal("burst of one second's worth of bytes not allowed")
+	}
+	if l.allow(now, 1) {
+		t.Fatal("byte over burst allowed")
+	}
+	now = now.Add(time.Second / 2)
+	if !l.allow(now, rate/2) {
+		t.Fatal("half a second's worth of bytes not allowed after half a second")
+	}
+	if l.allow(now, 1) {
+		t.Fatal("byte over rate allowed")
+	}
+	now = now.Add(time.Hour)
+	if l.allow(now, rate+1) {
+		t.Fatal("more than burst allowed after idling")
+	}
+	if !l.allow(now, rate) {
+		t.Fatal("burst not allowed after idling")
+	}
+
+	// Datagrams bigger than the rate must get through eventually.
+	l = newByteLimiter(1)
+	if !l.allow(now, minByteLimiterBurst) {
+		t.Fatal("maximum size datagram not allowed at low rate")
+	}
+}
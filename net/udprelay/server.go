@@ -8,6 +8,7 @@
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/rand"
 	"encoding/binary"
@@ -66,23 +67,22 @@
 // Server implements an experimental UDP relay server.
 type Server struct {
 	// The following fields are initialized once and never mutated.
-	logf                logger.Logf
-	disco               key.DiscoPrivate
-	discoPublic         key.DiscoPublic
-	bindLifetime        time.Duration
-	steadyStateLifetime time.Duration
-	bus                 *eventbus.Bus
-	uc4                 []batching.Conn // length is always nonzero
-	uc4Port             uint16          // always nonzero
-	uc6                 []batching.Conn // length may be zero if udp6 bind fails
-	uc6Port             uint16          // zero if len(uc6) is zero, otherwise nonzero
-	closeOnce           sync.Once
-	wg                  sync.WaitGroup
-	closeCh             chan struct{}
-	netChecker          *netcheck.Client
-	metrics             *metrics
-	netMon              *netmon.Monitor
-	cloudInfo           *cloudinfo.CloudInfo // used to query cloud metadata services
+	logf         logger.Logf
+	disco        key.DiscoPrivate
+	discoPublic  key.DiscoPublic
+	bindLifetime time.Duration
+	bus          *eventbus.Bus
+	uc4          []batching.Conn // length is always nonzero
+	uc4Port      uint16          // always nonzero
+	uc6          []batching.Conn // length may be zero if udp6 bind fails
+	uc6Port      uint16          // zero if len(uc6) is zero, otherwise nonzero
+	closeOnce    sync.Once
+	wg           sync.WaitGroup
+	closeCh      chan struct{}
+	netChecker   *netcheck.Client
+	metrics      *metrics
+	netMon       *netmon.Monitor
+	cloudInfo    *cloudinfo.CloudInfo // used to query cloud metadata services
 
 	mu                  sync.Mutex                      // guards the following fields
 	macSecrets          views.Slice[[blake2s.Size]byte] // [0] is most recent, max 2 elements
@@ -94,6 +94,11 @@ type Server struct {
 	closed              bool
 	lamportID           uint64
 	nextVNI             uint32
+	limits              Limits        // set with [Server.SetLimits]
+	steadyStateLifetime time.Duration // [Limits.IdleTimeout] or defaultSteadyStateLifetime
+	// sessionsByDisco is the number of entries in serverEndpointByDisco
+	// that each client is part of, for [Limits.MaxSessionsPerNode].
+	sessionsByDisco map[key.DiscoPublic]int
 	// serverEndpointByVNI is consistent with serverEndpointByDisco while mu is
 	// held, i.e. mu must be held around write ops. Read ops in performance
 	// sensitive paths, e.g. packet forwarding, do not need to acquire mu.
@@ -126,8 +131,10 @@ type serverEndpoint struct {
 	inProgressGeneration [2]uint32         // or zero if a handshake has never started, or has just completed
 	boundAddrPorts       [2]netip.AddrPort // or zero value if a handshake has never completed for that relay leg
 	lastSeen             [2]mono.Time
-	packetsRx            [2]uint64 // num packets received from/sent by each client after they are bound
-	bytesRx              [2]uint64 // num bytes received from/sent by each client after they are bound
+	packetsRx            [2]uint64    // num packets received from/sent by each client after they are bound
+	bytesRx              [2]uint64    // num bytes received from/sent by each client after they are bound
+	packetsDropped       [2]uint64    // num packets received from each client in excess of limiter
+	limiter              *byteLimiter // or nil if [Limits.SessionBytesPerSec] is unset
 }
 
 func blakeMACFromBindMsg(blakeKey [blake2s.Size]byte, src netip.AddrPort, msg disco.BindUDPRelayEndpointCommon) ([blake2s.Size]byte, error) {
@@ -296,21 +303,24 @@ func (e *serverEndpoint) handleDataPacket(from netip.AddrPort, b []byte, now mon
 		// endpoint was closed in [Server.endpointGC]
 		return nil, netip.AddrPort{}
 	}
+	var senderIndex int
 	switch {
 	case from == e.boundAddrPorts[0]:
-		e.lastSeen[0] = now
-		e.packetsRx[0]++
-		e.bytesRx[0] += uint64(len(b))
-		return b, e.boundAddrPorts[1]
+		senderIndex = 0
 	case from == e.boundAddrPorts[1]:
-		e.lastSeen[1] = now
-		e.packetsRx[1]++
-		e.bytesRx[1] += uint64(len(b))
-		return b, e.boundAddrPorts[0]
+		senderIndex = 1
 	default:
 		// unrecognized source
 		return nil, netip.AddrPort{}
 	}
+	e.lastSeen[senderIndex] = now
+	if e.limiter != nil && !e.limiter.allow(now, len(b)) {
+		e.packetsDropped[senderIndex]++
+		return nil, netip.AddrPort{}
+	}
+	e.packetsRx[senderIndex]++
+	e.bytesRx[senderIndex] += uint64(len(b))
+	return b, e.boundAddrPorts[1-senderIndex]
 }
 
 // maybeExpire checks if the endpoint has expired according to the provided timeouts and sets its closed state accordingly.
@@ -386,6 +396,7 @@ func NewServer(logf logger.Logf, port uint16, onlyStaticAddrPorts bool, metrics
 		closeCh:               make(chan struct{}),
 		onlyStaticAddrPorts:   onlyStaticAddrPorts,
 		serverEndpointByDisco: make(map[key.SortedPairOfDiscoPublic]*serverEndpoint),
+		sessionsByDisco:       make(map[key.DiscoPublic]int),
 		nextVNI:               minVNI,
 		cloudInfo:             cloudinfo.New(logf),
 	}
@@ -751,6 +762,7 @@ func (s *Server) Close() error {
 		defer s.mu.Unlock()
 		s.serverEndpointByVNI.Clear()
 		clear(s.serverEndpointByDisco)
+		clear(s.sessionsByDisco)
 		s.closed = true
 		s.bus.Close()
 		deregisterMetrics()
@@ -764,10 +776,23 @@ func (s *Server) endpointGC(bindLifetime, steadyStateLifetime time.Duration) {
 	// holding s.mu for the duration. Keep it simple (and slow) for now.
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	for k, v := range s.serverEndpointByDisco {
+	for _, v := range s.serverEndpointByDisco {
 		if v.maybeExpire(now, bindLifetime, steadyStateLifetime, s.metrics) {
-			delete(s.serverEndpointByDisco, k)
-			s.serverEndpointByVNI.Delete(v.vni)
+			s.deleteEndpointLocked(v)
+		}
+	}
+}
+
+// deleteEndpointLocked stops tracking the closed endpoint e. s.mu must be
+// held.
+func (s *Server) deleteEndpointLocked(e *serverEndpoint) {
+	delete(s.serverEndpointByDisco, e.discoPubKeys)
+	s.serverEndpointByVNI.Delete(e.vni)
+	for _, k := range e.discoPubKeys.Get() {
+		if s.sessionsByDisco[k] <= 1 {
+			delete(s.sessionsByDisco, k)
+		} else {
+			s.sessionsByDisco[k]--
 		}
 	}
 }
@@ -779,7 +804,10 @@ func (s *Server) endpointGCLoop() {
 	for {
 		select {
 		case <-ticker.C:
-			s.endpointGC(s.bindLifetime, s.steadyStateLifetime)
+			s.mu.Lock()
+			steadyStateLifetime := s.steadyStateLifetime
+			s.mu.Unlock()
+			s.endpointGC(s.bindLifetime, steadyStateLifetime)
 		case <-s.closeCh:
 			return
 		}
@@ -1007,6 +1035,8 @@ func (s *Server) getAllAddrPortsCopyLocked() []netip.AddrPort {
 // the following notable errors:
 //  1. [ErrServerClosed] if the server has been closed.
 //  2. [ErrServerNotReady] if the server is not ready.
+//  3. [ErrTooManySessions] or [ErrTooManySessionsForNode] if allocating
+//     would exceed the server's [Limits].
 func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.ServerEndpoint, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -1046,6 +1076,17 @@ func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.Serv
 		}, nil
 	}
 
+	if limit := s.limits.MaxSessions; limit > 0 && len(s.serverEndpointByDisco) >= limit {
+		return endpoint.ServerEndpoint{}, ErrTooManySessions
+	}
+	if limit := s.limits.MaxSessionsPerNode; limit > 0 {
+		for _, k := range pair.Get() {
+			if s.sessionsByDisco[k] >= limit {
+				return endpoint.ServerEndpoint{}, fmt.Errorf("%w %v", ErrTooManySessionsForNode, k.ShortString())
+			}
+		}
+	}
+
 	vni, err := s.getNextVNILocked()
 	if err != nil {
 		return endpoint.ServerEndpoint{}, err
@@ -1060,9 +1101,15 @@ func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.Serv
 	}
 	e.discoSharedSecrets[0] = s.disco.Shared(e.discoPubKeys.Get()[0])
 	e.discoSharedSecrets[1] = s.disco.Shared(e.discoPubKeys.Get()[1])
+	if r := s.limits.SessionBytesPerSec; r > 0 {
+		e.limiter = newByteLimiter(r)
+	}
 
 	s.serverEndpointByDisco[pair] = e
 	s.serverEndpointByVNI.Store(e.vni, e)
+	for _, k := range pair.Get() {
+		s.sessionsByDisco[k]++
+	}
 
 	s.logf("allocated endpoint vni=%d lamportID=%d disco[0]=%v disco[1]=%v", e.vni, e.lamportID, pair.Get()[0].ShortString(), pair.Get()[1].ShortString())
 	s.metrics.updateEndpoint(endpointClosed, endpointConnecting)
@@ -1088,6 +1135,7 @@ func (e *serverEndpoint) extractClientInfo() [2]status.ClientInfo {
 		ret[i].ShortDisco = e.discoPubKeys.Get()[i].ShortString()
 		ret[i].PacketsTx = e.packetsRx[i]
 		ret[i].BytesTx = e.bytesRx[i]
+		ret[i].PacketsDropped = e.packetsDropped[i]
 	}
 	return ret
 }
@@ -1114,6 +1162,59 @@ func (s *Server) GetSessions() []status.ServerSession {
 	return sessions
 }
 
+// CloseSession tears down the session with the provided VNI, as returned by
+// [Server.GetSessions]. Its clients may allocate a new one. CloseSession
+// returns [ErrNoSuchSession] if there's no such session.
+func (s *Server) CloseSession(vni uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	v, ok := s.serverEndpointByVNI.Load(vni)
+	if !ok {
+		return ErrNoSuchSession
+	}
+	e := v.(*serverEndpoint)
+	e.mu.Lock()
+	before := e.stateLocked()
+	e.closed = true
+	s.metrics.updateEndpoint(before, e.stateLocked())
+	e.mu.Unlock()
+	s.deleteEndpointLocked(e)
+	s.logf("closed endpoint vni=%d lamportID=%d disco[0]=%v disco[1]=%v", e.vni, e.lamportID, e.discoPubKeys.Get()[0].ShortString(), e.discoPubKeys.Get()[1].ShortString())
+	return nil
+}
+
+// SetLimits sets the limits on the resources the [Server] commits to
+// sessions, replacing any previously set. Lowering [Limits.MaxSessions] or
+// [Limits.MaxSessionsPerNode] doesn't close existing sessions, but the other
+// limits apply to existing sessions too.
+func (s *Server) SetLimits(limits Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = limits
+	s.steadyStateLifetime = cmp.Or(limits.IdleTimeout, defaultSteadyStateLifetime)
+	for _, e := range s.serverEndpointByDisco {
+		e.mu.Lock()
+		e.limiter = nil
+		if limits.SessionBytesPerSec > 0 {
+			e.limiter = newByteLimiter(limits.SessionBytesPerSec)
+		}
+		e.mu.Unlock()
+	}
+}
+
+// Limits returns the limits set with [Server.SetLimits], with the default
+// [Limits.IdleTimeout] filled in if it wasn't set.
+func (s *Server) Limits() Limits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	limits := s.limits
+	limits.IdleTimeout = s.steadyStateLifetime
+	return limits
+}
+
 // SetDERPMapView sets the [tailcfg.DERPMapView] to use for future netcheck
 // reports.
 func (s *Server) SetDERPMapView(view tailcfg.DERPMapView) {
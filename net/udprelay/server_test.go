@@ -545,3 +545,71 @@ func TestServer_endpointGC(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_limits(t *testing.T) {
+	disco := key.NewDisco()
+	s := &Server{
+		logf:                  t.Logf,
+		disco:                 disco,
+		discoPublic:           disco.Public(),
+		metrics:               &metrics{},
+		nextVNI:               minVNI,
+		serverEndpointByDisco: make(map[key.SortedPairOfDiscoPublic]*serverEndpoint),
+		sessionsByDisco:       make(map[key.DiscoPublic]int),
+		staticAddrPorts:       views.SliceOf([]netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:7777")}),
+	}
+	s.SetLimits(Limits{
+		MaxSessions:        3,
+		MaxSessionsPerNode: 2,
+		IdleTimeout:        time.Minute,
+	})
+	a, b, c, d := key.NewDisco().Public(), key.NewDisco().Public(), key.NewDisco().Public(), key.NewDisco().Public()
+
+	ab, err := s.AllocateEndpoint(a, b)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, ab.SteadyStateLifetime.Duration, qt.Equals, time.Minute)
+	_, err = s.AllocateEndpoint(a, c)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = s.AllocateEndpoint(a, d)
+	qt.Assert(t, err, qt.ErrorIs, ErrTooManySessionsForNode)
+	// Existing allocations aren't limited.
+	_, err = s.AllocateEndpoint(b, a)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = s.AllocateEndpoint(b, c)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = s.AllocateEndpoint(c, d)
+	qt.Assert(t, err, qt.ErrorIs, ErrTooManySessions)
+
+	qt.Assert(t, s.CloseSession(ab.VNI), qt.IsNil)
+	qt.Assert(t, s.CloseSession(ab.VNI), qt.ErrorIs, ErrNoSuchSession)
+	qt.Assert(t, s.sessionsByDisco[a], qt.Equals, 1)
+	qt.Assert(t, s.sessionsByDisco[b], qt.Equals, 1)
+	_, err = s.AllocateEndpoint(a, d)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, len(s.GetSessions()), qt.Equals, 3)
+
+	s.SetLimits(Limits{SessionBytesPerSec: 1000})
+	qt.Assert(t, s.Limits(), qt.Equals, Limits{SessionBytesPerSec: 1000, IdleTimeout: defaultSteadyStateLifetime})
+}
+
+func TestServerEndpoint_handleDataPacketLimited(t *testing.T) {
+	addrs := [2]netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:1"), netip.MustParseAddrPort("192.0.2.2:1")}
+	e := &serverEndpoint{
+		boundAddrPorts: addrs,
+		limiter:        newByteLimiter(1000),
+	}
+	now := mono.Now()
+	pkt := make([]byte, minByteLimiterBurst/2)
+	for range 2 {
+		_, to := e.handleDataPacket(addrs[0], pkt, now)
+		qt.Assert(t, to, qt.Equals, addrs[1])
+	}
+	_, to := e.handleDataPacket(addrs[1], pkt, now)
+	qt.Assert(t, to.IsValid(), qt.IsFalse)
+	qt.Assert(t, e.packetsRx, qt.Equals, [2]uint64{2, 0})
+	qt.Assert(t, e.packetsDropped, qt.Equals, [2]uint64{0, 1})
+	qt.Assert(t, e.lastSeen[1], qt.Equals, now)
+
+	_, to = e.handleDataPacket(addrs[1], pkt, now.Add(time.Duration(len(pkt))*time.Second/1000))
+	qt.Assert(t, to, qt.Equals, addrs[0])
+}
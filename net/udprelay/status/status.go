@@ -7,6 +7,7 @@
 
 import (
 	"net/netip"
+	"time"
 )
 
 // ServerStatus contains the listening UDP port and active sessions (if any) for
@@ -17,12 +18,34 @@ type ServerStatus struct {
 	// If the port has not been configured, UDPPort will be nil. A non-nil zero
 	// value signifies the user has opted for a random unused port.
 	UDPPort *uint16
+	// Limits are the limits on the resources the peer relay server commits
+	// to sessions, as configured by the user with 'tailscale set
+	// --relay-server-max-sessions' and similar flags. It's the zero value if
+	// the server isn't running.
+	Limits ServerLimits
 	// Sessions is a slice of detailed status information about each peer
 	// relay session that this node's peer relay server is involved with. It
 	// may be empty.
 	Sessions []ServerSession
 }
 
+// ServerLimits are the limits in effect on the resources a peer relay server
+// commits to sessions.
+type ServerLimits struct {
+	// MaxSessions is the maximum number of sessions relayed at once, or zero
+	// for no limit.
+	MaxSessions int
+	// MaxSessionsPerNode is the maximum number of sessions relayed at once
+	// that any one client is part of, or zero for no limit.
+	MaxSessionsPerNode int
+	// SessionBytesPerSec is the maximum rate in bytes per second at which a
+	// session is relayed, or zero for no limit.
+	SessionBytesPerSec int64
+	// IdleTimeout is how long a client of a session can go without sending
+	// anything before the session is torn down.
+	IdleTimeout time.Duration
+}
+
 // ClientInfo contains status-related information about a single peer relay
 // client involved in a single peer relay session.
 type ClientInfo struct {
@@ -51,6 +74,10 @@ type ClientInfo struct {
 	// is identical to the total overlay bytes that the peer relay server has
 	// received from this client.
 	BytesTx uint64
+	// PacketsDropped is the number of packets this peer relay client has sent
+	// via the relay server after completing a handshake that the server
+	// dropped, because they exceeded the session's bandwidth limit.
+	PacketsDropped uint64
 }
 
 // ServerSession contains status information for a single session between two
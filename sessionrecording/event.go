@@ -11,6 +11,7 @@
 
 const (
 	KubernetesAPIEventType = "kubernetes-api-request"
+	SFTPEventType          = "sftp-operation"
 )
 
 // Event represents the top-level structure of a tsrecorder event.
@@ -40,6 +41,46 @@ type Event struct {
 
 	// Destination provides details about the node receiving the request.
 	Destination Destination `json:"destination"`
+
+	// SFTP contains details about a file operation in an SSH session that
+	// is restricted to SFTP (if the type is `sftp-operation`).
+	SFTP *SFTPOperation `json:"sftp,omitempty"`
+}
+
+// SFTPOperation describes a file operation in a Tailscale SSH session that is
+// restricted to SFTP.
+type SFTPOperation struct {
+	// Op is the operation: one of "open", "read", "write", "rename",
+	// "remove", "mkdir", "rmdir", "setstat", "symlink" or "link".
+	//
+	// Reads and writes are reported once per open file, when it's closed.
+	Op string `json:"op"`
+
+	// Path is the path of the file on the destination node.
+	Path string `json:"path"`
+
+	// Target is the new path for renames, and the link's path for
+	// symlinks and links.
+	Target string `json:"target,omitempty"`
+
+	// Mode is how a file was opened: "r", "w" or "rw".
+	Mode string `json:"mode,omitempty"`
+
+	// Bytes is the number of bytes read or written.
+	Bytes int64 `json:"bytes,omitempty"`
+
+	// Error, if non-empty, is why the operation failed or was denied.
+	Error string `json:"error,omitempty"`
+
+	// SSHUser is the user name presented by the SSH client.
+	SSHUser string `json:"sshUser,omitempty"`
+
+	// LocalUser is the local user the session runs as.
+	LocalUser string `json:"localUser,omitempty"`
+
+	// ConnectionID and SessionID identify the SSH connection and session.
+	ConnectionID string `json:"connectionID,omitempty"`
+	SessionID    string `json:"sessionID,omitempty"`
 }
 
 // copied from https://github.com/kubernetes/kubernetes/blob/11ade2f7dd264c2f52a4a1342458abbbaa3cb2b1/staging/src/k8s.io/apiserver/pkg/endpoints/request/requestinfo.go#L44
@@ -0,0 +1,554 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// castSuffix and hashSuffix are the file name suffixes of recordings in a
+// [LocalStore] and of their integrity hashes.
+const (
+	castSuffix = ".cast"
+	hashSuffix = ".hmac"
+)
+
+// localStoreKeyLen is the length of the keys made by [LoadOrCreateKey].
+const localStoreKeyLen = 32
+
+// LocalStoreOptions are options for [NewLocalStore].
+type LocalStoreOptions struct {
+	// MaxSize is the maximum total size of the recordings, after which the
+	// oldest are deleted. If zero, there's no limit.
+	MaxSize int64
+
+	// MaxAge is how long to keep recordings, after which they're deleted.
+	// If zero, recordings are only deleted for size.
+	MaxAge time.Duration
+
+	// Key is the key for the HMAC-SHA256 written next to each recording.
+	// It should be kept somewhere only the process writing recordings can
+	// read, such as a file made by [LoadOrCreateKey]. If empty, no hashes
+	// are written and all recordings are unverified.
+	Key []byte
+}
+
+// LocalStore is a directory of session recordings on local disk, for nodes
+// that record sessions without a recorder node.
+//
+// Each recording is a file whose name ends in ".cast", in the same format
+// that's sent to recorder nodes. When a recording is complete, an
+// HMAC-SHA256 of its name and contents, keyed with the store's Key, is
+// written next to it in hex in a file with ".hmac" appended to its name, so
+// that later changes to the recording can be detected.
+//
+// The hash only detects changes made by someone who can't read the key.
+// Anyone who can, such as root on the node, can rewrite a recording and its
+// hash so that it verifies. Stores that need to be tamper-proof against the
+// node's administrators should send recordings to a recorder node instead.
+type LocalStore struct {
+	dir  string
+	opts LocalStoreOptions // MaxSize and MaxAge are guarded by mu
+
+	mu   sync.Mutex
+	open map[string]bool // names of recordings being written
+}
+
+// NewLocalStore returns a LocalStore for the recordings in dir, which is
+// created when the first recording is.
+func NewLocalStore(dir string, opts LocalStoreOptions) *LocalStore {
+	return &LocalStore{
+		dir:  dir,
+		opts: opts,
+		open: make(map[string]bool),
+	}
+}
+
+// SetLimits changes the store's MaxSize and MaxAge. The new limits are
+// applied when the next recording is created or finished.
+func (s *LocalStore) SetLimits(maxSize int64, maxAge time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opts.MaxSize = maxSize
+	s.opts.MaxAge = maxAge
+}
+
+// Dir returns the directory the recordings are in.
+func (s *LocalStore) Dir() string {
+	return s.dir
+}
+
+// Create creates a new recording, with a file name starting with prefix
+// and the time it started. Before doing so, it deletes old recordings as
+// needed to stay within the store's limits.
+//
+// Closing the returned writer writes the recording's hash.
+func (s *LocalStore) Create(prefix string, start time.Time) (io.WriteCloser, error) {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(start)
+	f, err := os.CreateTemp(s.dir, fmt.Sprintf("%s-%v-*%s", prefix, start.UnixNano(), castSuffix))
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(f.Name())
+	s.open[name] = true
+	return &localWriter{s: s, f: f, name: name, h: s.newHash(name)}, nil
+}
+
+// newHash returns the HMAC for the named recording, which has already been
+// fed its name, or nil if the store has no key.
+func (s *LocalStore) newHash(name string) hash.Hash {
+	if len(s.opts.Key) == 0 {
+		return nil
+	}
+	h := hmac.New(sha256.New, s.opts.Key)
+	io.WriteString(h, name)
+	h.Write([]byte{0})
+	return h
+}
+
+// LoadOrCreateKey returns the key in the file at path for
+// [LocalStoreOptions.Key], first creating the file with a random key,
+// readable only by the current user, if it doesn't exist.
+func LoadOrCreateKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != localStoreKeyLen {
+			return nil, fmt.Errorf("recording key %s has length %d; want %d", path, len(key), localStoreKeyLen)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	key = make([]byte, localStoreKeyLen)
+	rand.Read(key)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return LoadOrCreateKey(path) // created concurrently
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(key)
+	if serr := f.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return key, nil
+}
+
+// localWriter writes a recording in a LocalStore.
+type localWriter struct {
+	s    *LocalStore
+	f    *os.File
+	name string
+	h    hash.Hash
+}
+
+func (w *localWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	if w.h != nil {
+		w.h.Write(p[:n])
+	}
+	return n, err
+}
+
+// Close closes the recording, writes its hash and deletes old recordings as
+// needed to stay within the store's limits.
+func (w *localWriter) Close() error {
+	defer func() {
+		w.s.mu.Lock()
+		defer w.s.mu.Unlock()
+		delete(w.s.open, w.name)
+		w.s.pruneLocked(time.Now())
+	}()
+	err := w.f.Sync()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || w.h == nil {
+		return err
+	}
+	sum := fmt.Sprintf("%x\n", w.h.Sum(nil))
+	path := filepath.Join(w.s.dir, w.name+hashSuffix)
+	if err := os.WriteFile(path+".tmp", []byte(sum), 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// pruneLocked deletes the recordings that are older than the store's MaxAge,
+// then the oldest recordings until the rest fit in its MaxSize. Recordings
+// still being written are kept.
+func (s *LocalStore) pruneLocked(now time.Time) {
+	if s.opts.MaxSize <= 0 && s.opts.MaxAge <= 0 {
+		return
+	}
+	recs, err := s.files()
+	if err != nil {
+		return
+	}
+	var total int64
+	for _, fi := range recs {
+		total += fi.Size()
+	}
+	for _, fi := range recs {
+		tooOld := s.opts.MaxAge > 0 && now.Sub(fi.ModTime()) > s.opts.MaxAge
+		tooBig := s.opts.MaxSize > 0 && total > s.opts.MaxSize
+		if !tooOld && !tooBig {
+			continue
+		}
+		if s.open[fi.Name()] {
+			continue
+		}
+		path := filepath.Join(s.dir, fi.Name())
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		os.Remove(path + hashSuffix)
+		total -= fi.Size()
+	}
+}
+
+// files returns the recordings in the store, oldest first.
+func (s *LocalStore) files() ([]fs.FileInfo, error) {
+	des, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var fis []fs.FileInfo
+	for _, de := range des {
+		if !de.Type().IsRegular() || !strings.HasSuffix(de.Name(), castSuffix) {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue // removed concurrently
+		}
+		fis = append(fis, fi)
+	}
+	slices.SortFunc(fis, func(a, b fs.FileInfo) int { return a.ModTime().Compare(b.ModTime()) })
+	return fis, nil
+}
+
+// Integrity is the result of checking a recording against its hash.
+type Integrity string
+
+const (
+	// IntegrityOK means the recording matches its hash.
+	IntegrityOK Integrity = "ok"
+	// IntegrityModified means the recording doesn't match its hash, so it
+	// has been changed since it was written.
+	IntegrityModified Integrity = "modified"
+	// IntegrityUnverified means the recording has no hash, because it's
+	// still being written, the process writing it stopped, or the store
+	// has no key.
+	IntegrityUnverified Integrity = "unverified"
+)
+
+// LocalRecording describes a recording in a [LocalStore].
+type LocalRecording struct {
+	// Name is the recording's file name.
+	Name string
+
+	// Size is the size of the recording in bytes.
+	Size int64
+
+	// ModTime is when the recording was last written to.
+	ModTime time.Time
+
+	// Header is the recording's header, or nil if it can't be read.
+	Header *CastHeader `json:",omitempty"`
+
+	// Integrity is the result of checking the recording against its hash.
+	Integrity Integrity
+}
+
+// List returns the recordings in the store, oldest first, checking each
+// against its hash.
+func (s *LocalStore) List() ([]LocalRecording, error) {
+	fis, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	recs := make([]LocalRecording, 0, len(fis))
+	for _, fi := range fis {
+		rec := LocalRecording{
+			Name:    fi.Name(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		}
+		rec.Integrity, err = s.Verify(fi.Name())
+		if err != nil {
+			continue // removed concurrently
+		}
+		if f, err := s.Open(fi.Name()); err == nil {
+			if cr, err := NewCastReader(f); err == nil {
+				rec.Header = &cr.Header
+			}
+			f.Close()
+		}
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+// Open opens the named recording for reading.
+func (s *LocalStore) Open(name string) (*os.File, error) {
+	if !isLocalRecordingName(name) {
+		return nil, fmt.Errorf("invalid recording name %q", name)
+	}
+	return os.Open(filepath.Join(s.dir, name))
+}
+
+// Verify checks the named recording against its hash.
+func (s *LocalStore) Verify(name string) (Integrity, error) {
+	f, err := s.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sum, err := os.ReadFile(filepath.Join(s.dir, name+hashSuffix))
+	if errors.Is(err, fs.ErrNotExist) {
+		return IntegrityUnverified, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	h := s.newHash(name)
+	if h == nil {
+		return IntegrityUnverified, nil
+	}
+	want, err := hex.DecodeString(strings.TrimSpace(string(sum)))
+	if err != nil {
+		return IntegrityModified, nil
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if !hmac.Equal(h.Sum(nil), want) {
+		return IntegrityModified, nil
+	}
+	return IntegrityOK, nil
+}
+
+// isLocalRecordingName reports whether name is a valid name for a recording
+// in a LocalStore, and not a path elsewhere.
+func isLocalRecordingName(name string) bool {
+	return strings.HasSuffix(name, castSuffix) &&
+		!strings.HasPrefix(name, ".") &&
+		!strings.ContainsAny(name, `/\`)
+}
+
+// CastEvent is an event in a recording, encoded in JSON as a
+// [Time, Type, Data] array.
+type CastEvent struct {
+	// Time is when the event happened, in seconds since the recording
+	// started.
+	Time float64
+
+	// Type is the type of event: "o" for output, "i" for input, "r" for a
+	// terminal resize or "m" for a marker.
+	Type string
+
+	// Data is the output or input, "COLSxROWS" for a resize, or the marker's
+	// label.
+	Data string
+}
+
+// MarshalJSON implements [json.Marshaler].
+func (e CastEvent) MarshalJSON() ([]byte, error) {
+	// Don't escape HTML, which terminal output is full of.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode([]any{e.Time, e.Type, e.Data}); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// UnmarshalJSON implements [json.Unmarshaler].
+func (e *CastEvent) UnmarshalJSON(b []byte) error {
+	var a []json.RawMessage
+	if err := json.Unmarshal(b, &a); err != nil {
+		return err
+	}
+	if len(a) != 3 {
+		return fmt.Errorf("cast event has %d elements; want 3", len(a))
+	}
+	if err := json.Unmarshal(a[0], &e.Time); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(a[1], &e.Type); err != nil {
+		return err
+	}
+	return json.Unmarshal(a[2], &e.Data)
+}
+
+// CastReader reads a recording: a JSON header line followed by a line for
+// each event.
+type CastReader struct {
+	// Header is the recording's header.
+	Header CastHeader
+
+	br *bufio.Reader
+}
+
+// NewCastReader returns a CastReader for the recording in r, having read
+// its header.
+func NewCastReader(r io.Reader) (*CastReader, error) {
+	cr := &CastReader{br: bufio.NewReader(r)}
+	line, err := cr.readLine()
+	if err == io.EOF {
+		return nil, errors.New("empty recording")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(line, &cr.Header); err != nil {
+		return nil, fmt.Errorf("reading recording header: %w", err)
+	}
+	return cr, nil
+}
+
+// Next returns the next event in the recording, or io.EOF at its end.
+func (cr *CastReader) Next() (CastEvent, error) {
+	var e CastEvent
+	line, err := cr.readLine()
+	if err != nil {
+		return e, err
+	}
+	if err := json.Unmarshal(line, &e); err != nil {
+		return e, fmt.Errorf("reading recording event: %w", err)
+	}
+	return e, nil
+}
+
+// readLine returns the next non-empty line, or io.EOF. A final line
+// without a newline, as left by an interrupted recording, is ignored.
+func (cr *CastReader) readLine() ([]byte, error) {
+	for {
+		line, err := cr.br.ReadBytes('\n')
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, nil
+		}
+	}
+}
+
+// asciinemaHeader is the header of an asciinema v2 recording
+// (https://docs.asciinema.org/manual/asciicast/v2/).
+type asciinemaHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// WriteAsciinema writes the recording read from r to w as a plain asciinema
+// v2 recording, which asciinema and other players can replay. The
+// Tailscale-specific header fields are summarized in its title.
+func WriteAsciinema(w io.Writer, r io.Reader) error {
+	cr, err := NewCastReader(r)
+	if err != nil {
+		return err
+	}
+	h := asciinemaHeader{
+		Version:   2,
+		Width:     cr.Header.Width,
+		Height:    cr.Header.Height,
+		Timestamp: cr.Header.Timestamp,
+		Command:   cr.Header.Command,
+		Title:     cr.Header.Title(),
+		Env:       cr.Header.Env,
+	}
+	if h.Width == 0 || h.Height == 0 {
+		// Sessions without a pty have no size, but players need one.
+		h.Width, h.Height = 80, 24
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(h); err != nil {
+		return err
+	}
+	for {
+		e, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch e.Type {
+		case "o", "i", "r", "m":
+		default:
+			continue
+		}
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Title returns a one-line description of the session, like
+// "alice@example.com on laptop.example.ts.net as root".
+func (h *CastHeader) Title() string {
+	var sb strings.Builder
+	who := h.SrcNodeUser
+	if who == "" {
+		who = strings.Join(h.SrcNodeTags, ",")
+	}
+	if who != "" {
+		sb.WriteString(who)
+		sb.WriteString(" on ")
+	}
+	sb.WriteString(h.SrcNode)
+	if h.LocalUser != "" {
+		sb.WriteString(" as ")
+		sb.WriteString(h.LocalUser)
+	}
+	return sb.String()
+}
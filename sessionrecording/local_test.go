@@ -0,0 +1,188 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeTestRecording(t *testing.T, s *LocalStore, start time.Time, events ...CastEvent) string {
+	t.Helper()
+	w, err := s.Create("ssh-session", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr, err := json.Marshal(CastHeader{
+		Version:     2,
+		Timestamp:   start.Unix(),
+		SrcNode:     "laptop.example.ts.net",
+		SrcNodeUser: "alice@example.com",
+		SSHUser:     "root",
+		LocalUser:   "root",
+		Env:         map[string]string{"TERM": "xterm"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(w, "%s\n", hdr)
+	for _, e := range events {
+		j, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(w, "%s\n", j)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Base(w.(*localWriter).f.Name())
+}
+
+var testKey = bytes.Repeat([]byte{'k'}, localStoreKeyLen)
+
+func TestLocalStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ssh-sessions")
+	s := NewLocalStore(dir, LocalStoreOptions{Key: testKey})
+	start := time.Unix(1_800_000_000, 0)
+	name := writeTestRecording(t, s, start,
+		CastEvent{Time: 0.5, Type: "o", Data: "$ "},
+		CastEvent{Time: 1.25, Type: "o", Data: "ls\r\n"},
+	)
+	if !strings.HasPrefix(name, "ssh-session-1800000000000000000-") {
+		t.Errorf("name = %q", name)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, name+".hmac")); err != nil {
+		t.Errorf("no hash file: %v", err)
+	}
+
+	recs, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("got %d recordings; want 1", len(recs))
+	}
+	if got := recs[0]; got.Name != name || got.Integrity != IntegrityOK || got.Header == nil || got.Header.SSHUser != "root" {
+		t.Errorf("recording = %+v", got)
+	}
+
+	// The hash is only valid with the store's key.
+	other := NewLocalStore(dir, LocalStoreOptions{Key: bytes.Repeat([]byte{'x'}, localStoreKeyLen)})
+	if got, err := other.Verify(name); err != nil || got != IntegrityModified {
+		t.Errorf("Verify with other key = %v, %v; want %v", got, err, IntegrityModified)
+	}
+	if got, err := NewLocalStore(dir, LocalStoreOptions{}).Verify(name); err != nil || got != IntegrityUnverified {
+		t.Errorf("Verify without key = %v, %v; want %v", got, err, IntegrityUnverified)
+	}
+
+	// Changing the recording is detected.
+	path := filepath.Join(dir, name)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, bytes.Replace(b, []byte("ls"), []byte("rm"), 1), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Verify(name); err != nil || got != IntegrityModified {
+		t.Errorf("Verify = %v, %v; want %v", got, err, IntegrityModified)
+	}
+	os.Remove(path + ".hmac")
+	if got, err := s.Verify(name); err != nil || got != IntegrityUnverified {
+		t.Errorf("Verify = %v, %v; want %v", got, err, IntegrityUnverified)
+	}
+
+	for _, bad := range []string{"../foo.cast", "foo", ".cast", "a/b.cast"} {
+		if _, err := s.Open(bad); err == nil {
+			t.Errorf("Open(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestLocalStoreRetention(t *testing.T) {
+	dir := t.TempDir()
+	s := NewLocalStore(dir, LocalStoreOptions{MaxAge: time.Hour, Key: testKey})
+	start := time.Now()
+	old := writeTestRecording(t, s, start.Add(-3*time.Hour))
+	oldTime := start.Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, old), oldTime, oldTime); err != nil {
+		t.Fatal(err)
+	}
+	recent := writeTestRecording(t, s, start)
+	if _, err := os.Stat(filepath.Join(dir, old)); !os.IsNotExist(err) {
+		t.Errorf("old recording not deleted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, old+".hmac")); !os.IsNotExist(err) {
+		t.Errorf("old hash not deleted: %v", err)
+	}
+
+	// Limit the size so that only one recording fits.
+	fi, err := os.Stat(filepath.Join(dir, recent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLimits(fi.Size()+10, time.Hour)
+	newest := writeTestRecording(t, s, start.Add(time.Second))
+	recs, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].Name != newest {
+		t.Errorf("got recordings %+v; want only %q", recs, newest)
+	}
+}
+
+func TestLoadOrCreateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssh-recordings.key")
+	key, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != localStoreKeyLen {
+		t.Fatalf("key length = %d; want %d", len(key), localStoreKeyLen)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm()&0077 != 0 {
+		t.Errorf("key file = %v, %v; want readable only by owner", fi, err)
+	}
+	again, err := LoadOrCreateKey(path)
+	if err != nil || !bytes.Equal(again, key) {
+		t.Errorf("LoadOrCreateKey again = %x, %v; want %x", again, err, key)
+	}
+	if err := os.WriteFile(path, []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOrCreateKey(path); err == nil {
+		t.Error("LoadOrCreateKey succeeded with a short key")
+	}
+}
+
+func TestWriteAsciinema(t *testing.T) {
+	in := `{"version":2,"width":0,"height":0,"timestamp":1800000000,"srcNode":"laptop.example.ts.net","srcNodeID":"n1","srcNodeUser":"alice@example.com","env":{"TERM":"xterm"},"sshUser":"root","localUser":"root","connectionID":"c1"}
+[0.5,"o","$ <b>"]
+[0.75,"x","unknown"]
+
+[1.25,"o","ls\r\n"]
+[1.5,"o","trunc`
+	want := `{"version":2,"width":80,"height":24,"timestamp":1800000000,"title":"alice@example.com on laptop.example.ts.net as root","env":{"TERM":"xterm"}}
+[0.5,"o","$ <b>"]
+[1.25,"o","ls\r\n"]
+`
+	var out bytes.Buffer
+	if err := WriteAsciinema(&out, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -213,6 +213,17 @@ func (ss *sshSession) newIncubatorCommand(logf logger.Logf) (cmd *exec.Cmd, err
 	}
 
 	switch {
+	case isSFTP && ss.conn.finalAction.SFTPOnly != nil:
+		// SFTP-only sessions are always served by the incubator itself, so
+		// that nothing runs as the user, not even their login shell.
+		sftpOnly := ss.conn.finalAction.SFTPOnly
+		incubatorArgs = append(incubatorArgs, "--sftp", "--sftp-only", "--sftp-root="+ss.sftpOnlyRoot())
+		if sftpOnly.ReadOnly {
+			incubatorArgs = append(incubatorArgs, "--sftp-read-only")
+		}
+		if sftpOnly.WriteOnly {
+			incubatorArgs = append(incubatorArgs, "--sftp-write-only")
+		}
 	case isSFTP:
 		// Note that we include both the `--sftp` flag and a command to launch
 		// tailscaled as `be-child sftp`. If login or su is available, and
@@ -280,6 +291,10 @@ type incubatorArgs struct {
 	hasTTY             bool
 	cmd                string
 	isSFTP             bool
+	sftpOnly           bool
+	sftpRoot           string
+	sftpReadOnly       bool
+	sftpWriteOnly      bool
 	isShell            bool
 	forceV1Behavior    bool
 	debugTest          bool
@@ -305,6 +320,10 @@ func parseIncubatorArgs(args []string) (incubatorArgs, error) {
 	flags.StringVar(&ia.cmd, "cmd", "", "the cmd to launch, including all arguments (ignored in sftp mode)")
 	flags.BoolVar(&ia.isShell, "shell", false, "is launching a shell (with no cmds)")
 	flags.BoolVar(&ia.isSFTP, "sftp", false, "run sftp server (cmd is ignored)")
+	flags.BoolVar(&ia.sftpOnly, "sftp-only", false, "serve sftp for an SFTP-only session, auditing file operations")
+	flags.StringVar(&ia.sftpRoot, "sftp-root", "", "directory to confine an SFTP-only session to")
+	flags.BoolVar(&ia.sftpReadOnly, "sftp-read-only", false, "only permit reading files in an SFTP-only session")
+	flags.BoolVar(&ia.sftpWriteOnly, "sftp-write-only", false, "only permit writing files in an SFTP-only session")
 	flags.BoolVar(&ia.forceV1Behavior, "force-v1-behavior", false, "allow falling back to the su command if login is unavailable")
 	flags.BoolVar(&ia.debugTest, "debug-test", false, "should debug in test mode")
 	flags.BoolVar(&ia.isSELinuxEnforcing, "is-selinux-enforcing", false, "whether SELinux is in enforcing mode")
@@ -445,6 +464,9 @@ func handleSFTPInProcess(dlogf logger.Logf, ia incubatorArgs) error {
 		return err
 	}
 
+	if ia.sftpOnly {
+		return serveSFTPOnly(ia)
+	}
 	return serveSFTP()
 }
 
@@ -479,6 +501,10 @@ func serveSFTP() error {
 // the incubator to launch the shell.
 // See http://github.com/tailscale/tailscale/issues/4908.
 func shouldAttemptLoginShell(dlogf logger.Logf, ia incubatorArgs) bool {
+	if ia.sftpOnly {
+		dlogf("SFTP-only session, won't use login shell")
+		return false
+	}
 	if ia.forceV1Behavior && ia.isSFTP {
 		// v1 behavior did not run SFTP within a login shell.
 		dlogf("Forcing v1 behavior, won't use login shell for SFTP")
@@ -862,6 +888,18 @@ func (ss *sshSession) launchProcess() error {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("SSH_AUTH_SOCK=%s", ss.agentListener.Addr()))
 	}
 
+	if ss.conn.finalAction.SFTPOnly != nil {
+		auditRd, auditWr, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		// The incubator has its own copy of auditWr once it's started,
+		// and forwardSFTPAudit returns when that's closed.
+		defer auditWr.Close()
+		cmd.ExtraFiles = []*os.File{auditWr} // sftpAuditFD
+		go ss.forwardSFTPAudit(auditRd)
+	}
+
 	ptyReq, winCh, isPty := ss.Pty()
 	if !isPty {
 		ss.logf("starting non-pty command: %+v", cmd.Args)
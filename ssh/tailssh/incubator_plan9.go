@@ -345,6 +345,10 @@ func newCommand(cmdPath string, cmdEnviron []string, cmdArgs []string) *exec.Cmd
 //
 // It sets ss.cmd, stdin, stdout, and stderr.
 func (ss *sshSession) launchProcess() error {
+	if ss.conn.finalAction.SFTPOnly != nil {
+		return errors.New("SFTP-only sessions are not supported on Plan 9")
+	}
+
 	var err error
 	ss.cmd, err = ss.newIncubatorCommand(ss.logf)
 	if err != nil {
@@ -0,0 +1,145 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build (linux && !android) || (darwin && !ios) || freebsd || openbsd || plan9
+
+package tailssh
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"tailscale.com/envknob"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/localapi"
+	"tailscale.com/sessionrecording"
+)
+
+// debugLogSSH is the deprecated dev knob that ipn.Prefs.SSHRecordLocally
+// replaced.
+var debugLogSSH = envknob.RegisterBool("TS_DEBUG_LOG_SSH")
+
+// recordSSHToLocalDisk reports whether SSH sessions should be recorded to
+// local disk when there are no recorders configured.
+func recordSSHToLocalDisk(prefs ipn.PrefsView) bool {
+	return prefs.SSHRecordLocally() || debugLogSSH()
+}
+
+var (
+	localStoresMu sync.Mutex
+	localStores   map[string]*sessionrecording.LocalStore // by var root
+)
+
+// localRecordingStore returns the store for local recordings under varRoot,
+// with the retention limits in prefs.
+func localRecordingStore(varRoot string, prefs ipn.PrefsView) (*sessionrecording.LocalStore, error) {
+	if varRoot == "" {
+		return nil, errors.New("no var root for recording storage")
+	}
+	maxSizeMB := cmp.Or(prefs.SSHRecordingMaxSizeMB(), ipn.DefaultSSHRecordingMaxSizeMB)
+	maxSize := max(int64(maxSizeMB), 0) << 20
+	maxAge := prefs.SSHRecordingMaxAge().Duration
+
+	localStoresMu.Lock()
+	defer localStoresMu.Unlock()
+	if s, ok := localStores[varRoot]; ok {
+		s.SetLimits(maxSize, maxAge)
+		return s, nil
+	}
+	// The key is kept outside the recordings directory, so that copying
+	// that elsewhere doesn't give away the means to forge recordings.
+	key, err := sessionrecording.LoadOrCreateKey(filepath.Join(varRoot, "ssh-recordings.key"))
+	if err != nil {
+		return nil, err
+	}
+	s := sessionrecording.NewLocalStore(filepath.Join(varRoot, "ssh-sessions"), sessionrecording.LocalStoreOptions{
+		MaxSize: maxSize,
+		MaxAge:  maxAge,
+		Key:     key,
+	})
+	if localStores == nil {
+		localStores = make(map[string]*sessionrecording.LocalStore)
+	}
+	localStores[varRoot] = s
+	return s, nil
+}
+
+func init() {
+	localapi.Register("ssh-recordings/", serveSSHRecordings)
+}
+
+// serveSSHRecordings is the LocalAPI handler for SSH session recordings on
+// local disk.
+//
+// URL format:
+//
+//   - GET /localapi/v0/ssh-recordings/ lists the recordings, as a JSON
+//     array of [sessionrecording.LocalRecording].
+//   - GET /localapi/v0/ssh-recordings/:name returns a recording, with the
+//     result of checking it against its hash in the
+//     Tailscale-Recording-Integrity header.
+func serveSSHRecordings(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	// Recordings can contain anything shown in a session, including
+	// sessions as root, so they're only for local admins. Being allowed to
+	// change tailscaled's settings, as the operator is, isn't enough.
+	if !h.PermitWrite || h.Actor == nil || !h.Actor.IsLocalAdmin(h.LocalBackend().OperatorUserID()) {
+		http.Error(w, "SSH recording access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	suffix, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/ssh-recordings/")
+	if !ok {
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	lb := h.LocalBackend()
+	s, err := localRecordingStore(lb.TailscaleVarRoot(), lb.Prefs())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if suffix == "" {
+		recs, err := s.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(recs)
+		return
+	}
+	name, err := url.PathUnescape(suffix)
+	if err != nil {
+		http.Error(w, "bad recording name", http.StatusBadRequest)
+		return
+	}
+	integrity, err := s.Verify(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "no such recording", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f, err := s.Open(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Tailscale-Recording-Integrity", string(integrity))
+	io.Copy(w, f)
+}
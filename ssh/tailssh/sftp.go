@@ -0,0 +1,523 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build (linux && !android) || (darwin && !ios) || freebsd || openbsd
+
+// This file contains the SFTP server for sessions restricted to SFTP by
+// tailcfg.SSHAction.SFTPOnly, and the tailscaled side of its audit trail.
+
+package tailssh
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+	"tailscale.com/sessionrecording"
+	"tailscale.com/tempfork/gliderlabs/ssh"
+)
+
+// sftpAuditFD is the file descriptor on which the incubator of an SFTP-only
+// session reports file operations to tailscaled, as a stream of JSON
+// sessionrecording.SFTPOperation values.
+const sftpAuditFD = 3
+
+// sftpFS is the file system served to SFTP-only sessions. It's implemented
+// by *os.Root for sessions confined to a directory, and by osFS otherwise.
+type sftpFS interface {
+	OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error)
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	Readlink(name string) (string, error)
+	Mkdir(name string, perm fs.FileMode) error
+	Remove(name string) error
+	Rename(oldname, newname string) error
+	Symlink(oldname, newname string) error
+	Link(oldname, newname string) error
+	Chmod(name string, mode fs.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Chown(name string, uid, gid int) error
+}
+
+// osFS is an sftpFS for the whole file system.
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+func (osFS) Stat(name string) (fs.FileInfo, error)     { return os.Stat(name) }
+func (osFS) Lstat(name string) (fs.FileInfo, error)    { return os.Lstat(name) }
+func (osFS) Readlink(name string) (string, error)      { return os.Readlink(name) }
+func (osFS) Mkdir(name string, perm fs.FileMode) error { return os.Mkdir(name, perm) }
+func (osFS) Remove(name string) error                  { return os.Remove(name) }
+func (osFS) Rename(oldname, newname string) error      { return os.Rename(oldname, newname) }
+func (osFS) Symlink(oldname, newname string) error     { return os.Symlink(oldname, newname) }
+func (osFS) Link(oldname, newname string) error        { return os.Link(oldname, newname) }
+func (osFS) Chmod(name string, mode fs.FileMode) error { return os.Chmod(name, mode) }
+func (osFS) Chown(name string, uid, gid int) error     { return os.Chown(name, uid, gid) }
+func (osFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// sftpHandler implements the pkg/sftp request server handlers for SFTP-only
+// sessions, enforcing their read-only or write-only mode and auditing every
+// file operation.
+type sftpHandler struct {
+	fs        sftpFS
+	root      string // directory that fs is confined to, or empty
+	readOnly  bool
+	writeOnly bool
+	audit     func(sessionrecording.SFTPOperation)
+}
+
+// name returns the name in h.fs of the client's path p.
+func (h *sftpHandler) name(p string) string {
+	if h.root == "" {
+		return p
+	}
+	// An *os.Root only accepts names relative to it.
+	if p = strings.TrimPrefix(path.Clean("/"+p), "/"); p == "" {
+		return "."
+	}
+	return p
+}
+
+// hostPath returns the path on this machine of the client's path p.
+func (h *sftpHandler) hostPath(p string) string {
+	if h.root == "" {
+		return p
+	}
+	return filepath.Join(h.root, h.name(p))
+}
+
+// record audits op, which failed if err is non-nil.
+func (h *sftpHandler) record(op sessionrecording.SFTPOperation, err error) {
+	if err != nil {
+		op.Error = err.Error()
+	}
+	h.audit(op)
+}
+
+// deny audits op as denied by the session's mode and returns the error for
+// the client.
+func (h *sftpHandler) deny(op sessionrecording.SFTPOperation) error {
+	h.record(op, fs.ErrPermission)
+	return sftp.ErrSSHFxPermissionDenied
+}
+
+// Fileread implements sftp.FileReader.
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	f, err := h.open(r, "r")
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Filewrite implements sftp.FileWriter.
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	f, err := h.open(r, "w")
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// OpenFile implements sftp.OpenFileWriter.
+func (h *sftpHandler) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	mode := "rw"
+	if h.writeOnly {
+		// Some clients open files for reading and writing just to upload
+		// them. Let them, but without being able to read the file.
+		mode = "w"
+	}
+	f, err := h.open(r, mode)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (h *sftpHandler) open(r *sftp.Request, mode string) (*sftpFile, error) {
+	op := sessionrecording.SFTPOperation{Op: "open", Path: h.hostPath(r.Filepath), Mode: mode}
+	var flag int
+	var denied bool
+	switch mode {
+	case "r":
+		flag, denied = os.O_RDONLY, h.writeOnly
+	case "w":
+		flag, denied = os.O_WRONLY, h.readOnly
+	default:
+		flag, denied = os.O_RDWR, h.readOnly
+	}
+	if denied {
+		return nil, h.deny(op)
+	}
+	if h.writeOnly {
+		// Write-only sessions can only create new files, so that they
+		// can't truncate or overwrite existing ones.
+		flag |= os.O_CREATE | os.O_EXCL
+	} else if mode != "r" {
+		// Writes are always at explicit offsets, so O_APPEND is
+		// ignored, as it is by OpenSSH's sftp-server.
+		pf := r.Pflags()
+		if pf.Creat {
+			flag |= os.O_CREATE
+		}
+		if pf.Trunc {
+			flag |= os.O_TRUNC
+		}
+		if pf.Excl {
+			flag |= os.O_EXCL
+		}
+	}
+	f, err := h.fs.OpenFile(h.name(r.Filepath), flag, 0666)
+	h.record(op, err)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{f: f, h: h, path: op.Path, mode: mode}, nil
+}
+
+// sftpFile is a file opened by an SFTP client. It counts the bytes
+// transferred to audit them when it's closed.
+type sftpFile struct {
+	f    *os.File
+	h    *sftpHandler
+	path string // on this machine
+	mode string // "r", "w" or "rw"
+
+	read, written atomic.Int64
+}
+
+func (f *sftpFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.f.ReadAt(b, off)
+	f.read.Add(int64(n))
+	return n, err
+}
+
+func (f *sftpFile) WriteAt(b []byte, off int64) (int, error) {
+	n, err := f.f.WriteAt(b, off)
+	f.written.Add(int64(n))
+	return n, err
+}
+
+func (f *sftpFile) Close() error {
+	err := f.f.Close()
+	if strings.Contains(f.mode, "r") {
+		f.h.record(sessionrecording.SFTPOperation{Op: "read", Path: f.path, Bytes: f.read.Load()}, nil)
+	}
+	if strings.Contains(f.mode, "w") {
+		f.h.record(sessionrecording.SFTPOperation{Op: "write", Path: f.path, Bytes: f.written.Load()}, err)
+	}
+	return err
+}
+
+// Filecmd implements sftp.FileCmder.
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	op := sessionrecording.SFTPOperation{Op: strings.ToLower(r.Method), Path: h.hostPath(r.Filepath)}
+	var allowed bool
+	switch r.Method {
+	case "Rename", "PosixRename":
+		op.Op = "rename"
+		op.Target = h.hostPath(r.Target)
+		allowed = !h.readOnly
+		if h.writeOnly {
+			// Write-only sessions can't replace existing files, which
+			// would delete them, so they can't do POSIX renames, and
+			// their renames never replace anything. See filecmd.
+			allowed = r.Method == "Rename"
+		}
+	case "Mkdir":
+		allowed = !h.readOnly
+	case "Setstat":
+		// Write-only sessions can't truncate files, nor give them away.
+		flags := r.AttrFlags()
+		allowed = !h.readOnly && !(h.writeOnly && (flags.Size || flags.UidGid))
+	case "Remove", "Rmdir", "Link":
+		allowed = !h.readOnly && !h.writeOnly
+		if r.Method == "Link" {
+			op.Target = h.hostPath(r.Target)
+		}
+	case "Symlink":
+		// The request's Filepath is the contents of the symlink, not a
+		// path to resolve.
+		op.Path = r.Filepath
+		op.Target = h.hostPath(r.Target)
+		allowed = !h.readOnly && !h.writeOnly
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	if !allowed {
+		return h.deny(op)
+	}
+	err := h.filecmd(r)
+	h.record(op, err)
+	return err
+}
+
+func (h *sftpHandler) filecmd(r *sftp.Request) error {
+	name := h.name(r.Filepath)
+	switch r.Method {
+	case "Rename":
+		if h.writeOnly {
+			// Checking that the target doesn't exist before renaming
+			// races with its creation, so link the file to its new name
+			// instead, which fails if the name is taken. That can't
+			// rename directories, which write-only sessions have to
+			// create where they want them.
+			if err := h.fs.Link(name, h.name(r.Target)); err != nil {
+				return err
+			}
+			return h.fs.Remove(name)
+		}
+		// Unlike POSIX, SFTP renames mustn't replace an existing file.
+		if _, err := h.fs.Lstat(h.name(r.Target)); err == nil {
+			return fs.ErrExist
+		}
+		return h.fs.Rename(name, h.name(r.Target))
+	case "PosixRename":
+		return h.fs.Rename(name, h.name(r.Target))
+	case "Mkdir":
+		return h.fs.Mkdir(name, 0777)
+	case "Remove", "Rmdir":
+		return h.fs.Remove(name)
+	case "Link":
+		return h.fs.Link(name, h.name(r.Target))
+	case "Symlink":
+		return h.fs.Symlink(r.Filepath, h.name(r.Target))
+	case "Setstat":
+		return h.setstat(name, r)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *sftpHandler) setstat(name string, r *sftp.Request) error {
+	flags, attrs := r.AttrFlags(), r.Attributes()
+	if flags.Size {
+		f, err := h.fs.OpenFile(name, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		err = f.Truncate(int64(attrs.Size))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		if err := h.fs.Chmod(name, fs.FileMode(attrs.Mode).Perm()); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		if err := h.fs.Chtimes(name, time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0)); err != nil {
+			return err
+		}
+	}
+	if flags.UidGid {
+		if err := h.fs.Chown(name, int(attrs.UID), int(attrs.GID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filelist implements sftp.FileLister.
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	name := h.name(r.Filepath)
+	switch r.Method {
+	case "List":
+		if h.writeOnly {
+			return nil, sftp.ErrSSHFxPermissionDenied
+		}
+		f, err := h.fs.OpenFile(name, os.O_RDONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		fis, err := f.Readdir(-1)
+		if err != nil {
+			return nil, err
+		}
+		return sftpListerAt(fis), nil
+	case "Stat":
+		fi, err := h.fs.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		return sftpListerAt{fi}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// Lstat implements sftp.LstatFileLister.
+func (h *sftpHandler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	fi, err := h.fs.Lstat(h.name(r.Filepath))
+	if err != nil {
+		return nil, err
+	}
+	return sftpListerAt{fi}, nil
+}
+
+// Readlink implements sftp.ReadlinkFileLister.
+func (h *sftpHandler) Readlink(p string) (string, error) {
+	return h.fs.Readlink(h.name(p))
+}
+
+// sftpListerAt implements sftp.ListerAt for a fixed list of files.
+type sftpListerAt []fs.FileInfo
+
+func (l sftpListerAt) ListAt(ls []fs.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// serveSFTPOnly serves SFTP on stdin and stdout for an SFTP-only session,
+// auditing file operations to sftpAuditFD. It's run by the incubator after
+// dropping privileges.
+func serveSFTPOnly(ia incubatorArgs) error {
+	auditFile := os.NewFile(sftpAuditFD, "sftp-audit")
+	defer auditFile.Close()
+	var auditMu sync.Mutex
+	enc := json.NewEncoder(auditFile)
+	h := &sftpHandler{
+		fs:        osFS{},
+		readOnly:  ia.sftpReadOnly,
+		writeOnly: ia.sftpWriteOnly,
+		audit: func(op sessionrecording.SFTPOperation) {
+			auditMu.Lock()
+			defer auditMu.Unlock()
+			enc.Encode(op)
+		},
+	}
+	startDir := "/"
+	if ia.sftpRoot != "" {
+		root, err := os.OpenRoot(ia.sftpRoot)
+		if err != nil {
+			return err
+		}
+		defer root.Close()
+		h.fs, h.root = root, filepath.Clean(ia.sftpRoot)
+	} else if wd, err := os.Getwd(); err == nil {
+		startDir = wd
+	}
+	server := sftp.NewRequestServer(stdRWC{}, sftp.Handlers{
+		FileGet:  h,
+		FilePut:  h,
+		FileCmd:  h,
+		FileList: h,
+	}, sftp.WithStartDirectory(startDir))
+	// TODO(https://github.com/pkg/sftp/pull/554): Revert the check for io.EOF,
+	// when sftp is patched to report clean termination.
+	if err := server.Serve(); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// sftpOnlyRoot returns the directory that the SFTP-only session ss is
+// confined to, or "" if it isn't.
+func (ss *sshSession) sftpOnlyRoot() string {
+	root := ss.conn.finalAction.SFTPOnly.Root
+	if root == "" || filepath.IsAbs(root) {
+		return root
+	}
+	return filepath.Join(ss.conn.localUser.HomeDir, root)
+}
+
+// forwardSFTPAudit reads the file operations of an SFTP-only session from
+// the incubator, logs them and sends them to the session's recorders, if
+// any, until r is closed.
+func (ss *sshSession) forwardSFTPAudit(r io.ReadCloser) {
+	defer r.Close()
+	recorders, onFailure := ss.recorders()
+	dec := json.NewDecoder(r)
+	for {
+		var op sessionrecording.SFTPOperation
+		if err := dec.Decode(&op); err != nil {
+			if err != io.EOF {
+				ss.logf("sftp audit: %v", err)
+			}
+			return
+		}
+		op.SSHUser = ss.conn.info.sshUser
+		op.LocalUser = ss.conn.localUser.Username
+		op.ConnectionID = ss.conn.connID
+		op.SessionID = ss.sharedID
+		ss.logf("sftp: %s %q target=%q mode=%q bytes=%d err=%q", op.Op, op.Path, op.Target, op.Mode, op.Bytes, op.Error)
+		if len(recorders) == 0 {
+			continue
+		}
+		if err := ss.sendSFTPEvent(recorders, &op); err != nil {
+			if onFailure != nil && onFailure.TerminateSessionWithMessage != "" {
+				ss.logf("recording: error sending SFTP event (closing session): %v", err)
+				ss.cancelCtx(userVisibleError{
+					error: err,
+					msg:   onFailure.TerminateSessionWithMessage,
+				})
+				return
+			}
+			ss.logf("recording: error sending SFTP event (failing open): %v", err)
+		}
+	}
+}
+
+// sendSFTPEvent sends op to the first of recorders that accepts it.
+func (ss *sshSession) sendSFTPEvent(recorders []netip.AddrPort, op *sessionrecording.SFTPOperation) error {
+	ci := ss.conn.info
+	ev := &sessionrecording.Event{
+		Type:      sessionrecording.SFTPEventType,
+		Timestamp: ss.conn.srv.now().Unix(),
+		Source: sessionrecording.Source{
+			Node:   strings.TrimSuffix(ci.node.Name(), "."),
+			NodeID: ci.node.StableID(),
+		},
+		SFTP: op,
+	}
+	ev.UserAgent, _ = ss.Context().Value(ssh.ContextKeyClientVersion).(string)
+	if !ci.node.IsTagged() {
+		ev.Source.NodeUser = ci.uprof.LoginName
+		ev.Source.NodeUserID = ci.node.User()
+	} else {
+		ev.Source.NodeTags = ci.node.Tags().AsSlice()
+	}
+	if nm := ss.conn.srv.lb.NetMap(); nm != nil && nm.SelfNode.Valid() {
+		ev.Destination = sessionrecording.Destination{
+			Node:   strings.TrimSuffix(nm.SelfNode.Name(), "."),
+			NodeID: nm.SelfNode.StableID(),
+		}
+	}
+	j, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, ap := range recorders {
+		err := sessionrecording.SendEvent(ap, bytes.NewReader(j), ss.conn.srv.lb.Dialer().UserDial)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,268 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || darwin
+
+package tailssh
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/sftp"
+	"tailscale.com/sessionrecording"
+)
+
+type pipeRWC struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// newTestSFTPClient returns a client of an SFTP server with h's handlers.
+func newTestSFTPClient(t *testing.T, h *sftpHandler) *sftp.Client {
+	t.Helper()
+	srvRd, cliWr := io.Pipe()
+	cliRd, srvWr := io.Pipe()
+	server := sftp.NewRequestServer(pipeRWC{srvRd, srvWr}, sftp.Handlers{
+		FileGet:  h,
+		FilePut:  h,
+		FileCmd:  h,
+		FileList: h,
+	})
+	go server.Serve()
+	client, err := sftp.NewClientPipe(cliRd, cliWr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return client
+}
+
+// newTestSFTPHandler returns a handler confined to a new directory, and a
+// func returning the operations audited so far.
+func newTestSFTPHandler(t *testing.T, readOnly, writeOnly bool) (_ *sftpHandler, ops func() []sessionrecording.SFTPOperation) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "root")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { root.Close() })
+	var mu sync.Mutex
+	var got []sessionrecording.SFTPOperation
+	h := &sftpHandler{
+		fs:        root,
+		root:      dir,
+		readOnly:  readOnly,
+		writeOnly: writeOnly,
+		audit: func(op sessionrecording.SFTPOperation) {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, op)
+		},
+	}
+	return h, func() []sessionrecording.SFTPOperation {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]sessionrecording.SFTPOperation(nil), got...)
+	}
+}
+
+func TestSFTPOnlyWriteOnly(t *testing.T) {
+	h, ops := newTestSFTPHandler(t, false, true)
+	outside := filepath.Join(filepath.Dir(h.root), "outside")
+	if err := os.Mkdir(outside, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../outside", filepath.Join(h.root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestSFTPClient(t, h)
+
+	f, err := c.Create("/drop.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(h.root, "drop.txt")); err != nil || string(b) != "hello" {
+		t.Errorf("uploaded file = %q, %v", b, err)
+	}
+
+	if _, err := c.Create("/drop.txt"); err == nil {
+		t.Error("Create of existing file succeeded")
+	}
+	if _, err := c.OpenFile("/drop.txt", os.O_WRONLY); err == nil {
+		t.Error("OpenFile of existing file for writing succeeded")
+	}
+	if b, err := os.ReadFile(filepath.Join(h.root, "drop.txt")); err != nil || string(b) != "hello" {
+		t.Errorf("uploaded file = %q, %v; want it unchanged", b, err)
+	}
+	if _, err := c.Open("/drop.txt"); err == nil {
+		t.Error("Open for reading succeeded")
+	}
+	if err := c.Truncate("/drop.txt", 0); err == nil {
+		t.Error("Truncate succeeded")
+	}
+	if err := c.Chown("/drop.txt", 0, 0); err == nil {
+		t.Error("Chown succeeded")
+	}
+	if err := c.Chmod("/drop.txt", 0640); err != nil {
+		t.Errorf("Chmod: %v", err)
+	}
+	if _, err := c.ReadDir("/"); err == nil {
+		t.Error("ReadDir succeeded")
+	}
+	if err := c.Remove("/drop.txt"); err == nil {
+		t.Error("Remove succeeded")
+	}
+	if _, err := c.Create("/escape/x"); err == nil {
+		t.Error("Create through symlink out of the root succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "x")); !os.IsNotExist(err) {
+		t.Errorf("file created outside the root: %v", err)
+	}
+	if _, err := c.Create("/../../x"); err != nil {
+		t.Errorf("Create of path above the root: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(h.root, "x")); err != nil {
+		t.Errorf("path above the root not confined to it: %v", err)
+	}
+
+	drop := filepath.Join(h.root, "drop.txt")
+	want := []sessionrecording.SFTPOperation{
+		{Op: "open", Path: drop, Mode: "w"},
+		{Op: "write", Path: drop, Bytes: 5},
+		{Op: "open", Path: drop, Mode: "w", Error: "exists"},
+		{Op: "open", Path: drop, Mode: "w", Error: "exists"},
+		{Op: "open", Path: drop, Mode: "r", Error: "permission denied"},
+		{Op: "setstat", Path: drop, Error: "permission denied"},
+		{Op: "setstat", Path: drop, Error: "permission denied"},
+		{Op: "setstat", Path: drop},
+		{Op: "remove", Path: drop, Error: "permission denied"},
+	}
+	got := ops()
+	for i := range got {
+		if got[i].Op == "open" && got[i].Mode == "w" && got[i].Error != "" {
+			got[i].Error = "exists"
+		}
+	}
+	if len(got) < len(want) {
+		t.Fatalf("got %d audited operations, want at least %d", len(got), len(want))
+	}
+	if diff := cmp.Diff(want, got[:len(want)]); diff != "" {
+		t.Errorf("audited operations mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSFTPOnlyWriteOnlyRename(t *testing.T) {
+	h, ops := newTestSFTPHandler(t, false, true)
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(h.root, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := newTestSFTPClient(t, h)
+
+	if err := c.PosixRename("/a", "/b"); err == nil {
+		t.Error("PosixRename onto existing file succeeded")
+	}
+	if err := c.Rename("/a", "/b"); err == nil {
+		t.Error("Rename onto existing file succeeded")
+	}
+	if b, err := os.ReadFile(filepath.Join(h.root, "b")); err != nil || string(b) != "b" {
+		t.Errorf("rename target = %q, %v; want it unchanged", b, err)
+	}
+	if err := c.PosixRename("/a", "/c"); err == nil {
+		t.Error("PosixRename to new name succeeded")
+	}
+	if err := c.Rename("/a", "/c"); err != nil {
+		t.Errorf("Rename to new name: %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(h.root, "c")); err != nil || string(b) != "a" {
+		t.Errorf("renamed file = %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(h.root, "a")); !os.IsNotExist(err) {
+		t.Errorf("rename source still exists: %v", err)
+	}
+
+	got := ops()
+	if len(got) > 1 {
+		if got[1].Error == "" {
+			t.Error("failed rename audited without error")
+		}
+		got[1].Error = "exists"
+	}
+	a, b, cc := filepath.Join(h.root, "a"), filepath.Join(h.root, "b"), filepath.Join(h.root, "c")
+	want := []sessionrecording.SFTPOperation{
+		{Op: "rename", Path: a, Target: b, Error: "permission denied"},
+		{Op: "rename", Path: a, Target: b, Error: "exists"},
+		{Op: "rename", Path: a, Target: cc, Error: "permission denied"},
+		{Op: "rename", Path: a, Target: cc},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("audited operations mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSFTPOnlyReadOnly(t *testing.T) {
+	h, ops := newTestSFTPHandler(t, true, false)
+	if err := os.WriteFile(filepath.Join(h.root, "report.csv"), []byte("a,b\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestSFTPClient(t, h)
+
+	fis, err := c.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 || fis[0].Name() != "report.csv" {
+		t.Errorf("ReadDir = %v", fis)
+	}
+	f, err := c.Open("/report.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(f); err != nil || string(b) != "a,b\n" {
+		t.Errorf("read %q, %v", b, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Create("/new"); err == nil {
+		t.Error("Create succeeded")
+	}
+	if err := c.Rename("/report.csv", "/renamed.csv"); err == nil {
+		t.Error("Rename succeeded")
+	}
+	if err := c.Mkdir("/dir"); err == nil {
+		t.Error("Mkdir succeeded")
+	}
+
+	report := filepath.Join(h.root, "report.csv")
+	want := []sessionrecording.SFTPOperation{
+		{Op: "open", Path: report, Mode: "r"},
+		{Op: "read", Path: report, Bytes: 4},
+		{Op: "open", Path: filepath.Join(h.root, "new"), Mode: "rw", Error: "permission denied"},
+		{Op: "rename", Path: report, Target: filepath.Join(h.root, "renamed.csv"), Error: "permission denied"},
+		{Op: "mkdir", Path: filepath.Join(h.root, "dir"), Error: "permission denied"},
+	}
+	if diff := cmp.Diff(want, ops()); diff != "" {
+		t.Errorf("audited operations mismatch (-want +got):\n%s", diff)
+	}
+}
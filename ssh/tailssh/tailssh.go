@@ -33,6 +33,7 @@
 	gossh "golang.org/x/crypto/ssh"
 	"tailscale.com/envknob"
 	"tailscale.com/feature"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
@@ -81,6 +82,7 @@ type ipnLocalBackend interface {
 	Dialer() *tsdial.Dialer
 	TailscaleVarRoot() string
 	NodeKey() key.NodePublic
+	Prefs() ipn.PrefsView
 }
 
 type server struct {
@@ -251,6 +253,11 @@ type conn struct {
 	userGroupIDs []string     // set by clientAuth
 	acceptEnv    []string
 
+	// userCert is the OpenSSH certificate the client proved it holds the
+	// key for, if any. It's checked against SSHPrincipal.UserCertAuthorities.
+	userCert           *gossh.Certificate // set by VerifiedPublicKeyCallback
+	sentUserCertBanner bool               // whether errNeedUserCert sent its banner
+
 	// authCompleted is set to true after clientAuth has finished writing
 	// all authentication state fields (info, localUser, action0,
 	// finalAction, userGroupIDs, acceptEnv). It provides a memory
@@ -320,7 +327,8 @@ func (c *conn) errUnexpected(err error) error {
 // If policy evaluation fails, it returns an error.
 // If access is denied, it returns an error. This must always be an empty
 // gossh.PartialSuccessError to prevent further authentication methods from
-// being tried.
+// being tried, except for errUserCertRequired, which is returned when the
+// client may yet offer a key with an acceptable OpenSSH user certificate.
 func (c *conn) clientAuth(cm gossh.ConnMetadata) (perms *gossh.Permissions, retErr error) {
 	defer func() {
 		if pse, ok := retErr.(*gossh.PartialSuccessError); ok {
@@ -330,7 +338,7 @@ func (c *conn) clientAuth(cm gossh.ConnMetadata) (perms *gossh.Permissions, retE
 				pse.Next.PublicKeyCallback != nil {
 				panic("clientAuth attempted to return a non-empty PartialSuccessError")
 			}
-		} else if retErr != nil {
+		} else if retErr != nil && retErr != errUserCertRequired {
 			panic(fmt.Sprintf("clientAuth attempted to return a non-PartialSuccessError error of type: %t", retErr))
 		}
 	}()
@@ -349,6 +357,9 @@ func (c *conn) clientAuth(cm gossh.ConnMetadata) (perms *gossh.Permissions, retE
 		// do nothing
 	case rejectedUser:
 		return nil, c.errBanner(fmt.Sprintf("tailnet policy does not permit you to SSH as user %q", c.info.sshUser), nil)
+	case rejectedUserCert:
+		metricUserCertRequired.Add(1)
+		return nil, c.errNeedUserCert()
 	case rejected, noPolicy:
 		return nil, c.errBanner("tailnet policy does not permit you to SSH to this node", fmt.Errorf("failed to evaluate policy, result: %s", result))
 	default:
@@ -454,13 +465,24 @@ func (c *conn) ServerConfig(ctx ssh.Context) *gossh.ServerConfig {
 			// immediately supply a password. We humor them by accepting the
 			// password, but authenticate as usual, ignoring the actual value of
 			// the password.
-			return c.clientAuth(cm)
+			perms, err := c.clientAuth(cm)
+			if err == errUserCertRequired {
+				// No password will do; don't let the client keep trying.
+				return nil, errTerminal
+			}
+			return perms, err
 		},
 		PublicKeyCallback: func(cm gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
 			// Some clients don't request 'none' authentication. Instead, they
-			// immediately supply a public key. We humor them by accepting the
-			// key, but authenticate as usual, ignoring the actual content of
-			// the key.
+			// immediately supply a public key. We humor them by accepting any
+			// key, and authenticate as usual in VerifiedPublicKeyCallback once
+			// the client has proven it holds the key.
+			return &gossh.Permissions{}, nil
+		},
+		VerifiedPublicKeyCallback: func(cm gossh.ConnMetadata, key gossh.PublicKey, _ *gossh.Permissions, _ string) (*gossh.Permissions, error) {
+			// The content of the key is ignored, except that an OpenSSH
+			// user certificate may be needed to match a principal.
+			c.userCert, _ = key.(*gossh.Certificate)
 			return c.clientAuth(cm)
 		},
 	}
@@ -522,7 +544,7 @@ func (c *conn) mayReversePortForwardTo(ctx ssh.Context, destinationHost string,
 	if sshDisableForwarding() {
 		return false
 	}
-	if c.finalAction != nil && c.finalAction.AllowRemotePortForwarding {
+	if c.finalAction != nil && c.finalAction.AllowRemotePortForwarding && c.finalAction.SFTPOnly == nil {
 		metricRemotePortForward.Add(1)
 		return true
 	}
@@ -536,7 +558,7 @@ func (c *conn) mayForwardLocalPortTo(ctx ssh.Context, destinationHost string, de
 	if sshDisableForwarding() {
 		return false
 	}
-	if c.finalAction != nil && c.finalAction.AllowLocalPortForwarding {
+	if c.finalAction != nil && c.finalAction.AllowLocalPortForwarding && c.finalAction.SFTPOnly == nil {
 		metricLocalPortForward.Add(1)
 		return true
 	}
@@ -621,10 +643,11 @@ func (c *conn) setInfo(cm gossh.ConnMetadata) error {
 type evalResult string
 
 const (
-	noPolicy     evalResult = "no policy"
-	rejected     evalResult = "rejected"
-	rejectedUser evalResult = "rejected user"
-	accepted     evalResult = "accept"
+	noPolicy         evalResult = "no policy"
+	rejected         evalResult = "rejected"
+	rejectedUser     evalResult = "rejected user"
+	rejectedUserCert evalResult = "rejected user certificate"
+	accepted         evalResult = "accept"
 )
 
 // evaluatePolicy returns the SSHAction and localUser after evaluating
@@ -657,6 +680,21 @@ func (c *conn) handleSessionPostSSHAuth(s ssh.Session) {
 		s.Exit(1)
 		return
 	}
+	if sftpOnly := c.finalAction.SFTPOnly; sftpOnly != nil {
+		if s.Subsystem() != "sftp" {
+			metricSFTPOnlyDenied.Add(1)
+			c.logf("denying non-SFTP session for SFTP-only access")
+			fmt.Fprintf(s.Stderr(), "This account is restricted to SFTP.\r\n")
+			s.Exit(1)
+			return
+		}
+		if sftpOnly.ReadOnly && sftpOnly.WriteOnly {
+			c.logf("denying SFTP session: SFTP-only action is both read-only and write-only")
+			fmt.Fprintf(s.Stderr(), "Tailscale SSH is misconfigured.\r\n")
+			s.Exit(1)
+			return
+		}
+	}
 
 	ss := c.newSSHSession(s)
 	ss.logf("handling new SSH connection from %v (%v) to ssh-user %q", c.info.uprof.LoginName, c.info.src.Addr(), c.localUser.Username)
@@ -1081,11 +1119,6 @@ func (ss *sshSession) run() {
 	return
 }
 
-// recordSSHToLocalDisk is a deprecated dev knob to allow recording SSH sessions
-// to local storage. It is only used if there is no recording configured by the
-// coordination server. This will be removed in the future.
-var recordSSHToLocalDisk = envknob.RegisterBool("TS_DEBUG_LOG_SSH")
-
 // recorders returns the list of recorders to use for this session.
 // If the final action has a non-empty list of recorders, that list is
 // returned. Otherwise, the list of recorders from the initial action
@@ -1099,7 +1132,7 @@ func (ss *sshSession) recorders() ([]netip.AddrPort, *tailcfg.SSHRecorderFailure
 
 func (ss *sshSession) shouldRecord() bool {
 	recs, _ := ss.recorders()
-	return len(recs) > 0 || recordSSHToLocalDisk()
+	return len(recs) > 0 || recordSSHToLocalDisk(ss.conn.srv.lb.Prefs())
 }
 
 type sshConnInfo struct {
@@ -1131,18 +1164,25 @@ func (c *conn) ruleExpired(r *tailcfg.SSHRule) bool {
 }
 
 func (c *conn) evalSSHPolicy(pol *tailcfg.SSHPolicy) (a *tailcfg.SSHAction, localUser string, acceptEnv []string, result evalResult) {
-	failedOnUser := false
+	failedOnUser, failedOnUserCert := false, false
 	for _, r := range pol.Rules {
 		if a, localUser, acceptEnv, err := c.matchRule(r); err == nil {
 			return a, localUser, acceptEnv, accepted
 		} else if errors.Is(err, errUserMatch) {
 			failedOnUser = true
+		} else if errors.Is(err, errUserCertMatch) {
+			failedOnUserCert = true
 		}
 	}
 	result = rejected
 	if failedOnUser {
 		result = rejectedUser
 	}
+	if failedOnUserCert {
+		// A rule matched but for the certificate, which the client can
+		// still fix by offering another key.
+		result = rejectedUserCert
+	}
 	return nil, "", nil, result
 }
 
@@ -1153,6 +1193,7 @@ func (c *conn) evalSSHPolicy(pol *tailcfg.SSHPolicy) (a *tailcfg.SSHAction, loca
 	errRuleExpired    = errors.New("rule expired")
 	errPrincipalMatch = errors.New("principal didn't match")
 	errUserMatch      = errors.New("user didn't match")
+	errUserCertMatch  = errors.New("user certificate didn't match")
 	errInvalidConn    = errors.New("invalid connection state")
 )
 
@@ -1177,8 +1218,9 @@ func (c *conn) matchRule(r *tailcfg.SSHRule) (a *tailcfg.SSHAction, localUser st
 	if c.ruleExpired(r) {
 		return nil, "", nil, errRuleExpired
 	}
-	if !c.anyPrincipalMatches(r.Principals) {
-		return nil, "", nil, errPrincipalMatch
+	principalErr := c.anyPrincipalMatches(r.Principals)
+	if principalErr == errPrincipalMatch {
+		return nil, "", nil, principalErr
 	}
 	if !r.Action.Reject {
 		// For all but Reject rules, SSHUsers is required.
@@ -1189,6 +1231,11 @@ func (c *conn) matchRule(r *tailcfg.SSHRule) (a *tailcfg.SSHAction, localUser st
 			return nil, "", nil, errUserMatch
 		}
 	}
+	if principalErr != nil {
+		// Checked after the user so that a client without a
+		// certificate is only asked for one if it would help.
+		return nil, "", nil, principalErr
+	}
 	return r.Action, localUser, r.AcceptEnv, nil
 }
 
@@ -1203,16 +1250,26 @@ func mapLocalUser(ruleSSHUsers map[string]string, reqSSHUser string) (localUser
 	return v
 }
 
-func (c *conn) anyPrincipalMatches(ps []*tailcfg.SSHPrincipal) bool {
+// anyPrincipalMatches reports whether any of ps match the connection. It
+// returns errUserCertMatch if principals matched the Tailscale identity but
+// not the client's user certificate, or errPrincipalMatch if none matched.
+func (c *conn) anyPrincipalMatches(ps []*tailcfg.SSHPrincipal) error {
+	err := errPrincipalMatch
 	for _, p := range ps {
-		if p == nil {
+		if p == nil || !c.principalMatchesTailscaleIdentity(p) {
 			continue
 		}
-		if c.principalMatchesTailscaleIdentity(p) {
-			return true
+		if len(p.UserCertAuthorities) == 0 {
+			return nil
+		}
+		certErr := c.checkUserCert(p.UserCertAuthorities)
+		if certErr == nil {
+			return nil
 		}
+		c.logf("user certificate not accepted: %v", certErr)
+		err = errUserCertMatch
 	}
-	return false
+	return err
 }
 
 // principalMatchesTailscaleIdentity reports whether one of p's four fields
@@ -1245,19 +1302,12 @@ func randBytes(n int) []byte {
 }
 
 func (ss *sshSession) openFileForRecording(now time.Time) (_ io.WriteCloser, err error) {
-	varRoot := ss.conn.srv.lb.TailscaleVarRoot()
-	if varRoot == "" {
-		return nil, errors.New("no var root for recording storage")
-	}
-	dir := filepath.Join(varRoot, "ssh-sessions")
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		return nil, err
-	}
-	f, err := os.CreateTemp(dir, fmt.Sprintf("ssh-session-%v-*.cast", now.UnixNano()))
+	lb := ss.conn.srv.lb
+	s, err := localRecordingStore(lb.TailscaleVarRoot(), lb.Prefs())
 	if err != nil {
 		return nil, err
 	}
-	return f, nil
+	return s.Create("ssh-session", now)
 }
 
 // startNewRecording starts a new SSH session recording.
@@ -1273,7 +1323,7 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 	recorders, onFailure := ss.recorders()
 	var localRecording bool
 	if len(recorders) == 0 {
-		if recordSSHToLocalDisk() {
+		if recordSSHToLocalDisk(ss.conn.srv.lb.Prefs()) {
 			localRecording = true
 		} else {
 			return nil, errors.New("no recorders configured")
@@ -1566,8 +1616,10 @@ func envEq(a, b string) bool {
 	metricTerminalMalformed   = clientmetric.NewCounter("ssh_terminalaction_malformed")
 	metricTerminalFetchError  = clientmetric.NewCounter("ssh_terminalaction_fetch_error")
 	metricHolds               = clientmetric.NewCounter("ssh_holds")
+	metricUserCertRequired    = clientmetric.NewCounter("ssh_user_cert_required")
 	metricPolicyChangeKick    = clientmetric.NewCounter("ssh_policy_change_kick")
 	metricSFTP                = clientmetric.NewCounter("ssh_sftp_sessions")
+	metricSFTPOnlyDenied      = clientmetric.NewCounter("ssh_sftp_only_denied")
 	metricLocalPortForward    = clientmetric.NewCounter("ssh_local_port_forward_requests")
 	metricRemotePortForward   = clientmetric.NewCounter("ssh_remote_port_forward_requests")
 )
@@ -33,6 +33,7 @@
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
+	"tailscale.com/ipn"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/tailcfg"
 	glider "tailscale.com/tempfork/gliderlabs/ssh"
@@ -679,6 +680,10 @@ func (tb *testBackend) NodeKey() key.NodePublic {
 	return key.NodePublic{}
 }
 
+func (tb *testBackend) Prefs() ipn.PrefsView {
+	return (&ipn.Prefs{}).View()
+}
+
 type addressFakingConn struct {
 	net.Conn
 }
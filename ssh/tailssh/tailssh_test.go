@@ -36,6 +36,7 @@
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"tailscale.com/cmd/testwrapper/flakytest"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/net/memnet"
@@ -455,6 +456,10 @@ func (ts *localState) NodeKey() key.NodePublic {
 	return key.NewNode().Public()
 }
 
+func (ts *localState) Prefs() ipn.PrefsView {
+	return (&ipn.Prefs{}).View()
+}
+
 func newSSHRule(action *tailcfg.SSHAction) *tailcfg.SSHRule {
 	return &tailcfg.SSHRule{
 		SSHUsers: map[string]string{
@@ -0,0 +1,113 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build (linux && !android) || (darwin && !ios) || freebsd || openbsd || plan9
+
+package tailssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// sourceAddressOption is the OpenSSH certificate critical option that limits
+// the source addresses the certificate may be used from.
+const sourceAddressOption = "source-address"
+
+// errUserCertRequired is returned by clientAuth when the policy would accept
+// the connection if the client presented a valid OpenSSH user certificate.
+// Unlike errTerminal, it lets the client go on to offer other keys.
+var errUserCertRequired = errors.New("tailnet policy requires an OpenSSH user certificate")
+
+// errNeedUserCert is returned by auth callbacks when only a missing or
+// invalid user certificate keeps the policy from accepting the connection.
+// The first time, it writes a banner saying so.
+func (c *conn) errNeedUserCert() error {
+	if !c.sentUserCertBanner {
+		c.sentUserCertBanner = true
+		msg := fmt.Sprintf("tailscale: tailnet policy requires an OpenSSH certificate from a trusted CA to SSH as user %q\n", c.info.sshUser)
+		if err := c.spac.SendAuthBanner(msg); err != nil {
+			c.logf("failed to send auth banner: %s", err)
+		}
+	}
+	return errUserCertRequired
+}
+
+// checkUserCert checks the user certificate the client authenticated with,
+// if any, against the certificate authorities cas, which are public keys in
+// authorized_keys format.
+//
+// The certificate must be a user certificate signed by one of cas that is
+// currently valid and names the requested SSH user as a principal. Its only
+// critical option may be "source-address", which must match the client's
+// Tailscale IP.
+func (c *conn) checkUserCert(cas []string) error {
+	cert := c.userCert
+	if cert == nil {
+		return errors.New("no certificate presented")
+	}
+	if cert.CertType != gossh.UserCert {
+		return fmt.Errorf("certificate has type %d, not user", cert.CertType)
+	}
+	checker := &gossh.CertChecker{
+		IsUserAuthority: func(auth gossh.PublicKey) bool {
+			return isUserAuthority(cas, auth)
+		},
+		Clock: c.srv.now,
+	}
+	if !checker.IsUserAuthority(cert.SignatureKey) {
+		return fmt.Errorf("certificate %q signed by untrusted CA %s", cert.KeyId, gossh.FingerprintSHA256(cert.SignatureKey))
+	}
+	// Like sshd, but unlike CertChecker, require certificates to name
+	// their users.
+	if len(cert.ValidPrincipals) == 0 {
+		return fmt.Errorf("certificate %q has no principals", cert.KeyId)
+	}
+	// CheckCert checks the principals, validity period and signature, and
+	// rejects all critical options but source-address, which it leaves for
+	// us to check.
+	if err := checker.CheckCert(c.info.sshUser, cert); err != nil {
+		return fmt.Errorf("certificate %q: %w", cert.KeyId, err)
+	}
+	if v, ok := cert.CriticalOptions[sourceAddressOption]; ok {
+		if !sourceAddressAllowed(v, c.info.src.Addr()) {
+			return fmt.Errorf("certificate %q not valid from %v", cert.KeyId, c.info.src.Addr())
+		}
+	}
+	return nil
+}
+
+// isUserAuthority reports whether auth is one of cas, which are public keys
+// in authorized_keys format. Invalid entries in cas are ignored.
+func isUserAuthority(cas []string, auth gossh.PublicKey) bool {
+	want := auth.Marshal()
+	for _, ca := range cas {
+		k, _, _, _, err := gossh.ParseAuthorizedKey([]byte(ca))
+		if err == nil && bytes.Equal(k.Marshal(), want) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceAddressAllowed reports whether ip is matched by v, the value of a
+// certificate's source-address critical option: a comma-separated list of
+// addresses and CIDR prefixes.
+func sourceAddressAllowed(v string, ip netip.Addr) bool {
+	for s := range strings.SplitSeq(v, ",") {
+		s = strings.TrimSpace(s)
+		if p, err := netip.ParsePrefix(s); err == nil {
+			if p.Contains(ip) {
+				return true
+			}
+		} else if a, err := netip.ParseAddr(s); err == nil && a == ip {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || darwin
+
+package tailssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/netip"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+)
+
+func newTestCA(t *testing.T) (gossh.Signer, string) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, string(gossh.MarshalAuthorizedKey(signer.PublicKey()))
+}
+
+func TestCheckUserCert(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	ca, caPub := newTestCA(t)
+	otherCA, _ := newTestCA(t)
+	userPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userKey, err := gossh.NewPublicKey(userPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newCert := func(signer gossh.Signer, modify func(*gossh.Certificate)) *gossh.Certificate {
+		cert := &gossh.Certificate{
+			Key:             userKey,
+			KeyId:           "alice-breakglass",
+			CertType:        gossh.UserCert,
+			ValidPrincipals: []string{"root"},
+			ValidAfter:      uint64(now.Add(-time.Hour).Unix()),
+			ValidBefore:     uint64(now.Add(time.Hour).Unix()),
+		}
+		if modify != nil {
+			modify(cert)
+		}
+		if err := cert.SignCert(rand.Reader, signer); err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	tests := []struct {
+		name    string
+		cert    *gossh.Certificate
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			cert: newCert(ca, nil),
+		},
+		{
+			name:    "no-cert",
+			wantErr: true,
+		},
+		{
+			name:    "untrusted-ca",
+			cert:    newCert(otherCA, nil),
+			wantErr: true,
+		},
+		{
+			name: "host-cert",
+			cert: newCert(ca, func(c *gossh.Certificate) {
+				c.CertType = gossh.HostCert
+			}),
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			cert: newCert(ca, func(c *gossh.Certificate) {
+				c.ValidBefore = uint64(now.Add(-time.Minute).Unix())
+			}),
+			wantErr: true,
+		},
+		{
+			name: "not-yet-valid",
+			cert: newCert(ca, func(c *gossh.Certificate) {
+				c.ValidAfter = uint64(now.Add(time.Minute).Unix())
+			}),
+			wantErr: true,
+		},
+		{
+			name: "other-principal",
+			cert: newCert(ca, func(c *gossh.Certificate) {
+				c.ValidPrincipals = []string{"alice"}
+			}),
+			wantErr: true,
+		},
+		{
+			name: "no-principals",
+			cert: newCert(ca, func(c *gossh.Certificate) {
+				c.ValidPrincipals = nil
+			}),
+			wantErr: true,
+		},
+		{
+			name: "force-command",
+			cert: newCert(ca, func(c *gossh.Certificate) {
+				c.CriticalOptions = map[string]string{"force-command": "/bin/true"}
+			}),
+			wantErr: true,
+		},
+		{
+			name: "source-address-match",
+			cert: newCert(ca, func(c *gossh.Certificate) {
+				c.CriticalOptions = map[string]string{sourceAddressOption: "10.0.0.1,100.64.0.0/10"}
+			}),
+		},
+		{
+			name: "source-address-mismatch",
+			cert: newCert(ca, func(c *gossh.Certificate) {
+				c.CriticalOptions = map[string]string{sourceAddressOption: "10.0.0.0/8"}
+			}),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &conn{
+				info: &sshConnInfo{
+					sshUser: "root",
+					src:     netip.MustParseAddrPort("100.100.100.101:2231"),
+				},
+				srv:      &server{logf: tstest.WhileTestRunningLogger(t), timeNow: func() time.Time { return now }},
+				userCert: tt.cert,
+			}
+			err := c.checkUserCert([]string{"not a key", caPub})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkUserCert = %v; want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatchRuleUserCert(t *testing.T) {
+	ca, caPub := newTestCA(t)
+	userPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userKey, err := gossh.NewPublicKey(userPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &gossh.Certificate{
+		Key:             userKey,
+		CertType:        gossh.UserCert,
+		ValidPrincipals: []string{"root"},
+		ValidBefore:     gossh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	certRule := &tailcfg.SSHRule{
+		Action:     &tailcfg.SSHAction{Accept: true},
+		Principals: []*tailcfg.SSHPrincipal{{UserLogin: "alice@example.com", UserCertAuthorities: []string{caPub}}},
+		SSHUsers:   map[string]string{"root": "root"},
+	}
+	tests := []struct {
+		name       string
+		sshUser    string
+		login      string
+		cert       *gossh.Certificate
+		wantErr    error
+		wantResult evalResult
+	}{
+		{
+			name:       "with-cert",
+			sshUser:    "root",
+			login:      "alice@example.com",
+			cert:       cert,
+			wantResult: accepted,
+		},
+		{
+			name:       "without-cert",
+			sshUser:    "root",
+			login:      "alice@example.com",
+			wantErr:    errUserCertMatch,
+			wantResult: rejectedUserCert,
+		},
+		{
+			name:       "other-identity",
+			sshUser:    "root",
+			login:      "bob@example.com",
+			cert:       cert,
+			wantErr:    errPrincipalMatch,
+			wantResult: rejected,
+		},
+		{
+			// A certificate wouldn't help, so don't ask for one.
+			name:       "other-user-without-cert",
+			sshUser:    "alice",
+			login:      "alice@example.com",
+			wantErr:    errUserMatch,
+			wantResult: rejectedUser,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &conn{
+				info: &sshConnInfo{
+					sshUser: tt.sshUser,
+					uprof:   tailcfg.UserProfile{LoginName: tt.login},
+				},
+				srv:      &server{logf: tstest.WhileTestRunningLogger(t)},
+				userCert: tt.cert,
+			}
+			if _, _, _, err := c.matchRule(certRule); err != tt.wantErr {
+				t.Errorf("matchRule err = %v; want %v", err, tt.wantErr)
+			}
+			pol := &tailcfg.SSHPolicy{Rules: []*tailcfg.SSHRule{certRule}}
+			if _, _, _, result := c.evalSSHPolicy(pol); result != tt.wantResult {
+				t.Errorf("evalSSHPolicy result = %v; want %v", result, tt.wantResult)
+			}
+		})
+	}
+}
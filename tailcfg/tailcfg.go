@@ -180,7 +180,9 @@
 //   - 131: 2025-11-25: client respects [NodeAttrDefaultAutoUpdate]
 //   - 132: 2026-02-13: client respects [NodeAttrDisableHostsFileUpdates]
 //   - 133: 2026-02-17: client understands [NodeAttrForceRegisterMagicDNSIPv4Only]; MagicDNS IPv6 registered w/ OS by default
-const CurrentCapabilityVersion CapabilityVersion = 133
+//   - 134: 2026-10-16: client understands [SSHPrincipal.UserCertAuthorities]
+//   - 135: 2026-10-16: client understands [SSHAction.SFTPOnly]
+const CurrentCapabilityVersion CapabilityVersion = 135
 
 // ID is an integer ID for a user, node, or login allocated by the
 // control plane.
@@ -2917,7 +2919,7 @@ type SSHRule struct {
 // SSHPrincipal is either a particular node or a user on any node.
 type SSHPrincipal struct {
 	// Matching any one of the following four field causes a match.
-	// It must also match Certs, if non-empty.
+	// It must also match UserCertAuthorities, if non-empty.
 
 	Node      StableNodeID `json:"node,omitempty"`
 	NodeIP    string       `json:"nodeIP,omitempty"`
@@ -2925,6 +2927,19 @@ type SSHPrincipal struct {
 	Any       bool         `json:"any,omitempty"`       // if true, match any connection
 	// TODO(bradfitz): add StableUserID, once that exists
 
+	// UserCertAuthorities, if non-empty, additionally requires the client to
+	// authenticate with an OpenSSH user certificate signed by one of these
+	// certificate authorities. Each is a public key in authorized_keys
+	// format, like "ssh-ed25519 AAAA...".
+	//
+	// The certificate must be currently valid and must list the requested
+	// SSH user as one of its principals. Its only critical option may be
+	// "source-address", which is enforced.
+	//
+	// Only sent to clients with CapabilityVersion 134 or later; older
+	// clients would ignore it and match without a certificate.
+	UserCertAuthorities []string `json:"userCertAuthorities,omitempty"`
+
 	// UnusedPubKeys was public key support. It never became an official product
 	// feature and so as of 2024-12-12 is being removed.
 	// This stub exists to remind us not to re-use the JSON field name "pubKeys"
@@ -2993,6 +3008,32 @@ type SSHAction struct {
 	// OnRecorderFailure is the action to take if recording fails.
 	// If nil, the default action is to fail open.
 	OnRecordingFailure *SSHRecorderFailureAction `json:"onRecordingFailure,omitempty"`
+
+	// SFTPOnly, if non-nil, restricts accepted connections to file
+	// transfers with the SFTP subsystem. Shells, commands and agent and
+	// port forwarding are refused. Each file operation in the session is
+	// sent to the Recorders, if any, as a session recording event.
+	SFTPOnly *SSHSFTPOnly `json:"sftpOnly,omitempty"`
+}
+
+// SSHSFTPOnly configures SSH sessions that are restricted to SFTP by
+// [SSHAction.SFTPOnly].
+type SSHSFTPOnly struct {
+	// Root, if non-empty, is the directory that SFTP clients are confined
+	// to and see as "/". A relative path is relative to the local user's
+	// home directory. The local user must have access to it.
+	Root string `json:"root,omitempty"`
+
+	// ReadOnly, if true, only permits listing and downloading files.
+	ReadOnly bool `json:"readOnly,omitempty"`
+
+	// WriteOnly, if true, only permits uploading files, creating
+	// directories and renaming files. Files can't be downloaded or
+	// removed, and directories can't be listed.
+	//
+	// ReadOnly and WriteOnly are mutually exclusive; sessions for an
+	// SSHAction with both set are rejected.
+	WriteOnly bool `json:"writeOnly,omitempty"`
 }
 
 // SSHRecorderFailureAction is the action to take if recording fails.
@@ -535,6 +535,9 @@ func (src *SSHAction) Clone() *SSHAction {
 	if dst.OnRecordingFailure != nil {
 		dst.OnRecordingFailure = new(*src.OnRecordingFailure)
 	}
+	if dst.SFTPOnly != nil {
+		dst.SFTPOnly = new(*src.SFTPOnly)
+	}
 	return dst
 }
 
@@ -550,6 +553,7 @@ func (src *SSHAction) Clone() *SSHAction {
 	AllowRemotePortForwarding bool
 	Recorders                 []netip.AddrPort
 	OnRecordingFailure        *SSHRecorderFailureAction
+	SFTPOnly                  *SSHSFTPOnly
 }{})
 
 // Clone makes a deep copy of SSHPrincipal.
@@ -560,17 +564,19 @@ func (src *SSHPrincipal) Clone() *SSHPrincipal {
 	}
 	dst := new(SSHPrincipal)
 	*dst = *src
+	dst.UserCertAuthorities = append(src.UserCertAuthorities[:0:0], src.UserCertAuthorities...)
 	dst.UnusedPubKeys = append(src.UnusedPubKeys[:0:0], src.UnusedPubKeys...)
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _SSHPrincipalCloneNeedsRegeneration = SSHPrincipal(struct {
-	Node          StableNodeID
-	NodeIP        string
-	UserLogin     string
-	Any           bool
-	UnusedPubKeys []string
+	Node                StableNodeID
+	NodeIP              string
+	UserLogin           string
+	Any                 bool
+	UserCertAuthorities []string
+	UnusedPubKeys       []string
 }{})
 
 // Clone makes a deep copy of ControlDialPlan.
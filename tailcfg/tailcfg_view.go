@@ -2138,6 +2138,14 @@ func (v SSHActionView) OnRecordingFailure() views.ValuePointer[SSHRecorderFailur
 	return views.ValuePointerOf(v.ж.OnRecordingFailure)
 }
 
+// SFTPOnly, if non-nil, restricts accepted connections to file
+// transfers with the SFTP subsystem. Shells, commands and agent and
+// port forwarding are refused. Each file operation in the session is
+// sent to the Recorders, if any, as a session recording event.
+func (v SSHActionView) SFTPOnly() views.ValuePointer[SSHSFTPOnly] {
+	return views.ValuePointerOf(v.ж.SFTPOnly)
+}
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _SSHActionViewNeedsRegeneration = SSHAction(struct {
 	Message                   string
@@ -2150,6 +2158,7 @@ func (v SSHActionView) OnRecordingFailure() views.ValuePointer[SSHRecorderFailur
 	AllowRemotePortForwarding bool
 	Recorders                 []netip.AddrPort
 	OnRecordingFailure        *SSHRecorderFailureAction
+	SFTPOnly                  *SSHSFTPOnly
 }{})
 
 // View returns a read-only view of SSHPrincipal.
@@ -2228,6 +2237,21 @@ func (v SSHPrincipalView) UserLogin() string { return v.ж.UserLogin }
 // if true, match any connection
 func (v SSHPrincipalView) Any() bool { return v.ж.Any }
 
+// UserCertAuthorities, if non-empty, additionally requires the client to
+// authenticate with an OpenSSH user certificate signed by one of these
+// certificate authorities. Each is a public key in authorized_keys
+// format, like "ssh-ed25519 AAAA...".
+//
+// The certificate must be currently valid and must list the requested
+// SSH user as one of its principals. Its only critical option may be
+// "source-address", which is enforced.
+//
+// Only sent to clients with CapabilityVersion 134 or later; older
+// clients would ignore it and match without a certificate.
+func (v SSHPrincipalView) UserCertAuthorities() views.Slice[string] {
+	return views.SliceOf(v.ж.UserCertAuthorities)
+}
+
 // UnusedPubKeys was public key support. It never became an official product
 // feature and so as of 2024-12-12 is being removed.
 // This stub exists to remind us not to re-use the JSON field name "pubKeys"
@@ -2240,11 +2264,12 @@ func (v SSHPrincipalView) UnusedPubKeys() views.Slice[string] {
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _SSHPrincipalViewNeedsRegeneration = SSHPrincipal(struct {
-	Node          StableNodeID
-	NodeIP        string
-	UserLogin     string
-	Any           bool
-	UnusedPubKeys []string
+	Node                StableNodeID
+	NodeIP              string
+	UserLogin           string
+	Any                 bool
+	UserCertAuthorities []string
+	UnusedPubKeys       []string
 }{})
 
 // View returns a read-only view of ControlDialPlan.
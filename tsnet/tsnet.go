@@ -343,6 +343,8 @@ func (s *Server) Loopback() (addr string, proxyCred, localAPICred string, err er
 		s5s := &socks5.Server{
 			Logf:     s5l,
 			Dialer:   s.dialer.UserDial,
+			Resolve:  s.dialer.UserResolve,
+			Listen:   s.dialer.UserListen,
 			Username: "tsnet",
 			Password: s.proxyCred,
 		}
@@ -739,6 +741,13 @@ func (s *Server) start() (reterr error) {
 		}
 		return udpConn, nil
 	}
+	s.dialer.NetstackListenTCP = func(src netip.AddrPort) (net.Listener, error) {
+		ln, err := ns.ListenTCP(bools.IfElse(src.Addr().Is6(), "tcp6", "tcp4"), src.String())
+		if err != nil {
+			return nil, err
+		}
+		return ln, nil
+	}
 
 	if s.Store == nil {
 		stateFile := filepath.Join(s.rootPath, "tailscaled.state")
@@ -88,23 +88,22 @@ func (v *ResolverView) UnmarshalJSONFrom(dec *jsontext.Decoder) error {
 //   - A plain IP address for a "classic" UDP+TCP DNS resolver.
 //     This is the common format as sent by the control plane.
 //   - An IP:port, for tests.
-//   - "https://resolver.com/path" for DNS over HTTPS; currently
-//     as of 2022-09-08 only used for certain well-known resolvers
-//     (see the publicdns package) for which the IP addresses to dial DoH are
-//     known ahead of time, so bootstrap DNS resolution is not required.
+//   - "https://resolver.com/path" for DNS over HTTPS. For certain
+//     well-known resolvers (see the publicdns package), the IP addresses
+//     to dial DoH are known ahead of time, so bootstrap DNS resolution is
+//     not required.
 //   - "http://node-address:port/path" for DNS over HTTP over WireGuard. This
 //     is implemented in the PeerAPI for exit nodes and app connectors.
-//   - [TODO] "tls://resolver.com" for DNS over TCP+TLS
+//   - "tls://resolver.com" or "tls://resolver.com:port" for DNS over
+//     TCP+TLS. The port defaults to 853.
 func (v ResolverView) Addr() string { return v.ж.Addr }
 
-// BootstrapResolution is an optional suggested resolution for the
-// DoT/DoH resolver, if the resolver URL does not reference an IP
-// address directly.
-// BootstrapResolution may be empty, in which case clients should
-// look up the DoT/DoH server using their local "classic" DNS
-// resolver.
-//
-// As of 2022-09-08, BootstrapResolution is not yet used.
+// BootstrapResolution are the IP addresses to dial for the DoT/DoH
+// resolver. They're required if the resolver URL references a hostname
+// rather than an IP address, except for the well-known DoH resolvers
+// whose addresses are known ahead of time: clients don't look up the
+// resolver's hostname with their local "classic" DNS resolver, which
+// may itself be sending queries to this resolver.
 func (v ResolverView) BootstrapResolution() views.Slice[netip.Addr] {
 	return views.SliceOf(v.ж.BootstrapResolution)
 }
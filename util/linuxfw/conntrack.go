@@ -0,0 +1,204 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package linuxfw
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// This file contains functionality to delete connection tracking entries.
+// NAT rules only apply to the first packet of a flow, after which the kernel
+// translates the rest of the flow as recorded in its conntrack entry. A UDP
+// flow whose packets keep arriving never expires, so when a DNAT rule
+// changes, the flows that it applied to need to be forgotten for the new
+// rule to take effect for them.
+
+// ctnetlink message types and attributes, from
+// include/uapi/linux/netfilter/nfnetlink_conntrack.h.
+const (
+	nfnlSubsysCTNetlink = 1
+
+	ipctnlMsgCTGet    = 1
+	ipctnlMsgCTDelete = 2
+
+	ctaTupleOrig = 1
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+)
+
+// conntrackTuple is the original direction tuple of a conntrack entry.
+type conntrackTuple struct {
+	src, dst         netip.Addr
+	proto            uint8
+	srcPort, dstPort uint16
+}
+
+// DeleteConntrackEntries deletes the connection tracking entries for flows
+// of the IP protocol proto (such as unix.IPPROTO_UDP) that were originally
+// destined to dst, so that their next packets are matched against the
+// current NAT rules. It returns the number of entries deleted.
+func DeleteConntrackEntries(dst netip.Addr, proto uint8) (int, error) {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error connecting to ctnetlink: %w", err)
+	}
+	defer conn.Close()
+
+	family := uint8(unix.AF_INET)
+	if dst.Is6() {
+		family = unix.AF_INET6
+	}
+	msgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(nfnlSubsysCTNetlink<<8 | ipctnlMsgCTGet),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: nfgenmsg(family),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error listing conntrack entries: %w", err)
+	}
+	var deleted int
+	for _, m := range msgs {
+		t, err := parseConntrackEntry(m.Data)
+		if err != nil {
+			return deleted, err
+		}
+		if t.proto != proto || t.dst != dst.Unmap() {
+			continue
+		}
+		data, err := marshalConntrackDelete(family, t)
+		if err != nil {
+			return deleted, err
+		}
+		_, err = conn.Execute(netlink.Message{
+			Header: netlink.Header{
+				Type:  netlink.HeaderType(nfnlSubsysCTNetlink<<8 | ipctnlMsgCTDelete),
+				Flags: netlink.Request | netlink.Acknowledge,
+			},
+			Data: data,
+		})
+		if errors.Is(err, unix.ENOENT) {
+			// The flow expired in the meantime.
+			continue
+		}
+		if err != nil {
+			return deleted, fmt.Errorf("error deleting conntrack entry: %w", err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// nfgenmsg returns the nfnetlink header of a message for the given address
+// family.
+func nfgenmsg(family uint8) []byte {
+	// Family, version (NFNETLINK_V0) and resource ID.
+	return []byte{family, 0, 0, 0}
+}
+
+// parseConntrackEntry returns the original direction tuple of the conntrack
+// entry in the ctnetlink message data b.
+func parseConntrackEntry(b []byte) (conntrackTuple, error) {
+	var t conntrackTuple
+	if len(b) < 4 {
+		return t, errors.New("short conntrack message")
+	}
+	ad, err := netlink.NewAttributeDecoder(b[4:])
+	if err != nil {
+		return t, err
+	}
+	ad.ByteOrder = binary.BigEndian
+	for ad.Next() {
+		if ad.Type() != ctaTupleOrig {
+			continue
+		}
+		ad.Nested(func(tad *netlink.AttributeDecoder) error {
+			for tad.Next() {
+				switch tad.Type() {
+				case ctaTupleIP:
+					tad.Nested(func(iad *netlink.AttributeDecoder) error {
+						for iad.Next() {
+							addr, _ := netip.AddrFromSlice(iad.Bytes())
+							switch iad.Type() {
+							case ctaIPv4Src, ctaIPv6Src:
+								t.src = addr
+							case ctaIPv4Dst, ctaIPv6Dst:
+								t.dst = addr
+							}
+						}
+						return nil
+					})
+				case ctaTupleProto:
+					tad.Nested(func(pad *netlink.AttributeDecoder) error {
+						for pad.Next() {
+							switch pad.Type() {
+							case ctaProtoNum:
+								t.proto = pad.Uint8()
+							case ctaProtoSrcPort:
+								t.srcPort = pad.Uint16()
+							case ctaProtoDstPort:
+								t.dstPort = pad.Uint16()
+							}
+						}
+						return nil
+					})
+				}
+			}
+			return nil
+		})
+	}
+	if err := ad.Err(); err != nil {
+		return t, fmt.Errorf("error parsing conntrack entry: %w", err)
+	}
+	return t, nil
+}
+
+// marshalConntrackDelete returns the ctnetlink message data of a request to
+// delete the conntrack entry with original direction tuple t.
+func marshalConntrackDelete(family uint8, t conntrackTuple) ([]byte, error) {
+	srcType, dstType := uint16(ctaIPv4Src), uint16(ctaIPv4Dst)
+	if family == unix.AF_INET6 {
+		srcType, dstType = ctaIPv6Src, ctaIPv6Dst
+	}
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	ae.Nested(ctaTupleOrig, func(tae *netlink.AttributeEncoder) error {
+		tae.Nested(ctaTupleIP, func(iae *netlink.AttributeEncoder) error {
+			iae.Bytes(srcType, t.src.AsSlice())
+			iae.Bytes(dstType, t.dst.AsSlice())
+			return nil
+		})
+		tae.Nested(ctaTupleProto, func(pae *netlink.AttributeEncoder) error {
+			pae.Uint8(ctaProtoNum, t.proto)
+			pae.Uint16(ctaProtoSrcPort, t.srcPort)
+			pae.Uint16(ctaProtoDstPort, t.dstPort)
+			return nil
+		})
+		return nil
+	})
+	attrs, err := ae.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return append(nfgenmsg(family), attrs...), nil
+}
@@ -0,0 +1,68 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package linuxfw
+
+import (
+	"net/netip"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestConntrackTupleRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		family uint8
+		tuple  conntrackTuple
+	}{
+		{
+			name:   "udp4",
+			family: unix.AF_INET,
+			tuple: conntrackTuple{
+				src:     netip.MustParseAddr("100.64.0.1"),
+				dst:     netip.MustParseAddr("100.99.98.97"),
+				proto:   unix.IPPROTO_UDP,
+				srcPort: 41641,
+				dstPort: 53,
+			},
+		},
+		{
+			name:   "tcp6",
+			family: unix.AF_INET6,
+			tuple: conntrackTuple{
+				src:     netip.MustParseAddr("fd7a:115c:a1e0::1"),
+				dst:     netip.MustParseAddr("fd7a:115c:a1e0::2"),
+				proto:   unix.IPPROTO_TCP,
+				srcPort: 1234,
+				dstPort: 443,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := marshalConntrackDelete(tt.family, tt.tuple)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b[0] != tt.family {
+				t.Errorf("family = %d, want %d", b[0], tt.family)
+			}
+			got, err := parseConntrackEntry(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.tuple {
+				t.Errorf("got %+v, want %+v", got, tt.tuple)
+			}
+		})
+	}
+}
+
+func TestParseConntrackEntryShort(t *testing.T) {
+	if _, err := parseConntrackEntry([]byte{unix.AF_INET}); err == nil {
+		t.Error("got nil error for short message")
+	}
+}
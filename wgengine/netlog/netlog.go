@@ -115,7 +115,11 @@ func (nl *Logger) Running() bool {
 // The sock is used to populated the PhysicalTraffic field in [netlogtype.Message].
 //
 // The netMon parameter is optional; if non-nil it's used to do faster interface lookups.
-func (nl *Logger) Startup(logf logger.Logf, nm *netmap.NetworkMap, nodeLogID, domainLogID logid.PrivateID, tun, sock Device, netMon *netmon.Monitor, health *health.Tracker, bus *eventbus.Bus, logExitFlowEnabledEnabled bool) error {
+//
+// Each message is also written to the provided sinks, which the caller
+// continues to own. If nodeLogID is zero, messages are only written
+// to the sinks and not uploaded.
+func (nl *Logger) Startup(logf logger.Logf, nm *netmap.NetworkMap, nodeLogID, domainLogID logid.PrivateID, tun, sock Device, netMon *netmon.Monitor, health *health.Tracker, bus *eventbus.Bus, logExitFlowEnabledEnabled bool, sinks []Sink) error {
 	nl.mu.Lock()
 	defer nl.mu.Unlock()
 
@@ -128,25 +132,28 @@ func (nl *Logger) Startup(logf logger.Logf, nm *netmap.NetworkMap, nodeLogID, do
 	if logf == nil {
 		logf = log.Printf
 	}
-	httpc := &http.Client{Transport: logpolicy.NewLogtailTransport(logtail.DefaultHost, netMon, health, logf)}
-	if testClient != nil {
-		httpc = testClient
+	var logger *logtail.Logger
+	if !nodeLogID.IsZero() {
+		httpc := &http.Client{Transport: logpolicy.NewLogtailTransport(logtail.DefaultHost, netMon, health, logf)}
+		if testClient != nil {
+			httpc = testClient
+		}
+		logger = logtail.NewLogger(logtail.Config{
+			Collection:    "tailtraffic.log.tailscale.io",
+			PrivateID:     nodeLogID,
+			CopyPrivateID: domainLogID,
+			Bus:           bus,
+			Stderr:        io.Discard,
+			CompressLogs:  true,
+			HTTPC:         httpc,
+			// TODO(joetsai): Set Buffer? Use an in-memory buffer for now.
+
+			// Include process sequence numbers to identify missing samples.
+			IncludeProcID:       true,
+			IncludeProcSequence: true,
+		}, logf)
+		logger.SetSockstatsLabel(sockstats.LabelNetlogLogger)
 	}
-	logger := logtail.NewLogger(logtail.Config{
-		Collection:    "tailtraffic.log.tailscale.io",
-		PrivateID:     nodeLogID,
-		CopyPrivateID: domainLogID,
-		Bus:           bus,
-		Stderr:        io.Discard,
-		CompressLogs:  true,
-		HTTPC:         httpc,
-		// TODO(joetsai): Set Buffer? Use an in-memory buffer for now.
-
-		// Include process sequence numbers to identify missing samples.
-		IncludeProcID:       true,
-		IncludeProcSequence: true,
-	}, logf)
-	logger.SetSockstatsLabel(sockstats.LabelNetlogLogger)
 
 	// Register the connection tracker into the TUN device.
 	tun = cmp.Or[Device](tun, noopDevice{})
@@ -167,6 +174,14 @@ func (nl *Logger) Startup(logf logger.Logf, nm *netmap.NetworkMap, nodeLogID, do
 		defer close(recorderDone)
 		for rec := range recordsChan {
 			msg := rec.toMessage(false, !logExitFlowEnabledEnabled)
+			for _, s := range sinks {
+				if err := s.WriteMessage(&msg); err != nil {
+					logf("netlog: sink error: %v", err)
+				}
+			}
+			if logger == nil {
+				continue
+			}
 			if b, err := jsonv2.Marshal(msg, jsontext.AllowInvalidUTF8(true)); err != nil {
 				if nl.logf != nil {
 					nl.logf("netlog: json.Marshal error: %v", err)
@@ -190,7 +205,10 @@ func (nl *Logger) Startup(logf logger.Logf, nm *netmap.NetworkMap, nodeLogID, do
 		recorderDone = nil
 
 		// Try to upload all pending records.
-		err := logger.Shutdown(ctx)
+		var err error
+		if logger != nil {
+			err = logger.Shutdown(ctx)
+		}
 
 		// Purge state.
 		nl.shutdownLocked = nil
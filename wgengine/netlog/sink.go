@@ -0,0 +1,136 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_netlog && !ts_omit_logtail
+
+package netlog
+
+import (
+	"fmt"
+	"iter"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"tailscale.com/types/netlogtype"
+)
+
+// Sink is a local destination for network flow logs, which receives them in
+// addition to (or, if the control plane hasn't enabled network logging,
+// instead of) the Tailscale log server.
+type Sink interface {
+	// WriteMessage writes a network flow log message.
+	// Calls are never concurrent.
+	WriteMessage(*netlogtype.Message) error
+
+	// Close flushes any buffered messages and closes the sink.
+	Close() error
+}
+
+// NewSink returns a new Sink described by spec, which is one of:
+//
+//   - "jsonl:PATH", to append each message as a line of JSON to the file
+//     PATH, which is rotated when it reaches 100 MiB, keeping 5 old files.
+//     Options "?max-size=SIZE&max-files=N" change the limits; SIZE may
+//     have a K, M or G suffix.
+//   - "ipfix:HOST:PORT", to export each flow to an IPFIX collector over UDP.
+//   - "netflow9:HOST:PORT", to export each flow to a NetFlow v9 collector
+//     over UDP.
+//   - "syslog:" to write each flow as JSON to the local syslog daemon, or
+//     "syslog:udp://HOST:PORT" or "syslog:tcp://HOST:PORT" for a remote one.
+func NewSink(spec string) (Sink, error) {
+	kind, arg, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid network log sink %q; want KIND:ARG", spec)
+	}
+	switch kind {
+	case "jsonl":
+		return newJSONLSink(arg)
+	case "ipfix":
+		return newFlowSink(ipfixVersion, arg)
+	case "netflow9":
+		return newFlowSink(netflow9Version, arg)
+	case "syslog":
+		return newSyslogSink(arg)
+	}
+	return nil, fmt.Errorf("unknown network log sink %q; want jsonl, ipfix, netflow9 or syslog", kind)
+}
+
+// ParseSinks returns the Sinks for the comma-separated specs,
+// as described by NewSink.
+func ParseSinks(specs string) ([]Sink, error) {
+	var sinks []Sink
+	for spec := range strings.SplitSeq(specs, ",") {
+		if spec == "" {
+			continue
+		}
+		s, err := NewSink(spec)
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+// flow is a single connection's traffic from a [netlogtype.Message].
+type flow struct {
+	typ string // "virtual", "subnet", "exit" or "physical"
+	netlogtype.ConnectionCounts
+}
+
+// flows returns an iterator over the flows in m.
+func flows(m *netlogtype.Message) iter.Seq[flow] {
+	return func(yield func(flow) bool) {
+		for _, t := range []struct {
+			typ   string
+			conns []netlogtype.ConnectionCounts
+		}{
+			{"virtual", m.VirtualTraffic},
+			{"subnet", m.SubnetTraffic},
+			{"exit", m.ExitTraffic},
+			{"physical", m.PhysicalTraffic},
+		} {
+			for _, cc := range t.conns {
+				if !yield(flow{t.typ, cc}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// nodeNames returns the names of the nodes in m by their addresses.
+func nodeNames(m *netlogtype.Message) map[netip.Addr]string {
+	names := make(map[netip.Addr]string)
+	for _, n := range append([]netlogtype.Node{m.SrcNode}, m.DstNodes...) {
+		for _, a := range n.Addresses {
+			names[a] = n.Name
+		}
+	}
+	return names
+}
+
+// parseSize parses a size in bytes with an optional K, M or G suffix.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
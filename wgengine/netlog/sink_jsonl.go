@@ -0,0 +1,144 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_netlog && !ts_omit_logtail
+
+package netlog
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"tailscale.com/types/netlogtype"
+)
+
+const (
+	defaultJSONLMaxSize  = 100 << 20
+	defaultJSONLMaxFiles = 5
+)
+
+// jsonlSink is a Sink that appends messages as JSON Lines to a file,
+// rotating it to PATH.1, PATH.2, and so on when it gets too big.
+type jsonlSink struct {
+	path     string
+	maxSize  int64 // rotate before the file would exceed this
+	maxFiles int   // number of rotated files to keep
+
+	f    *os.File
+	size int64 // of f
+}
+
+func newJSONLSink(arg string) (Sink, error) {
+	path, query, _ := strings.Cut(arg, "?")
+	if path == "" {
+		return nil, errors.New("jsonl sink: missing file name")
+	}
+	s := &jsonlSink{
+		path:     path,
+		maxSize:  defaultJSONLMaxSize,
+		maxFiles: defaultJSONLMaxFiles,
+	}
+	opts, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("jsonl sink: %w", err)
+	}
+	for k, v := range opts {
+		switch k {
+		case "max-size":
+			if s.maxSize, err = parseSize(v[0]); err != nil {
+				return nil, fmt.Errorf("jsonl sink: max-size: %w", err)
+			}
+		case "max-files":
+			if s.maxFiles, err = strconv.Atoi(v[0]); err != nil || s.maxFiles < 0 {
+				return nil, fmt.Errorf("jsonl sink: invalid max-files %q", v[0])
+			}
+		default:
+			return nil, fmt.Errorf("jsonl sink: unknown option %q", k)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("jsonl sink: %w", err)
+	}
+	if err := s.open(); err != nil {
+		return nil, fmt.Errorf("jsonl sink: %w", err)
+	}
+	return s, nil
+}
+
+func (s *jsonlSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, fi.Size()
+	return nil
+}
+
+// rotate closes the current file, shifts the rotated files up by one
+// (dropping the oldest), and opens a new file.
+func (s *jsonlSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	s.f = nil
+	if s.maxFiles == 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return s.open()
+	}
+	os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxFiles))
+	for i := s.maxFiles - 1; i > 0; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *jsonlSink) WriteMessage(m *netlogtype.Message) error {
+	b, err := jsonv2.Marshal(m, jsontext.AllowInvalidUTF8(true))
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if s.f == nil {
+		// A previous rotation failed; try again.
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.size > 0 && s.size+int64(len(b)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("rotating %s: %w", s.path, err)
+		}
+	}
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+	return err
+}
+
+func (s *jsonlSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,281 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_netlog && !ts_omit_logtail
+
+package netlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"tailscale.com/types/netlogtype"
+)
+
+// Export protocol versions, as found in the first two bytes of each packet.
+const (
+	netflow9Version = 9
+	ipfixVersion    = 10
+)
+
+const (
+	// maxFlowPacketSize is the maximum size of an exported packet,
+	// chosen to avoid IP fragmentation on common paths.
+	maxFlowPacketSize = 1400
+
+	// flowTemplateInterval is how often templates are resent, so that a
+	// collector that restarts (or loses a packet) learns them again.
+	flowTemplateInterval = time.Minute
+
+	// Template IDs; IDs below 256 are reserved for sets.
+	flowTemplateIPv4 = 256
+	flowTemplateIPv6 = 257
+
+	// Template set IDs.
+	netflow9TemplateSetID = 0
+	ipfixTemplateSetID    = 2
+)
+
+// Information element IDs. NetFlow v9 and IPFIX share numbering for these.
+const (
+	ieOctetDeltaCount          = 1
+	iePacketDeltaCount         = 2
+	ieProtocolIdentifier       = 4
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieLastSwitched             = 21 // NetFlow v9 only; ms of sysUptime
+	ieFirstSwitched            = 22 // NetFlow v9 only; ms of sysUptime
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieFlowDirection            = 61  // 0 is ingress, 1 is egress
+	ieFlowStartMilliseconds    = 152 // IPFIX only
+	ieFlowEndMilliseconds      = 153 // IPFIX only
+)
+
+// flowField is a field of a template.
+type flowField struct {
+	id, size uint16
+}
+
+// flowSink is a Sink that exports each connection's traffic as flow records
+// to a NetFlow v9 or IPFIX collector over UDP. Each connection produces an
+// egress record for its transmitted traffic and an ingress record, with the
+// addresses swapped, for its received traffic.
+type flowSink struct {
+	version uint16 // netflow9Version or ipfixVersion
+	conn    net.Conn
+	start   time.Time // for NetFlow v9 sysUptime
+
+	now func() time.Time // or time.Now
+
+	lastTemplates time.Time // zero until the templates are first sent
+	packets       uint32    // packets sent; the NetFlow v9 sequence number
+	records       uint32    // data records sent; the IPFIX sequence number
+}
+
+func newFlowSink(version uint16, addr string) (Sink, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("flow log collector %q: want HOST:PORT", addr)
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &flowSink{
+		version: version,
+		conn:    conn,
+		start:   time.Now(),
+		now:     time.Now,
+	}, nil
+}
+
+// template returns the fields of the template for IPv6 or IPv4 records.
+func (s *flowSink) template(is6 bool) []flowField {
+	fields := []flowField{
+		{ieProtocolIdentifier, 1},
+		{ieSourceTransportPort, 2},
+		{ieDestinationTransportPort, 2},
+	}
+	if is6 {
+		fields = append(fields, flowField{ieSourceIPv6Address, 16}, flowField{ieDestinationIPv6Address, 16})
+	} else {
+		fields = append(fields, flowField{ieSourceIPv4Address, 4}, flowField{ieDestinationIPv4Address, 4})
+	}
+	fields = append(fields, flowField{ieOctetDeltaCount, 8}, flowField{iePacketDeltaCount, 8})
+	if s.version == ipfixVersion {
+		fields = append(fields, flowField{ieFlowStartMilliseconds, 8}, flowField{ieFlowEndMilliseconds, 8})
+	} else {
+		fields = append(fields, flowField{ieFirstSwitched, 4}, flowField{ieLastSwitched, 4})
+	}
+	return append(fields, flowField{ieFlowDirection, 1})
+}
+
+func (s *flowSink) headerSize() int {
+	if s.version == ipfixVersion {
+		return 16
+	}
+	return 20
+}
+
+// appendTemplateSet appends a set with both templates to b.
+func (s *flowSink) appendTemplateSet(b []byte) []byte {
+	setID := uint16(netflow9TemplateSetID)
+	if s.version == ipfixVersion {
+		setID = ipfixTemplateSetID
+	}
+	start := len(b)
+	b = binary.BigEndian.AppendUint16(b, setID)
+	b = binary.BigEndian.AppendUint16(b, 0) // length, set below
+	for _, is6 := range []bool{false, true} {
+		id := uint16(flowTemplateIPv4)
+		if is6 {
+			id = flowTemplateIPv6
+		}
+		fields := s.template(is6)
+		b = binary.BigEndian.AppendUint16(b, id)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(fields)))
+		for _, f := range fields {
+			b = binary.BigEndian.AppendUint16(b, f.id)
+			b = binary.BigEndian.AppendUint16(b, f.size)
+		}
+	}
+	binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+	return b
+}
+
+// flowRecord is a unidirectional flow to export.
+type flowRecord struct {
+	conn    netlogtype.Connection
+	packets uint64
+	bytes   uint64
+	egress  bool
+}
+
+// appendRecord appends the data record for r, which must match the template
+// for its address family, to b.
+func (s *flowSink) appendRecord(b []byte, r flowRecord, start, end time.Time) []byte {
+	b = append(b, byte(r.conn.Proto))
+	b = binary.BigEndian.AppendUint16(b, r.conn.Src.Port())
+	b = binary.BigEndian.AppendUint16(b, r.conn.Dst.Port())
+	b = append(b, r.conn.Src.Addr().AsSlice()...)
+	b = append(b, r.conn.Dst.Addr().AsSlice()...)
+	b = binary.BigEndian.AppendUint64(b, r.bytes)
+	b = binary.BigEndian.AppendUint64(b, r.packets)
+	if s.version == ipfixVersion {
+		b = binary.BigEndian.AppendUint64(b, uint64(start.UnixMilli()))
+		b = binary.BigEndian.AppendUint64(b, uint64(end.UnixMilli()))
+	} else {
+		b = binary.BigEndian.AppendUint32(b, s.uptime(start))
+		b = binary.BigEndian.AppendUint32(b, s.uptime(end))
+	}
+	var dir byte
+	if r.egress {
+		dir = 1
+	}
+	return append(b, dir)
+}
+
+// uptime returns t as milliseconds since the sink was created, as used for
+// NetFlow v9 timestamps.
+func (s *flowSink) uptime(t time.Time) uint32 {
+	return uint32(max(t.Sub(s.start).Milliseconds(), 0))
+}
+
+// recordSize returns the size of a data record for the address family.
+func (s *flowSink) recordSize(is6 bool) int {
+	var n int
+	for _, f := range s.template(is6) {
+		n += int(f.size)
+	}
+	return n
+}
+
+func (s *flowSink) WriteMessage(m *netlogtype.Message) error {
+	var byFamily [2][]flowRecord // IPv4, IPv6
+	for f := range flows(m) {
+		c := f.Connection
+		if !c.Src.Addr().IsValid() || c.Src.Addr().Is4() != c.Dst.Addr().Is4() {
+			continue // no template for mixed address families
+		}
+		fam := 0
+		if !c.Src.Addr().Is4() {
+			fam = 1
+		}
+		if f.TxPackets > 0 {
+			byFamily[fam] = append(byFamily[fam], flowRecord{c, f.TxPackets, f.TxBytes, true})
+		}
+		if f.RxPackets > 0 {
+			rc := netlogtype.Connection{Proto: c.Proto, Src: c.Dst, Dst: c.Src}
+			byFamily[fam] = append(byFamily[fam], flowRecord{rc, f.RxPackets, f.RxBytes, false})
+		}
+	}
+
+	var errs []error
+	now := s.now()
+	for fam, recs := range byFamily {
+		is6 := fam == 1
+		templateID := uint16(flowTemplateIPv4)
+		if is6 {
+			templateID = flowTemplateIPv6
+		}
+		recSize := s.recordSize(is6)
+		for len(recs) > 0 {
+			pkt := make([]byte, s.headerSize(), maxFlowPacketSize)
+			var count int // records in pkt, including templates for NetFlow v9
+			if s.lastTemplates.IsZero() || now.Sub(s.lastTemplates) >= flowTemplateInterval {
+				pkt = s.appendTemplateSet(pkt)
+				count += 2
+				s.lastTemplates = now
+			}
+			setStart := len(pkt)
+			pkt = binary.BigEndian.AppendUint16(pkt, templateID)
+			pkt = binary.BigEndian.AppendUint16(pkt, 0) // length, set below
+			n := min(len(recs), (maxFlowPacketSize-len(pkt)-3)/recSize)
+			for _, r := range recs[:n] {
+				pkt = s.appendRecord(pkt, r, m.Start, m.End)
+			}
+			for (len(pkt)-setStart)%4 != 0 {
+				pkt = append(pkt, 0)
+			}
+			binary.BigEndian.PutUint16(pkt[setStart+2:], uint16(len(pkt)-setStart))
+			count += n
+			recs = recs[n:]
+
+			s.putHeader(pkt, now, count, n)
+			if _, err := s.conn.Write(pkt); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// putHeader writes the packet header at the start of pkt, which has count
+// records, of which dataRecords are data records, and advances the sequence
+// numbers.
+func (s *flowSink) putHeader(pkt []byte, now time.Time, count, dataRecords int) {
+	binary.BigEndian.PutUint16(pkt[0:], s.version)
+	if s.version == ipfixVersion {
+		binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)))
+		binary.BigEndian.PutUint32(pkt[4:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(pkt[8:], s.records)
+		binary.BigEndian.PutUint32(pkt[12:], 0) // observation domain
+	} else {
+		binary.BigEndian.PutUint16(pkt[2:], uint16(count))
+		binary.BigEndian.PutUint32(pkt[4:], s.uptime(now))
+		binary.BigEndian.PutUint32(pkt[8:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(pkt[12:], s.packets)
+		binary.BigEndian.PutUint32(pkt[16:], 0) // source ID
+	}
+	s.packets++
+	s.records += uint32(dataRecords)
+}
+
+func (s *flowSink) Close() error {
+	return s.conn.Close()
+}
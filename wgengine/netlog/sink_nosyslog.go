@@ -0,0 +1,12 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_netlog && !ts_omit_logtail && !((linux && !android) || (darwin && !ios) || freebsd || openbsd)
+
+package netlog
+
+import "errors"
+
+func newSyslogSink(string) (Sink, error) {
+	return nil, errors.New("syslog network log sink not supported on this platform")
+}
@@ -0,0 +1,78 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_netlog && !ts_omit_logtail && ((linux && !android) || (darwin && !ios) || freebsd || openbsd)
+
+package netlog
+
+import (
+	"errors"
+	"fmt"
+	"log/syslog"
+	"net/url"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netlogtype"
+)
+
+// syslogSink is a Sink that writes each flow as a JSON object to syslog.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink(arg string) (Sink, error) {
+	var network, addr string
+	if arg != "" {
+		u, err := url.Parse(arg)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return nil, fmt.Errorf("syslog sink %q: want syslog:, syslog:udp://HOST:PORT or syslog:tcp://HOST:PORT", arg)
+		}
+		network, addr = u.Scheme, u.Host
+	}
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, "tailscaled-netlog")
+	if err != nil {
+		return nil, fmt.Errorf("syslog sink: %w", err)
+	}
+	return &syslogSink{w: w}, nil
+}
+
+// syslogFlow is the JSON written to syslog for each flow.
+type syslogFlow struct {
+	NodeID tailcfg.StableNodeID `json:"nodeId"`
+	Start  time.Time            `json:"start"`
+	End    time.Time            `json:"end"`
+	Type   string               `json:"type"` // "virtual", "subnet", "exit" or "physical"
+	netlogtype.ConnectionCounts
+	SrcNode string `json:"srcNode,omitzero"`
+	DstNode string `json:"dstNode,omitzero"`
+}
+
+func (s *syslogSink) WriteMessage(m *netlogtype.Message) error {
+	names := nodeNames(m)
+	var errs []error
+	for f := range flows(m) {
+		b, err := jsonv2.Marshal(syslogFlow{
+			NodeID:           m.NodeID,
+			Start:            m.Start,
+			End:              m.End,
+			Type:             f.typ,
+			ConnectionCounts: f.ConnectionCounts,
+			SrcNode:          names[f.Src.Addr()],
+			DstNode:          names[f.Dst.Addr()],
+		}, jsontext.AllowInvalidUTF8(true))
+		if err == nil {
+			err = s.w.Info(string(b))
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,288 @@
+// Copyright (c) Tailscale Inc & contributors
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ts_omit_netlog && !ts_omit_logtail
+
+package netlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jsonv2 "github.com/go-json-experiment/json"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/netlogtype"
+)
+
+func TestParseSinks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flows.jsonl")
+	tests := []struct {
+		specs   string
+		want    int
+		wantErr bool
+	}{
+		{specs: "", want: 0},
+		{specs: "jsonl:" + path, want: 1},
+		{specs: "jsonl:" + path + "?max-size=10M&max-files=2,ipfix:127.0.0.1:4739", want: 2},
+		{specs: "bogus", wantErr: true},
+		{specs: "kafka:localhost:9092", wantErr: true},
+		{specs: "jsonl:", wantErr: true},
+		{specs: "jsonl:" + path + "?max-size=lots", wantErr: true},
+		{specs: "jsonl:" + path + "?color=blue", wantErr: true},
+		{specs: "ipfix:localhost", wantErr: true},
+		{specs: "jsonl:" + path + ",netflow9:", wantErr: true},
+		{specs: "syslog:http://localhost:514", wantErr: true},
+	}
+	for _, tt := range tests {
+		sinks, err := ParseSinks(tt.specs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSinks(%q) error = %v; want error: %v", tt.specs, err, tt.wantErr)
+		}
+		if len(sinks) != tt.want && !tt.wantErr {
+			t.Errorf("ParseSinks(%q) = %d sinks; want %d", tt.specs, len(sinks), tt.want)
+		}
+		for _, s := range sinks {
+			if err := s.Close(); err != nil {
+				t.Errorf("Close: %v", err)
+			}
+		}
+	}
+}
+
+func testMessage() *netlogtype.Message {
+	return &netlogtype.Message{
+		NodeID: "n123456CNTL",
+		Start:  time.Unix(1700000000, 0).UTC(),
+		End:    time.Unix(1700000005, 0).UTC(),
+		SrcNode: netlogtype.Node{
+			NodeID:    "n123456CNTL",
+			Name:      "test.tail123456.ts.net",
+			Addresses: []netip.Addr{addr("100.1.2.3"), addr("fd7a:115c:a1e0::1")},
+		},
+		VirtualTraffic: []netlogtype.ConnectionCounts{
+			{Connection: conn(ipproto.TCP, "100.1.2.3:1234", "100.1.2.4:80"), Counts: counts(10, 1000, 5, 500)},
+			{Connection: conn(ipproto.UDP, "[fd7a:115c:a1e0::1]:53", "[fd7a:115c:a1e0::2]:5353"), Counts: counts(1, 100, 0, 0)},
+		},
+		PhysicalTraffic: []netlogtype.ConnectionCounts{
+			// Mixed address families can't be exported as flows.
+			{Connection: conn(0, "100.1.2.4:0", "[2001:db8::1]:41641"), Counts: counts(3, 300, 3, 300)},
+		},
+	}
+}
+
+func TestJSONLSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netlog", "flows.jsonl")
+	m := testMessage()
+	b, err := jsonv2.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fit two messages per file.
+	s, err := NewSink(fmt.Sprintf("jsonl:%s?max-size=%d&max-files=2", path, 2*(len(b)+1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 7 {
+		if err := s.WriteMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, wantLines := range map[string]int{path: 1, path + ".1": 2, path + ".2": 2} {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var lines int
+		for sc := bufio.NewScanner(f); sc.Scan(); lines++ {
+			var got netlogtype.Message
+			if err := jsonv2.Unmarshal(sc.Bytes(), &got); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if got.NodeID != m.NodeID || len(got.VirtualTraffic) != len(m.VirtualTraffic) {
+				t.Errorf("%s: got message %+v", name, got)
+			}
+		}
+		if lines != wantLines {
+			t.Errorf("%s: got %d lines; want %d", name, lines, wantLines)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("oldest file not removed: %v", err)
+	}
+}
+
+// flowPacket is a decoded NetFlow v9 or IPFIX packet.
+type flowPacket struct {
+	version   uint16
+	sequence  uint32
+	templates map[uint16][]flowField
+	records   map[uint16][][]byte // by template ID
+}
+
+func parseFlowPacket(t *testing.T, b []byte) flowPacket {
+	t.Helper()
+	p := flowPacket{
+		version:   binary.BigEndian.Uint16(b),
+		templates: make(map[uint16][]flowField),
+		records:   make(map[uint16][][]byte),
+	}
+	switch p.version {
+	case ipfixVersion:
+		if n := binary.BigEndian.Uint16(b[2:]); int(n) != len(b) {
+			t.Fatalf("IPFIX length = %d; want %d", n, len(b))
+		}
+		p.sequence = binary.BigEndian.Uint32(b[8:])
+		b = b[16:]
+	case netflow9Version:
+		p.sequence = binary.BigEndian.Uint32(b[12:])
+		b = b[20:]
+	default:
+		t.Fatalf("unknown version %d", p.version)
+	}
+	for len(b) > 0 {
+		id, n := binary.BigEndian.Uint16(b), binary.BigEndian.Uint16(b[2:])
+		if n%4 != 0 || int(n) > len(b) {
+			t.Fatalf("bad set length %d", n)
+		}
+		set := b[4:n]
+		b = b[n:]
+		switch id {
+		case netflow9TemplateSetID, ipfixTemplateSetID:
+			for len(set) > 0 {
+				tid, count := binary.BigEndian.Uint16(set), binary.BigEndian.Uint16(set[2:])
+				set = set[4:]
+				for range count {
+					p.templates[tid] = append(p.templates[tid], flowField{binary.BigEndian.Uint16(set), binary.BigEndian.Uint16(set[2:])})
+					set = set[4:]
+				}
+			}
+		default:
+			var size int
+			for _, f := range (&flowSink{version: p.version}).template(id == flowTemplateIPv6) {
+				size += int(f.size)
+			}
+			for len(set) >= size {
+				p.records[id] = append(p.records[id], set[:size])
+				set = set[size:]
+			}
+		}
+	}
+	return p
+}
+
+func TestFlowSink(t *testing.T) {
+	for _, kind := range []string{"ipfix", "netflow9"} {
+		t.Run(kind, func(t *testing.T) {
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pc.Close()
+			s, err := NewSink(kind + ":" + pc.LocalAddr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			read := func() flowPacket {
+				t.Helper()
+				pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+				buf := make([]byte, 2000)
+				n, _, err := pc.ReadFrom(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return parseFlowPacket(t, buf[:n])
+			}
+
+			m := testMessage()
+			if err := s.WriteMessage(m); err != nil {
+				t.Fatal(err)
+			}
+			p4, p6 := read(), read()
+			if len(p4.templates) != 2 {
+				t.Errorf("first packet has %d templates; want 2", len(p4.templates))
+			}
+			if len(p6.templates) != 0 {
+				t.Errorf("second packet has %d templates; want 0", len(p6.templates))
+			}
+			if got := len(p4.records[flowTemplateIPv4]); got != 2 {
+				t.Fatalf("got %d IPv4 records; want 2", got)
+			}
+			if got := len(p6.records[flowTemplateIPv6]); got != 1 {
+				t.Fatalf("got %d IPv6 records; want 1", got)
+			}
+
+			// The egress record is the connection as logged; the ingress
+			// record has the addresses swapped.
+			egress, ingress := p4.records[flowTemplateIPv4][0], p4.records[flowTemplateIPv4][1]
+			if egress[0] != byte(ipproto.TCP) {
+				t.Errorf("protocol = %d; want TCP", egress[0])
+			}
+			if got := binary.BigEndian.Uint16(egress[1:]); got != 1234 {
+				t.Errorf("egress source port = %d; want 1234", got)
+			}
+			if got, _ := netip.AddrFromSlice(egress[5:9]); got != addr("100.1.2.3") {
+				t.Errorf("egress source address = %v; want 100.1.2.3", got)
+			}
+			if got, _ := netip.AddrFromSlice(ingress[5:9]); got != addr("100.1.2.4") {
+				t.Errorf("ingress source address = %v; want 100.1.2.4", got)
+			}
+			if got := binary.BigEndian.Uint64(egress[13:]); got != 1000 {
+				t.Errorf("egress bytes = %d; want 1000", got)
+			}
+			if got := binary.BigEndian.Uint64(ingress[21:]); got != 5 {
+				t.Errorf("ingress packets = %d; want 5", got)
+			}
+			if got, want := egress[len(egress)-1], byte(1); got != want {
+				t.Errorf("egress direction = %d; want %d", got, want)
+			}
+			if got, want := ingress[len(ingress)-1], byte(0); got != want {
+				t.Errorf("ingress direction = %d; want %d", got, want)
+			}
+			if kind == "ipfix" {
+				if got := binary.BigEndian.Uint64(egress[29:]); got != uint64(m.Start.UnixMilli()) {
+					t.Errorf("flow start = %d; want %d", got, m.Start.UnixMilli())
+				}
+			}
+
+			// Templates aren't resent until the interval passes.
+			if err := s.WriteMessage(m); err != nil {
+				t.Fatal(err)
+			}
+			p4 = read()
+			read()
+			if len(p4.templates) != 0 {
+				t.Errorf("templates resent too soon")
+			}
+			wantSeq := uint32(2) // packets
+			if kind == "ipfix" {
+				wantSeq = 3 // data records
+			}
+			if p4.sequence != wantSeq {
+				t.Errorf("sequence = %d; want %d", p4.sequence, wantSeq)
+			}
+
+			s.(*flowSink).now = func() time.Time { return time.Now().Add(flowTemplateInterval) }
+			if err := s.WriteMessage(m); err != nil {
+				t.Fatal(err)
+			}
+			if p4 = read(); len(p4.templates) != 2 {
+				t.Errorf("templates not resent after interval")
+			}
+			read()
+		})
+	}
+}
@@ -45,6 +45,7 @@
 	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/views"
 	"tailscale.com/util/backoff"
@@ -165,6 +166,7 @@ type userspaceEngine struct {
 
 	// networkLogger logs statistics about network connections.
 	networkLogger netlog.Logger
+	netLogSinks   []netlog.Sink // from Config.NetLogSinks; read-only
 
 	// Lock ordering: magicsock.Conn.mu, wgLock, then mu.
 }
@@ -266,6 +268,11 @@ type Config struct {
 	// app connector handling logic.
 	Conn25PacketHooks Conn25PacketHooks
 
+	// NetLogSinks are local destinations for network flow logs. Unlike
+	// uploading to the log server, they don't need the control plane to
+	// enable network logging. The engine closes them when it's closed.
+	NetLogSinks []netlog.Sink
+
 	// ForceDiscoKey, if non-zero, forces the use of a specific disco
 	// private key. This should only be used for special cases and
 	// experiments, not for production. The recommended normal path is to
@@ -387,6 +394,7 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		reconfigureVPN:    conf.ReconfigureVPN,
 		health:            conf.HealthTracker,
 		conn25PacketHooks: conf.Conn25PacketHooks,
+		netLogSinks:       conf.NetLogSinks,
 	}
 
 	if e.birdClient != nil {
@@ -1079,8 +1087,10 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	netLogIDsNowValid := !newLogIDs.NodeID.IsZero() && !newLogIDs.DomainID.IsZero()
 	netLogIDsWasValid := !oldLogIDs.NodeID.IsZero() && !oldLogIDs.DomainID.IsZero()
 	netLogIDsChanged := netLogIDsNowValid && netLogIDsWasValid && newLogIDs != oldLogIDs
-	netLogRunning := netLogIDsNowValid && !routerCfg.Equal(&router.Config{})
-	if !buildfeatures.HasNetLog || envknob.NoLogsNoSupport() {
+	netLogUpload := netLogIDsNowValid && !envknob.NoLogsNoSupport()
+	netLogWasUpload := netLogIDsWasValid && !envknob.NoLogsNoSupport()
+	netLogRunning := (netLogUpload || len(e.netLogSinks) > 0) && !routerCfg.Equal(&router.Config{})
+	if !buildfeatures.HasNetLog {
 		netLogRunning = false
 	}
 
@@ -1135,9 +1145,10 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 		return err
 	}
 
-	// Shutdown the network logger because the IDs changed.
+	// Shutdown the network logger because the IDs changed, or because
+	// uploading was enabled or disabled while it writes to local sinks.
 	// Let it be started back up by subsequent logic.
-	if buildfeatures.HasNetLog && netLogIDsChanged && e.networkLogger.Running() {
+	if buildfeatures.HasNetLog && (netLogIDsChanged || netLogUpload != netLogWasUpload) && e.networkLogger.Running() {
 		e.logf("wgengine: Reconfig: shutting down network logger")
 		ctx, cancel := context.WithTimeout(context.Background(), networkLoggerUploadTimeout)
 		defer cancel()
@@ -1149,11 +1160,16 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 	// Startup the network logger.
 	// Do this before configuring the router so that we capture initial packets.
 	if buildfeatures.HasNetLog && netLogRunning && !e.networkLogger.Running() {
-		nid := cfg.NetworkLogging.NodeID
-		tid := cfg.NetworkLogging.DomainID
+		var nid, tid logid.PrivateID
+		if netLogUpload {
+			nid = cfg.NetworkLogging.NodeID
+			tid = cfg.NetworkLogging.DomainID
+			e.logf("wgengine: Reconfig: starting up network logger (node:%s tailnet:%s, %d local sinks)", nid.Public(), tid.Public(), len(e.netLogSinks))
+		} else {
+			e.logf("wgengine: Reconfig: starting up network logger (%d local sinks)", len(e.netLogSinks))
+		}
 		logExitFlowEnabled := cfg.NetworkLogging.LogExitFlowEnabled
-		e.logf("wgengine: Reconfig: starting up network logger (node:%s tailnet:%s)", nid.Public(), tid.Public())
-		if err := e.networkLogger.Startup(e.logf, nm, nid, tid, e.tundev, e.magicConn, e.netMon, e.health, e.eventBus, logExitFlowEnabled); err != nil {
+		if err := e.networkLogger.Startup(e.logf, nm, nid, tid, e.tundev, e.magicConn, e.netMon, e.health, e.eventBus, logExitFlowEnabled, e.netLogSinks); err != nil {
 			e.logf("wgengine: Reconfig: error starting up network logger: %v", err)
 		}
 		e.networkLogger.ReconfigRoutes(routerCfg)
@@ -1388,6 +1404,11 @@ func (e *userspaceEngine) Close() {
 	if err := e.networkLogger.Shutdown(ctx); err != nil {
 		e.logf("wgengine: Close: error shutting down network logger: %v", err)
 	}
+	for _, s := range e.netLogSinks {
+		if err := s.Close(); err != nil {
+			e.logf("wgengine: Close: error closing network log sink: %v", err)
+		}
+	}
 }
 
 func (e *userspaceEngine) Done() <-chan struct{} {